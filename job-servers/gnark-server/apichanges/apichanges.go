@@ -0,0 +1,262 @@
+// Package apichanges records every change to the HTTP API and its payloads
+// that a client may have to act on, so that clients can find out what
+// changed since the version they were written against without reading the
+// release notes. The registry is served on /api-changes.
+package apichanges
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kinds of change.
+const (
+	Added      = "added"
+	Changed    = "changed"
+	Deprecated = "deprecated"
+	Removed    = "removed"
+)
+
+// AllEndpoints is the Endpoint of changes that apply to every route.
+const AllEndpoints = "*"
+
+// Change is one API-affecting change.
+type Change struct {
+	// Version is the API version that introduced the change.
+	Version  string `json:"version"`
+	Endpoint string `json:"endpoint"`
+	Type     string `json:"type"`
+	// Breaking is set when clients written against an earlier version may
+	// fail or misbehave without adapting.
+	Breaking bool `json:"breaking"`
+	// Note says what changed and how to migrate.
+	Note string `json:"note"`
+}
+
+// Registry lists the changes in the order they were made. Every change to a
+// route, a request or response field, a status code or an error code that
+// clients can observe gets an entry, with a new Version for each release.
+// Entries are never edited once released.
+var Registry = []Change{
+	{"1.0", "/health", Added, false, "Reports whether the server is up."},
+	{"1.0", "/start-proof", Added, false, "Queues a proof of a plonky2 proof with public inputs and returns its jobId."},
+	{"1.0", "/get-proof", Added, false, "Returns the result of a job."},
+
+	{"1.1", "/start-proofs", Added, false, "Queues a batch of proofs in one request."},
+
+	{"1.2", "/start-proof", Changed, true, "callbackUrl is validated on submission and an invalid or blocked URL is rejected with 400 instead of failing later."},
+	{"1.2", "/start-proof", Changed, false, "The job result is POSTed to callbackUrl when the job finishes."},
+
+	{"1.3", "/health", Changed, true, "Returns 503 when the loaded circuit keys are invalid. Use /health only as a liveness check."},
+	{"1.3", "/proof-events", Added, false, "Streams the stage transitions of a job as server-sent events."},
+
+	{"1.4", "/start-proof", Changed, true, "Returns 503 with code shutting_down while the server drains. Retry on another instance."},
+	{"1.4", "/start-proofs", Changed, true, "Returns 503 with code shutting_down while the server drains. Retry on another instance."},
+	{"1.4", "/public-status", Added, false, "Reports queue and worker status without authentication."},
+
+	{"1.5", "/start-proof", Changed, true, "Requires an API key in an Authorization: Bearer header. Requests without one get 401."},
+	{"1.5", "/start-proofs", Changed, true, "Requires an API key in an Authorization: Bearer header. Requests without one get 401."},
+	{"1.5", "/get-proof", Changed, true, "Requires an API key in an Authorization: Bearer header. Requests without one get 401."},
+	{"1.5", "/proof-events", Changed, true, "Requires an API key in an Authorization: Bearer header. Requests without one get 401."},
+	{"1.5", "/jobs/", Added, false, "POST /jobs/{jobId}/replay proves a finished job again and compares the results."},
+
+	{"1.6", "/start-proof", Changed, true, "Submissions are rate limited per client and refused with 429 and a Retry-After header above the limit."},
+
+	{"1.7", "/get-proof", Changed, true, "Returns 410 with code job_expired for a finished job whose records have expired, instead of 404."},
+	{"1.7", "/start-proof", Changed, false, "Accepts ttlSeconds to set how long the result is kept."},
+
+	{"1.8", "/start-proof", Changed, false, "Accepts an Idempotency-Key header or idempotencyKey field; a repeated key returns the original jobId."},
+
+	{"1.9", AllEndpoints, Changed, true, "Errors are JSON objects with a stable code, a message and optional details, instead of plain text. get-proof reports the code of a failed job in errorCode."},
+	{"1.9", "/export-verifier", Added, false, "Returns the Solidity verifier of the loaded verifying key."},
+
+	{"1.10", "/metrics", Added, false, "Prometheus metrics of the proving lifecycle."},
+	{"1.10", "/stats", Added, false, "Reports proving and end-to-end latency percentiles of the instance."},
+	{"1.10", "/start-proof", Changed, false, "Accepts upstreamCreatedAt, the RFC 3339 time the upstream created the work."},
+
+	{"1.11", "/health/ready", Added, false, "Readiness check of Redis and each part of the circuit data."},
+	{"1.11", "/start-proof", Changed, true, "Returns 503 with code proving_disabled when the server runs in verify-only mode."},
+
+	{"1.12", "/start-proof", Changed, true, "Returns 429 with code queue_full when the proof queue is at its limit."},
+	{"1.12", "/dashboard/", Added, false, "Operator dashboard, on the admin listener."},
+	{"1.12", "/dashboard/api/summary", Added, false, "Dashboard summary of the queue and workers, on the admin listener."},
+	{"1.12", "/dashboard/api/jobs", Added, false, "Dashboard list of recent jobs, on the admin listener."},
+
+	{"1.13", "/start-proof", Changed, false, "Returns 202 with the jobId of an identical job still in flight and an X-Deduplicated: true header."},
+	{"1.13", "/get-proof", Changed, false, "Accepts format=calldata to return the proof encoded for the Solidity verifier."},
+	{"1.13", "/export-verifier", Changed, true, "Served only on the admin listener (ADMIN_PORT) and no longer on the public port."},
+
+	{"1.14", "/get-proof", Changed, false, "Returns the lifecycle of the job, its state and the time it entered each state, in job."},
+	{"1.14", "/start-proof", Changed, true, "Public inputs that do not fit the input layout are rejected with 422 instead of failing the job."},
+
+	{"1.15", "/retry-proof", Added, false, "Queues a failed job again with its stored input."},
+
+	{"1.16", "/version", Added, false, "Reports the loaded circuit release and the build."},
+
+	{"1.17", "/estimate-prove-time", Added, false, "Estimates when a new job would finish."},
+	{"1.17", AllEndpoints, Changed, false, "Request bodies may be sent with Content-Encoding: gzip and responses are gzipped for clients that accept it."},
+
+	{"1.18", "/proof-events", Changed, true, "Sends a ping event every 15 seconds while the job is pending. Ignore event types you do not know."},
+	{"1.18", "/proof-events", Changed, false, "Accepts the job ID as id as well as jobId."},
+	{"1.18", "/start-proof", Changed, true, "A proof that is valid JSON but not a well-formed plonky2 proof is rejected with 400 and code malformed_proof instead of failing the job."},
+
+	{"1.19", "/proof-ws", Added, false, "Streams the stage transitions of a job over a WebSocket."},
+
+	{"1.20", "/api-changes", Added, false, "Lists the changes to the API since a version."},
+	{"1.20", AllEndpoints, Changed, false, "Responses carry the API version in X-Api-Version, and X-Api-Changes-Since when the X-Api-Known-Version of the request is older."},
+
+	{"1.21", "/start-proof", Changed, false, "Accepts an X-Priority header or priority field of high, normal or low; job.priority reports it. An invalid value is rejected with 400 and code invalid_priority."},
+	{"1.21", "/start-proofs", Changed, false, "Accepts an X-Priority header for the batch or a priority field per entry."},
+	{"1.21", "/get-proof", Changed, false, "Reports the priority of the job in job.priority."},
+
+	{"1.22", "/start-proof", Changed, false, "Accepts a circuit field naming the circuit to prove with. It is required when several circuits are loaded, and an unknown or missing circuit is rejected with 400 and code unknown_circuit listing the loaded circuits."},
+	{"1.22", "/start-proofs", Changed, false, "Accepts a circuit field per entry."},
+	{"1.22", "/get-proof", Changed, false, "Reports the circuit that proved the job in circuit."},
+	{"1.22", "/version", Changed, false, "Lists every loaded circuit in circuits. The top-level circuit fields are only set when a single circuit is loaded."},
+	{"1.22", "/export-verifier", Changed, false, "Accepts ?circuit= to select the circuit when several are loaded."},
+	{"1.22", "/health/ready", Changed, false, "The circuit checks are prefixed with the circuit name when several circuits are loaded."},
+
+	{"1.23", "/get-proof", Changed, false, "proof carries verifierDigest and inputHash in decimal, and verifierDigestHex and inputHashHex as 0x-prefixed 32-byte words. A job whose proof does not commit to the submitted public inputs fails with errorCode input_hash_mismatch."},
+	{"1.23", "/start-proof", Changed, false, "A proof returned from the proof cache carries the same verifierDigest and inputHash fields as get-proof."},
+
+	{"1.24", "/dead-letter-jobs", Added, false, "Lists the jobs given up on after crashing the prover, on the admin listener."},
+	{"1.24", "/dead-letter-jobs/retry", Added, false, "Queues a dead-lettered job again with its stored input, on the admin listener."},
+
+	{"1.25", "/start-proof", Changed, false, "Accepts proveTimeoutSeconds to bound the proving time of the job; a value above the server maximum is rejected with 400 and code invalid_prove_timeout."},
+	{"1.25", "/start-proofs", Changed, false, "Accepts proveTimeoutSeconds per entry."},
+	{"1.25", "/get-proof", Changed, false, "A job that exceeds its prove timeout fails with errorCode timeout. job.proveTimeoutSeconds reports the timeout the job ran with."},
+
+	{"1.26", "/reload-secrets", Added, false, "Reads the secret files again and reports which secrets were rotated, on the admin listener."},
+
+	{"1.27", "/events", Added, false, "Replays the state transitions of all jobs from a cursor, on the admin listener."},
+
+	{"1.28", "/openapi.json", Added, false, "OpenAPI 3 description of the HTTP API, generated from the payload types."},
+
+	{"1.29", "/proof", Added, false, "DELETE /proof?jobId= purges a job, its result and its stored input, on the admin listener. Jobs being proved are refused with 409 and code job_proving."},
+	{"1.29", "/events", Changed, false, "A purged job is logged with state deleted."},
+
+	{"1.30", AllEndpoints, Changed, false, "Authenticated endpoints accept a JSON Web Token as the bearer credential when the server sets JWT_SECRET."},
+	{"1.30", "/start-proof", Changed, false, "A token whose allowed_circuits claim does not list the circuit digest of the verifier data is refused with 403 and code circuit_not_allowed."},
+	{"1.30", "/start-proofs", Changed, false, "Entries for a circuit digest the token does not allow fail with errorCode circuit_not_allowed."},
+	{"1.31", "/fleet", Added, false, "Lists the live instances sharing the Redis queue, on the admin listener."},
+	{"1.32", "/prove", Added, false, "Queues a proof and waits up to waitSeconds for it, answering like get-proof, or with 202 and the jobId when it is still pending."},
+	{"1.33", AllEndpoints, Changed, false, "Requests from an origin in CORS_ALLOWED_ORIGINS get CORS headers, and preflight OPTIONS requests are answered with 204."},
+	{"1.34", "/start-proof", Changed, false, "Proofs with an array longer than the circuit allows are refused with 413 and code proof_too_large before they are decoded, and more public inputs than the circuit has with 422 and code invalid_public_input_count."},
+	{"1.34", "/start-proofs", Changed, false, "Entries with an array longer than the circuit allows fail with errorCode proof_too_large."},
+	{"1.34", "/prove", Changed, false, "Proofs with an array longer than the circuit allows are refused with 413 and code proof_too_large."},
+	{"1.35", "/reload-circuit", Added, false, "Loads the circuits again from the data directory and swaps them in without a restart, on the admin listener."},
+	{"1.36", "/test-vectors", Added, false, "Derives calls the Solidity verifier must reject from the proof of a succeeded job, on the admin listener."},
+	{"1.37", "/circuit-info", Added, false, "Returns the constraint and public input counts, key sizes and circuit digest of a circuit."},
+	{"1.38", "/jobs/", Changed, false, "POST /jobs/{jobId}/replay accepts notify=true to notify the original callbackUrl, and force=true to bypass the callback dedupe window."},
+	{"1.38", "/get-proof", Changed, false, "job.callbackStatus is suppressed_duplicate, with job.callbackDuplicateOf, when the callback was not sent because an identical one was delivered within CALLBACK_DEDUPE_WINDOW_SECONDS."},
+	{"1.39", "/reload-circuit", Changed, false, "Also served for POST, loads the circuits from the dataDir of an optional request body, and reports the verifyingKeyHash of each circuit."},
+	{"1.40", "/start-proof", Changed, false, "Accepts circuit_name in place of circuit. A request naming two different circuits is rejected with 400 and code invalid_request."},
+	{"1.40", "/start-proofs", Changed, false, "Accepts circuit_name in place of circuit in each entry."},
+	{"1.40", "/reload-circuit", Changed, false, "A circuit in the request body reloads that circuit alone, or adds it, and keeps the others. An unknown one is rejected with 400 and code unknown_circuit."},
+	{"1.41", "/get-proof", Changed, false, "Responses with a proof carry backend, plonk or groth16, the proving system of the proof."},
+	{"1.42", "/start-proof", Changed, false, "Accepts notAfter. Jobs not expected to be proved by then fail without proving with errorCode deadline_exceeded, or are marked likely_late with DEADLINE_POLICY=flag. A notAfter that is not in the future is rejected with 400 and code invalid_not_after."},
+	{"1.42", "/start-proofs", Changed, false, "Accepts notAfter in each entry."},
+	{"1.42", "/get-proof", Changed, false, "job carries notAfter, deadlineDecision and deadlineEstimateMs for jobs submitted with a notAfter."},
+	{"1.43", "/circuit-info", Changed, false, "Reports input_digest_bits and input_digest_headroom, the width of the inputHash public input and how far its largest value is below the BN254 scalar field modulus."},
+	{"1.44", "/get-proof", Changed, true, "Finished jobs are kept for 1 hour instead of 24 by default, after which get-proof returns 410 with code job_expired. Set RESULT_TTL_SECONDS or ttlSeconds to keep them longer."},
+	{"1.45", "/retry-proof", Changed, true, "A token whose allowed_circuits does not include the circuit digest of the job is refused with 403 and code circuit_not_allowed."},
+	{"1.45", "/jobs/", Changed, true, "POST /jobs/{jobId}/replay is refused with 403 and code circuit_not_allowed when the allowed_circuits of the token does not include the circuit digest of the job."},
+	{"1.46", "/start-proofs", Changed, false, "Entries are created like start-proof requests: an entry whose public inputs are in the proof cache is finished right away and marked cached, and one identical to a job still in flight returns that job and is marked deduplicated."},
+	{"1.47", "/proof", Changed, false, "DELETE /proof also removes the job from the job store, the proof cache and the PostgreSQL mirror, and deletes jobs only the job store of the instance still knows."},
+	{"1.48", "/proof", Changed, true, "Failed jobs are kept for 5 minutes by default instead of as long as completed jobs, and are reported as expired after that. The TTLs are set with JOB_RESULT_TTL_SECONDS and FAILED_JOB_TTL_SECONDS."},
+	{"1.49", "/start-proof", Changed, true, "Proof requests are rate limited by default to 60 per minute per client, set with RATE_LIMIT_RPM. Requests over the limit are refused with 429 and code rate_limited."},
+	{"1.50", "/start-proof", Changed, false, "Circuits with more than 8 public inputs are supported. Their inputs share the 253 bits of the inputHash evenly, and inputs wider than their share are refused with 422 and code public_input_out_of_range."},
+}
+
+// Current is the API version of this server, the newest version in
+// Registry.
+var Current = Registry[len(Registry)-1].Version
+
+// Version is a MAJOR.MINOR API version.
+type Version struct {
+	Major int
+	Minor int
+}
+
+// ParseVersion parses a MAJOR.MINOR version such as "1.4".
+func ParseVersion(s string) (Version, error) {
+	major, minor, ok := strings.Cut(s, ".")
+	if !ok {
+		return Version{}, fmt.Errorf("invalid API version %q, expected MAJOR.MINOR", s)
+	}
+	var v Version
+	var err error
+	if v.Major, err = strconv.Atoi(major); err != nil || v.Major < 0 {
+		return Version{}, fmt.Errorf("invalid API version %q, expected MAJOR.MINOR", s)
+	}
+	if v.Minor, err = strconv.Atoi(minor); err != nil || v.Minor < 0 {
+		return Version{}, fmt.Errorf("invalid API version %q, expected MAJOR.MINOR", s)
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Less reports whether v is older than w.
+func (v Version) Less(w Version) bool {
+	if v.Major != w.Major {
+		return v.Major < w.Major
+	}
+	return v.Minor < w.Minor
+}
+
+// Since returns the changes made after version, in order.
+func Since(version Version) []Change {
+	changes := []Change{}
+	for _, c := range Registry {
+		if version.Less(mustParse(c.Version)) {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+func mustParse(s string) Version {
+	v, err := ParseVersion(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Validate checks that the registry is well-formed and in order, and that
+// every route pattern served has an entry that added it.
+func Validate(patterns []string) error {
+	var last Version
+	added := make(map[string]bool)
+	for i, c := range Registry {
+		v, err := ParseVersion(c.Version)
+		if err != nil {
+			return fmt.Errorf("change %d: %w", i, err)
+		}
+		if v.Less(last) {
+			return fmt.Errorf("change %d: version %s is older than the change before it", i, c.Version)
+		}
+		last = v
+		switch c.Type {
+		case Added, Changed, Deprecated, Removed:
+		default:
+			return fmt.Errorf("change %d: unknown type %q", i, c.Type)
+		}
+		if c.Endpoint == "" || c.Note == "" {
+			return fmt.Errorf("change %d: endpoint and note are required", i)
+		}
+		if c.Type == Added {
+			added[c.Endpoint] = true
+		}
+	}
+	for _, pattern := range patterns {
+		if !added[pattern] {
+			return fmt.Errorf("route %s has no %q entry in the API change registry", pattern, Added)
+		}
+	}
+	return nil
+}
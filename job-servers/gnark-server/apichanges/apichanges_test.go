@@ -0,0 +1,99 @@
+package apichanges
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryIsValid(t *testing.T) {
+	if err := Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	if Current != Registry[len(Registry)-1].Version {
+		t.Fatalf("Current = %s, want the newest version of the registry", Current)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		registry []Change
+		patterns []string
+		wantErr  string
+	}{
+		{"valid", []Change{
+			{"1.0", "/health", Added, false, "Added."},
+			{"1.1", "/health", Changed, true, "Changed."},
+		}, []string{"/health"}, ""},
+		{"route without an entry", []Change{
+			{"1.0", "/health", Added, false, "Added."},
+		}, []string{"/health", "/start-proof"}, "route /start-proof has no"},
+		{"route only changed", []Change{
+			{"1.0", "/health", Added, false, "Added."},
+			{"1.1", "/start-proof", Changed, false, "Changed."},
+		}, []string{"/start-proof"}, "route /start-proof has no"},
+		{"out of order", []Change{
+			{"1.10", "/health", Added, false, "Added."},
+			{"1.9", "/health", Changed, false, "Changed."},
+		}, nil, "change 1: version 1.9 is older"},
+		{"invalid version", []Change{{"1", "/health", Added, false, "Added."}}, nil, "change 0: invalid API version"},
+		{"unknown type", []Change{{"1.0", "/health", "renamed", false, "Renamed."}}, nil, `unknown type "renamed"`},
+		{"no note", []Change{{"1.0", "/health", Added, false, ""}}, nil, "endpoint and note are required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := Registry
+			Registry = tt.registry
+			t.Cleanup(func() { Registry = registry })
+
+			err := Validate(tt.patterns)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, s := range []string{"1.0", "1.9", "1.10", "2.3"} {
+		v, err := ParseVersion(s)
+		if err != nil || v.String() != s {
+			t.Fatalf("ParseVersion(%q) = %v, %v", s, v, err)
+		}
+	}
+	for _, s := range []string{"", "1", "1.x", "v1.2", "1.-1", "1.2.3"} {
+		if v, err := ParseVersion(s); err == nil {
+			t.Fatalf("ParseVersion(%q) = %v, want an error", s, v)
+		}
+	}
+	if !(Version{1, 9}).Less(Version{1, 10}) || (Version{2, 0}).Less(Version{1, 10}) || (Version{1, 4}).Less(Version{1, 4}) {
+		t.Fatal("Less does not order versions numerically")
+	}
+}
+
+func TestSince(t *testing.T) {
+	changes := Since(Version{1, 9})
+	if len(changes) == 0 {
+		t.Fatal("Since(1.9) is empty")
+	}
+	for _, c := range changes {
+		if v := mustParse(c.Version); !(Version{1, 9}).Less(v) {
+			t.Fatalf("Since(1.9) lists a change of %s", c.Version)
+		}
+	}
+	if changes[0].Version != "1.10" {
+		t.Fatalf("Since(1.9) starts at %s, want 1.10", changes[0].Version)
+	}
+	if got := Since(mustParse(Current)); len(got) != 0 {
+		t.Fatalf("Since(Current) = %v, want nothing", got)
+	}
+	if got := Since(Version{0, 0}); len(got) != len(Registry) {
+		t.Fatalf("Since(0.0) has %d changes, want all %d", len(got), len(Registry))
+	}
+}
@@ -0,0 +1,84 @@
+package verifierCircuit
+
+import (
+	"errors"
+	"fmt"
+
+	"gnark-server/utils"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/qope/gnark-plonky2-verifier/plonk/gates"
+	"github.com/qope/gnark-plonky2-verifier/types"
+)
+
+// goldilocksTwoAdicity is the largest n such that 2^n divides the order of
+// the multiplicative group of the Goldilocks field, which bounds the size of
+// the evaluation domains of a plonky2 proof.
+const goldilocksTwoAdicity = 32
+
+// Validate checks that the parameters of commonData are consistent, so that
+// a malformed common_circuit_data.json is refused with a readable error
+// rather than an index out of range inside the verifier chip during
+// frontend.Compile. It checks that:
+//
+//   - the degree 2^degree_bits and its low degree extension fit in the
+//     two-adic subgroup of Goldilocks, and the FRI reductions fit in them;
+//   - every gate is known to the verifier;
+//   - the routed wires are a subset of the wires, with one k_i each, and the
+//     number of partial products matches them and the quotient degree;
+//   - the public inputs can be packed into a BN254 scalar, the field the
+//     circuit is compiled over.
+//
+// Every inconsistency found is reported.
+func Validate(commonData types.CommonCircuitData) error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	degreeBits := commonData.DegreeBits
+	rateBits := commonData.FriParams.Config.RateBits
+	check(degreeBits > 0, "degree_bits must be positive")
+	check(commonData.FriParams.DegreeBits == degreeBits, "fri_params.degree_bits is %d, expected degree_bits %d", commonData.FriParams.DegreeBits, degreeBits)
+	check(degreeBits+rateBits <= goldilocksTwoAdicity, "a degree of 2^%d with rate_bits %d exceeds the 2^%d subgroup of Goldilocks", degreeBits, rateBits, goldilocksTwoAdicity)
+	check(uint64(commonData.FriParams.TotalArities()) <= degreeBits+rateBits, "the FRI reduction arity bits add up to %d, more than the %d bits of the low degree extension", commonData.FriParams.TotalArities(), degreeBits+rateBits)
+	check(commonData.FriParams.Config.NumQueryRounds > 0, "num_query_rounds must be positive")
+
+	check(len(commonData.GateIds) > 0, "the circuit has no gates")
+	for _, id := range commonData.GateIds {
+		if err := checkGate(id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	config := commonData.Config
+	check(config.NumRoutedWires > 0, "num_routed_wires must be positive")
+	check(config.NumRoutedWires <= config.NumWires, "num_routed_wires %d exceeds num_wires %d", config.NumRoutedWires, config.NumWires)
+	check(uint64(len(commonData.KIs)) == config.NumRoutedWires, "there are %d k_is, expected one per routed wire (%d)", len(commonData.KIs), config.NumRoutedWires)
+	check(config.NumChallenges > 0, "num_challenges must be positive")
+	qdf := commonData.QuotientDegreeFactor
+	check(qdf > 0 && qdf <= config.MaxQuotientDegreeFactor, "quotient_degree_factor %d is not between 1 and max_quotient_degree_factor %d", qdf, config.MaxQuotientDegreeFactor)
+	if qdf > 0 && config.NumRoutedWires > 0 {
+		expected := (config.NumRoutedWires+qdf-1)/qdf - 1
+		check(commonData.NumPartialProducts == expected, "num_partial_products is %d, expected %d for %d routed wires and a quotient degree factor of %d", commonData.NumPartialProducts, expected, config.NumRoutedWires, qdf)
+	}
+
+	if _, err := utils.InputDigestConfigFor(int(commonData.NumPublicInputs), ecc.BN254.ScalarField()); err != nil {
+		errs = append(errs, fmt.Errorf("num_public_inputs %d: %w", commonData.NumPublicInputs, err))
+	}
+	return errors.Join(errs...)
+}
+
+// checkGate returns an error if the verifier does not know the gate id or
+// cannot parse its parameters, which gates.GateInstanceFromId panics on.
+func checkGate(id string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("gate %q: %v", id, r)
+		}
+	}()
+	gates.GateInstanceFromId(id)
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"math/big"
 
+	"gnark-server/utils"
+
 	"github.com/consensys/gnark/frontend"
 	"github.com/qope/gnark-plonky2-verifier/types"
 	"github.com/qope/gnark-plonky2-verifier/variables"
@@ -28,19 +30,41 @@ func (c *VerifierCircuit) Define(api frontend.API) error {
 
 	publicInputs := c.ProofWithPis.PublicInputs
 
-	if len(publicInputs) != 8 {
-		return fmt.Errorf("expected 8 public inputs, got %d", len(publicInputs))
+	cfg, err := utils.InputDigestConfigFor(int(c.CommonCircuitData.NumPublicInputs), api.Compiler().Field())
+	if err != nil {
+		return err
 	}
-
-	inputDigest := frontend.Variable(0)
-	for i := 0; i < 8; i++ {
-		limb := publicInputs[7-i].Limb
-		inputDigest = api.Add(inputDigest, api.Mul(limb, frontend.Variable(new(big.Int).Lsh(big.NewInt(1), uint(32*i)))))
+	if err := cfg.Check(api.Compiler().Field()); err != nil {
+		return err
+	}
+	limbs := make([]frontend.Variable, len(publicInputs))
+	for i, input := range publicInputs {
+		limbs[i] = input.Limb
+	}
+	digest, err := inputDigest(api, limbs, cfg)
+	if err != nil {
+		return err
 	}
 
-	api.AssertIsEqual(c.InputHash, inputDigest)
+	api.AssertIsEqual(c.InputHash, digest)
 
 	api.AssertIsEqual(c.VerifierDigest, c.VerifierData.CircuitDigest)
 
 	return nil
 }
+
+// inputDigest packs the public inputs into the inputHash of the circuit as
+// utils.CalculateDigest does outside of it, for any number of inputs cfg
+// has.
+func inputDigest(api frontend.API, publicInputs []frontend.Variable, cfg utils.InputDigestConfig) (frontend.Variable, error) {
+	n := len(cfg.Lengths)
+	if len(publicInputs) != n {
+		return nil, fmt.Errorf("expected %d public inputs, got %d", n, len(publicInputs))
+	}
+	digest := frontend.Variable(0)
+	for i := 0; i < n; i++ {
+		limb := publicInputs[n-1-i]
+		digest = api.Add(digest, api.Mul(limb, frontend.Variable(new(big.Int).Lsh(big.NewInt(1), cfg.Shift(n-1-i)))))
+	}
+	return digest, nil
+}
@@ -0,0 +1,64 @@
+package verifierCircuit
+
+import (
+	"fmt"
+	"testing"
+
+	"gnark-server/utils"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// digestCircuit checks the packing of Define on its own, since a plonky2
+// proof with a given number of public inputs is not at hand.
+type digestCircuit struct {
+	PublicInputs []frontend.Variable
+	InputHash    frontend.Variable `gnark:",public"`
+	cfg          utils.InputDigestConfig
+}
+
+func (c *digestCircuit) Define(api frontend.API) error {
+	digest, err := inputDigest(api, c.PublicInputs, c.cfg)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(c.InputHash, digest)
+	return nil
+}
+
+func TestInputDigestMatchesCalculateDigest(t *testing.T) {
+	for _, n := range []int{1, 8, 9, 16} {
+		t.Run(fmt.Sprint(n, " inputs"), func(t *testing.T) {
+			cfg, err := utils.InputDigestConfigFor(n, ecc.BN254.ScalarField())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Every input at its largest value, which the first one
+			// exceeds in the widths of the others.
+			inputs := make([]uint64, n)
+			for i, bits := range cfg.Lengths {
+				inputs[i] = uint64(1)<<bits - 1
+			}
+			digest, err := utils.CalculateDigest(inputs, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			circuit := &digestCircuit{PublicInputs: make([]frontend.Variable, n), cfg: cfg}
+			assignment := &digestCircuit{PublicInputs: make([]frontend.Variable, n), InputHash: digest}
+			for i, input := range inputs {
+				assignment.PublicInputs[i] = input
+			}
+			if err := test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()); err != nil {
+				t.Fatalf("in-circuit digest differs from CalculateDigest: %v", err)
+			}
+
+			assignment.PublicInputs[0], assignment.PublicInputs[n-1] = inputs[n-1], inputs[0]
+			if n > 1 && test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()) == nil {
+				t.Fatal("in-circuit digest ignores the order of the inputs")
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package circuitData
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	curve "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	cs "github.com/consensys/gnark/constraint/bn254"
+)
+
+// Names of the proving backends, as accepted by PROVING_BACKEND.
+const (
+	BackendPlonk   = "plonk"
+	BackendGroth16 = "groth16"
+)
+
+// Backend proves and verifies with one proving system. Proofs are exchanged
+// in the layout expected by the Solidity verifier of that system.
+type Backend interface {
+	// Name is BackendPlonk or BackendGroth16.
+	Name() string
+	Prove(ccs constraint.ConstraintSystem, fullWitness witness.Witness) ([]byte, error)
+	Verify(proof []byte, vk VerifyingKey, publicWitness witness.Witness) error
+	// Check reports whether the proving key is loaded and matches vk.
+	Check(vk VerifyingKey) error
+}
+
+// VerifyingKey is implemented by the verifying keys of all backends.
+type VerifyingKey interface {
+	io.WriterTo
+	io.ReaderFrom
+	NbPublicWitness() int
+	ExportSolidity(w io.Writer) error
+}
+
+// PlonkBackend proves with PLONK over a KZG setup.
+type PlonkBackend struct {
+	Pk plonk_bn254.ProvingKey
+}
+
+func (b *PlonkBackend) Name() string { return BackendPlonk }
+
+func (b *PlonkBackend) Prove(ccs constraint.ConstraintSystem, fullWitness witness.Witness) ([]byte, error) {
+	spr, ok := ccs.(*cs.SparseR1CS)
+	if !ok {
+		return nil, fmt.Errorf("plonk needs a sparse R1CS, got %T", ccs)
+	}
+	proof, err := plonk_bn254.Prove(spr, &b.Pk, fullWitness)
+	if err != nil {
+		return nil, err
+	}
+	return proof.MarshalSolidity(), nil
+}
+
+func (b *PlonkBackend) Verify(proof []byte, vk VerifyingKey, publicWitness witness.Witness) error {
+	plonkVk, ok := vk.(*plonk_bn254.VerifyingKey)
+	if !ok {
+		return fmt.Errorf("plonk cannot verify with a %T", vk)
+	}
+	nbCommits := len(plonkVk.CommitmentConstraintIndexes)
+	// 9 points and 8 scalars, plus a scalar and a point per commitment.
+	if expected := 9*64 + 8*32 + nbCommits*(32+64); len(proof) != expected {
+		return fmt.Errorf("plonk proof is %d bytes, expected %d", len(proof), expected)
+	}
+	p := plonk_bn254.UnmarshalSolidity(proof, nbCommits)
+	return plonk_bn254.Verify(&p, plonkVk, publicWitness.Vector().(fr.Vector))
+}
+
+func (b *PlonkBackend) Check(vk VerifyingKey) error {
+	plonkVk, ok := vk.(*plonk_bn254.VerifyingKey)
+	if !ok {
+		return fmt.Errorf("plonk cannot use a %T", vk)
+	}
+	if b.Pk.Vk == nil || b.Pk.Vk.Size == 0 || b.Pk.Vk.NbPublicWitness() == 0 {
+		return errors.New("proving key is not loaded")
+	}
+	if b.Pk.Vk.Size != plonkVk.Size || b.Pk.Vk.NbPublicWitness() != plonkVk.NbPublicWitness() {
+		return fmt.Errorf("proving key (size %d, %d public inputs) does not match verifying key (size %d, %d public inputs)",
+			b.Pk.Vk.Size, b.Pk.Vk.NbPublicWitness(), plonkVk.Size, plonkVk.NbPublicWitness())
+	}
+	return nil
+}
+
+// Groth16Backend proves with Groth16. Its keys come from a circuit specific
+// setup.
+type Groth16Backend struct {
+	Pk groth16_bn254.ProvingKey
+}
+
+func (b *Groth16Backend) Name() string { return BackendGroth16 }
+
+// Prove returns the points A, B and C in the layout of the uint256[8] proof
+// argument of the Solidity verifier, followed by the Pedersen commitments and
+// their proof of knowledge if the circuit has any.
+func (b *Groth16Backend) Prove(ccs constraint.ConstraintSystem, fullWitness witness.Witness) ([]byte, error) {
+	r1cs, ok := ccs.(*cs.R1CS)
+	if !ok {
+		return nil, fmt.Errorf("groth16 needs an R1CS, got %T", ccs)
+	}
+	proof, err := groth16.Prove(r1cs, &b.Pk, fullWitness)
+	if err != nil {
+		return nil, err
+	}
+	p := proof.(*groth16_bn254.Proof)
+	res := make([]byte, 0, 256+64*(len(p.Commitments)+1))
+	res = append(res, p.Ar.Marshal()...)
+	res = append(res, p.Bs.Marshal()...)
+	res = append(res, p.Krs.Marshal()...)
+	if len(p.Commitments) > 0 {
+		for i := range p.Commitments {
+			res = append(res, p.Commitments[i].Marshal()...)
+		}
+		res = append(res, p.CommitmentPok.Marshal()...)
+	}
+	return res, nil
+}
+
+func (b *Groth16Backend) Verify(proof []byte, vk VerifyingKey, publicWitness witness.Witness) error {
+	groth16Vk, ok := vk.(*groth16_bn254.VerifyingKey)
+	if !ok {
+		return fmt.Errorf("groth16 cannot verify with a %T", vk)
+	}
+	nbCommitments := len(groth16Vk.PublicAndCommitmentCommitted)
+	expected := 256
+	if nbCommitments > 0 {
+		expected += 64 * (nbCommitments + 1)
+	}
+	if len(proof) != expected {
+		return fmt.Errorf("groth16 proof is %d bytes, expected %d", len(proof), expected)
+	}
+	var p groth16_bn254.Proof
+	if err := p.Ar.Unmarshal(proof[0:64]); err != nil {
+		return err
+	}
+	if err := p.Bs.Unmarshal(proof[64:192]); err != nil {
+		return err
+	}
+	if err := p.Krs.Unmarshal(proof[192:256]); err != nil {
+		return err
+	}
+	if nbCommitments > 0 {
+		p.Commitments = make([]curve.G1Affine, nbCommitments)
+		offset := 256
+		for i := range p.Commitments {
+			if err := p.Commitments[i].Unmarshal(proof[offset : offset+64]); err != nil {
+				return err
+			}
+			offset += 64
+		}
+		if err := p.CommitmentPok.Unmarshal(proof[offset : offset+64]); err != nil {
+			return err
+		}
+	}
+	return groth16.Verify(&p, groth16Vk, publicWitness)
+}
+
+func (b *Groth16Backend) Check(vk VerifyingKey) error {
+	groth16Vk, ok := vk.(*groth16_bn254.VerifyingKey)
+	if !ok {
+		return fmt.Errorf("groth16 cannot use a %T", vk)
+	}
+	if b.Pk.Domain.Cardinality == 0 || len(b.Pk.G1.A) == 0 {
+		return errors.New("proving key is not loaded")
+	}
+	if !b.Pk.G1.Alpha.Equal(&groth16Vk.G1.Alpha) || !b.Pk.G2.Delta.Equal(&groth16Vk.G2.Delta) {
+		return errors.New("proving key does not match verifying key")
+	}
+	return nil
+}
+
+// newBackend returns an empty backend with its verifying key and constraint
+// system, ready to be read from disk.
+func newBackend(name string) (Backend, VerifyingKey, constraint.ConstraintSystem, error) {
+	switch name {
+	case BackendPlonk:
+		return &PlonkBackend{}, &plonk_bn254.VerifyingKey{}, &cs.SparseR1CS{}, nil
+	case BackendGroth16:
+		return &Groth16Backend{}, &groth16_bn254.VerifyingKey{}, &cs.R1CS{}, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown proving backend %q, use %s or %s", name, BackendPlonk, BackendGroth16)
+	}
+}
+
+// publicInputs returns the number of public inputs of ccs. R1CS count the
+// constant wire as a public variable, sparse R1CS do not.
+func publicInputs(ccs constraint.ConstraintSystem) int {
+	public := ccs.GetNbPublicVariables()
+	if sys, ok := ccs.(*cs.R1CS); ok && sys.Type == constraint.SystemR1CS {
+		public--
+	}
+	return public
+}
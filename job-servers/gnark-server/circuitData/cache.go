@@ -0,0 +1,121 @@
+package circuitData
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/consensys/gnark"
+)
+
+const (
+	// DefaultDir is the directory setup writes to and the server loads from,
+	// unless CIRCUIT_DATA_DIR is set.
+	DefaultDir = "data"
+)
+
+// Files names the files setup writes to DefaultDir for a backend. The PLONK
+// files keep their original names; the Groth16 ones are prefixed so that both
+// backends can be set up in the same directory.
+type Files struct {
+	Circuit          string
+	ProvingKey       string
+	VerifyingKey     string
+	SolidityVerifier string
+	// SolcReport records which solc versions compiled SolidityVerifier.
+	SolcReport string
+	CacheKey   string
+	// Manifest lists the sizes and hashes of the other files.
+	Manifest string
+}
+
+// BackendFiles returns the file names of a backend.
+func BackendFiles(backend string) Files {
+	prefix := ""
+	if backend != BackendPlonk {
+		prefix = backend + "_"
+	}
+	return Files{
+		Circuit:          prefix + "circuit.r1cs",
+		ProvingKey:       prefix + "proving.key",
+		VerifyingKey:     prefix + "verifying.key",
+		SolidityVerifier: prefix + "verifier.sol",
+		SolcReport:       prefix + "solc_report.json",
+		CacheKey:         "." + prefix + "cache_key",
+		Manifest:         prefix + manifestFile,
+	}
+}
+
+// Names returns every file of the backend, along with the plonky2 circuit
+// data they were generated from.
+func (f Files) Names() []string {
+	return []string{CommonCircuitDataFile, f.CacheKey, f.VerifyingKey, f.SolidityVerifier, f.SolcReport, f.Circuit, f.ProvingKey}
+}
+
+// ErrStaleCache is returned by InitCircuitData when the compiled circuit in
+// data/ was not produced from the current circuit parameters and gnark
+// version. Re-running the setup binary regenerates it.
+var ErrStaleCache = errors.New("compiled circuit is stale, re-run setup")
+
+// CacheKey hashes the circuit parameters, the gnark version and the backend
+// that the compiled constraint system and keys depend on.
+func CacheKey(backend string) (string, error) {
+	return cacheKey(Paths{}, backend)
+}
+
+func cacheKey(paths Paths, backend string) (string, error) {
+	f, _, err := openFile(paths.Path(Files{}, CommonCircuitDataFile))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "\ngnark %s", gnark.Version.String())
+	if backend != BackendPlonk {
+		fmt.Fprintf(h, "\nbackend %s", backend)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteCacheKey records the current cache key in the circuit directory of
+// paths. It is called by setup after the circuit and keys have been
+// written.
+func WriteCacheKey(paths Paths, backend string) error {
+	key, err := cacheKey(paths, backend)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(paths.Path(Files{}, BackendFiles(backend).CacheKey), []byte(key+"\n"), 0644)
+}
+
+// checkCache returns ErrStaleCache if the compiled circuit of backend is
+// missing or its recorded cache key does not match the current one.
+func checkCache(paths Paths, backend string) error {
+	files := BackendFiles(backend)
+	circuitPath := paths.Path(files, files.Circuit)
+	cacheKeyPath := paths.Path(files, files.CacheKey)
+	if _, err := statFile(circuitPath); errors.Is(err, ErrMissingFile) {
+		return fmt.Errorf("%w: %w", ErrStaleCache, err)
+	}
+	expected, err := cacheKey(paths, backend)
+	if err != nil {
+		return err
+	}
+	stored, err := os.ReadFile(cacheKeyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrStaleCache, fileError(cacheKeyPath, err))
+	} else if err != nil {
+		return err
+	}
+	if actual := strings.TrimSpace(string(stored)); actual != expected {
+		return fmt.Errorf("%w: cache key %s does not match %s", ErrStaleCache, actual, expected)
+	}
+	return nil
+}
@@ -1,43 +1,514 @@
 package circuitData
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"time"
 
-	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
-	cs "github.com/consensys/gnark/constraint/bn254"
+	"gnark-server/objectstore"
+	"gnark-server/solc"
+	"gnark-server/utils"
+
+	"github.com/consensys/gnark"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/constraint"
 )
 
 type CircuitData struct {
-	Pk  plonk_bn254.ProvingKey
-	Vk  plonk_bn254.VerifyingKey
-	Ccs cs.SparseR1CS
+	// Name is the name of the circuit, the name of its directory under
+	// data/, or DefaultCircuit when the keys are in data/ itself.
+	Name string
+	// Backend holds the proving key of the proving system the circuit was
+	// set up for.
+	Backend Backend
+	Vk      VerifyingKey
+	Ccs     constraint.ConstraintSystem
+	// ReleaseId is a short identifier of the loaded circuit release, derived
+	// from the hash of the verifying key file.
+	ReleaseId string
+	// Version identifies exactly what was loaded. It is computed once by
+	// InitCircuitDataFromDir.
+	Version Version
+	// ProofLimits bounds the proofs submitted for the circuit. It is nil
+	// when the common circuit data is not in the data directory.
+	ProofLimits *ProofLimits
+
+	// inputDigest packs the public inputs of the plonky2 proofs into the
+	// inputHash of the circuit, as Define does for the number of public
+	// inputs in the common circuit data.
+	inputDigest utils.InputDigestConfig
+
+	logger *log.Logger
+}
+
+// Version describes the loaded circuit release.
+type Version struct {
+	// VerifyingKeyHash is the hex SHA-256 of the verifying key file.
+	VerifyingKeyHash string
+	// CircuitDigest is the plonky2 circuit digest of the verifier only
+	// circuit data the circuit was set up with, or empty if that file is not
+	// in the data directory.
+	CircuitDigest string
+	// Constraints is the number of constraints of the compiled circuit.
+	Constraints int
+	// PublicInputs is the number of public inputs of the verifying key.
+	PublicInputs int
+	// ProvingKeySize and VerifyingKeySize are the sizes in bytes of the key
+	// files. ProvingKeySize is zero when the proving key did not load.
+	ProvingKeySize   int64
+	VerifyingKeySize int64
+	GnarkVersion     string
+	// Solc lists the solc versions setup compiled the Solidity verifier
+	// with, or is nil if setup did not check any.
+	Solc []solc.Result
+}
+
+const (
+	releaseIdLength = 12
+
+	// DefaultCircuit is the name of the only circuit when its keys are in
+	// data/ itself rather than in a directory per circuit.
+	DefaultCircuit = "default"
+)
+
+// InitCircuitData loads the compiled circuits and keys of backend from the
+// data directory of paths, keyed by circuit name. See InitCircuitsFromDir for
+// the layout. With overrides, the data directory is the only circuit, named
+// DefaultCircuit, and the overridden files are read from their paths. It
+// returns ErrStaleCache if they were not generated for the current circuit
+// parameters. With fastLoad, proving keys are read without subgroup checks
+// when their release manifest vouches for them, see InitCircuitDataFromDir.
+func InitCircuitData(paths Paths, backend string, fastLoad bool) (Registry, error) {
+	if !paths.HasOverrides() {
+		return InitCircuitsFromDir(paths.DataDir(), backend, fastLoad)
+	}
+	data, err := InitCircuitDataFromPaths(paths, backend, fastLoad)
+	data.Name = DefaultCircuit
+	return Registry{DefaultCircuit: data}, err
+}
+
+// ErrProvingKey is returned by InitCircuitData, wrapped, when everything but
+// the proving key loaded. The returned CircuitData can still verify proofs.
+var ErrProvingKey = errors.New("proving key could not be loaded")
+
+// InitCircuitsFromDir loads every circuit of backend in dir. If dir holds
+// the verifying key of backend itself, or a pointer to a release of it, it
+// is the only circuit, named DefaultCircuit. Otherwise each subdirectory
+// <name>/ holding one is loaded as the circuit name, laid out like data/ for
+// a single circuit. See ReleaseDir for the two layouts. If only
+// proving keys failed to load, the circuits are returned along with the
+// ErrProvingKey of the first such circuit.
+func InitCircuitsFromDir(dir string, backend string, fastLoad bool) (Registry, error) {
+	if isCircuitDir(dir, backend) {
+		data, err := InitCircuitDataFromDir(dir, backend, fastLoad)
+		data.Name = DefaultCircuit
+		return Registry{DefaultCircuit: data}, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fileError(dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if isCircuitDir(filepath.Join(dir, entry.Name()), backend) {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: no %s in %s or in a circuit directory under it", ErrStaleCache, BackendFiles(backend).VerifyingKey, dir)
+	}
+	sort.Strings(names)
+	circuits := make(Registry, len(names))
+	var pkErr error
+	for _, name := range names {
+		data, err := initNamedCircuit(filepath.Join(dir, name), name, backend, fastLoad)
+		if err != nil && !errors.Is(err, ErrProvingKey) {
+			return nil, fmt.Errorf("circuit %s: %w", name, err)
+		}
+		if err != nil && pkErr == nil {
+			pkErr = fmt.Errorf("circuit %s: %w", name, err)
+		}
+		circuits[name] = data
+	}
+	return circuits, pkErr
+}
+
+// InitCircuitDataFromDir is InitCircuitData for a data directory laid out
+// like data/, in either layout.
+func InitCircuitDataFromDir(dir string, backend string, fastLoad bool) (CircuitData, error) {
+	return InitCircuitDataFromPaths(Paths{Dir: dir}, backend, fastLoad)
+}
+
+// InitCircuitDataFromPaths is InitCircuitDataFromDir for the circuit
+// directory of paths, reading the overridden files from their paths. A file
+// that does not exist is an error wrapping ErrMissingFile, naming its
+// absolute path.
+//
+// Before anything is deserialized, the verifying key, constraint system and
+// proving key are checked against the manifest of the release, or the one
+// setup wrote in the flat layout: a file whose size or SHA-256 differs
+// returns an error wrapping ErrManifestMismatch, which is ErrProvingKey for
+// the proving key. Without a manifest, as for keys written by older setup
+// versions, the files are loaded with a warning. Each file must also be read
+// to its end.
+//
+// The subgroup checks of every point make reading the proving key by far the
+// slowest part of startup. With fastLoad, a proving key whose checksum was
+// verified is read without them. The time each file took to load is logged.
+//
+// Overridden files can be objects in S3 or Google Cloud Storage, given as
+// s3:// or gs:// URLs. With an ObjectCacheDir they are downloaded there
+// first, unless the copy of their current ETag already is, and loaded like
+// local files. Otherwise they are streamed into the deserializers, hashed
+// as they are read for the manifest check, so a proving key read this way
+// always gets its subgroup checks.
+func InitCircuitDataFromPaths(paths Paths, backend string, fastLoad bool) (CircuitData, error) {
+	var data CircuitData
+	var err error
+	data.Backend, data.Vk, data.Ccs, err = newBackend(backend)
+	if err != nil {
+		return data, err
+	}
+	paths, manifestPath, manifest, err := openRelease(paths, backend, true)
+	if err != nil {
+		return data, err
+	}
+	dir := paths.DataDir()
+	files := BackendFiles(backend)
+	pkVerified := false
+	var pkErr error
+	if manifest == nil {
+		log.Printf("WARNING: %s has no %s, the checksums of the keys are not verified (re-run setup to write it)\n", dir, filepath.Base(manifestPath))
+	} else {
+		startedAt := time.Now()
+		for _, name := range []string{files.VerifyingKey, files.Circuit} {
+			if err := manifest.VerifyPath(paths.Path(files, name), name); err != nil {
+				return data, err
+			}
+		}
+		pkErr = manifest.VerifyPath(paths.Path(files, files.ProvingKey), files.ProvingKey)
+		pkVerified = pkErr == nil && !objectstore.IsURL(paths.Path(files, files.ProvingKey))
+		if pkVerified {
+			log.Printf("Verified the checksums of %s against %s in %v\n", dir, filepath.Base(manifestPath), time.Since(startedAt).Round(time.Millisecond))
+		}
+	}
+	if err := data.loadVerifyingKey(paths, files, manifest); err != nil {
+		return data, err
+	}
+	if pkErr == nil {
+		pkPath := paths.Path(files, files.ProvingKey)
+		pkErr = data.loadProvingKey(pkPath, manifest.object(pkPath, files.ProvingKey), fastLoad && pkVerified)
+	}
+	startedAt := time.Now()
+	ccsPath := paths.Path(files, files.Circuit)
+	if _, err := readArtifact("constraint system", ccsPath, manifest.object(ccsPath, files.Circuit), data.Ccs.ReadFrom); err != nil {
+		return data, err
+	}
+	data.Version.Constraints = data.Ccs.GetNbConstraints()
+	data.Version.PublicInputs = data.Vk.NbPublicWitness()
+	data.logger.Printf("Loaded %s in %v\n", files.Circuit, time.Since(startedAt).Round(time.Millisecond))
+	data.Version.GnarkVersion = gnark.Version.String()
+	if data.Version.CircuitDigest, err = readCircuitDigest(paths.Path(files, VerifierOnlyCircuitDataFile)); err != nil {
+		return data, err
+	}
+	if err := data.loadCommonCircuitData(paths.Path(files, CommonCircuitDataFile)); err != nil {
+		return data, err
+	}
+	if report, err := solc.ReadReport(filepath.Join(dir, files.SolcReport)); err == nil {
+		data.Version.Solc = report.Results
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return data, err
+	}
+	if pkErr != nil {
+		// Drop the partially read key so that ValidateProvingKey fails.
+		data.Backend, _, _, _ = newBackend(backend)
+		return data, fmt.Errorf("%w: %w", ErrProvingKey, pkErr)
+	}
+	return data, nil
 }
 
-func InitCircuitData() CircuitData {
+// InitVerifierFromPaths loads what verifying proofs of the circuit of paths
+// takes: its verifying key, checked against the manifest as
+// InitCircuitDataFromPaths does, and the input layout of its common circuit
+// data. The constraint system and proving key are neither read nor required,
+// so the returned CircuitData can only verify.
+func InitVerifierFromPaths(paths Paths, backend string) (CircuitData, error) {
 	var data CircuitData
-	{
-		fVk, err := os.Open("data/verifying.key")
-		if err != nil {
-			panic(err)
+	var err error
+	data.Backend, data.Vk, _, err = newBackend(backend)
+	if err != nil {
+		return data, err
+	}
+	paths, manifestPath, manifest, err := openRelease(paths, backend, false)
+	if err != nil {
+		return data, err
+	}
+	files := BackendFiles(backend)
+	if manifest == nil {
+		log.Printf("WARNING: %s has no %s, the checksum of the verifying key is not verified (re-run setup to write it)\n", paths.DataDir(), filepath.Base(manifestPath))
+	}
+	if err := data.loadVerifyingKey(paths, files, manifest); err != nil {
+		return data, err
+	}
+	data.Version.PublicInputs = data.Vk.NbPublicWitness()
+	if err := data.loadCommonCircuitData(paths.Path(files, CommonCircuitDataFile)); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+// openRelease returns paths in the release directory of its data directory,
+// with object overrides fetched, along with the path of the manifest of the
+// release and the manifest, nil if there is none. The manifest must be for
+// backend. With compiled, the compiled circuit must be current, see
+// checkCache.
+func openRelease(paths Paths, backend string, compiled bool) (Paths, string, *Manifest, error) {
+	circuitDir := paths.DataDir()
+	if objectstore.IsURL(circuitDir) {
+		return paths, "", nil, fmt.Errorf("the data directory %s must be local, set the path of each file to its object URL instead", circuitDir)
+	}
+	dir, err := ReleaseDir(circuitDir, backend)
+	if err != nil {
+		return paths, "", nil, err
+	}
+	paths = paths.In(dir)
+	if paths, err = paths.fetchObjects(context.Background()); err != nil {
+		return paths, "", nil, err
+	}
+	if compiled {
+		if err := checkCache(paths, backend); err != nil {
+			return paths, "", nil, err
 		}
-		_, _ = data.Vk.ReadFrom(fVk)
-		defer fVk.Close()
 	}
-	{
-		fPk, err := os.Open("data/proving.key")
-		if err != nil {
-			panic(err)
+	manifestPath := filepath.Join(dir, manifestFile)
+	if dir == circuitDir {
+		manifestPath = filepath.Join(dir, BackendFiles(backend).Manifest)
+	}
+	manifest, err := readManifest(manifestPath)
+	if err != nil {
+		return paths, "", nil, err
+	}
+	if manifest != nil && manifest.Backend != "" && manifest.Backend != backend {
+		return paths, "", nil, fmt.Errorf("%w: %s was written for the %s backend, not %s", ErrManifestMismatch, manifestPath, manifest.Backend, backend)
+	}
+	return paths, manifestPath, manifest, nil
+}
+
+// loadVerifyingKey reads the verifying key of paths, and sets the release ID
+// and logger of d from its hash.
+func (d *CircuitData) loadVerifyingKey(paths Paths, files Files, manifest *Manifest) error {
+	startedAt := time.Now()
+	h := sha256.New()
+	vkPath := paths.Path(files, files.VerifyingKey)
+	size, err := readArtifact("verifying key", vkPath, manifest.object(vkPath, files.VerifyingKey), func(r io.Reader) (int64, error) {
+		return d.Vk.ReadFrom(io.TeeReader(r, h))
+	})
+	if err != nil {
+		return err
+	}
+	d.Version.VerifyingKeySize = size
+	d.Version.VerifyingKeyHash = hex.EncodeToString(h.Sum(nil))
+	d.ReleaseId = d.Version.VerifyingKeyHash[:releaseIdLength]
+	d.logger = log.New(log.Writer(), "release="+d.ReleaseId+" ", log.Flags()|log.Lmsgprefix)
+	d.logger.Printf("Loaded %s in %v\n", files.VerifyingKey, time.Since(startedAt).Round(time.Millisecond))
+	return nil
+}
+
+// loadCommonCircuitData sets the proof limits and input layout of d from the
+// common circuit data at path, if it exists.
+func (d *CircuitData) loadCommonCircuitData(path string) error {
+	common, err := readCommonCircuitData(path)
+	if err != nil || common == nil {
+		return err
+	}
+	limits := NewProofLimits(*common)
+	d.ProofLimits = &limits
+	d.inputDigest, err = utils.InputDigestConfigFor(int(common.NumPublicInputs), ecc.BN254.ScalarField())
+	return err
+}
+
+// ReleaseId returns the release ID of the keys of backend in dir, as
+// InitCircuitDataFromDir would set it, without loading them.
+func ReleaseId(dir string, backend string) (string, error) {
+	dir, err := ReleaseDir(dir, backend)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(filepath.Join(dir, BackendFiles(backend).VerifyingKey))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:releaseIdLength], nil
+}
+
+// readCircuitDigest returns the circuit digest of a verifier only circuit
+// data file, or "" if the file does not exist.
+func readCircuitDigest(path string) (string, error) {
+	raw, err := ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var vd struct {
+		CircuitDigest string `json:"circuit_digest"`
+	}
+	if err := json.Unmarshal(raw, &vd); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return vd.CircuitDigest, nil
+}
+
+// loadProvingKey reads the proving key at path, without subgroup checks if
+// unchecked is set. See readArtifact for expected.
+func (d *CircuitData) loadProvingKey(path string, expected *ManifestFile, unchecked bool) error {
+	startedAt := time.Now()
+	size, err := readArtifact("proving key", path, expected, func(r io.Reader) (int64, error) {
+		return d.readProvingKey(r, !unchecked)
+	})
+	if err != nil {
+		return err
+	}
+	d.Version.ProvingKeySize = size
+	if unchecked {
+		d.Logger().Printf("Loaded %s in %v without subgroup checks, checksum verified\n", filepath.Base(path), time.Since(startedAt).Round(time.Millisecond))
+	} else {
+		d.Logger().Printf("Loaded %s in %v\n", filepath.Base(path), time.Since(startedAt).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// readArtifact opens path and deserializes the artifact in it with read,
+// which must consume the whole file: a key cut short by an interrupted copy
+// fails to decode, and one followed by stray bytes was not written by setup.
+// It returns the size of the file. Unless expected is nil, the SHA-256 of
+// what was read must match it, or an error wrapping ErrManifestMismatch is
+// returned. Errors name the artifact and its path.
+func readArtifact(artifact string, path string, expected *ManifestFile, read func(io.Reader) (int64, error)) (int64, error) {
+	f, size, err := openFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", artifact, err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	h := sha256.New()
+	if expected != nil {
+		r = io.TeeReader(f, h)
+	}
+	n, err := read(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s %s: after %d of %d bytes: %w", artifact, displayPath(path), n, size, err)
+	}
+	if n != size {
+		return 0, fmt.Errorf("failed to read %s %s: read %d of %d bytes", artifact, displayPath(path), n, size)
+	}
+	if expected != nil {
+		if actual := hex.EncodeToString(h.Sum(nil)); actual != expected.Sha256 {
+			return 0, fmt.Errorf("%w: %s %s has SHA-256 %s, expected %s", ErrManifestMismatch, artifact, displayPath(path), actual, expected.Sha256)
 		}
-		_, _ = data.Pk.ReadFrom(fPk)
-		defer fPk.Close()
 	}
-	{
-		fCs, err := os.Open("data/circuit.r1cs")
-		if err != nil {
-			panic(err)
+	return n, nil
+}
+
+// readProvingKey reads the proving key of the backend from r. Without
+// subgroupChecks the points are not checked to be in the right subgroup,
+// which is only safe for a key known to be the one setup wrote.
+func (d *CircuitData) readProvingKey(r io.Reader, subgroupChecks bool) (int64, error) {
+	switch b := d.Backend.(type) {
+	case *PlonkBackend:
+		if !subgroupChecks {
+			return b.Pk.UnsafeReadFrom(r)
+		}
+		return b.Pk.ReadFrom(r)
+	case *Groth16Backend:
+		if !subgroupChecks {
+			return b.Pk.UnsafeReadFrom(r)
 		}
-		_, _ = data.Ccs.ReadFrom(fCs)
-		defer fCs.Close()
+		return b.Pk.ReadFrom(r)
+	default:
+		return 0, fmt.Errorf("cannot read proving key of %T", d.Backend)
+	}
+}
+
+// Logger returns the logger for work done with this circuit. Its lines are
+// prefixed with the release ID so that logs say which release served a job.
+func (d *CircuitData) Logger() *log.Logger {
+	if d.logger == nil {
+		return log.Default()
+	}
+	return d.logger
+}
+
+// InputDigestConfig returns how the public inputs of the plonky2 proofs of
+// the circuit are packed into its inputHash. Without common circuit data in
+// the data directory it is utils.DefaultInputDigestConfig.
+func (d *CircuitData) InputDigestConfig() utils.InputDigestConfig {
+	if len(d.inputDigest.Lengths) == 0 {
+		return utils.DefaultInputDigestConfig
+	}
+	return d.inputDigest
+}
+
+// Validate checks that the proving key, verifying key and constraint system
+// were actually populated from disk and are consistent with each other.
+func (d *CircuitData) Validate() error {
+	if err := d.ValidateVerifyingKey(); err != nil {
+		return err
+	}
+	if err := d.ValidateProvingKey(); err != nil {
+		return err
+	}
+	return d.ValidateConstraintSystem()
+}
+
+// ValidateVerifyingKey checks that the verifying key was loaded.
+func (d *CircuitData) ValidateVerifyingKey() error {
+	if d.Vk == nil || d.Vk.NbPublicWitness() == 0 {
+		return errors.New("verifying key is not loaded")
+	}
+	return nil
+}
+
+// ValidateProvingKey checks that the proving key was loaded and matches the
+// verifying key.
+func (d *CircuitData) ValidateProvingKey() error {
+	if d.Backend == nil {
+		return errors.New("proving key is not loaded")
+	}
+	if err := d.ValidateVerifyingKey(); err != nil {
+		return err
+	}
+	return d.Backend.Check(d.Vk)
+}
+
+// ValidateConstraintSystem checks that the constraint system was loaded and,
+// if the verifying key was, that both have the same public inputs.
+func (d *CircuitData) ValidateConstraintSystem() error {
+	if d.Ccs == nil || d.Ccs.GetNbConstraints() <= 0 {
+		return errors.New("constraint system has no constraints")
+	}
+	if d.Vk == nil {
+		return nil
+	}
+	if public := publicInputs(d.Ccs); public != d.Vk.NbPublicWitness() {
+		return fmt.Errorf("constraint system has %d public inputs, the verifying key %d", public, d.Vk.NbPublicWitness())
 	}
-	return data
+	return nil
 }
@@ -0,0 +1,207 @@
+package circuitData
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// squareCircuit proves knowledge of the square root of Y.
+type squareCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (c *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.X, c.X), c.Y)
+	return nil
+}
+
+// writeGroth16Dir sets up squareCircuit with Groth16 in a temporary circuit
+// directory in the flat layout, as setup would, and returns it.
+func writeGroth16Dir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeGroth16Files(t, dir)
+	return dir
+}
+
+// writeGroth16Files sets up squareCircuit with Groth16 in the circuit
+// directory dir in the flat layout, with new keys on every call.
+func writeGroth16Files(t *testing.T, dir string) {
+	t.Helper()
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	common, err := os.ReadFile(filepath.Join("..", DefaultDir, CommonCircuitDataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, CommonCircuitDataFile), common, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := BackendFiles(BackendGroth16)
+	for name, artifact := range map[string]io.WriterTo{files.Circuit: ccs, files.ProvingKey: pk, files.VerifyingKey: vk} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := artifact.WriteTo(f); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteCacheKey(Paths{Dir: dir}, BackendGroth16); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInitCircuitDataFromDirCorruptArtifact(t *testing.T) {
+	dir := writeGroth16Dir(t)
+	if _, err := InitCircuitDataFromDir(dir, BackendGroth16, false); err != nil {
+		t.Fatalf("InitCircuitDataFromDir() = %v", err)
+	}
+
+	files := BackendFiles(BackendGroth16)
+	tests := []struct {
+		artifact string
+		file     string
+	}{
+		{"constraint system", files.Circuit},
+		{"verifying key", files.VerifyingKey},
+		{"proving key", files.ProvingKey},
+	}
+	for _, tt := range tests {
+		for _, corruption := range []string{"truncated", "trailing bytes"} {
+			t.Run(tt.artifact+"/"+corruption, func(t *testing.T) {
+				dir := writeGroth16Dir(t)
+				path := filepath.Join(dir, tt.file)
+				raw, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if corruption == "truncated" {
+					raw = raw[:len(raw)/2]
+				} else {
+					raw = append(raw, 0)
+				}
+				if err := os.WriteFile(path, raw, 0644); err != nil {
+					t.Fatal(err)
+				}
+
+				_, err = InitCircuitDataFromDir(dir, BackendGroth16, false)
+				if err == nil {
+					t.Fatal("InitCircuitDataFromDir() accepted a corrupt " + tt.artifact)
+				}
+				want := "failed to read " + tt.artifact + " " + displayPath(path)
+				if !strings.Contains(err.Error(), want) {
+					t.Fatalf("InitCircuitDataFromDir() = %v, want an error containing %q", err, want)
+				}
+				if tt.file == files.ProvingKey && !errors.Is(err, ErrProvingKey) {
+					t.Fatalf("InitCircuitDataFromDir() = %v, want ErrProvingKey", err)
+				}
+			})
+		}
+	}
+}
+
+// proveSquare proves with data that 3 is the square root of 9 and verifies
+// the proof.
+func proveSquare(t *testing.T, data CircuitData) {
+	t.Helper()
+	full, err := frontend.NewWitness(&squareCircuit{X: 3, Y: 9}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := full.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := data.Backend.Prove(data.Ccs, full)
+	if err != nil {
+		t.Fatalf("Prove() = %v", err)
+	}
+	if err := data.Backend.Verify(proof, data.Vk, public); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+}
+
+// loadLogged loads dir and returns what the load logged.
+func loadLogged(t *testing.T, dir string, fastLoad bool) (CircuitData, string, error) {
+	t.Helper()
+	var buf strings.Builder
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	data, err := InitCircuitDataFromDir(dir, BackendGroth16, fastLoad)
+	return data, buf.String(), err
+}
+
+func TestFastLoadProvingKey(t *testing.T) {
+	const unchecked = "without subgroup checks, checksum verified"
+	dir := writeGroth16Dir(t)
+	if err := WriteSetupManifest(Paths{Dir: dir}, BackendGroth16); err != nil {
+		t.Fatal(err)
+	}
+	data, logged, err := loadLogged(t, dir, true)
+	if err != nil {
+		t.Fatalf("InitCircuitDataFromDir() with fast load = %v", err)
+	}
+	if !strings.Contains(logged, unchecked) {
+		t.Fatalf("the proving key was not fast loaded:\n%s", logged)
+	}
+	files := BackendFiles(BackendGroth16)
+	for _, name := range []string{files.VerifyingKey, files.ProvingKey, files.Circuit} {
+		if !strings.Contains(logged, "Loaded "+name+" in ") {
+			t.Fatalf("the load duration of %s was not logged:\n%s", name, logged)
+		}
+	}
+	proveSquare(t, data)
+
+	// Without a manifest to vouch for it, the key is read with the checks.
+	dir = writeGroth16Dir(t)
+	data, logged, err = loadLogged(t, dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logged, unchecked) {
+		t.Fatalf("a proving key without a checksum was fast loaded:\n%s", logged)
+	}
+	proveSquare(t, data)
+
+	// A key that does not match its manifest is not fast loaded.
+	if err := WriteSetupManifest(Paths{Dir: dir}, BackendGroth16); err != nil {
+		t.Fatal(err)
+	}
+	pk := filepath.Join(dir, files.ProvingKey)
+	raw, err := os.ReadFile(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)/2] ^= 1
+	if err := os.WriteFile(pk, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, logged, err = loadLogged(t, dir, true)
+	if !errors.Is(err, ErrProvingKey) || !errors.Is(err, ErrManifestMismatch) {
+		t.Fatalf("InitCircuitDataFromDir() of a tampered key = %v, want ErrProvingKey and ErrManifestMismatch", err)
+	}
+	if strings.Contains(logged, unchecked) {
+		t.Fatalf("a tampered proving key was fast loaded:\n%s", logged)
+	}
+}
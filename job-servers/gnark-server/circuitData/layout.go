@@ -0,0 +1,407 @@
+package circuitData
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"gnark-server/objectstore"
+)
+
+// A circuit directory, data/ or data/<name>/, holds its releases in
+// releases/<release>/, each with the files of a backend and a manifest, and
+// names the release the server loads in a pointer file, current for PLONK.
+// Setup still writes the flat layout of earlier versions, the files in the
+// circuit directory itself; MigrateLegacyLayouts moves them into a release
+// on startup.
+const (
+	releasesDir  = "releases"
+	manifestFile = "manifest.json"
+
+	legacyReleasePrefix = "legacy-"
+)
+
+// Manifest records the files of a release and their hashes.
+type Manifest struct {
+	// Release is empty in the manifest setup writes in the flat layout.
+	Release   string         `json:"release,omitempty"`
+	Backend   string         `json:"backend"`
+	CreatedAt time.Time      `json:"createdAt"`
+	Files     []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// File returns the entry of the file name, or nil if it is not listed.
+func (m *Manifest) File(name string) *ManifestFile {
+	if m == nil {
+		return nil
+	}
+	for i := range m.Files {
+		if m.Files[i].Name == name {
+			return &m.Files[i]
+		}
+	}
+	return nil
+}
+
+// ErrManifestMismatch is wrapped by the errors of Manifest.Verify.
+var ErrManifestMismatch = errors.New("file does not match the manifest")
+
+// Verify checks that the file name in dir has the size and SHA-256 the
+// manifest lists for it.
+func (m *Manifest) Verify(dir string, name string) error {
+	return m.VerifyPath(filepath.Join(dir, name), name)
+}
+
+// VerifyPath is Verify for the file name kept at path. Only the size of an
+// object is checked: readArtifact checks its SHA-256 as it reads it.
+func (m *Manifest) VerifyPath(path string, name string) error {
+	expected := m.File(name)
+	if expected == nil {
+		return fmt.Errorf("%w: %s is not listed", ErrManifestMismatch, name)
+	}
+	size, err := statFile(path)
+	if err != nil {
+		return err
+	}
+	if size != expected.Size {
+		return fmt.Errorf("%w: %s is %d bytes, expected %d", ErrManifestMismatch, path, size, expected.Size)
+	}
+	if objectstore.IsURL(path) {
+		// Objects are hashed as they are read, see readArtifact, rather
+		// than downloaded twice.
+		return nil
+	}
+	actual, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	if actual.Sha256 != expected.Sha256 {
+		return fmt.Errorf("%w: %s has SHA-256 %s, expected %s", ErrManifestMismatch, path, actual.Sha256, expected.Sha256)
+	}
+	return nil
+}
+
+// object returns the entry of name when path is an object URL, whose
+// SHA-256 VerifyPath left to be checked as it is read, or nil.
+func (m *Manifest) object(path string, name string) *ManifestFile {
+	if m == nil || !objectstore.IsURL(path) {
+		return nil
+	}
+	return m.File(name)
+}
+
+// readManifest returns the manifest at path, or nil when there is none.
+func readManifest(path string) (*Manifest, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+// WriteSetupManifest records the sizes and hashes of the files of backend
+// in the circuit directory of paths, including the overridden ones. It is
+// called by setup after every file has been written; the server verifies the
+// keys against it before loading them, and MigrateLegacyLayout before moving
+// them into a release.
+func WriteSetupManifest(paths Paths, backend string) error {
+	manifest, err := newManifest(paths, backend)
+	if err != nil {
+		return err
+	}
+	return writeManifest(paths.Path(Files{}, BackendFiles(backend).Manifest), manifest)
+}
+
+// newManifest hashes the files of backend of paths.
+func newManifest(paths Paths, backend string) (Manifest, error) {
+	files := BackendFiles(backend)
+	manifest := Manifest{Backend: backend, CreatedAt: time.Now().UTC()}
+	for _, name := range append(copiedFiles(), migratedFiles(files)...) {
+		file, err := hashFile(paths.Path(files, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return manifest, err
+		}
+		file.Name = name
+		manifest.Files = append(manifest.Files, file)
+	}
+	return manifest, nil
+}
+
+func writeManifest(path string, manifest Manifest) error {
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(raw, '\n'))
+}
+
+// currentFile returns the name of the pointer file of backend.
+func currentFile(backend string) string {
+	if backend != BackendPlonk {
+		return backend + "_current"
+	}
+	return "current"
+}
+
+// ReleaseDir returns the directory the files of backend in the circuit
+// directory dir are loaded from: the release its pointer file names, or dir
+// itself when it holds a verifying key in the flat layout or has no pointer
+// file.
+func ReleaseDir(dir string, backend string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, BackendFiles(backend).VerifyingKey)); err == nil {
+		return dir, nil
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, currentFile(backend)))
+	if errors.Is(err, os.ErrNotExist) {
+		return dir, nil
+	} else if err != nil {
+		return "", err
+	}
+	release := strings.TrimSpace(string(raw))
+	if release == "" || release != filepath.Base(release) || release == "." || release == ".." {
+		return "", fmt.Errorf("%s does not name a release: %q", filepath.Join(dir, currentFile(backend)), release)
+	}
+	return filepath.Join(dir, releasesDir, release), nil
+}
+
+// isCircuitDir reports whether dir holds a circuit of backend in either
+// layout.
+func isCircuitDir(dir string, backend string) bool {
+	for _, name := range []string{BackendFiles(backend).VerifyingKey, currentFile(backend)} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateLegacyLayouts migrates dir and every circuit directory under it
+// from the flat layout, see MigrateLegacyLayout.
+func MigrateLegacyLayouts(dir string, backend string, dryRun bool) error {
+	dirs := []string{dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != releasesDir {
+			dirs = append(dirs, filepath.Join(dir, entry.Name()))
+		}
+	}
+	for _, d := range dirs {
+		if err := MigrateLegacyLayout(d, backend, dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateLegacyLayout moves the files of backend written by setup to the
+// circuit directory dir into releases/legacy-<release ID>/, writes its
+// manifest and points the current pointer file at it. The plonky2 circuit
+// data setup reads is copied rather than moved. A release left incomplete by
+// an interrupted migration is completed. Keys that do not match the manifest
+// setup wrote are not migrated, with a warning.
+//
+// Nothing is changed when dryRun is set: the steps are only logged. When the
+// directory is read-only the files are left in place with a warning, and
+// ReleaseDir keeps loading them from there.
+func MigrateLegacyLayout(dir string, backend string, dryRun bool) error {
+	files := BackendFiles(backend)
+	if _, err := os.Stat(filepath.Join(dir, files.VerifyingKey)); errors.Is(err, os.ErrNotExist) {
+		return completeInterruptedMigration(dir, backend, dryRun)
+	} else if err != nil {
+		return err
+	}
+	releaseId, err := ReleaseId(dir, backend)
+	if err != nil {
+		return err
+	}
+	release := legacyReleasePrefix + releaseId
+	target := filepath.Join(dir, releasesDir, release)
+	prefix := "Migrating"
+	if dryRun {
+		prefix = "Dry run: would migrate"
+	}
+	// Files that do not match the manifest of setup are left in place, so
+	// that loading them reports the mismatch.
+	if manifest, err := readManifest(filepath.Join(dir, files.Manifest)); err != nil {
+		return err
+	} else if manifest != nil {
+		for _, name := range []string{files.VerifyingKey, files.Circuit, files.ProvingKey} {
+			if err := manifest.Verify(dir, name); err != nil {
+				log.Printf("WARNING: %s is not migrated: %v\n", dir, err)
+				return nil
+			}
+		}
+	}
+	log.Printf("%s %s from the flat layout into %s\n", prefix, dir, target)
+	if dryRun {
+		for _, name := range migratedFiles(files) {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				log.Printf("Dry run: would move %s\n", filepath.Join(dir, name))
+			}
+		}
+		for _, name := range copiedFiles() {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				log.Printf("Dry run: would copy %s\n", filepath.Join(dir, name))
+			}
+		}
+		log.Printf("Dry run: would write %s and point %s at %s\n",
+			filepath.Join(target, manifestFile), filepath.Join(dir, currentFile(backend)), release)
+		return nil
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		if isReadOnly(err) {
+			log.Printf("WARNING: %s cannot be migrated, loading the flat layout in place: %v\n", dir, err)
+			return nil
+		}
+		return err
+	}
+	// Any manifest is stale now; completeInterruptedMigration recognizes a
+	// release without one if the migration is interrupted.
+	if err := os.Remove(filepath.Join(target, manifestFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for _, name := range copiedFiles() {
+		if err := copyFile(filepath.Join(dir, name), filepath.Join(target, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	// The verifying key is moved last, so that the flat layout is still
+	// detected until every other file has been moved.
+	for _, name := range migratedFiles(files) {
+		if err := os.Rename(filepath.Join(dir, name), filepath.Join(target, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	// The manifest of the release replaces the one setup wrote.
+	if err := os.Remove(filepath.Join(dir, files.Manifest)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return finishRelease(dir, backend, release)
+}
+
+// completeInterruptedMigration finishes a legacy release whose files were
+// all moved but whose manifest was not written.
+func completeInterruptedMigration(dir string, backend string, dryRun bool) error {
+	matches, err := filepath.Glob(filepath.Join(dir, releasesDir, legacyReleasePrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, target := range matches {
+		if _, err := os.Stat(filepath.Join(target, BackendFiles(backend).VerifyingKey)); err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(target, manifestFile)); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		release := filepath.Base(target)
+		if dryRun {
+			log.Printf("Dry run: would complete the interrupted migration of %s into %s\n", dir, target)
+			return nil
+		}
+		log.Printf("Completing the interrupted migration of %s into %s\n", dir, target)
+		return finishRelease(dir, backend, release)
+	}
+	return nil
+}
+
+// finishRelease writes the manifest of release and points dir at it.
+func finishRelease(dir string, backend string, release string) error {
+	target := filepath.Join(dir, releasesDir, release)
+	manifest, err := newManifest(Paths{Dir: target}, backend)
+	if err != nil {
+		return err
+	}
+	manifest.Release = release
+	if err := writeManifest(filepath.Join(target, manifestFile), manifest); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, currentFile(backend)), []byte(release+"\n")); err != nil {
+		return err
+	}
+	log.Printf("Migrated %s into %s, %d files\n", dir, target, len(manifest.Files))
+	return nil
+}
+
+// migratedFiles returns the files setup writes, the verifying key last.
+func migratedFiles(files Files) []string {
+	return []string{files.CacheKey, files.SolidityVerifier, files.SolcReport, files.Circuit, files.ProvingKey, files.VerifyingKey}
+}
+
+// copiedFiles returns the plonky2 circuit data setup reads, which stays in
+// the circuit directory for the next setup run.
+func copiedFiles() []string {
+	return []string{CommonCircuitDataFile, VerifierOnlyCircuitDataFile}
+}
+
+func hashFile(path string) (ManifestFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	return ManifestFile{Sha256: hex.EncodeToString(h.Sum(nil)), Size: size}, nil
+}
+
+func copyFile(from string, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// writeFileAtomic replaces path with data through a temporary file, so that
+// readers see either the old or the new content.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// isReadOnly reports whether err is the failure to write to a read-only
+// file system or a directory without write permission.
+func isReadOnly(err error) bool {
+	return errors.Is(err, syscall.EROFS) || errors.Is(err, os.ErrPermission)
+}
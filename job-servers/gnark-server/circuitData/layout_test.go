@@ -0,0 +1,225 @@
+package circuitData
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// listDir returns the names under dir, relative to it, sorted.
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		names = append(names, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// assertMigrated checks that the flat keys of dir were moved into the
+// release the pointer of dir names, with a manifest of their hashes, and
+// returns the release.
+func assertMigrated(t *testing.T, dir string) string {
+	t.Helper()
+	files := BackendFiles(BackendGroth16)
+	for _, name := range []string{files.VerifyingKey, files.ProvingKey, files.Circuit, files.CacheKey, files.Manifest} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s is still in the circuit directory: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, CommonCircuitDataFile)); err != nil {
+		t.Fatalf("the plonky2 circuit data was not kept for setup: %v", err)
+	}
+	releaseDir, err := ReleaseDir(dir, BackendGroth16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := filepath.Base(releaseDir)
+	if !strings.HasPrefix(release, legacyReleasePrefix) || releaseDir != filepath.Join(dir, releasesDir, release) {
+		t.Fatalf("ReleaseDir() = %s, want a legacy release", releaseDir)
+	}
+	manifest, err := readManifest(filepath.Join(releaseDir, manifestFile))
+	if err != nil || manifest == nil {
+		t.Fatalf("manifest of %s: %v, %v", release, manifest, err)
+	}
+	if manifest.Release != release || manifest.Backend != BackendGroth16 {
+		t.Fatalf("manifest names release %s of %s, want %s of %s", manifest.Release, manifest.Backend, release, BackendGroth16)
+	}
+	for _, name := range []string{files.VerifyingKey, files.ProvingKey, files.Circuit, CommonCircuitDataFile} {
+		if err := manifest.Verify(releaseDir, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return release
+}
+
+func TestMigrateLegacyLayout(t *testing.T) {
+	dir := writeGroth16Dir(t)
+	if err := WriteSetupManifest(Paths{Dir: dir}, BackendGroth16); err != nil {
+		t.Fatal(err)
+	}
+	releaseId, err := ReleaseId(dir, BackendGroth16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := listDir(t, dir)
+	if err := MigrateLegacyLayout(dir, BackendGroth16, true); err != nil {
+		t.Fatal(err)
+	}
+	if after := listDir(t, dir); strings.Join(after, " ") != strings.Join(before, " ") {
+		t.Fatalf("the dry run changed the directory from %v to %v", before, after)
+	}
+
+	if err := MigrateLegacyLayout(dir, BackendGroth16, false); err != nil {
+		t.Fatal(err)
+	}
+	if release := assertMigrated(t, dir); release != legacyReleasePrefix+releaseId {
+		t.Fatalf("migrated into %s, want %s%s", release, legacyReleasePrefix, releaseId)
+	}
+	if id, err := ReleaseId(dir, BackendGroth16); err != nil || id != releaseId {
+		t.Fatalf("ReleaseId() after the migration = %s, %v, want %s", id, err, releaseId)
+	}
+	if _, err := InitCircuitDataFromDir(dir, BackendGroth16, false); err != nil {
+		t.Fatalf("loading the migrated release: %v", err)
+	}
+
+	// An already migrated directory is left as it is.
+	migrated := listDir(t, dir)
+	if err := MigrateLegacyLayouts(dir, BackendGroth16, false); err != nil {
+		t.Fatal(err)
+	}
+	if again := listDir(t, dir); strings.Join(again, " ") != strings.Join(migrated, " ") {
+		t.Fatalf("migrating again changed the directory from %v to %v", migrated, again)
+	}
+}
+
+func TestMigrateLegacyLayouts(t *testing.T) {
+	dir := writeGroth16Dir(t)
+	circuit := filepath.Join(dir, "withdrawal")
+	if err := os.Mkdir(circuit, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeGroth16Files(t, circuit)
+	if err := MigrateLegacyLayouts(dir, BackendGroth16, false); err != nil {
+		t.Fatal(err)
+	}
+	assertMigrated(t, dir)
+	assertMigrated(t, circuit)
+}
+
+func TestMigrateMixedLayout(t *testing.T) {
+	dir := writeGroth16Dir(t)
+	if err := MigrateLegacyLayout(dir, BackendGroth16, false); err != nil {
+		t.Fatal(err)
+	}
+	first := assertMigrated(t, dir)
+
+	// Setup ran again in place: the new flat keys take precedence over the
+	// pointer until they are migrated too.
+	writeGroth16Files(t, dir)
+	if releaseDir, err := ReleaseDir(dir, BackendGroth16); err != nil || releaseDir != dir {
+		t.Fatalf("ReleaseDir() of a mixed layout = %s, %v, want the flat keys", releaseDir, err)
+	}
+	if _, err := InitCircuitDataFromDir(dir, BackendGroth16, false); err != nil {
+		t.Fatalf("loading the flat keys of a mixed layout: %v", err)
+	}
+	if err := MigrateLegacyLayout(dir, BackendGroth16, false); err != nil {
+		t.Fatal(err)
+	}
+	second := assertMigrated(t, dir)
+	if second == first {
+		t.Fatalf("new keys were migrated into the previous release %s", first)
+	}
+	if _, err := os.Stat(filepath.Join(dir, releasesDir, first, BackendFiles(BackendGroth16).VerifyingKey)); err != nil {
+		t.Fatalf("the previous release was not kept: %v", err)
+	}
+}
+
+func TestMigrateInterruptedLayout(t *testing.T) {
+	dir := writeGroth16Dir(t)
+	if err := MigrateLegacyLayout(dir, BackendGroth16, false); err != nil {
+		t.Fatal(err)
+	}
+	release := assertMigrated(t, dir)
+	// Interrupted after the files were moved, before the manifest and
+	// pointer were written.
+	os.Remove(filepath.Join(dir, releasesDir, release, manifestFile))
+	os.Remove(filepath.Join(dir, currentFile(BackendGroth16)))
+
+	if err := MigrateLegacyLayout(dir, BackendGroth16, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, currentFile(BackendGroth16))); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("the dry run completed the migration: %v", err)
+	}
+	if err := MigrateLegacyLayout(dir, BackendGroth16, false); err != nil {
+		t.Fatal(err)
+	}
+	if completed := assertMigrated(t, dir); completed != release {
+		t.Fatalf("completed into %s, want %s", completed, release)
+	}
+}
+
+func TestMigrateLeavesMismatchedKeys(t *testing.T) {
+	dir := writeGroth16Dir(t)
+	if err := WriteSetupManifest(Paths{Dir: dir}, BackendGroth16); err != nil {
+		t.Fatal(err)
+	}
+	pk := filepath.Join(dir, BackendFiles(BackendGroth16).ProvingKey)
+	raw, err := os.ReadFile(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 1
+	if err := os.WriteFile(pk, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := listDir(t, dir)
+	if err := MigrateLegacyLayout(dir, BackendGroth16, false); err != nil {
+		t.Fatal(err)
+	}
+	if after := listDir(t, dir); strings.Join(after, " ") != strings.Join(before, " ") {
+		t.Fatalf("keys that do not match the manifest were migrated: %v", after)
+	}
+}
+
+func TestMigrateReadOnlyLayout(t *testing.T) {
+	if !isReadOnly(&fs.PathError{Op: "mkdir", Path: "releases", Err: syscall.EROFS}) || !isReadOnly(fs.ErrPermission) || isReadOnly(fs.ErrNotExist) {
+		t.Fatal("isReadOnly does not recognize read-only file systems")
+	}
+
+	dir := writeGroth16Dir(t)
+	if err := os.Chmod(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+	if f, err := os.Create(filepath.Join(dir, "probe")); err == nil {
+		f.Close()
+		t.Skip("the directory stays writable, as for root")
+	}
+	before := listDir(t, dir)
+	if err := MigrateLegacyLayout(dir, BackendGroth16, false); err != nil {
+		t.Fatalf("MigrateLegacyLayout() of a read-only directory = %v, want the flat layout kept", err)
+	}
+	if after := listDir(t, dir); strings.Join(after, " ") != strings.Join(before, " ") {
+		t.Fatalf("the read-only directory changed from %v to %v", before, after)
+	}
+	if _, err := InitCircuitDataFromDir(dir, BackendGroth16, false); err != nil {
+		t.Fatalf("loading the flat layout in place: %v", err)
+	}
+}
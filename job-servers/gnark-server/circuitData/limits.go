@@ -0,0 +1,102 @@
+package circuitData
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/qope/gnark-plonky2-verifier/types"
+)
+
+// ProofLimits bounds the arrays of the plonky2 proofs a circuit accepts, so
+// that oversized submissions can be refused before they are decoded. The
+// limits are upper bounds; the exact structure is checked after decoding.
+type ProofLimits struct {
+	// PublicInputs bounds public_inputs.
+	PublicInputs int
+	// Arrays bounds the arrays of the proof by the name of their field, such
+	// as wires or query_round_proofs.
+	Arrays map[string]int
+	// MaxArray bounds every other array, including the arrays nested in
+	// arrays, such as the limbs of extension elements.
+	MaxArray int
+}
+
+// NewProofLimits derives the proof limits of a circuit from its common
+// circuit data: the openings and Merkle caps of the plonky2 proof have the
+// sizes the circuit configuration gives, and the FRI proof has one query
+// round per num_query_rounds and one step per reduction.
+func NewProofLimits(common types.CommonCircuitDataRaw) ProofLimits {
+	config := common.Config
+	fri := common.FriParams
+	capSize := 1 << fri.Config.CapHeight
+	totalArity, maxArity := uint64(0), uint64(0)
+	for _, bits := range fri.ReductionArityBits {
+		totalArity += bits
+		if bits > maxArity {
+			maxArity = bits
+		}
+	}
+	finalPolyBits := uint64(0)
+	if fri.DegreeBits > totalArity {
+		finalPolyBits = fri.DegreeBits - totalArity
+	}
+	challenges := int(config.NumChallenges)
+	limits := ProofLimits{
+		PublicInputs: int(common.NumPublicInputs),
+		Arrays: map[string]int{
+			"wires_cap":                     capSize,
+			"plonk_zs_partial_products_cap": capSize,
+			"quotient_polys_cap":            capSize,
+			"constants":                     int(common.NumConstants),
+			"plonk_sigmas":                  int(config.NumRoutedWires),
+			"wires":                         int(config.NumWires),
+			"plonk_zs":                      challenges,
+			"plonk_zs_next":                 challenges,
+			"partial_products":              challenges * int(common.NumPartialProducts),
+			"quotient_polys":                challenges * int(common.QuotientDegreeFactor),
+			"commit_phase_merkle_caps":      len(fri.ReductionArityBits),
+			"query_round_proofs":            int(fri.Config.NumQueryRounds),
+			// One Merkle proof per committed polynomial batch: constants
+			// and sigmas, wires, Zs and partial products, quotient.
+			"evals_proofs": 4,
+			"siblings":     int(fri.DegreeBits + fri.Config.RateBits),
+			"steps":        len(fri.ReductionArityBits),
+			"evals":        1 << maxArity,
+			"coeffs":       1 << finalPolyBits,
+		},
+	}
+	// Nested arrays hold Merkle cap hashes, extension limbs and the leaves
+	// of the initial trees, one element per polynomial of a batch.
+	limits.MaxArray = 2
+	for _, n := range []int{
+		capSize,
+		int(common.NumConstants + config.NumRoutedWires),
+		int(config.NumWires),
+		challenges * (1 + int(common.NumPartialProducts)),
+		challenges * int(common.QuotientDegreeFactor),
+	} {
+		limits.MaxArray = max(limits.MaxArray, n)
+	}
+	for _, n := range limits.Arrays {
+		limits.MaxArray = max(limits.MaxArray, n)
+	}
+	return limits
+}
+
+// readCommonCircuitData reads the common circuit data at path, or returns
+// nil when the file does not exist.
+func readCommonCircuitData(path string) (*types.CommonCircuitDataRaw, error) {
+	raw, err := ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var common types.CommonCircuitDataRaw
+	if err := json.Unmarshal(raw, &common); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &common, nil
+}
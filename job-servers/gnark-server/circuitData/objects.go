@@ -0,0 +1,80 @@
+package circuitData
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"gnark-server/objectstore"
+)
+
+// fetchObjects returns p with the object URLs of its overrides replaced by
+// copies downloaded to ObjectCacheDir, or p as is without one.
+func (p Paths) fetchObjects(ctx context.Context) (Paths, error) {
+	if p.ObjectCacheDir == "" {
+		return p, nil
+	}
+	for _, path := range p.overrides() {
+		if !objectstore.IsURL(*path) {
+			continue
+		}
+		local, err := objectstore.Fetch(ctx, *path, p.ObjectCacheDir)
+		if err != nil {
+			return p, fileError(*path, err)
+		}
+		*path = local
+	}
+	return p, nil
+}
+
+// statFile returns the size of the file or object at path.
+func statFile(path string) (int64, error) {
+	if objectstore.IsURL(path) {
+		obj, err := objectstore.Stat(context.Background(), path)
+		if err != nil {
+			return 0, fileError(path, err)
+		}
+		return obj.Size, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fileError(path, err)
+	}
+	return info.Size(), nil
+}
+
+// openFile opens the file or object at path and returns its size.
+func openFile(path string) (io.ReadCloser, int64, error) {
+	if objectstore.IsURL(path) {
+		r, obj, err := objectstore.Open(context.Background(), path)
+		if err != nil {
+			return nil, 0, fileError(path, err)
+		}
+		return r, obj.Size, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fileError(path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("%s: %w", displayPath(path), err)
+	}
+	return f, info.Size(), nil
+}
+
+// ReadFile reads the file or object at path. Like os.ReadFile, it returns
+// an error wrapping os.ErrNotExist when there is none.
+func ReadFile(path string) ([]byte, error) {
+	if !objectstore.IsURL(path) {
+		return os.ReadFile(path)
+	}
+	r, _, err := objectstore.Open(context.Background(), path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,146 @@
+package circuitData
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gnark-server/objectstore"
+)
+
+// The plonky2 files setup compiles the circuit from. The common circuit
+// data and verifier only circuit data are also read by the server.
+const (
+	CommonCircuitDataFile       = "common_circuit_data.json"
+	VerifierOnlyCircuitDataFile = "verifier_only_circuit_data.json"
+	ProofWithPublicInputsFile   = "proof_with_public_inputs.json"
+)
+
+// Paths locates the files of the circuits: a data directory, and files of a
+// single circuit kept outside of it, such as keys mounted from a read-only
+// volume.
+type Paths struct {
+	// Dir is the directory of the circuit, or the data directory holding a
+	// directory per circuit. Empty means DefaultDir.
+	Dir string
+	// Each override replaces the file of its kind in Dir, in either layout.
+	// With any override set, Dir holds a single circuit. An override can be
+	// the s3:// or gs:// URL of an object, see InitCircuitDataFromPaths.
+	ProvingKey              string
+	VerifyingKey            string
+	ConstraintSystem        string
+	CommonCircuitData       string
+	VerifierOnlyCircuitData string
+	ProofWithPublicInputs   string
+	// ObjectCacheDir is where objects are downloaded to before they are
+	// read. Empty means objects are streamed as they are read.
+	ObjectCacheDir string
+}
+
+// PathsFromEnv returns the paths set by CIRCUIT_DATA_DIR, the
+// CIRCUIT_*_PATH overrides and CIRCUIT_OBJECT_CACHE_DIR.
+func PathsFromEnv() Paths {
+	return Paths{
+		Dir:                     os.Getenv("CIRCUIT_DATA_DIR"),
+		ProvingKey:              os.Getenv("CIRCUIT_PROVING_KEY_PATH"),
+		VerifyingKey:            os.Getenv("CIRCUIT_VERIFYING_KEY_PATH"),
+		ConstraintSystem:        os.Getenv("CIRCUIT_CONSTRAINT_SYSTEM_PATH"),
+		CommonCircuitData:       os.Getenv("CIRCUIT_COMMON_DATA_PATH"),
+		VerifierOnlyCircuitData: os.Getenv("CIRCUIT_VERIFIER_ONLY_DATA_PATH"),
+		ProofWithPublicInputs:   os.Getenv("CIRCUIT_PROOF_WITH_PUBLIC_INPUTS_PATH"),
+		ObjectCacheDir:          os.Getenv("CIRCUIT_OBJECT_CACHE_DIR"),
+	}
+}
+
+// DataDir returns Dir, or DefaultDir when it is empty.
+func (p Paths) DataDir() string {
+	if p.Dir == "" {
+		return DefaultDir
+	}
+	return p.Dir
+}
+
+// HasOverrides reports whether a file is read from outside of Dir.
+func (p Paths) HasOverrides() bool {
+	return p.ProvingKey != "" || p.VerifyingKey != "" || p.ConstraintSystem != "" ||
+		p.CommonCircuitData != "" || p.VerifierOnlyCircuitData != "" || p.ProofWithPublicInputs != ""
+}
+
+// In returns p for the circuit directory dir, keeping the overrides.
+func (p Paths) In(dir string) Paths {
+	p.Dir = dir
+	return p
+}
+
+// Path returns the path of the file name of files: its override, or name
+// in Dir.
+func (p Paths) Path(files Files, name string) string {
+	var override string
+	switch name {
+	case files.ProvingKey:
+		override = p.ProvingKey
+	case files.VerifyingKey:
+		override = p.VerifyingKey
+	case files.Circuit:
+		override = p.ConstraintSystem
+	case CommonCircuitDataFile:
+		override = p.CommonCircuitData
+	case VerifierOnlyCircuitDataFile:
+		override = p.VerifierOnlyCircuitData
+	case ProofWithPublicInputsFile:
+		override = p.ProofWithPublicInputs
+	}
+	if override != "" {
+		return override
+	}
+	return filepath.Join(p.DataDir(), name)
+}
+
+// CheckInputs returns an error naming the first plonky2 file setup compiles
+// the circuit from that does not exist, or the first override that is an
+// object URL: setup reads and writes local files only.
+func (p Paths) CheckInputs() error {
+	for _, path := range p.overrides() {
+		if objectstore.IsURL(*path) {
+			return fmt.Errorf("%s is an object URL, setup only reads and writes local files", *path)
+		}
+	}
+	for _, name := range []string{CommonCircuitDataFile, VerifierOnlyCircuitDataFile, ProofWithPublicInputsFile} {
+		path := p.Path(Files{}, name)
+		if _, err := os.Stat(path); err != nil {
+			return fileError(path, err)
+		}
+	}
+	return nil
+}
+
+func (p *Paths) overrides() []*string {
+	return []*string{&p.ProvingKey, &p.VerifyingKey, &p.ConstraintSystem, &p.CommonCircuitData, &p.VerifierOnlyCircuitData, &p.ProofWithPublicInputs}
+}
+
+// ErrMissingFile is wrapped by the errors of files of the circuit data that
+// do not exist.
+var ErrMissingFile = errors.New("circuit data file does not exist")
+
+// fileError names the absolute path of a file, or the URL of an object,
+// that does not exist, which os errors only give as it was passed in. Other
+// errors are returned as is.
+func fileError(path string, err error) error {
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return fmt.Errorf("%w: %s", ErrMissingFile, displayPath(path))
+}
+
+// displayPath returns the absolute path of a local file for error messages,
+// or the URL of an object.
+func displayPath(path string) string {
+	if objectstore.IsURL(path) {
+		return path
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,99 @@
+package circuitData
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+)
+
+// Registry holds the loaded circuits by name. It is not modified once it is
+// in use: With returns a new one, so readers can keep the one they got while
+// a circuit is reloaded.
+type Registry map[string]CircuitData
+
+// ErrUnknownCircuit is returned by InitCircuit when the data directory has
+// no circuit of the name.
+var ErrUnknownCircuit = errors.New("unknown circuit")
+
+// Get returns the circuit called name. An empty name selects the only
+// circuit, if there is only one.
+func (r Registry) Get(name string) (CircuitData, bool) {
+	if name == "" {
+		return r.Sole()
+	}
+	data, ok := r[name]
+	return data, ok
+}
+
+// Sole returns the only circuit, if there is only one.
+func (r Registry) Sole() (CircuitData, bool) {
+	if len(r) != 1 {
+		return CircuitData{}, false
+	}
+	for _, data := range r {
+		return data, true
+	}
+	return CircuitData{}, false
+}
+
+// Names returns the names of the circuits in order.
+func (r Registry) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sorted returns the circuits ordered by name.
+func (r Registry) Sorted() []CircuitData {
+	sorted := make([]CircuitData, 0, len(r))
+	for _, name := range r.Names() {
+		sorted = append(sorted, r[name])
+	}
+	return sorted
+}
+
+// With returns a copy of r holding data as the circuit of its name, in
+// place of the one r holds, if any.
+func (r Registry) With(data CircuitData) Registry {
+	next := make(Registry, len(r)+1)
+	for name, d := range r {
+		next[name] = d
+	}
+	next[data.Name] = data
+	return next
+}
+
+// InitCircuit loads the circuit called name from the data directory of
+// paths, laid out as for InitCircuitData: the directory <name>/ under it, or
+// the data directory itself when it holds a single circuit, which is then
+// named DefaultCircuit. A name the data directory has no circuit of returns
+// an error wrapping ErrUnknownCircuit.
+func InitCircuit(paths Paths, name string, backend string, fastLoad bool) (CircuitData, error) {
+	dir := paths.DataDir()
+	if paths.HasOverrides() || isCircuitDir(dir, backend) {
+		if name != DefaultCircuit {
+			return CircuitData{}, fmt.Errorf("%w %q: %s holds a single circuit, %s", ErrUnknownCircuit, name, dir, DefaultCircuit)
+		}
+		circuits, err := InitCircuitData(paths, backend, fastLoad)
+		return circuits[DefaultCircuit], err
+	}
+	circuitDir := filepath.Join(dir, name)
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." || !isCircuitDir(circuitDir, backend) {
+		return CircuitData{}, fmt.Errorf("%w %q: no %s in %s", ErrUnknownCircuit, name, BackendFiles(backend).VerifyingKey, circuitDir)
+	}
+	return initNamedCircuit(circuitDir, name, backend, fastLoad)
+}
+
+// initNamedCircuit loads the circuit in dir as the circuit name of a data
+// directory holding several.
+func initNamedCircuit(dir string, name string, backend string, fastLoad bool) (CircuitData, error) {
+	data, err := InitCircuitDataFromDir(dir, backend, fastLoad)
+	data.Name = name
+	data.logger = log.New(log.Writer(), "circuit="+name+" release="+data.ReleaseId+" ", log.Flags()|log.Lmsgprefix)
+	return data, err
+}
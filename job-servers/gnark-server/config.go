@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"gnark-server/handlers"
+	"gnark-server/middleware"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// defaultRateLimitRPM is the number of proof requests a client may make
+	// per minute when RATE_LIMIT_RPM is not set.
+	defaultRateLimitRPM = 60
+	// defaultRateLimitBurst is the number of proof requests a client may make
+	// at once when START_PROOF_RATE_BURST is not set.
+	defaultRateLimitBurst = 10
+)
+
+// lookupEnv returns the value of the first of names that is set, along with
+// its name. The first name is the documented one; the others are aliases.
+func lookupEnv(names ...string) (string, string) {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v, name
+		}
+	}
+	return "", names[0]
+}
+
+// secondsFromEnv parses the first of names that is set as a positive number
+// of seconds. It returns zero if none is set.
+func secondsFromEnv(names ...string) (time.Duration, error) {
+	v, name := lookupEnv(names...)
+	if v == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// resultTTLsFromEnv reads how long the records of completed and failed jobs
+// are kept, from JOB_RESULT_TTL_SECONDS and FAILED_JOB_TTL_SECONDS. The names
+// read by older versions, RESULT_TTL_SECONDS and FAILED_RESULT_TTL_SECONDS,
+// are accepted as aliases. Zero leaves the defaults of handlers.State.
+func resultTTLsFromEnv() (resultTTL time.Duration, failedResultTTL time.Duration, err error) {
+	if resultTTL, err = secondsFromEnv("JOB_RESULT_TTL_SECONDS", "RESULT_TTL_SECONDS"); err != nil {
+		return 0, 0, err
+	}
+	if failedResultTTL, err = secondsFromEnv("FAILED_JOB_TTL_SECONDS", "FAILED_RESULT_TTL_SECONDS"); err != nil {
+		return 0, 0, err
+	}
+	return resultTTL, failedResultTTL, nil
+}
+
+// maxJobAttemptsFromEnv reads the number of runs after which a job is moved
+// to the dead-letter queue from MAX_JOB_ATTEMPTS, or else from MAX_RETRIES,
+// the number of runs after the first one. MAX_RETRIES=0 means that a job is
+// never run again.
+func maxJobAttemptsFromEnv() (int, error) {
+	if v := os.Getenv("MAX_JOB_ATTEMPTS"); v != "" {
+		attempts, err := strconv.Atoi(v)
+		if err != nil || attempts < 1 {
+			return 0, fmt.Errorf("MAX_JOB_ATTEMPTS must be a positive integer")
+		}
+		return attempts, nil
+	}
+	if v := os.Getenv("MAX_RETRIES"); v != "" {
+		retries, err := strconv.Atoi(v)
+		if err != nil || retries < 0 {
+			return 0, fmt.Errorf("MAX_RETRIES must be a non-negative integer")
+		}
+		return retries + 1, nil
+	}
+	return handlers.DefaultMaxJobAttempts, nil
+}
+
+// trustedProxyDepthFromEnv reads the number of reverse proxies in front of
+// the server that append to X-Forwarded-For from TRUSTED_PROXY_DEPTH.
+func trustedProxyDepthFromEnv() (int, error) {
+	v := os.Getenv("TRUSTED_PROXY_DEPTH")
+	if v == "" {
+		return 0, nil
+	}
+	depth, err := strconv.Atoi(v)
+	if err != nil || depth < 0 {
+		return 0, fmt.Errorf("TRUSTED_PROXY_DEPTH must be a non-negative integer")
+	}
+	return depth, nil
+}
+
+// rateLimiterFromEnv returns the rate limiter of the proof endpoints, which
+// lets each client make RATE_LIMIT_RPM requests per minute (default 60),
+// with bursts of START_PROOF_RATE_BURST. START_PROOF_RATE_LIMIT, the name read
+// by older versions, is accepted as an alias. RATE_LIMIT_RPM=0 disables the
+// limit, in which case it returns nil.
+func rateLimiterFromEnv(rdb redis.UniversalClient) (*middleware.RateLimiter, error) {
+	perMinute := float64(defaultRateLimitRPM)
+	if v, name := lookupEnv("RATE_LIMIT_RPM", "START_PROOF_RATE_LIMIT"); v != "" {
+		var err error
+		perMinute, err = strconv.ParseFloat(v, 64)
+		if err != nil || perMinute < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative number", name)
+		}
+	}
+	if perMinute == 0 {
+		return nil, nil
+	}
+	burst := defaultRateLimitBurst
+	if v := os.Getenv("START_PROOF_RATE_BURST"); v != "" {
+		var err error
+		burst, err = strconv.Atoi(v)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("START_PROOF_RATE_BURST must be a positive integer")
+		}
+	}
+	depth, err := trustedProxyDepthFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &middleware.RateLimiter{RedisClient: rdb, Rate: perMinute / 60, Burst: burst, TrustedProxyDepth: depth}, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResultTTLsFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantResult time.Duration
+		wantFailed time.Duration
+		wantErr    bool
+	}{
+		{"unset", nil, 0, 0, false},
+		{"requested names", map[string]string{"JOB_RESULT_TTL_SECONDS": "7200", "FAILED_JOB_TTL_SECONDS": "600"}, 2 * time.Hour, 10 * time.Minute, false},
+		{"aliases", map[string]string{"RESULT_TTL_SECONDS": "7200", "FAILED_RESULT_TTL_SECONDS": "600"}, 2 * time.Hour, 10 * time.Minute, false},
+		{"requested names win", map[string]string{"JOB_RESULT_TTL_SECONDS": "60", "RESULT_TTL_SECONDS": "7200", "FAILED_JOB_TTL_SECONDS": "30", "FAILED_RESULT_TTL_SECONDS": "600"}, time.Minute, 30 * time.Second, false},
+		{"success only", map[string]string{"JOB_RESULT_TTL_SECONDS": "7200"}, 2 * time.Hour, 0, false},
+		{"zero", map[string]string{"JOB_RESULT_TTL_SECONDS": "0"}, 0, 0, true},
+		{"not a number", map[string]string{"FAILED_JOB_TTL_SECONDS": "5m"}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"JOB_RESULT_TTL_SECONDS", "RESULT_TTL_SECONDS", "FAILED_JOB_TTL_SECONDS", "FAILED_RESULT_TTL_SECONDS"} {
+				t.Setenv(name, tt.env[name])
+			}
+			resultTTL, failedResultTTL, err := resultTTLsFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resultTTLsFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if resultTTL != tt.wantResult || failedResultTTL != tt.wantFailed {
+				t.Fatalf("resultTTLsFromEnv() = %v, %v, want %v, %v", resultTTL, failedResultTTL, tt.wantResult, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestMaxJobAttemptsFromEnv(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts string
+		maxRetries  string
+		want        int
+		wantErr     bool
+	}{
+		{"unset", "", "", 3, false},
+		{"attempts", "5", "", 5, false},
+		{"one attempt", "1", "", 1, false},
+		{"zero attempts", "0", "", 0, true},
+		{"negative attempts", "-1", "", 0, true},
+		{"retries", "", "2", 3, false},
+		{"zero retries", "", "0", 1, false},
+		{"negative retries", "", "-1", 0, true},
+		{"invalid retries", "", "many", 0, true},
+		{"attempts win", "5", "0", 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAX_JOB_ATTEMPTS", tt.maxAttempts)
+			t.Setenv("MAX_RETRIES", tt.maxRetries)
+			got, err := maxJobAttemptsFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("maxJobAttemptsFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("maxJobAttemptsFromEnv() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantRPM   float64
+		wantBurst int
+		wantDepth int
+		wantErr   bool
+	}{
+		{"unset", nil, 60, 10, 0, false},
+		{"rpm", map[string]string{"RATE_LIMIT_RPM": "120"}, 120, 10, 0, false},
+		{"alias", map[string]string{"START_PROOF_RATE_LIMIT": "30"}, 30, 10, 0, false},
+		{"rpm wins", map[string]string{"RATE_LIMIT_RPM": "120", "START_PROOF_RATE_LIMIT": "30"}, 120, 10, 0, false},
+		{"disabled", map[string]string{"RATE_LIMIT_RPM": "0"}, 0, 0, 0, false},
+		{"burst and depth", map[string]string{"START_PROOF_RATE_BURST": "5", "TRUSTED_PROXY_DEPTH": "2"}, 60, 5, 2, false},
+		{"negative rpm", map[string]string{"RATE_LIMIT_RPM": "-1"}, 0, 0, 0, true},
+		{"invalid burst", map[string]string{"START_PROOF_RATE_BURST": "0"}, 0, 0, 0, true},
+		{"invalid depth", map[string]string{"TRUSTED_PROXY_DEPTH": "-1"}, 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"RATE_LIMIT_RPM", "START_PROOF_RATE_LIMIT", "START_PROOF_RATE_BURST", "TRUSTED_PROXY_DEPTH"} {
+				t.Setenv(name, tt.env[name])
+			}
+			limiter, err := rateLimiterFromEnv(nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rateLimiterFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantRPM == 0 {
+				if limiter != nil {
+					t.Fatalf("rateLimiterFromEnv() = %+v, want no limiter", limiter)
+				}
+				return
+			}
+			if limiter == nil {
+				t.Fatal("rateLimiterFromEnv() returned no limiter")
+			}
+			if limiter.Rate*60 != tt.wantRPM || limiter.Burst != tt.wantBurst || limiter.TrustedProxyDepth != tt.wantDepth {
+				t.Fatalf("rateLimiterFromEnv() = %v/min, burst %d, depth %d, want %v/min, burst %d, depth %d",
+					limiter.Rate*60, limiter.Burst, limiter.TrustedProxyDepth, tt.wantRPM, tt.wantBurst, tt.wantDepth)
+			}
+		})
+	}
+}
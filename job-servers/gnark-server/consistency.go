@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"gnark-server/handlers"
+	"gnark-server/mirror"
+	"gnark-server/secrets"
+
+	"github.com/joho/godotenv"
+)
+
+// exitInconsistent is the exit code of check-consistency when issues are
+// left unrepaired.
+const exitInconsistent = 3
+
+// runCheckConsistency implements `gnark-server check-consistency`, which
+// reports drift between the job records, queues, counters and claims in
+// REDIS_URL and, with --repair, fixes the issues that are safe to fix. The
+// return value is the exit code.
+func runCheckConsistency(args []string) int {
+	flags := flag.NewFlagSet("check-consistency", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "fix dangling queue entries, counter drift and dangling idempotency and in-flight claims; the other issues are only reported")
+	batchSize := flags.Int64("batch-size", handlers.DefaultConsistencyBatchSize, "keys or entries read per Redis call")
+	rate := flags.Float64("rate", 20, "maximum Redis calls per second")
+	grace := flags.Duration("grace", 5*time.Second, "how long an issue must persist before it is reported")
+	jsonOutput := flags.Bool("json", false, "write the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 0 || *batchSize < 1 || *rate <= 0 || *grace < 0 {
+		fmt.Fprintln(os.Stderr, "usage: gnark-server check-consistency [--repair] [--batch-size <n>] [--rate <calls per second>] [--grace <duration>] [--json]")
+		return exitUsage
+	}
+	godotenv.Load()
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	s, ok := snapshotState(logger)
+	if !ok {
+		return 1
+	}
+	ctx := context.Background()
+	opts := handlers.ConsistencyOptions{
+		Repair:        *repair,
+		BatchSize:     *batchSize,
+		BatchInterval: time.Duration(float64(time.Second) / *rate),
+		Grace:         *grace,
+	}
+
+	mirrorURL, _, err := secrets.Lookup("MIRROR_DATABASE_URL")
+	if err != nil {
+		logger.Println(err)
+		return 1
+	}
+	if mirrorURL != "" {
+		m, err := mirror.Open(ctx, mirrorURL)
+		if err != nil {
+			logger.Println("Mirror database error:", err)
+			return 1
+		}
+		defer m.Close(ctx)
+		opts.Mirrored = m.Mirrored
+	} else {
+		logger.Println("MIRROR_DATABASE_URL is not set, expired jobs are not checked against the mirror")
+	}
+
+	report, err := s.CheckConsistency(ctx, opts)
+	if err != nil {
+		logger.Println("Consistency check failed:", err)
+		return 1
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(report)
+	} else {
+		for _, issue := range report.Issues {
+			status := "reported"
+			switch {
+			case issue.Repaired:
+				status = "repaired"
+			case issue.Repairable:
+				status = "repairable"
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", issue.Class, status, issue.Key, issue.JobId, issue.Detail)
+		}
+		scopes := make([]string, 0, len(report.Checked))
+		for scope := range report.Checked {
+			scopes = append(scopes, scope)
+		}
+		sort.Strings(scopes)
+		for _, scope := range scopes {
+			logger.Printf("Checked %d entries of %s\n", report.Checked[scope], scope)
+		}
+	}
+	unresolved := report.Unresolved()
+	logger.Printf("%d issues found, %d unresolved\n", len(report.Issues), unresolved)
+	if unresolved > 0 {
+		return exitInconsistent
+	}
+	return 0
+}
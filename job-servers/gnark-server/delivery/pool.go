@@ -0,0 +1,310 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 256
+
+	overflowKeyPrefix = "gnark_delivery_overflow:"
+	refillInterval    = time.Second
+	redisTimeout      = 5 * time.Second
+)
+
+var ErrUnknownSink = errors.New("unknown delivery sink")
+
+// Task is one delivery to a sink. Tasks that do not fit in the in-memory
+// queue are stored as JSON in Redis, so the payload must carry everything
+// the handler needs.
+type Task struct {
+	JobId   string          `json:"jobId"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Handler delivers a task. It should give up when ctx is done; the task is
+// then kept in Redis for the next instance.
+type Handler func(ctx context.Context, task Task) error
+
+// SinkConfig bounds the deliveries of a sink: at most Workers run at once and
+// at most QueueSize wait in memory. Zero values use the defaults.
+type SinkConfig struct {
+	Workers   int
+	QueueSize int
+}
+
+// Stats are the counters of a sink since the pool started.
+type Stats struct {
+	Queued     int
+	InFlight   int64
+	Delivered  int64
+	Failed     int64
+	Overflowed int64
+}
+
+// Pool runs deliveries on a bounded number of workers per sink. A task that
+// does not fit in the queue of its sink, or is enqueued during shutdown, is
+// appended to a Redis list that the sink drains as the queue frees up, so a
+// burst of deliveries neither spawns unbounded goroutines nor is lost on a
+// restart.
+type Pool struct {
+	rdb   redis.UniversalClient
+	sinks map[string]*sink
+
+	// mu guards stopped so that no task enters a queue after Close started
+	// draining it.
+	mu      sync.RWMutex
+	stopped bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+
+	// ctx is passed to handlers and cancelled when Close gives up.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type sink struct {
+	name    string
+	handler Handler
+	queue   chan Task
+
+	inFlight   atomic.Int64
+	delivered  atomic.Int64
+	failed     atomic.Int64
+	overflowed atomic.Int64
+}
+
+// New returns a pool without sinks. Register the sinks before enqueueing.
+func New(rdb redis.UniversalClient) *Pool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		rdb:    rdb,
+		sinks:  make(map[string]*sink),
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+func overflowKey(name string) string {
+	return overflowKeyPrefix + name
+}
+
+// Register adds a sink and starts its workers. Tasks left in its overflow
+// list by a previous instance are picked up once the workers have room.
+func (p *Pool) Register(name string, config SinkConfig, handler Handler) {
+	if config.Workers <= 0 {
+		config.Workers = DefaultWorkers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultQueueSize
+	}
+	s := &sink{name: name, handler: handler, queue: make(chan Task, config.QueueSize)}
+	p.sinks[name] = s
+	p.wg.Add(config.Workers + 1)
+	for i := 0; i < config.Workers; i++ {
+		go p.work(s)
+	}
+	go p.refill(s)
+}
+
+// Enqueue schedules a delivery without blocking.
+func (p *Pool) Enqueue(name string, task Task) error {
+	s, ok := p.sinks[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSink, name)
+	}
+	p.mu.RLock()
+	if !p.stopped {
+		select {
+		case s.queue <- task:
+			p.mu.RUnlock()
+			return nil
+		default:
+		}
+	}
+	p.mu.RUnlock()
+	return p.overflow(s, task)
+}
+
+// overflow appends task to the Redis list of its sink.
+func (p *Pool) overflow(s *sink, task Task) error {
+	raw, err := json.Marshal(task)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		err = p.rdb.RPush(ctx, overflowKey(s.name), raw).Err()
+	}
+	if err != nil {
+		s.failed.Add(1)
+		log.Printf("Failed to queue %s delivery for job %s: %v\n", s.name, task.JobId, err)
+		return err
+	}
+	s.overflowed.Add(1)
+	return nil
+}
+
+func (p *Pool) work(s *sink) {
+	defer p.wg.Done()
+	for {
+		select {
+		case task := <-s.queue:
+			p.run(s, task)
+		case <-p.stop:
+			for {
+				select {
+				case task := <-s.queue:
+					p.run(s, task)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Pool) run(s *sink, task Task) {
+	if p.ctx.Err() != nil {
+		// Close gave up: keep the task for the next instance.
+		p.overflow(s, task)
+		return
+	}
+	s.inFlight.Add(1)
+	err := s.handler(p.ctx, task)
+	s.inFlight.Add(-1)
+	switch {
+	case err == nil:
+		s.delivered.Add(1)
+	case p.ctx.Err() != nil:
+		p.overflow(s, task)
+	default:
+		s.failed.Add(1)
+		log.Printf("%s delivery for job %s failed: %v\n", s.name, task.JobId, err)
+	}
+}
+
+// refill moves tasks from the overflow list of s into its queue while the
+// queue has room.
+func (p *Pool) refill(s *sink) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(refillInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		for len(s.queue) < cap(s.queue) {
+			if !p.refillOne(s) {
+				break
+			}
+		}
+	}
+}
+
+// refillOne moves the oldest overflowed task of s into its queue and reports
+// whether there may be more.
+func (p *Pool) refillOne(s *sink) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	raw, err := p.rdb.LPop(ctx, overflowKey(s.name)).Bytes()
+	if err == redis.Nil {
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to read overflowed %s deliveries: %v\n", s.name, err)
+		return false
+	}
+	var task Task
+	if err := json.Unmarshal(raw, &task); err != nil {
+		s.failed.Add(1)
+		log.Printf("Dropping malformed %s delivery: %v\n", s.name, err)
+		return true
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.stopped {
+		select {
+		case s.queue <- task:
+			return true
+		default:
+		}
+	}
+	// Lost the room to Enqueue or shutdown started: put the task back first
+	// in line.
+	if err := p.rdb.LPush(ctx, overflowKey(s.name), raw).Err(); err != nil {
+		s.failed.Add(1)
+		log.Printf("Failed to requeue %s delivery for job %s: %v\n", s.name, task.JobId, err)
+	}
+	return false
+}
+
+// Sinks returns the names of the registered sinks, sorted.
+func (p *Pool) Sinks() []string {
+	names := make([]string, 0, len(p.sinks))
+	for name := range p.sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stats returns the counters of the named sink.
+func (p *Pool) Stats(name string) (Stats, bool) {
+	s, ok := p.sinks[name]
+	if !ok {
+		return Stats{}, false
+	}
+	return Stats{
+		Queued:     len(s.queue),
+		InFlight:   s.inFlight.Load(),
+		Delivered:  s.delivered.Load(),
+		Failed:     s.failed.Load(),
+		Overflowed: s.overflowed.Load(),
+	}, true
+}
+
+// Backlog returns the number of tasks of the named sink waiting in Redis,
+// across all instances.
+func (p *Pool) Backlog(ctx context.Context, name string) (int64, error) {
+	return p.rdb.LLen(ctx, overflowKey(name)).Result()
+}
+
+// Close stops taking tasks into memory and waits for the queued and running
+// deliveries to finish. Tasks enqueued from now on go to Redis. When ctx is
+// done first, running handlers are cancelled and every task that did not
+// complete is written to Redis for the next instance.
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.stop)
+	}
+	p.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
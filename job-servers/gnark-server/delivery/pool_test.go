@@ -0,0 +1,208 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/goleak"
+)
+
+// newTestRedis returns a client of an in-memory Redis, connected so that its
+// background goroutines are running before goroutines are counted.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return mr, rdb
+}
+
+// waitFor polls cond until it holds or a few seconds passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolBoundsSlowSink(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	// A sink that holds every request until released.
+	release := make(chan struct{})
+	var running, peak, received atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		running.Add(-1)
+		received.Add(1)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	const workers, queueSize, tasks = 2, 4, 12
+	pool := New(rdb)
+	pool.Register("webhook", SinkConfig{Workers: workers, QueueSize: queueSize}, func(ctx context.Context, task Task) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, strings.NewReader(string(task.Payload)))
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	})
+	for i := 0; i < tasks; i++ {
+		if err := pool.Enqueue("webhook", Task{JobId: fmt.Sprint(i), Payload: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, "the workers to reach the sink", func() bool { return running.Load() == workers })
+	stats, _ := pool.Stats("webhook")
+	if stats.InFlight != workers || stats.Queued > queueSize {
+		t.Fatalf("stats while saturated = %+v, want %d in flight and at most %d queued", stats, workers, queueSize)
+	}
+	if int64(stats.Queued)+stats.InFlight+stats.Overflowed != tasks {
+		t.Fatalf("stats while saturated = %+v, want every task queued, in flight or overflowed", stats)
+	}
+	if backlog, err := pool.Backlog(context.Background(), "webhook"); err != nil || backlog != stats.Overflowed {
+		t.Fatalf("Backlog() = %d, %v, want the %d overflowed tasks in Redis", backlog, err, stats.Overflowed)
+	}
+
+	close(release)
+	waitFor(t, "every delivery", func() bool {
+		stats, _ := pool.Stats("webhook")
+		return stats.Delivered == tasks
+	})
+	if p := peak.Load(); p > workers {
+		t.Fatalf("%d concurrent deliveries, want at most %d", p, workers)
+	}
+	if received.Load() != tasks {
+		t.Fatalf("the sink received %d deliveries, want %d", received.Load(), tasks)
+	}
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	client.CloseIdleConnections()
+}
+
+func TestPoolCloseDrainsQueue(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	pool := New(rdb)
+	pool.Register("webhook", SinkConfig{Workers: 1, QueueSize: 10}, func(ctx context.Context, task Task) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		if err := pool.Enqueue("webhook", Task{JobId: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pool.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := pool.Stats("webhook"); stats.Delivered != 5 || stats.Queued != 0 {
+		t.Fatalf("stats after Close() = %+v, want the queue drained", stats)
+	}
+
+	// Deliveries scheduled during shutdown wait in Redis.
+	if err := pool.Enqueue("webhook", Task{JobId: "late"}); err != nil {
+		t.Fatal(err)
+	}
+	if backlog, err := pool.Backlog(context.Background(), "webhook"); err != nil || backlog != 1 {
+		t.Fatalf("Backlog() after Close() = %d, %v, want 1", backlog, err)
+	}
+	if err := pool.Enqueue("unknown", Task{JobId: "x"}); err == nil {
+		t.Fatal("Enqueue() accepted an unknown sink")
+	}
+}
+
+func TestPoolResumesAfterRestart(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	// The first instance is stuck on a sink and gives up on shutdown.
+	stuck := New(rdb)
+	stuck.Register("webhook", SinkConfig{Workers: 1, QueueSize: 1}, func(ctx context.Context, task Task) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	want := []string{"0", "1", "2", "3"}
+	for _, jobId := range want {
+		if err := stuck.Enqueue("webhook", Task{JobId: jobId, Payload: []byte(`"` + jobId + `"`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the stuck delivery", func() bool {
+		stats, _ := stuck.Stats("webhook")
+		return stats.InFlight == 1
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := stuck.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Close() = %v, want it to give up", err)
+	}
+	if backlog, err := stuck.Backlog(context.Background(), "webhook"); err != nil || backlog != int64(len(want)) {
+		t.Fatalf("Backlog() after giving up = %d, %v, want every task kept", backlog, err)
+	}
+
+	// The next instance delivers them.
+	var mu sync.Mutex
+	var delivered []string
+	next := New(rdb)
+	next.Register("webhook", SinkConfig{}, func(ctx context.Context, task Task) error {
+		if string(task.Payload) != `"`+task.JobId+`"` {
+			return fmt.Errorf("task %s has payload %s", task.JobId, task.Payload)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, task.JobId)
+		return nil
+	})
+	waitFor(t, "the resumed deliveries", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(delivered) >= len(want)
+	})
+	if err := next.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(delivered)
+	if strings.Join(delivered, ",") != strings.Join(want, ",") {
+		t.Fatalf("delivered %v after the restart, want %v once each", delivered, want)
+	}
+	if stats, _ := next.Stats("webhook"); stats.Failed != 0 {
+		t.Fatalf("stats after the restart = %+v", stats)
+	}
+	if backlog, _ := next.Backlog(context.Background(), "webhook"); backlog != 0 {
+		t.Fatalf("Backlog() after the restart = %d, want 0", backlog)
+	}
+}
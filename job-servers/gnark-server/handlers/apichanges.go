@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gnark-server/apichanges"
+)
+
+// APIChangesResponse is the response of api-changes.
+type APIChangesResponse struct {
+	// Current is the API version of this server.
+	Current string `json:"current"`
+	// Since is the version the changes are listed from, or empty when all
+	// changes are listed.
+	Since   string              `json:"since,omitempty"`
+	Changes []apichanges.Change `json:"changes"`
+}
+
+// APIChangesHandler serves GET /api-changes, the changes made to the API
+// after the version in the since query parameter, or all of them.
+func APIChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	res := APIChangesResponse{Current: apichanges.Current, Changes: apichanges.Registry}
+	if since := r.URL.Query().Get("since"); since != "" {
+		version, err := apichanges.ParseVersion(since)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		res.Since = version.String()
+		res.Changes = apichanges.Since(version)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
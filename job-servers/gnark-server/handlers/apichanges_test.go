@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gnark-server/apichanges"
+)
+
+func TestAPIChangesHandler(t *testing.T) {
+	w := serve(APIChangesHandler, http.MethodGet, "/api-changes", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("api-changes: %d %s", w.Code, w.Body)
+	}
+	var all APIChangesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	if all.Current != apichanges.Current || all.Since != "" || len(all.Changes) != len(apichanges.Registry) {
+		t.Fatalf("api-changes = current %s since %q with %d changes, want current %s with all %d", all.Current, all.Since, len(all.Changes), apichanges.Current, len(apichanges.Registry))
+	}
+
+	w = serve(APIChangesHandler, http.MethodGet, "/api-changes?since=1.19", "")
+	var since APIChangesResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &since); err != nil {
+		t.Fatal(err)
+	}
+	if since.Since != "1.19" || len(since.Changes) != len(apichanges.Since(apichanges.Version{Major: 1, Minor: 19})) {
+		t.Fatalf("api-changes?since=1.19 = since %q with %d changes", since.Since, len(since.Changes))
+	}
+	if since.Changes[0].Version != "1.20" || since.Changes[0].Endpoint != "/api-changes" {
+		t.Fatalf("api-changes?since=1.19 starts with %+v, want the 1.20 entry adding /api-changes", since.Changes[0])
+	}
+
+	if w := serve(APIChangesHandler, http.MethodGet, "/api-changes?since=yesterday", ""); w.Code != http.StatusBadRequest || errorCode(t, w) != codeInvalidRequest {
+		t.Fatalf("api-changes?since=yesterday: %d %s, want 400 %s", w.Code, w.Body, codeInvalidRequest)
+	}
+	if w := serve(APIChangesHandler, http.MethodPost, "/api-changes", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST api-changes: %d, want 405", w.Code)
+	}
+}
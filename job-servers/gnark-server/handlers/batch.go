@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const maxBatchSize = 64
+
+type BatchProofResult struct {
+	JobId        *string `json:"jobId"`
+	ErrorMessage *string `json:"errorMessage"`
+	ErrorCode    *string `json:"errorCode,omitempty"`
+	// Cached is set when the job was finished from the proof cache.
+	Cached bool `json:"cached,omitempty"`
+	// Deduplicated is set when JobId is an identical submission that was
+	// still in flight.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+// StartProofs accepts an array of proof requests and validates each entry
+// independently. If the queue has room for every valid entry, each is then
+// created like a start-proof request. The response has one entry per
+// request, in the same order.
+func (s *State) StartProofs(w http.ResponseWriter, r *http.Request) {
+	if s.isStopping() {
+		writeError(w, http.StatusServiceUnavailable, codeShuttingDown, errShuttingDown.Error())
+		return
+	}
+	if s.VerifyOnly {
+		writeError(w, http.StatusServiceUnavailable, codeProvingDisabled, errProvingDisabled.Error())
+		return
+	}
+	ctx, span := s.tracer().Start(r.Context(), "StartProofs")
+	defer span.End()
+
+	var bodies []proofRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&bodies); err != nil {
+		writeError(w, http.StatusBadRequest, codeMalformedJSON, err.Error())
+		return
+	}
+	rawInputs := make([]ProofRequest, len(bodies))
+	inputErrs := make([]error, len(bodies))
+	for i, body := range bodies {
+		rawInputs[i], inputErrs[i] = s.proofRequest(body)
+	}
+	// The X-Priority header applies to the entries that do not set one.
+	if priority := r.Header.Get(priorityHeader); priority != "" {
+		for i := range rawInputs {
+			if rawInputs[i].Priority == "" {
+				rawInputs[i].Priority = priority
+			}
+		}
+	}
+	if len(rawInputs) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidBatch, "empty batch")
+		return
+	}
+	if len(rawInputs) > maxBatchSize {
+		writeErrorDetails(w, http.StatusBadRequest, codeInvalidBatch,
+			fmt.Sprintf("batch size %d exceeds the maximum of %d", len(rawInputs), maxBatchSize),
+			map[string]interface{}{"maxBatchSize": maxBatchSize})
+		return
+	}
+	span.SetAttributes(attribute.Int("batch.size", len(rawInputs)))
+
+	results := make([]BatchProofResult, len(rawInputs))
+	jobs := make([]*preparedJob, len(rawInputs))
+	valid := 0
+	for i, rawInput := range rawInputs {
+		if err := inputErrs[i]; err != nil {
+			results[i].setError(requestErrorCode(err), err.Error())
+			continue
+		}
+		job, err := s.prepareJob(ctx, rawInput)
+		if err != nil {
+			results[i].setError(requestErrorCode(err), err.Error())
+			continue
+		}
+		jobs[i] = &job
+		valid++
+	}
+	if valid > 0 {
+		if err := s.checkQueueCapacity(ctx, valid); err != nil {
+			writeRequestError(w, err)
+			return
+		}
+	}
+
+	accepted := 0
+	for i, job := range jobs {
+		if job == nil {
+			continue
+		}
+		_jobId, err := uuid.NewRandom()
+		if err != nil {
+			writeInternalError(w)
+			return
+		}
+		started, err := s.createJob(ctx, _jobId.String(), *job, false)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			log.Printf("Failed to create entry %d of batch: %v\n", i, err)
+			results[i].setError(codeInternalError, "Internal server error")
+			continue
+		}
+		results[i].JobId = &started.jobId
+		results[i].Cached = started.cached != nil
+		results[i].Deduplicated = started.deduplicated
+		accepted++
+	}
+
+	json.NewEncoder(w).Encode(results)
+	log.Printf("StartProofs accepted %d of %d jobs\n", accepted, len(rawInputs))
+}
+
+// setError records that the entry of a batch was refused.
+func (r *BatchProofResult) setError(code string, message string) {
+	r.ErrorCode = &code
+	r.ErrorMessage = &message
+}
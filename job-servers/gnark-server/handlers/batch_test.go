@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gnark-server/proofcache"
+)
+
+// startProofs submits a batch and returns its results.
+func startProofs(t *testing.T, s *State, inputs []ProofRequest) []BatchProofResult {
+	t.Helper()
+	body, err := json.Marshal(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.StartProofs(w, httptest.NewRequest(http.MethodPost, "/start-proofs", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("start-proofs: %d %s, want 200", w.Code, w.Body)
+	}
+	var results []BatchProofResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("%d results for %d entries", len(results), len(inputs))
+	}
+	return results
+}
+
+func TestStartProofsDeduplicatesInflight(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	ctx := context.Background()
+	input := testProofRequest(t)
+	invalid := ProofRequest{Proof: "{}", VerifierData: input.VerifierData}
+
+	results := startProofs(t, s, []ProofRequest{input, invalid, input})
+	if results[0].JobId == nil || results[0].Deduplicated {
+		t.Fatalf("first entry: %+v, want a new job", results[0])
+	}
+	jobId := *results[0].JobId
+	if results[1].JobId != nil || results[1].ErrorCode == nil {
+		t.Fatalf("invalid entry: %+v, want an error", results[1])
+	}
+	if results[2].JobId == nil || *results[2].JobId != jobId || !results[2].Deduplicated {
+		t.Fatalf("identical entry: %+v, want job %s deduplicated", results[2], jobId)
+	}
+	if queued := queuedJobs(t, s); len(queued) != 1 || queued[0] != jobId {
+		t.Fatalf("queued %v, want [%s]", queued, jobId)
+	}
+
+	meta, err := s.getJobMetadata(ctx, jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := s.soleCircuit()
+	digest, err := requestDigest(input, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The input digest gives the job its shard.
+	if meta[metaInputDigest] != digest || meta[metaCircuit] != data.Name {
+		t.Fatalf("metadata %v, want inputDigest %s and circuit %s", meta, digest, data.Name)
+	}
+	if owner, _ := s.RedisClient.Get(ctx, getRedisInflightKey(digest)).Result(); owner != jobId {
+		t.Fatalf("in-flight key held by %q, want %s", owner, jobId)
+	}
+
+	// A single start-proof of the same inputs joins the batch job too.
+	started, err := s.startProof(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started.jobId != jobId || !started.deduplicated {
+		t.Fatalf("startProof = %+v, want job %s deduplicated", started, jobId)
+	}
+}
+
+func TestStartProofsServesProofCache(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	ctx := context.Background()
+	s.ProofCache = proofcache.New(s.RedisClient, 0)
+	input := testProofRequest(t)
+	data, _ := s.soleCircuit()
+	digest, err := requestDigest(input, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := proofcache.Entry{PublicInputs: []string{"1", "2"}, Proof: "0x1234"}
+	if err := s.ProofCache.Put(ctx, data.ReleaseId, digest, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	results := startProofs(t, s, []ProofRequest{input})
+	if results[0].JobId == nil || !results[0].Cached {
+		t.Fatalf("entry: %+v, want a job served from the proof cache", results[0])
+	}
+	if queued := queuedJobs(t, s); len(queued) != 0 {
+		t.Fatalf("cache hit queued %v", queued)
+	}
+	response, err := s.getProof(ctx, *results[0].JobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Success || response.Proof == nil || response.Proof.Proof != entry.Proof {
+		t.Fatalf("get-proof = %+v, want the cached proof", response)
+	}
+}
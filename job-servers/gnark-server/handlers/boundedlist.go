@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	listCallbackAttempts = "callbackAttempts"
+
+	metaRecordSizeBytes = "recordSizeBytes"
+)
+
+// ListCap bounds a per-job Redis list. Once the list grows past Head+Tail
+// entries the first Head and the last Tail entries are kept and everything in
+// between is replaced by a single truncation marker recording how many entries
+// were dropped.
+type ListCap struct {
+	Head int64
+	Tail int64
+}
+
+var defaultListCaps = map[string]ListCap{
+	listCallbackAttempts: {Head: 5, Tail: 20},
+}
+
+// ParseListCaps parses overrides of the form "name=head:tail,name=head:tail".
+func ParseListCaps(s string) (map[string]ListCap, error) {
+	caps := make(map[string]ListCap, len(defaultListCaps))
+	for name, c := range defaultListCaps {
+		caps[name] = c
+	}
+	if strings.TrimSpace(s) == "" {
+		return caps, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		name, bounds, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid list cap %q, expected name=head:tail", entry)
+		}
+		if _, known := defaultListCaps[name]; !known {
+			return nil, fmt.Errorf("unknown list %q", name)
+		}
+		headStr, tailStr, ok := strings.Cut(bounds, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid list cap %q, expected name=head:tail", entry)
+		}
+		head, err := strconv.ParseInt(headStr, 10, 64)
+		if err != nil || head < 0 {
+			return nil, fmt.Errorf("invalid head cap in %q", entry)
+		}
+		tail, err := strconv.ParseInt(tailStr, 10, 64)
+		if err != nil || tail < 1 {
+			return nil, fmt.Errorf("invalid tail cap in %q", entry)
+		}
+		caps[name] = ListCap{Head: head, Tail: tail}
+	}
+	return caps, nil
+}
+
+// boundedAppendScript appends ARGV[1] to the list KEYS[1] and evicts entries
+// according to the head/tail caps in ARGV[2] and ARGV[3]. It returns whether
+// the list was truncated and the total size of the list in bytes.
+var boundedAppendScript = redis.NewScript(`
+local key = KEYS[1]
+local head = tonumber(ARGV[2])
+local tail = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+redis.call('RPUSH', key, ARGV[1])
+local len = redis.call('LLEN', key)
+
+local dropped = 0
+local limit = head + tail
+if head < len then
+  local ok, decoded = pcall(cjson.decode, redis.call('LINDEX', key, head))
+  if ok and type(decoded) == 'table' and decoded['truncated'] == true then
+    dropped = tonumber(decoded['dropped'])
+    limit = limit + 1
+  end
+end
+
+local truncated = 0
+if len > limit then
+  local items = redis.call('LRANGE', key, 0, -1)
+  dropped = dropped + (len - limit)
+  local out = {}
+  for i = 1, head do
+    out[#out + 1] = items[i]
+  end
+  out[#out + 1] = cjson.encode({truncated = true, dropped = dropped})
+  for i = len - tail + 1, len do
+    out[#out + 1] = items[i]
+  end
+  redis.call('DEL', key)
+  redis.call('RPUSH', key, unpack(out))
+  truncated = 1
+end
+
+if ttl > 0 then
+  redis.call('EXPIRE', key, ttl)
+end
+
+local size = 0
+for _, item in ipairs(redis.call('LRANGE', key, 0, -1)) do
+  size = size + #item
+end
+return {truncated, size}
+`)
+
+// boundedAppend appends value to one of the per-job lists and applies the
+// configured cap. Every code path that appends to a per-job list must go
+// through this helper.
+func (s *State) boundedAppend(ctx context.Context, jobId string, list string, key string, value []byte) error {
+	caps := s.ListCaps
+	if caps == nil {
+		caps = defaultListCaps
+	}
+	c, ok := caps[list]
+	if !ok {
+		return fmt.Errorf("no cap configured for list %q", list)
+	}
+	expiresAt := s.jobExpiry(ctx, jobId)
+	ttl := int64(time.Until(expiresAt).Seconds())
+	if ttl < 1 {
+		ttl = 1
+	}
+	res, err := boundedAppendScript.Run(ctx, s.RedisClient, []string{key}, value, c.Head, c.Tail, ttl).Int64Slice()
+	if err != nil {
+		return err
+	}
+	truncated, size := res[0] == 1, res[1]
+	if truncated {
+		log.Printf("Warning: %s list of job %s exceeded its cap (head %d, tail %d) and was truncated, %d bytes retained\n", list, jobId, c.Head, c.Tail, size)
+	}
+	metaKey := getRedisMetaKey(jobId)
+	_, err = s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, metaKey, fmt.Sprintf("%s.%s", metaRecordSizeBytes, list), size)
+		pipe.ExpireAt(ctx, metaKey, expiresAt)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gnark-server/webhook"
+
+	"github.com/google/uuid"
+)
+
+func TestBoundedAppendKeepsHeadAndTail(t *testing.T) {
+	ctx := context.Background()
+	caps, err := ParseListCaps("callbackAttempts=2:3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		caps    map[string]ListCap
+		appends int
+	}{
+		{"default caps", nil, 1000},
+		{"configured caps", caps, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestState(t)
+			s.ListCaps = tt.caps
+			c := defaultListCaps[listCallbackAttempts]
+			if tt.caps != nil {
+				c = tt.caps[listCallbackAttempts]
+			}
+			jobId := uuid.NewString()
+			// Every attempt carries a long error, as a failing callback
+			// target would.
+			errMsg := strings.Repeat("x", 512)
+			var largest int
+			for i := 0; i < tt.appends; i++ {
+				attempt := webhook.Attempt{Attempt: i, Time: time.Now().UTC(), StatusCode: 503, Error: errMsg}
+				if raw, _ := json.Marshal(attempt); len(raw) > largest {
+					largest = len(raw)
+				}
+				if err := s.recordCallbackAttempt(ctx, jobId, attempt); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			items, err := s.RedisClient.LRange(ctx, getRedisCallbackAttemptsKey(jobId), 0, -1).Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(items)) != c.Head+c.Tail+1 {
+				t.Fatalf("%d entries, want %d kept and the truncation marker", len(items), c.Head+c.Tail)
+			}
+			attemptAt := func(i int) int {
+				var attempt webhook.Attempt
+				if err := json.Unmarshal([]byte(items[i]), &attempt); err != nil {
+					t.Fatal(err)
+				}
+				return attempt.Attempt
+			}
+			for i := 0; i < int(c.Head); i++ {
+				if got := attemptAt(i); got != i {
+					t.Fatalf("entry %d is attempt %d, want the head attempt %d", i, got, i)
+				}
+			}
+			var marker struct {
+				Truncated bool  `json:"truncated"`
+				Dropped   int64 `json:"dropped"`
+			}
+			if err := json.Unmarshal([]byte(items[c.Head]), &marker); err != nil {
+				t.Fatal(err)
+			}
+			if wantDropped := int64(tt.appends) - c.Head - c.Tail; !marker.Truncated || marker.Dropped != wantDropped {
+				t.Fatalf("marker %s, want %d dropped", items[c.Head], wantDropped)
+			}
+			for i := 0; i < int(c.Tail); i++ {
+				want := tt.appends - int(c.Tail) + i
+				if got := attemptAt(int(c.Head) + 1 + i); got != want {
+					t.Fatalf("tail entry %d is attempt %d, want %d", i, got, want)
+				}
+			}
+
+			// The record stays within the budget of its caps however many
+			// attempts were made, and its size is recorded on the job.
+			budget := int64(largest)*(c.Head+c.Tail) + int64(len(items[c.Head]))
+			size, err := s.RedisClient.HGet(ctx, getRedisMetaKey(jobId), metaRecordSizeBytes+"."+listCallbackAttempts).Int64()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size > budget {
+				t.Fatalf("record of %d bytes, want at most %d", size, budget)
+			}
+			var total int64
+			for _, item := range items {
+				total += int64(len(item))
+			}
+			if size != total {
+				t.Fatalf("recorded size %d, want the %d bytes of the list", size, total)
+			}
+		})
+	}
+}
+
+func TestParseListCaps(t *testing.T) {
+	caps, err := ParseListCaps("")
+	if err != nil || caps[listCallbackAttempts] != defaultListCaps[listCallbackAttempts] {
+		t.Fatalf("ParseListCaps(\"\") = %v, %v, want the defaults", caps, err)
+	}
+	caps, err = ParseListCaps(" callbackAttempts=0:10 ")
+	if err != nil || caps[listCallbackAttempts] != (ListCap{Head: 0, Tail: 10}) {
+		t.Fatalf("ParseListCaps() = %v, %v, want head 0 and tail 10", caps, err)
+	}
+	for _, s := range []string{"notes=1:1", "callbackAttempts=5", "callbackAttempts", "callbackAttempts=-1:5", "callbackAttempts=1:0"} {
+		if _, err := ParseListCaps(s); err == nil {
+			t.Errorf("ParseListCaps(%q) accepted an invalid cap", s)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"gnark-server/webhook"
+)
+
+const (
+	redisCallbackAttemptsKeyPrefix = "gnark_proof_callback_attempts:"
+	redisCallbackDedupeKeyPrefix   = "gnark_callback_dedupe:"
+
+	// callbackSuppressedDuplicate is the callbackStatus of a job whose
+	// notification was not sent because an identical one was delivered
+	// within the dedupe window.
+	callbackSuppressedDuplicate = "suppressed_duplicate"
+)
+
+func getRedisCallbackAttemptsKey(jobId string) string {
+	return fmt.Sprintf("%s%s", redisCallbackAttemptsKeyPrefix, jobId)
+}
+
+// getRedisCallbackDedupeKey identifies the notifications of a terminal status
+// of an input, proved with a circuit release, sent to callbackUrl.
+func getRedisCallbackDedupeKey(callbackUrl string, digest string, circuitRelease string, success bool) string {
+	status := jobStateFailed
+	if success {
+		status = jobStateDone
+	}
+	sum := sha256.Sum256([]byte(callbackUrl + "\n" + digest + "\n" + circuitRelease + "\n" + status))
+	return redisCallbackDedupeKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// suppressedCallback is the entry recorded in the callback attempts of a
+// job instead of a delivery.
+type suppressedCallback struct {
+	Time        time.Time `json:"time"`
+	Suppressed  string    `json:"suppressed"`
+	DuplicateOf string    `json:"duplicateOf"`
+}
+
+// notifyCallback POSTs the response GetProof would return to callbackUrl and
+// records every attempt in Redis next to the job. Within CallbackDedupeWindow
+// of an identical notification, the job records a suppressed duplicate
+// instead, unless its metadata forces the delivery. A failed delivery is
+// returned.
+func (s *State) notifyCallback(ctx context.Context, jobId string, callbackUrl string, response ProofResponse, meta map[string]string) error {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to encode callback: %w", err)
+	}
+	var dedupeKey string
+	if digest := meta[metaCallbackInputDigest]; s.CallbackDedupeWindow > 0 && digest != "" {
+		dedupeKey = getRedisCallbackDedupeKey(callbackUrl, digest, response.CircuitRelease, response.Success)
+		duplicateOf, err := s.claimCallback(ctx, dedupeKey, jobId, meta[metaCallbackForce] == "true")
+		if err != nil {
+			// Delivering twice is better than not at all.
+			log.Printf("Failed to check callback of job %s for duplicates: %v\n", jobId, err)
+			dedupeKey = ""
+		} else if duplicateOf != "" {
+			s.suppressCallback(ctx, jobId, duplicateOf)
+			return nil
+		}
+	}
+	deliverer := s.CallbackDeliverer
+	if deliverer == nil {
+		deliverer = &webhook.Deliverer{Validator: s.CallbackValidator}
+	}
+	err = deliverer.Deliver(ctx, callbackUrl, body, func(attempt webhook.Attempt) {
+		if err := s.recordCallbackAttempt(ctx, jobId, attempt); err != nil {
+			log.Printf("Failed to record callback attempt for job %s: %v\n", jobId, err)
+		}
+	})
+	if err != nil {
+		if dedupeKey != "" {
+			// An undelivered notification must not suppress the next one.
+			if err := s.release(ctx, dedupeKey, jobId); err != nil {
+				log.Printf("Failed to release callback dedupe key of job %s: %v\n", jobId, err)
+			}
+		}
+		return err
+	}
+	if err := s.RedisClient.HDel(ctx, getRedisMetaKey(jobId), metaCallbackStatus, metaCallbackDuplicateOf).Err(); err != nil {
+		log.Printf("Failed to clear callback status of job %s: %v\n", jobId, err)
+	}
+	log.Println("Callback delivered. jobId", jobId)
+	return nil
+}
+
+// claimCallback marks jobId as the job that sends the notification of
+// dedupeKey for CallbackDedupeWindow. If another notification claimed it,
+// the job that sent it is returned; a job retried with the same ID finds its
+// own claim. A forced claim takes the key over.
+func (s *State) claimCallback(ctx context.Context, dedupeKey string, jobId string, force bool) (string, error) {
+	if force {
+		return "", s.RedisClient.Set(ctx, dedupeKey, jobId, s.CallbackDedupeWindow).Err()
+	}
+	return s.claim(ctx, dedupeKey, jobId, s.CallbackDedupeWindow)
+}
+
+// suppressCallback records on the job that its notification duplicates the
+// one duplicateOf sent.
+func (s *State) suppressCallback(ctx context.Context, jobId string, duplicateOf string) {
+	entry, err := json.Marshal(suppressedCallback{Time: time.Now().UTC(), Suppressed: "duplicate", DuplicateOf: duplicateOf})
+	if err == nil {
+		err = s.boundedAppend(ctx, jobId, listCallbackAttempts, getRedisCallbackAttemptsKey(jobId), entry)
+	}
+	if err != nil {
+		log.Printf("Failed to record suppressed callback for job %s: %v\n", jobId, err)
+	}
+	err = s.RedisClient.HSet(ctx, getRedisMetaKey(jobId),
+		metaCallbackStatus, callbackSuppressedDuplicate,
+		metaCallbackDuplicateOf, duplicateOf).Err()
+	if err != nil {
+		log.Printf("Failed to record callback status of job %s: %v\n", jobId, err)
+	}
+	log.Println("Callback suppressed as a duplicate of", duplicateOf, "jobId", jobId)
+}
+
+func (s *State) recordCallbackAttempt(ctx context.Context, jobId string, attempt webhook.Attempt) error {
+	attemptJSON, err := json.Marshal(attempt)
+	if err != nil {
+		return err
+	}
+	return s.boundedAppend(ctx, jobId, listCallbackAttempts, getRedisCallbackAttemptsKey(jobId), attemptJSON)
+}
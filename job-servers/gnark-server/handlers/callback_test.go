@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gnark-server/webhook"
+)
+
+// callbackReceiver counts the notifications POSTed to it and answers them
+// with status.
+type callbackReceiver struct {
+	url       string
+	delivered atomic.Int64
+	status    atomic.Int64
+}
+
+func newCallbackReceiver(t *testing.T) *callbackReceiver {
+	t.Helper()
+	receiver := &callbackReceiver{}
+	receiver.status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			receiver.delivered.Add(1)
+		}
+		w.WriteHeader(int(receiver.status.Load()))
+	}))
+	t.Cleanup(srv.Close)
+	receiver.url = srv.URL + "/callback"
+	return receiver
+}
+
+// newCallbackTestState returns a proving State that stores inputs and
+// delivers callbacks to local receivers with the given dedupe window.
+func newCallbackTestState(t *testing.T, window time.Duration) *State {
+	t.Helper()
+	s, _ := newProvingTestState(t)
+	s.StoreInputs = true
+	s.CallbackDedupeWindow = window
+	s.CallbackValidator = &webhook.Validator{AllowPrivateTargets: true}
+	s.CallbackDeliverer = &webhook.Deliverer{Validator: s.CallbackValidator, MaxAttempts: 1}
+	return s
+}
+
+// submitWithCallback queues a job notifying receiver and returns its ID.
+func submitWithCallback(t *testing.T, s *State, receiver *callbackReceiver) string {
+	t.Helper()
+	input := testProofRequest(t)
+	input.CallbackUrl = receiver.url
+	results := startProofs(t, s, []ProofRequest{input})
+	if results[0].JobId == nil {
+		t.Fatalf("start-proofs: %s", *results[0].ErrorMessage)
+	}
+	return *results[0].JobId
+}
+
+// finishNotified finishes a job as a worker of the release in testdata
+// would and waits for its callback.
+func finishNotified(t *testing.T, s *State, jobId string, success bool) {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), metaCircuitRelease, "0123456789ab").Err(); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := s.getJobMetadata(ctx, jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := ProofResponse{Success: success}
+	if success {
+		result := newProveResult([]string{"1", "2"}, "aa")
+		response.Proof = &result
+	} else {
+		errMsg := "prover failed"
+		response.ErrorMessage = &errMsg
+	}
+	s.finishJob(ctx, jobId, response, meta)
+	s.inFlight.Wait()
+}
+
+// replay replays jobId with the query and returns the replay's job ID.
+func replay(t *testing.T, s *State, jobId string, query string) string {
+	t.Helper()
+	w := serve(s.Jobs, http.MethodPost, "/jobs/"+jobId+"/replay"+query, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("replay%s: %d %s, want 200", query, w.Code, w.Body)
+	}
+	var response ReplayJobResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.JobId
+}
+
+// assertCallback checks the callback status get-proof reports for jobId
+// and, for a suppressed one, the recorded attempt.
+func assertCallback(t *testing.T, s *State, jobId string, duplicateOf string) {
+	t.Helper()
+	ctx := context.Background()
+	response, err := s.getProof(ctx, jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Job == nil {
+		t.Fatalf("get-proof of %s has no job record", jobId)
+	}
+	if duplicateOf == "" {
+		if response.Job.CallbackStatus != "" || response.Job.CallbackDuplicateOf != "" {
+			t.Fatalf("job %s reports callback %s of %s, want it delivered", jobId, response.Job.CallbackStatus, response.Job.CallbackDuplicateOf)
+		}
+		return
+	}
+	if response.Job.CallbackStatus != callbackSuppressedDuplicate || response.Job.CallbackDuplicateOf != duplicateOf {
+		t.Fatalf("job %s reports callback %q of %q, want %s of %s", jobId, response.Job.CallbackStatus, response.Job.CallbackDuplicateOf, callbackSuppressedDuplicate, duplicateOf)
+	}
+	attempts, err := s.RedisClient.LRange(ctx, getRedisCallbackAttemptsKey(jobId), 0, -1).Result()
+	if err != nil || len(attempts) == 0 {
+		t.Fatalf("callback attempts of %s: %v, %v", jobId, attempts, err)
+	}
+	var last suppressedCallback
+	if err := json.Unmarshal([]byte(attempts[len(attempts)-1]), &last); err != nil || last.Suppressed != "duplicate" || last.DuplicateOf != duplicateOf {
+		t.Fatalf("last callback attempt of %s = %s, want a suppressed duplicate of %s", jobId, attempts[len(attempts)-1], duplicateOf)
+	}
+}
+
+func TestCallbackDedupeReplay(t *testing.T) {
+	s := newCallbackTestState(t, time.Minute)
+	receiver := newCallbackReceiver(t)
+	original := submitWithCallback(t, s, receiver)
+	finishNotified(t, s, original, true)
+	if n := receiver.delivered.Load(); n != 1 {
+		t.Fatalf("%d notifications for the original job, want 1", n)
+	}
+	assertCallback(t, s, original, "")
+
+	// A replay without notify does not notify at all.
+	silent := replay(t, s, original, "")
+	finishNotified(t, s, silent, true)
+	if n := receiver.delivered.Load(); n != 1 {
+		t.Fatalf("a replay without notify sent a notification")
+	}
+
+	notified := replay(t, s, original, "?notify=true")
+	finishNotified(t, s, notified, true)
+	if n := receiver.delivered.Load(); n != 1 {
+		t.Fatalf("%d notifications after a replay within the window, want it suppressed", n)
+	}
+	assertCallback(t, s, notified, original)
+
+	forced := replay(t, s, original, "?force=true")
+	finishNotified(t, s, forced, true)
+	if n := receiver.delivered.Load(); n != 2 {
+		t.Fatalf("%d notifications after a forced replay, want it delivered", n)
+	}
+	assertCallback(t, s, forced, "")
+
+	// The forced delivery took the window over.
+	again := replay(t, s, original, "?notify=true")
+	finishNotified(t, s, again, true)
+	assertCallback(t, s, again, forced)
+
+	// Another terminal status is another notification.
+	failed := replay(t, s, original, "?notify=true")
+	finishNotified(t, s, failed, false)
+	if n := receiver.delivered.Load(); n != 3 {
+		t.Fatalf("%d notifications after a failed replay, want it delivered", n)
+	}
+	assertCallback(t, s, failed, "")
+}
+
+func TestCallbackDedupeDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	s := newCallbackTestState(t, time.Minute)
+	receiver := newCallbackReceiver(t)
+	jobId := submitWithCallback(t, s, receiver)
+	if _, err := s.dequeueJob(ctx); err != nil {
+		t.Fatal(err)
+	}
+	finishNotified(t, s, jobId, false)
+	s.deadLetter(ctx, jobId, errDeadlineExceeded)
+	if n := receiver.delivered.Load(); n != 1 {
+		t.Fatalf("%d notifications for the failed job, want 1", n)
+	}
+
+	w := serve(s.RetryDeadLetterHandler, http.MethodPost, "/dead-letter-jobs/retry?jobId="+jobId, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("retry dead letter: %d %s, want 200", w.Code, w.Body)
+	}
+	if _, err := s.dequeueJob(ctx); err != nil {
+		t.Fatal(err)
+	}
+	finishNotified(t, s, jobId, false)
+	if n := receiver.delivered.Load(); n != 1 {
+		t.Fatalf("%d notifications after the requeued dead letter failed again, want it suppressed", n)
+	}
+	assertCallback(t, s, jobId, jobId)
+}
+
+func TestCallbackDedupeFailedDelivery(t *testing.T) {
+	s := newCallbackTestState(t, time.Minute)
+	receiver := newCallbackReceiver(t)
+	receiver.status.Store(http.StatusBadRequest)
+	original := submitWithCallback(t, s, receiver)
+	finishNotified(t, s, original, true)
+
+	// The rejected notification does not suppress the next one.
+	receiver.status.Store(http.StatusOK)
+	notified := replay(t, s, original, "?notify=true")
+	finishNotified(t, s, notified, true)
+	if n := receiver.delivered.Load(); n != 2 {
+		t.Fatalf("%d notifications, want the replay delivered after the original was rejected", n)
+	}
+	assertCallback(t, s, notified, "")
+}
+
+func TestCallbackDedupeDisabled(t *testing.T) {
+	s := newCallbackTestState(t, 0)
+	receiver := newCallbackReceiver(t)
+	original := submitWithCallback(t, s, receiver)
+	finishNotified(t, s, original, true)
+	notified := replay(t, s, original, "?notify=true")
+	finishNotified(t, s, notified, true)
+	if n := receiver.delivered.Load(); n != 2 {
+		t.Fatalf("%d notifications without a dedupe window, want 2", n)
+	}
+	assertCallback(t, s, notified, "")
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/hex"
+
+	"gnark-server/prover"
+	"gnark-server/utils"
+)
+
+// Output formats of get-proof.
+const (
+	proofFormatJSON     = "json"
+	proofFormatCalldata = "calldata"
+)
+
+// ProofCalldata is a proof encoded for the exported Solidity verifier.
+type ProofCalldata struct {
+	// Backend is the proving system, plonk or groth16.
+	Backend string `json:"backend"`
+	// Proof is the 0x-prefixed proof argument: the bytes proof of the PLONK
+	// verifier, or the uint256[8] proof of the Groth16 verifier followed by
+	// any commitments.
+	Proof string `json:"proof"`
+	// PublicInputs are verifierDigest and inputHash as 0x-prefixed 32-byte
+	// words.
+	PublicInputs []string `json:"publicInputs"`
+	// Calldata is the ABI encoded call to the verifier's entry point.
+	Calldata string `json:"calldata"`
+}
+
+// CalldataProofResponse is ProofResponse with the proof in ProofCalldata
+// form, returned by get-proof with format=calldata.
+type CalldataProofResponse struct {
+	Success        bool           `json:"success"`
+	Proof          *ProofCalldata `json:"proof"`
+	ErrorMessage   *string        `json:"errorMessage"`
+	ErrorCode      *string        `json:"errorCode,omitempty"`
+	CircuitRelease string         `json:"circuitRelease,omitempty"`
+	Job            *JobRecord     `json:"job,omitempty"`
+}
+
+// calldataResponse converts a job response to the calldata format. Proofs
+// stored before responses recorded their backend are assumed to come from
+// the backend this server runs.
+func (s *State) calldataResponse(response ProofResponse) (CalldataProofResponse, error) {
+	resp := CalldataProofResponse{
+		Success:        response.Success,
+		ErrorMessage:   response.ErrorMessage,
+		ErrorCode:      response.ErrorCode,
+		CircuitRelease: response.CircuitRelease,
+		Job:            response.Job,
+	}
+	if response.Proof == nil {
+		return resp, nil
+	}
+	proof, err := utils.SolidityProof(response.Proof.Proof)
+	if err != nil {
+		return resp, err
+	}
+	publicInputs, err := utils.SolidityPublicInputs(response.Proof.PublicInputs)
+	if err != nil {
+		return resp, err
+	}
+	backend := response.Backend
+	if backend == "" {
+		backend = s.backendName()
+	}
+	result := &prover.Result{
+		Backend:      backend,
+		PublicInputs: response.Proof.PublicInputs,
+		Proof:        proof,
+	}
+	calldata, err := prover.VerifyCalldata(result)
+	if err != nil {
+		return resp, err
+	}
+	resp.Proof = &ProofCalldata{
+		Backend:      result.Backend,
+		Proof:        "0x" + hex.EncodeToString(proof),
+		PublicInputs: publicInputs,
+		Calldata:     "0x" + hex.EncodeToString(calldata),
+	}
+	return resp, nil
+}
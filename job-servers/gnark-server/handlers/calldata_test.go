@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gnark-server/circuitData"
+
+	"github.com/google/uuid"
+)
+
+func TestGetProofCalldataFormat(t *testing.T) {
+	s, _ := newTestState(t)
+	ctx := context.Background()
+	jobId := uuid.NewString()
+	proof := strings.Repeat("ab", 40)
+	result := newProveResult([]string{"1", "255"}, proof)
+	response := ProofResponse{Success: true, Proof: &result, Backend: circuitData.BackendPlonk}
+	if err := s.setProofResponse(ctx, jobId, response, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(s.GetProof, http.MethodGet, "/get-proof?jobId="+jobId, "")
+	var plain ProofResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &plain); err != nil {
+		t.Fatal(err)
+	}
+	if plain.Proof == nil || plain.Proof.Proof != proof || plain.Proof.VerifierDigest != "1" {
+		t.Fatalf("default format = %s, want the stored proof", w.Body)
+	}
+	if w2 := serve(s.GetProof, http.MethodGet, "/get-proof?jobId="+jobId+"&format=json", ""); w2.Body.String() != w.Body.String() {
+		t.Fatalf("format=json = %s, want the default format %s", w2.Body, w.Body)
+	}
+
+	w = serve(s.GetProof, http.MethodGet, "/get-proof?jobId="+jobId+"&format=calldata", "")
+	var calldata CalldataProofResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &calldata); err != nil {
+		t.Fatal(err)
+	}
+	if !calldata.Success || calldata.Proof == nil {
+		t.Fatalf("format=calldata = %s, want a proof", w.Body)
+	}
+	wantInputs := []string{"0x" + strings.Repeat("0", 63) + "1", "0x" + strings.Repeat("0", 62) + "ff"}
+	if calldata.Proof.Proof != "0x"+proof || calldata.Proof.Backend != circuitData.BackendPlonk ||
+		len(calldata.Proof.PublicInputs) != 2 || calldata.Proof.PublicInputs[0] != wantInputs[0] || calldata.Proof.PublicInputs[1] != wantInputs[1] {
+		t.Fatalf("format=calldata proof = %+v, want %s with inputs %v", calldata.Proof, proof, wantInputs)
+	}
+	if !strings.Contains(calldata.Proof.Calldata, proof) {
+		t.Fatalf("calldata %s does not hold the proof", calldata.Proof.Calldata)
+	}
+}
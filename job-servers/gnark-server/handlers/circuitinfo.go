@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// CircuitInfoResponse is the response of GET /circuit-info. Its fields are
+// snake_case, like those of EstimateProveTimeResponse.
+type CircuitInfoResponse struct {
+	Circuit        string `json:"circuit"`
+	CircuitRelease string `json:"circuit_release"`
+	NbConstraints  int    `json:"nb_constraints"`
+	NbPublicInputs int    `json:"nb_public_inputs"`
+	// ProvingKeySizeBytes is zero when the proving key did not load, in
+	// verify-only mode.
+	ProvingKeySizeBytes   int64 `json:"proving_key_size_bytes"`
+	VerifyingKeySizeBytes int64 `json:"verifying_key_size_bytes"`
+	// CircuitDigest is the plonky2 circuit digest the circuit was set up
+	// with, the verifierDigest public input of its proofs, as a decimal
+	// string. It is empty when the verifier only circuit data is absent.
+	CircuitDigest string `json:"circuit_digest"`
+	// InputDigestBits is the width of the inputHash public input, into
+	// which the plonky2 public inputs are packed, and InputDigestHeadroom
+	// how far its largest value is below the BN254 scalar field modulus, as
+	// a decimal string.
+	InputDigestBits     uint   `json:"input_digest_bits"`
+	InputDigestHeadroom string `json:"input_digest_headroom"`
+}
+
+// CircuitInfo serves GET /circuit-info?circuit=<name>, the size of a loaded
+// circuit, for clients to size their calls and check they target the
+// circuit they expect. The values are computed when the circuit data is
+// loaded.
+func (s *State) CircuitInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	data, err := s.circuit(r.URL.Query().Get("circuit"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	inputDigest := data.InputDigestConfig()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CircuitInfoResponse{
+		Circuit:               data.Name,
+		CircuitRelease:        data.ReleaseId,
+		NbConstraints:         data.Version.Constraints,
+		NbPublicInputs:        data.Version.PublicInputs,
+		ProvingKeySizeBytes:   data.Version.ProvingKeySize,
+		VerifyingKeySizeBytes: data.Version.VerifyingKeySize,
+		CircuitDigest:         data.Version.CircuitDigest,
+		InputDigestBits:       inputDigest.Bits(),
+		InputDigestHeadroom:   inputDigest.Headroom(ecc.BN254.ScalarField()).String(),
+	})
+}
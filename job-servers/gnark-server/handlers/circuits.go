@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"gnark-server/circuitData"
+)
+
+const (
+	metaCircuit = "circuit"
+
+	codeUnknownCircuit = "unknown_circuit"
+)
+
+// loadedCircuits returns the loaded circuits by name. /reload-circuit
+// replaces the map rather than modifying it, so callers can keep reading the
+// one they got.
+func (s *State) loadedCircuits() circuitData.Registry {
+	s.circuitsMu.RLock()
+	defer s.circuitsMu.RUnlock()
+	return s.Circuits
+}
+
+// sortedCircuits returns the loaded circuits ordered by name.
+func (s *State) sortedCircuits() []circuitData.CircuitData {
+	return s.loadedCircuits().Sorted()
+}
+
+// circuitNames returns the names of the loaded circuits in order.
+func (s *State) circuitNames() []string {
+	return s.loadedCircuits().Names()
+}
+
+// circuit returns the loaded circuit called name. An empty name selects the
+// only circuit, and is an error when several are loaded.
+func (s *State) circuit(name string) (circuitData.CircuitData, error) {
+	circuits := s.loadedCircuits()
+	if data, ok := circuits.Get(name); ok {
+		return data, nil
+	}
+	names := circuits.Names()
+	message := fmt.Sprintf("unknown circuit %q, available circuits: %s", name, strings.Join(names, ", "))
+	if name == "" {
+		message = "circuit is required, available circuits: " + strings.Join(names, ", ")
+	}
+	return circuitData.CircuitData{}, &RequestError{
+		Code:    codeUnknownCircuit,
+		Message: message,
+		Details: map[string]interface{}{"circuits": names},
+	}
+}
+
+// soleCircuit returns the loaded circuit if there is only one.
+func (s *State) soleCircuit() (circuitData.CircuitData, bool) {
+	return s.loadedCircuits().Sole()
+}
+
+// backendName returns the proving backend, which all circuits share.
+func (s *State) backendName() string {
+	for _, data := range s.loadedCircuits() {
+		if data.Backend != nil {
+			return data.Backend.Name()
+		}
+	}
+	return ""
+}
+
+// circuitReleases describes the releases of the loaded circuits for status
+// pages: the release ID of the only circuit, or name=release pairs.
+func (s *State) circuitReleases() string {
+	if data, ok := s.soleCircuit(); ok {
+		return data.ReleaseId
+	}
+	circuits := s.sortedCircuits()
+	releases := make([]string, 0, len(circuits))
+	for _, data := range circuits {
+		releases = append(releases, data.Name+"="+data.ReleaseId)
+	}
+	return strings.Join(releases, ", ")
+}
+
+// validateCircuits runs validate on every circuit and returns the first
+// error, prefixed with the circuit name when several are loaded.
+func (s *State) validateCircuits(validate func(*circuitData.CircuitData) error) error {
+	circuits := s.sortedCircuits()
+	if len(circuits) == 0 {
+		return fmt.Errorf("no circuit is loaded")
+	}
+	for _, data := range circuits {
+		if err := validate(&data); err != nil {
+			if len(circuits) > 1 {
+				return fmt.Errorf("circuit %s: %w", data.Name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
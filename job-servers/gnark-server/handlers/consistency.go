@@ -0,0 +1,726 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gnark-server/redisconfig"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Classes of inconsistencies found by CheckConsistency.
+const (
+	// IssueDanglingQueueEntry is a queued job ID whose metadata and input are
+	// gone, so no worker can prove it.
+	IssueDanglingQueueEntry = "dangling_queue_entry"
+	// IssueDanglingProcessingEntry is a job ID in the processing list whose
+	// metadata is gone. Recovery decides what happens to it.
+	IssueDanglingProcessingEntry = "dangling_processing_entry"
+	// IssueUnindexedJob is a queued job that is in no queue, or a proving
+	// job that is not in the processing list and has no lease.
+	IssueUnindexedJob = "unindexed_job"
+	// IssueCounterDrift is a recordSizeBytes counter of a job that does not
+	// match the size of its list.
+	IssueCounterDrift = "counter_drift"
+	// IssueDanglingIdempotencyKey and IssueDanglingInflightKey are claims
+	// that map to a job with no records left.
+	IssueDanglingIdempotencyKey = "dangling_idempotency_key"
+	IssueDanglingInflightKey    = "dangling_inflight_key"
+	// IssueUnarchivedTombstone is an expired job that never reached the
+	// mirror. Its records are gone, so it can only be reported.
+	IssueUnarchivedTombstone = "unarchived_tombstone"
+
+	DefaultConsistencyBatchSize = 200
+)
+
+// repairableIssues are the classes that are fixed with Repair set. Each
+// repair is a script that checks the inconsistency again before fixing it,
+// so that it cannot undo a concurrent write. The other classes need a
+// decision, for example whether to queue a job again, and are only reported.
+var repairableIssues = map[string]bool{
+	IssueDanglingQueueEntry:     true,
+	IssueCounterDrift:           true,
+	IssueDanglingIdempotencyKey: true,
+	IssueDanglingInflightKey:    true,
+}
+
+// perJobListKeys maps the per-job lists whose size is counted in the job
+// metadata to their keys.
+var perJobListKeys = map[string]func(jobId string) string{
+	listCallbackAttempts: getRedisCallbackAttemptsKey,
+}
+
+// removeDanglingQueueEntryScript removes ARGV[1] from the queue KEYS[1], a
+// sorted set or the legacy list, if the metadata KEYS[2] and input KEYS[3]
+// of the job still do not exist. It returns the number of entries removed.
+var removeDanglingQueueEntryScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[2]) == 1 or redis.call('EXISTS', KEYS[3]) == 1 then
+  return 0
+end
+if redis.call('TYPE', KEYS[1])['ok'] == 'zset' then
+  return redis.call('ZREM', KEYS[1], ARGV[1])
+end
+return redis.call('LREM', KEYS[1], 0, ARGV[1])
+`)
+
+// listSizeScript returns the total size in bytes of the list KEYS[1], as
+// boundedAppendScript counts it.
+var listSizeScript = redis.NewScript(`
+local size = 0
+for _, item in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+  size = size + #item
+end
+return size
+`)
+
+// resetListSizeScript sets the counter ARGV[1] in the metadata KEYS[1] to
+// the size of the list KEYS[2], if the metadata still exists. It returns the
+// size, or -1 if the job is gone.
+var resetListSizeScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  return -1
+end
+local size = 0
+for _, item in ipairs(redis.call('LRANGE', KEYS[2], 0, -1)) do
+  size = size + #item
+end
+redis.call('HSET', KEYS[1], ARGV[1], size)
+return size
+`)
+
+// removeDanglingClaimScript deletes the claim KEYS[1] if it still maps to
+// the job ARGV[1] and the metadata, result and expired marker of the job,
+// KEYS[2] to KEYS[4], still do not exist.
+var removeDanglingClaimScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+  return 0
+end
+if redis.call('EXISTS', KEYS[2], KEYS[3], KEYS[4]) > 0 then
+  return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+// ConsistencyOptions configures CheckConsistency.
+type ConsistencyOptions struct {
+	// Repair fixes the issues of the repairable classes.
+	Repair bool
+	// BatchSize is the number of keys or entries read per Redis call. Zero
+	// means DefaultConsistencyBatchSize.
+	BatchSize int64
+	// BatchInterval is the minimum time between two Redis calls, which
+	// bounds the load the check puts on a live server.
+	BatchInterval time.Duration
+	// Grace is how long an issue must persist before it is reported, so that
+	// records in the middle of a write are not mistaken for drift.
+	Grace time.Duration
+	// Mirrored returns which of the jobs have a row in the mirror. When nil,
+	// tombstones are not checked.
+	Mirrored func(ctx context.Context, jobIds []string) (map[string]bool, error)
+}
+
+// ConsistencyIssue is one inconsistency.
+type ConsistencyIssue struct {
+	Class      string `json:"class"`
+	Key        string `json:"key"`
+	JobId      string `json:"jobId,omitempty"`
+	Detail     string `json:"detail"`
+	Repairable bool   `json:"repairable"`
+	Repaired   bool   `json:"repaired"`
+}
+
+// ConsistencyReport is the result of CheckConsistency.
+type ConsistencyReport struct {
+	// Checked is the number of entries examined, by index or key pattern.
+	Checked map[string]int64   `json:"checked"`
+	Issues  []ConsistencyIssue `json:"issues"`
+}
+
+// Unresolved returns the number of issues that were not repaired.
+func (r *ConsistencyReport) Unresolved() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if !issue.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// consistencyCandidate is an issue found by the scan. still checks it again
+// after the grace period, and repair fixes it and reports whether anything
+// changed.
+type consistencyCandidate struct {
+	issue  ConsistencyIssue
+	still  func(ctx context.Context) (bool, error)
+	repair func(ctx context.Context) (bool, error)
+}
+
+type consistencyCheck struct {
+	s          *State
+	opts       ConsistencyOptions
+	report     *ConsistencyReport
+	candidates []consistencyCandidate
+	lastCall   time.Time
+}
+
+// CheckConsistency scans the job records, queues, counters and claims in
+// Redis for inconsistencies between them. It only reads in batches of
+// SCAN, ZSCAN and LRANGE calls paced by BatchInterval, never with blocking
+// commands, so it can run against a live server. Issues found are checked
+// again after Grace and only reported if they persist.
+func (s *State) CheckConsistency(ctx context.Context, opts ConsistencyOptions) (*ConsistencyReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultConsistencyBatchSize
+	}
+	c := &consistencyCheck{s: s, opts: opts, report: &ConsistencyReport{Checked: map[string]int64{}, Issues: []ConsistencyIssue{}}}
+	indexed, err := c.scanQueues(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.scanJobs(ctx, indexed); err != nil {
+		return nil, err
+	}
+	for prefix, class := range map[string]string{
+		redisIdempotencyKeyPrefix: IssueDanglingIdempotencyKey,
+		redisInflightKeyPrefix:    IssueDanglingInflightKey,
+	} {
+		if err := c.scanClaims(ctx, prefix, class); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Mirrored != nil {
+		if err := c.scanTombstones(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.candidates) > 0 && opts.Grace > 0 {
+		select {
+		case <-time.After(opts.Grace):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	for _, candidate := range c.candidates {
+		if err := c.pace(ctx); err != nil {
+			return nil, err
+		}
+		still, err := candidate.still(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !still {
+			continue
+		}
+		issue := candidate.issue
+		issue.Repairable = repairableIssues[issue.Class]
+		if opts.Repair && issue.Repairable {
+			if issue.Repaired, err = candidate.repair(ctx); err != nil {
+				return nil, fmt.Errorf("failed to repair %s %s: %w", issue.Class, issue.Key, err)
+			}
+		}
+		c.report.Issues = append(c.report.Issues, issue)
+	}
+	sort.SliceStable(c.report.Issues, func(i, j int) bool {
+		a, b := c.report.Issues[i], c.report.Issues[j]
+		if a.Class != b.Class {
+			return a.Class < b.Class
+		}
+		return a.Key+a.JobId < b.Key+b.JobId
+	})
+	return c.report, nil
+}
+
+// pace waits until BatchInterval has passed since the previous Redis call.
+func (c *consistencyCheck) pace(ctx context.Context) error {
+	if wait := time.Until(c.lastCall.Add(c.opts.BatchInterval)); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c.lastCall = time.Now()
+	return ctx.Err()
+}
+
+func (c *consistencyCheck) add(issue ConsistencyIssue, still func(context.Context) (bool, error), repair func(context.Context) (bool, error)) {
+	c.candidates = append(c.candidates, consistencyCandidate{issue: issue, still: still, repair: repair})
+}
+
+// jobRecordsExist reports, for each job, whether its metadata, result or
+// expired marker exists.
+func (c *consistencyCheck) jobRecordsExist(ctx context.Context, jobIds []string) ([]bool, error) {
+	if err := c.pace(ctx); err != nil {
+		return nil, err
+	}
+	pipe := c.s.RedisClient.Pipeline()
+	cmds := make([]*redis.IntCmd, len(jobIds))
+	for i, jobId := range jobIds {
+		cmds[i] = pipe.Exists(ctx, getRedisMetaKey(jobId), getRedisKey(jobId), getRedisExpiredKey(jobId))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	exist := make([]bool, len(jobIds))
+	for i, cmd := range cmds {
+		exist[i] = cmd.Val() > 0
+	}
+	return exist, nil
+}
+
+// unprovable reports whether the metadata and input of a job are both gone.
+func (c *consistencyCheck) unprovable(ctx context.Context, jobId string) (bool, error) {
+	n, err := c.s.RedisClient.Exists(ctx, getRedisMetaKey(jobId), getRedisInputKey(jobId)).Result()
+	return n == 0, err
+}
+
+// listContains walks the list key in batches looking for value.
+func (c *consistencyCheck) listContains(ctx context.Context, key string, value string) (bool, error) {
+	for start := int64(0); ; start += c.opts.BatchSize {
+		if err := c.pace(ctx); err != nil {
+			return false, err
+		}
+		entries, err := c.s.RedisClient.LRange(ctx, key, start, start+c.opts.BatchSize-1).Result()
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range entries {
+			if entry == value {
+				return true, nil
+			}
+		}
+		if int64(len(entries)) < c.opts.BatchSize {
+			return false, nil
+		}
+	}
+}
+
+// scanQueues looks for queue and processing entries of jobs that are gone
+// and returns the IDs of every job found in the lists, which are not
+// indexed by job.
+func (c *consistencyCheck) scanQueues(ctx context.Context) (map[string]bool, error) {
+	rdb := c.s.RedisClient
+	for _, queueKey := range redisQueueKeys() {
+		queueKey := queueKey
+		var cursor uint64
+		for {
+			if err := c.pace(ctx); err != nil {
+				return nil, err
+			}
+			entries, next, err := rdb.ZScan(ctx, queueKey, cursor, "", c.opts.BatchSize).Result()
+			if err != nil {
+				return nil, err
+			}
+			// ZSCAN returns members and scores in turn.
+			jobIds := make([]string, 0, len(entries)/2)
+			for i := 0; i < len(entries); i += 2 {
+				jobIds = append(jobIds, entries[i])
+			}
+			if err := c.checkQueued(ctx, queueKey, jobIds); err != nil {
+				return nil, err
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+
+	indexed := map[string]bool{}
+	for _, listKey := range []string{redisLegacyQueueKey, redisProcessingKey} {
+		listKey := listKey
+		for start := int64(0); ; start += c.opts.BatchSize {
+			if err := c.pace(ctx); err != nil {
+				return nil, err
+			}
+			jobIds, err := rdb.LRange(ctx, listKey, start, start+c.opts.BatchSize-1).Result()
+			if err != nil {
+				return nil, err
+			}
+			for _, jobId := range jobIds {
+				indexed[jobId] = true
+			}
+			if listKey == redisLegacyQueueKey {
+				err = c.checkQueued(ctx, listKey, jobIds)
+			} else {
+				err = c.checkProcessing(ctx, jobIds)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if int64(len(jobIds)) < c.opts.BatchSize {
+				break
+			}
+		}
+	}
+	return indexed, nil
+}
+
+func (c *consistencyCheck) checkQueued(ctx context.Context, queueKey string, jobIds []string) error {
+	c.report.Checked[queueKey] += int64(len(jobIds))
+	if len(jobIds) == 0 {
+		return nil
+	}
+	if err := c.pace(ctx); err != nil {
+		return err
+	}
+	pipe := c.s.RedisClient.Pipeline()
+	cmds := make([]*redis.IntCmd, len(jobIds))
+	for i, jobId := range jobIds {
+		cmds[i] = pipe.Exists(ctx, getRedisMetaKey(jobId), getRedisInputKey(jobId))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	for i, jobId := range jobIds {
+		if cmds[i].Val() > 0 {
+			continue
+		}
+		jobId := jobId
+		c.add(ConsistencyIssue{
+			Class:  IssueDanglingQueueEntry,
+			Key:    queueKey,
+			JobId:  jobId,
+			Detail: "job has no metadata and no input",
+		}, func(ctx context.Context) (bool, error) {
+			return c.unprovable(ctx, jobId)
+		}, func(ctx context.Context) (bool, error) {
+			n, err := removeDanglingQueueEntryScript.Run(ctx, c.s.RedisClient,
+				[]string{queueKey, getRedisMetaKey(jobId), getRedisInputKey(jobId)}, jobId).Int64()
+			return n > 0, err
+		})
+	}
+	return nil
+}
+
+func (c *consistencyCheck) checkProcessing(ctx context.Context, jobIds []string) error {
+	c.report.Checked[redisProcessingKey] += int64(len(jobIds))
+	if len(jobIds) == 0 {
+		return nil
+	}
+	if err := c.pace(ctx); err != nil {
+		return err
+	}
+	pipe := c.s.RedisClient.Pipeline()
+	cmds := make([]*redis.IntCmd, len(jobIds))
+	for i, jobId := range jobIds {
+		cmds[i] = pipe.Exists(ctx, getRedisMetaKey(jobId))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	for i, jobId := range jobIds {
+		if cmds[i].Val() > 0 {
+			continue
+		}
+		jobId := jobId
+		c.add(ConsistencyIssue{
+			Class:  IssueDanglingProcessingEntry,
+			Key:    redisProcessingKey,
+			JobId:  jobId,
+			Detail: "job in the processing list has no metadata",
+		}, func(ctx context.Context) (bool, error) {
+			n, err := c.s.RedisClient.Exists(ctx, getRedisMetaKey(jobId)).Result()
+			if err != nil || n > 0 {
+				return false, err
+			}
+			return c.listContains(ctx, redisProcessingKey, jobId)
+		}, nil)
+	}
+	return nil
+}
+
+// scanJobs checks that queued and proving jobs are indexed and that the size
+// counters of their lists are right.
+func (c *consistencyCheck) scanJobs(ctx context.Context, indexed map[string]bool) error {
+	rdb, err := redisconfig.ScanClient(ctx, c.s.RedisClient)
+	if err != nil {
+		return err
+	}
+	var cursor uint64
+	for {
+		if err := c.pace(ctx); err != nil {
+			return err
+		}
+		keys, next, err := rdb.Scan(ctx, cursor, redisMetaKeyPrefix+"*", c.opts.BatchSize).Result()
+		if err != nil {
+			return err
+		}
+		c.report.Checked[redisMetaKeyPrefix+"*"] += int64(len(keys))
+		if len(keys) > 0 {
+			if err := c.pace(ctx); err != nil {
+				return err
+			}
+			pipe := c.s.RedisClient.Pipeline()
+			cmds := make([]*redis.StringStringMapCmd, len(keys))
+			for i, key := range keys {
+				cmds[i] = pipe.HGetAll(ctx, key)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return err
+			}
+			for i, key := range keys {
+				jobId := strings.TrimPrefix(key, redisMetaKeyPrefix)
+				meta := cmds[i].Val()
+				if err := c.checkIndexed(ctx, jobId, meta, indexed); err != nil {
+					return err
+				}
+				if err := c.checkCounters(ctx, jobId, meta); err != nil {
+					return err
+				}
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// isIndexed reports whether a job in state is where the workers or recovery
+// look for it.
+func (c *consistencyCheck) isIndexed(ctx context.Context, jobId string, state string, indexed map[string]bool) (bool, error) {
+	rdb := c.s.RedisClient
+	if indexed != nil && indexed[jobId] {
+		return true, nil
+	}
+	if err := c.pace(ctx); err != nil {
+		return false, err
+	}
+	switch state {
+	case jobStateQueued:
+		pipe := rdb.Pipeline()
+		var cmds []*redis.FloatCmd
+		for _, queueKey := range redisQueueKeys() {
+			cmds = append(cmds, pipe.ZScore(ctx, queueKey, jobId))
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return false, err
+		}
+		for _, cmd := range cmds {
+			if cmd.Err() == nil {
+				return true, nil
+			}
+		}
+	case jobStateProving:
+		n, err := rdb.Exists(ctx, getRedisLeaseKey(jobId)).Result()
+		if err != nil || n > 0 {
+			return n > 0, err
+		}
+	default:
+		return true, nil
+	}
+	if indexed != nil {
+		return false, nil
+	}
+	// Rechecking: the lists may have changed since they were read.
+	for _, listKey := range []string{redisLegacyQueueKey, redisProcessingKey} {
+		found, err := c.listContains(ctx, listKey, jobId)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+func (c *consistencyCheck) checkIndexed(ctx context.Context, jobId string, meta map[string]string, indexed map[string]bool) error {
+	state := meta[metaState]
+	if state != jobStateQueued && state != jobStateProving {
+		return nil
+	}
+	ok, err := c.isIndexed(ctx, jobId, state, indexed)
+	if err != nil || ok {
+		return err
+	}
+	detail := "queued job is in no queue"
+	if state == jobStateProving {
+		detail = "proving job is not in the processing list and has no lease"
+	}
+	c.add(ConsistencyIssue{
+		Class:  IssueUnindexedJob,
+		Key:    getRedisMetaKey(jobId),
+		JobId:  jobId,
+		Detail: detail,
+	}, func(ctx context.Context) (bool, error) {
+		current, err := c.s.RedisClient.HGet(ctx, getRedisMetaKey(jobId), metaState).Result()
+		if err == redis.Nil || current != state {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		ok, err := c.isIndexed(ctx, jobId, state, nil)
+		return !ok, err
+	}, nil)
+	return nil
+}
+
+func (c *consistencyCheck) checkCounters(ctx context.Context, jobId string, meta map[string]string) error {
+	for field, value := range meta {
+		list, ok := strings.CutPrefix(field, metaRecordSizeBytes+".")
+		if !ok {
+			continue
+		}
+		listKey, known := perJobListKeys[list]
+		if !known {
+			continue
+		}
+		stored, _ := strconv.ParseInt(value, 10, 64)
+		if err := c.pace(ctx); err != nil {
+			return err
+		}
+		actual, err := listSizeScript.Run(ctx, c.s.RedisClient, []string{listKey(jobId)}).Int64()
+		if err != nil {
+			return err
+		}
+		if stored == actual {
+			continue
+		}
+		field, metaKey, key := field, getRedisMetaKey(jobId), listKey(jobId)
+		c.add(ConsistencyIssue{
+			Class:  IssueCounterDrift,
+			Key:    metaKey,
+			JobId:  jobId,
+			Detail: fmt.Sprintf("%s is %d, the list holds %d bytes", field, stored, actual),
+		}, func(ctx context.Context) (bool, error) {
+			stored, err := c.s.RedisClient.HGet(ctx, metaKey, field).Int64()
+			if err == redis.Nil {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+			actual, err := listSizeScript.Run(ctx, c.s.RedisClient, []string{key}).Int64()
+			return stored != actual, err
+		}, func(ctx context.Context) (bool, error) {
+			size, err := resetListSizeScript.Run(ctx, c.s.RedisClient, []string{metaKey, key}, field).Int64()
+			return size >= 0, err
+		})
+	}
+	return nil
+}
+
+// scanClaims looks for idempotency or in-flight claims of jobs that are gone.
+func (c *consistencyCheck) scanClaims(ctx context.Context, prefix string, class string) error {
+	rdb, err := redisconfig.ScanClient(ctx, c.s.RedisClient)
+	if err != nil {
+		return err
+	}
+	var cursor uint64
+	for {
+		if err := c.pace(ctx); err != nil {
+			return err
+		}
+		keys, next, err := rdb.Scan(ctx, cursor, prefix+"*", c.opts.BatchSize).Result()
+		if err != nil {
+			return err
+		}
+		c.report.Checked[prefix+"*"] += int64(len(keys))
+		if len(keys) > 0 {
+			if err := c.pace(ctx); err != nil {
+				return err
+			}
+			jobIds, err := c.s.RedisClient.MGet(ctx, keys...).Result()
+			if err != nil {
+				return err
+			}
+			var claimKeys, claimJobIds []string
+			for i, value := range jobIds {
+				if jobId, ok := value.(string); ok {
+					claimKeys = append(claimKeys, keys[i])
+					claimJobIds = append(claimJobIds, jobId)
+				}
+			}
+			exist, err := c.jobRecordsExist(ctx, claimJobIds)
+			if err != nil {
+				return err
+			}
+			for i, jobId := range claimJobIds {
+				if exist[i] {
+					continue
+				}
+				key, jobId := claimKeys[i], jobId
+				c.add(ConsistencyIssue{
+					Class:  class,
+					Key:    key,
+					JobId:  jobId,
+					Detail: "claim maps to a job with no records",
+				}, func(ctx context.Context) (bool, error) {
+					current, err := c.s.RedisClient.Get(ctx, key).Result()
+					if err == redis.Nil || current != jobId {
+						return false, nil
+					} else if err != nil {
+						return false, err
+					}
+					exist, err := c.jobRecordsExist(ctx, []string{jobId})
+					return err == nil && !exist[0], err
+				}, func(ctx context.Context) (bool, error) {
+					n, err := removeDanglingClaimScript.Run(ctx, c.s.RedisClient,
+						[]string{key, getRedisMetaKey(jobId), getRedisKey(jobId), getRedisExpiredKey(jobId)}, jobId).Int64()
+					return n > 0, err
+				})
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// scanTombstones looks for expired jobs that have no row in the mirror.
+func (c *consistencyCheck) scanTombstones(ctx context.Context) error {
+	rdb, err := redisconfig.ScanClient(ctx, c.s.RedisClient)
+	if err != nil {
+		return err
+	}
+	var cursor uint64
+	for {
+		if err := c.pace(ctx); err != nil {
+			return err
+		}
+		keys, next, err := rdb.Scan(ctx, cursor, redisExpiredKeyPrefix+"*", c.opts.BatchSize).Result()
+		if err != nil {
+			return err
+		}
+		c.report.Checked[redisExpiredKeyPrefix+"*"] += int64(len(keys))
+		if len(keys) > 0 {
+			jobIds := make([]string, len(keys))
+			for i, key := range keys {
+				jobIds[i] = strings.TrimPrefix(key, redisExpiredKeyPrefix)
+			}
+			mirrored, err := c.opts.Mirrored(ctx, jobIds)
+			if err != nil {
+				return fmt.Errorf("failed to read the mirror: %w", err)
+			}
+			for _, jobId := range jobIds {
+				if mirrored[jobId] {
+					continue
+				}
+				jobId := jobId
+				c.add(ConsistencyIssue{
+					Class:  IssueUnarchivedTombstone,
+					Key:    getRedisExpiredKey(jobId),
+					JobId:  jobId,
+					Detail: "expired job has no row in the mirror",
+				}, func(ctx context.Context) (bool, error) {
+					n, err := c.s.RedisClient.Exists(ctx, getRedisExpiredKey(jobId)).Result()
+					if err != nil || n == 0 {
+						return false, err
+					}
+					mirrored, err := c.opts.Mirrored(ctx, []string{jobId})
+					return err == nil && !mirrored[jobId], err
+				}, nil)
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
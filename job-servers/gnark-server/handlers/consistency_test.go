@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gnark-server/webhook"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// issueKey identifies an issue in a report.
+func issueKey(issue ConsistencyIssue) string {
+	return issue.Class + " " + issue.Key + " " + issue.JobId
+}
+
+// checkConsistency runs the check with small batches and returns its issues
+// by issueKey.
+func checkConsistency(t *testing.T, s *State, repair bool, mirrored map[string]bool) map[string]ConsistencyIssue {
+	t.Helper()
+	report, err := s.CheckConsistency(context.Background(), ConsistencyOptions{
+		Repair:    repair,
+		BatchSize: 2,
+		Mirrored: func(ctx context.Context, jobIds []string) (map[string]bool, error) {
+			return mirrored, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("CheckConsistency() = %v", err)
+	}
+	issues := make(map[string]ConsistencyIssue, len(report.Issues))
+	for _, issue := range report.Issues {
+		issues[issueKey(issue)] = issue
+	}
+	if len(issues) != len(report.Issues) {
+		t.Fatalf("issues reported twice: %+v", report.Issues)
+	}
+	return issues
+}
+
+func TestCheckConsistency(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newProvingTestState(t)
+	rdb := s.RedisClient
+
+	// A consistent namespace: a queued, a proving and a finished job with
+	// its claims, and an archived tombstone.
+	claimJob(t, s, s.leaseHolder())
+	queued := startProofs(t, s, []ProofRequest{withPublicInputs(t, 7, 1)})[0].JobId
+	if queued == nil {
+		t.Fatal("start-proofs queued no job")
+	}
+	finished := addFinishedJob(t, s, jobStateDone, testProofRequest(t))
+	if err := s.recordCallbackAttempt(ctx, finished, webhook.Attempt{Attempt: 1, Time: time.Now(), StatusCode: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rdb.Set(ctx, redisIdempotencyKeyPrefix+"client:key", finished, time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+	archived := uuid.NewString()
+	if err := rdb.Set(ctx, getRedisExpiredKey(archived), "{}", time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+	mirrored := map[string]bool{archived: true}
+	if issues := checkConsistency(t, s, false, mirrored); len(issues) != 0 {
+		t.Fatalf("issues in a consistent namespace: %v", issues)
+	}
+
+	// One inconsistency of every class.
+	ghost, lost, stale, unarchived := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+	queueKey := redisQueueKeys()[0]
+	sizeField := metaRecordSizeBytes + "." + listCallbackAttempts
+	setup := []func(pipe redis.Pipeliner){
+		func(pipe redis.Pipeliner) { pipe.ZAdd(ctx, queueKey, &redis.Z{Score: 1, Member: ghost}) },
+		func(pipe redis.Pipeliner) { pipe.RPush(ctx, redisLegacyQueueKey, ghost) },
+		func(pipe redis.Pipeliner) { pipe.RPush(ctx, redisProcessingKey, ghost) },
+		func(pipe redis.Pipeliner) { pipe.HSet(ctx, getRedisMetaKey(lost), metaState, jobStateQueued) },
+		func(pipe redis.Pipeliner) { pipe.HSet(ctx, getRedisMetaKey(stale), metaState, jobStateProving) },
+		func(pipe redis.Pipeliner) { pipe.HSet(ctx, getRedisMetaKey(finished), sizeField, 999) },
+		func(pipe redis.Pipeliner) { pipe.Set(ctx, redisIdempotencyKeyPrefix+"client:gone", ghost, time.Hour) },
+		func(pipe redis.Pipeliner) { pipe.Set(ctx, redisInflightKeyPrefix+"digest", ghost, time.Hour) },
+		func(pipe redis.Pipeliner) { pipe.Set(ctx, getRedisExpiredKey(unarchived), "{}", time.Hour) },
+	}
+	pipe := rdb.TxPipeline()
+	for _, f := range setup {
+		f(pipe)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ConsistencyIssue{
+		{Class: IssueDanglingQueueEntry, Key: queueKey, JobId: ghost, Repairable: true},
+		{Class: IssueDanglingQueueEntry, Key: redisLegacyQueueKey, JobId: ghost, Repairable: true},
+		{Class: IssueDanglingProcessingEntry, Key: redisProcessingKey, JobId: ghost},
+		{Class: IssueUnindexedJob, Key: getRedisMetaKey(lost), JobId: lost},
+		{Class: IssueUnindexedJob, Key: getRedisMetaKey(stale), JobId: stale},
+		{Class: IssueCounterDrift, Key: getRedisMetaKey(finished), JobId: finished, Repairable: true},
+		{Class: IssueDanglingIdempotencyKey, Key: redisIdempotencyKeyPrefix + "client:gone", JobId: ghost, Repairable: true},
+		{Class: IssueDanglingInflightKey, Key: redisInflightKeyPrefix + "digest", JobId: ghost, Repairable: true},
+		{Class: IssueUnarchivedTombstone, Key: getRedisExpiredKey(unarchived), JobId: unarchived},
+	}
+	issues := checkConsistency(t, s, false, mirrored)
+	if len(issues) != len(want) {
+		t.Fatalf("found %d issues, want %d: %v", len(issues), len(want), issues)
+	}
+	for _, w := range want {
+		issue, ok := issues[issueKey(w)]
+		if !ok {
+			t.Fatalf("%s was not detected, found %v", issueKey(w), issues)
+		}
+		if issue.Repairable != w.Repairable || issue.Repaired || issue.Detail == "" {
+			t.Fatalf("issue %+v, want repairable %v and not repaired", issue, w.Repairable)
+		}
+	}
+
+	// Repair fixes the safe classes only.
+	issues = checkConsistency(t, s, true, mirrored)
+	for _, w := range want {
+		if issue := issues[issueKey(w)]; issue.Repaired != w.Repairable {
+			t.Fatalf("issue %+v after repair, want repaired %v", issue, w.Repairable)
+		}
+	}
+	issues = checkConsistency(t, s, false, mirrored)
+	for _, w := range want {
+		if _, found := issues[issueKey(w)]; found == w.Repairable {
+			t.Fatalf("after repair, %s found: %v", issueKey(w), found)
+		}
+	}
+	if size, err := rdb.HGet(ctx, getRedisMetaKey(finished), sizeField).Int64(); err != nil || size == 999 {
+		t.Fatalf("%s after repair = %d, %v", sizeField, size, err)
+	}
+	if n, err := rdb.Exists(ctx, redisIdempotencyKeyPrefix+"client:key").Result(); err != nil || n != 1 {
+		t.Fatal("the repair removed the claim of an existing job")
+	}
+	if jobs := queuedJobs(t, s); len(jobs) != 1 || jobs[0] != *queued {
+		t.Fatalf("queued jobs after repair = %v, want [%s]", jobs, *queued)
+	}
+}
+
+func TestCheckConsistencyGrace(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestState(t)
+	ghost := uuid.NewString()
+	if err := s.RedisClient.ZAdd(ctx, redisQueueKeys()[0], &redis.Z{Score: 1, Member: ghost}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	// The job is written while the check waits out its grace period, as a
+	// submission racing the scan would.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		s.RedisClient.HSet(ctx, getRedisMetaKey(ghost), metaState, jobStateQueued)
+	}()
+	report, err := s.CheckConsistency(ctx, ConsistencyOptions{Repair: true, Grace: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 0 {
+		t.Fatalf("issues resolved within the grace period were reported: %+v", report.Issues)
+	}
+	if score, err := s.RedisClient.ZScore(ctx, redisQueueKeys()[0], ghost).Result(); err != nil || score != 1 {
+		t.Fatalf("the queue entry of a job written during the grace period was removed: %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	redisRecentJobsKey = "gnark_recent_jobs"
+	// recentJobsLimit is how many finished jobs the dashboard can list and
+	// compute throughput from, across all instances.
+	recentJobsLimit   = 500
+	throughputWindow  = time.Hour
+	dashboardTimeout  = 2 * time.Second
+	unknownClientName = "(none)"
+)
+
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+// DashboardHandler serves the embedded dashboard page under /dashboard/.
+func DashboardHandler() http.Handler {
+	assets, err := fs.Sub(dashboardAssets, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(assets)))
+}
+
+// RecentJob is a finished job as listed on the dashboard.
+type RecentJob struct {
+	JobId           string    `json:"jobId"`
+	Client          string    `json:"client,omitempty"`
+	Success         bool      `json:"success"`
+	ErrorCode       string    `json:"errorCode,omitempty"`
+	ProveDurationMs *int64    `json:"proveDurationMs,omitempty"`
+	CacheHit        bool      `json:"cacheHit,omitempty"`
+	CircuitRelease  string    `json:"circuitRelease,omitempty"`
+	FinishedAt      time.Time `json:"finishedAt"`
+}
+
+// recordRecentJob adds a finished job to the capped list the dashboard
+// reads.
+func (s *State) recordRecentJob(ctx context.Context, jobId string, response ProofResponse, meta map[string]string) {
+	job := RecentJob{
+		JobId:          jobId,
+		Client:         meta[metaClient],
+		Success:        response.Success,
+		CacheHit:       meta[metaCacheHit] == "true",
+		CircuitRelease: response.CircuitRelease,
+		FinishedAt:     time.Now().UTC(),
+	}
+	if response.ErrorCode != nil {
+		job.ErrorCode = *response.ErrorCode
+	}
+	if ms, err := strconv.ParseInt(meta[metaProveDurationMs], 10, 64); err == nil {
+		job.ProveDurationMs = &ms
+	}
+	jobJSON, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+	pipe := s.RedisClient.TxPipeline()
+	pipe.LPush(ctx, redisRecentJobsKey, jobJSON)
+	pipe.LTrim(ctx, redisRecentJobsKey, 0, recentJobsLimit-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to record job %s in the recent jobs: %v\n", jobId, err)
+	}
+}
+
+func (s *State) recentJobs(ctx context.Context) ([]RecentJob, error) {
+	entries, err := s.RedisClient.LRange(ctx, redisRecentJobsKey, 0, recentJobsLimit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]RecentJob, 0, len(entries))
+	for _, entry := range entries {
+		var job RecentJob
+		if err := json.Unmarshal([]byte(entry), &job); err == nil {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// ClientThroughput counts the jobs of one API key that finished within the
+// throughput window.
+type ClientThroughput struct {
+	Client    string `json:"client"`
+	Succeeded int    `json:"succeeded"`
+	Failed    int    `json:"failed"`
+	CacheHits int    `json:"cacheHits"`
+}
+
+// throughput groups the jobs finished since `since` by API key label, busiest
+// first.
+func throughput(jobs []RecentJob, since time.Time) []ClientThroughput {
+	byClient := map[string]*ClientThroughput{}
+	for _, job := range jobs {
+		if job.FinishedAt.Before(since) {
+			continue
+		}
+		client := job.Client
+		if client == "" {
+			client = unknownClientName
+		}
+		t := byClient[client]
+		if t == nil {
+			t = &ClientThroughput{Client: client}
+			byClient[client] = t
+		}
+		if job.Success {
+			t.Succeeded++
+		} else {
+			t.Failed++
+		}
+		if job.CacheHit {
+			t.CacheHits++
+		}
+	}
+	result := make([]ClientThroughput, 0, len(byClient))
+	for _, t := range byClient {
+		result = append(result, *t)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].Succeeded+result[i].Failed, result[j].Succeeded+result[j].Failed
+		if a != b {
+			return a > b
+		}
+		return result[i].Client < result[j].Client
+	})
+	return result
+}
+
+type DashboardQueue struct {
+	// Depth is the number of queued and running jobs across all instances,
+	// or nil if Redis could not be read.
+	Depth          *int64 `json:"depth"`
+	MaxQueueLength *int64 `json:"maxQueueLength"`
+	ActiveWorkers  int64  `json:"activeWorkers"`
+	// Shard is the shard of this instance, or nil if it is not sharded.
+	Shard *ShardStats `json:"shard,omitempty"`
+}
+
+type DashboardSummary struct {
+	GeneratedAt    time.Time `json:"generatedAt"`
+	CircuitRelease string    `json:"circuitRelease"`
+	Backend        string    `json:"backend"`
+	VerifyOnly     bool      `json:"verifyOnly"`
+	Stopping       bool      `json:"stopping"`
+
+	Queue           DashboardQueue `json:"queue"`
+	ProveDuration   *DurationStats `json:"proveDuration"`
+	EndToEndLatency *DurationStats `json:"endToEndLatency"`
+
+	Readiness        ReadinessResponse     `json:"readiness"`
+	ReadinessHistory []ReadinessTransition `json:"readinessHistory"`
+
+	// ThroughputWindowMinutes is the window Throughput covers. Only the last
+	// recentJobsLimit jobs are counted.
+	ThroughputWindowMinutes int                `json:"throughputWindowMinutes"`
+	Throughput              []ClientThroughput `json:"throughput"`
+}
+
+// DashboardSummaryHandler serves GET /dashboard/api/summary: the queue,
+// duration percentiles, readiness and its history, and per API key
+// throughput over the last hour.
+func (s *State) DashboardSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), dashboardTimeout)
+	defer cancel()
+	now := time.Now()
+	resp := DashboardSummary{
+		GeneratedAt:             now.UTC(),
+		CircuitRelease:          s.circuitReleases(),
+		VerifyOnly:              s.VerifyOnly,
+		Stopping:                s.isStopping(),
+		Queue:                   DashboardQueue{ActiveWorkers: s.activeJobs.Load(), Shard: s.shardStats()},
+		ProveDuration:           s.proveDurations.stats(),
+		EndToEndLatency:         s.endToEndLatencies.stats(),
+		Readiness:               s.checkReadiness(ctx),
+		ThroughputWindowMinutes: int(throughputWindow / time.Minute),
+		Throughput:              []ClientThroughput{},
+	}
+	resp.Backend = s.backendName()
+	resp.ReadinessHistory, _ = s.readinessHistory.snapshot()
+	if depth, err := s.queueDepth(ctx); err == nil {
+		resp.Queue.Depth = &depth
+	}
+	if s.MaxQueueLength > 0 {
+		resp.Queue.MaxQueueLength = &s.MaxQueueLength
+	}
+	if jobs, err := s.recentJobs(ctx); err != nil {
+		log.Println("Failed to read recent jobs:", err)
+	} else {
+		resp.Throughput = throughput(jobs, now.Add(-throughputWindow))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RunningJob is a job being proved on this instance.
+type RunningJob struct {
+	JobId  string `json:"jobId"`
+	Client string `json:"client,omitempty"`
+	Stage  string `json:"stage"`
+	// StageUpdatedAt is when the job entered Stage.
+	StageUpdatedAt string `json:"stageUpdatedAt,omitempty"`
+}
+
+type DashboardJobs struct {
+	Running []RunningJob `json:"running"`
+	Recent  []RecentJob  `json:"recent"`
+}
+
+// DashboardJobsHandler serves GET /dashboard/api/jobs: the jobs this instance
+// is proving and the most recently finished jobs of all instances, newest
+// first. limit bounds the number of finished jobs and defaults to 50.
+func (s *State) DashboardJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > recentJobsLimit {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest,
+				"limit must be between 1 and "+strconv.Itoa(recentJobsLimit))
+			return
+		}
+		limit = n
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), dashboardTimeout)
+	defer cancel()
+
+	resp := DashboardJobs{Running: []RunningJob{}, Recent: []RecentJob{}}
+	s.running.Range(func(key, _ any) bool {
+		jobId := key.(string)
+		job := RunningJob{JobId: jobId}
+		if meta, err := s.getJobMetadata(ctx, jobId); err == nil {
+			job.Client = meta[metaClient]
+			job.Stage = meta[metaStage]
+			job.StageUpdatedAt = meta[metaStageUpdatedAt]
+		}
+		resp.Running = append(resp.Running, job)
+		return true
+	})
+	sort.Slice(resp.Running, func(i, j int) bool { return resp.Running[i].JobId < resp.Running[j].JobId })
+	jobs, err := s.recentJobs(ctx)
+	if err != nil {
+		log.Println("Failed to read recent jobs:", err)
+		writeInternalError(w)
+		return
+	}
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	resp.Recent = append(resp.Recent, jobs...)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
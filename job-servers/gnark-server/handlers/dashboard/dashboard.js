@@ -0,0 +1,114 @@
+// The dashboard polls the admin JSON endpoints and renders them into the
+// tables of index.html. Values are inserted as text, never as HTML.
+"use strict";
+
+const refreshInterval = 5000;
+
+function byId(id) {
+  return document.getElementById(id);
+}
+
+function setText(id, text) {
+  byId(id).textContent = text;
+}
+
+function ms(value) {
+  if (value === null || value === undefined) {
+    return "-";
+  }
+  return value < 1000 ? value + " ms" : (value / 1000).toFixed(1) + " s";
+}
+
+function percentiles(stats) {
+  return stats ? [stats.p50Ms, stats.p90Ms, stats.p99Ms].map(ms).join(" / ") : "-";
+}
+
+function time(value) {
+  return value ? new Date(value).toLocaleTimeString() : "-";
+}
+
+function fillTable(id, rows, cells) {
+  const body = byId(id);
+  body.replaceChildren();
+  for (const row of rows) {
+    const tr = document.createElement("tr");
+    for (const cell of cells(row)) {
+      const td = document.createElement("td");
+      if (cell && cell.className) {
+        td.className = cell.className;
+        td.textContent = cell.text;
+      } else {
+        td.textContent = cell;
+      }
+      tr.appendChild(td);
+    }
+    body.appendChild(tr);
+  }
+}
+
+function status(value) {
+  return { text: value, className: value };
+}
+
+function renderSummary(summary) {
+  setText("release", "release " + summary.circuitRelease + " (" + summary.backend + ")");
+  const badge = byId("status");
+  let overall = summary.readiness.status;
+  if (summary.stopping) {
+    overall = "stopping";
+  } else if (summary.verifyOnly) {
+    overall = "degraded";
+  }
+  badge.textContent = summary.verifyOnly ? "verify-only" : overall;
+  badge.className = "badge " + (overall === "stopping" ? "degraded" : overall);
+  setText("updated", "updated " + time(summary.generatedAt));
+
+  const queue = summary.queue;
+  setText("queue-depth", queue.depth === null ? "unknown" : queue.depth);
+  setText("queue-max", queue.maxQueueLength === null ? "unbounded" : queue.maxQueueLength);
+  setText("queue-workers", queue.activeWorkers);
+  setText("prove-duration", percentiles(summary.proveDuration));
+  setText("end-to-end", percentiles(summary.endToEndLatency));
+
+  const checks = Object.entries(summary.readiness.checks).sort();
+  fillTable("checks", checks, ([name, check]) => [name, status(check.status), check.error || ""]);
+  const history = (summary.readinessHistory || []).slice().reverse();
+  fillTable("history", history, (t) => [time(t.time), status(t.status), (t.failing || []).join(", ")]);
+
+  setText("window", summary.throughputWindowMinutes);
+  fillTable("throughput", summary.throughput, (t) => [t.client, t.succeeded, t.failed, t.cacheHits]);
+}
+
+function renderJobs(jobs) {
+  fillTable("running", jobs.running, (j) => [j.jobId, j.client || "", j.stage || "", time(j.stageUpdatedAt)]);
+  fillTable("recent", jobs.recent, (j) => [
+    time(j.finishedAt),
+    j.jobId,
+    j.client || "",
+    j.success ? status("ok") : status("fail"),
+    j.cacheHit ? "cache hit" : j.success ? ms(j.proveDurationMs) : j.errorCode || "",
+  ]);
+}
+
+async function fetchJSON(path) {
+  const response = await fetch(path, { credentials: "same-origin", cache: "no-store" });
+  if (!response.ok) {
+    throw new Error(path + ": " + response.status);
+  }
+  return response.json();
+}
+
+async function refresh() {
+  try {
+    const [summary, jobs] = await Promise.all([fetchJSON("api/summary"), fetchJSON("api/jobs")]);
+    renderSummary(summary);
+    renderJobs(jobs);
+    byId("error").hidden = true;
+  } catch (err) {
+    setText("error", "Failed to refresh: " + err.message);
+    byId("error").hidden = false;
+  }
+}
+
+refresh();
+setInterval(refresh, refreshInterval);
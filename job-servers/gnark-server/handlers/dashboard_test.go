@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDashboardHandlerServesAssets(t *testing.T) {
+	handler := DashboardHandler()
+	tests := []struct {
+		path            string
+		wantContentType string
+		want            string
+	}{
+		{"/dashboard/", "text/html", `<script src="dashboard.js">`},
+		{"/dashboard/dashboard.js", "javascript", `fetchJSON("api/summary")`},
+		{"/dashboard/dashboard.css", "text/css", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("GET %s: %d", tt.path, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); !strings.Contains(ct, tt.wantContentType) {
+				t.Fatalf("GET %s Content-Type = %q, want %s", tt.path, ct, tt.wantContentType)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Fatalf("GET %s does not contain %q", tt.path, tt.want)
+			}
+		})
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dashboard/missing.js", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("GET /dashboard/missing.js: %d, want 404", w.Code)
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	now := time.Now()
+	jobs := []RecentJob{
+		{Client: "indexer", Success: true, FinishedAt: now},
+		{Client: "indexer", Success: false, FinishedAt: now},
+		{Client: "indexer", Success: true, CacheHit: true, FinishedAt: now},
+		{Client: "wallet", Success: true, FinishedAt: now},
+		{Success: true, FinishedAt: now},
+		{Client: "wallet", Success: true, FinishedAt: now.Add(-2 * throughputWindow)},
+	}
+	want := []ClientThroughput{
+		{Client: "indexer", Succeeded: 2, Failed: 1, CacheHits: 1},
+		{Client: unknownClientName, Succeeded: 1},
+		{Client: "wallet", Succeeded: 1},
+	}
+	if got := throughput(jobs, now.Add(-throughputWindow)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("throughput() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDashboardAPI(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	ctx := context.Background()
+	var finished []string
+	for i, client := range []string{"indexer", "indexer", "wallet"} {
+		jobId := uuid.NewString()
+		if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), metaClient, client, metaProveDurationMs, "1500").Err(); err != nil {
+			t.Fatal(err)
+		}
+		response := ProofResponse{Success: i != 1}
+		if !response.Success {
+			errMsg, errCode := "prover failed", codeJobFailed
+			response.ErrorMessage, response.ErrorCode = &errMsg, &errCode
+		}
+		meta, err := s.getJobMetadata(ctx, jobId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.recordRecentJob(ctx, jobId, response, meta)
+		finished = append(finished, jobId)
+	}
+	startProofs(t, s, []ProofRequest{testProofRequest(t)})
+
+	var summary DashboardSummary
+	w := serve(s.DashboardSummaryHandler, http.MethodGet, "/dashboard/api/summary", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("summary %d %s: %v", w.Code, w.Body, err)
+	}
+	if summary.Queue.Depth == nil || *summary.Queue.Depth != 1 {
+		t.Fatalf("summary queue = %+v, want a depth of 1", summary.Queue)
+	}
+	wantThroughput := []ClientThroughput{{Client: "indexer", Succeeded: 1, Failed: 1}, {Client: "wallet", Succeeded: 1}}
+	if !reflect.DeepEqual(summary.Throughput, wantThroughput) {
+		t.Fatalf("summary throughput = %+v, want %+v", summary.Throughput, wantThroughput)
+	}
+	if summary.Readiness.Checks["redis"].Status != "ok" {
+		t.Fatalf("summary readiness = %+v, want Redis ok", summary.Readiness)
+	}
+
+	var jobs DashboardJobs
+	w = serve(s.DashboardJobsHandler, http.MethodGet, "/dashboard/api/jobs?limit=2", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &jobs); err != nil {
+		t.Fatalf("jobs %d %s: %v", w.Code, w.Body, err)
+	}
+	if len(jobs.Recent) != 2 || jobs.Recent[0].JobId != finished[2] || jobs.Recent[1].JobId != finished[1] {
+		t.Fatalf("recent jobs = %+v, want the last two finished, newest first", jobs.Recent)
+	}
+	if recent := jobs.Recent[1]; recent.Success || recent.ErrorCode != codeJobFailed || recent.ProveDurationMs == nil || *recent.ProveDurationMs != 1500 {
+		t.Fatalf("failed job = %+v, want its error code and prove duration", recent)
+	}
+	if len(jobs.Running) != 0 {
+		t.Fatalf("running jobs = %+v, want none", jobs.Running)
+	}
+
+	for _, target := range []string{"/dashboard/api/jobs?limit=0", "/dashboard/api/jobs?limit=501", "/dashboard/api/jobs?limit=many"} {
+		if w := serve(s.DashboardJobsHandler, http.MethodGet, target, ""); w.Code != http.StatusBadRequest {
+			t.Fatalf("GET %s: %d, want 400", target, w.Code)
+		}
+	}
+	if w := serve(s.DashboardSummaryHandler, http.MethodPost, "/dashboard/api/summary", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /dashboard/api/summary: %d, want 405", w.Code)
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	redisDeadLetterKey = "gnark_proof_dead_letter"
+	// deadLetterLimit is how many dead-lettered jobs are kept, across all
+	// instances.
+	deadLetterLimit = 500
+)
+
+var errNotDeadLettered = &RequestError{Code: codeJobNotFound, Message: "job is not in the dead-letter queue"}
+
+// DeadLetterJob is a job that was given up on because it kept crashing the
+// prover: it failed or was interrupted on its last allowed attempt, or made
+// the prover panic.
+type DeadLetterJob struct {
+	JobId        string    `json:"jobId"`
+	Client       string    `json:"client,omitempty"`
+	ErrorMessage string    `json:"errorMessage"`
+	ErrorCode    string    `json:"errorCode"`
+	Attempts     int64     `json:"attempts"`
+	DeadAt       time.Time `json:"deadAt"`
+}
+
+// DeadLetterJobsResponse is the response of GET /dead-letter-jobs, newest
+// first.
+type DeadLetterJobsResponse struct {
+	Jobs []DeadLetterJob `json:"jobs"`
+}
+
+// maxJobAttempts returns the number of runs after which a failed job is
+// moved to the dead-letter queue.
+func (s *State) maxJobAttempts() int64 {
+	if s.MaxJobAttempts > 0 {
+		return int64(s.MaxJobAttempts)
+	}
+	return DefaultMaxJobAttempts
+}
+
+// failDeadLetter fails a job that will not be run again by itself and
+// records it in the dead-letter queue, where an operator can inspect and
+// retry it.
+func (s *State) failDeadLetter(ctx context.Context, jobId string, err error) {
+	s.failJob(ctx, jobId, err)
+	s.deadLetter(ctx, jobId, err)
+}
+
+// deadLetter records a failed job in the dead-letter queue.
+func (s *State) deadLetter(ctx context.Context, jobId string, err error) {
+	meta, metaErr := s.getJobMetadata(ctx, jobId)
+	if metaErr != nil {
+		log.Printf("Failed to load metadata for job %s: %v\n", jobId, metaErr)
+	}
+	job := DeadLetterJob{
+		JobId:        jobId,
+		Client:       meta[metaClient],
+		ErrorMessage: err.Error(),
+		ErrorCode:    failureCode(err),
+		Attempts:     attemptsFromMetadata(meta),
+		DeadAt:       time.Now().UTC(),
+	}
+	jobJSON, jsonErr := json.Marshal(job)
+	if jsonErr != nil {
+		return
+	}
+	pipe := s.RedisClient.TxPipeline()
+	pipe.LPush(ctx, redisDeadLetterKey, jobJSON)
+	pipe.LTrim(ctx, redisDeadLetterKey, 0, deadLetterLimit-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to move job %s to the dead-letter queue: %v\n", jobId, err)
+		return
+	}
+	s.Metrics.deadLettered()
+	log.Println("Moved job", jobId, "to the dead-letter queue after", job.Attempts, "attempts:", job.ErrorMessage)
+}
+
+// deadLetterJobs returns the entries of the dead-letter queue, newest first,
+// with the raw entry of each so that it can be removed.
+func (s *State) deadLetterJobs(ctx context.Context) ([]DeadLetterJob, []string, error) {
+	entries, err := s.RedisClient.LRange(ctx, redisDeadLetterKey, 0, deadLetterLimit-1).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	jobs := make([]DeadLetterJob, 0, len(entries))
+	raw := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		var job DeadLetterJob
+		if err := json.Unmarshal([]byte(entry), &job); err == nil {
+			jobs = append(jobs, job)
+			raw = append(raw, entry)
+		}
+	}
+	return jobs, raw, nil
+}
+
+// retryDeadLetter queues a dead-lettered job again like retry-proof, and
+// takes it out of the dead-letter queue once it is queued.
+func (s *State) retryDeadLetter(ctx context.Context, jobId string) (int64, error) {
+	jobs, raw, err := s.deadLetterJobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var entries []string
+	for i, job := range jobs {
+		if job.JobId == jobId {
+			entries = append(entries, raw[i])
+		}
+	}
+	if len(entries) == 0 {
+		return 0, errNotDeadLettered
+	}
+	attempts, err := s.retryJob(ctx, jobId)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if err := s.RedisClient.LRem(ctx, redisDeadLetterKey, 0, entry).Err(); err != nil {
+			log.Printf("Failed to remove job %s from the dead-letter queue: %v\n", jobId, err)
+		}
+	}
+	return attempts, nil
+}
+
+// DeadLetterJobsHandler serves GET /dead-letter-jobs, the jobs given up on
+// by every instance.
+func (s *State) DeadLetterJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	jobs, _, err := s.deadLetterJobs(r.Context())
+	if err != nil {
+		log.Println("Failed to read the dead-letter queue:", err)
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeadLetterJobsResponse{Jobs: jobs})
+}
+
+// RetryDeadLetterHandler serves POST /dead-letter-jobs/retry?jobId=... It
+// requeues a dead-lettered job with its stored input.
+func (s *State) RetryDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	jobId := r.URL.Query().Get("jobId")
+	attempts, err := s.retryDeadLetter(r.Context(), jobId)
+	if err == errNotDeadLettered {
+		writeError(w, http.StatusNotFound, errNotDeadLettered.Code, errNotDeadLettered.Message)
+		return
+	}
+	writeRetryResponse(w, jobId, attempts, err)
+}
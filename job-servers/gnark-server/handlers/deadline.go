@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gnark-server/circuitData"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// DeadlinePolicyExpire fails jobs that are not expected to finish before
+	// their notAfter without proving them.
+	DeadlinePolicyExpire = "expire"
+	// DeadlinePolicyFlag proves them anyway and marks them likely late.
+	DeadlinePolicyFlag = "flag"
+
+	deadlineProceed    = "proceed"
+	deadlineLikelyLate = "likely_late"
+	deadlineExpired    = "expired"
+
+	metaNotAfter            = "notAfter"
+	metaDeadlineDecision    = "deadlineDecision"
+	metaDeadlineEstimateMs  = "deadlineEstimateMs"
+	metaDeadlineRemainingMs = "deadlineRemainingMs"
+
+	// grpcNotAfterMetadata is the request metadata key holding the notAfter
+	// of a gRPC StartProof, in RFC 3339.
+	grpcNotAfterMetadata = "not-after"
+
+	codeDeadlineExceeded = "deadline_exceeded"
+	codeInvalidNotAfter  = "invalid_not_after"
+)
+
+var errDeadlineExceeded = errors.New("the job cannot finish before its notAfter")
+
+// ValidDeadlinePolicy reports whether policy is a DEADLINE_POLICY value.
+func ValidDeadlinePolicy(policy string) bool {
+	return policy == DeadlinePolicyExpire || policy == DeadlinePolicyFlag
+}
+
+func (s *State) deadlinePolicy() string {
+	if s.DeadlinePolicy == "" {
+		return DeadlinePolicyExpire
+	}
+	return s.DeadlinePolicy
+}
+
+// validateNotAfter checks the notAfter of a request, which must be in the
+// future when it is set.
+func validateNotAfter(notAfter *time.Time, now time.Time) error {
+	if notAfter != nil && !notAfter.After(now) {
+		return &RequestError{
+			Code:    codeInvalidNotAfter,
+			Message: fmt.Sprintf("notAfter %s is not in the future", notAfter.UTC().Format(time.RFC3339)),
+		}
+	}
+	return nil
+}
+
+// parseNotAfter parses the notAfter of a gRPC submission, which is unset
+// when value is empty.
+func parseNotAfter(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	notAfter, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, &RequestError{Code: codeInvalidNotAfter, Message: fmt.Sprintf("%s must be an RFC 3339 time: %v", grpcNotAfterMetadata, err)}
+	}
+	return &notAfter, nil
+}
+
+// deadlineDecision decides whether a job due by notAfter is proved when it is
+// claimed at now and proofs of its circuit take up to estimate. A job whose
+// deadline passed is expired. One with less time left than the estimate is
+// expired or flagged likely late depending on policy, and any other job
+// proceeds. Without an estimate, only the deadline itself is checked.
+func deadlineDecision(notAfter time.Time, now time.Time, estimate time.Duration, policy string) string {
+	remaining := notAfter.Sub(now)
+	switch {
+	case remaining <= 0:
+		return deadlineExpired
+	case remaining >= estimate:
+		return deadlineProceed
+	case policy == DeadlinePolicyFlag:
+		return deadlineLikelyLate
+	default:
+		return deadlineExpired
+	}
+}
+
+// proveEstimate returns the p95 of the recent proving durations of circuit,
+// or zero until minEstimateSamples were recorded.
+func (s *State) proveEstimate(ctx context.Context, circuit string) (time.Duration, error) {
+	durations, err := s.proveDurationHistoryOf(ctx, getRedisCircuitProveDurationsKey(circuit))
+	if err != nil || len(durations) < minEstimateSamples {
+		return 0, err
+	}
+	return sortedPercentiles(durations, 95)[0], nil
+}
+
+// checkDeadline decides whether a claimed job with a notAfter is proved by
+// data, and records the decision and the estimate it was based on in the
+// job metadata. It returns false after failing a job that is expired.
+func (s *State) checkDeadline(ctx context.Context, jobId string, data *circuitData.CircuitData) bool {
+	value, err := s.RedisClient.HGet(ctx, getRedisMetaKey(jobId), metaNotAfter).Result()
+	if err == redis.Nil {
+		return true
+	} else if err != nil {
+		data.Logger().Printf("Failed to read the notAfter of job %s: %v\n", jobId, err)
+		return true
+	}
+	notAfter, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		data.Logger().Printf("Ignoring the malformed notAfter %q of job %s\n", value, jobId)
+		return true
+	}
+	estimate, err := s.proveEstimate(ctx, data.Name)
+	if err != nil {
+		data.Logger().Printf("Failed to estimate the proving time of job %s: %v\n", jobId, err)
+	}
+	now := time.Now()
+	decision := deadlineDecision(notAfter, now, estimate, s.deadlinePolicy())
+	remaining := notAfter.Sub(now)
+	fields := map[string]interface{}{
+		metaDeadlineDecision:    decision,
+		metaDeadlineEstimateMs:  estimate.Milliseconds(),
+		metaDeadlineRemainingMs: remaining.Milliseconds(),
+	}
+	if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), fields).Err(); err != nil {
+		data.Logger().Printf("Failed to record the deadline decision of job %s: %v\n", jobId, err)
+	}
+	switch decision {
+	case deadlineExpired:
+		data.Logger().Printf("Job %s expires without proving: %v left before its notAfter, proofs take up to %v\n", jobId, remaining.Round(time.Millisecond), estimate.Round(time.Millisecond))
+		s.failJob(ctx, jobId, fmt.Errorf("%w: %v left, proofs of circuit %s take up to %v", errDeadlineExceeded, remaining.Round(time.Millisecond), data.Name, estimate.Round(time.Millisecond)))
+		return false
+	case deadlineLikelyLate:
+		data.Logger().Printf("Job %s is likely late: %v left before its notAfter, proofs take up to %v\n", jobId, remaining.Round(time.Millisecond), estimate.Round(time.Millisecond))
+	}
+	return true
+}
+
+// deadlineFromMetadata fills the deadline fields of record.
+func deadlineFromMetadata(record *JobRecord, meta map[string]string) {
+	if notAfter, err := time.Parse(time.RFC3339Nano, meta[metaNotAfter]); err == nil {
+		record.NotAfter = &notAfter
+	}
+	record.DeadlineDecision = meta[metaDeadlineDecision]
+	if record.DeadlineDecision != "" {
+		record.DeadlineEstimateMs, _ = strconv.ParseInt(meta[metaDeadlineEstimateMs], 10, 64)
+	}
+}
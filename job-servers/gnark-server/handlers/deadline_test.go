@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gnark-server/circuitData"
+)
+
+func TestDeadlineDecision(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		notAfter time.Time
+		estimate time.Duration
+		policy   string
+		want     string
+	}{
+		{"enough time", now.Add(2 * time.Minute), time.Minute, DeadlinePolicyExpire, deadlineProceed},
+		{"exactly the estimate", now.Add(time.Minute), time.Minute, DeadlinePolicyExpire, deadlineProceed},
+		{"too little time, expire", now.Add(30 * time.Second), time.Minute, DeadlinePolicyExpire, deadlineExpired},
+		{"too little time, flag", now.Add(30 * time.Second), time.Minute, DeadlinePolicyFlag, deadlineLikelyLate},
+		{"passed, flag", now.Add(-time.Second), time.Minute, DeadlinePolicyFlag, deadlineExpired},
+		{"due now", now, 0, DeadlinePolicyFlag, deadlineExpired},
+		{"no estimate", now.Add(time.Second), 0, DeadlinePolicyExpire, deadlineProceed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deadlineDecision(tt.notAfter, now, tt.estimate, tt.policy); got != tt.want {
+				t.Fatalf("deadlineDecision() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// seedProveDurations records n proofs of circuit that took duration.
+func seedProveDurations(t *testing.T, s *State, circuit string, n int, duration time.Duration) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		s.recordProveDurationSample(context.Background(), fmt.Sprintf("seed-%d", i), circuit, duration)
+	}
+}
+
+func TestCheckDeadline(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       string
+		samples      int
+		left         time.Duration
+		wantProved   bool
+		wantDecision string
+		wantEstimate int64
+	}{
+		{"proceed", DeadlinePolicyExpire, minEstimateSamples, time.Hour, true, deadlineProceed, 60000},
+		{"likely late", DeadlinePolicyFlag, minEstimateSamples, 10 * time.Second, true, deadlineLikelyLate, 60000},
+		{"expired", DeadlinePolicyExpire, minEstimateSamples, 10 * time.Second, false, deadlineExpired, 60000},
+		{"without a baseline", DeadlinePolicyExpire, minEstimateSamples - 1, 10 * time.Second, true, deadlineProceed, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, _ := newProvingTestState(t)
+			s.DeadlinePolicy = tt.policy
+			seedProveDurations(t, s, circuitData.DefaultCircuit, tt.samples, time.Minute)
+			// Another circuit proves slower, which must not count.
+			seedProveDurations(t, s, "slow", minEstimateSamples, time.Hour)
+
+			input := testProofRequest(t)
+			notAfter := time.Now().Add(tt.left)
+			input.NotAfter = &notAfter
+			result := startProofs(t, s, []ProofRequest{input})[0]
+			if result.JobId == nil {
+				t.Fatalf("start-proofs: %s", *result.ErrorMessage)
+			}
+			jobId := *result.JobId
+			if _, err := s.dequeueJob(ctx); err != nil {
+				t.Fatal(err)
+			}
+			data := s.Circuits[circuitData.DefaultCircuit]
+			if proved := s.checkDeadline(ctx, jobId, &data); proved != tt.wantProved {
+				t.Fatalf("checkDeadline() = %v, want %v", proved, tt.wantProved)
+			}
+
+			response, err := s.getProof(ctx, jobId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := response.Job
+			if job == nil || job.NotAfter == nil || !job.NotAfter.Equal(notAfter) {
+				t.Fatalf("get-proof job = %+v, want notAfter %v", job, notAfter)
+			}
+			if job.DeadlineDecision != tt.wantDecision || job.DeadlineEstimateMs != tt.wantEstimate {
+				t.Fatalf("decision %s against %dms, want %s against %dms", job.DeadlineDecision, job.DeadlineEstimateMs, tt.wantDecision, tt.wantEstimate)
+			}
+			if tt.wantProved {
+				if response.ErrorMessage != nil {
+					t.Fatalf("job to prove failed: %s", *response.ErrorMessage)
+				}
+				return
+			}
+			if response.Success || response.ErrorCode == nil || *response.ErrorCode != codeDeadlineExceeded {
+				t.Fatalf("expired job response = %+v, want errorCode %s", response, codeDeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestStartProofRejectsPastNotAfter(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	input := testProofRequest(t)
+	notAfter := time.Now().Add(-time.Minute)
+	input.NotAfter = &notAfter
+	result := startProofs(t, s, []ProofRequest{input})[0]
+	if result.JobId != nil || result.ErrorCode == nil || *result.ErrorCode != codeInvalidNotAfter {
+		t.Fatalf("start-proofs with a past notAfter = %+v, want %s", result, codeInvalidNotAfter)
+	}
+	if queued := queuedJobs(t, s); len(queued) != 0 {
+		t.Fatalf("queued %v", queued)
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gnark-server/jobstore"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// jobStateDeleted is logged in the event log when a job is purged. The
+	// job has no records left to be in that state.
+	jobStateDeleted = "deleted"
+
+	codeJobProving = "job_proving"
+
+	// redisDeletedKeyPrefix marks deleted jobs for as long as the job store
+	// of another instance may still hold them, so that its recovery and
+	// get-proof leave them deleted.
+	redisDeletedKeyPrefix = "gnark_proof_deleted:"
+)
+
+func getRedisDeletedKey(jobId string) string {
+	return redisDeletedKeyPrefix + jobId
+}
+
+var errJobProving = &RequestError{Code: codeJobProving, Message: "job is being proved and cannot be deleted until it finishes"}
+
+// deleteJobScript removes every record of a job unless a worker holds it,
+// marks it deleted and logs the deletion in the event log. A job is held
+// while it is in the processing list, has a lease or is in the proving state.
+// The idempotency and in-flight claims are deleted only if they still map to
+// the job. It returns 1 if the job was deleted, 0 if it is unknown and -1 if
+// it is held. With ARGV[5] set, a job unknown to Redis is deleted anyway,
+// for a job only the job store still knows.
+//
+// KEYS: metadata, result, input, expired marker, callback attempts, lease,
+// processing, high, normal and low queues, legacy queue, event log, deleted
+// marker, then the claims of the job.
+// ARGV: jobId, now, event log length, deleted marker TTL in seconds, whether
+// to delete unknown jobs.
+var deleteJobScript = redis.NewScript(`
+local state = redis.call('HGET', KEYS[1], 'state')
+if not state and redis.call('EXISTS', KEYS[2]) == 0 and redis.call('EXISTS', KEYS[4]) == 0 and ARGV[5] ~= 'true' then
+  return 0
+end
+if state == 'proving' or redis.call('EXISTS', KEYS[6]) == 1 then
+  return -1
+end
+for _, id in ipairs(redis.call('LRANGE', KEYS[7], 0, -1)) do
+  if id == ARGV[1] then
+    return -1
+  end
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5])
+for i = 8, 10 do
+  redis.call('ZREM', KEYS[i], ARGV[1])
+end
+redis.call('LREM', KEYS[11], 0, ARGV[1])
+redis.call('SET', KEYS[13], ARGV[2], 'EX', ARGV[4])
+for i = 14, #KEYS do
+  if redis.call('GET', KEYS[i]) == ARGV[1] then
+    redis.call('DEL', KEYS[i])
+  end
+end
+redis.call('XADD', KEYS[12], 'MAXLEN', '~', ARGV[3], '*',
+  'jobId', ARGV[1], 'state', 'deleted', 'at', ARGV[2])
+return 1
+`)
+
+// DeleteProofResponse is the response of DELETE /proof.
+type DeleteProofResponse struct {
+	JobId     string    `json:"jobId"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// deleteJob purges the result, metadata, stored input, expired marker and
+// claims of a job from Redis in one step, and takes it out of the queues if
+// it has not started. Jobs a worker is proving cannot be deleted. The job is
+// then removed from the job store, the proof cache and the mirror, and its
+// deleted marker keeps the job stores of other instances from recovering or
+// serving it.
+func (s *State) deleteJob(ctx context.Context, jobId string) (time.Time, error) {
+	if _, err := uuid.Parse(jobId); err != nil {
+		return time.Time{}, errInvalidJobId
+	}
+	meta, err := s.getJobMetadata(ctx, jobId)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keys := []string{
+		getRedisMetaKey(jobId),
+		getRedisKey(jobId),
+		getRedisInputKey(jobId),
+		getRedisExpiredKey(jobId),
+		getRedisCallbackAttemptsKey(jobId),
+		getRedisLeaseKey(jobId),
+		redisProcessingKey,
+	}
+	keys = append(keys, redisQueueKeys()...)
+	keys = append(keys, redisLegacyQueueKey, redisEventLogKey, getRedisDeletedKey(jobId))
+	if key := meta[metaIdempotencyKey]; key != "" {
+		keys = append(keys, key)
+	}
+	if digest := meta[metaInputDigest]; digest != "" {
+		keys = append(keys, getRedisInflightKey(digest))
+	}
+	stored := false
+	if s.JobStore != nil {
+		if _, err := s.JobStore.Get(jobId); err == nil {
+			stored = true
+		} else if !errors.Is(err, jobstore.ErrNotFound) {
+			return time.Time{}, err
+		}
+	}
+	now := time.Now()
+	deleted, err := deleteJobScript.Run(ctx, s.RedisClient, keys, jobId,
+		now.UTC().Format(time.RFC3339Nano), s.eventLogMaxLength(),
+		int64(jobStoreTombstoneAge.Seconds()), strconv.FormatBool(stored)).Int64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch deleted {
+	case 0:
+		return time.Time{}, errJobNotFound
+	case -1:
+		return time.Time{}, errJobProving
+	}
+	s.unstoreJob(jobId)
+	digest := meta[metaInputDigest]
+	if digest == "" {
+		// Jobs served from the proof cache were never in flight.
+		digest = meta[metaCallbackInputDigest]
+	}
+	if s.ProofCache != nil && meta[metaCircuitRelease] != "" && digest != "" {
+		if err := s.ProofCache.Delete(ctx, meta[metaCircuitRelease], digest); err != nil {
+			log.Printf("Failed to delete the proof cache entry of job %s: %v\n", jobId, err)
+		}
+	}
+	if s.Mirror != nil {
+		if err := s.Mirror.Delete(ctx, jobId); err != nil {
+			log.Printf("Failed to delete job %s from the mirror: %v\n", jobId, err)
+		}
+	}
+	log.Println("DeleteProof", jobId)
+	return now.UTC(), nil
+}
+
+// isDeleted reports whether a job was deleted with DELETE /proof.
+func (s *State) isDeleted(ctx context.Context, jobId string) (bool, error) {
+	n, err := s.RedisClient.Exists(ctx, getRedisDeletedKey(jobId)).Result()
+	return n > 0, err
+}
+
+// DeleteProof serves DELETE /proof?jobId=... It purges a job and its stored
+// payloads before they expire.
+func (s *State) DeleteProof(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w, http.MethodDelete)
+		return
+	}
+	jobId := r.URL.Query().Get("jobId")
+	deletedAt, err := s.deleteJob(r.Context(), jobId)
+	switch {
+	case err == errInvalidJobId:
+		writeError(w, http.StatusBadRequest, codeInvalidJobId, err.Error())
+	case err == errJobNotFound:
+		writeError(w, http.StatusNotFound, codeJobNotFound, err.Error())
+	case err == errJobProving:
+		writeError(w, http.StatusConflict, errJobProving.Code, errJobProving.Message)
+	case err != nil:
+		log.Printf("Failed to delete job %s: %v\n", jobId, err)
+		writeInternalError(w)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DeleteProofResponse{JobId: jobId, DeletedAt: deletedAt})
+	}
+}
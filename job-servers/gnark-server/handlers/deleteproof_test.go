@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"gnark-server/jobstore"
+	"gnark-server/proofcache"
+)
+
+func TestDeleteProofThenRecover(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newProvingTestState(t)
+	s.JobStore = openJobStore(t, filepath.Join(t.TempDir(), "jobs.db"))
+	s.ProofCache = proofcache.New(s.RedisClient, 0)
+	input := testProofRequest(t)
+	started, err := s.startProof(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobId := started.jobId
+
+	// A worker proves the job.
+	data, _ := s.soleCircuit()
+	digest, err := requestDigest(input, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), metaCircuitRelease, data.ReleaseId).Err(); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := s.getJobMetadata(ctx, jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := newProveResult([]string{"1", "2"}, "abcd")
+	if err := s.ProofCache.Put(ctx, data.ReleaseId, digest, proofcache.Entry{PublicInputs: result.PublicInputs, Proof: result.Proof}); err != nil {
+		t.Fatal(err)
+	}
+	s.finishJob(ctx, jobId, ProofResponse{Success: true, Proof: &result}, meta)
+
+	w := serve(s.DeleteProof, http.MethodDelete, "/proof?jobId="+jobId, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s, want 200", w.Code, w.Body)
+	}
+	if _, err := s.JobStore.Get(jobId); !errors.Is(err, jobstore.ErrNotFound) {
+		t.Fatalf("job store still has the deleted job: %v", err)
+	}
+	if entry, err := s.ProofCache.Get(ctx, data.ReleaseId, digest); err != nil || entry != nil {
+		t.Fatalf("proof cache = %v, %v, want no entry", entry, err)
+	}
+
+	if recovered, err := s.RecoverPendingJobs(ctx); err != nil || recovered != 0 {
+		t.Fatalf("RecoverPendingJobs = %d, %v, want 0", recovered, err)
+	}
+	if _, err := s.getProof(ctx, jobId); err != errJobNotFound {
+		t.Fatalf("getProof after delete = %v, want %v", err, errJobNotFound)
+	}
+	if started, err := s.startProof(ctx, input); err != nil || started.cached != nil {
+		t.Fatalf("resubmission = %+v, %v, want a queued job", started, err)
+	}
+}
+
+func TestDeleteProofReachesOtherJobStores(t *testing.T) {
+	ctx := context.Background()
+	// Instance a queues the job, so only its job store records it.
+	a, _ := newProvingTestState(t)
+	a.JobStore = openJobStore(t, filepath.Join(t.TempDir(), "a.db"))
+	started, err := a.startProof(ctx, testProofRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Instance b, with a job store of its own, deletes it.
+	b := &State{RedisClient: a.RedisClient, Circuits: a.Circuits, JobStore: openJobStore(t, filepath.Join(t.TempDir(), "b.db"))}
+	w := serve(b.DeleteProof, http.MethodDelete, "/proof?jobId="+started.jobId, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s, want 200", w.Code, w.Body)
+	}
+
+	// Instance a restarts.
+	if recovered, err := a.RecoverPendingJobs(ctx); err != nil || recovered != 0 {
+		t.Fatalf("RecoverPendingJobs = %d, %v, want 0", recovered, err)
+	}
+	if queued := queuedJobs(t, a); len(queued) != 0 {
+		t.Fatalf("queued %v, want none", queued)
+	}
+	if _, err := a.JobStore.Get(started.jobId); !errors.Is(err, jobstore.ErrNotFound) {
+		t.Fatalf("job store of a still has the deleted job: %v", err)
+	}
+}
+
+func TestDeleteProofOfJobOnlyInJobStore(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newProvingTestState(t)
+	s.JobStore = openJobStore(t, filepath.Join(t.TempDir(), "jobs.db"))
+	started, err := s.startProof(ctx, testProofRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.FlushAll()
+
+	w := serve(s.DeleteProof, http.MethodDelete, "/proof?jobId="+started.jobId, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s, want 200", w.Code, w.Body)
+	}
+	if recovered, err := s.RecoverPendingJobs(ctx); err != nil || recovered != 0 {
+		t.Fatalf("RecoverPendingJobs = %d, %v, want 0", recovered, err)
+	}
+	w = serve(s.DeleteProof, http.MethodDelete, "/proof?jobId="+started.jobId, "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("second delete: %d %s, want 404", w.Code, w.Body)
+	}
+}
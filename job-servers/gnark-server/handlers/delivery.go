@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"gnark-server/delivery"
+	"gnark-server/mirror"
+)
+
+const (
+	deliverySinkCallback = "callback"
+	deliverySinkMirror   = "mirror"
+)
+
+// callbackDelivery is the payload of a callback delivery. It carries the
+// metadata notifyCallback reads, so that an instance other than the one that
+// finished the job can send it.
+type callbackDelivery struct {
+	CallbackUrl string            `json:"callbackUrl"`
+	Response    ProofResponse     `json:"response"`
+	Meta        map[string]string `json:"meta,omitempty"`
+}
+
+// ParseDeliverySinks parses per-sink worker counts and queue sizes of the
+// form "sink=n,sink=n". Sinks left out use the delivery defaults.
+func ParseDeliverySinks(workers string, queueSizes string) (map[string]delivery.SinkConfig, error) {
+	configs := map[string]delivery.SinkConfig{
+		deliverySinkCallback: {},
+		deliverySinkMirror:   {},
+	}
+	set := func(spec string, apply func(*delivery.SinkConfig, int)) error {
+		if strings.TrimSpace(spec) == "" {
+			return nil
+		}
+		for _, entry := range strings.Split(spec, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return fmt.Errorf("invalid delivery setting %q, expected sink=n", entry)
+			}
+			config, known := configs[name]
+			if !known {
+				return fmt.Errorf("unknown delivery sink %q", name)
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid value in %q, expected a positive integer", entry)
+			}
+			apply(&config, n)
+			configs[name] = config
+		}
+		return nil
+	}
+	if err := set(workers, func(c *delivery.SinkConfig, n int) { c.Workers = n }); err != nil {
+		return nil, err
+	}
+	if err := set(queueSizes, func(c *delivery.SinkConfig, n int) { c.QueueSize = n }); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// RegisterDeliverySinks registers the callback sink, and the mirror sink when
+// the mirror is enabled, on pool and routes deliveries through it.
+func (s *State) RegisterDeliverySinks(pool *delivery.Pool, configs map[string]delivery.SinkConfig) {
+	pool.Register(deliverySinkCallback, configs[deliverySinkCallback], s.runCallbackDelivery)
+	if s.Mirror != nil {
+		pool.Register(deliverySinkMirror, configs[deliverySinkMirror], s.runMirrorDelivery)
+	}
+	s.Deliveries = pool
+	s.Metrics.registerDeliveries(pool)
+}
+
+// deliverCallback schedules the notification of a finished job.
+func (s *State) deliverCallback(jobId string, callbackUrl string, response ProofResponse, meta map[string]string) {
+	if s.Deliveries == nil {
+		s.goTracked(func() {
+			if err := s.notifyCallback(context.Background(), jobId, callbackUrl, response, meta); err != nil {
+				log.Printf("Callback for job %s failed: %v\n", jobId, err)
+			}
+		})
+		return
+	}
+	payload := callbackDelivery{CallbackUrl: callbackUrl, Response: response}
+	for _, field := range []string{metaCallbackInputDigest, metaCallbackForce} {
+		if value, ok := meta[field]; ok {
+			if payload.Meta == nil {
+				payload.Meta = make(map[string]string)
+			}
+			payload.Meta[field] = value
+		}
+	}
+	s.enqueueDelivery(deliverySinkCallback, jobId, payload)
+}
+
+func (s *State) enqueueDelivery(sink string, jobId string, payload interface{}) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode %s delivery for job %s: %v\n", sink, jobId, err)
+		return
+	}
+	if err := s.Deliveries.Enqueue(sink, delivery.Task{JobId: jobId, Payload: raw}); err != nil {
+		log.Printf("Failed to schedule %s delivery for job %s: %v\n", sink, jobId, err)
+	}
+}
+
+func (s *State) runCallbackDelivery(ctx context.Context, task delivery.Task) error {
+	var payload callbackDelivery
+	if err := json.Unmarshal(task.Payload, &payload); err != nil {
+		return err
+	}
+	return s.notifyCallback(ctx, task.JobId, payload.CallbackUrl, payload.Response, payload.Meta)
+}
+
+func (s *State) runMirrorDelivery(ctx context.Context, task delivery.Task) error {
+	var record mirror.Record
+	if err := json.Unmarshal(task.Payload, &record); err != nil {
+		return err
+	}
+	return s.Mirror.Write(ctx, []mirror.Record{record})
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"gnark-server/prover"
+	"gnark-server/utils"
+	"gnark-server/webhook"
+)
+
+// Error codes returned in ErrorResponse.Code and ProofResponse.ErrorCode.
+// They are part of the API and must not change once released.
+const (
+	codeMalformedJSON           = "malformed_json"
+	codeMalformedProof          = "malformed_proof"
+	codeInvalidRequest          = "invalid_request"
+	codeInvalidBatch            = "invalid_batch"
+	codeInvalidPublicInputCount = "invalid_public_input_count"
+	codePublicInputOutOfRange   = "public_input_out_of_range"
+	codeInvalidJobId            = "invalid_job_id"
+	codeCircuitNotAllowed       = "circuit_not_allowed"
+	codeProofTooLarge           = "proof_too_large"
+	codeInvalidPriority         = "invalid_priority"
+	codeJobNotFound             = "job_not_found"
+	codeJobFailed               = "job_failed"
+	codeProverError             = "prover_error"
+	codeInputHashMismatch       = "input_hash_mismatch"
+	codeNotFound                = "not_found"
+	codeMethodNotAllowed        = "method_not_allowed"
+	codeRateLimited             = "rate_limited"
+	codeQueueFull               = "queue_full"
+	codeShuttingDown            = "shutting_down"
+	codeTooManyConnections      = "too_many_connections"
+	codeProvingDisabled         = "proving_disabled"
+	codeInternalError           = "internal_error"
+)
+
+type ErrorResponse struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeErrorDetails(w, status, code, message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, status int, code string, message string, details map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Code: code, Message: message, Details: details})
+}
+
+func writeInternalError(w http.ResponseWriter) {
+	writeError(w, http.StatusInternalServerError, codeInternalError, "Internal server error")
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+}
+
+// inputErrorCode returns the error code for a proof input that failed
+// validation, keeping the validation message as is.
+func inputErrorCode(err error) string {
+	switch {
+	case errors.Is(err, utils.ErrPublicInputCount):
+		return codeInvalidPublicInputCount
+	case errors.Is(err, utils.ErrPublicInputRange):
+		return codePublicInputOutOfRange
+	case errors.Is(err, prover.ErrMalformedInput):
+		return codeMalformedJSON
+	case errors.Is(err, prover.ErrMalformedProof):
+		return codeMalformedProof
+	case errors.Is(err, prover.ErrProofTooLarge):
+		return codeProofTooLarge
+	default:
+		return codeInvalidRequest
+	}
+}
+
+// requestErrorStatus returns the HTTP status of a rejected submission.
+// Public inputs that parse but do not fit the input layout are well-formed
+// requests that cannot be processed, so they get 422 rather than 400.
+func requestErrorStatus(code string) int {
+	switch code {
+	case codeInvalidPublicInputCount, codePublicInputOutOfRange:
+		return http.StatusUnprocessableEntity
+	case codeCircuitNotAllowed:
+		return http.StatusForbidden
+	case codeProofTooLarge:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// failureCode classifies the error a job failed with. Errors in the proving
+// backend are prover errors; everything else means the job itself could not
+// be proved.
+func failureCode(err error) string {
+	var proverErr *prover.ProverError
+	if errors.As(err, &proverErr) {
+		return codeProverError
+	}
+	if errors.Is(err, prover.ErrInputHashMismatch) {
+		return codeInputHashMismatch
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codeTimeout
+	}
+	if errors.Is(err, errDeadlineExceeded) {
+		return codeDeadlineExceeded
+	}
+	if code := inputErrorCode(err); code != codeInvalidRequest {
+		return code
+	}
+	return codeJobFailed
+}
+
+// requestErrorCode returns the error code of a rejected submission.
+func requestErrorCode(err error) string {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) && reqErr.Code != "" {
+		return reqErr.Code
+	}
+	var verr *webhook.ValidationError
+	if errors.As(err, &verr) {
+		return verr.Code
+	}
+	return codeInvalidRequest
+}
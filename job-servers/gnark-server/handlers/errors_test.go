@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gnark-server/prover"
+
+	"github.com/google/uuid"
+)
+
+// withPublicInputs returns the proof request of testdata with its public
+// inputs replaced by the first n ones and extra.
+func withPublicInputs(t *testing.T, n int, extra ...uint64) ProofRequest {
+	t.Helper()
+	input := testProofRequest(t)
+	var proof map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(input.Proof), &proof); err != nil {
+		t.Fatal(err)
+	}
+	var publicInputs []interface{}
+	if err := json.Unmarshal(proof["public_inputs"], &publicInputs); err != nil {
+		t.Fatal(err)
+	}
+	publicInputs = publicInputs[:n]
+	for _, v := range extra {
+		publicInputs = append(publicInputs, v)
+	}
+	raw, err := json.Marshal(publicInputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof["public_inputs"] = raw
+	proofJSON, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.Proof = string(proofJSON)
+	return input
+}
+
+func TestStartProofErrorCodes(t *testing.T) {
+	body := func(t *testing.T, input ProofRequest) string {
+		t.Helper()
+		raw, err := json.Marshal(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+	tests := []struct {
+		name        string
+		body        func(t *testing.T) string
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+	}{
+		{"malformed JSON", func(t *testing.T) string { return "{" }, http.StatusBadRequest, codeMalformedJSON, ""},
+		{"proof not JSON", func(t *testing.T) string {
+			input := testProofRequest(t)
+			input.Proof = "not a proof"
+			return body(t, input)
+		}, http.StatusBadRequest, codeMalformedJSON, ""},
+		{"truncated proof", func(t *testing.T) string {
+			input := testProofRequest(t)
+			input.Proof = input.Proof[:len(input.Proof)/2]
+			return body(t, input)
+		}, http.StatusBadRequest, codeMalformedJSON, ""},
+		{"malformed proof", func(t *testing.T) string {
+			input := testProofRequest(t)
+			input.Proof = strings.Replace(input.Proof, `"wires_cap": [`, `"wires_cap": ["0x1", `, 1)
+			return body(t, input)
+		}, http.StatusBadRequest, codeMalformedProof, `proof.wires_cap[0] is not a decimal BN254 field element: "0x1"`},
+		{"too many public inputs", func(t *testing.T) string { return body(t, withPublicInputs(t, 8, 1)) },
+			http.StatusUnprocessableEntity, codeInvalidPublicInputCount, "expected 8 public inputs, got 9"},
+		{"too few public inputs", func(t *testing.T) string { return body(t, withPublicInputs(t, 7)) },
+			http.StatusUnprocessableEntity, codeInvalidPublicInputCount, "expected 8 public inputs, got 7"},
+		{"public input out of range", func(t *testing.T) string { return body(t, withPublicInputs(t, 7, 1<<32)) },
+			http.StatusUnprocessableEntity, codePublicInputOutOfRange, "public input[7] exceeds 32 bits: 4294967296 (max: 4294967295)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newProvingTestState(t)
+			w := httptest.NewRecorder()
+			s.StartProof(w, httptest.NewRequest(http.MethodPost, "/start-proof", strings.NewReader(tt.body(t))))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("start-proof: %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("start-proof Content-Type = %q, want application/json", ct)
+			}
+			var response ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Code != tt.wantCode {
+				t.Fatalf("start-proof error code = %s, want %s", response.Code, tt.wantCode)
+			}
+			if tt.wantMessage != "" && response.Message != tt.wantMessage {
+				t.Fatalf("start-proof error message = %q, want %q", response.Message, tt.wantMessage)
+			}
+			if jobs := queuedJobs(t, s); len(jobs) != 0 {
+				t.Fatalf("queued %v, want nothing", jobs)
+			}
+		})
+	}
+}
+
+func TestGetProofErrorCodes(t *testing.T) {
+	s, _ := newTestState(t)
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown job", "/get-proof?jobId=" + uuid.NewString(), http.StatusNotFound, codeJobNotFound},
+		{"invalid job ID", "/get-proof?jobId=not-a-job", http.StatusBadRequest, codeInvalidJobId},
+		{"unknown format", "/get-proof?jobId=" + uuid.NewString() + "&format=xml", http.StatusBadRequest, codeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s.GetProof, http.MethodGet, tt.target, "")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("get-proof: %d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("get-proof Content-Type = %q, want application/json", ct)
+			}
+			if code := errorCode(t, w); code != tt.wantCode {
+				t.Fatalf("get-proof error code = %s, want %s", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestFailedJobErrorCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+	}{
+		{"job failed", errors.New("witness does not satisfy the circuit"), codeJobFailed},
+		{"prover error", &prover.ProverError{Err: errors.New("out of memory")}, codeProverError},
+		{"input hash mismatch", prover.ErrInputHashMismatch, codeInputHashMismatch},
+		{"timeout", context.DeadlineExceeded, codeTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestState(t)
+			ctx := context.Background()
+			jobId := uuid.NewString()
+			if err := s.setProofResponse(ctx, jobId, ProofResponse{Success: true}, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			s.failJob(ctx, jobId, tt.err)
+
+			w := serve(s.GetProof, http.MethodGet, "/get-proof?jobId="+jobId, "")
+			var response ProofResponse
+			if err := json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Success || response.ErrorCode == nil || *response.ErrorCode != tt.wantCode {
+				t.Fatalf("get-proof = %s, want a failure with code %s", w.Body, tt.wantCode)
+			}
+			if *response.ErrorMessage != tt.err.Error() {
+				t.Fatalf("get-proof error message = %q, want %q", *response.ErrorMessage, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// redisProveDurationsKey is a sorted set of the durations of recent
+	// successful proofs across all instances, scored by completion time in
+	// milliseconds. Members are "<jobId>:<durationMs>".
+	redisProveDurationsKey = "gnark_prove_durations"
+	// redisCircuitProveDurationsKeyPrefix prefixes the same history per
+	// circuit, which deadlines are checked against.
+	redisCircuitProveDurationsKeyPrefix = "gnark_prove_durations:"
+
+	proveDurationHistorySize = 1000
+	// minEstimateSamples is the number of durations below which no estimate
+	// is given.
+	minEstimateSamples = 10
+)
+
+// EstimateProveTimeResponse is the response of estimate-prove-time.
+type EstimateProveTimeResponse struct {
+	P50Ms      int64 `json:"p50_ms"`
+	P95Ms      int64 `json:"p95_ms"`
+	P99Ms      int64 `json:"p99_ms"`
+	SampleSize int   `json:"sample_size"`
+}
+
+func getRedisCircuitProveDurationsKey(circuit string) string {
+	return redisCircuitProveDurationsKeyPrefix + circuit
+}
+
+// recordProveDurationSample adds the duration of a successful proof of
+// circuit to the shared history and to that of the circuit, and trims both
+// to the last proveDurationHistorySize.
+func (s *State) recordProveDurationSample(ctx context.Context, jobId string, circuit string, duration time.Duration) {
+	member := jobId + ":" + strconv.FormatInt(duration.Milliseconds(), 10)
+	score := float64(time.Now().UnixMilli())
+	pipe := s.RedisClient.TxPipeline()
+	for _, key := range []string{redisProveDurationsKey, getRedisCircuitProveDurationsKey(circuit)} {
+		pipe.ZAdd(ctx, key, &redis.Z{Score: score, Member: member})
+		pipe.ZRemRangeByRank(ctx, key, 0, -proveDurationHistorySize-1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to record duration history of job %s: %v\n", jobId, err)
+	}
+}
+
+// proveDurationHistory returns the durations in the shared history.
+func (s *State) proveDurationHistory(ctx context.Context) ([]time.Duration, error) {
+	return s.proveDurationHistoryOf(ctx, redisProveDurationsKey)
+}
+
+// proveDurationHistoryOf returns the durations in the history at key.
+func (s *State) proveDurationHistoryOf(ctx context.Context, key string) ([]time.Duration, error) {
+	members, err := s.RedisClient.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	durations := make([]time.Duration, 0, len(members))
+	for _, member := range members {
+		i := strings.LastIndexByte(member, ':')
+		ms, err := strconv.ParseInt(member[i+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		durations = append(durations, time.Duration(ms)*time.Millisecond)
+	}
+	return durations, nil
+}
+
+// EstimateProveTime serves GET /estimate-prove-time: percentiles of the last
+// proveDurationHistorySize successful proving durations of all instances,
+// so that clients know how long to wait before polling. It returns 204 until
+// minEstimateSamples durations have been recorded.
+func (s *State) EstimateProveTime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	durations, err := s.proveDurationHistory(r.Context())
+	if err != nil {
+		log.Println("Failed to read duration history:", err)
+		writeInternalError(w)
+		return
+	}
+	if len(durations) < minEstimateSamples {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ps := sortedPercentiles(durations, 50, 95, 99)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(EstimateProveTimeResponse{
+		P50Ms:      ps[0].Milliseconds(),
+		P95Ms:      ps[1].Milliseconds(),
+		P99Ms:      ps[2].Milliseconds(),
+		SampleSize: len(durations),
+	})
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// redisEventLogKey is the stream of the state transitions of every job,
+	// oldest first. It is separate from the queues and outlives the jobs.
+	redisEventLogKey = "gnark_proof_event_log"
+
+	// DefaultEventLogMaxLength is the number of transitions kept in the event
+	// log when EVENT_LOG_MAX_LENGTH is not set. Redis trims the stream
+	// approximately, so slightly more may be kept.
+	DefaultEventLogMaxLength = 100000
+
+	defaultEventLogLimit = 100
+	maxEventLogLimit     = 1000
+)
+
+// JobTransition is an entry of the event log: a job entering State at At.
+// Attempts is set when a job is queued again, and ErrorCode when it fails.
+type JobTransition struct {
+	// Id is the stream ID of the entry, the cursor to replay from.
+	Id        string    `json:"id"`
+	JobId     string    `json:"jobId"`
+	State     string    `json:"state"`
+	At        time.Time `json:"at"`
+	Attempts  int64     `json:"attempts,omitempty"`
+	ErrorCode string    `json:"errorCode,omitempty"`
+}
+
+// EventLogResponse is a page of the event log.
+type EventLogResponse struct {
+	Events []JobTransition `json:"events"`
+	// Next is the cursor to pass as from for the following page. It is from
+	// when there are no newer events.
+	Next string `json:"next"`
+	// Oldest is the ID of the oldest transition still kept.
+	Oldest string `json:"oldest,omitempty"`
+	// Truncated is set when from is older than the oldest transition kept,
+	// so that transitions after from may have been trimmed.
+	Truncated bool `json:"truncated"`
+}
+
+// eventLogMaxLength returns the number of transitions kept in the event log.
+func (s *State) eventLogMaxLength() int64 {
+	if s.EventLogMaxLength > 0 {
+		return s.EventLogMaxLength
+	}
+	return DefaultEventLogMaxLength
+}
+
+// queueTransition appends to pipe the event log entry of the transition
+// recorded by fields, which were built by stateFields, so that it is written
+// in the same transaction as the state. details adds fields such as
+// errorCode to the entry.
+func (s *State) queueTransition(ctx context.Context, pipe redis.Pipeliner, jobId string, fields map[string]interface{}, details map[string]interface{}) {
+	state, _ := fields[metaState].(string)
+	values := map[string]interface{}{
+		"jobId": jobId,
+		"state": state,
+		"at":    fields[metaStateAt(state)],
+	}
+	for k, v := range details {
+		values[k] = v
+	}
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisEventLogKey,
+		MaxLen: s.eventLogMaxLength(),
+		Approx: true,
+		Values: values,
+	})
+}
+
+// parseStreamId parses a stream ID, MILLISECONDS-SEQUENCE or MILLISECONDS.
+func parseStreamId(id string) (ms uint64, seq uint64, ok bool) {
+	msPart, seqPart, hasSeq := strings.Cut(id, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return ms, seq, true
+}
+
+// streamIdLess reports whether stream ID a is older than b. Both must parse.
+func streamIdLess(a, b string) bool {
+	aMs, aSeq, _ := parseStreamId(a)
+	bMs, bSeq, _ := parseStreamId(b)
+	if aMs != bMs {
+		return aMs < bMs
+	}
+	return aSeq < bSeq
+}
+
+// streamIdAfter returns the smallest stream ID newer than id, so that ranges
+// can start after a cursor on Redis versions without exclusive ranges.
+func streamIdAfter(id string) string {
+	ms, seq, _ := parseStreamId(id)
+	if seq == ^uint64(0) {
+		return strconv.FormatUint(ms+1, 10) + "-0"
+	}
+	return strconv.FormatUint(ms, 10) + "-" + strconv.FormatUint(seq+1, 10)
+}
+
+// jobTransitionFromMessage decodes an event log entry.
+func jobTransitionFromMessage(msg redis.XMessage) JobTransition {
+	str := func(name string) string {
+		v, _ := msg.Values[name].(string)
+		return v
+	}
+	transition := JobTransition{
+		Id:        msg.ID,
+		JobId:     str("jobId"),
+		State:     str("state"),
+		ErrorCode: str("errorCode"),
+	}
+	transition.At, _ = time.Parse(time.RFC3339Nano, str("at"))
+	transition.Attempts, _ = strconv.ParseInt(str("attempts"), 10, 64)
+	return transition
+}
+
+// eventLog returns up to limit transitions newer than from, oldest first. An
+// empty from starts at the oldest transition kept.
+func (s *State) eventLog(ctx context.Context, from string, limit int) (EventLogResponse, error) {
+	response := EventLogResponse{Events: []JobTransition{}, Next: from}
+	oldest, err := s.RedisClient.XRangeN(ctx, redisEventLogKey, "-", "+", 1).Result()
+	if err != nil {
+		return response, err
+	}
+	if len(oldest) == 0 {
+		return response, nil
+	}
+	response.Oldest = oldest[0].ID
+	start := "-"
+	if from != "" {
+		start = streamIdAfter(from)
+		response.Truncated = streamIdLess(from, response.Oldest)
+	}
+	messages, err := s.RedisClient.XRangeN(ctx, redisEventLogKey, start, "+", int64(limit)).Result()
+	if err != nil {
+		return response, err
+	}
+	for _, msg := range messages {
+		response.Events = append(response.Events, jobTransitionFromMessage(msg))
+	}
+	if len(messages) > 0 {
+		response.Next = messages[len(messages)-1].ID
+	}
+	return response, nil
+}
+
+// EventLogHandler serves GET /events?from=<id>&limit=<n>: the state
+// transitions of all jobs after the cursor from, oldest first. Consumers
+// replay the log by passing the next cursor of each page as from. limit
+// defaults to 100.
+func (s *State) EventLogHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	from := r.URL.Query().Get("from")
+	if from != "" {
+		if _, _, ok := parseStreamId(from); !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "from must be an event ID such as 1700000000000-0")
+			return
+		}
+	}
+	limit := defaultEventLogLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxEventLogLimit {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest,
+				"limit must be between 1 and "+strconv.Itoa(maxEventLogLimit))
+			return
+		}
+		limit = n
+	}
+	response, err := s.eventLog(r.Context(), from, limit)
+	if err != nil {
+		log.Println("Failed to read the event log:", err)
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// replayEvents pages through the event log from the cursor from with pages
+// of limit and returns every transition.
+func replayEvents(t *testing.T, s *State, from string, limit int) []JobTransition {
+	t.Helper()
+	var events []JobTransition
+	for {
+		target := "/events?limit=" + jsonNumber(limit)
+		if from != "" {
+			target += "&from=" + from
+		}
+		w := serve(s.EventLogHandler, http.MethodGet, target, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("events: %d %s", w.Code, w.Body)
+		}
+		var page EventLogResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Truncated {
+			t.Fatalf("events from %q is truncated", from)
+		}
+		if len(page.Events) > limit {
+			t.Fatalf("page of %d events with limit %d", len(page.Events), limit)
+		}
+		if len(page.Events) == 0 {
+			if page.Next != from {
+				t.Fatalf("empty page moved the cursor from %q to %q", from, page.Next)
+			}
+			return events
+		}
+		events = append(events, page.Events...)
+		from = page.Next
+	}
+}
+
+func jsonNumber(n int) string {
+	raw, _ := json.Marshal(n)
+	return string(raw)
+}
+
+// synthesizeHistory runs jobs through their lifecycle: every job is queued
+// and proved, and every other one fails. It returns the transitions
+// expected in the event log, in order.
+func synthesizeHistory(t *testing.T, s *State, jobs int) []JobTransition {
+	t.Helper()
+	ctx := context.Background()
+	var want []JobTransition
+	var ids []string
+	for i := 0; i < jobs; i++ {
+		// Distinct public inputs, so that the submissions are not
+		// deduplicated.
+		input := withPublicInputs(t, 7, uint64(i))
+		results := startProofs(t, s, []ProofRequest{input})
+		if results[0].JobId == nil {
+			t.Fatalf("start-proofs: %s", *results[0].ErrorMessage)
+		}
+		ids = append(ids, *results[0].JobId)
+		want = append(want, JobTransition{JobId: *results[0].JobId, State: jobStateQueued})
+	}
+	for i, jobId := range ids {
+		s.setJobState(ctx, jobId, jobStateProving, nil)
+		want = append(want, JobTransition{JobId: jobId, State: jobStateProving})
+		if i%2 == 1 {
+			s.failJob(ctx, jobId, errors.New("witness does not satisfy the circuit"))
+			want = append(want, JobTransition{JobId: jobId, State: jobStateFailed, ErrorCode: codeJobFailed})
+			continue
+		}
+		meta, err := s.getJobMetadata(ctx, jobId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := newProveResult([]string{"1", "2"}, "00")
+		s.finishJob(ctx, jobId, ProofResponse{Success: true, Proof: &result}, meta)
+		want = append(want, JobTransition{JobId: jobId, State: jobStateDone})
+	}
+	return want
+}
+
+func assertTransitions(t *testing.T, got []JobTransition, want []JobTransition) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("replayed %d transitions, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if i > 0 && !streamIdLess(got[i-1].Id, got[i].Id) {
+			t.Fatalf("transition %d (%s) is not after %s", i, got[i].Id, got[i-1].Id)
+		}
+		if got[i].JobId != want[i].JobId || got[i].State != want[i].State || got[i].ErrorCode != want[i].ErrorCode {
+			t.Fatalf("transition %d = %+v, want %+v", i, got[i], want[i])
+		}
+		if got[i].At.IsZero() {
+			t.Fatalf("transition %d has no time", i)
+		}
+	}
+}
+
+func TestEventLogReplay(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	want := synthesizeHistory(t, s, 6)
+
+	all := replayEvents(t, s, "", maxEventLogLimit)
+	assertTransitions(t, all, want)
+	for _, limit := range []int{1, 4, 7} {
+		assertTransitions(t, replayEvents(t, s, "", limit), want)
+	}
+	// Replaying from any cursor yields exactly the transitions after it.
+	for i, event := range all {
+		assertTransitions(t, replayEvents(t, s, event.Id, 5), want[i+1:])
+	}
+}
+
+func TestEventLogRetention(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	s.EventLogMaxLength = 5
+	synthesizeHistory(t, s, 4)
+	ctx := context.Background()
+	kept, err := s.RedisClient.XLen(ctx, redisEventLogKey).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept < 5 || kept >= 12 {
+		t.Fatalf("the event log keeps %d of 12 transitions with a maximum length of 5", kept)
+	}
+
+	page, err := s.eventLog(ctx, "1-0", maxEventLogLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.Truncated || page.Oldest == "" || page.Oldest != page.Events[0].Id {
+		t.Fatalf("events from a trimmed cursor: truncated %v, oldest %q, first %q", page.Truncated, page.Oldest, page.Events[0].Id)
+	}
+	// The terminal transitions are the newest and are never trimmed first.
+	last := page.Events[len(page.Events)-1]
+	if last.State != jobStateDone && last.State != jobStateFailed {
+		t.Fatalf("last transition %+v is not terminal", last)
+	}
+}
+
+func TestEventLogHandlerRequests(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	w := serve(s.EventLogHandler, http.MethodGet, "/events", "")
+	var page EventLogResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || len(page.Events) != 0 || page.Next != "" || page.Truncated {
+		t.Fatalf("events of an empty log: %d %s", w.Code, w.Body)
+	}
+	for _, target := range []string{"/events?from=yesterday", "/events?from=1-x", "/events?limit=0", "/events?limit=1001", "/events?limit=ten"} {
+		if w := serve(s.EventLogHandler, http.MethodGet, target, ""); w.Code != http.StatusBadRequest || errorCode(t, w) != codeInvalidRequest {
+			t.Fatalf("%s: %d %s, want 400 %s", target, w.Code, w.Body, codeInvalidRequest)
+		}
+	}
+	if w := serve(s.EventLogHandler, http.MethodPost, "/events", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /events: %d, want 405", w.Code)
+	}
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gnark-server/prover"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	stageQueued            = "queued"
+	stageWitnessGeneration = prover.StageWitnessGeneration
+	stageProving           = prover.StageProving
+	stageVerifying         = prover.StageVerifying
+	stageDone              = "done"
+	stageFailed            = "failed"
+
+	metaStage          = "stage"
+	metaStageUpdatedAt = "stageUpdatedAt"
+
+	redisEventsChannelPrefix = "gnark_proof_events:"
+
+	defaultProofEventsIdleTimeout = 10 * time.Minute
+	// proofEventsPingInterval keeps streams open through proxies that close
+	// idle connections.
+	proofEventsPingInterval = 15 * time.Second
+)
+
+type ProofEvent struct {
+	JobId string    `json:"jobId"`
+	Stage string    `json:"stage"`
+	Time  time.Time `json:"time"`
+}
+
+func getRedisEventsChannel(jobId string) string {
+	return fmt.Sprintf("%s%s", redisEventsChannelPrefix, jobId)
+}
+
+func isTerminalStage(stage string) bool {
+	return stage == stageDone || stage == stageFailed
+}
+
+// setStage records the current stage of a job and publishes the transition
+// so that /proof-events subscribers on any replica receive it.
+func (s *State) setStage(ctx context.Context, jobId string, stage string) {
+	event := ProofEvent{JobId: jobId, Stage: stage, Time: time.Now().UTC()}
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode stage event for job %s: %v\n", jobId, err)
+		return
+	}
+	metaKey := getRedisMetaKey(jobId)
+	_, err = s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, metaKey, metaStage, stage, metaStageUpdatedAt, event.Time.Format(time.RFC3339Nano))
+		pipe.Expire(ctx, metaKey, expiration)
+		pipe.Publish(ctx, getRedisEventsChannel(jobId), eventJSON)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to publish stage %s for job %s: %v\n", stage, jobId, err)
+	}
+}
+
+// ProofEvents streams the stage transitions of a job as server-sent events.
+// The current stage is sent immediately on connect and the stream is closed
+// once the job reaches done or failed. A ping event is sent every
+// proofEventsPingInterval while the job is pending. The job is selected with
+// jobId, or id.
+func (s *State) ProofEvents(w http.ResponseWriter, r *http.Request) {
+	jobId := r.URL.Query().Get("jobId")
+	if jobId == "" {
+		jobId = r.URL.Query().Get("id")
+	}
+	if _, err := uuid.Parse(jobId); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJobId, errInvalidJobId.Error())
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeInternalError, "streaming unsupported")
+		return
+	}
+	ctx := r.Context()
+
+	pubsub, current, err := s.subscribeProofEvents(ctx, jobId)
+	if errors.Is(err, errJobNotFound) {
+		writeError(w, http.StatusNotFound, codeJobNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeInternalError(w)
+		return
+	}
+	defer pubsub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	writeEvent(w, current)
+	flusher.Flush()
+	if isTerminalStage(current.Stage) {
+		return
+	}
+
+	idleTimeout := s.ProofEventsIdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultProofEventsIdleTimeout
+	}
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+	ping := time.NewTicker(proofEventsPingInterval)
+	defer ping.Stop()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ping.C:
+			fmt.Fprintf(w, "event: ping\ndata: {\"time\":%q}\n\n", now.UTC().Format(time.RFC3339Nano))
+			flusher.Flush()
+		case <-s.streams.done():
+			return
+		case <-idle.C:
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event ProofEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+			if isTerminalStage(event.Stage) {
+				return
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(idleTimeout)
+		}
+	}
+}
+
+// subscribeProofEvents subscribes to the stage transitions of a job and
+// returns its current stage, or errJobNotFound. The subscription is made
+// before the current stage is read so that no transition between the two is
+// lost.
+func (s *State) subscribeProofEvents(ctx context.Context, jobId string) (*redis.PubSub, ProofEvent, error) {
+	pubsub := s.RedisClient.Subscribe(ctx, getRedisEventsChannel(jobId))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, ProofEvent{}, err
+	}
+	meta, err := s.getJobMetadata(ctx, jobId)
+	if err == nil && meta[metaStage] == "" {
+		err = errJobNotFound
+	}
+	if err != nil {
+		pubsub.Close()
+		return nil, ProofEvent{}, err
+	}
+	current := ProofEvent{JobId: jobId, Stage: meta[metaStage]}
+	current.Time, _ = time.Parse(time.RFC3339Nano, meta[metaStageUpdatedAt])
+	return pubsub, current, nil
+}
+
+func writeEvent(w http.ResponseWriter, event ProofEvent) {
+	eventJSON, _ := json.Marshal(event)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Stage, eventJSON)
+}
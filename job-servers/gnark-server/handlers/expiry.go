@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// defaultResultTTL is how long finished job records are kept when
+	// neither the server configuration nor the request sets a TTL.
+	defaultResultTTL = time.Hour
+	// defaultFailedResultTTL is how long failed job records are kept by
+	// default. Failures are usually looked at soon or retried right away.
+	defaultFailedResultTTL = 5 * time.Minute
+	maxResultTTL           = 30 * 24 * time.Hour
+	// expiredMarkerRetention is how long after a job record expires GetProof
+	// can still tell that the job existed.
+	expiredMarkerRetention = 7 * 24 * time.Hour
+
+	redisExpiredKeyPrefix = "gnark_proof_expired:"
+
+	metaTTLSeconds = "ttlSeconds"
+	metaExpiresAt  = "expiresAt"
+)
+
+var errJobExpired = &RequestError{Code: "job_expired", Message: "job result has expired"}
+
+func getRedisExpiredKey(jobId string) string {
+	return fmt.Sprintf("%s%s", redisExpiredKeyPrefix, jobId)
+}
+
+// validateTTL checks the ttlSeconds of a request. Zero selects the server
+// default.
+func validateTTL(ttlSeconds int64) error {
+	if ttlSeconds < 0 || time.Duration(ttlSeconds)*time.Second > maxResultTTL {
+		return &RequestError{
+			Code:    "invalid_ttl",
+			Message: fmt.Sprintf("ttlSeconds must be between 1 and %d", int64(maxResultTTL.Seconds())),
+		}
+	}
+	return nil
+}
+
+// resultTTL returns how long the records of a finished job are kept: the
+// ttlSeconds of the request if set, otherwise the configured default for
+// completed or failed jobs.
+func (s *State) resultTTL(success bool, meta map[string]string) time.Duration {
+	if seconds, err := strconv.ParseInt(meta[metaTTLSeconds], 10, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if !success {
+		if s.FailedResultTTL > 0 {
+			return s.FailedResultTTL
+		}
+		return defaultFailedResultTTL
+	}
+	if s.ResultTTL > 0 {
+		return s.ResultTTL
+	}
+	return defaultResultTTL
+}
+
+// expireJob gives every record of a finished job the same expiry and leaves a
+// marker behind so that GetProof can report the job as expired rather than
+// unknown once the records are gone. The marker holds the job's lifecycle,
+// ending in the expired state at expiresAt.
+func (s *State) expireJob(ctx context.Context, jobId string, expiresAt time.Time) {
+	record := s.jobRecord(ctx, jobId)
+	if record == nil {
+		record = &JobRecord{Timestamps: map[string]time.Time{}}
+	}
+	record.State = jobStateExpired
+	record.Timestamps[jobStateExpired] = expiresAt.UTC()
+	marker, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Failed to encode the lifecycle of job %s: %v\n", jobId, err)
+		return
+	}
+	_, err = s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, getRedisMetaKey(jobId), metaExpiresAt, expiresAt.Unix())
+		for _, key := range []string{
+			getRedisKey(jobId),
+			getRedisMetaKey(jobId),
+			getRedisInputKey(jobId),
+			getRedisCallbackAttemptsKey(jobId),
+		} {
+			pipe.ExpireAt(ctx, key, expiresAt)
+		}
+		pipe.Set(ctx, getRedisExpiredKey(jobId), marker, time.Until(expiresAt)+expiredMarkerRetention)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to set expiry of job %s: %v\n", jobId, err)
+	}
+}
+
+// jobExpiry returns the time a job's records expire, for writes that happen
+// after the job finished. Jobs that have not finished use the default
+// expiration.
+func (s *State) jobExpiry(ctx context.Context, jobId string) time.Time {
+	if unix, err := s.RedisClient.HGet(ctx, getRedisMetaKey(jobId), metaExpiresAt).Int64(); err == nil {
+		return time.Unix(unix, 0)
+	}
+	return time.Now().Add(expiration)
+}
+
+// isExpired reports whether a job that is no longer in Redis used to exist.
+func (s *State) isExpired(ctx context.Context, jobId string) (bool, error) {
+	n, err := s.RedisClient.Exists(ctx, getRedisExpiredKey(jobId)).Result()
+	return n > 0, err
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResultTTL(t *testing.T) {
+	tests := []struct {
+		name            string
+		resultTTL       time.Duration
+		failedResultTTL time.Duration
+		success         bool
+		meta            map[string]string
+		want            time.Duration
+	}{
+		{"success default", 0, 0, true, nil, time.Hour},
+		{"failure default", 0, 0, false, nil, 5 * time.Minute},
+		{"failure ignores success TTL", 2 * time.Hour, 0, false, nil, 5 * time.Minute},
+		{"success configured", 2 * time.Hour, 0, true, nil, 2 * time.Hour},
+		{"failure configured", 2 * time.Hour, 10 * time.Minute, false, nil, 10 * time.Minute},
+		{"per job", 2 * time.Hour, 10 * time.Minute, false, map[string]string{metaTTLSeconds: "30"}, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &State{ResultTTL: tt.resultTTL, FailedResultTTL: tt.failedResultTTL}
+			if got := s.resultTTL(tt.success, tt.meta); got != tt.want {
+				t.Fatalf("resultTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
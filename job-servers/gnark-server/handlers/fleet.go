@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"gnark-server/apichanges"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// redisFleetKey is a sorted set of the registered instance IDs, scored
+	// by the time of their last heartbeat in milliseconds.
+	redisFleetKey = "gnark_fleet"
+	// redisFleetInstanceKeyPrefix prefixes the registration of an instance,
+	// which expires when it stops heartbeating.
+	redisFleetInstanceKeyPrefix = "gnark_fleet_instance:"
+
+	// DefaultFleetHeartbeatInterval is how often instances refresh their
+	// registration when FLEET_HEARTBEAT_SECONDS is not set.
+	DefaultFleetHeartbeatInterval = 10 * time.Second
+	// fleetMissedHeartbeats is the number of heartbeats an instance may miss
+	// before it is no longer listed.
+	fleetMissedHeartbeats = 3
+
+	fleetRoleProver     = "prover"
+	fleetRoleVerifyOnly = "verify-only"
+
+	// legacyLeaseHolder is the lease value of servers that did not record
+	// the instance holding the lease.
+	legacyLeaseHolder = "1"
+)
+
+// FleetInstance is the registration of a running instance.
+type FleetInstance struct {
+	InstanceId string `json:"instanceId"`
+	Hostname   string `json:"hostname"`
+	// Role is prover, or verify-only for instances without a usable proving
+	// key, which run no workers.
+	Role  string      `json:"role"`
+	Shard *FleetShard `json:"shard,omitempty"`
+	// ApiVersion and BuildCommit identify the server version.
+	ApiVersion  string `json:"apiVersion"`
+	BuildCommit string `json:"buildCommit,omitempty"`
+	// CircuitReleases maps the loaded circuits to their release IDs.
+	CircuitReleases map[string]string `json:"circuitReleases"`
+	StartedAt       time.Time         `json:"startedAt"`
+	LastHeartbeat   time.Time         `json:"lastHeartbeat"`
+	// RunningJobs lists the jobs the instance is proving.
+	RunningJobs []string `json:"runningJobs"`
+	// Draining is set once the instance stopped claiming jobs to shut down.
+	Draining bool `json:"draining"`
+}
+
+// FleetShard is the shard assignment of an instance.
+type FleetShard struct {
+	Count int `json:"count"`
+	Index int `json:"index"`
+}
+
+// FleetResponse lists the live instances sharing the Redis queue.
+type FleetResponse struct {
+	// Self is the instance that answered.
+	Self      string          `json:"self"`
+	Instances []FleetInstance `json:"instances"`
+}
+
+func getRedisFleetInstanceKey(instanceId string) string {
+	return redisFleetInstanceKeyPrefix + instanceId
+}
+
+// fleetTTL is how long a registration outlives the last heartbeat.
+func (s *State) fleetTTL() time.Duration {
+	interval := s.FleetHeartbeatInterval
+	if interval <= 0 {
+		interval = DefaultFleetHeartbeatInterval
+	}
+	return fleetMissedHeartbeats * interval
+}
+
+// leaseHolder is the lease value of the jobs this instance proves.
+func (s *State) leaseHolder() string {
+	if s.InstanceId == "" {
+		return legacyLeaseHolder
+	}
+	return s.InstanceId
+}
+
+// fleetInstance describes this instance as of now.
+func (s *State) fleetInstance(hostname string, startedAt time.Time) FleetInstance {
+	circuits := s.loadedCircuits()
+	instance := FleetInstance{
+		InstanceId:      s.InstanceId,
+		Hostname:        hostname,
+		Role:            fleetRoleProver,
+		ApiVersion:      apichanges.Current,
+		BuildCommit:     s.BuildCommit,
+		CircuitReleases: make(map[string]string, len(circuits)),
+		StartedAt:       startedAt.UTC(),
+		LastHeartbeat:   time.Now().UTC(),
+		RunningJobs:     []string{},
+		Draining:        s.isStopping(),
+	}
+	if s.VerifyOnly {
+		instance.Role = fleetRoleVerifyOnly
+	}
+	if s.Shard != nil {
+		instance.Shard = &FleetShard{Count: s.Shard.Count, Index: s.Shard.Index}
+	}
+	for name, data := range circuits {
+		instance.CircuitReleases[name] = data.ReleaseId
+	}
+	s.running.Range(func(jobId, _ interface{}) bool {
+		instance.RunningJobs = append(instance.RunningJobs, jobId.(string))
+		return true
+	})
+	sort.Strings(instance.RunningJobs)
+	return instance
+}
+
+// heartbeat writes the registration of this instance.
+func (s *State) heartbeat(ctx context.Context, instance FleetInstance) error {
+	instanceJSON, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+	_, err = s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, getRedisFleetInstanceKey(instance.InstanceId), instanceJSON, s.fleetTTL())
+		pipe.ZAdd(ctx, redisFleetKey, &redis.Z{Score: float64(instance.LastHeartbeat.UnixMilli()), Member: instance.InstanceId})
+		return nil
+	})
+	return err
+}
+
+// StartFleetHeartbeat registers this instance in the fleet registry under
+// InstanceId and refreshes the registration every FleetHeartbeatInterval
+// until the returned function is called, which removes it. It warns when
+// another live instance is registered under the same ID, since the two
+// would overwrite each other's registration and leases.
+func (s *State) StartFleetHeartbeat() (stop func()) {
+	ctx := context.Background()
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Println("Failed to read the hostname for the fleet registry:", err)
+	}
+	startedAt := time.Now()
+	if n, err := s.RedisClient.Exists(ctx, getRedisFleetInstanceKey(s.InstanceId)).Result(); err == nil && n > 0 {
+		log.Printf("Warning: instance ID %s is already registered by a live instance, set a unique INSTANCE_ID\n", s.InstanceId)
+	}
+	if err := s.heartbeat(ctx, s.fleetInstance(hostname, startedAt)); err != nil {
+		log.Println("Failed to register in the fleet registry:", err)
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(s.fleetTTL() / fleetMissedHeartbeats)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.heartbeat(ctx, s.fleetInstance(hostname, startedAt)); err != nil {
+					log.Println("Failed to refresh the fleet registration:", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		_, err := s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, getRedisFleetInstanceKey(s.InstanceId))
+			pipe.ZRem(ctx, redisFleetKey, s.InstanceId)
+			return nil
+		})
+		if err != nil {
+			log.Println("Failed to leave the fleet registry:", err)
+		}
+	}
+}
+
+// fleet returns the live instances, ordered by instance ID, and drops the
+// stale ones from the registry.
+func (s *State) fleet(ctx context.Context) ([]FleetInstance, error) {
+	cutoff := strconv.FormatInt(time.Now().Add(-s.fleetTTL()).UnixMilli(), 10)
+	if err := s.RedisClient.ZRemRangeByScore(ctx, redisFleetKey, "-inf", "("+cutoff).Err(); err != nil {
+		return nil, err
+	}
+	ids, err := s.RedisClient.ZRange(ctx, redisFleetKey, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return []FleetInstance{}, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = getRedisFleetInstanceKey(id)
+	}
+	values, err := s.RedisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]FleetInstance, 0, len(values))
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var instance FleetInstance
+		if err := json.Unmarshal([]byte(raw), &instance); err != nil {
+			log.Printf("Failed to decode the fleet registration of %s: %v\n", ids[i], err)
+			continue
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].InstanceId < instances[j].InstanceId })
+	return instances, nil
+}
+
+// liveInstances returns the IDs of the live instances.
+func (s *State) liveInstances(ctx context.Context) (map[string]bool, error) {
+	instances, err := s.fleet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	live := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		live[instance.InstanceId] = true
+	}
+	return live, nil
+}
+
+// FleetHandler serves GET /fleet, the instances sharing the Redis queue
+// that heartbeated recently.
+func (s *State) FleetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	instances, err := s.fleet(r.Context())
+	if err != nil {
+		log.Println("Failed to read the fleet registry:", err)
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FleetResponse{Self: s.InstanceId, Instances: instances})
+}
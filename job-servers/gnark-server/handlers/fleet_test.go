@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fleetView returns the /fleet response of s.
+func fleetView(t *testing.T, s *State) FleetResponse {
+	t.Helper()
+	w := serve(s.FleetHandler, http.MethodGet, "/fleet", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /fleet = %d: %s", w.Code, w.Body.String())
+	}
+	var fleet FleetResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &fleet); err != nil {
+		t.Fatal(err)
+	}
+	return fleet
+}
+
+// instanceIds returns the IDs of the instances of fleet, in order.
+func instanceIds(fleet FleetResponse) []string {
+	ids := make([]string, len(fleet.Instances))
+	for i, instance := range fleet.Instances {
+		ids[i] = instance.InstanceId
+	}
+	return ids
+}
+
+func TestFleetRegistry(t *testing.T) {
+	ctx := context.Background()
+	prover, mr := newProvingTestState(t)
+	prover.InstanceId = "prover-a"
+	prover.BuildCommit = "abc123"
+	prover.FleetHeartbeatInterval = 20 * time.Millisecond
+	prover.Shard = &Shard{Count: 2, Index: 0}
+	prover.running.Store("job-2", struct{}{})
+	prover.running.Store("job-1", struct{}{})
+
+	verifier := &State{RedisClient: prover.RedisClient, Circuits: prover.Circuits, InstanceId: "verifier-b", VerifyOnly: true}
+	verifier.Shard = &Shard{Count: 2, Index: 1}
+	verifier.StopAccepting()
+
+	// An instance that stopped heartbeating long ago, whose registration
+	// has not expired yet.
+	stale := &State{RedisClient: prover.RedisClient, InstanceId: "stale-c"}
+	staleInstance := stale.fleetInstance("gone", time.Now().Add(-time.Hour))
+	staleInstance.LastHeartbeat = time.Now().Add(-2 * stale.fleetTTL())
+	if err := stale.heartbeat(ctx, staleInstance); err != nil {
+		t.Fatal(err)
+	}
+
+	stopProver := prover.StartFleetHeartbeat()
+	stopped := false
+	defer func() {
+		if !stopped {
+			stopProver()
+		}
+	}()
+	if err := verifier.heartbeat(ctx, verifier.fleetInstance("verifier-host", time.Now())); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every instance answers with the same fleet.
+	fleet := fleetView(t, verifier)
+	if fleet.Self != "verifier-b" {
+		t.Fatalf("self = %s, want verifier-b", fleet.Self)
+	}
+	if ids := instanceIds(fleet); len(ids) != 2 || ids[0] != "prover-a" || ids[1] != "verifier-b" {
+		t.Fatalf("fleet = %v, want [prover-a verifier-b]", ids)
+	}
+	if ids := instanceIds(fleetView(t, prover)); len(ids) != 2 {
+		t.Fatalf("fleet of prover-a = %v", ids)
+	}
+	if ids, err := prover.RedisClient.ZRange(ctx, redisFleetKey, 0, -1).Result(); err != nil || len(ids) != 2 {
+		t.Fatalf("registry = %v, %v, want the stale registration dropped", ids, err)
+	}
+
+	a, b := fleet.Instances[0], fleet.Instances[1]
+	if a.Role != fleetRoleProver || a.Draining || a.BuildCommit != "abc123" || a.ApiVersion == "" {
+		t.Fatalf("prover-a = %+v", a)
+	}
+	if a.Shard == nil || *a.Shard != (FleetShard{Count: 2, Index: 0}) {
+		t.Fatalf("shard of prover-a = %+v", a.Shard)
+	}
+	if len(a.RunningJobs) != 2 || a.RunningJobs[0] != "job-1" || a.RunningJobs[1] != "job-2" {
+		t.Fatalf("running jobs of prover-a = %v", a.RunningJobs)
+	}
+	if a.CircuitReleases["default"] != "0123456789ab" {
+		t.Fatalf("circuit releases of prover-a = %v", a.CircuitReleases)
+	}
+	if b.Role != fleetRoleVerifyOnly || !b.Draining || b.Hostname != "verifier-host" || b.RunningJobs == nil {
+		t.Fatalf("verifier-b = %+v", b)
+	}
+	if b.Shard == nil || *b.Shard != (FleetShard{Count: 2, Index: 1}) {
+		t.Fatalf("shard of verifier-b = %+v", b.Shard)
+	}
+
+	// The heartbeat refreshes the registration of prover-a.
+	time.Sleep(5 * prover.FleetHeartbeatInterval)
+	if refreshed := fleetView(t, verifier).Instances[0]; !refreshed.LastHeartbeat.After(a.LastHeartbeat) {
+		t.Fatalf("last heartbeat of prover-a stayed %v", a.LastHeartbeat)
+	}
+
+	// An instance that shuts down leaves the registry.
+	stopProver()
+	stopped = true
+	if ids := instanceIds(fleetView(t, verifier)); len(ids) != 1 || ids[0] != "verifier-b" {
+		t.Fatalf("fleet after prover-a stopped = %v, want [verifier-b]", ids)
+	}
+
+	// A registration that is not refreshed expires.
+	mr.FastForward(verifier.fleetTTL() + time.Second)
+	if fleet := fleetView(t, prover); len(fleet.Instances) != 0 || fleet.Self != "prover-a" {
+		t.Fatalf("fleet after verifier-b expired = %+v, want none", fleet)
+	}
+}
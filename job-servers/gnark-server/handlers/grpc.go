@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gnark-server/circuitData"
+	pb "gnark-server/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer exposes StartProof, GetProof and Health over gRPC. It shares State, and
+// therefore the Redis job store and circuit data, with the HTTP handlers.
+type GRPCServer struct {
+	pb.UnimplementedGnarkServerServer
+	State *State
+}
+
+func (g *GRPCServer) StartProof(ctx context.Context, req *pb.ProofRequest) (*pb.StartProofResponse, error) {
+	rawInput := ProofRequest{
+		Proof:          string(req.GetProof()),
+		VerifierData:   string(req.GetVerifierData()),
+		CallbackUrl:    req.GetCallbackUrl(),
+		TtlSeconds:     req.GetTtlSeconds(),
+		IdempotencyKey: req.GetIdempotencyKey(),
+		Circuit:        incomingMetadata(ctx, grpcCircuitMetadata),
+	}
+	if ms := req.GetUpstreamCreatedAtMs(); ms != 0 {
+		createdAt := time.UnixMilli(ms)
+		rawInput.UpstreamCreatedAt = &createdAt
+	}
+	notAfter, err := parseNotAfter(incomingMetadata(ctx, grpcNotAfterMetadata))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	rawInput.NotAfter = notAfter
+	started, err := g.State.startProof(ctx, rawInput)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.StartProofResponse{JobId: started.jobId}, nil
+}
+
+func (g *GRPCServer) GetProof(ctx context.Context, req *pb.GetProofRequest) (*pb.GetProofResponse, error) {
+	response, err := g.State.getProof(ctx, req.GetJobId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &pb.GetProofResponse{
+		Success:      response.Success,
+		ErrorMessage: response.ErrorMessage,
+	}
+	if response.Proof != nil {
+		proof, err := hex.DecodeString(response.Proof.Proof)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "stored proof is not valid hex")
+		}
+		resp.Proof = &pb.ProveResult{
+			PublicInputs: response.Proof.PublicInputs,
+			Proof:        proof,
+		}
+	}
+	return resp, nil
+}
+
+func (g *GRPCServer) Health(ctx context.Context, req *pb.HealthRequest) (*pb.HealthResponse, error) {
+	if g.State.VerifyOnly {
+		if err := g.State.validateCircuits((*circuitData.CircuitData).ValidateVerifyingKey); err != nil {
+			return &pb.HealthResponse{
+				Status: "unavailable",
+				Error:  "circuit data validation failed: " + err.Error(),
+			}, nil
+		}
+		return &pb.HealthResponse{Status: "degraded", Error: errProvingDisabled.Error()}, nil
+	}
+	if err := g.State.validateCircuits((*circuitData.CircuitData).Validate); err != nil {
+		return &pb.HealthResponse{
+			Status: "unavailable",
+			Error:  "circuit data validation failed: " + err.Error(),
+		}, nil
+	}
+	return &pb.HealthResponse{Status: "ok"}, nil
+}
+
+// grpcCircuitMetadata is the request metadata key that selects the circuit
+// of a StartProof call.
+const grpcCircuitMetadata = "circuit"
+
+// incomingMetadata returns the first value of key in the request metadata.
+func incomingMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func grpcError(err error) error {
+	var reqErr *RequestError
+	var fullErr *QueueFullError
+	switch {
+	case err == errJobExpired:
+		return status.Error(codes.NotFound, err.Error())
+	case errors.As(err, &reqErr) && reqErr.Code == codeCircuitNotAllowed:
+		return status.Error(codes.PermissionDenied, reqErr.Error())
+	case errors.As(err, &reqErr):
+		return status.Error(codes.InvalidArgument, reqErr.Error())
+	case errors.As(err, &fullErr):
+		return status.Error(codes.ResourceExhausted, fullErr.Error())
+	case err == errShuttingDown, err == errProvingDisabled:
+		return status.Error(codes.Unavailable, err.Error())
+	case err == errInvalidJobId:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case err == errJobNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		return status.Error(codes.Internal, "Internal server error")
+	}
+}
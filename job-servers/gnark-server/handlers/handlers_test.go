@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"gnark-server/circuitData"
+	"gnark-server/middleware"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// testCircuitDigest is the circuit digest of the verifier data in testdata.
+const testCircuitDigest = "7333968704277044365911105813294038499737090437135973260233960671933432682220"
+
+const testJWTSecret = "0123456789abcdef0123456789abcdef"
+
+// newTestState returns a State backed by an in-memory Redis.
+func newTestState(t *testing.T) (*State, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return &State{RedisClient: rdb}, mr
+}
+
+// newProvingTestState returns a State backed by an in-memory Redis that
+// accepts proofs for the circuit of testdata, without loading its keys.
+func newProvingTestState(t *testing.T) (*State, *miniredis.Miniredis) {
+	t.Helper()
+	s, mr := newTestState(t)
+	s.Circuits = circuitData.Registry{
+		circuitData.DefaultCircuit: {Name: circuitData.DefaultCircuit, ReleaseId: "0123456789ab"},
+	}
+	return s, mr
+}
+
+// testProofRequest returns a start-proof request for the plonky2 proof in
+// testdata.
+func testProofRequest(t *testing.T) ProofRequest {
+	t.Helper()
+	proof, err := os.ReadFile("../testdata/proof_with_public_inputs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifierData, err := os.ReadFile("../testdata/verifier_only_circuit_data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ProofRequest{Proof: string(proof), VerifierData: string(verifierData)}
+}
+
+// addFinishedJob records a job that finished in state with its stored input
+// and returns its ID.
+func addFinishedJob(t *testing.T, s *State, state string, input ProofRequest) string {
+	t.Helper()
+	ctx := context.Background()
+	jobId := uuid.NewString()
+	errMsg := "prover failed"
+	response := ProofResponse{Success: state != jobStateFailed}
+	if state == jobStateFailed {
+		response.ErrorMessage = &errMsg
+	}
+	if err := s.setProofResponse(ctx, jobId, response, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), metaState, state).Err(); err != nil {
+		t.Fatal(err)
+	}
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RedisClient.Set(ctx, getRedisInputKey(jobId), inputJSON, time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return jobId
+}
+
+// jwtAuth returns the JWT authentication of the server and a token for it
+// with the given allowed_circuits claim.
+func jwtAuth(t *testing.T, allowedCircuits []string) (*middleware.Auth, string) {
+	t.Helper()
+	auth, err := middleware.JWTAuth(testJWTSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := middleware.JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "indexer",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		AllowedCircuits: allowedCircuits,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth, token
+}
+
+// serve sends a request with the bearer token to handler and returns the
+// response.
+func serve(handler http.HandlerFunc, method string, target string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// errorCode returns the code of an error response.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error response %q: %v", w.Body.String(), err)
+	}
+	return body.Code
+}
+
+// queuedJobs returns the IDs of the jobs in the queues, of every priority.
+func queuedJobs(t *testing.T, s *State) []string {
+	t.Helper()
+	var jobs []string
+	for _, key := range redisQueueKeys() {
+		ids, err := s.RedisClient.ZRange(context.Background(), key, 0, -1).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, ids...)
+	}
+	return jobs
+}
@@ -1,10 +1,174 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gnark-server/circuitData"
 )
 
-func HealthHandler(w http.ResponseWriter, r *http.Request) {
+type HealthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// HealthHandler reports whether the loaded circuit data is usable, so that
+// readiness probes stop routing traffic to an instance whose keys failed to
+// load.
+func (s *State) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	validate := (*circuitData.CircuitData).Validate
+	if s.VerifyOnly {
+		validate = (*circuitData.CircuitData).ValidateVerifyingKey
+	}
+	if err := s.validateCircuits(validate); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(HealthResponse{
+			Status: "unavailable",
+			Error:  "circuit data validation failed: " + err.Error(),
+		})
+		return
+	}
+	if s.VerifyOnly {
+		fmt.Fprintf(w, "OK (verify-only)")
+		return
+	}
 	fmt.Fprintf(w, "OK")
-}
\ No newline at end of file
+}
+
+const readinessRedisTimeout = 500 * time.Millisecond
+
+// ReadinessCheck is the result of one readiness check.
+type ReadinessCheck struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs *int64 `json:"latencyMs,omitempty"`
+}
+
+type ReadinessResponse struct {
+	// Status is "ok", "fail", or "degraded" when the server runs in
+	// verify-only mode and every other check passes.
+	Status string `json:"status"`
+	// Degraded explains why proving is disabled in verify-only mode.
+	Degraded string                    `json:"degraded,omitempty"`
+	Checks   map[string]ReadinessCheck `json:"checks"`
+}
+
+// ReadyHandler checks that Redis answers a PING and that the proving key,
+// verifying key and constraint system are loaded. It responds 503 if any
+// check fails. In verify-only mode the missing proving key is reported as
+// "degraded" and does not fail readiness, since verification and reads are
+// still served. When the wrapper's pinned circuit digest was checked at
+// startup, a mismatch is reported with status "warn" under wrapperDigest,
+// without failing readiness. The checks only inspect data already in memory and ping Redis
+// with a short timeout, so it is cheap enough for frequent probes.
+func (s *State) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	resp := s.checkReadiness(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Status == "fail" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkReadiness runs the readiness checks and records the result in the
+// readiness history.
+func (s *State) checkReadiness(ctx context.Context) ReadinessResponse {
+	ctx, cancel := context.WithTimeout(ctx, readinessRedisTimeout)
+	defer cancel()
+	startedAt := time.Now()
+	redisErr := s.RedisClient.Ping(ctx).Err()
+	latencyMs := time.Since(startedAt).Milliseconds()
+
+	resp := ReadinessResponse{Status: "ok", Checks: map[string]ReadinessCheck{}}
+	record := func(name string, err error) {
+		check := ReadinessCheck{Status: "ok"}
+		if err != nil {
+			check = ReadinessCheck{Status: "fail", Error: err.Error()}
+			resp.Status = "fail"
+		} else if name == "redis" {
+			check.LatencyMs = &latencyMs
+		}
+		resp.Checks[name] = check
+	}
+	record("redis", redisErr)
+	// The checks of each circuit are prefixed with its name when several
+	// are loaded.
+	circuits := s.sortedCircuits()
+	for _, data := range circuits {
+		prefix := ""
+		if len(circuits) > 1 {
+			prefix = data.Name + "."
+		}
+		if s.VerifyOnly {
+			resp.Checks[prefix+"provingKey"] = ReadinessCheck{Status: "degraded", Error: errProvingDisabled.Error()}
+		} else {
+			record(prefix+"provingKey", data.ValidateProvingKey())
+		}
+		record(prefix+"verifyingKey", data.ValidateVerifyingKey())
+		record(prefix+"constraintSystem", data.ValidateConstraintSystem())
+	}
+	if check := s.wrapperDigest.Load(); check != nil {
+		resp.Checks["wrapperDigest"] = *check
+	}
+	if s.VerifyOnly && resp.Status == "ok" {
+		resp.Status = "degraded"
+		resp.Degraded = errProvingDisabled.Error()
+	}
+	s.readinessHistory.record(resp, time.Now())
+	return resp
+}
+
+const readinessHistorySize = 100
+
+// ReadinessTransition is a change of the overall readiness status.
+type ReadinessTransition struct {
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	// Failing lists the checks that did not pass.
+	Failing []string `json:"failing,omitempty"`
+}
+
+// readinessHistory keeps the last readinessHistorySize readiness status
+// changes seen by this instance. Repeated results are not recorded, so
+// frequent probes do not push older changes out.
+type readinessHistory struct {
+	mu          sync.Mutex
+	transitions []ReadinessTransition
+	lastChecked time.Time
+}
+
+func (h *readinessHistory) record(resp ReadinessResponse, now time.Time) {
+	var failing []string
+	for name, check := range resp.Checks {
+		if check.Status != "ok" {
+			failing = append(failing, name)
+		}
+	}
+	sort.Strings(failing)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastChecked = now
+	if n := len(h.transitions); n > 0 && h.transitions[n-1].Status == resp.Status &&
+		slices.Equal(h.transitions[n-1].Failing, failing) {
+		return
+	}
+	h.transitions = append(h.transitions, ReadinessTransition{Time: now, Status: resp.Status, Failing: failing})
+	if len(h.transitions) > readinessHistorySize {
+		h.transitions = h.transitions[len(h.transitions)-readinessHistorySize:]
+	}
+}
+
+// snapshot returns the recorded transitions, oldest first, and when
+// readiness was last checked.
+func (h *readinessHistory) snapshot() ([]ReadinessTransition, time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.transitions), h.lastChecked
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gnark-server/circuitData"
+)
+
+func TestVerifyOnlyMode(t *testing.T) {
+	dir := writeReleaseDir(t)
+	pkPath := filepath.Join(dir, circuitData.BackendFiles(circuitData.BackendGroth16).ProvingKey)
+	pk, err := os.ReadFile(pkPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pkPath, pk[:len(pk)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := circuitData.InitCircuitDataFromDir(dir, circuitData.BackendGroth16, false)
+	if !errors.Is(err, circuitData.ErrProvingKey) {
+		t.Fatalf("InitCircuitDataFromDir() = %v, want ErrProvingKey", err)
+	}
+
+	s, _ := newTestState(t)
+	s.StoreInputs = true
+	s.Circuits = circuitData.Registry{circuitData.DefaultCircuit: data}
+	s.VerifyOnly = true
+	finished := addFinishedJob(t, s, jobStateDone, testProofRequest(t))
+
+	// Proving is refused with proving_disabled.
+	body := func(t *testing.T) string {
+		raw, err := json.Marshal(testProofRequest(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+	refused := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    string
+	}{
+		{"start-proof", s.StartProof, "/start-proof", body(t)},
+		{"start-proofs", s.StartProofs, "/start-proofs", "[" + body(t) + "]"},
+		{"replay", s.Jobs, "/jobs/" + finished + "/replay", ""},
+	}
+	for _, tt := range refused {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body)))
+			if w.Code != http.StatusServiceUnavailable || errorCode(t, w) != codeProvingDisabled {
+				t.Fatalf("%s: %d %s, want 503 %s", tt.name, w.Code, w.Body, codeProvingDisabled)
+			}
+		})
+	}
+	if queued := queuedJobs(t, s); len(queued) != 0 {
+		t.Fatalf("queued %v in verify-only mode", queued)
+	}
+
+	// Reads, verification and health keep working.
+	served := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		want    string
+	}{
+		{"get-proof", s.GetProof, "/get-proof?jobId=" + finished, `"success":true`},
+		{"health", s.HealthHandler, "/health", "OK (verify-only)"},
+		{"ready", s.ReadyHandler, "/health/ready", `"status":"degraded"`},
+		{"verifier", s.ExportVerifier, "/verifier", "contract"},
+		{"stats", s.Stats, "/stats", "clockSkewClamped"},
+	}
+	for _, tt := range served {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.handler, http.MethodGet, tt.target, "")
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), tt.want) {
+				t.Fatalf("%s: %d %s, want 200 containing %s", tt.name, w.Code, w.Body, tt.want)
+			}
+		})
+	}
+	var ready ReadinessResponse
+	if err := json.Unmarshal(serve(s.ReadyHandler, http.MethodGet, "/health/ready", "").Body.Bytes(), &ready); err != nil {
+		t.Fatal(err)
+	}
+	if ready.Degraded == "" || ready.Checks["provingKey"].Status != "degraded" || ready.Checks["verifyingKey"].Status != "ok" {
+		t.Fatalf("readiness = %+v, want the proving key reported as degraded", ready)
+	}
+
+	// Workers do not claim jobs queued by other instances.
+	s.VerifyOnly = false
+	queued := *startProofs(t, s, []ProofRequest{testProofRequest(t)})[0].JobId
+	s.VerifyOnly = true
+	s.StartWorker(0)
+	time.Sleep(100 * time.Millisecond)
+	s.StopAccepting()
+	if jobs := queuedJobs(t, s); len(jobs) != 1 || jobs[0] != queued {
+		t.Fatalf("queue holds %v, want %s left for other instances", jobs, queued)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"gnark-server/middleware"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisIdempotencyKeyPrefix = "gnark_proof_idempotency:"
+	defaultIdempotencyWindow  = 24 * time.Hour
+	maxIdempotencyKeyLength   = 255
+
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// metaIdempotencyKey records the Redis key of the idempotency claim of a
+	// job, so that deleting the job can release it.
+	metaIdempotencyKey = "idempotencyKey"
+)
+
+// releaseClaimScript deletes KEYS[1] only if it still maps to the job in
+// ARGV[1], so a failed submission never releases another job's claim.
+var releaseClaimScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// getRedisIdempotencyKey scopes keys to the submitting client so that two
+// clients picking the same key do not see each other's jobs.
+func getRedisIdempotencyKey(ctx context.Context, key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s%s:%s", redisIdempotencyKeyPrefix, middleware.ClientLabel(ctx), hex.EncodeToString(digest[:]))
+}
+
+func validateIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return &RequestError{
+			Code:    "invalid_idempotency_key",
+			Message: fmt.Sprintf("idempotency key must be at most %d characters", maxIdempotencyKeyLength),
+		}
+	}
+	return nil
+}
+
+// claimIdempotencyKey atomically maps key to jobId. If the key is already
+// mapped within the idempotency window, the existing job ID is returned and
+// the caller must not enqueue jobId.
+func (s *State) claimIdempotencyKey(ctx context.Context, key string, jobId string) (string, error) {
+	window := s.IdempotencyWindow
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+	return s.claim(ctx, getRedisIdempotencyKey(ctx, key), jobId, window)
+}
+
+// claim atomically maps redisKey to jobId for ttl. If the key already maps to
+// a job, that job's ID is returned instead.
+func (s *State) claim(ctx context.Context, redisKey string, jobId string, ttl time.Duration) (string, error) {
+	for {
+		claimed, err := s.RedisClient.SetNX(ctx, redisKey, jobId, ttl).Result()
+		if err != nil {
+			return "", err
+		}
+		if claimed {
+			return "", nil
+		}
+		existing, err := s.RedisClient.Get(ctx, redisKey).Result()
+		if err == redis.Nil {
+			// The claim expired or was released between SETNX and GET, try
+			// again.
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		return existing, nil
+	}
+}
+
+// releaseIdempotencyKey undoes a claim whose job could not be enqueued, so
+// that a retry with the same key is not answered with a job that never ran.
+func (s *State) releaseIdempotencyKey(ctx context.Context, key string, jobId string) {
+	if err := s.release(ctx, getRedisIdempotencyKey(ctx, key), jobId); err != nil {
+		log.Printf("Failed to release idempotency key of job %s: %v\n", jobId, err)
+	}
+}
+
+// release deletes a claim made with claim, if it still maps to jobId.
+func (s *State) release(ctx context.Context, redisKey string, jobId string) error {
+	err := releaseClaimScript.Run(ctx, s.RedisClient, []string{redisKey}, jobId).Err()
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
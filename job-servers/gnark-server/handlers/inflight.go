@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"gnark-server/circuitData"
+)
+
+const (
+	redisInflightKeyPrefix = "gnark_proof_inflight:"
+
+	// deduplicatedHeader marks start-proof responses that returned the job
+	// of an identical submission still in flight.
+	deduplicatedHeader = "X-Deduplicated"
+
+	metaInputDigest = "inputDigest"
+)
+
+// getRedisInflightKey maps the digest of a request's public inputs to the
+// job proving them. Unlike idempotency keys it is shared by all clients.
+func getRedisInflightKey(digest string) string {
+	return redisInflightKeyPrefix + digest
+}
+
+// requestDigest returns the digest identifying the public inputs of a
+// validated request for data, the same key the proof cache uses.
+func requestDigest(rawInput ProofRequest, data circuitData.CircuitData) (string, error) {
+	proofRaw, vdRaw, err := parseProofRequest(rawInput)
+	if err != nil {
+		return "", err
+	}
+	return proofCacheKey(proofRaw, vdRaw, data)
+}
+
+// claimInflight marks jobId as the job proving digest. If another job is
+// already proving it, that job's ID is returned and the caller must not
+// enqueue jobId.
+func (s *State) claimInflight(ctx context.Context, digest string, jobId string) (string, error) {
+	return s.claim(ctx, getRedisInflightKey(digest), jobId, expiration)
+}
+
+// releaseInflight lets new submissions of digest start a job again. It is
+// called once the job's result is stored, and when the job could not be
+// enqueued.
+func (s *State) releaseInflight(ctx context.Context, digest string, jobId string) {
+	if err := s.release(ctx, getRedisInflightKey(digest), jobId); err != nil {
+		log.Printf("Failed to release in-flight key of job %s: %v\n", jobId, err)
+	}
+}
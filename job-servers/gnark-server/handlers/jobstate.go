@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Lifecycle states of a job. Unlike stages, which follow the prover step by
+// step, states only change when the job is queued, picked up by a worker,
+// finished or expired. jobStateCancelled is reserved for jobs cancelled by a
+// client, which this server does not support yet.
+const (
+	jobStateQueued    = "queued"
+	jobStateProving   = "proving"
+	jobStateDone      = "done"
+	jobStateFailed    = "failed"
+	jobStateCancelled = "cancelled"
+	jobStateExpired   = "expired"
+
+	metaState = "state"
+)
+
+var jobStates = []string{jobStateQueued, jobStateProving, jobStateDone, jobStateFailed, jobStateCancelled, jobStateExpired}
+
+// JobRecord is the lifecycle of a job: its current state and when it
+// entered each state it went through, in UTC. A retried job starts over from
+// queued, and Attempts counts its runs. NonProvable is set on jobs imported
+// from a snapshot of a circuit release that is not loaded.
+type JobRecord struct {
+	State       string               `json:"state"`
+	Timestamps  map[string]time.Time `json:"timestamps"`
+	Attempts    int64                `json:"attempts"`
+	NonProvable bool                 `json:"nonProvable,omitempty"`
+	// Priority is the queue the job was submitted to.
+	Priority string `json:"priority,omitempty"`
+	// ProveTimeoutSeconds is the prove timeout the job ran with, or was
+	// submitted with while it is queued.
+	ProveTimeoutSeconds int64 `json:"proveTimeoutSeconds,omitempty"`
+	// CallbackStatus is suppressed_duplicate when the callback was not sent
+	// because CallbackDuplicateOf delivered an identical one.
+	CallbackStatus      string `json:"callbackStatus,omitempty"`
+	CallbackDuplicateOf string `json:"callbackDuplicateOf,omitempty"`
+	// NotAfter is the deadline the job was submitted with. Once it was
+	// claimed, DeadlineDecision is proceed, likely_late or expired, taken
+	// against a p95 proving time of DeadlineEstimateMs (0 without enough
+	// samples).
+	NotAfter           *time.Time `json:"notAfter,omitempty"`
+	DeadlineDecision   string     `json:"deadlineDecision,omitempty"`
+	DeadlineEstimateMs int64      `json:"deadlineEstimateMs,omitempty"`
+}
+
+// metaStateAt is the metadata field holding when a job entered state.
+func metaStateAt(state string) string {
+	return state + "At"
+}
+
+// stateFields returns the metadata fields that move a job to state at now.
+func stateFields(state string, now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		metaState:          state,
+		metaStateAt(state): now.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// queuedFields returns the stage and state metadata of a newly queued job.
+func queuedFields(now time.Time) map[string]interface{} {
+	fields := stateFields(jobStateQueued, now)
+	fields[metaStage] = stageQueued
+	return fields
+}
+
+// setJobState records that a job entered state, and logs the transition
+// with details in the event log in the same transaction.
+func (s *State) setJobState(ctx context.Context, jobId string, state string, details map[string]interface{}) {
+	metaKey := getRedisMetaKey(jobId)
+	fields := stateFields(state, time.Now())
+	pipe := s.RedisClient.TxPipeline()
+	pipe.HSet(ctx, metaKey, fields)
+	pipe.Expire(ctx, metaKey, expiration)
+	s.queueTransition(ctx, pipe, jobId, fields, details)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to record state %s of job %s: %v\n", state, jobId, err)
+	}
+}
+
+// jobRecordFromMetadata returns the lifecycle recorded in a job's metadata,
+// or nil for jobs submitted before states were recorded.
+func jobRecordFromMetadata(meta map[string]string) *JobRecord {
+	if meta[metaState] == "" {
+		return nil
+	}
+	record := &JobRecord{
+		State:       meta[metaState],
+		Timestamps:  map[string]time.Time{},
+		Attempts:    attemptsFromMetadata(meta),
+		NonProvable: meta[metaNonProvable] == "true",
+		Priority:    meta[metaPriority],
+		// The callback fields are only recorded by suppressed deliveries.
+		CallbackStatus:      meta[metaCallbackStatus],
+		CallbackDuplicateOf: meta[metaCallbackDuplicateOf],
+	}
+	record.ProveTimeoutSeconds, _ = strconv.ParseInt(meta[metaProveTimeoutSeconds], 10, 64)
+	deadlineFromMetadata(record, meta)
+	for _, state := range jobStates {
+		if at, err := time.Parse(time.RFC3339Nano, meta[metaStateAt(state)]); err == nil {
+			record.Timestamps[state] = at
+		}
+	}
+	return record
+}
+
+// jobRecord loads the lifecycle of a job that is still in Redis.
+func (s *State) jobRecord(ctx context.Context, jobId string) *JobRecord {
+	meta, err := s.getJobMetadata(ctx, jobId)
+	if err != nil {
+		log.Printf("Failed to load metadata for job %s: %v\n", jobId, err)
+		return nil
+	}
+	return jobRecordFromMetadata(meta)
+}
+
+// expiredJobRecord returns the lifecycle kept in the expired marker of a job
+// whose records are gone. Markers written before states were recorded only
+// hold the expiry time and yield nil.
+func (s *State) expiredJobRecord(ctx context.Context, jobId string) *JobRecord {
+	marker, err := s.RedisClient.Get(ctx, getRedisExpiredKey(jobId)).Result()
+	if err != nil {
+		return nil
+	}
+	var record JobRecord
+	if err := json.Unmarshal([]byte(marker), &record); err != nil || record.State == "" {
+		return nil
+	}
+	return &record
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gnark-server/jobstore"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// jobStoreTombstoneAge is the age past which a pending job of the job store
+// that Redis has no trace of is not re-enqueued. Every finished job leaves an
+// expired marker in Redis for at most this long, so an older job may have
+// been finished by another instance, whose job store is not this one.
+const jobStoreTombstoneAge = maxResultTTL + expiredMarkerRetention
+
+// storeJob records a job about to be queued in the job store, if enabled,
+// along with the metadata it is queued with. It is called before the job is
+// written to Redis, so that a crash in between cannot lose it, and the job
+// is refused if it cannot be recorded. If Redis then fails, unstoreJob
+// removes the record again.
+func (s *State) storeJob(jobId string, input ProofRequest, meta map[string]interface{}) error {
+	if s.JobStore == nil {
+		return nil
+	}
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to encode job %s for the job store: %w", jobId, err)
+	}
+	metadata := make(map[string]string, len(meta))
+	for k, v := range meta {
+		metadata[k] = fmt.Sprint(v)
+	}
+	inputHash := sha256.Sum256([]byte(input.Proof))
+	circuit := metadata[metaCircuit]
+	if circuit == "" {
+		circuit = input.Circuit
+	}
+	err = s.JobStore.Create(jobstore.Job{
+		JobId:     jobId,
+		InputHash: hex.EncodeToString(inputHash[:]),
+		Circuit:   circuit,
+		Input:     inputJSON,
+		Metadata:  metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record job %s in the job store: %w", jobId, err)
+	}
+	return nil
+}
+
+// storeRetriedJob records a job that retry-proof queued again as pending in
+// the job store, if enabled, with its metadata as the retry left it. The
+// retry is already in Redis, so a failure is logged.
+func (s *State) storeRetriedJob(ctx context.Context, jobId string, input ProofRequest) {
+	if s.JobStore == nil {
+		return
+	}
+	meta, err := s.getJobMetadata(ctx, jobId)
+	if err != nil {
+		log.Printf("Failed to load metadata for job %s: %v\n", jobId, err)
+	}
+	fields := make(map[string]interface{}, len(meta))
+	for k, v := range meta {
+		fields[k] = v
+	}
+	if err := s.storeJob(jobId, input, fields); err != nil {
+		log.Println(err)
+	}
+}
+
+// unstoreJob removes the record of a job that could not be queued, or was
+// deleted, from the job store, if enabled.
+func (s *State) unstoreJob(jobId string) {
+	if s.JobStore == nil {
+		return
+	}
+	if err := s.JobStore.Delete(jobId); err != nil && !errors.Is(err, jobstore.ErrNotFound) {
+		log.Printf("Failed to remove job %s from the job store: %v\n", jobId, err)
+	}
+}
+
+// storeJobResult records the terminal response of a job in the job store, if
+// enabled.
+func (s *State) storeJobResult(jobId string, response ProofResponse) {
+	if s.JobStore == nil {
+		return
+	}
+	status := jobstore.StatusFailed
+	var proof []byte
+	var publicInputs []string
+	var errMsg string
+	if response.Success && response.Proof != nil {
+		status = jobstore.StatusDone
+		proof, _ = hex.DecodeString(response.Proof.Proof)
+		publicInputs = response.Proof.PublicInputs
+	}
+	if response.ErrorMessage != nil {
+		errMsg = *response.ErrorMessage
+	}
+	if err := s.JobStore.Finish(jobId, status, proof, publicInputs, errMsg); err != nil && !errors.Is(err, jobstore.ErrNotFound) {
+		log.Printf("Failed to record result of job %s in the job store: %v\n", jobId, err)
+	}
+}
+
+// storedProofResponse returns the response of a job the job store recorded
+// as finished, for get-proof when Redis lost it. It returns false if the
+// store is disabled or does not know the job as finished.
+func (s *State) storedProofResponse(jobId string) (ProofResponse, bool) {
+	if s.JobStore == nil {
+		return ProofResponse{}, false
+	}
+	job, err := s.JobStore.Get(jobId)
+	if err != nil {
+		if !errors.Is(err, jobstore.ErrNotFound) {
+			log.Printf("Failed to read job %s from the job store: %v\n", jobId, err)
+		}
+		return ProofResponse{}, false
+	}
+	switch job.Status {
+	case jobstore.StatusDone:
+		result := newProveResult(job.PublicInputs, hex.EncodeToString(job.Proof))
+		return ProofResponse{Success: true, Proof: &result, Circuit: job.Circuit}, true
+	case jobstore.StatusFailed:
+		errMsg := job.ErrorMessage
+		return ProofResponse{Success: false, ErrorMessage: &errMsg, Circuit: job.Circuit}, true
+	default:
+		return ProofResponse{}, false
+	}
+}
+
+// RecoverPendingJobs re-enqueues the pending jobs of the job store that Redis
+// no longer knows about, for example after Redis lost its data, and catches
+// the store up on pending jobs that Redis already finished. Deleted jobs are
+// removed from the store. A job with an
+// expired marker finished and expired, possibly on another instance, and a
+// job older than jobStoreTombstoneAge may have, so neither is re-enqueued.
+// It is called on startup before the workers start and returns the number of
+// re-enqueued jobs.
+func (s *State) RecoverPendingJobs(ctx context.Context) (int, error) {
+	if s.JobStore == nil {
+		return 0, nil
+	}
+	jobs, err := s.JobStore.Pending()
+	if err != nil {
+		return 0, err
+	}
+	recovered := 0
+	for _, job := range jobs {
+		response, err := s.getProofResponse(ctx, job.JobId)
+		if err == nil {
+			if response.Proof != nil || response.ErrorMessage != nil {
+				s.storeJobResult(job.JobId, response)
+			}
+			continue
+		} else if err != redis.Nil {
+			return recovered, err
+		}
+		if deleted, err := s.isDeleted(ctx, job.JobId); err != nil {
+			return recovered, err
+		} else if deleted {
+			s.unstoreJob(job.JobId)
+			continue
+		}
+		finished, err := s.expiredJobStatus(ctx, job.JobId)
+		if err != nil {
+			return recovered, err
+		}
+		if finished == "" && time.Since(job.CreatedAt) > jobStoreTombstoneAge {
+			finished = jobstore.StatusFailed
+		}
+		if finished != "" {
+			errMsg := ""
+			if finished == jobstore.StatusFailed {
+				errMsg = "the job was not in Redis when this instance recovered it"
+			}
+			if err := s.JobStore.Finish(job.JobId, finished, nil, nil, errMsg); err != nil {
+				return recovered, err
+			}
+			continue
+		}
+		var input ProofRequest
+		if err := json.Unmarshal(job.Input, &input); err != nil {
+			log.Printf("Cannot recover job %s, its stored input is invalid: %v\n", job.JobId, err)
+			continue
+		}
+		meta := s.recoveredMetadata(job, input)
+		for k, v := range queuedFields(time.Now()) {
+			meta[k] = v
+		}
+		pipe := s.RedisClient.TxPipeline()
+		if err := queueProofResponse(ctx, pipe, job.JobId, ProofResponse{Success: true}); err != nil {
+			return recovered, err
+		}
+		queueJobMetadata(ctx, pipe, job.JobId, meta)
+		s.queueTransition(ctx, pipe, job.JobId, meta, nil)
+		if err := enqueueJob(ctx, pipe, job.JobId, input); err != nil {
+			return recovered, err
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return recovered, err
+		}
+		if digest := job.Metadata[metaInputDigest]; digest != "" {
+			if _, err := s.claimInflight(ctx, digest, job.JobId); err != nil {
+				log.Printf("Failed to mark recovered job %s as in flight: %v\n", job.JobId, err)
+			}
+		}
+		log.Println("Recovered job", job.JobId, "from the job store")
+		recovered++
+	}
+	return recovered, nil
+}
+
+// recoveredMetadata returns the metadata to queue a recovered job with: the
+// metadata it was queued with, or what its input tells for jobs recorded
+// before the job store kept the metadata. The circuit of the job is always
+// set, since workers prove the job with it.
+func (s *State) recoveredMetadata(job jobstore.Job, input ProofRequest) map[string]interface{} {
+	meta := map[string]interface{}{}
+	for k, v := range job.Metadata {
+		meta[k] = v
+	}
+	if meta[metaCircuit] == nil {
+		switch {
+		case job.Circuit != "":
+			meta[metaCircuit] = job.Circuit
+		case input.Circuit != "":
+			meta[metaCircuit] = input.Circuit
+		}
+	}
+	if len(job.Metadata) > 0 {
+		return meta
+	}
+	if input.CallbackUrl != "" {
+		meta[metaCallbackUrl] = input.CallbackUrl
+		if data, err := s.circuit(input.Circuit); err == nil {
+			if digest, err := requestDigest(input, data); err == nil {
+				meta[metaCallbackInputDigest] = digest
+			}
+		}
+	}
+	if input.TtlSeconds > 0 {
+		meta[metaTTLSeconds] = strconv.FormatInt(input.TtlSeconds, 10)
+	}
+	if input.UpstreamCreatedAt != nil {
+		meta[metaUpstreamCreatedAt] = input.UpstreamCreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return meta
+}
+
+// expiredJobStatus returns the job store status of a job whose records
+// expired from Redis, done or failed, or "" if Redis has no expired marker
+// of it.
+func (s *State) expiredJobStatus(ctx context.Context, jobId string) (string, error) {
+	markerJSON, err := s.RedisClient.Get(ctx, getRedisExpiredKey(jobId)).Bytes()
+	if err == redis.Nil {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var record JobRecord
+	if err := json.Unmarshal(markerJSON, &record); err == nil {
+		if _, ok := record.Timestamps[jobStateDone]; ok {
+			return jobstore.StatusDone, nil
+		}
+	}
+	return jobstore.StatusFailed, nil
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gnark-server/circuitData"
+	"gnark-server/jobstore"
+)
+
+// openJobStore opens the job store at path and closes it with the test.
+func openJobStore(t *testing.T, path string) *jobstore.BoltStore {
+	t.Helper()
+	store, err := jobstore.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// twoCircuits returns a registry of two circuits proving the testdata
+// circuit, so that jobs have to name theirs.
+func twoCircuits() circuitData.Registry {
+	return circuitData.Registry{
+		"claim":      {Name: "claim", ReleaseId: "0123456789ab"},
+		"withdrawal": {Name: "withdrawal", ReleaseId: "ba9876543210"},
+	}
+}
+
+func TestRecoverPendingJobsAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	s, mr := newTestState(t)
+	s.Circuits = twoCircuits()
+	store, err := jobstore.OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.JobStore = store
+	input := testProofRequest(t)
+	input.Circuit = "withdrawal"
+	input.TtlSeconds = 600
+	started, err := s.startProof(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.getJobMetadata(ctx, started.jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server stops and Redis loses its data before it restarts.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mr.FlushAll()
+	restarted := &State{RedisClient: s.RedisClient, Circuits: twoCircuits(), JobStore: openJobStore(t, path)}
+	recovered, err := restarted.RecoverPendingJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered != 1 {
+		t.Fatalf("recovered %d jobs, want 1", recovered)
+	}
+	if queued := queuedJobs(t, restarted); len(queued) != 1 || queued[0] != started.jobId {
+		t.Fatalf("queued %v, want [%s]", queued, started.jobId)
+	}
+	meta, err := restarted.getJobMetadata(ctx, started.jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{metaCircuit, metaInputDigest, metaTTLSeconds} {
+		if meta[field] == "" || meta[field] != before[field] {
+			t.Errorf("recovered %s = %q, want %q", field, meta[field], before[field])
+		}
+	}
+	if meta[metaState] != jobStateQueued {
+		t.Errorf("recovered state = %q, want %s", meta[metaState], jobStateQueued)
+	}
+	if _, err := restarted.circuit(meta[metaCircuit]); err != nil {
+		t.Errorf("recovered job cannot be proved: %v", err)
+	}
+
+	// Recovering again finds the job in Redis and leaves it alone.
+	if recovered, err := restarted.RecoverPendingJobs(ctx); err != nil || recovered != 0 {
+		t.Fatalf("second recovery = %d, %v, want 0", recovered, err)
+	}
+}
+
+func TestRecoverPendingJobsSkipsFinishedJobs(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newProvingTestState(t)
+	s.JobStore = openJobStore(t, filepath.Join(t.TempDir(), "jobs.db"))
+	started, err := s.startProof(ctx, testProofRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance proved the job, so this job store never saw it
+	// finish, and its records expired.
+	marker, err := json.Marshal(JobRecord{State: jobStateExpired, Timestamps: map[string]time.Time{jobStateDone: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr.FlushAll()
+	mr.Set(getRedisExpiredKey(started.jobId), string(marker))
+
+	recovered, err := s.RecoverPendingJobs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recovered != 0 {
+		t.Fatalf("recovered %d jobs, want 0", recovered)
+	}
+	if queued := queuedJobs(t, s); len(queued) != 0 {
+		t.Fatalf("queued %v, want none", queued)
+	}
+	job, err := s.JobStore.Get(started.jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != jobstore.StatusDone {
+		t.Fatalf("job store status = %s, want %s", job.Status, jobstore.StatusDone)
+	}
+}
+
+func TestStartProofRefusedWithoutJobStore(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newProvingTestState(t)
+	store := openJobStore(t, filepath.Join(t.TempDir(), "jobs.db"))
+	s.JobStore = store
+	store.Close()
+
+	if _, err := s.startProof(ctx, testProofRequest(t)); err == nil {
+		t.Fatal("startProof queued a job the job store could not record")
+	}
+	if queued := queuedJobs(t, s); len(queued) != 0 {
+		t.Fatalf("queued %v, want none", queued)
+	}
+	// The in-flight claim was released, so the job can be submitted again.
+	s.JobStore = nil
+	if started, err := s.startProof(ctx, testProofRequest(t)); err != nil || started.deduplicated {
+		t.Fatalf("resubmission = %+v, %v, want a new job", started, err)
+	}
+}
+
+func TestGetProofFallsBackToJobStore(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newProvingTestState(t)
+	s.JobStore = openJobStore(t, filepath.Join(t.TempDir(), "jobs.db"))
+	started, err := s.startProof(ctx, testProofRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := newProveResult([]string{"1", "2"}, "abcd")
+	s.storeJobResult(started.jobId, ProofResponse{Success: true, Proof: &result})
+
+	mr.FlushAll()
+	response, err := s.getProof(ctx, started.jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Success || response.Proof == nil || response.Proof.Proof != "abcd" || response.Proof.InputHash != "2" {
+		t.Fatalf("getProof = %+v, want the stored proof", response)
+	}
+}
+
+func TestRecoverPendingJobsRestoresCircuit(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestState(t)
+	s.Circuits = twoCircuits()
+	s.JobStore = openJobStore(t, filepath.Join(t.TempDir(), "jobs.db"))
+
+	input := testProofRequest(t)
+	input.Circuit = "claim"
+	started, err := s.startProof(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := s.JobStore.Get(started.jobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Circuit != "claim" {
+		t.Fatalf("job store circuit = %q, want claim", job.Circuit)
+	}
+
+	// Records written before the job store kept the metadata only have
+	// the circuit.
+	inputJSON, err := json.Marshal(testProofRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyId := "7d3b8f2e-5c1a-4e6b-9f0d-2a4c6e8b0d1f"
+	if err := s.JobStore.Create(jobstore.Job{JobId: legacyId, Circuit: "withdrawal", Input: inputJSON}); err != nil {
+		t.Fatal(err)
+	}
+	if recovered, err := s.RecoverPendingJobs(ctx); err != nil || recovered != 1 {
+		t.Fatalf("RecoverPendingJobs = %d, %v, want 1", recovered, err)
+	}
+	circuit, err := s.RedisClient.HGet(ctx, getRedisMetaKey(legacyId), metaCircuit).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if circuit != "withdrawal" {
+		t.Fatalf("recovered circuit = %q, want withdrawal", circuit)
+	}
+}
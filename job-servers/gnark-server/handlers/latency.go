@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxUpstreamAge and maxUpstreamClockSkew bound how far in the past and
+	// in the future an upstreamCreatedAt may be.
+	maxUpstreamAge       = 7 * 24 * time.Hour
+	maxUpstreamClockSkew = 5 * time.Minute
+
+	metaUpstreamCreatedAt = "upstreamCreatedAt"
+	metaEndToEndLatencyMs = "endToEndLatencyMs"
+)
+
+// validateUpstreamCreatedAt checks the optional upstreamCreatedAt of a
+// request against now.
+func validateUpstreamCreatedAt(createdAt *time.Time, now time.Time) error {
+	if createdAt == nil {
+		return nil
+	}
+	if createdAt.Before(now.Add(-maxUpstreamAge)) || createdAt.After(now.Add(maxUpstreamClockSkew)) {
+		return &RequestError{
+			Code: "invalid_upstream_created_at",
+			Message: fmt.Sprintf("upstreamCreatedAt must be at most %v in the past and %v in the future",
+				maxUpstreamAge, maxUpstreamClockSkew),
+		}
+	}
+	return nil
+}
+
+// endToEndLatency returns the time from upstreamCreatedAt to finishedAt.
+// Latencies that are negative because of clock skew between the upstream and
+// this server are clamped to zero and reported as skewed.
+func endToEndLatency(createdAt time.Time, finishedAt time.Time) (latency time.Duration, skewed bool) {
+	latency = finishedAt.Sub(createdAt)
+	if latency < 0 {
+		return 0, true
+	}
+	return latency, false
+}
+
+// recordEndToEndLatency stores and records the latency from the upstream
+// creation time of a job to its completion. Jobs submitted without
+// upstreamCreatedAt are skipped.
+func (s *State) recordEndToEndLatency(ctx context.Context, jobId string, meta map[string]string, finishedAt time.Time) {
+	createdAt, err := time.Parse(time.RFC3339Nano, meta[metaUpstreamCreatedAt])
+	if err != nil {
+		return
+	}
+	latency, skewed := endToEndLatency(createdAt, finishedAt)
+	if skewed {
+		log.Printf("Job %s finished before its upstreamCreatedAt %s, clamping latency to 0\n", jobId, meta[metaUpstreamCreatedAt])
+		s.clockSkewClamped.Add(1)
+	}
+	s.Metrics.observeEndToEnd(latency, skewed)
+	s.endToEndLatencies.add(latency)
+	ms := strconv.FormatInt(latency.Milliseconds(), 10)
+	meta[metaEndToEndLatencyMs] = ms
+	if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), metaEndToEndLatencyMs, ms).Err(); err != nil {
+		log.Printf("Failed to record end-to-end latency of job %s: %v\n", jobId, err)
+	}
+}
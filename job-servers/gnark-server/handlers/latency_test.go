@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestValidateUpstreamCreatedAt(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		createdAt := now.Add(d)
+		return &createdAt
+	}
+	tests := []struct {
+		name      string
+		createdAt *time.Time
+		wantErr   bool
+	}{
+		{"missing", nil, false},
+		{"now", at(0), false},
+		{"an hour ago", at(-time.Hour), false},
+		{"oldest accepted", at(-maxUpstreamAge), false},
+		{"too old", at(-maxUpstreamAge - time.Second), true},
+		{"slightly ahead", at(time.Minute), false},
+		{"too far ahead", at(maxUpstreamClockSkew + time.Second), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateUpstreamCreatedAt(tt.createdAt, now); (err != nil) != tt.wantErr {
+				t.Fatalf("validateUpstreamCreatedAt() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEndToEndLatency(t *testing.T) {
+	tests := []struct {
+		name        string
+		createdAt   *time.Time
+		wantLatency bool
+		wantSkewed  bool
+	}{
+		{"normal", func() *time.Time { t := time.Now().Add(-time.Minute); return &t }(), true, false},
+		// The upstream clock runs a minute ahead of this server, so the job
+		// finishes before it was created.
+		{"skewed", func() *time.Time { t := time.Now().Add(time.Minute); return &t }(), true, true},
+		{"missing", nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newProvingTestState(t)
+			s.Metrics = NewMetrics(s.RedisClient)
+			ctx := context.Background()
+			input := testProofRequest(t)
+			input.UpstreamCreatedAt = tt.createdAt
+			body, err := json.Marshal(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			s.StartProof(w, httptest.NewRequest(http.MethodPost, "/start-proof", bytes.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("start-proof: %d %s", w.Code, w.Body)
+			}
+			var started StartProofResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil {
+				t.Fatal(err)
+			}
+			s.failJob(ctx, started.JobId, context.Canceled)
+
+			meta, err := s.getJobMetadata(ctx, started.JobId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			latencyMs, recorded := meta[metaEndToEndLatencyMs]
+			if recorded != tt.wantLatency {
+				t.Fatalf("job metadata %v, want end-to-end latency %v", meta, tt.wantLatency)
+			}
+			var stats StatsResponse
+			if err := json.Unmarshal(serve(s.Stats, http.MethodGet, "/stats", "").Body.Bytes(), &stats); err != nil {
+				t.Fatal(err)
+			}
+			var histogram dto.Metric
+			if err := s.Metrics.endToEnd.Write(&histogram); err != nil {
+				t.Fatal(err)
+			}
+			samples := histogram.GetHistogram().GetSampleCount()
+			if !tt.wantLatency {
+				if stats.EndToEndLatency != nil || samples != 0 {
+					t.Fatalf("stats %+v, %d histogram samples, want no latency recorded", stats, samples)
+				}
+				return
+			}
+			if stats.EndToEndLatency == nil || stats.EndToEndLatency.Count != 1 || samples != 1 {
+				t.Fatalf("stats %+v, %d histogram samples, want one latency recorded", stats, samples)
+			}
+			skewed := int64(0)
+			if tt.wantSkewed {
+				skewed = 1
+				if latencyMs != "0" || stats.EndToEndLatency.P99Ms != 0 {
+					t.Fatalf("skewed latency = %s ms, p99 %d ms, want it clamped to 0", latencyMs, stats.EndToEndLatency.P99Ms)
+				}
+			} else if stats.EndToEndLatency.P50Ms < time.Minute.Milliseconds() {
+				t.Fatalf("end-to-end p50 = %d ms, want at least a minute", stats.EndToEndLatency.P50Ms)
+			}
+			if stats.ClockSkewClamped != skewed || testutil.ToFloat64(s.Metrics.clockSkew) != float64(skewed) {
+				t.Fatalf("clock skew clamped %d, counter %v, want %d", stats.ClockSkewClamped, testutil.ToFloat64(s.Metrics.clockSkew), skewed)
+			}
+		})
+	}
+}
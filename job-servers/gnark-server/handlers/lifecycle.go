@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var errShuttingDown = errors.New("server is shutting down")
+
+var errProvingDisabled = errors.New("proving is disabled because the proving key failed to load")
+
+// signal is a channel that is closed at most once and can be used from a
+// zero value.
+type signal struct {
+	init  sync.Once
+	close sync.Once
+	ch    chan struct{}
+}
+
+func (c *signal) done() chan struct{} {
+	c.init.Do(func() { c.ch = make(chan struct{}) })
+	return c.ch
+}
+
+func (c *signal) fire() {
+	ch := c.done()
+	c.close.Do(func() { close(ch) })
+}
+
+// goTracked runs f in the background as part of the in-flight work that
+// shutdown waits for.
+func (s *State) goTracked(f func()) {
+	s.inFlight.Add(1)
+	go func() {
+		defer s.inFlight.Done()
+		f()
+	}()
+}
+
+// StopAccepting makes new proof submissions fail with 503 and tells the
+// workers to return after their current job. Read endpoints keep serving.
+func (s *State) StopAccepting() {
+	s.stopping.Store(true)
+	s.stop.fire()
+}
+
+// CloseStreams ends long-lived /proof-events streams and /proof-ws
+// connections so that the HTTP server can shut down. Register it with
+// http.Server.RegisterOnShutdown.
+func (s *State) CloseStreams() {
+	s.streams.fire()
+}
+
+// stopped returns a channel that is closed once StopAccepting is called.
+func (s *State) stopped() chan struct{} {
+	return s.stop.done()
+}
+
+func (s *State) isStopping() bool {
+	return s.stopping.Load()
+}
+
+// WaitForJobs blocks until every worker has finished its current job and
+// returned and every callback delivery started outside of the delivery pool
+// is done, or ctx is done. Jobs still in the queue are left there for the
+// next instance to pick up.
+func (s *State) WaitForJobs(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		s.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RequeueRunning pushes the jobs this instance is still proving back to the
+// front of the queue so that a restarted instance or another replica picks
+// them up. It is called when the drain timeout expires.
+func (s *State) RequeueRunning(ctx context.Context) {
+	now := time.Now()
+	s.running.Range(func(key, _ interface{}) bool {
+		jobId := key.(string)
+		metaKey := getRedisMetaKey(jobId)
+		priority, err := s.RedisClient.HGet(ctx, metaKey, metaPriority).Result()
+		if err != nil && err != redis.Nil {
+			log.Printf("Failed to read the priority of job %s: %v\n", jobId, err)
+		}
+		_, err = s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.LRem(ctx, redisProcessingKey, 1, jobId)
+			requeueJobFront(ctx, pipe, jobId, priority)
+			fields := stateFields(jobStateQueued, now)
+			fields[metaStage] = stageQueued
+			fields[metaStageUpdatedAt] = fields[metaStateAt(jobStateQueued)]
+			pipe.HSet(ctx, metaKey, fields)
+			s.queueTransition(ctx, pipe, jobId, fields, nil)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Failed to requeue job %s: %v\n", jobId, err)
+		} else {
+			log.Println("Requeued unfinished job", jobId)
+		}
+		return true
+	})
+}
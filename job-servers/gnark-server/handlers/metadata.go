@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisMetaKeyPrefix = "gnark_proof_meta:"
+
+	metaCallbackUrl        = "callbackUrl"
+	metaCallbackValidation = "callbackValidation"
+	// metaCallbackInputDigest is the input digest of a job with a callback,
+	// which identifies its notifications for deduplication.
+	metaCallbackInputDigest = "callbackInputDigest"
+	// metaCallbackForce makes the callback of a replay bypass deduplication.
+	metaCallbackForce       = "callbackForce"
+	metaCallbackStatus      = "callbackStatus"
+	metaCallbackDuplicateOf = "callbackDuplicateOf"
+	metaClient              = "client"
+	metaCircuitRelease      = "circuitRelease"
+)
+
+func getRedisMetaKey(jobId string) string {
+	return fmt.Sprintf("%s%s", redisMetaKeyPrefix, jobId)
+}
+
+// queueJobMetadata adds fields to the job's metadata hash, which lives next to
+// the proof response and shares its expiration.
+func queueJobMetadata(ctx context.Context, pipe redis.Pipeliner, jobId string, fields map[string]interface{}) {
+	if len(fields) == 0 {
+		return
+	}
+	key := getRedisMetaKey(jobId)
+	pipe.HSet(ctx, key, fields)
+	pipe.Expire(ctx, key, expiration)
+}
+
+func (s *State) getJobMetadata(ctx context.Context, jobId string) (map[string]string, error) {
+	return s.RedisClient.HGetAll(ctx, getRedisMetaKey(jobId)).Result()
+}
@@ -0,0 +1,372 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gnark-server/delivery"
+	"gnark-server/metrics"
+	"gnark-server/metricspush"
+	"gnark-server/prover"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// stageRedisWrite is the metrics stage of storing a job's final response.
+	stageRedisWrite = "redis-write"
+
+	queueDepthScrapeTimeout = 2 * time.Second
+	maxRedisOperationLabels = 32
+)
+
+// Metrics holds the Prometheus collectors of the job lifecycle. A nil
+// *Metrics records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	proofsStarted   prometheus.Counter
+	proofsSucceeded prometheus.Counter
+	proofsFailed    *prometheus.CounterVec
+	proveDuration   *prometheus.HistogramVec
+	stageDuration   *prometheus.HistogramVec
+	endToEnd        prometheus.Histogram
+	clockSkew       prometheus.Counter
+	redisErrors     *prometheus.CounterVec
+	activeWorkers   prometheus.Gauge
+	proofCache      *prometheus.CounterVec
+	coalescedReads  prometheus.Counter
+	pushFailures    prometheus.Counter
+	shardClaims     *prometheus.CounterVec
+	shardInfo       *prometheus.GaugeVec
+	deadLetters     prometheus.Counter
+
+	stages          *metrics.ClosedSet
+	failureCodes    *metrics.ClosedSet
+	redisOperations *metrics.CappedSet
+}
+
+// NewMetrics creates the collectors and instruments rdb, whose queue depth
+// is read on every scrape and whose failed commands are counted.
+func NewMetrics(rdb redis.UniversalClient) *Metrics {
+	buckets := prometheus.ExponentialBuckets(0.001, 2.5, 16)
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		proofsStarted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gnark_proofs_started_total",
+			Help: "Proof jobs accepted into the queue.",
+		}),
+		proofsSucceeded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gnark_proofs_succeeded_total",
+			Help: "Proof jobs that produced a verified proof.",
+		}),
+		proofsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gnark_proofs_failed_total",
+			Help: "Proof jobs that failed, by error code.",
+		}, []string{"code"}),
+		proveDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gnark_proof_duration_seconds",
+			Help:    "Time from a worker picking up a job to its result, by outcome.",
+			Buckets: buckets,
+		}, []string{"result"}),
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gnark_proof_stage_duration_seconds",
+			Help:    "Time spent in each stage of proving a job.",
+			Buckets: buckets,
+		}, []string{"stage"}),
+		endToEnd: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gnark_proof_end_to_end_latency_seconds",
+			Help:    "Time from upstreamCreatedAt to the job's result, for jobs submitted with one.",
+			Buckets: buckets,
+		}),
+		clockSkew: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gnark_proof_end_to_end_clock_skew_total",
+			Help: "End-to-end latencies that were negative because of clock skew and were clamped to zero.",
+		}),
+		redisErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gnark_redis_errors_total",
+			Help: "Failed Redis commands, by command.",
+		}, []string{"operation"}),
+		activeWorkers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gnark_active_workers",
+			Help: "Workers on this instance that are proving a job.",
+		}),
+		proofCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gnark_proof_cache_lookups_total",
+			Help: "Proof cache lookups by start-proof, by result.",
+		}, []string{"result"}),
+		coalescedReads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gnark_proof_result_reads_coalesced_total",
+			Help: "get-proof requests served by a result read started by a concurrent request for the same job.",
+		}),
+		pushFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gnark_metrics_push_failures_total",
+			Help: "Metrics snapshots that could not be pushed when METRICS_PUSH is set.",
+		}),
+		shardClaims: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gnark_shard_claims_total",
+			Help: "Jobs claimed by sharded workers, by kind: own shard, fallback to another shard, or untagged.",
+		}, []string{"kind"}),
+		shardInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "gnark_shard_info",
+			Help: "Shard assignment of this instance, set to 1 when SHARD_COUNT is set.",
+		}, []string{"count", "index"}),
+		deadLetters: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gnark_proof_dead_letters_total",
+			Help: "Jobs moved to the dead-letter queue after crashing the prover.",
+		}),
+		stages: metrics.NewClosedSet(prover.StageWitnessGeneration, prover.StageProving, prover.StageVerifying, stageRedisWrite),
+		failureCodes: metrics.NewClosedSet(codeProverError, codeJobFailed, codeMalformedJSON,
+			codeMalformedProof, codeInvalidPublicInputCount, codePublicInputOutOfRange, codeInputHashMismatch, codeTimeout, codeDeadlineExceeded),
+		redisOperations: metrics.NewCappedSet(maxRedisOperationLabels),
+	}
+	queueDepth := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gnark_proof_queue_depth",
+		Help: "Jobs waiting in or being processed from the shared queue.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), queueDepthScrapeTimeout)
+		defer cancel()
+		depth, err := queueDepth(ctx, rdb)
+		if err != nil {
+			return math.NaN()
+		}
+		return float64(depth)
+	})
+	abandonedProofs := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gnark_proofs_abandoned",
+		Help: "Witness constructions and proofs of timed out jobs that still run in the background.",
+	}, func() float64 {
+		return float64(prover.Abandoned())
+	})
+	m.registry.MustRegister(m.proofsStarted, m.proofsSucceeded, m.proofsFailed,
+		m.proveDuration, m.stageDuration, m.endToEnd, m.clockSkew, m.redisErrors, m.activeWorkers, m.proofCache, m.coalescedReads, m.pushFailures,
+		m.shardClaims, m.shardInfo, m.deadLetters, queueDepth, abandonedProofs)
+	rdb.AddHook(redisErrorHook{m})
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// StartPush pushes a snapshot of the metrics, labelled with instance, to sink
+// every interval. Failures are logged and counted; they never affect serving.
+func (m *Metrics) StartPush(sink metricspush.Sink, instance string, interval time.Duration) *metricspush.Pusher {
+	return metricspush.Start(m.registry, sink, instance, interval, func(err error) {
+		log.Println("Metrics push error:", err)
+		m.pushFailures.Inc()
+	})
+}
+
+func (m *Metrics) started(n int) {
+	if m == nil {
+		return
+	}
+	m.proofsStarted.Add(float64(n))
+}
+
+func (m *Metrics) finished(response ProofResponse) {
+	if m == nil {
+		return
+	}
+	if response.Success {
+		m.proofsSucceeded.Inc()
+		return
+	}
+	code := codeJobFailed
+	if response.ErrorCode != nil {
+		code = *response.ErrorCode
+	}
+	m.proofsFailed.WithLabelValues(m.failureCodes.Label(code)).Inc()
+}
+
+// SetShard records the shard assignment of this instance.
+func (m *Metrics) SetShard(shard *Shard) {
+	if m == nil || shard == nil {
+		return
+	}
+	m.shardInfo.WithLabelValues(strconv.Itoa(shard.Count), strconv.Itoa(shard.Index)).Set(1)
+}
+
+func (m *Metrics) deadLettered() {
+	if m == nil {
+		return
+	}
+	m.deadLetters.Inc()
+}
+
+func (m *Metrics) shardClaim(kind string) {
+	if m == nil {
+		return
+	}
+	m.shardClaims.WithLabelValues(kind).Inc()
+}
+
+func (m *Metrics) workerBusy(delta float64) {
+	if m == nil {
+		return
+	}
+	m.activeWorkers.Add(delta)
+}
+
+func (m *Metrics) proofCacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.proofCache.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) coalescedRead() {
+	if m == nil {
+		return
+	}
+	m.coalescedReads.Inc()
+}
+
+func (m *Metrics) observeProve(success bool, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if !success {
+		result = "failed"
+	}
+	m.proveDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+func (m *Metrics) observeStage(stage string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.stageDuration.WithLabelValues(m.stages.Label(stage)).Observe(duration.Seconds())
+}
+
+func (m *Metrics) observeEndToEnd(latency time.Duration, skewed bool) {
+	if m == nil {
+		return
+	}
+	m.endToEnd.Observe(latency.Seconds())
+	if skewed {
+		m.clockSkew.Inc()
+	}
+}
+
+// stageTimer times the stages reported by prover.Prove. Each stage ends when
+// the next one starts or when stop is called.
+type stageTimer struct {
+	metrics   *Metrics
+	stage     string
+	startedAt time.Time
+}
+
+func (t *stageTimer) start(stage string) {
+	t.stop()
+	t.stage = stage
+	t.startedAt = time.Now()
+}
+
+func (t *stageTimer) stop() {
+	if t.stage == "" {
+		return
+	}
+	t.metrics.observeStage(t.stage, time.Since(t.startedAt))
+	t.stage = ""
+}
+
+// redisErrorHook counts failed Redis commands. redis.Nil is a missing key or
+// an empty blocking pop, not an error.
+type redisErrorHook struct {
+	metrics *Metrics
+}
+
+func (h redisErrorHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h redisErrorHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	h.record(cmd)
+	return nil
+}
+
+func (h redisErrorHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h redisErrorHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		h.record(cmd)
+	}
+	return nil
+}
+
+func (h redisErrorHook) record(cmd redis.Cmder) {
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		h.metrics.redisErrors.WithLabelValues(h.metrics.redisOperations.Label(cmd.Name())).Inc()
+	}
+}
+
+// registerDeliveries exports the per-sink counters of pool.
+func (m *Metrics) registerDeliveries(pool *delivery.Pool) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(deliveryCollector{pool})
+}
+
+var (
+	deliveryQueuedDesc = prometheus.NewDesc("gnark_delivery_queued",
+		"Deliveries waiting in the in-memory queue of this instance, by sink.", []string{"sink"}, nil)
+	deliveryInFlightDesc = prometheus.NewDesc("gnark_delivery_in_flight",
+		"Deliveries being sent by this instance, by sink.", []string{"sink"}, nil)
+	deliveryDeliveredDesc = prometheus.NewDesc("gnark_delivery_delivered_total",
+		"Deliveries completed by this instance, by sink.", []string{"sink"}, nil)
+	deliveryFailedDesc = prometheus.NewDesc("gnark_delivery_failed_total",
+		"Deliveries that failed or could not be queued, by sink.", []string{"sink"}, nil)
+	deliveryOverflowedDesc = prometheus.NewDesc("gnark_delivery_overflowed_total",
+		"Deliveries written to the Redis overflow list because the queue was full or the instance was shutting down, by sink.", []string{"sink"}, nil)
+	deliveryBacklogDesc = prometheus.NewDesc("gnark_delivery_overflow_backlog",
+		"Deliveries waiting in the shared Redis overflow list, by sink.", []string{"sink"}, nil)
+)
+
+// deliveryCollector reads the delivery counters on every scrape.
+type deliveryCollector struct {
+	pool *delivery.Pool
+}
+
+func (c deliveryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- deliveryQueuedDesc
+	ch <- deliveryInFlightDesc
+	ch <- deliveryDeliveredDesc
+	ch <- deliveryFailedDesc
+	ch <- deliveryOverflowedDesc
+	ch <- deliveryBacklogDesc
+}
+
+func (c deliveryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueDepthScrapeTimeout)
+	defer cancel()
+	for _, sink := range c.pool.Sinks() {
+		stats, _ := c.pool.Stats(sink)
+		ch <- prometheus.MustNewConstMetric(deliveryQueuedDesc, prometheus.GaugeValue, float64(stats.Queued), sink)
+		ch <- prometheus.MustNewConstMetric(deliveryInFlightDesc, prometheus.GaugeValue, float64(stats.InFlight), sink)
+		ch <- prometheus.MustNewConstMetric(deliveryDeliveredDesc, prometheus.CounterValue, float64(stats.Delivered), sink)
+		ch <- prometheus.MustNewConstMetric(deliveryFailedDesc, prometheus.CounterValue, float64(stats.Failed), sink)
+		ch <- prometheus.MustNewConstMetric(deliveryOverflowedDesc, prometheus.CounterValue, float64(stats.Overflowed), sink)
+		backlog := math.NaN()
+		if n, err := c.pool.Backlog(ctx, sink); err == nil {
+			backlog = float64(n)
+		}
+		ch <- prometheus.MustNewConstMetric(deliveryBacklogDesc, prometheus.GaugeValue, backlog, sink)
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"gnark-server/mirror"
+	"gnark-server/redisconfig"
+)
+
+const backfillScanCount = 500
+
+func (s *State) mirrorRecord(jobId string, response ProofResponse, completedAt time.Time) mirror.Record {
+	if data, ok := s.soleCircuit(); ok && response.CircuitRelease == "" {
+		response.CircuitRelease = data.ReleaseId
+	}
+	record := mirror.Record{
+		JobId:          jobId,
+		Success:        response.Success,
+		ErrorMessage:   response.ErrorMessage,
+		CircuitRelease: response.CircuitRelease,
+		CompletedAt:    completedAt,
+	}
+	if response.Proof != nil {
+		record.PublicInputs = response.Proof.PublicInputs
+	}
+	return record
+}
+
+// mirrorJob hands the terminal record of a job to the mirror, if enabled.
+func (s *State) mirrorJob(jobId string, response ProofResponse) {
+	if s.Mirror == nil {
+		return
+	}
+	record := s.mirrorRecord(jobId, response, time.Now().UTC())
+	if s.Deliveries == nil {
+		s.Mirror.Enqueue(record)
+		return
+	}
+	s.enqueueDelivery(deliverySinkMirror, jobId, record)
+}
+
+// BackfillMirror mirrors every terminal job currently stored in Redis. It is
+// used to populate a freshly created mirror database.
+func (s *State) BackfillMirror(ctx context.Context) (int, error) {
+	mirrored := 0
+	rdb, err := redisconfig.ScanClient(ctx, s.RedisClient)
+	if err != nil {
+		return 0, err
+	}
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, redisKeyPrefix+"*", backfillScanCount).Result()
+		if err != nil {
+			return mirrored, err
+		}
+		records := make([]mirror.Record, 0, len(keys))
+		for _, key := range keys {
+			jobId := strings.TrimPrefix(key, redisKeyPrefix)
+			response, err := s.getProofResponse(ctx, jobId)
+			if err != nil {
+				log.Printf("Skipping job %s: %v\n", jobId, err)
+				continue
+			}
+			if response.Proof == nil && response.ErrorMessage == nil {
+				continue
+			}
+			completedAt := time.Now().UTC()
+			if meta, err := s.getJobMetadata(ctx, jobId); err == nil {
+				if t, err := time.Parse(time.RFC3339Nano, meta[metaStageUpdatedAt]); err == nil {
+					completedAt = t
+				}
+			}
+			records = append(records, s.mirrorRecord(jobId, response, completedAt))
+		}
+		if len(records) > 0 {
+			if err := s.Mirror.Upsert(ctx, records); err != nil {
+				return mirrored, err
+			}
+			mirrored += len(records)
+		}
+		cursor = next
+		if cursor == 0 {
+			return mirrored, nil
+		}
+	}
+}
@@ -0,0 +1,663 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"gnark-server/apichanges"
+	"gnark-server/openapi"
+)
+
+// Security schemes of the OpenAPI document.
+const (
+	securityAPIKey     = "apiKey"
+	securityAdminToken = "adminToken"
+)
+
+// apiOperation describes one operation of the HTTP API for /openapi.json.
+// Pattern ties it to the route table: every served route must be described.
+type apiOperation struct {
+	pattern string
+	// path is the OpenAPI path, when it differs from pattern.
+	path     string
+	method   string
+	summary  string
+	security string
+	// admin operations are only served on the admin listener.
+	admin          bool
+	params         []openapi.Parameter
+	request        interface{}
+	requestExample string
+	// requestOptional is set when the request body may be left out.
+	requestOptional bool
+	responses       []apiResponse
+}
+
+// apiResponse is one response of an apiOperation. body is a value of the
+// payload type, encoded as JSON unless contentType says otherwise, and
+// alternatives are the other payload types the response may have.
+type apiResponse struct {
+	status       int
+	description  string
+	contentType  string
+	body         interface{}
+	alternatives []interface{}
+	example      string
+	headers      map[string]string
+}
+
+func jobIdParam(description string) openapi.Parameter {
+	return openapi.Parameter{Name: "jobId", In: "query", Required: true, Description: description, Schema: &openapi.Schema{Type: "string", Format: "uuid"}}
+}
+
+func queryParam(name string, typ string, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: typ}}
+}
+
+func headerParam(name string, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "header", Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+func jsonResponse(status int, description string, body interface{}, example string) apiResponse {
+	return apiResponse{status: status, description: description, body: body, example: example}
+}
+
+// errorResponse describes an ErrorResponse returned with status, listing the
+// codes it may carry.
+func errorResponse(status int, codes ...string) apiResponse {
+	description := http.StatusText(status)
+	if len(codes) > 0 {
+		description += ", with code"
+		for i, code := range codes {
+			if i > 0 {
+				description += ","
+			}
+			description += " " + code
+		}
+	}
+	return apiResponse{status: status, description: description, body: ErrorResponse{}}
+}
+
+var (
+	errorsUnauthorized    = errorResponse(http.StatusUnauthorized, "unauthorized")
+	errorsInternal        = errorResponse(http.StatusInternalServerError, codeInternalError)
+	errorsMethod          = errorResponse(http.StatusMethodNotAllowed, codeMethodNotAllowed)
+	errorsInvalidJobId    = errorResponse(http.StatusBadRequest, codeInvalidJobId)
+	errorsSubmitUnavail   = errorResponse(http.StatusServiceUnavailable, codeShuttingDown, codeProvingDisabled)
+	errorsSubmitRateLimit = apiResponse{
+		status:      http.StatusTooManyRequests,
+		description: "Too Many Requests, with code rate_limited or queue_full",
+		body:        ErrorResponse{},
+		example:     `{"code":"queue_full","message":"proof queue is full (1000 of 1000 jobs), retry later","details":{"queueDepth":1000,"maxQueueLength":1000}}`,
+		headers:     map[string]string{"Retry-After": "Seconds to wait before retrying, set with rate_limited."},
+	}
+)
+
+// reloadCircuitResponses are shared by PUT and POST /reload-circuit.
+var reloadCircuitResponses = []apiResponse{
+	jsonResponse(http.StatusOK, "The circuits now in use. Jobs already proving finish with the previous keys.", ReloadCircuitResponse{},
+		`{"dataDir":"/data/releases/v2","circuits":[{"name":"default","releaseId":"3f9a1c07d2e4","previousReleaseId":"b81e5d0a6c32","constraints":3145728,"provingKeySize":1073741824,"verifyingKeySize":1504,"verifyingKeyHash":"3f9a1c07d2e4b5a6978812f0c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f6"}]}`),
+	errorResponse(http.StatusBadRequest, codeMalformedJSON, codeInvalidRequest, codeUnknownCircuit),
+	errorsUnauthorized,
+	errorsMethod,
+	errorResponse(http.StatusConflict, codeReloadInProgress),
+	errorResponse(http.StatusInternalServerError, codeCircuitReloadFailed),
+}
+
+const (
+	exampleJobId        = "306a20df-e359-4b3c-b6c6-8a1049b90fde"
+	exampleJobRecord    = `{"state":"done","timestamps":{"queued":"2024-07-01T12:00:00.113Z","proving":"2024-07-01T12:00:00.402Z","done":"2024-07-01T12:01:31.007Z"},"attempts":1,"priority":"normal"}`
+	exampleProveResult  = `{"publicInputs":["4079990936128339718950297493457962829148009512212898213578624066939568713463","1234"],"proof":"2a4f9c...","verifierDigest":"4079990936128339718950297493457962829148009512212898213578624066939568713463","verifierDigestHex":"0x0905397d8826ccf6e1a5b3d15d0a3a7a1d33c1bb48ea3e0bf0d0a9b4f4b2b4f7","inputHash":"1234","inputHashHex":"0x00000000000000000000000000000000000000000000000000000000000004d2"}`
+	exampleProofRequest = `{"proof":"{\"wires_cap\":[]}","verifierData":"{\"constants_sigmas_cap\":[]}","callbackUrl":"https://example.com/proofs","ttlSeconds":3600,"priority":"high","circuit":"withdrawal","proveTimeoutSeconds":600}`
+)
+
+// apiOperations describes every route of the HTTP API.
+var apiOperations = []apiOperation{
+	{
+		pattern: "/health", method: http.MethodGet, summary: "Liveness check. Answers OK in plain text while the loaded circuit data is valid.",
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The server is up.", contentType: "text/plain"},
+			jsonResponse(http.StatusServiceUnavailable, "The loaded circuit data is invalid.", HealthResponse{},
+				`{"status":"unavailable","error":"circuit data validation failed: proving key is not loaded"}`),
+		},
+	},
+	{
+		pattern: "/health/ready", method: http.MethodGet, summary: "Readiness check of Redis and each part of the circuit data.",
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "Ready, or degraded in verify-only mode.", ReadinessResponse{},
+				`{"status":"ok","checks":{"redis":{"status":"ok","latencyMs":1},"provingKey":{"status":"ok"},"verifyingKey":{"status":"ok"},"constraintSystem":{"status":"ok"}}}`),
+			jsonResponse(http.StatusServiceUnavailable, "A check failed.", ReadinessResponse{}, ""),
+		},
+	},
+	{
+		pattern: "/version", method: http.MethodGet, summary: "The loaded circuit releases and the build.",
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The version of the server.", VersionResponse{},
+				`{"circuitRelease":"2024-06-30-a1b2c3d","backend":"plonk","verifyingKeyHash":"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","constraints":4194304,"gnarkVersion":"v0.9.1","buildCommit":"03dfd50","circuits":[{"circuit":"withdrawal","circuitRelease":"2024-06-30-a1b2c3d","verifyingKeyHash":"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","constraints":4194304}]}`),
+			errorsMethod,
+		},
+	},
+	{
+		pattern: "/circuit-info", method: http.MethodGet, summary: "The constraint and public input counts and key sizes of a circuit.",
+		params: []openapi.Parameter{queryParam("circuit", "string", "The circuit, required when several are loaded.")},
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The size of the circuit.", CircuitInfoResponse{},
+				`{"circuit":"default","circuit_release":"3f9c2a41d07e","nb_constraints":3215427,"nb_public_inputs":2,"proving_key_size_bytes":1207959552,"verifying_key_size_bytes":1252,"circuit_digest":"10639849666975086414110868463771120369189468607622759510754735453420311446140","input_digest_bits":253,"input_digest_headroom":"7414231717174750794300032619171286606889616317210963838766006185586667290626"}`),
+			errorResponse(http.StatusBadRequest, codeUnknownCircuit),
+			errorsMethod,
+		},
+	},
+	{
+		pattern: "/api-changes", method: http.MethodGet, summary: "The changes made to the API after a version.",
+		params: []openapi.Parameter{queryParam("since", "string", "List the changes after this MAJOR.MINOR version. All changes are listed when it is left out.")},
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The changes, oldest first.", APIChangesResponse{},
+				`{"current":"1.27","since":"1.26","changes":[{"version":"1.27","endpoint":"/events","type":"added","breaking":false,"note":"Replays the state transitions of all jobs from a cursor, on the admin listener."}]}`),
+			errorResponse(http.StatusBadRequest, codeInvalidRequest),
+			errorsMethod,
+		},
+	},
+	{
+		pattern: "/openapi.json", method: http.MethodGet, summary: "This OpenAPI description of the HTTP API.",
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The OpenAPI 3 document.", contentType: "application/json"},
+			errorsMethod,
+		},
+	},
+	{
+		pattern: "/metrics", method: http.MethodGet, summary: "Prometheus metrics of the proving lifecycle.",
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "Metrics in the Prometheus text format.", contentType: "text/plain"},
+		},
+	},
+	{
+		pattern: "/public-status", method: http.MethodGet, summary: "Coarse queue and worker status, without authentication. Only the fields enabled by PUBLIC_STATUS_FIELDS are set.",
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The status.", PublicStatusResponse{},
+				`{"health":"ok","queueDepth":"low","avgProofMinutes":2,"circuitRelease":"2024-06-30-a1b2c3d"}`),
+			errorResponse(http.StatusTooManyRequests, codeRateLimited),
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/start-proof", method: http.MethodPost, summary: "Queues a proof of a plonky2 proof and returns its jobId.", security: securityAPIKey,
+		params: []openapi.Parameter{
+			headerParam(idempotencyKeyHeader, "Returns the job of an earlier submission with the same key instead of queueing a new one. The idempotencyKey field takes precedence."),
+			headerParam(priorityHeader, "Queue of the job, high, normal or low. The priority field takes precedence."),
+		},
+		request: ProofRequest{}, requestExample: exampleProofRequest,
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The job was queued, or served from the proof cache with its proof.", StartProofResponse{},
+				`{"jobId":"`+exampleJobId+`","job":{"state":"queued","timestamps":{"queued":"2024-07-01T12:00:00.113Z"},"attempts":1,"priority":"high","proveTimeoutSeconds":600}}`),
+			{status: http.StatusAccepted, description: "An identical job is still in flight and its jobId is returned.", body: StartProofResponse{},
+				headers: map[string]string{deduplicatedHeader: "Always true."}},
+			errorResponse(http.StatusBadRequest, codeMalformedJSON, codeMalformedProof, codeInvalidRequest, codeInvalidPriority, codeUnknownCircuit, codeInvalidProveTimeout, codeInvalidNotAfter),
+			errorsUnauthorized,
+			errorResponse(http.StatusForbidden, codeCircuitNotAllowed),
+			errorResponse(http.StatusRequestEntityTooLarge, codeProofTooLarge),
+			errorResponse(http.StatusUnprocessableEntity, codeInvalidPublicInputCount, codePublicInputOutOfRange),
+			errorsSubmitRateLimit,
+			errorsInternal,
+			errorsSubmitUnavail,
+		},
+	},
+	{
+		pattern: "/prove", method: http.MethodPost, summary: "Queues a proof like start-proof and waits for it to finish, for low-volume callers.", security: securityAPIKey,
+		params: []openapi.Parameter{
+			queryParam("waitSeconds", "integer", "How long to wait for the job, capped by MAX_PROVE_WAIT_SECONDS (default 300), which is also the default."),
+			headerParam(idempotencyKeyHeader, "Returns the job of an earlier submission with the same key instead of queueing a new one. The idempotencyKey field takes precedence."),
+			headerParam(priorityHeader, "Queue of the job, high, normal or low. The priority field takes precedence."),
+		},
+		request: ProofRequest{}, requestExample: exampleProofRequest,
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The job finished. The body is the get-proof response, with the proof or the error of the job.", ProofResponse{},
+				`{"success":true,"proof":`+exampleProveResult+`,"errorMessage":null,"circuit":"withdrawal","circuitRelease":"2024-06-30-a1b2c3d","backend":"plonk","job":`+exampleJobRecord+`}`),
+			jsonResponse(http.StatusAccepted, "The job is still pending after the wait. Poll get-proof with its jobId.", StartProofResponse{},
+				`{"jobId":"`+exampleJobId+`","job":{"state":"proving","timestamps":{"queued":"2024-07-01T12:00:00.113Z","proving":"2024-07-01T12:00:00.210Z"},"attempts":1,"priority":"normal"}}`),
+			errorResponse(http.StatusBadRequest, codeMalformedJSON, codeMalformedProof, codeInvalidRequest, codeInvalidPriority, codeUnknownCircuit, codeInvalidProveTimeout, codeInvalidNotAfter),
+			errorsUnauthorized,
+			errorResponse(http.StatusForbidden, codeCircuitNotAllowed),
+			errorsMethod,
+			errorResponse(http.StatusRequestEntityTooLarge, codeProofTooLarge),
+			errorResponse(http.StatusUnprocessableEntity, codeInvalidPublicInputCount, codePublicInputOutOfRange),
+			errorsSubmitRateLimit,
+			errorsInternal,
+			errorsSubmitUnavail,
+		},
+	},
+	{
+		pattern: "/start-proofs", method: http.MethodPost, summary: "Queues a batch of proofs in one request. Entries are validated independently.", security: securityAPIKey,
+		params:  []openapi.Parameter{headerParam(priorityHeader, "Queue of the entries that do not set a priority.")},
+		request: []ProofRequest{}, requestExample: "[" + exampleProofRequest + "]",
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "One result per entry, in order.", []BatchProofResult{},
+				`[{"jobId":"`+exampleJobId+`","errorMessage":null},{"jobId":null,"errorMessage":"invalid proof","errorCode":"malformed_proof"}]`),
+			errorResponse(http.StatusBadRequest, codeMalformedJSON, codeInvalidBatch),
+			errorsUnauthorized,
+			errorsSubmitRateLimit,
+			errorsInternal,
+			errorsSubmitUnavail,
+		},
+	},
+	{
+		pattern: "/get-proof", method: http.MethodGet, summary: "The result of a job: success with a null proof while it is pending, the proof once done, or the error once failed.", security: securityAPIKey,
+		params: []openapi.Parameter{
+			jobIdParam("The job to read."),
+			queryParam("format", "string", "json (default), or calldata for the proof encoded for the Solidity verifier as a CalldataProofResponse."),
+		},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The result of the job, a ProofResponse, or a CalldataProofResponse with format=calldata.",
+				body: ProofResponse{}, alternatives: []interface{}{CalldataProofResponse{}},
+				example: `{"success":true,"proof":` + exampleProveResult + `,"errorMessage":null,"circuit":"withdrawal","circuitRelease":"2024-06-30-a1b2c3d","backend":"plonk","job":` + exampleJobRecord + `}`},
+			errorResponse(http.StatusBadRequest, codeInvalidJobId, codeInvalidRequest),
+			errorsUnauthorized,
+			errorResponse(http.StatusNotFound, codeJobNotFound),
+			{status: http.StatusGone, description: "Gone, with code job_expired. details.job holds the lifecycle of the job when it is known.", body: ErrorResponse{},
+				example: `{"code":"job_expired","message":"job has expired","details":{"job":{"state":"expired","timestamps":{"queued":"2024-07-01T12:00:00.113Z","done":"2024-07-01T12:01:31.007Z","expired":"2024-07-02T12:01:31.007Z"},"attempts":1}}}`},
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/retry-proof", method: http.MethodPost, summary: "Queues a failed job again with its stored input.", security: securityAPIKey,
+		params: []openapi.Parameter{jobIdParam("The failed job to retry.")},
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The job was queued again.", RetryProofResponse{}, `{"jobId":"`+exampleJobId+`","attempts":2}`),
+			errorsInvalidJobId,
+			errorsUnauthorized,
+			errorResponse(http.StatusForbidden, codeCircuitNotAllowed),
+			errorResponse(http.StatusNotFound, codeJobNotFound),
+			errorsMethod,
+			errorResponse(http.StatusConflict, codeJobNotFailed, "input_not_stored"),
+			errorResponse(http.StatusGone, "job_expired"),
+			errorResponse(http.StatusTooManyRequests, codeRateLimited),
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/proof-events", method: http.MethodGet, summary: "Streams the stage transitions of a job as server-sent events, one ProofEvent per event, until it is done or failed. Ping events are sent while it is pending.", security: securityAPIKey,
+		params: []openapi.Parameter{
+			jobIdParam("The job to follow."),
+			queryParam("id", "string", "Alias of jobId."),
+		},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "An event stream whose data are ProofEvent objects.", contentType: "text/event-stream", body: ProofEvent{},
+				example: `{"jobId":"` + exampleJobId + `","stage":"proving","time":"2024-07-01T12:00:03.551Z"}`},
+			errorsInvalidJobId,
+			errorsUnauthorized,
+			errorResponse(http.StatusNotFound, codeJobNotFound),
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/proof-ws", method: http.MethodGet, summary: "Streams the stage transitions of a job over a WebSocket, one ProofEvent JSON message each.", security: securityAPIKey,
+		params: []openapi.Parameter{
+			jobIdParam("The job to follow."),
+			queryParam("id", "string", "Alias of jobId."),
+		},
+		responses: []apiResponse{
+			{status: http.StatusSwitchingProtocols, description: "The WebSocket was opened. Messages are ProofEvent objects.", body: ProofEvent{}},
+			errorsInvalidJobId,
+			errorsUnauthorized,
+			errorResponse(http.StatusNotFound, codeJobNotFound),
+			errorsInternal,
+			errorResponse(http.StatusServiceUnavailable, codeTooManyConnections),
+		},
+	},
+	{
+		pattern: "/jobs/", path: "/jobs/{jobId}/replay", method: http.MethodPost, summary: "Proves a finished job again from its stored input. With compare=true the result of the replay job carries a replayReport.", security: securityAPIKey,
+		params: []openapi.Parameter{
+			{Name: "jobId", In: "path", Required: true, Schema: &openapi.Schema{Type: "string", Format: "uuid"}},
+			queryParam("compare", "boolean", "Compare the replay with the original job."),
+			queryParam("notify", "boolean", "Notify the original callbackUrl when the replay finishes, unless an identical notification was delivered within the dedupe window."),
+			queryParam("force", "boolean", "Notify the original callbackUrl even if an identical notification was delivered within the dedupe window. Implies notify."),
+		},
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The replay job was queued.", ReplayJobResponse{}, `{"jobId":"`+exampleJobId+`"}`),
+			errorResponse(http.StatusBadRequest, codeInvalidJobId, "callback_target_blocked", "callback_probe_failed"),
+			errorsUnauthorized,
+			errorResponse(http.StatusForbidden, codeCircuitNotAllowed),
+			errorResponse(http.StatusNotFound, codeJobNotFound, codeNotFound),
+			errorsMethod,
+			errorResponse(http.StatusConflict, "input_not_stored", "job_not_finished", "job_not_provable"),
+			errorResponse(http.StatusTooManyRequests, codeQueueFull),
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/stats", method: http.MethodGet, summary: "Proving and end-to-end latency percentiles of this instance.", security: securityAPIKey,
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The stats.", StatsResponse{},
+				`{"proveDuration":{"count":120,"p50Ms":84000,"p90Ms":97000,"p99Ms":110000},"endToEndLatency":null,"clockSkewClamped":0,"queueDepth":4,"maxQueueLength":null,"activeWorkers":1}`),
+			errorsUnauthorized,
+		},
+	},
+	{
+		pattern: "/estimate-prove-time", method: http.MethodGet, summary: "Estimates how long a new job takes from the durations of the last successful proofs.", security: securityAPIKey,
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "Duration percentiles.", EstimateProveTimeResponse{}, `{"p50_ms":84000,"p95_ms":101000,"p99_ms":110000,"sample_size":1000}`),
+			{status: http.StatusNoContent, description: "Too few proofs were recorded to estimate."},
+			errorsUnauthorized,
+			errorsMethod,
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/export-verifier", method: http.MethodGet, summary: "The Solidity verifier of the verifying key of a circuit.", security: securityAdminToken, admin: true,
+		params: []openapi.Parameter{queryParam("circuit", "string", "The circuit, required when several are loaded.")},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The Solidity source of the verifier.", contentType: "text/plain"},
+			errorResponse(http.StatusBadRequest, codeUnknownCircuit),
+			errorsUnauthorized,
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/test-vectors", method: http.MethodGet, summary: "Calls the Solidity verifier must reject, derived from the proof of a succeeded job, along with the valid call.", security: securityAdminToken, admin: true,
+		params: []openapi.Parameter{jobIdParam("The succeeded job whose proof is mutated.")},
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The valid call first, then one call per mutation. Every mutation was checked to fail verification with the loaded verifying key.", TestVectorsResponse{},
+				`{"jobId":"`+exampleJobId+`","circuit":"default","circuitRelease":"3f9a1c07d2e4","backend":"plonk","vectors":[`+
+					`{"name":"valid","valid":true,"proof":"0x1b2e","publicInputs":["0x0000000000000000000000000000000000000000000000000000000000000001","0x0000000000000000000000000000000000000000000000000000000000000002"],"calldata":"0x31e2c1a5"},`+
+					`{"name":"input_hash_changed","valid":false,"mutation":"inputHash, the second public input, incremented by one","reason":"the proof commits to the digest of the plonky2 public inputs, so it must not verify for other inputs","proof":"0x1b2e","publicInputs":["0x0000000000000000000000000000000000000000000000000000000000000001","0x0000000000000000000000000000000000000000000000000000000000000003"],"calldata":"0x31e2c1a5"}]}`),
+			errorResponse(http.StatusBadRequest, codeInvalidJobId, codeUnknownCircuit),
+			errorsUnauthorized,
+			errorResponse(http.StatusNotFound, codeJobNotFound),
+			errorsMethod,
+			errorResponse(http.StatusConflict, codeJobNotProved, codeReleaseNotLoaded),
+			errorResponse(http.StatusGone, "job_expired"),
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/dashboard/", method: http.MethodGet, summary: "Operator dashboard. Accepts the admin token as the password of HTTP basic authentication.", security: securityAdminToken, admin: true,
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The dashboard page.", contentType: "text/html"},
+			errorsUnauthorized,
+		},
+	},
+	{
+		pattern: "/dashboard/api/summary", method: http.MethodGet, summary: "Queue, duration percentiles, readiness and per-client throughput for the dashboard.", security: securityAdminToken, admin: true,
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The summary.", DashboardSummary{}, ""),
+			errorsUnauthorized,
+			errorsMethod,
+		},
+	},
+	{
+		pattern: "/dashboard/api/jobs", method: http.MethodGet, summary: "The jobs this instance is proving and the most recently finished jobs of all instances, newest first.", security: securityAdminToken, admin: true,
+		params: []openapi.Parameter{queryParam("limit", "integer", "Number of finished jobs, 50 by default.")},
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The jobs.", DashboardJobs{},
+				`{"running":[{"jobId":"`+exampleJobId+`","client":"orchestrator","stage":"proving","stageUpdatedAt":"2024-07-01T12:00:03.551Z"}],"recent":[{"jobId":"8c0f6f0e-7f0b-4a43-9d1e-2a4e3f1c9b10","success":false,"errorCode":"timeout","circuitRelease":"2024-06-30-a1b2c3d","finishedAt":"2024-07-01T11:58:00Z"}]}`),
+			errorResponse(http.StatusBadRequest, codeInvalidRequest),
+			errorsUnauthorized,
+			errorsMethod,
+		},
+	},
+	{
+		pattern: "/dead-letter-jobs", method: http.MethodGet, summary: "The jobs given up on after crashing the prover, newest first.", security: securityAdminToken, admin: true,
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The dead-lettered jobs.", DeadLetterJobsResponse{},
+				`{"jobs":[{"jobId":"`+exampleJobId+`","client":"orchestrator","errorMessage":"job was interrupted by a server restart after 3 attempts, giving up","errorCode":"job_failed","attempts":3,"deadAt":"2024-07-01T12:04:10.113Z"}]}`),
+			errorsUnauthorized,
+			errorsMethod,
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/dead-letter-jobs/retry", method: http.MethodPost, summary: "Queues a dead-lettered job again with its stored input.", security: securityAdminToken, admin: true,
+		params: []openapi.Parameter{jobIdParam("The dead-lettered job.")},
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The job was queued again.", RetryProofResponse{}, `{"jobId":"`+exampleJobId+`","attempts":4}`),
+			errorsInvalidJobId,
+			errorsUnauthorized,
+			errorResponse(http.StatusForbidden, codeCircuitNotAllowed),
+			errorResponse(http.StatusNotFound, codeJobNotFound),
+			errorsMethod,
+			errorResponse(http.StatusConflict, codeJobNotFailed, "input_not_stored"),
+			errorResponse(http.StatusGone, "job_expired"),
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/reload-secrets", method: http.MethodPost, summary: "Reads the secret files again and reports which secrets were rotated.", security: securityAdminToken, admin: true,
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The secrets read from files.", ReloadSecretsResponse{},
+				`{"secrets":[{"name":"API_KEYS","rotated":true,"restartRequired":false},{"name":"REDIS_URL","rotated":false,"restartRequired":true}]}`),
+			errorsUnauthorized,
+			errorsMethod,
+		},
+	},
+	{
+		pattern: "/reload-circuit", method: http.MethodPut, summary: "Loads the circuits again from the data directory, or from dataDir, and swaps them in once they validate. With circuit, only that circuit is loaded.", security: securityAdminToken, admin: true,
+		request: ReloadCircuitRequest{}, requestExample: `{"dataDir":"/data/releases/v2"}`, requestOptional: true,
+		responses: reloadCircuitResponses,
+	},
+	{
+		pattern: "/reload-circuit", method: http.MethodPost, summary: "Same as PUT /reload-circuit.", security: securityAdminToken, admin: true,
+		request: ReloadCircuitRequest{}, requestExample: `{"dataDir":"/data/releases/v2"}`, requestOptional: true,
+		responses: reloadCircuitResponses,
+	},
+	{
+		pattern: "/proof", method: http.MethodDelete, summary: "Purges a job, its result and its stored input before they expire.", security: securityAdminToken, admin: true,
+		params: []openapi.Parameter{jobIdParam("The job to delete.")},
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The job was deleted.", DeleteProofResponse{}, `{"jobId":"`+exampleJobId+`","deletedAt":"2024-07-02T09:30:00.000Z"}`),
+			errorsInvalidJobId,
+			errorsUnauthorized,
+			errorResponse(http.StatusNotFound, codeJobNotFound),
+			errorsMethod,
+			errorResponse(http.StatusConflict, codeJobProving),
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/events", method: http.MethodGet, summary: "Replays the state transitions of all jobs after a cursor, oldest first.", security: securityAdminToken, admin: true,
+		params: []openapi.Parameter{
+			queryParam("from", "string", "The event ID to replay after, the next cursor of the previous page. Left out to start at the oldest event kept."),
+			queryParam("limit", "integer", "Number of events, 100 by default and at most 1000."),
+		},
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "A page of the event log.", EventLogResponse{},
+				`{"events":[{"id":"1719835450114-0","jobId":"`+exampleJobId+`","state":"failed","at":"2024-07-01T12:04:10.114Z","errorCode":"timeout"},{"id":"1719835450120-0","jobId":"`+exampleJobId+`","state":"queued","at":"2024-07-01T12:04:10.120Z","attempts":2}],"next":"1719835450120-0","oldest":"1719800000000-0","truncated":false}`),
+			errorResponse(http.StatusBadRequest, codeInvalidRequest),
+			errorsUnauthorized,
+			errorsMethod,
+			errorsInternal,
+		},
+	},
+	{
+		pattern: "/fleet", method: http.MethodGet, summary: "Lists the instances sharing the Redis queue that heartbeated recently, with the jobs they are proving.", security: securityAdminToken, admin: true,
+		responses: []apiResponse{
+			jsonResponse(http.StatusOK, "The live instances, ordered by instance ID.", FleetResponse{},
+				`{"self":"prover-a","instances":[{"instanceId":"prover-a","hostname":"prover-a-7d9f","role":"prover","shard":{"count":2,"index":0},"apiVersion":"1.31","buildCommit":"a1b2c3d","circuitReleases":{"default":"2024-06-30-a1b2c3d"},"startedAt":"2024-07-01T08:00:00Z","lastHeartbeat":"2024-07-01T12:00:05.210Z","runningJobs":["`+exampleJobId+`"],"draining":false}]}`),
+			errorsUnauthorized,
+			errorsMethod,
+			errorsInternal,
+		},
+	},
+}
+
+// BuildOpenAPI describes the operations served under patterns as an OpenAPI
+// 3 document. It fails when a pattern has no operation in apiOperations or
+// an example does not match the schema of its payload, so that neither can
+// ship out of date.
+func BuildOpenAPI(patterns []string) (*openapi.Document, error) {
+	doc := openapi.New(openapi.Info{
+		Title:       "gnark-server",
+		Version:     apichanges.Current,
+		Description: "Wraps plonky2 proofs in gnark proofs. Errors are ErrorResponse objects with a stable code; ignore codes you do not know. Operations tagged admin are only served on the admin listener.",
+	}, reflect.TypeOf(State{}).PkgPath())
+	doc.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		securityAPIKey:     {Type: "http", Scheme: "bearer", Description: "An API key of API_KEYS, or a JSON Web Token signed with JWT_SECRET. Not required when authentication is disabled."},
+		securityAdminToken: {Type: "http", Scheme: "bearer", Description: "ADMIN_TOKEN."},
+	}
+	served := make(map[string]bool, len(patterns))
+	for _, pattern := range patterns {
+		served[pattern] = true
+	}
+	described := map[string]bool{}
+	for _, op := range apiOperations {
+		described[op.pattern] = true
+		if !served[op.pattern] {
+			continue
+		}
+		operation, err := op.build(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", op.method, op.pattern, err)
+		}
+		path := op.path
+		if path == "" {
+			path = op.pattern
+		}
+		if err := doc.Add(path, op.method, operation); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range patterns {
+		if !described[pattern] {
+			return nil, fmt.Errorf("route %s has no operation in the OpenAPI description", pattern)
+		}
+	}
+	enums := []struct {
+		v        interface{}
+		property string
+		values   []string
+	}{
+		{JobRecord{}, "state", jobStates},
+		{JobRecord{}, "priority", priorities},
+		{JobTransition{}, "state", append(jobStates[:len(jobStates):len(jobStates)], jobStateDeleted)},
+		{ProofEvent{}, "stage", []string{stageQueued, stageWitnessGeneration, stageProving, stageVerifying, stageDone, stageFailed}},
+		{ProofRequest{}, "priority", priorities},
+	}
+	for _, enum := range enums {
+		if err := doc.SetEnum(enum.v, enum.property, enum.values); err != nil {
+			return nil, err
+		}
+	}
+	if err := doc.SetDescription(ErrorResponse{}, "code", "Stable error code. New codes may be added; treat unknown codes by their HTTP status."); err != nil {
+		return nil, err
+	}
+	// Examples are checked once every schema is complete, enums included.
+	for _, op := range apiOperations {
+		if served[op.pattern] {
+			if err := op.validateExamples(doc); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", op.method, op.pattern, err)
+			}
+		}
+	}
+	return doc, nil
+}
+
+func (op apiOperation) build(doc *openapi.Document) (*openapi.Operation, error) {
+	operation := &openapi.Operation{
+		Summary:    op.summary,
+		Parameters: op.params,
+		Responses:  map[string]*openapi.Response{},
+	}
+	if op.admin {
+		operation.Tags = []string{"admin"}
+	}
+	if op.security != "" {
+		operation.Security = []map[string][]string{{op.security: {}}}
+	}
+	if op.request != nil {
+		schema, err := doc.Schema(op.request)
+		if err != nil {
+			return nil, err
+		}
+		operation.RequestBody = &openapi.RequestBody{
+			Required: !op.requestOptional,
+			Content:  map[string]openapi.MediaType{"application/json": {Schema: schema, Example: rawExample(op.requestExample)}},
+		}
+	}
+	for _, resp := range op.responses {
+		response := &openapi.Response{Description: resp.description}
+		contentType := resp.contentType
+		if contentType == "" && resp.body != nil {
+			contentType = "application/json"
+		}
+		if contentType != "" {
+			media := openapi.MediaType{Example: rawExample(resp.example)}
+			if resp.body != nil {
+				schema, err := resp.schema(doc)
+				if err != nil {
+					return nil, err
+				}
+				media.Schema = schema
+			}
+			response.Content = map[string]openapi.MediaType{contentType: media}
+		}
+		for name, description := range resp.headers {
+			if response.Headers == nil {
+				response.Headers = map[string]openapi.Header{}
+			}
+			response.Headers[name] = openapi.Header{Description: description, Schema: &openapi.Schema{Type: "string"}}
+		}
+		status := strconv.Itoa(resp.status)
+		if operation.Responses[status] != nil {
+			return nil, fmt.Errorf("response %s is described twice", status)
+		}
+		operation.Responses[status] = response
+	}
+	return operation, nil
+}
+
+func (op apiOperation) validateExamples(doc *openapi.Document) error {
+	if op.requestExample != "" {
+		schema, err := doc.Schema(op.request)
+		if err != nil {
+			return err
+		}
+		if err := doc.ValidateExample(schema, []byte(op.requestExample)); err != nil {
+			return fmt.Errorf("request example: %w", err)
+		}
+	}
+	for _, resp := range op.responses {
+		if resp.example == "" || resp.body == nil {
+			continue
+		}
+		schema, err := resp.schema(doc)
+		if err != nil {
+			return err
+		}
+		if err := doc.ValidateExample(schema, []byte(resp.example)); err != nil {
+			return fmt.Errorf("example of response %d: %w", resp.status, err)
+		}
+	}
+	return nil
+}
+
+func (resp apiResponse) schema(doc *openapi.Document) (*openapi.Schema, error) {
+	if len(resp.alternatives) > 0 {
+		return doc.AnyOf(append([]interface{}{resp.body}, resp.alternatives...)...)
+	}
+	return doc.Schema(resp.body)
+}
+
+func rawExample(example string) json.RawMessage {
+	if example == "" {
+		return nil
+	}
+	return json.RawMessage(example)
+}
+
+// OpenAPIHandler serves GET /openapi.json, the document built by
+// BuildOpenAPI.
+func OpenAPIHandler(doc *openapi.Document) (http.HandlerFunc, error) {
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}, nil
+}
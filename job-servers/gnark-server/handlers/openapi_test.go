@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gnark-server/openapi"
+)
+
+// describedPatterns returns the patterns of every operation of the OpenAPI
+// description.
+func describedPatterns() []string {
+	var patterns []string
+	for _, op := range apiOperations {
+		patterns = append(patterns, op.pattern)
+	}
+	return patterns
+}
+
+// checkPayload validates the JSON response w to method path against the
+// schema doc describes for its status.
+func checkPayload(t *testing.T, doc *openapi.Document, method string, path string, w *httptest.ResponseRecorder) {
+	t.Helper()
+	item := doc.Paths[path]
+	if item == nil || (*item)[strings.ToLower(method)] == nil {
+		t.Fatalf("%s %s is not described", method, path)
+	}
+	response := (*item)[strings.ToLower(method)].Responses[strconv.Itoa(w.Code)]
+	if response == nil {
+		t.Fatalf("%s %s answered %d, which is not described: %s", method, path, w.Code, w.Body)
+	}
+	media, ok := response.Content["application/json"]
+	if !ok || media.Schema == nil {
+		t.Fatalf("%s %s %d has no JSON schema", method, path, w.Code)
+	}
+	if err := doc.ValidateExample(media.Schema, w.Body.Bytes()); err != nil {
+		t.Fatalf("%s %s %d payload %s does not match its schema: %v", method, path, w.Code, w.Body, err)
+	}
+}
+
+func TestBuildOpenAPI(t *testing.T) {
+	doc, err := BuildOpenAPI(describedPatterns())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range apiOperations {
+		path := op.path
+		if path == "" {
+			path = op.pattern
+		}
+		if item := doc.Paths[path]; item == nil || (*item)[strings.ToLower(op.method)] == nil {
+			t.Fatalf("%s %s is missing from the document", op.method, path)
+		}
+	}
+	if _, err := BuildOpenAPI([]string{"/health", "/undocumented"}); err == nil || !strings.Contains(err.Error(), "/undocumented") {
+		t.Fatalf("BuildOpenAPI() of an undescribed route = %v", err)
+	}
+
+	partial, err := BuildOpenAPI([]string{"/health"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partial.Paths) != 1 {
+		t.Fatalf("document of /health describes %d paths", len(partial.Paths))
+	}
+
+	handler, err := OpenAPIHandler(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var served map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil || w.Code != http.StatusOK || served["openapi"] != openapi.Version {
+		t.Fatalf("openapi.json: %d, %v", w.Code, err)
+	}
+}
+
+func TestOpenAPIDescribesRealPayloads(t *testing.T) {
+	doc, err := BuildOpenAPI(describedPatterns())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newProvingTestState(t)
+	ctx := context.Background()
+	post := func(handler http.HandlerFunc, target string, body interface{}) *httptest.ResponseRecorder {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, target, bytes.NewReader(raw)))
+		return w
+	}
+
+	// The request a client sends matches the request schema.
+	request, err := json.Marshal(testProofRequest(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestSchema, err := doc.Schema(ProofRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.ValidateExample(requestSchema, request); err != nil {
+		t.Fatalf("start-proof request does not match its schema: %v", err)
+	}
+
+	started := post(s.StartProof, "/start-proof", testProofRequest(t))
+	checkPayload(t, doc, http.MethodPost, "/start-proof", started)
+	var start StartProofResponse
+	if err := json.Unmarshal(started.Body.Bytes(), &start); err != nil {
+		t.Fatal(err)
+	}
+	checkPayload(t, doc, http.MethodPost, "/start-proof", post(s.StartProof, "/start-proof", testProofRequest(t)))
+	checkPayload(t, doc, http.MethodPost, "/start-proof", post(s.StartProof, "/start-proof", ProofRequest{Proof: "{"}))
+	checkPayload(t, doc, http.MethodPost, "/start-proof", post(s.StartProof, "/start-proof", withPublicInputs(t, 7)))
+	checkPayload(t, doc, http.MethodPost, "/start-proofs", post(s.StartProofs, "/start-proofs",
+		[]ProofRequest{withPublicInputs(t, 7, 1), {Proof: "{}"}}))
+
+	getProof := func() *httptest.ResponseRecorder {
+		return serve(s.GetProof, http.MethodGet, "/get-proof?jobId="+start.JobId, "")
+	}
+	checkPayload(t, doc, http.MethodGet, "/get-proof", getProof())
+	s.setJobState(ctx, start.JobId, jobStateProving, nil)
+	checkPayload(t, doc, http.MethodGet, "/get-proof", getProof())
+	meta, err := s.getJobMetadata(ctx, start.JobId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := newProveResult([]string{"1", "2"}, "00")
+	s.finishJob(ctx, start.JobId, ProofResponse{Success: true, Proof: &result}, meta)
+	checkPayload(t, doc, http.MethodGet, "/get-proof", getProof())
+	checkPayload(t, doc, http.MethodGet, "/get-proof", serve(s.GetProof, http.MethodGet, "/get-proof?jobId=not-a-job", ""))
+	checkPayload(t, doc, http.MethodGet, "/get-proof", serve(s.GetProof, http.MethodGet, "/get-proof?jobId=306a20df-e359-4b3c-b6c6-8a1049b90fde", ""))
+
+	failed := addFinishedJob(t, s, jobStateQueued, testProofRequest(t))
+	s.failDeadLetter(ctx, failed, errors.New("prover panicked"))
+	checkPayload(t, doc, http.MethodGet, "/get-proof", serve(s.GetProof, http.MethodGet, "/get-proof?jobId="+failed, ""))
+	checkPayload(t, doc, http.MethodGet, "/dead-letter-jobs", serve(s.DeadLetterJobsHandler, http.MethodGet, "/dead-letter-jobs", ""))
+
+	checkPayload(t, doc, http.MethodGet, "/events", serve(s.EventLogHandler, http.MethodGet, "/events?limit=3", ""))
+	checkPayload(t, doc, http.MethodGet, "/events", serve(s.EventLogHandler, http.MethodGet, "/events?limit=0", ""))
+	checkPayload(t, doc, http.MethodGet, "/api-changes", serve(APIChangesHandler, http.MethodGet, "/api-changes?since=1.40", ""))
+	checkPayload(t, doc, http.MethodGet, "/health/ready", serve(s.ReadyHandler, http.MethodGet, "/health/ready", ""))
+	checkPayload(t, doc, http.MethodGet, "/version", serve(s.VersionHandler, http.MethodGet, "/version", ""))
+	checkPayload(t, doc, http.MethodGet, "/fleet", serve(s.FleetHandler, http.MethodGet, "/fleet", ""))
+	checkPayload(t, doc, http.MethodGet, "/stats", serve(s.Stats, http.MethodGet, "/stats", ""))
+	checkPayload(t, doc, http.MethodDelete, "/proof", serve(s.DeleteProof, http.MethodDelete, "/proof?jobId="+start.JobId, ""))
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"log"
+
+	"gnark-server/circuitData"
+	"gnark-server/proofcache"
+	"gnark-server/prover"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/qope/gnark-plonky2-verifier/types"
+)
+
+const (
+	// cacheHeader tells StartProof clients whether the proof came from the
+	// proof cache.
+	cacheHeader = "X-Cache"
+
+	metaCacheHit = "cacheHit"
+)
+
+// StartProofResponse is the response of start-proof. Proof is only set when
+// the proof was served from the proof cache.
+type StartProofResponse struct {
+	JobId string       `json:"jobId"`
+	Proof *ProveResult `json:"proof,omitempty"`
+	// Job is the lifecycle of the job as of the response.
+	Job *JobRecord `json:"job,omitempty"`
+}
+
+// proofCacheKey returns the proof cache key of a plonky2 proof: the hash of
+// the public witness of the gnark proof of data that wraps it.
+func proofCacheKey(proofRaw types.ProofWithPublicInputsRaw, vdRaw types.VerifierOnlyCircuitDataRaw, data circuitData.CircuitData) (string, error) {
+	publicWitness, err := prover.PublicWitness(proofRaw, vdRaw, data.InputDigestConfig())
+	if err != nil {
+		return "", err
+	}
+	publicWitnessBytes, err := publicWitness.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return proofcache.Key(publicWitnessBytes), nil
+}
+
+// lookupProofCache returns the proof cached by circuit release for the
+// request digest, or nil if there is none or the cache is disabled. Cache
+// errors are logged and treated as misses.
+func (s *State) lookupProofCache(ctx context.Context, release string, digest string) *proofcache.Entry {
+	if s.ProofCache == nil {
+		return nil
+	}
+	entry, err := s.ProofCache.Get(ctx, release, digest)
+	if err != nil {
+		log.Println("Failed to read proof cache:", err)
+		return nil
+	}
+	s.Metrics.proofCacheLookup(entry != nil)
+	return entry
+}
+
+// cacheProof stores a successful proof of a circuit in the proof cache.
+func (s *State) cacheProof(ctx context.Context, data circuitData.CircuitData, proofRaw types.ProofWithPublicInputsRaw, vdRaw types.VerifierOnlyCircuitDataRaw, result ProveResult) {
+	if s.ProofCache == nil {
+		return
+	}
+	key, err := proofCacheKey(proofRaw, vdRaw, data)
+	if err == nil {
+		err = s.ProofCache.Put(ctx, data.ReleaseId, key, proofcache.Entry{PublicInputs: result.PublicInputs, Proof: result.Proof})
+	}
+	if err != nil {
+		log.Println("Failed to write proof cache:", err)
+	}
+}
+
+// completeFromCache records a new job and finishes it with a cached proof,
+// without queueing it. The job is otherwise handled like a proved one: it
+// can be fetched with get-proof and its callback is notified.
+func (s *State) completeFromCache(ctx context.Context, jobId string, release string, meta map[string]interface{}, rawInput ProofRequest, entry *proofcache.Entry) error {
+	meta[metaCircuitRelease] = release
+	meta[metaCacheHit] = "true"
+	if err := s.storeJob(jobId, rawInput, meta); err != nil {
+		return err
+	}
+	_, err := s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err := queueProofResponse(ctx, pipe, jobId, ProofResponse{Success: true}); err != nil {
+			return err
+		}
+		queueJobMetadata(ctx, pipe, jobId, meta)
+		s.queueTransition(ctx, pipe, jobId, meta, nil)
+		if s.StoreInputs {
+			return storeJobInput(ctx, pipe, jobId, rawInput)
+		}
+		return nil
+	})
+	if err != nil {
+		s.unstoreJob(jobId)
+		return err
+	}
+	s.Metrics.started(1)
+	storedMeta, err := s.getJobMetadata(ctx, jobId)
+	if err != nil {
+		log.Printf("Failed to load metadata for job %s: %v\n", jobId, err)
+	}
+	result := newProveResult(entry.PublicInputs, entry.Proof)
+	response := ProofResponse{
+		Success: true,
+		Proof:   &result,
+	}
+	s.finishJob(ctx, jobId, response, storedMeta)
+	return nil
+}
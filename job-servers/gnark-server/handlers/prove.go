@@ -4,22 +4,24 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
 	"time"
 
-	verifierCircuit "gnark-server/circuit"
 	"gnark-server/circuitData"
+	"gnark-server/middleware"
+	"gnark-server/prover"
 	"gnark-server/utils"
+	"gnark-server/webhook"
 
-	"github.com/consensys/gnark-crypto/ecc"
-	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
-	"github.com/consensys/gnark/frontend"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/qope/gnark-plonky2-verifier/types"
-	"github.com/qope/gnark-plonky2-verifier/variables"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -30,29 +32,103 @@ const (
 type ProveResult struct {
 	PublicInputs []string `json:"publicInputs"`
 	Proof        string   `json:"proof"`
+	// VerifierDigest and InputHash are the public inputs of the proof, in
+	// decimal and as 0x-prefixed 32-byte words, so that callers can build
+	// the on-chain call without packing the plonky2 public inputs again.
+	VerifierDigest    string `json:"verifierDigest,omitempty"`
+	VerifierDigestHex string `json:"verifierDigestHex,omitempty"`
+	InputHash         string `json:"inputHash,omitempty"`
+	InputHashHex      string `json:"inputHashHex,omitempty"`
+}
+
+// newProveResult returns the result of a proof with the given decimal public
+// inputs and hex proof.
+func newProveResult(publicInputs []string, proof string) ProveResult {
+	result := ProveResult{PublicInputs: publicInputs, Proof: proof}
+	if len(publicInputs) != 2 {
+		return result
+	}
+	result.VerifierDigest, result.VerifierDigestHex = publicInput(publicInputs[0])
+	result.InputHash, result.InputHashHex = publicInput(publicInputs[1])
+	return result
+}
+
+func publicInput(decimal string) (string, string) {
+	value, ok := new(big.Int).SetString(decimal, 10)
+	if !ok {
+		return decimal, ""
+	}
+	return decimal, fmt.Sprintf("0x%064x", value)
+}
+
+type ProofRequest struct {
+	Proof        string `json:"proof"`
+	VerifierData string `json:"verifierData"`
+	CallbackUrl  string `json:"callbackUrl,omitempty"`
+	// TtlSeconds overrides how long the result is kept once the job finishes.
+	TtlSeconds int64 `json:"ttlSeconds,omitempty"`
+	// IdempotencyKey makes retried submissions return the original job. The
+	// Idempotency-Key header is used when it is empty.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// UpstreamCreatedAt is when the upstream created the work being proved,
+	// used to measure end-to-end latency.
+	UpstreamCreatedAt *time.Time `json:"upstreamCreatedAt,omitempty"`
+	// Priority is the queue of the job: high, normal or low. The X-Priority
+	// header is used when it is empty, and normal when both are.
+	Priority string `json:"priority,omitempty"`
+	// Circuit names the circuit to prove against. It may be empty when the
+	// server loads a single circuit.
+	Circuit string `json:"circuit,omitempty"`
+	// CircuitName is accepted in place of Circuit. It is cleared once the
+	// circuit is resolved, so stored inputs only carry Circuit.
+	CircuitName string `json:"circuit_name,omitempty"`
+	// ProveTimeoutSeconds overrides how long the job may spend proving, up
+	// to the server maximum.
+	ProveTimeoutSeconds int64 `json:"proveTimeoutSeconds,omitempty"`
+	// NotAfter is when the client stops waiting for the proof. A job that is
+	// not expected to be proved by then is handled by DEADLINE_POLICY when
+	// it is claimed.
+	NotAfter *time.Time `json:"notAfter,omitempty"`
 }
 
 type ProofResponse struct {
 	Success      bool         `json:"success"`
 	Proof        *ProveResult `json:"proof"`
 	ErrorMessage *string      `json:"errorMessage"`
-}
-
-type State struct {
-	CircuitData circuitData.CircuitData
-	RedisClient *redis.Client
+	// ErrorCode classifies ErrorMessage for failed jobs.
+	ErrorCode *string `json:"errorCode,omitempty"`
+	// ReplayReport is set on replay jobs started with compare=true.
+	ReplayReport *ReplayReport `json:"replayReport,omitempty"`
+	// Circuit is the circuit the job was submitted for.
+	Circuit string `json:"circuit,omitempty"`
+	// CircuitRelease identifies the circuit release that proved the job.
+	CircuitRelease string `json:"circuitRelease,omitempty"`
+	// Backend is the proving system of Proof, plonk or groth16.
+	Backend string `json:"backend,omitempty"`
+	// Job is the lifecycle of the job. It is read from the job metadata by
+	// GetProof and not stored with the response.
+	Job *JobRecord `json:"job,omitempty"`
 }
 
 func getRedisKey(jobId string) string {
 	return fmt.Sprintf("%s%s", redisKeyPrefix, jobId)
 }
 
-func (s *State) setProofResponse(ctx context.Context, jobId string, response ProofResponse) error {
+func (s *State) setProofResponse(ctx context.Context, jobId string, response ProofResponse, ttl time.Duration) error {
 	responseJSON, err := json.Marshal(response)
 	if err != nil {
 		return err
 	}
-	return s.RedisClient.Set(ctx, getRedisKey(jobId), responseJSON, expiration).Err()
+	return s.RedisClient.Set(ctx, getRedisKey(jobId), responseJSON, ttl).Err()
+}
+
+func queueProofResponse(ctx context.Context, pipe redis.Pipeliner, jobId string, response ProofResponse) error {
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, getRedisKey(jobId), responseJSON, expiration)
+	return nil
 }
 
 func (s *State) getProofResponse(ctx context.Context, jobId string) (ProofResponse, error) {
@@ -65,125 +141,647 @@ func (s *State) getProofResponse(ctx context.Context, jobId string) (ProofRespon
 	return response, err
 }
 
-func (s *State) prove(jobId string, proofRaw types.ProofWithPublicInputsRaw, vdRaw types.VerifierOnlyCircuitDataRaw) error {
-	proofWithPis := variables.DeserializeProofWithPublicInputs(proofRaw)
-	verifierData := variables.DeserializeVerifierOnlyCircuitData(vdRaw)
-	inputHash, err := utils.CalculateInputDigest(proofRaw.PublicInputs)
+func (s *State) prove(data *circuitData.CircuitData, jobId string, proofRaw types.ProofWithPublicInputsRaw, vdRaw types.VerifierOnlyCircuitDataRaw) error {
+	ctx := context.Background()
+	logger := data.Logger()
+	meta, err := s.getJobMetadata(ctx, jobId)
 	if err != nil {
-		return err
+		logger.Printf("Failed to load metadata for job %s: %v\n", jobId, err)
 	}
-	assignment := verifierCircuit.VerifierCircuit{
-		VerifierDigest: verifierData.CircuitDigest,
-		InputHash:      frontend.Variable(inputHash),
-		ProofWithPis:   proofWithPis,
-		VerifierData:   verifierData,
+	if sc := traceContextFromMetadata(meta); sc.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, sc)
 	}
-	witness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
-	ctx := context.Background()
-	if err != nil {
+	ctx, span := s.tracer().Start(ctx, "prove", trace.WithAttributes(jobIdAttribute(jobId)))
+	defer span.End()
+	startedAt := time.Now()
+
+	fail := func(err error) error {
+		duration := time.Since(startedAt)
+		s.Metrics.observeProve(false, duration)
+		s.recordProveDuration(ctx, jobId, meta, duration)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		errMsg := err.Error()
+		errCode := failureCode(err)
 		resp := ProofResponse{
 			Success:      false,
 			Proof:        nil,
 			ErrorMessage: &errMsg,
+			ErrorCode:    &errCode,
 		}
-		s.setProofResponse(ctx, jobId, resp)
-		return err
-	}
-	proof, err := plonk_bn254.Prove(&s.CircuitData.Ccs, &s.CircuitData.Pk, witness)
-	if err != nil {
-		errMsg := err.Error()
-		resp := ProofResponse{
-			Success:      false,
-			Proof:        nil,
-			ErrorMessage: &errMsg,
+		s.finishJob(ctx, jobId, resp, meta)
+		logger.Printf("Prove failed. jobId %s: %v\n", jobId, err)
+		// Every failed run counts as an attempt, so a job that fails on its
+		// last one is given up on like one that keeps crashing the prover.
+		if attemptsFromMetadata(meta) >= s.maxJobAttempts() {
+			s.deadLetter(ctx, jobId, err)
 		}
-		s.setProofResponse(ctx, jobId, resp)
 		return err
 	}
-	proofHex := hex.EncodeToString(proof.MarshalSolidity())
-	publicInputs, err := utils.ExtractPublicInputs(witness)
-	if err != nil {
-		errMsg := err.Error()
-		resp := ProofResponse{
-			Success:      false,
-			Proof:        nil,
-			ErrorMessage: &errMsg,
+
+	proveCtx := ctx
+	if timeout := s.proveTimeout(meta); timeout > 0 {
+		var cancel context.CancelFunc
+		proveCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		// The timeout in effect is recorded for debugging, whether it came
+		// from the request or the server.
+		seconds := int64(timeout.Seconds())
+		if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), metaProveTimeoutSeconds, seconds).Err(); err != nil {
+			logger.Printf("Failed to record the prove timeout of job %s: %v\n", jobId, err)
 		}
-		s.setProofResponse(ctx, jobId, resp)
-		return err
 	}
-	publicInputsStr := make([]string, len(publicInputs))
-	for i, bi := range publicInputs {
-		publicInputsStr[i] = bi.String()
+	stages := stageTimer{metrics: s.Metrics}
+	proved, err := prover.Prove(proveCtx, s.tracer(), data, proofRaw, vdRaw, func(stage string) {
+		stages.start(stage)
+		s.setStage(ctx, jobId, stage)
+	})
+	stages.stop()
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("job exceeded its prove timeout of %v: %w", s.proveTimeout(meta), err)
+		logger.Printf("Job %s timed out: %v\n", jobId, err)
+	}
+	if errors.Is(err, prover.ErrInputHashMismatch) {
+		logger.Printf("ERROR: job %s produced a proof for other public inputs than submitted: %v\n", jobId, err)
 	}
-	result := ProveResult{
-		PublicInputs: publicInputsStr,
-		Proof:        proofHex,
+	if err != nil {
+		return fail(err)
 	}
+	result := newProveResult(proved.PublicInputs, hex.EncodeToString(proved.Proof))
 	resp := ProofResponse{
 		Success: true,
 		Proof:   &result,
 	}
-	s.setProofResponse(ctx, jobId, resp)
-	log.Println("Prove done. jobId", jobId)
+	duration := time.Since(startedAt)
+	s.Metrics.observeProve(true, duration)
+	s.proveDurations.add(duration)
+	s.recordProveDuration(ctx, jobId, meta, duration)
+	s.recordProveDurationSample(ctx, jobId, data.Name, duration)
+	s.cacheProof(ctx, *data, proofRaw, vdRaw, result)
+	s.finishJob(ctx, jobId, resp, meta)
+	logger.Println("Prove done. jobId", jobId)
 	return nil
 }
 
-func (s *State) StartProof(w http.ResponseWriter, r *http.Request) {
-	_jobId, err := uuid.NewRandom()
+// finishJob stores the terminal response of a job and notifies its callback
+// URL, if one was registered at submission.
+func (s *State) finishJob(ctx context.Context, jobId string, response ProofResponse, meta map[string]string) {
+	response.Circuit = meta[metaCircuit]
+	response.CircuitRelease = meta[metaCircuitRelease]
+	if response.Proof != nil && response.Backend == "" {
+		response.Backend = s.backendName()
+	}
+	response.ReplayReport = s.replayReport(ctx, response, meta)
+	s.recordEndToEndLatency(ctx, jobId, meta, time.Now())
+	expiresAt := time.Now().Add(s.resultTTL(response.Success, meta))
+	s.storeProofResponse(ctx, jobId, response, time.Until(expiresAt))
+	if digest := meta[metaInputDigest]; digest != "" {
+		s.releaseInflight(ctx, digest, jobId)
+	}
+	s.Metrics.finished(response)
+	s.storeJobResult(jobId, response)
+	if response.Success {
+		s.setJobState(ctx, jobId, jobStateDone, nil)
+		s.setStage(ctx, jobId, stageDone)
+	} else {
+		var details map[string]interface{}
+		if response.ErrorCode != nil {
+			details = map[string]interface{}{"errorCode": *response.ErrorCode}
+		}
+		s.setJobState(ctx, jobId, jobStateFailed, details)
+		s.setStage(ctx, jobId, stageFailed)
+	}
+	s.expireJob(ctx, jobId, expiresAt)
+	s.retainJobInput(ctx, jobId, response.Success)
+	s.recordRecentJob(ctx, jobId, response, meta)
+	s.mirrorJob(jobId, response)
+	if callbackUrl := meta[metaCallbackUrl]; callbackUrl != "" {
+		s.deliverCallback(jobId, callbackUrl, response, meta)
+	}
+}
+
+// failJob marks a job as failed outside of prove.
+func (s *State) failJob(ctx context.Context, jobId string, err error) {
+	meta, metaErr := s.getJobMetadata(ctx, jobId)
+	if metaErr != nil {
+		log.Printf("Failed to load metadata for job %s: %v\n", jobId, metaErr)
+	}
+	errMsg := err.Error()
+	errCode := failureCode(err)
+	s.finishJob(ctx, jobId, ProofResponse{Success: false, ErrorMessage: &errMsg, ErrorCode: &errCode}, meta)
+}
+
+// storeProofResponse writes the final job response to Redis inside its own span.
+func (s *State) storeProofResponse(ctx context.Context, jobId string, response ProofResponse, ttl time.Duration) {
+	ctx, span := s.tracer().Start(ctx, "redis.store_result", trace.WithAttributes(jobIdAttribute(jobId)))
+	defer span.End()
+	startedAt := time.Now()
+	defer func() { s.Metrics.observeStage(stageRedisWrite, time.Since(startedAt)) }()
+	if err := s.setProofResponse(ctx, jobId, response, ttl); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		log.Printf("Failed to store proof response in Redis: %v\n", err)
+	}
+}
+
+// requestCircuit returns the circuit a request names in circuit or
+// circuit_name, and records its name in circuit.
+func (s *State) requestCircuit(rawInput *ProofRequest) (circuitData.CircuitData, error) {
+	name := rawInput.Circuit
+	if rawInput.CircuitName != "" {
+		if name != "" && name != rawInput.CircuitName {
+			return circuitData.CircuitData{}, &RequestError{Code: codeInvalidRequest,
+				Message: fmt.Sprintf("circuit %q and circuit_name %q name different circuits", name, rawInput.CircuitName)}
+		}
+		name = rawInput.CircuitName
+	}
+	data, err := s.circuit(name)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return data, err
 	}
-	jobId := _jobId.String()
+	rawInput.Circuit = data.Name
+	rawInput.CircuitName = ""
+	return data, nil
+}
+
+func parseProofRequest(rawInput ProofRequest) (types.ProofWithPublicInputsRaw, types.VerifierOnlyCircuitDataRaw, error) {
+	return prover.ParseInput([]byte(rawInput.Proof), []byte(rawInput.VerifierData))
+}
 
-	var rawInput struct {
-		Proof        string `json:"proof"`
-		VerifierData string `json:"verifierData"`
+// validateCallback checks the optional callbackUrl of a validated request for
+// data and returns the job metadata to record for it.
+func (s *State) validateCallback(ctx context.Context, rawInput ProofRequest, data circuitData.CircuitData) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	callbackUrl := rawInput.CallbackUrl
+	if callbackUrl == "" {
+		return fields, nil
 	}
-	if err := json.NewDecoder(r.Body).Decode(&rawInput); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	validator := s.CallbackValidator
+	if validator == nil {
+		validator = &webhook.Validator{}
 	}
+	probe, err := validator.Validate(ctx, callbackUrl)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := requestDigest(rawInput, data)
+	if err != nil {
+		return nil, err
+	}
+	fields[metaCallbackUrl] = callbackUrl
+	fields[metaCallbackValidation] = probe
+	fields[metaCallbackInputDigest] = digest
+	return fields, nil
+}
 
-	var proofRaw types.ProofWithPublicInputsRaw
-	if err := json.Unmarshal([]byte(rawInput.Proof), &proofRaw); err != nil {
-		http.Error(w, "Failed to parse proof JSON: "+err.Error(), http.StatusBadRequest)
+var (
+	errInvalidJobId = errors.New("Invalid JobId")
+	errJobNotFound  = errors.New("job not found")
+)
+
+// RequestError reports a problem with the submitted request rather than with
+// the server.
+type RequestError struct {
+	Code    string
+	Message string
+	Details map[string]interface{}
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func writeRequestError(w http.ResponseWriter, err error) {
+	if err == errShuttingDown {
+		writeError(w, http.StatusServiceUnavailable, codeShuttingDown, err.Error())
+		return
+	}
+	if err == errProvingDisabled {
+		writeError(w, http.StatusServiceUnavailable, codeProvingDisabled, err.Error())
+		return
+	}
+	var fullErr *QueueFullError
+	if errors.As(err, &fullErr) {
+		writeErrorDetails(w, http.StatusTooManyRequests, codeQueueFull, fullErr.Error(),
+			map[string]interface{}{"queueDepth": fullErr.Depth, "maxQueueLength": fullErr.Max})
 		return
 	}
-	var vdRaw types.VerifierOnlyCircuitDataRaw
-	if err := json.Unmarshal([]byte(rawInput.VerifierData), &vdRaw); err != nil {
-		http.Error(w, "Failed to parse verifier data JSON: "+err.Error(), http.StatusBadRequest)
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		log.Println("Request failed:", err)
+		writeInternalError(w)
 		return
 	}
+	writeErrorDetails(w, requestErrorStatus(reqErr.Code), reqErr.Code, reqErr.Message, reqErr.Details)
+}
+
+func (s *State) maxPublicInputs() int {
+	if s.MaxPublicInputs > 0 {
+		return s.MaxPublicInputs
+	}
+	return prover.DefaultMaxPublicInputs
+}
+
+// validateProofRequest parses the proof, checks its structure and its public
+// inputs, so that malformed submissions are rejected before they are queued.
+// Proofs with arrays beyond the limits of the circuit are refused before
+// they are decoded.
+func (s *State) validateProofRequest(rawInput ProofRequest, data circuitData.CircuitData) error {
+	err := prover.CheckProofSize([]byte(rawInput.Proof), data.ProofLimits, s.maxPublicInputs())
+	var proofRaw types.ProofWithPublicInputsRaw
+	if err == nil {
+		proofRaw, _, err = parseProofRequest(rawInput)
+	}
+	if err == nil {
+		err = prover.ValidateProof(proofRaw)
+	}
+	if err == nil {
+		_, err = utils.CalculateDigest(proofRaw.PublicInputs, data.InputDigestConfig())
+	}
+	if err != nil {
+		return &RequestError{Code: inputErrorCode(err), Message: err.Error()}
+	}
+	return nil
+}
+
+// checkCircuitAllowed refuses a validated request for a circuit digest the
+// credentials of ctx do not allow.
+func checkCircuitAllowed(ctx context.Context, rawInput ProofRequest) error {
+	_, vdRaw, err := parseProofRequest(rawInput)
+	if err != nil {
+		return err
+	}
+	if !middleware.CircuitAllowed(ctx, vdRaw.CircuitDigest) {
+		return &RequestError{
+			Code:    codeCircuitNotAllowed,
+			Message: fmt.Sprintf("credentials do not allow proofs for circuit digest %s", vdRaw.CircuitDigest),
+		}
+	}
+	return nil
+}
+
+// startedJob is the outcome of startProof.
+type startedJob struct {
+	jobId string
+	// cached is the proof when the job was finished from the proof cache.
+	cached *ProveResult
+	// deduplicated is set when jobId is an identical submission that was
+	// still in flight.
+	deduplicated bool
+}
+
+// startProof validates a proof request, records the new job in Redis and
+// pushes it onto the job queue. If the proof cache holds a proof for the same
+// public inputs, the job is finished with it right away. If a job proving the
+// same public inputs is still in flight, that job is returned instead. It is
+// shared by the HTTP and gRPC transports.
+func (s *State) startProof(ctx context.Context, rawInput ProofRequest) (startedJob, error) {
+	if s.isStopping() {
+		return startedJob{}, errShuttingDown
+	}
+	if s.VerifyOnly {
+		return startedJob{}, errProvingDisabled
+	}
+	_jobId, err := uuid.NewRandom()
+	if err != nil {
+		return startedJob{}, err
+	}
+	jobId := _jobId.String()
+	ctx, span := s.tracer().Start(ctx, "StartProof", trace.WithAttributes(jobIdAttribute(jobId)))
+	defer span.End()
+
+	job, err := s.prepareJob(ctx, rawInput)
+	if err != nil {
+		return startedJob{}, err
+	}
+	return s.createJob(ctx, jobId, job, true)
+}
+
+// preparedJob is a validated proof request, along with the metadata of the
+// job it creates.
+type preparedJob struct {
+	input ProofRequest
+	data  circuitData.CircuitData
+	meta  map[string]interface{}
+	// digest identifies the public inputs, see requestDigest.
+	digest string
+}
+
+// prepareJob validates a proof request and builds the metadata of its job.
+// Nothing is written to Redis yet, see createJob.
+func (s *State) prepareJob(ctx context.Context, rawInput ProofRequest) (preparedJob, error) {
+	data, err := s.requestCircuit(&rawInput)
+	if err != nil {
+		return preparedJob{}, err
+	}
+	if err := s.validateProofRequest(rawInput, data); err != nil {
+		return preparedJob{}, err
+	}
+	if err := checkCircuitAllowed(ctx, rawInput); err != nil {
+		return preparedJob{}, err
+	}
+	if err := validateTTL(rawInput.TtlSeconds); err != nil {
+		return preparedJob{}, err
+	}
+	if err := s.validateProveTimeout(rawInput.ProveTimeoutSeconds); err != nil {
+		return preparedJob{}, err
+	}
+	if err := validateIdempotencyKey(rawInput.IdempotencyKey); err != nil {
+		return preparedJob{}, err
+	}
+	if err := validatePriority(rawInput.Priority); err != nil {
+		return preparedJob{}, err
+	}
+	if err := validateUpstreamCreatedAt(rawInput.UpstreamCreatedAt, time.Now()); err != nil {
+		return preparedJob{}, err
+	}
+	if err := validateNotAfter(rawInput.NotAfter, time.Now()); err != nil {
+		return preparedJob{}, err
+	}
+	meta, err := s.validateCallback(ctx, rawInput, data)
+	if err != nil {
+		var verr *webhook.ValidationError
+		if errors.As(err, &verr) {
+			return preparedJob{}, &RequestError{Code: verr.Code, Message: verr.Message}
+		}
+		return preparedJob{}, &RequestError{Code: codeInvalidRequest, Message: err.Error()}
+	}
+	for k, v := range traceMetadata(ctx) {
+		meta[k] = v
+	}
+	if client := middleware.ClientLabel(ctx); client != "" {
+		meta[metaClient] = client
+	}
+	if rawInput.TtlSeconds > 0 {
+		meta[metaTTLSeconds] = rawInput.TtlSeconds
+	}
+	if rawInput.ProveTimeoutSeconds > 0 {
+		meta[metaProveTimeoutSeconds] = rawInput.ProveTimeoutSeconds
+	}
+	if rawInput.UpstreamCreatedAt != nil {
+		meta[metaUpstreamCreatedAt] = rawInput.UpstreamCreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	if rawInput.NotAfter != nil {
+		meta[metaNotAfter] = rawInput.NotAfter.UTC().Format(time.RFC3339Nano)
+	}
+	for k, v := range queuedFields(time.Now()) {
+		meta[k] = v
+	}
+	meta[metaCircuit] = data.Name
+	digest, err := requestDigest(rawInput, data)
+	if err != nil {
+		return preparedJob{}, err
+	}
+	return preparedJob{input: rawInput, data: data, meta: meta, digest: digest}, nil
+}
+
+// createJob records a prepared job as jobId and queues it, unless its
+// idempotency key already maps to a job, the proof cache finishes it or an
+// identical job is still in flight. Unless checkCapacity is false, because
+// the caller already checked it, a full queue refuses the job.
+func (s *State) createJob(ctx context.Context, jobId string, job preparedJob, checkCapacity bool) (startedJob, error) {
+	rawInput, meta, digest := job.input, job.meta, job.digest
+	entry := s.lookupProofCache(ctx, job.data.ReleaseId, digest)
+	if key := rawInput.IdempotencyKey; key != "" {
+		existing, err := s.claimIdempotencyKey(ctx, key, jobId)
+		if err != nil {
+			return startedJob{}, err
+		}
+		if existing != "" {
+			log.Println("StartProof", existing, "duplicate submission")
+			return startedJob{jobId: existing}, nil
+		}
+		meta[metaIdempotencyKey] = getRedisIdempotencyKey(ctx, key)
+	}
+	releaseIdempotencyKey := func() {
+		if rawInput.IdempotencyKey != "" {
+			s.releaseIdempotencyKey(ctx, rawInput.IdempotencyKey, jobId)
+		}
+	}
+	if entry != nil {
+		if err := s.completeFromCache(ctx, jobId, job.data.ReleaseId, meta, rawInput, entry); err != nil {
+			releaseIdempotencyKey()
+			return startedJob{}, err
+		}
+		log.Println("StartProof", jobId, "served from the proof cache")
+		result := newProveResult(entry.PublicInputs, entry.Proof)
+		return startedJob{jobId: jobId, cached: &result}, nil
+	}
+	inflight, err := s.claimInflight(ctx, digest, jobId)
+	if err != nil {
+		releaseIdempotencyKey()
+		return startedJob{}, err
+	}
+	if inflight != "" {
+		releaseIdempotencyKey()
+		log.Println("StartProof", inflight, "deduplicated in-flight submission")
+		return startedJob{jobId: inflight, deduplicated: true}, nil
+	}
+	meta[metaInputDigest] = digest
+	abandon := func() {
+		releaseIdempotencyKey()
+		s.releaseInflight(ctx, digest, jobId)
+	}
+	if checkCapacity {
+		if err := s.checkQueueCapacity(ctx, 1); err != nil {
+			abandon()
+			return startedJob{}, err
+		}
+	}
+	if err := s.storeJob(jobId, rawInput, meta); err != nil {
+		abandon()
+		return startedJob{}, err
+	}
 	resp := ProofResponse{
 		Success: true,
 		Proof:   nil,
 	}
-	if err := s.setProofResponse(context.Background(), jobId, resp); err != nil {
-		log.Printf("Failed to store proof response in Redis: %v\n", err)
+	pipe := s.RedisClient.TxPipeline()
+	err = queueProofResponse(ctx, pipe, jobId, resp)
+	if err == nil {
+		queueJobMetadata(ctx, pipe, jobId, meta)
+		s.queueTransition(ctx, pipe, jobId, meta, nil)
+		err = enqueueJob(ctx, pipe, jobId, rawInput)
+	}
+	if err == nil {
+		_, err = pipe.Exec(ctx)
+	}
+	if err != nil {
+		abandon()
+		s.unstoreJob(jobId)
+		return startedJob{}, err
 	}
-	go s.prove(jobId, proofRaw, vdRaw)
-	json.NewEncoder(w).Encode(map[string]string{"jobId": jobId})
-	log.Println("StartProof", jobId)
+	s.Metrics.started(1)
+	if client := middleware.ClientLabel(ctx); client != "" {
+		log.Println("StartProof", jobId, "client", client)
+	} else {
+		log.Println("StartProof", jobId)
+	}
+	return startedJob{jobId: jobId}, nil
 }
 
-func (s *State) GetProof(w http.ResponseWriter, r *http.Request) {
-	jobId := r.URL.Query().Get("jobId")
+// getProof loads the current response of a job. It is shared by the HTTP and
+// gRPC transports.
+func (s *State) getProof(ctx context.Context, jobId string) (ProofResponse, error) {
 	log.Println("GetProof", jobId)
-	_, err := uuid.Parse(jobId)
+	if _, err := uuid.Parse(jobId); err != nil {
+		return ProofResponse{}, errInvalidJobId
+	}
+	opts := []trace.SpanStartOption{trace.WithAttributes(jobIdAttribute(jobId))}
+	if sc, err := s.getTraceContext(ctx, jobId); err == nil && sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+	ctx, span := s.tracer().Start(ctx, "GetProof", opts...)
+	defer span.End()
+
+	response, err := s.coalescedProofResponse(ctx, jobId)
+	if err == redis.Nil {
+		if expired, err := s.isExpired(ctx, jobId); err == nil && expired {
+			response.Job = s.expiredJobRecord(ctx, jobId)
+			return response, errJobExpired
+		}
+		if deleted, err := s.isDeleted(ctx, jobId); err == nil && !deleted {
+			if stored, ok := s.storedProofResponse(jobId); ok {
+				return stored, nil
+			}
+		}
+		return response, errJobNotFound
+	} else if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return response, err
+	}
+	meta, err := s.getJobMetadata(ctx, jobId)
 	if err != nil {
-		http.Error(w, "Invalid JobId", http.StatusBadRequest)
+		log.Printf("Failed to load metadata for job %s: %v\n", jobId, err)
+	}
+	response.Job = jobRecordFromMetadata(meta)
+	if response.Circuit == "" {
+		response.Circuit = meta[metaCircuit]
+	}
+	return response, nil
+}
+
+// proofRequestBody is a ProofRequest as decoded from a request body, with
+// the proof left as the raw JSON string until its size is checked.
+type proofRequestBody struct {
+	ProofRequest
+	Proof json.RawMessage `json:"proof"`
+}
+
+// proofRequest checks the size of the proof of body against the limits of
+// its circuit, before the proof is unescaped and decoded, and returns the
+// request. Unknown circuits are left to startProof.
+func (s *State) proofRequest(body proofRequestBody) (ProofRequest, error) {
+	rawInput := body.ProofRequest
+	if len(body.Proof) == 0 {
+		return rawInput, nil
+	}
+	if data, err := s.circuit(rawInput.Circuit); err == nil {
+		if err := prover.CheckQuotedProofSize(body.Proof, data.ProofLimits, s.maxPublicInputs()); err != nil {
+			return rawInput, &RequestError{Code: inputErrorCode(err), Message: err.Error()}
+		}
+	}
+	if err := json.Unmarshal(body.Proof, &rawInput.Proof); err != nil {
+		return rawInput, &RequestError{Code: codeMalformedJSON, Message: "proof: " + err.Error()}
+	}
+	return rawInput, nil
+}
+
+// decodeProofRequest reads the body of start-proof and prove, taking the
+// idempotency key and priority from the headers when the body leaves them
+// out. Proofs beyond the limits of their circuit are refused before they are
+// decoded. It answers malformed bodies itself and then returns false.
+func (s *State) decodeProofRequest(w http.ResponseWriter, r *http.Request) (ProofRequest, bool) {
+	var body proofRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, codeMalformedJSON, err.Error())
+		return ProofRequest{}, false
+	}
+	rawInput, err := s.proofRequest(body)
+	if err != nil {
+		writeRequestError(w, err)
+		return rawInput, false
+	}
+	if rawInput.IdempotencyKey == "" {
+		rawInput.IdempotencyKey = r.Header.Get(idempotencyKeyHeader)
+	}
+	if rawInput.Priority == "" {
+		rawInput.Priority = r.Header.Get(priorityHeader)
+	}
+	return rawInput, true
+}
+
+func (s *State) StartProof(w http.ResponseWriter, r *http.Request) {
+	rawInput, ok := s.decodeProofRequest(w, r)
+	if !ok {
 		return
 	}
-	response, err := s.getProofResponse(r.Context(), jobId)
-	if err == redis.Nil {
-		http.Error(w, "job not found", http.StatusNotFound)
+	started, err := s.startProof(r.Context(), rawInput)
+	if err != nil {
+		writeRequestError(w, err)
 		return
-	} else if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+	if s.ProofCache != nil {
+		if started.cached != nil {
+			w.Header().Set(cacheHeader, "HIT")
+		} else {
+			w.Header().Set(cacheHeader, "MISS")
+		}
+	}
+	if started.deduplicated {
+		w.Header().Set(deduplicatedHeader, "true")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(StartProofResponse{
+		JobId: started.jobId,
+		Proof: started.cached,
+		Job:   s.jobRecord(r.Context(), started.jobId),
+	})
+}
+
+func (s *State) GetProof(w http.ResponseWriter, r *http.Request) {
+	jobId := r.URL.Query().Get("jobId")
+	format := r.URL.Query().Get("format")
+	if format != "" && format != proofFormatJSON && format != proofFormatCalldata {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf("format must be %s or %s", proofFormatJSON, proofFormatCalldata))
+		return
+	}
+	response, err := s.getProof(r.Context(), jobId)
+	if err != nil {
+		writeGetProofError(w, response, err)
+		return
+	}
+	if format == proofFormatCalldata {
+		calldataResponse, err := s.calldataResponse(response)
+		if err != nil {
+			log.Printf("Failed to encode calldata of job %s: %v\n", jobId, err)
+			writeInternalError(w)
+			return
+		}
+		json.NewEncoder(w).Encode(calldataResponse)
 		return
 	}
 	json.NewEncoder(w).Encode(response)
 }
+
+// writeGetProofError answers a request for the result of a job that getProof
+// could not load.
+func writeGetProofError(w http.ResponseWriter, response ProofResponse, err error) {
+	switch err {
+	case errInvalidJobId:
+		writeError(w, http.StatusBadRequest, codeInvalidJobId, err.Error())
+	case errJobNotFound:
+		writeError(w, http.StatusNotFound, codeJobNotFound, err.Error())
+	case errJobExpired:
+		var details map[string]interface{}
+		if response.Job != nil {
+			details = map[string]interface{}{"job": response.Job}
+		}
+		writeErrorDetails(w, http.StatusGone, errJobExpired.Code, errJobExpired.Message, details)
+	default:
+		writeInternalError(w)
+	}
+}
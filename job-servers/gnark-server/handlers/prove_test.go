@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStartProofChecksProofSizeBeforeDecoding(t *testing.T) {
+	// The proof of testdata has 8 public inputs.
+	tests := []struct {
+		name            string
+		maxPublicInputs int
+		wantCode        int
+	}{
+		{"at the limit", 8, http.StatusOK},
+		{"one past the limit", 7, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newProvingTestState(t)
+			s.MaxPublicInputs = tt.maxPublicInputs
+			body, err := json.Marshal(testProofRequest(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			s.StartProof(w, httptest.NewRequest(http.MethodPost, "/start-proof", bytes.NewReader(body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("start-proof: %d %s, want %d", w.Code, w.Body, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK {
+				return
+			}
+			if code := errorCode(t, w); code != codeInvalidPublicInputCount {
+				t.Fatalf("start-proof error code = %s, want %s", code, codeInvalidPublicInputCount)
+			}
+			if !strings.Contains(w.Body.String(), "at most 7 public inputs") {
+				t.Fatalf("start-proof = %s, want the size check to refuse it", w.Body)
+			}
+			if jobs := queuedJobs(t, s); len(jobs) != 0 {
+				t.Fatalf("queued %v, want nothing", jobs)
+			}
+		})
+	}
+}
+
+func TestStartProofsChecksProofSizePerEntry(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	s.MaxPublicInputs = 8
+	oversized := testProofRequest(t)
+	oversized.Proof = `{"public_inputs": [` + strings.Repeat("1, ", 8) + `1]}`
+	results := startProofs(t, s, []ProofRequest{testProofRequest(t), oversized})
+	if results[0].JobId == nil {
+		t.Fatalf("entry at the limit was refused: %+v", results[0])
+	}
+	if results[1].JobId != nil || results[1].ErrorCode == nil || *results[1].ErrorCode != codeInvalidPublicInputCount {
+		t.Fatalf("entry past the limit = %+v, want %s", results[1], codeInvalidPublicInputCount)
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMaxProveTimeout bounds the proveTimeoutSeconds of requests
+	// when MAX_PROVE_TIMEOUT_SECONDS is not set.
+	DefaultMaxProveTimeout = time.Hour
+
+	metaProveTimeoutSeconds = "proveTimeoutSeconds"
+
+	codeTimeout             = "timeout"
+	codeInvalidProveTimeout = "invalid_prove_timeout"
+)
+
+func (s *State) maxProveTimeout() time.Duration {
+	if s.MaxProveTimeout > 0 {
+		return s.MaxProveTimeout
+	}
+	return DefaultMaxProveTimeout
+}
+
+// validateProveTimeout checks the proveTimeoutSeconds of a request. Zero
+// selects the server default.
+func (s *State) validateProveTimeout(seconds int64) error {
+	limit := int64(s.maxProveTimeout().Seconds())
+	if seconds < 0 || seconds > limit {
+		return &RequestError{
+			Code:    codeInvalidProveTimeout,
+			Message: fmt.Sprintf("proveTimeoutSeconds must be between 1 and %d", limit),
+		}
+	}
+	return nil
+}
+
+// proveTimeout returns how long a job may spend building its witness and
+// proving: the proveTimeoutSeconds of the request if set, otherwise
+// ProveTimeout. Zero means no limit.
+func (s *State) proveTimeout(meta map[string]string) time.Duration {
+	if seconds, err := strconv.ParseInt(meta[metaProveTimeoutSeconds], 10, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return s.ProveTimeout
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gnark-server/circuitData"
+	"gnark-server/middleware"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	PublicStatusHealth         = "health"
+	PublicStatusQueueDepth     = "queueDepth"
+	PublicStatusAvgProofTime   = "avgProofMinutes"
+	PublicStatusCircuitRelease = "circuitRelease"
+
+	publicStatusCacheTTL = 15 * time.Second
+
+	// publicStatusRate and publicStatusBurst limit the requests of each
+	// client IP. publicStatusLimiterIdle is how long the limiter of an IP
+	// that stopped sending requests is kept.
+	publicStatusRate        = rate.Limit(10)
+	publicStatusBurst       = 20
+	publicStatusLimiterIdle = time.Minute
+
+	queueDepthMediumThreshold = 5
+	queueDepthHighThreshold   = 20
+)
+
+var allPublicStatusFields = []string{
+	PublicStatusHealth,
+	PublicStatusQueueDepth,
+	PublicStatusAvgProofTime,
+	PublicStatusCircuitRelease,
+}
+
+// PublicStatusResponse is the deliberately coarse view served without
+// authentication. It must never carry per-job or per-client data; add a
+// field here only if it is safe to publish to anyone.
+type PublicStatusResponse struct {
+	Health          *string `json:"health,omitempty"`
+	QueueDepth      *string `json:"queueDepth,omitempty"`
+	AvgProofMinutes *int64  `json:"avgProofMinutes,omitempty"`
+	CircuitRelease  *string `json:"circuitRelease,omitempty"`
+}
+
+// PublicStatus serves GET /public-status.
+type PublicStatus struct {
+	State  *State
+	Fields map[string]bool
+	// TrustedProxyDepth is the number of reverse proxies in front of the
+	// server that append to X-Forwarded-For, as for
+	// middleware.RateLimiter.
+	TrustedProxyDepth int
+
+	mu       sync.Mutex
+	cached   []byte
+	cachedAt time.Time
+
+	limitersMu sync.Mutex
+	limiters   map[string]*clientLimiter
+	sweptAt    time.Time
+}
+
+// clientLimiter is the limiter of one client IP and when it was last used.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ParsePublicStatusFields parses a comma separated list of enabled fields.
+// An empty string enables all of them.
+func ParsePublicStatusFields(s string) (map[string]bool, error) {
+	fields := map[string]bool{}
+	if strings.TrimSpace(s) == "" {
+		for _, f := range allPublicStatusFields {
+			fields[f] = true
+		}
+		return fields, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		known := false
+		for _, k := range allPublicStatusFields {
+			known = known || k == f
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown public status field %q", f)
+		}
+		fields[f] = true
+	}
+	return fields, nil
+}
+
+func NewPublicStatus(state *State, fields map[string]bool, trustedProxyDepth int) *PublicStatus {
+	return &PublicStatus{
+		State:             state,
+		Fields:            fields,
+		TrustedProxyDepth: trustedProxyDepth,
+		limiters:          map[string]*clientLimiter{},
+	}
+}
+
+// allow takes a token from the limiter of the client IP of r. Limiters idle
+// for publicStatusLimiterIdle are dropped, at most once per that period, so
+// that the map does not grow with every address that ever called.
+func (p *PublicStatus) allow(r *http.Request) bool {
+	ip := middleware.ClientIP(r, p.TrustedProxyDepth)
+	now := time.Now()
+	p.limitersMu.Lock()
+	defer p.limitersMu.Unlock()
+	if now.Sub(p.sweptAt) > publicStatusLimiterIdle {
+		for key, client := range p.limiters {
+			if now.Sub(client.lastSeen) > publicStatusLimiterIdle {
+				delete(p.limiters, key)
+			}
+		}
+		p.sweptAt = now
+	}
+	client, ok := p.limiters[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(publicStatusRate, publicStatusBurst)}
+		p.limiters[ip] = client
+	}
+	client.lastSeen = now
+	return client.limiter.AllowN(now, 1)
+}
+
+func (p *PublicStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.allow(r) {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, codeRateLimited, "too many requests")
+		return
+	}
+	body, err := p.snapshot()
+	if err != nil {
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(publicStatusCacheTTL.Seconds())))
+	w.Write(body)
+}
+
+func (p *PublicStatus) snapshot() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cached != nil && time.Since(p.cachedAt) < publicStatusCacheTTL {
+		return p.cached, nil
+	}
+	body, err := json.Marshal(p.build())
+	if err != nil {
+		return nil, err
+	}
+	p.cached, p.cachedAt = body, time.Now()
+	return body, nil
+}
+
+func (p *PublicStatus) build() PublicStatusResponse {
+	var resp PublicStatusResponse
+	s := p.State
+	if p.Fields[PublicStatusHealth] {
+		health := "up"
+		if s.isStopping() {
+			health = "maintenance"
+		} else if s.VerifyOnly || s.validateCircuits((*circuitData.CircuitData).Validate) != nil {
+			health = "degraded"
+		}
+		resp.Health = &health
+	}
+	if p.Fields[PublicStatusQueueDepth] {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		bucket := "unknown"
+		if depth, err := s.queueDepth(ctx); err != nil {
+			log.Printf("Failed to read queue depth: %v\n", err)
+		} else if depth >= queueDepthHighThreshold {
+			bucket = "high"
+		} else if depth >= queueDepthMediumThreshold {
+			bucket = "medium"
+		} else {
+			bucket = "low"
+		}
+		resp.QueueDepth = &bucket
+	}
+	if p.Fields[PublicStatusAvgProofTime] {
+		if avg, ok := s.proveDurations.average(); ok {
+			minutes := int64(math.Round(avg.Minutes()))
+			resp.AvgProofMinutes = &minutes
+		}
+	}
+	if release := s.circuitReleases(); p.Fields[PublicStatusCircuitRelease] && release != "" {
+		resp.CircuitRelease = &release
+	}
+	return resp
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublicStatusLeaksNoJobOrClientData(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	ctx := context.Background()
+	finished := addFinishedJob(t, s, jobStateFailed, testProofRequest(t))
+	if err := s.RedisClient.HSet(ctx, getRedisMetaKey(finished),
+		metaClient, "secret-client",
+		metaCallbackUrl, "https://callback.example/secret-hook",
+	).Err(); err != nil {
+		t.Fatal(err)
+	}
+	queued := *startProofs(t, s, []ProofRequest{testProofRequest(t)})[0].JobId
+	s.proveDurations.add(3 * time.Minute)
+
+	fields, err := ParsePublicStatusFields("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	NewPublicStatus(s, fields, 0).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public-status", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("public-status: %d %s, want 200", w.Code, w.Body)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	for key := range body {
+		known := false
+		for _, field := range allPublicStatusFields {
+			known = known || key == field
+		}
+		if !known {
+			t.Errorf("public-status has the field %q, which is not a public one", key)
+		}
+	}
+	if body[PublicStatusQueueDepth] != "low" || body[PublicStatusAvgProofTime] != float64(3) {
+		t.Errorf("public-status = %s, want queueDepth low and avgProofMinutes 3", w.Body)
+	}
+	for _, secret := range []string{finished, queued, "secret-client", "secret-hook", "prover failed"} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Errorf("public-status = %s, which contains %q", w.Body, secret)
+		}
+	}
+}
+
+func TestPublicStatusFields(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	fields, err := ParsePublicStatusFields("health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	NewPublicStatus(s, fields, 0).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/public-status", nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 1 || body[PublicStatusHealth] == nil {
+		t.Fatalf("public-status = %s, want only health", w.Body)
+	}
+	if _, err := ParsePublicStatusFields("health,jobs"); err == nil {
+		t.Fatal("ParsePublicStatusFields() accepted an unknown field")
+	}
+}
+
+func TestPublicStatusRateLimitPerClient(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	fields, err := ParsePublicStatusFields("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(p *PublicStatus, remoteAddr string, forwardedFor string) int {
+		r := httptest.NewRequest(http.MethodGet, "/public-status", nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, r)
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Fatal("429 without Retry-After")
+		}
+		return w.Code
+	}
+
+	direct := NewPublicStatus(s, fields, 0)
+	for i := 0; i < publicStatusBurst; i++ {
+		if code := get(direct, "198.51.100.1:1234", "203.0.113.1"); code != http.StatusOK {
+			t.Fatalf("request %d: %d, want 200", i, code)
+		}
+	}
+	if code := get(direct, "198.51.100.1:1234", "203.0.113.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst with another X-Forwarded-For: %d, want 429", code)
+	}
+	if code := get(direct, "198.51.100.2:1234", ""); code != http.StatusOK {
+		t.Fatalf("request of another client: %d, want 200", code)
+	}
+
+	proxied := NewPublicStatus(s, fields, 1)
+	for i := 0; i < publicStatusBurst; i++ {
+		if code := get(proxied, "10.0.0.1:1234", "203.0.113.1"); code != http.StatusOK {
+			t.Fatalf("request %d through the proxy: %d, want 200", i, code)
+		}
+	}
+	if code := get(proxied, "10.0.0.1:1234", "203.0.113.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("request past the burst through the proxy: %d, want 429", code)
+	}
+	if code := get(proxied, "10.0.0.1:1234", "203.0.113.2"); code != http.StatusOK {
+		t.Fatalf("request of another client through the proxy: %d, want 200", code)
+	}
+}
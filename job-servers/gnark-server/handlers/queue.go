@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// redisQueueKeyPrefix is followed by the priority of the jobs in the
+	// queue. Each queue is a sorted set of job IDs scored by enqueue time in
+	// milliseconds.
+	redisQueueKeyPrefix = "gnark_proof_queue:"
+	// redisLegacyQueueKey is the list older servers queue jobs on. Workers
+	// still drain it, between the high and normal queues.
+	redisLegacyQueueKey = "gnark_proof_queue"
+	redisProcessingKey  = "gnark_proof_processing"
+	redisInputKeyPrefix = "gnark_proof_input:"
+
+	metaPriority = "priority"
+
+	priorityHeader = "X-Priority"
+)
+
+// Job priorities. Workers take jobs from the high queue first and from the
+// low queue only when the others are empty.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+var priorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+func getRedisQueueKey(priority string) string {
+	return redisQueueKeyPrefix + priority
+}
+
+// redisQueueKeys returns the queue keys from the highest priority to the
+// lowest.
+func redisQueueKeys() []string {
+	keys := make([]string, len(priorities))
+	for i, priority := range priorities {
+		keys[i] = getRedisQueueKey(priority)
+	}
+	return keys
+}
+
+func getRedisInputKey(jobId string) string {
+	return fmt.Sprintf("%s%s", redisInputKeyPrefix, jobId)
+}
+
+// validatePriority checks the priority of a request. An empty priority is
+// normal.
+func validatePriority(priority string) error {
+	switch priority {
+	case "", PriorityHigh, PriorityNormal, PriorityLow:
+		return nil
+	}
+	return &RequestError{
+		Code:    codeInvalidPriority,
+		Message: fmt.Sprintf("priority must be %s, %s or %s", PriorityHigh, PriorityNormal, PriorityLow),
+	}
+}
+
+// jobPriority returns the priority of a job from its input or metadata
+// field, which is normal when unset.
+func jobPriority(priority string) string {
+	if priority == "" {
+		return PriorityNormal
+	}
+	return priority
+}
+
+// enqueueJob stores the job input and adds the job to the queue of its
+// priority, behind the jobs already there.
+func enqueueJob(ctx context.Context, pipe redis.Pipeliner, jobId string, input ProofRequest) error {
+	if err := storeJobInput(ctx, pipe, jobId, input); err != nil {
+		return err
+	}
+	priority := jobPriority(input.Priority)
+	pipe.HSet(ctx, getRedisMetaKey(jobId), metaPriority, priority)
+	pipe.ZAddNX(ctx, getRedisQueueKey(priority), &redis.Z{Score: float64(time.Now().UnixMilli()), Member: jobId})
+	return nil
+}
+
+// claimJobScript moves the next job to the processing list. The queues are
+// searched from the highest priority to the lowest, with the legacy list
+// between the high and normal queues, and each from its oldest job. When
+// ARGV[1] is 0 the first job found is taken. Otherwise the search only looks
+// at the ARGV[3] oldest jobs of each queue and takes the first one whose
+// inputDigest maps to shard ARGV[2] of ARGV[1], or that has no input digest.
+// If there is none and more than ARGV[4] jobs are queued, it takes the first
+// job found. It returns the job ID and the kind of claim, or nil.
+//
+// The metadata keys of the jobs are KEYS[6] followed by the job ID. The
+// prefix is passed as a key, although no such key exists, so that the
+// cluster client tags it into the slot of the other keys like theirs.
+//
+// KEYS: high, normal and low queues, legacy queue, processing, metadata key
+// prefix.
+// ARGV: shard count or 0, shard index, scan depth, fallback backlog.
+var claimJobScript = redis.NewScript(`
+local count = tonumber(ARGV[1])
+local index = tonumber(ARGV[2])
+local depth = tonumber(ARGV[3])
+local order = {1, 4, 2, 3}
+local function oldest(q, n)
+  if q == 4 then
+    local ids = redis.call('LRANGE', KEYS[4], -n, -1)
+    local reversed = {}
+    for i = #ids, 1, -1 do
+      reversed[#reversed + 1] = ids[i]
+    end
+    return reversed
+  end
+  return redis.call('ZRANGE', KEYS[q], 0, n - 1)
+end
+local function take(q, id, kind)
+  if q == 4 then
+    redis.call('LREM', KEYS[4], -1, id)
+  else
+    redis.call('ZREM', KEYS[q], id)
+  end
+  redis.call('LPUSH', KEYS[5], id)
+  return {id, kind}
+end
+local function shardKind(id)
+  local digest = redis.call('HGET', KEYS[6] .. id, 'inputDigest')
+  if not digest or #digest < 8 then
+    return 'untagged'
+  end
+  local prefix = tonumber(string.sub(digest, 1, 8), 16)
+  if not prefix then
+    return 'untagged'
+  elseif prefix % count == index then
+    return 'own'
+  end
+  return nil
+end
+if count == 0 then
+  for _, q in ipairs(order) do
+    local ids = oldest(q, 1)
+    if #ids > 0 then
+      return take(q, ids[1], 'any')
+    end
+  end
+  return nil
+end
+local queued = 0
+for _, q in ipairs(order) do
+  local ids = oldest(q, depth)
+  for _, id in ipairs(ids) do
+    local kind = shardKind(id)
+    if kind then
+      return take(q, id, kind)
+    end
+  end
+  if q == 4 then
+    queued = queued + redis.call('LLEN', KEYS[4])
+  else
+    queued = queued + redis.call('ZCARD', KEYS[q])
+  end
+end
+if queued > tonumber(ARGV[4]) then
+  for _, q in ipairs(order) do
+    local ids = oldest(q, 1)
+    if #ids > 0 then
+      return take(q, ids[1], 'fallback')
+    end
+  end
+end
+return nil
+`)
+
+// dequeueJob moves the next job to the processing list, waiting up to
+// dequeueTimeout for one. It returns redis.Nil if there was none. With a
+// Shard, only the jobs of the shard are claimed unless there is a backlog.
+func (s *State) dequeueJob(ctx context.Context) (string, error) {
+	keys := append(redisQueueKeys(), redisLegacyQueueKey, redisProcessingKey, redisMetaKeyPrefix)
+	args := []interface{}{0, 0, shardScanDepth, 0}
+	if s.Shard != nil {
+		args = []interface{}{s.Shard.Count, s.Shard.Index, shardScanDepth, s.Shard.FallbackBacklog}
+	}
+	res, err := claimJobScript.Run(ctx, s.RedisClient, keys, args...).StringSlice()
+	if err == redis.Nil {
+		// Claims across several queues cannot block, so poll instead.
+		select {
+		case <-s.stopped():
+		case <-time.After(dequeueTimeout):
+		}
+		return "", err
+	} else if err != nil {
+		return "", err
+	}
+	if len(res) != 2 {
+		return "", fmt.Errorf("unexpected claim result %v", res)
+	}
+	jobId, kind := res[0], res[1]
+	if s.Shard != nil {
+		s.countClaim(s.Shard, kind)
+	}
+	return jobId, nil
+}
+
+// requeueJobFront queues a job that was taken out of the queue again, ahead
+// of the other jobs of its priority.
+func requeueJobFront(ctx context.Context, pipe redis.Pipeliner, jobId string, priority string) {
+	pipe.ZAdd(ctx, getRedisQueueKey(jobPriority(priority)), &redis.Z{Score: 0, Member: jobId})
+}
+
+func storeJobInput(ctx context.Context, pipe redis.Pipeliner, jobId string, input ProofRequest) error {
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, getRedisInputKey(jobId), inputJSON, expiration)
+	return nil
+}
+
+func (s *State) loadJobInput(ctx context.Context, jobId string) (ProofRequest, error) {
+	var input ProofRequest
+	inputJSON, err := s.RedisClient.Get(ctx, getRedisInputKey(jobId)).Bytes()
+	if err != nil {
+		return input, err
+	}
+	err = json.Unmarshal(inputJSON, &input)
+	return input, err
+}
+
+// queueDepth returns the number of jobs waiting in or being processed from
+// the shared queue, across all replicas.
+func (s *State) queueDepth(ctx context.Context) (int64, error) {
+	return queueDepth(ctx, s.RedisClient)
+}
+
+// QueueFullError is returned when accepting more jobs would make the queue
+// longer than State.MaxQueueLength.
+type QueueFullError struct {
+	Depth int64
+	Max   int64
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("proof queue is full (%d of %d jobs), retry later", e.Depth, e.Max)
+}
+
+// checkQueueCapacity returns a *QueueFullError if n more jobs do not fit in
+// the queue. The check is not atomic with the push, so concurrent submissions
+// can overshoot MaxQueueLength by a few jobs.
+func (s *State) checkQueueCapacity(ctx context.Context, n int) error {
+	if s.MaxQueueLength <= 0 {
+		return nil
+	}
+	depth, err := s.queueDepth(ctx)
+	if err != nil {
+		return err
+	}
+	if depth+int64(n) > s.MaxQueueLength {
+		return &QueueFullError{Depth: depth, Max: s.MaxQueueLength}
+	}
+	return nil
+}
+
+func queueDepth(ctx context.Context, rdb redis.UniversalClient) (int64, error) {
+	pipe := rdb.Pipeline()
+	queued := make([]*redis.IntCmd, 0, len(priorities))
+	for _, key := range redisQueueKeys() {
+		queued = append(queued, pipe.ZCard(ctx, key))
+	}
+	legacy := pipe.LLen(ctx, redisLegacyQueueKey)
+	processing := pipe.LLen(ctx, redisProcessingKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	depth := legacy.Val() + processing.Val()
+	for _, cmd := range queued {
+		depth += cmd.Val()
+	}
+	return depth, nil
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gnark-server/redisconfig"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+func TestDequeueJobClaimsOwnShard(t *testing.T) {
+	tests := []struct {
+		name   string
+		client func(addr string) redis.UniversalClient
+		prefix string
+	}{
+		{"standalone", func(addr string) redis.UniversalClient {
+			return redis.NewClient(&redis.Options{Addr: addr})
+		}, ""},
+		{"cluster", func(addr string) redis.UniversalClient {
+			return redisconfig.NewClusterClient(&redis.ClusterOptions{Addrs: []string{addr}})
+		}, redisconfig.ClusterHashTag},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mr := miniredis.RunT(t)
+			rdb := tt.client(mr.Addr())
+			defer rdb.Close()
+			shard, err := NewShard(2, 1, DefaultShardFallbackBacklog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &State{RedisClient: rdb, Shard: shard}
+
+			// The oldest job belongs to shard 0, so a claim that cannot read
+			// the input digests takes it as untagged.
+			other := queueShardedJob(t, s, strings.Repeat("0", 64), 1)
+			own := queueShardedJob(t, s, "00000001"+strings.Repeat("0", 56), 2)
+			if !mr.Exists(tt.prefix + getRedisMetaKey(own)) {
+				t.Fatalf("metadata of job %s is not stored under %s", own, tt.prefix+getRedisMetaKey(own))
+			}
+
+			jobId, err := s.dequeueJob(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jobId != own {
+				t.Fatalf("claimed %s, want %s of shard 1 rather than %s of shard 0", jobId, own, other)
+			}
+			if stats := s.shardStats(); stats.Own != 1 || stats.Untagged != 0 {
+				t.Fatalf("shard stats %+v, want one own claim", stats)
+			}
+			processing, err := rdb.LRange(ctx, redisProcessingKey, 0, -1).Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(processing) != 1 || processing[0] != own {
+				t.Fatalf("processing %v, want [%s]", processing, own)
+			}
+		})
+	}
+}
+
+// queueShardedJob queues a normal priority job with inputDigest at score and
+// returns its ID.
+func queueShardedJob(t *testing.T, s *State, inputDigest string, score float64) string {
+	t.Helper()
+	ctx := context.Background()
+	jobId := uuid.NewString()
+	if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), metaInputDigest, inputDigest).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RedisClient.ZAdd(ctx, getRedisQueueKey(PriorityNormal), &redis.Z{Score: score, Member: jobId}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return jobId
+}
+
+func TestShardedFleetClaimAffinity(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	fleet := make([]*State, 3)
+	for i := range fleet {
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { rdb.Close() })
+		shard, err := NewShard(3, i, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fleet[i] = &State{RedisClient: rdb, Shard: shard}
+	}
+	// shardDigest returns an input digest whose first 32 bits map to shard.
+	shardDigest := func(shard int, n int) string {
+		return fmt.Sprintf("%08x", 3*n+shard) + strings.Repeat("0", 56)
+	}
+
+	// Affinity: every instance claims the jobs of its own shard, in order,
+	// although the jobs of all shards are interleaved in the queue.
+	want := map[int][]string{}
+	for n := 0; n < 3; n++ {
+		for shard := 0; shard < 3; shard++ {
+			jobId := queueShardedJob(t, fleet[0], shardDigest(shard, n), float64(3*n+shard))
+			want[shard] = append(want[shard], jobId)
+		}
+	}
+	for round := 0; round < 3; round++ {
+		for shard, s := range fleet {
+			jobId, err := s.dequeueJob(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jobId != want[shard][round] {
+				t.Fatalf("shard %d claimed %s in round %d, want %s", shard, jobId, round, want[shard][round])
+			}
+		}
+	}
+	for shard, s := range fleet {
+		if stats := s.shardStats(); stats.Own != 3 || stats.Fallback != 0 || stats.Untagged != 0 {
+			t.Fatalf("shard %d stats %+v, want three own claims", shard, stats)
+		}
+	}
+
+	// Fallback: with only jobs of shard 0 queued, shard 1 leaves them while
+	// the backlog is at most 4 and helps out once it is larger.
+	var backlog []string
+	for n := 0; n < 4; n++ {
+		backlog = append(backlog, queueShardedJob(t, fleet[0], shardDigest(0, 10+n), float64(100+n)))
+	}
+	if jobId, err := fleet[1].dequeueJob(ctx); err != redis.Nil {
+		t.Fatalf("shard 1 claimed %q, %v with a backlog of 4, want nothing", jobId, err)
+	}
+	backlog = append(backlog, queueShardedJob(t, fleet[0], shardDigest(0, 14), 104))
+	jobId, err := fleet[1].dequeueJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobId != backlog[0] {
+		t.Fatalf("shard 1 claimed %s with a backlog of 5, want the oldest job %s", jobId, backlog[0])
+	}
+	if stats := fleet[1].shardStats(); stats.Fallback != 1 {
+		t.Fatalf("shard 1 stats %+v, want one fallback claim", stats)
+	}
+
+	// Untagged jobs go to whichever shard claims first.
+	untagged := uuid.NewString()
+	if err := fleet[2].RedisClient.ZAdd(ctx, getRedisQueueKey(PriorityNormal), &redis.Z{Score: 1, Member: untagged}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if jobId, err := fleet[2].dequeueJob(ctx); err != nil || jobId != untagged {
+		t.Fatalf("shard 2 claimed %q, %v, want the untagged job %s", jobId, err, untagged)
+	}
+	if stats := fleet[2].shardStats(); stats.Untagged != 1 {
+		t.Fatalf("shard 2 stats %+v, want one untagged claim", stats)
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisLeaseKeyPrefix = "gnark_proof_lease:"
+
+	// leaseTTL is how long the lease of a job outlives its worker. Workers
+	// renew it every leaseRenewInterval while proving.
+	leaseTTL           = 30 * time.Second
+	leaseRenewInterval = 10 * time.Second
+
+	// recoveryGrace is how long a job in the processing list must stay
+	// without a lease before it is recovered. Workers take the lease right
+	// after moving a job to the processing list, so this only has to cover
+	// that gap.
+	recoveryGrace = 5 * time.Second
+
+	// DefaultMaxJobAttempts is the number of runs after which an interrupted
+	// job is failed instead of queued again, and a failed one is moved to the
+	// dead-letter queue, when MAX_JOB_ATTEMPTS is not set.
+	DefaultMaxJobAttempts = 3
+)
+
+func getRedisLeaseKey(jobId string) string {
+	return fmt.Sprintf("%s%s", redisLeaseKeyPrefix, jobId)
+}
+
+// holdLease marks jobId as being proved by a live worker of this instance
+// until the returned function is called. The lease holds the instance ID and
+// expires leaseTTL after the worker stops renewing it, for example because
+// its process was killed.
+func (s *State) holdLease(ctx context.Context, jobId string) (release func()) {
+	key := getRedisLeaseKey(jobId)
+	if err := s.RedisClient.Set(ctx, key, s.leaseHolder(), leaseTTL).Err(); err != nil {
+		log.Printf("Failed to take the lease of job %s: %v\n", jobId, err)
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(leaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.RedisClient.Expire(ctx, key, leaseTTL).Err(); err != nil {
+					log.Printf("Failed to renew the lease of job %s: %v\n", jobId, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		if err := s.RedisClient.Del(ctx, key).Err(); err != nil {
+			log.Printf("Failed to release the lease of job %s: %v\n", jobId, err)
+		}
+	}
+}
+
+// recoverJobScript takes an interrupted job out of the processing list, if
+// it is still there without a lease or with the lease ARGV[5] of an instance
+// that is gone, and queues it again at the front of the queue of its
+// priority with one more attempt. It returns the new attempt count, 0 if the
+// job has used up ARGV[3] attempts and was only taken out of the processing
+// list, and -1 if it was not interrupted. The requeue is logged in the event
+// log.
+//
+// KEYS: processing, metadata, lease, high, normal and low queues, event log.
+// ARGV: jobId, now, max attempts, event log length, dead lease holder or "".
+var recoverJobScript = redis.NewScript(`
+local lease = redis.call('GET', KEYS[3])
+if lease and lease ~= ARGV[5] then
+  return -1
+end
+if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 0 then
+  return -1
+end
+redis.call('DEL', KEYS[3])
+local attempts = tonumber(redis.call('HGET', KEYS[2], 'attempts') or '1') + 1
+if attempts > tonumber(ARGV[3]) then
+  return 0
+end
+redis.call('HDEL', KEYS[2], 'provingAt')
+redis.call('HSET', KEYS[2], 'attempts', attempts, 'state', 'queued', 'queuedAt', ARGV[2])
+local queue = KEYS[5]
+local priority = redis.call('HGET', KEYS[2], 'priority')
+if priority == 'high' then
+  queue = KEYS[4]
+elseif priority == 'low' then
+  queue = KEYS[6]
+end
+redis.call('ZADD', queue, 0, ARGV[1])
+redis.call('XADD', KEYS[7], 'MAXLEN', '~', ARGV[4], '*',
+  'jobId', ARGV[1], 'state', 'queued', 'at', ARGV[2], 'attempts', attempts)
+return attempts
+`)
+
+// RecoverInterrupted finds the jobs left in the processing list by workers
+// that died while proving them, across all instances, and queues them again
+// so that clients do not wait for them forever. A job is interrupted when it
+// is still orphaned recoveryGrace after it was first seen orphaned: it has no
+// lease, or its lease is held by an instance missing from the fleet registry,
+// or by this instance although none of its workers is proving the job, which
+// happens when an instance restarts under the same ID before the lease of
+// its previous run expired. Leases of servers that do not record their
+// instance are trusted until they expire. Jobs that already ran maxAttempts
+// times are failed instead. It returns the number of jobs requeued and
+// failed.
+func (s *State) RecoverInterrupted(ctx context.Context, maxAttempts int) (requeued int, failed int, err error) {
+	candidates, err := s.orphanedJobs(ctx)
+	if err != nil || len(candidates) == 0 {
+		return 0, 0, err
+	}
+	select {
+	case <-time.After(recoveryGrace):
+	case <-ctx.Done():
+		return 0, 0, ctx.Err()
+	}
+	orphaned, err := s.orphanedJobs(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	for jobId := range candidates {
+		holder, ok := orphaned[jobId]
+		if !ok {
+			continue
+		}
+		keys := append([]string{redisProcessingKey, getRedisMetaKey(jobId), getRedisLeaseKey(jobId)}, redisQueueKeys()...)
+		keys = append(keys, redisEventLogKey)
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		attempts, err := recoverJobScript.Run(ctx, s.RedisClient, keys, jobId, now, maxAttempts, s.eventLogMaxLength(), holder).Int64()
+		if err != nil {
+			return requeued, failed, err
+		}
+		switch {
+		case attempts < 0:
+			continue
+		case attempts == 0:
+			log.Println("Failing interrupted job", jobId, "after", maxAttempts, "attempts")
+			s.failDeadLetter(ctx, jobId, fmt.Errorf("job was interrupted by a server restart after %d attempts, giving up", maxAttempts))
+			failed++
+		default:
+			log.Println("Requeued interrupted job", jobId, "attempt", attempts)
+			s.setStage(ctx, jobId, stageQueued)
+			requeued++
+		}
+	}
+	return requeued, failed, nil
+}
+
+// orphanedJobs returns the jobs in the processing list that no live worker
+// holds, mapped to the holder of their stale lease, "" for jobs without one.
+func (s *State) orphanedJobs(ctx context.Context) (map[string]string, error) {
+	jobIds, err := s.RedisClient.LRange(ctx, redisProcessingKey, 0, -1).Result()
+	if err != nil || len(jobIds) == 0 {
+		return nil, err
+	}
+	live, err := s.liveInstances(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pipe := s.RedisClient.Pipeline()
+	leases := make([]*redis.StringCmd, len(jobIds))
+	for i, jobId := range jobIds {
+		leases[i] = pipe.Get(ctx, getRedisLeaseKey(jobId))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	orphaned := make(map[string]string)
+	for i, jobId := range jobIds {
+		holder, err := leases[i].Result()
+		switch {
+		case err == redis.Nil:
+			orphaned[jobId] = ""
+		case err != nil:
+			return nil, err
+		case holder == legacyLeaseHolder:
+		case holder == s.InstanceId:
+			if _, running := s.running.Load(jobId); !running {
+				orphaned[jobId] = holder
+			}
+		case !live[holder]:
+			orphaned[jobId] = holder
+		}
+	}
+	return orphaned, nil
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// claimJob queues a job and claims it like a worker of s would, leaving it
+// proving in the processing list under a lease held by holder. It returns
+// the job ID.
+func claimJob(t *testing.T, s *State, holder string) string {
+	t.Helper()
+	ctx := context.Background()
+	results := startProofs(t, s, []ProofRequest{testProofRequest(t)})
+	if results[0].JobId == nil {
+		t.Fatalf("start-proofs: %s", *results[0].ErrorMessage)
+	}
+	jobId, err := s.dequeueJob(ctx)
+	if err != nil || jobId != *results[0].JobId {
+		t.Fatalf("dequeueJob() = %s, %v, want %s", jobId, err, *results[0].JobId)
+	}
+	if err := s.RedisClient.Set(ctx, getRedisLeaseKey(jobId), holder, leaseTTL).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), stateFields(jobStateProving, time.Now())).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return jobId
+}
+
+// restart returns a State for an instance started on the Redis of s after s
+// was killed.
+func restart(s *State, instanceId string) *State {
+	return &State{RedisClient: s.RedisClient, Circuits: s.Circuits, InstanceId: instanceId}
+}
+
+func TestRecoverInterrupted(t *testing.T) {
+	tests := []struct {
+		name string
+		// interrupt leaves a job in the processing list of s and returns it.
+		interrupt   func(t *testing.T, s *State, mr *miniredis.Miniredis) string
+		restartedAs string
+		wantState   string
+	}{
+		{"killed worker, lease expired", func(t *testing.T, s *State, mr *miniredis.Miniredis) string {
+			jobId := claimJob(t, s, "prover-a")
+			mr.FastForward(leaseTTL + time.Second)
+			return jobId
+		}, "prover-b", jobStateQueued},
+		{"killed instance, lease of a gone instance", func(t *testing.T, s *State, mr *miniredis.Miniredis) string {
+			return claimJob(t, s, "prover-a")
+		}, "prover-b", jobStateQueued},
+		{"restarted under the same ID before the lease expired", func(t *testing.T, s *State, mr *miniredis.Miniredis) string {
+			return claimJob(t, s, "prover-a")
+		}, "prover-a", jobStateQueued},
+		{"live instance", func(t *testing.T, s *State, mr *miniredis.Miniredis) string {
+			jobId := claimJob(t, s, "prover-c")
+			live := restart(s, "prover-c")
+			if err := live.heartbeat(context.Background(), live.fleetInstance("c", time.Now())); err != nil {
+				t.Fatal(err)
+			}
+			return jobId
+		}, "prover-b", jobStateProving},
+		{"legacy lease", func(t *testing.T, s *State, mr *miniredis.Miniredis) string {
+			return claimJob(t, s, legacyLeaseHolder)
+		}, "prover-b", jobStateProving},
+		{"attempts used up", func(t *testing.T, s *State, mr *miniredis.Miniredis) string {
+			jobId := claimJob(t, s, "prover-a")
+			if err := s.RedisClient.HSet(context.Background(), getRedisMetaKey(jobId), metaAttempts, DefaultMaxJobAttempts).Err(); err != nil {
+				t.Fatal(err)
+			}
+			mr.FastForward(leaseTTL + time.Second)
+			return jobId
+		}, "prover-b", jobStateFailed},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// Each recovery waits recoveryGrace.
+			t.Parallel()
+			ctx := context.Background()
+			s, mr := newProvingTestState(t)
+			s.InstanceId = "prover-a"
+			jobId := tt.interrupt(t, s, mr)
+
+			restarted := restart(s, tt.restartedAs)
+			requeued, failed, err := restarted.RecoverInterrupted(ctx, DefaultMaxJobAttempts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantRequeued, wantFailed := 0, 0
+			switch tt.wantState {
+			case jobStateQueued:
+				wantRequeued = 1
+			case jobStateFailed:
+				wantFailed = 1
+			}
+			if requeued != wantRequeued || failed != wantFailed {
+				t.Fatalf("RecoverInterrupted() requeued %d and failed %d, want %d and %d", requeued, failed, wantRequeued, wantFailed)
+			}
+
+			meta, err := restarted.getJobMetadata(ctx, jobId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta[metaState] != tt.wantState {
+				t.Fatalf("job state %q, want %q", meta[metaState], tt.wantState)
+			}
+			processing, err := s.RedisClient.LRange(ctx, redisProcessingKey, 0, -1).Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantState == jobStateProving {
+				if len(processing) != 1 || processing[0] != jobId {
+					t.Fatalf("processing list %v, want the job left alone", processing)
+				}
+				return
+			}
+			if len(processing) != 0 {
+				t.Fatalf("processing list %v, want it empty", processing)
+			}
+
+			switch tt.wantState {
+			case jobStateQueued:
+				if meta[metaAttempts] != "2" {
+					t.Fatalf("attempts %q, want 2", meta[metaAttempts])
+				}
+				// The worker loop of the restarted instance picks it up again.
+				claimed, err := restarted.dequeueJob(ctx)
+				if err != nil || claimed != jobId {
+					t.Fatalf("dequeueJob() after recovery = %s, %v, want %s", claimed, err, jobId)
+				}
+			case jobStateFailed:
+				response, err := restarted.getProofResponse(ctx, jobId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := "interrupted by a server restart after 3 attempts"
+				if response.Success || response.ErrorMessage == nil || !strings.Contains(*response.ErrorMessage, want) {
+					t.Fatalf("get-proof %+v, want a failure containing %q", response, want)
+				}
+				if n, err := s.RedisClient.LLen(ctx, redisDeadLetterKey).Result(); err != nil || n != 1 {
+					t.Fatalf("dead-letter queue has %d jobs, %v, want 1", n, err)
+				}
+				if jobs := queuedJobs(t, restarted); len(jobs) != 0 {
+					t.Fatalf("queued %v, want nothing", jobs)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverInterruptedWithoutOrphans(t *testing.T) {
+	s, _ := newProvingTestState(t)
+	startProofs(t, s, []ProofRequest{testProofRequest(t)})
+	started := time.Now()
+	requeued, failed, err := s.RecoverInterrupted(context.Background(), DefaultMaxJobAttempts)
+	if err != nil || requeued != 0 || failed != 0 {
+		t.Fatalf("RecoverInterrupted() = %d, %d, %v, want nothing recovered", requeued, failed, err)
+	}
+	if elapsed := time.Since(started); elapsed >= recoveryGrace {
+		t.Fatalf("RecoverInterrupted() waited %v with nothing to recover", elapsed)
+	}
+	if jobs := queuedJobs(t, s); len(jobs) != 1 {
+		t.Fatalf("queued %v, want the job left queued", jobs)
+	}
+}
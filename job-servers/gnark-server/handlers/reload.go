@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+
+	"gnark-server/circuitData"
+	"gnark-server/objectstore"
+)
+
+// ReloadCircuitRequest is the optional body of /reload-circuit.
+type ReloadCircuitRequest struct {
+	// DataDir is a data directory to load the circuits from instead of the
+	// configured one, laid out like data/. The CIRCUIT_*_PATH overrides do
+	// not apply to it.
+	DataDir string `json:"dataDir,omitempty"`
+	// Circuit reloads only the circuit of this name, or adds it if it is not
+	// loaded yet. The other circuits are kept as they are.
+	Circuit string `json:"circuit,omitempty"`
+}
+
+// ReloadCircuitResponse is the response of /reload-circuit.
+type ReloadCircuitResponse struct {
+	// DataDir is the directory the circuits were loaded from, when it is not
+	// the configured one.
+	DataDir string `json:"dataDir,omitempty"`
+	// Circuits are the circuits that were loaded, all of them unless the
+	// request named one.
+	Circuits []ReloadedCircuit `json:"circuits"`
+}
+
+// ReloadedCircuit describes a circuit loaded by /reload-circuit.
+type ReloadedCircuit struct {
+	Name      string `json:"name"`
+	ReleaseId string `json:"releaseId"`
+	// PreviousReleaseId is the release that was loaded before, or empty for
+	// a circuit that was not loaded.
+	PreviousReleaseId string `json:"previousReleaseId,omitempty"`
+	Constraints       int    `json:"constraints"`
+	ProvingKeySize    int64  `json:"provingKeySize"`
+	VerifyingKeySize  int64  `json:"verifyingKeySize"`
+	// VerifyingKeyHash is the hex SHA-256 of the verifying key file.
+	VerifyingKeyHash string `json:"verifyingKeyHash"`
+}
+
+const (
+	codeReloadInProgress    = "reload_in_progress"
+	codeCircuitReloadFailed = "circuit_reload_failed"
+)
+
+// ReloadCircuitHandler serves PUT and POST /reload-circuit. It loads the
+// circuits again from the data directory, or from the dataDir of the request
+// body, validates them and swaps them in for the ones in use. With a circuit
+// in the body, only that circuit is loaded and swapped in. Jobs already
+// proving finish with the keys they started with, and the old keys are freed
+// once they are done, so memory holds both sets of keys for a while. If
+// anything fails to load or validate, the loaded circuits are kept and 500
+// is returned.
+//
+// In verify-only mode a proving key that fails to load is tolerated, as at
+// startup, but the server stays verify-only until it is restarted.
+func (s *State) ReloadCircuitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPut+", "+http.MethodPost)
+		return
+	}
+	var req ReloadCircuitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, codeMalformedJSON, err.Error())
+		return
+	}
+	if err := checkReloadDir(req.DataDir); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if !s.reloading.TryLock() {
+		writeError(w, http.StatusConflict, codeReloadInProgress, "circuits are already being reloaded")
+		return
+	}
+	defer s.reloading.Unlock()
+
+	loaded, err := s.loadForReload(req)
+	if errors.Is(err, circuitData.ErrUnknownCircuit) {
+		writeError(w, http.StatusBadRequest, codeUnknownCircuit, err.Error())
+		return
+	}
+	validate := (*circuitData.CircuitData).Validate
+	if s.VerifyOnly && errors.Is(err, circuitData.ErrProvingKey) {
+		log.Println("WARNING: Circuit reload:", err)
+		validate = (*circuitData.CircuitData).ValidateVerifyingKey
+		err = nil
+	}
+	if err == nil {
+		err = validateLoadedCircuits(loaded, validate)
+	}
+	if err != nil {
+		log.Println("Circuit reload failed, keeping the loaded circuits:", err)
+		writeError(w, http.StatusInternalServerError, codeCircuitReloadFailed, err.Error())
+		return
+	}
+
+	s.circuitsMu.Lock()
+	previous := s.Circuits
+	if req.Circuit != "" {
+		s.Circuits = previous.With(loaded[req.Circuit])
+	} else {
+		s.Circuits = loaded
+	}
+	s.circuitsMu.Unlock()
+
+	resp := ReloadCircuitResponse{DataDir: req.DataDir, Circuits: make([]ReloadedCircuit, 0, len(loaded))}
+	for name, data := range loaded {
+		resp.Circuits = append(resp.Circuits, ReloadedCircuit{
+			Name:              name,
+			ReleaseId:         data.ReleaseId,
+			PreviousReleaseId: previous[name].ReleaseId,
+			Constraints:       data.Version.Constraints,
+			ProvingKeySize:    data.Version.ProvingKeySize,
+			VerifyingKeySize:  data.Version.VerifyingKeySize,
+			VerifyingKeyHash:  data.Version.VerifyingKeyHash,
+		})
+		log.Printf("Reloaded circuit %s, release %s (was %q), verifying key %s\n", name, data.ReleaseId, previous[name].ReleaseId, data.Version.VerifyingKeyHash)
+	}
+	sort.Slice(resp.Circuits, func(i, j int) bool { return resp.Circuits[i].Name < resp.Circuits[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// loadForReload loads the circuits of a reload request: the one it names, or
+// all of them.
+func (s *State) loadForReload(req ReloadCircuitRequest) (circuitData.Registry, error) {
+	if req.Circuit == "" {
+		return s.LoadCircuits(req.DataDir)
+	}
+	data, err := s.LoadCircuit(req.DataDir, req.Circuit)
+	if errors.Is(err, circuitData.ErrUnknownCircuit) {
+		return nil, err
+	}
+	return circuitData.Registry{req.Circuit: data}, err
+}
+
+// checkReloadDir checks the dataDir of a reload request, which is empty or a
+// local directory.
+func checkReloadDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if objectstore.IsURL(dir) {
+		return fmt.Errorf("dataDir must be a local directory, not %s", dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("dataDir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("dataDir %s is not a directory", dir)
+	}
+	return nil
+}
+
+// validateLoadedCircuits is validateCircuits for circuits that are not in
+// use yet.
+func validateLoadedCircuits(circuits circuitData.Registry, validate func(*circuitData.CircuitData) error) error {
+	s := &State{Circuits: circuits}
+	return s.validateCircuits(validate)
+}
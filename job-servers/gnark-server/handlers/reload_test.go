@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gnark-server/circuitData"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// squareCircuit proves knowledge of the square root of Y. It stands in for
+// the verifier circuit, whose keys take long to set up.
+type squareCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (c *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.X, c.X), c.Y)
+	return nil
+}
+
+// writeReleaseDir sets up squareCircuit with Groth16 in a temporary data
+// directory, as setup would. Every call makes new keys, so a new release.
+func writeReleaseDir(t *testing.T) string {
+	t.Helper()
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	common, err := os.ReadFile(filepath.Join("..", circuitData.DefaultDir, circuitData.CommonCircuitDataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, circuitData.CommonCircuitDataFile), common, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := circuitData.BackendFiles(circuitData.BackendGroth16)
+	for name, artifact := range map[string]io.WriterTo{files.Circuit: ccs, files.ProvingKey: pk, files.VerifyingKey: vk} {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := artifact.WriteTo(f); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := circuitData.WriteCacheKey(circuitData.Paths{Dir: dir}, circuitData.BackendGroth16); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReloadSwitchesReleaseOfJobs(t *testing.T) {
+	ctx := context.Background()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	load := func(dir string) (circuitData.Registry, error) {
+		data, err := circuitData.InitCircuitDataFromDir(dir, circuitData.BackendGroth16, false)
+		if err != nil {
+			return nil, err
+		}
+		return circuitData.Registry{circuitData.DefaultCircuit: data}, nil
+	}
+	before, after := writeReleaseDir(t), writeReleaseDir(t)
+	s, _ := newTestState(t)
+	circuits, err := load(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Circuits = circuits
+	s.LoadCircuits = load
+	releaseBefore := circuits[circuitData.DefaultCircuit].ReleaseId
+
+	// runJob queues a job, claims it and lets it run, which fails since the
+	// circuit is not the verifier circuit, and returns its response.
+	runJob := func() (string, ProofResponse) {
+		t.Helper()
+		jobId := *startProofs(t, s, []ProofRequest{testProofRequest(t)})[0].JobId
+		claimed, err := s.dequeueJob(ctx)
+		if err != nil || claimed != jobId {
+			t.Fatalf("claimed %q, %v, want %s", claimed, err, jobId)
+		}
+		if err := s.processJob(ctx, jobId); err != nil {
+			t.Fatal(err)
+		}
+		response, err := s.getProofResponse(ctx, jobId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jobId, response
+	}
+	jobBefore, responseBefore := runJob()
+
+	body, err := json.Marshal(ReloadCircuitRequest{DataDir: after})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.ReloadCircuitHandler(w, httptest.NewRequest(http.MethodPost, "/reload-circuit", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("reload: %d %s, want 200", w.Code, w.Body)
+	}
+	var reloaded ReloadCircuitResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &reloaded); err != nil {
+		t.Fatal(err)
+	}
+	releaseAfter := reloaded.Circuits[0].ReleaseId
+	if releaseAfter == releaseBefore || reloaded.Circuits[0].PreviousReleaseId != releaseBefore {
+		t.Fatalf("reload response %+v, want a new release replacing %s", reloaded, releaseBefore)
+	}
+
+	jobAfter, responseAfter := runJob()
+	for _, tt := range []struct {
+		jobId    string
+		response ProofResponse
+		release  string
+	}{
+		{jobBefore, responseBefore, releaseBefore},
+		{jobAfter, responseAfter, releaseAfter},
+	} {
+		if tt.response.CircuitRelease != tt.release {
+			t.Errorf("job %s has release %q, want %s", tt.jobId, tt.response.CircuitRelease, tt.release)
+		}
+		meta, err := s.getJobMetadata(ctx, tt.jobId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta[metaCircuitRelease] != tt.release {
+			t.Errorf("metadata of job %s has release %q, want %s", tt.jobId, meta[metaCircuitRelease], tt.release)
+		}
+		// The lines logged about the job say which release served it.
+		logged := 0
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if !strings.Contains(line, tt.jobId) || !strings.Contains(line, "release=") {
+				continue
+			}
+			if !strings.Contains(line, "release="+tt.release) {
+				t.Errorf("log line %q of job %s names another release than %s", line, tt.jobId, tt.release)
+			}
+			logged++
+		}
+		if logged == 0 {
+			t.Errorf("no log line of job %s names its release %s", tt.jobId, tt.release)
+		}
+	}
+}
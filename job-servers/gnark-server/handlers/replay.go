@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gnark-server/circuitData"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	metaProveDurationMs = "proveDurationMs"
+	metaReplayOf        = "replayOf"
+	metaReplayCompare   = "replayCompare"
+)
+
+// ReplayReport compares a replayed job with the job it replays. Proofs are
+// randomized, so the comparison is semantic: both proofs must verify and
+// commit to the same public inputs.
+type ReplayReport struct {
+	OriginalJobId     string   `json:"originalJobId"`
+	Match             bool     `json:"match"`
+	OriginalVerified  bool     `json:"originalVerified"`
+	ReplayVerified    bool     `json:"replayVerified"`
+	PublicInputsMatch bool     `json:"publicInputsMatch"`
+	OriginalDuration  *int64   `json:"originalDurationMs"`
+	ReplayDuration    *int64   `json:"replayDurationMs"`
+	DurationDelta     *int64   `json:"durationDeltaMs"`
+	Differences       []string `json:"differences"`
+}
+
+var (
+	errInputNotStored = &RequestError{Code: "input_not_stored", Message: "job input is not stored, enable STORE_INPUTS to replay jobs"}
+	errJobNotFinished = &RequestError{Code: "job_not_finished", Message: "job has not finished yet"}
+)
+
+// recordProveDuration stores how long proving took so that replays can be
+// compared against it.
+func (s *State) recordProveDuration(ctx context.Context, jobId string, meta map[string]string, duration time.Duration) {
+	ms := strconv.FormatInt(duration.Milliseconds(), 10)
+	if meta != nil {
+		meta[metaProveDurationMs] = ms
+	}
+	if err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId), metaProveDurationMs, ms).Err(); err != nil {
+		log.Printf("Failed to record duration of job %s: %v\n", jobId, err)
+	}
+}
+
+// replayOptions are the query parameters of a replay.
+type replayOptions struct {
+	// Compare adds a ReplayReport to the replay's response.
+	Compare bool
+	// Notify sends the replay's response to the original callback URL,
+	// unless an identical notification was delivered within the dedupe
+	// window.
+	Notify bool
+	// Force notifies the original callback URL even then.
+	Force bool
+}
+
+// replayJob queues a new job proving the stored input of originalJobId. The
+// replay only inherits the original callback URL with opts.Notify or
+// opts.Force.
+func (s *State) replayJob(ctx context.Context, originalJobId string, opts replayOptions) (string, error) {
+	if s.isStopping() {
+		return "", errShuttingDown
+	}
+	if s.VerifyOnly {
+		return "", errProvingDisabled
+	}
+	if _, err := uuid.Parse(originalJobId); err != nil {
+		return "", errInvalidJobId
+	}
+	if err := s.checkProvable(ctx, originalJobId); err != nil {
+		return "", err
+	}
+	original, err := s.getProofResponse(ctx, originalJobId)
+	if err == redis.Nil {
+		return "", errJobNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if opts.Compare && original.Proof == nil && original.ErrorMessage == nil {
+		return "", errJobNotFinished
+	}
+	if !s.StoreInputs {
+		return "", errInputNotStored
+	}
+	input, err := s.loadJobInput(ctx, originalJobId)
+	if err == redis.Nil {
+		return "", errInputNotStored
+	} else if err != nil {
+		return "", err
+	}
+	if err := checkCircuitAllowed(ctx, input); err != nil {
+		return "", err
+	}
+	callbackMeta := map[string]interface{}{}
+	if opts.Notify || opts.Force {
+		// The target may have been blocked since the original submission.
+		var data circuitData.CircuitData
+		data, err = s.circuit(input.Circuit)
+		if err == nil {
+			callbackMeta, err = s.validateCallback(ctx, input, data)
+		}
+		if err != nil {
+			return "", &RequestError{Code: requestErrorCode(err), Message: err.Error()}
+		}
+		if opts.Force && input.CallbackUrl != "" {
+			callbackMeta[metaCallbackForce] = "true"
+		}
+	} else {
+		input.CallbackUrl = ""
+	}
+
+	_jobId, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	jobId := _jobId.String()
+	ctx, span := s.tracer().Start(ctx, "ReplayProof", trace.WithAttributes(jobIdAttribute(jobId)))
+	defer span.End()
+
+	meta := map[string]interface{}{
+		metaReplayOf:      originalJobId,
+		metaReplayCompare: strconv.FormatBool(opts.Compare),
+	}
+	for k, v := range callbackMeta {
+		meta[k] = v
+	}
+	if input.Circuit != "" {
+		meta[metaCircuit] = input.Circuit
+	}
+	for k, v := range queuedFields(time.Now()) {
+		meta[k] = v
+	}
+	for k, v := range traceMetadata(ctx) {
+		meta[k] = v
+	}
+	if err := s.checkQueueCapacity(ctx, 1); err != nil {
+		return "", err
+	}
+	if err := s.storeJob(jobId, input, meta); err != nil {
+		return "", err
+	}
+	pipe := s.RedisClient.TxPipeline()
+	err = queueProofResponse(ctx, pipe, jobId, ProofResponse{Success: true})
+	if err == nil {
+		queueJobMetadata(ctx, pipe, jobId, meta)
+		s.queueTransition(ctx, pipe, jobId, meta, nil)
+		err = enqueueJob(ctx, pipe, jobId, input)
+	}
+	if err == nil {
+		_, err = pipe.Exec(ctx)
+	}
+	if err != nil {
+		s.unstoreJob(jobId)
+		return "", err
+	}
+	s.Metrics.started(1)
+	log.Println("ReplayProof", jobId, "of", originalJobId)
+	return jobId, nil
+}
+
+// replayReport builds the report of a finished replay job, or returns nil if
+// the job is not a replay with comparison enabled.
+func (s *State) replayReport(ctx context.Context, response ProofResponse, meta map[string]string) *ReplayReport {
+	originalJobId := meta[metaReplayOf]
+	if originalJobId == "" || meta[metaReplayCompare] != "true" {
+		return nil
+	}
+	original, err := s.getProofResponse(ctx, originalJobId)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed to load original job: %v", err)
+		original = ProofResponse{ErrorMessage: &errMsg}
+	}
+	originalMeta, err := s.getJobMetadata(ctx, originalJobId)
+	if err != nil {
+		log.Printf("Failed to load metadata for job %s: %v\n", originalJobId, err)
+	}
+	return compareReplay(originalJobId, original, response,
+		parseDurationMs(originalMeta[metaProveDurationMs]), parseDurationMs(meta[metaProveDurationMs]))
+}
+
+func parseDurationMs(value string) *int64 {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &ms
+}
+
+func compareReplay(originalJobId string, original, replay ProofResponse, originalDuration, replayDuration *int64) *ReplayReport {
+	report := &ReplayReport{
+		OriginalJobId:    originalJobId,
+		OriginalVerified: original.Success && original.Proof != nil,
+		ReplayVerified:   replay.Success && replay.Proof != nil,
+		OriginalDuration: originalDuration,
+		ReplayDuration:   replayDuration,
+		Differences:      []string{},
+	}
+	if originalDuration != nil && replayDuration != nil {
+		delta := *replayDuration - *originalDuration
+		report.DurationDelta = &delta
+	}
+	if report.OriginalVerified != report.ReplayVerified {
+		report.Differences = append(report.Differences, fmt.Sprintf("verification: original %s, replay %s",
+			outcome(original), outcome(replay)))
+	}
+	if report.OriginalVerified && report.ReplayVerified {
+		a, b := original.Proof.PublicInputs, replay.Proof.PublicInputs
+		if len(a) != len(b) {
+			report.Differences = append(report.Differences,
+				fmt.Sprintf("publicInputs: original has %d, replay has %d", len(a), len(b)))
+		}
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if a[i] != b[i] {
+				report.Differences = append(report.Differences,
+					fmt.Sprintf("publicInputs[%d]: original %s, replay %s", i, a[i], b[i]))
+			}
+		}
+	}
+	report.PublicInputsMatch = report.OriginalVerified && report.ReplayVerified && len(report.Differences) == 0
+	report.Match = len(report.Differences) == 0
+	return report
+}
+
+func outcome(response ProofResponse) string {
+	if response.Success && response.Proof != nil {
+		return "verified"
+	}
+	if response.ErrorMessage != nil {
+		return "failed (" + *response.ErrorMessage + ")"
+	}
+	return "failed"
+}
+
+// ReplayJobResponse is the response of POST /jobs/{id}/replay.
+type ReplayJobResponse struct {
+	// JobId is the job that proves the original again.
+	JobId string `json:"jobId"`
+}
+
+// Jobs serves POST /jobs/{id}/replay. With compare=true the replay job's
+// response carries a ReplayReport once it finishes. With notify=true it is
+// sent to the original callback URL, and force=true bypasses the callback
+// dedupe window as well.
+func (s *State) Jobs(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	if len(parts) != 2 || parts[1] != "replay" {
+		writeError(w, http.StatusNotFound, codeNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	query := r.URL.Query()
+	jobId, err := s.replayJob(r.Context(), parts[0], replayOptions{
+		Compare: query.Get("compare") == "true",
+		Notify:  query.Get("notify") == "true",
+		Force:   query.Get("force") == "true",
+	})
+	switch {
+	case err == errInvalidJobId:
+		writeError(w, http.StatusBadRequest, codeInvalidJobId, err.Error())
+	case err == errJobNotFound:
+		writeError(w, http.StatusNotFound, codeJobNotFound, err.Error())
+	case err == errInputNotStored || err == errJobNotFinished || err == errJobNotProvable:
+		reqErr := err.(*RequestError)
+		writeError(w, http.StatusConflict, reqErr.Code, reqErr.Message)
+	case err != nil:
+		writeRequestError(w, err)
+	default:
+		json.NewEncoder(w).Encode(ReplayJobResponse{JobId: jobId})
+	}
+}
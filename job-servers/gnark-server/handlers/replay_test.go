@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplayChecksAllowedCircuits(t *testing.T) {
+	s, _ := newTestState(t)
+	s.StoreInputs = true
+	jobId := addFinishedJob(t, s, jobStateDone, testProofRequest(t))
+
+	auth, restricted := jwtAuth(t, []string{"1234567890"})
+	w := serve(auth.Require(s.Jobs), http.MethodPost, "/jobs/"+jobId+"/replay", restricted)
+	if w.Code != http.StatusForbidden || errorCode(t, w) != codeCircuitNotAllowed {
+		t.Fatalf("restricted token: %d %s, want 403 %s", w.Code, w.Body, codeCircuitNotAllowed)
+	}
+	if queued := queuedJobs(t, s); len(queued) != 0 {
+		t.Fatalf("refused replay queued %v", queued)
+	}
+
+	_, allowed := jwtAuth(t, []string{"1234567890", testCircuitDigest})
+	w = serve(auth.Require(s.Jobs), http.MethodPost, "/jobs/"+jobId+"/replay", allowed)
+	if w.Code != http.StatusOK {
+		t.Fatalf("allowed token: %d %s, want 200", w.Code, w.Body)
+	}
+	if queued := queuedJobs(t, s); len(queued) != 1 {
+		t.Fatalf("queued %v, want the replay", queued)
+	}
+}
+
+func TestReplayCompareReport(t *testing.T) {
+	original := newProveResult([]string{"1", "2"}, "aa")
+	proverErr := "prover failed"
+	tests := []struct {
+		name        string
+		replay      ProofResponse
+		wantMatch   bool
+		differences []string
+	}{
+		{"matching", ProofResponse{Success: true, Proof: &ProveResult{PublicInputs: []string{"1", "2"}, Proof: "bb"}}, true, []string{}},
+		{"other public inputs", ProofResponse{Success: true, Proof: &ProveResult{PublicInputs: []string{"1", "3"}, Proof: "bb"}}, false,
+			[]string{"publicInputs[1]: original 2, replay 3"}},
+		{"replay failed", ProofResponse{Success: false, ErrorMessage: &proverErr}, false,
+			[]string{"verification: original verified, replay failed (prover failed)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, _ := newProvingTestState(t)
+			s.StoreInputs = true
+			originalJobId := addFinishedJob(t, s, jobStateDone, testProofRequest(t))
+			if err := s.setProofResponse(ctx, originalJobId, ProofResponse{Success: true, Proof: &original}, time.Hour); err != nil {
+				t.Fatal(err)
+			}
+			s.recordProveDuration(ctx, originalJobId, nil, time.Second)
+
+			w := serve(s.Jobs, http.MethodPost, "/jobs/"+originalJobId+"/replay?compare=true", "")
+			if w.Code != http.StatusOK {
+				t.Fatalf("replay: %d %s, want 200", w.Code, w.Body)
+			}
+			var replay ReplayJobResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &replay); err != nil {
+				t.Fatal(err)
+			}
+
+			// Finish the replay as a worker would.
+			meta, err := s.getJobMetadata(ctx, replay.JobId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.recordProveDuration(ctx, replay.JobId, meta, 1500*time.Millisecond)
+			s.finishJob(ctx, replay.JobId, tt.replay, meta)
+
+			response, err := s.getProofResponse(ctx, replay.JobId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := response.ReplayReport
+			if report == nil {
+				t.Fatal("replay has no report")
+			}
+			if report.OriginalJobId != originalJobId || report.Match != tt.wantMatch || !report.OriginalVerified {
+				t.Fatalf("report %+v, want match %v against the verified %s", report, tt.wantMatch, originalJobId)
+			}
+			if report.ReplayVerified != tt.replay.Success || report.PublicInputsMatch != tt.wantMatch {
+				t.Fatalf("report %+v, want the replay verified %v", report, tt.replay.Success)
+			}
+			if report.DurationDelta == nil || *report.DurationDelta != 500 {
+				t.Fatalf("report duration delta %v, want 500ms", report.DurationDelta)
+			}
+			if !reflect.DeepEqual(report.Differences, tt.differences) {
+				t.Fatalf("report differences %q, want %q", report.Differences, tt.differences)
+			}
+		})
+	}
+}
+
+func TestReplayCompareRefusesUnfinishedJob(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newProvingTestState(t)
+	s.StoreInputs = true
+	jobId := addFinishedJob(t, s, jobStateDone, testProofRequest(t))
+	if err := s.setProofResponse(ctx, jobId, ProofResponse{Success: true}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(s.Jobs, http.MethodPost, "/jobs/"+jobId+"/replay?compare=true", "")
+	if w.Code != http.StatusConflict || errorCode(t, w) != errJobNotFinished.Code {
+		t.Fatalf("replay of a pending job: %d %s, want 409 %s", w.Code, w.Body, errJobNotFinished.Code)
+	}
+}
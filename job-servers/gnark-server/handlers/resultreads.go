@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"time"
+)
+
+// resultReadTimeout bounds a coalesced result read, which no longer ends
+// when the request that started it does.
+const resultReadTimeout = 30 * time.Second
+
+// coalescedProofResponse is getProofResponse for the get-proof path.
+// Concurrent reads of the same job share one Redis read, so that a popular
+// proof is fetched once when its readers arrive together. The shared read is
+// detached from the caller that started it: a waiter whose context is done
+// returns early without cancelling the read for the others.
+func (s *State) coalescedProofResponse(ctx context.Context, jobId string) (ProofResponse, error) {
+	read := false
+	ch := s.resultReads.DoChan(jobId, func() (interface{}, error) {
+		read = true
+		readCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resultReadTimeout)
+		defer cancel()
+		return s.getProofResponse(readCtx, jobId)
+	})
+	select {
+	case res := <-ch:
+		if !read {
+			s.Metrics.coalescedRead()
+		}
+		return res.Val.(ProofResponse), res.Err
+	case <-ctx.Done():
+		return ProofResponse{}, ctx.Err()
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// slowResultHook holds reads of the result of a job until release is
+// closed, and counts them.
+type slowResultHook struct {
+	key     string
+	reads   atomic.Int64
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *slowResultHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "get" && len(cmd.Args()) > 1 && cmd.Args()[1] == h.key {
+		if h.reads.Add(1) == 1 {
+			close(h.started)
+		}
+		<-h.release
+	}
+	return ctx, nil
+}
+
+func (h *slowResultHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *slowResultHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *slowResultHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// newSlowResultState returns a State with a finished job whose result reads
+// are held by the returned hook.
+func newSlowResultState(t *testing.T) (*State, string, *slowResultHook) {
+	t.Helper()
+	s, _ := newTestState(t)
+	s.Metrics = NewMetrics(s.RedisClient)
+	jobId := uuid.NewString()
+	result := newProveResult([]string{"1", "2"}, "aa")
+	if err := s.setProofResponse(context.Background(), jobId, ProofResponse{Success: true, Proof: &result}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	hook := &slowResultHook{key: getRedisKey(jobId), started: make(chan struct{}), release: make(chan struct{})}
+	s.RedisClient.AddHook(hook)
+	return s, jobId, hook
+}
+
+func TestCoalescedProofResponseSharesOneRead(t *testing.T) {
+	s, jobId, hook := newSlowResultState(t)
+	const readers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := s.coalescedProofResponse(context.Background(), jobId)
+			if err == nil && (response.Proof == nil || response.Proof.Proof != "aa") {
+				t.Errorf("coalescedProofResponse() = %+v, want the stored proof", response)
+			}
+			errs <- err
+		}()
+	}
+	<-hook.started
+	// Let the other readers join the read in flight.
+	time.Sleep(100 * time.Millisecond)
+	close(hook.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reads := hook.reads.Load(); reads != 1 {
+		t.Fatalf("%d readers made %d Redis reads, want 1", readers, reads)
+	}
+	if coalesced := testutil.ToFloat64(s.Metrics.coalescedReads); coalesced != readers-1 {
+		t.Fatalf("coalesced reads = %v, want %d", coalesced, readers-1)
+	}
+}
+
+func TestCoalescedProofResponseOutlivesCancelledReader(t *testing.T) {
+	s, jobId, hook := newSlowResultState(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := s.coalescedProofResponse(ctx, jobId)
+		first <- err
+	}()
+	<-hook.started
+	second := make(chan ProofResponse, 1)
+	go func() {
+		response, err := s.coalescedProofResponse(context.Background(), jobId)
+		if err != nil {
+			t.Error(err)
+		}
+		second <- response
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The reader that started the read gives up; the read goes on.
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("cancelled reader got %v, want context.Canceled", err)
+	}
+	close(hook.release)
+	if response := <-second; response.Proof == nil || response.Proof.Proof != "aa" {
+		t.Fatalf("remaining reader got %+v, want the stored proof", response)
+	}
+	if reads := hook.reads.Load(); reads != 1 {
+		t.Fatalf("made %d Redis reads, want 1", reads)
+	}
+}
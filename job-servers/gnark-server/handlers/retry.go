@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	metaAttempts = "attempts"
+
+	codeJobNotFailed = "job_not_failed"
+)
+
+var errRetryInputGone = &RequestError{Code: "input_not_stored", Message: "job input is no longer stored, resubmit the proof"}
+
+// retryJobScript moves a failed job back to the back of the queue of its
+// priority. It checks the state and the input and requeues in one step, so
+// that concurrent retries of the same job queue it once, and logs the
+// requeue in the event log. It returns the new attempt count, -1 if the job
+// is not failed and -2 if its input is gone.
+//
+// KEYS: metadata, result, input, expired marker, high, normal and low queues,
+// event log.
+// ARGV: jobId, pending response, expiration in seconds, now, now in
+// milliseconds, event log length.
+var retryJobScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'state') ~= 'failed' then
+  return -1
+end
+if redis.call('EXISTS', KEYS[3]) == 0 then
+  return -2
+end
+local attempts = tonumber(redis.call('HGET', KEYS[1], 'attempts') or '1') + 1
+redis.call('HDEL', KEYS[1], 'provingAt', 'doneAt', 'failedAt', 'expiresAt')
+redis.call('HSET', KEYS[1], 'attempts', attempts, 'state', 'queued', 'queuedAt', ARGV[4],
+  'stage', 'queued', 'stageUpdatedAt', ARGV[4])
+redis.call('EXPIRE', KEYS[1], ARGV[3])
+redis.call('SET', KEYS[2], ARGV[2], 'EX', ARGV[3])
+redis.call('EXPIRE', KEYS[3], ARGV[3])
+redis.call('DEL', KEYS[4])
+local queue = KEYS[6]
+local priority = redis.call('HGET', KEYS[1], 'priority')
+if priority == 'high' then
+  queue = KEYS[5]
+elseif priority == 'low' then
+  queue = KEYS[7]
+end
+redis.call('ZADD', queue, ARGV[5], ARGV[1])
+redis.call('XADD', KEYS[8], 'MAXLEN', '~', ARGV[6], '*',
+  'jobId', ARGV[1], 'state', 'queued', 'at', ARGV[4], 'attempts', attempts)
+return attempts
+`)
+
+// RetryProofResponse is the response of retry-proof.
+type RetryProofResponse struct {
+	JobId string `json:"jobId"`
+	// Attempts counts the runs of the job, including the one just queued.
+	Attempts int64 `json:"attempts"`
+}
+
+// retryInputTTL returns how long the input of a failed job is kept for
+// retries.
+func (s *State) retryInputTTL() time.Duration {
+	if s.RetryInputTTL > 0 {
+		return s.RetryInputTTL
+	}
+	return expiration
+}
+
+// retainJobInput decides what happens to the stored input of a finished job.
+// Inputs are kept with the job when STORE_INPUTS is set. Otherwise the input
+// of a failed job is kept for RetryInputTTL so that it can be retried, and
+// the input of a successful job is dropped.
+func (s *State) retainJobInput(ctx context.Context, jobId string, success bool) {
+	if s.StoreInputs {
+		return
+	}
+	var err error
+	if success {
+		err = s.RedisClient.Del(ctx, getRedisInputKey(jobId)).Err()
+	} else {
+		err = s.RedisClient.Expire(ctx, getRedisInputKey(jobId), s.retryInputTTL()).Err()
+	}
+	if err != nil {
+		log.Printf("Failed to update stored input of job %s: %v\n", jobId, err)
+	}
+}
+
+// retryJob queues a failed job again under the same job ID, proving its
+// stored input. It returns the new attempt count.
+func (s *State) retryJob(ctx context.Context, jobId string) (int64, error) {
+	if s.isStopping() {
+		return 0, errShuttingDown
+	}
+	if s.VerifyOnly {
+		return 0, errProvingDisabled
+	}
+	if _, err := uuid.Parse(jobId); err != nil {
+		return 0, errInvalidJobId
+	}
+	ctx, span := s.tracer().Start(ctx, "RetryProof", trace.WithAttributes(jobIdAttribute(jobId)))
+	defer span.End()
+
+	meta, err := s.getJobMetadata(ctx, jobId)
+	if err != nil {
+		return 0, err
+	}
+	if len(meta) == 0 {
+		if expired, err := s.isExpired(ctx, jobId); err == nil && expired {
+			return 0, errJobExpired
+		}
+		return 0, errJobNotFound
+	}
+	if state := meta[metaState]; state != jobStateFailed {
+		return 0, jobNotFailedError(state)
+	}
+	if meta[metaNonProvable] == "true" {
+		return 0, errJobNotProvable
+	}
+	input, err := s.loadJobInput(ctx, jobId)
+	if err == redis.Nil {
+		return 0, errRetryInputGone
+	} else if err != nil {
+		return 0, err
+	}
+	if err := checkCircuitAllowed(ctx, input); err != nil {
+		return 0, err
+	}
+	if err := s.checkQueueCapacity(ctx, 1); err != nil {
+		return 0, err
+	}
+	pending, err := json.Marshal(ProofResponse{Success: true})
+	if err != nil {
+		return 0, err
+	}
+	keys := []string{
+		getRedisMetaKey(jobId),
+		getRedisKey(jobId),
+		getRedisInputKey(jobId),
+		getRedisExpiredKey(jobId),
+	}
+	keys = append(keys, redisQueueKeys()...)
+	keys = append(keys, redisEventLogKey)
+	now := time.Now()
+	attempts, err := retryJobScript.Run(ctx, s.RedisClient, keys, jobId, pending,
+		int64(expiration.Seconds()), now.UTC().Format(time.RFC3339Nano), now.UnixMilli(), s.eventLogMaxLength()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	switch attempts {
+	case -1:
+		// Another request retried the job since the metadata was read.
+		return 0, jobNotFailedError(jobStateQueued)
+	case -2:
+		return 0, errRetryInputGone
+	}
+	if digest := meta[metaInputDigest]; digest != "" {
+		if _, err := s.claimInflight(ctx, digest, jobId); err != nil {
+			log.Printf("Failed to mark retried job %s as in flight: %v\n", jobId, err)
+		}
+	}
+	s.Metrics.started(1)
+	s.storeRetriedJob(ctx, jobId, input)
+	log.Println("RetryProof", jobId, "attempt", attempts)
+	return attempts, nil
+}
+
+func jobNotFailedError(state string) error {
+	if state == "" {
+		state = "not finished or has no recorded state"
+	}
+	return &RequestError{Code: codeJobNotFailed, Message: fmt.Sprintf("only failed jobs can be retried, job is %s", state)}
+}
+
+// attemptsFromMetadata returns the number of runs of a job, which is one
+// until it is retried.
+func attemptsFromMetadata(meta map[string]string) int64 {
+	if attempts, err := strconv.ParseInt(meta[metaAttempts], 10, 64); err == nil {
+		return attempts
+	}
+	return 1
+}
+
+// RetryProof serves POST /retry-proof?jobId=... It requeues a failed job
+// with its stored input.
+func (s *State) RetryProof(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	jobId := r.URL.Query().Get("jobId")
+	attempts, err := s.retryJob(r.Context(), jobId)
+	writeRetryResponse(w, jobId, attempts, err)
+}
+
+// writeRetryResponse answers a retry of jobId with the outcome of retryJob.
+func writeRetryResponse(w http.ResponseWriter, jobId string, attempts int64, err error) {
+	var reqErr *RequestError
+	switch {
+	case err == errInvalidJobId:
+		writeError(w, http.StatusBadRequest, codeInvalidJobId, err.Error())
+	case err == errJobNotFound:
+		writeError(w, http.StatusNotFound, codeJobNotFound, err.Error())
+	case err == errJobExpired:
+		writeError(w, http.StatusGone, errJobExpired.Code, errJobExpired.Message)
+	case err == errRetryInputGone || err == errJobNotProvable:
+		reqErr := err.(*RequestError)
+		writeError(w, http.StatusConflict, reqErr.Code, reqErr.Message)
+	case errors.As(err, &reqErr) && reqErr.Code == codeJobNotFailed:
+		writeError(w, http.StatusConflict, reqErr.Code, reqErr.Message)
+	case err != nil:
+		writeRequestError(w, err)
+	default:
+		json.NewEncoder(w).Encode(RetryProofResponse{JobId: jobId, Attempts: attempts})
+	}
+}
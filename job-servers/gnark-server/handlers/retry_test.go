@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestRetryProofChecksAllowedCircuits(t *testing.T) {
+	s, _ := newTestState(t)
+	jobId := addFinishedJob(t, s, jobStateFailed, testProofRequest(t))
+
+	auth, restricted := jwtAuth(t, []string{"1234567890"})
+	w := serve(auth.Require(s.RetryProof), http.MethodPost, "/retry-proof?jobId="+jobId, restricted)
+	if w.Code != http.StatusForbidden || errorCode(t, w) != codeCircuitNotAllowed {
+		t.Fatalf("restricted token: %d %s, want 403 %s", w.Code, w.Body, codeCircuitNotAllowed)
+	}
+	if queued := queuedJobs(t, s); len(queued) != 0 {
+		t.Fatalf("refused retry queued %v", queued)
+	}
+	if state, _ := s.RedisClient.HGet(context.Background(), getRedisMetaKey(jobId), metaState).Result(); state != jobStateFailed {
+		t.Fatalf("refused retry left the job %s, want %s", state, jobStateFailed)
+	}
+
+	_, allowed := jwtAuth(t, []string{testCircuitDigest})
+	w = serve(auth.Require(s.RetryProof), http.MethodPost, "/retry-proof?jobId="+jobId, allowed)
+	if w.Code != http.StatusOK {
+		t.Fatalf("allowed token: %d %s, want 200", w.Code, w.Body)
+	}
+	if queued := queuedJobs(t, s); len(queued) != 1 || queued[0] != jobId {
+		t.Fatalf("queued %v, want [%s]", queued, jobId)
+	}
+}
+
+func TestRetryDeadLetterIgnoresAllowedCircuits(t *testing.T) {
+	// The admin route authenticates with ADMIN_TOKEN, which carries no
+	// allowed_circuits claim.
+	s, _ := newTestState(t)
+	jobId := addFinishedJob(t, s, jobStateFailed, testProofRequest(t))
+	s.deadLetter(context.Background(), jobId, errDeadlineExceeded)
+
+	w := serve(s.RetryDeadLetterHandler, http.MethodPost, "/dead-letter-jobs/retry?jobId="+jobId, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s, want 200", w.Code, w.Body)
+	}
+	if queued := queuedJobs(t, s); len(queued) != 1 || queued[0] != jobId {
+		t.Fatalf("queued %v, want [%s]", queued, jobId)
+	}
+}
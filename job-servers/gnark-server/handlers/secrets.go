@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gnark-server/secrets"
+)
+
+// ReloadSecretsResponse is the response of POST /reload-secrets.
+type ReloadSecretsResponse struct {
+	Secrets []secrets.Result `json:"secrets"`
+}
+
+// ReloadSecretsHandler serves POST /reload-secrets. It checks the secret
+// files right away instead of at the next watch interval, and reports which
+// secrets were rotated and which changed but need a restart.
+func (s *State) ReloadSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	resp := ReloadSecretsResponse{Secrets: []secrets.Result{}}
+	if s.Secrets != nil {
+		resp.Secrets = append(resp.Secrets, s.Secrets.Check()...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	// DefaultShardFallbackBacklog is the queue length above which a sharded
+	// worker with no job of its own claims any job, when
+	// SHARD_FALLBACK_BACKLOG is not set.
+	DefaultShardFallbackBacklog = 10
+
+	// shardScanDepth is the number of oldest jobs of each queue a sharded
+	// worker looks through for one of its shard.
+	shardScanDepth = 256
+
+	// Kinds of sharded claims.
+	shardClaimOwn      = "own"
+	shardClaimFallback = "fallback"
+	shardClaimUntagged = "untagged"
+)
+
+// Shard restricts the jobs the workers of this instance claim to those whose
+// input digest maps to shard Index of Count, so that identical and related
+// inputs keep landing on the same instance. The shard of a job is the first
+// 32 bits of its inputDigest modulo Count. Jobs without an input digest, such
+// as batch submissions and jobs queued by older servers, are claimed by any
+// shard.
+type Shard struct {
+	Count int
+	Index int
+	// FallbackBacklog is the number of queued jobs above which a worker
+	// that finds no job of its shard claims the oldest job instead, so that
+	// an idle shard helps with a backlog.
+	FallbackBacklog int64
+
+	own      atomic.Int64
+	fallback atomic.Int64
+	untagged atomic.Int64
+}
+
+// NewShard validates a shard assignment.
+func NewShard(count int, index int, fallbackBacklog int64) (*Shard, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("shard count must be positive, got %d", count)
+	}
+	if index < 0 || index >= count {
+		return nil, fmt.Errorf("shard index must be between 0 and %d, got %d", count-1, index)
+	}
+	if fallbackBacklog < 0 {
+		return nil, errors.New("shard fallback backlog must not be negative")
+	}
+	return &Shard{Count: count, Index: index, FallbackBacklog: fallbackBacklog}, nil
+}
+
+// countClaim records a claim of kind by a worker of shard.
+func (s *State) countClaim(shard *Shard, kind string) {
+	switch kind {
+	case shardClaimOwn:
+		shard.own.Add(1)
+	case shardClaimFallback:
+		shard.fallback.Add(1)
+	default:
+		shard.untagged.Add(1)
+	}
+	s.Metrics.shardClaim(kind)
+}
+
+// ShardStats describes the shard of this instance and what its workers
+// claimed.
+type ShardStats struct {
+	Count int `json:"count"`
+	Index int `json:"index"`
+	// FallbackBacklog is the queue length above which workers claim jobs of
+	// other shards when their own is empty.
+	FallbackBacklog int64 `json:"fallbackBacklog"`
+	// Own, Fallback and Untagged count the jobs claimed from this shard,
+	// from other shards because of the backlog, and without input digest.
+	Own      int64 `json:"own"`
+	Fallback int64 `json:"fallback"`
+	Untagged int64 `json:"untagged"`
+}
+
+// shardStats returns the stats of the shard of this instance, or nil if it
+// is not sharded.
+func (s *State) shardStats() *ShardStats {
+	if s.Shard == nil {
+		return nil
+	}
+	return &ShardStats{
+		Count:           s.Shard.Count,
+		Index:           s.Shard.Index,
+		FallbackBacklog: s.Shard.FallbackBacklog,
+		Own:             s.Shard.own.Load(),
+		Fallback:        s.Shard.fallback.Load(),
+		Untagged:        s.Shard.untagged.Load(),
+	}
+}
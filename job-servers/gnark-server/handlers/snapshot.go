@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gnark-server/redisconfig"
+	"gnark-server/snapshot"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// metaNonProvable marks jobs imported from a snapshot of a circuit
+	// release whose keys are not loaded here. They cannot be retried or
+	// replayed.
+	metaNonProvable = "nonProvable"
+	metaImportedAt  = "importedAt"
+
+	snapshotScanCount = 500
+)
+
+var errJobNotProvable = &RequestError{Code: "job_not_provable", Message: "job was imported from a snapshot of a circuit release that is not loaded"}
+
+// JobFilter selects the jobs of a snapshot. Empty fields match every job.
+type JobFilter struct {
+	State  string
+	Client string
+	// Since matches jobs queued at or after it.
+	Since time.Time
+}
+
+func (f JobFilter) matches(meta map[string]string) bool {
+	if f.State != "" && meta[metaState] != f.State {
+		return false
+	}
+	if f.Client != "" && meta[metaClient] != f.Client {
+		return false
+	}
+	if !f.Since.IsZero() {
+		queuedAt, err := time.Parse(time.RFC3339Nano, meta[metaStateAt(jobStateQueued)])
+		if err != nil || queuedAt.Before(f.Since) {
+			return false
+		}
+	}
+	return true
+}
+
+// FindJobs returns the IDs of up to limit jobs in Redis that match filter.
+// Redis is scanned, so the jobs are in no particular order.
+func (s *State) FindJobs(ctx context.Context, filter JobFilter, limit int) ([]string, error) {
+	rdb, err := redisconfig.ScanClient(ctx, s.RedisClient)
+	if err != nil {
+		return nil, err
+	}
+	var jobIds []string
+	iter := rdb.Scan(ctx, 0, redisMetaKeyPrefix+"*", snapshotScanCount).Iterator()
+	for iter.Next(ctx) && len(jobIds) < limit {
+		jobId := strings.TrimPrefix(iter.Val(), redisMetaKeyPrefix)
+		meta, err := s.getJobMetadata(ctx, jobId)
+		if err != nil {
+			return nil, err
+		}
+		if filter.matches(meta) {
+			jobIds = append(jobIds, jobId)
+		}
+	}
+	return jobIds, iter.Err()
+}
+
+// SnapshotJob reads the records of a job. Inputs can be megabytes, so they
+// are only included when withInput is set.
+func (s *State) SnapshotJob(ctx context.Context, jobId string, withInput bool) (snapshot.Job, error) {
+	job := snapshot.Job{JobId: jobId}
+	pipe := s.RedisClient.Pipeline()
+	result := pipe.Get(ctx, getRedisKey(jobId))
+	meta := pipe.HGetAll(ctx, getRedisMetaKey(jobId))
+	attempts := pipe.LRange(ctx, getRedisCallbackAttemptsKey(jobId), 0, -1)
+	var input *redis.StringCmd
+	if withInput {
+		input = pipe.Get(ctx, getRedisInputKey(jobId))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return job, err
+	}
+	job.Metadata = meta.Val()
+	if len(job.Metadata) == 0 && result.Err() == redis.Nil {
+		return job, errJobNotFound
+	}
+	if result.Err() == nil {
+		job.Result = json.RawMessage(result.Val())
+	}
+	if input != nil && input.Err() == nil {
+		job.Input = json.RawMessage(input.Val())
+	}
+	for _, attempt := range attempts.Val() {
+		job.CallbackAttempts = append(job.CallbackAttempts, json.RawMessage(attempt))
+	}
+	return job, nil
+}
+
+// RestoreJob writes the records of a snapshot job to Redis. It is meant for
+// development servers: the job is not queued, and nonProvable marks it as
+// belonging to a circuit release whose keys are not loaded. Jobs that
+// already exist are left alone and reported as not restored.
+func (s *State) RestoreJob(ctx context.Context, job snapshot.Job, nonProvable bool) (bool, error) {
+	n, err := s.RedisClient.Exists(ctx, getRedisKey(job.JobId), getRedisMetaKey(job.JobId)).Result()
+	if err != nil || n > 0 {
+		return false, err
+	}
+	meta := map[string]interface{}{metaImportedAt: time.Now().UTC().Format(time.RFC3339Nano)}
+	for k, v := range job.Metadata {
+		meta[k] = v
+	}
+	if nonProvable {
+		meta[metaNonProvable] = "true"
+	}
+	pipe := s.RedisClient.TxPipeline()
+	if len(job.Result) > 0 {
+		pipe.Set(ctx, getRedisKey(job.JobId), []byte(job.Result), expiration)
+	}
+	queueJobMetadata(ctx, pipe, job.JobId, meta)
+	if len(job.Input) > 0 {
+		pipe.Set(ctx, getRedisInputKey(job.JobId), []byte(job.Input), expiration)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	for _, attempt := range job.CallbackAttempts {
+		if err := s.boundedAppend(ctx, job.JobId, listCallbackAttempts, getRedisCallbackAttemptsKey(job.JobId), attempt); err != nil {
+			return true, fmt.Errorf("failed to restore callback attempts: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// checkProvable refuses jobs imported for a circuit release that is not
+// loaded.
+func (s *State) checkProvable(ctx context.Context, jobId string) error {
+	nonProvable, err := s.RedisClient.HGet(ctx, getRedisMetaKey(jobId), metaNonProvable).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if nonProvable == "true" {
+		return errJobNotProvable
+	}
+	return nil
+}
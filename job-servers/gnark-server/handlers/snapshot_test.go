@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestFindJobsFilters(t *testing.T) {
+	s, _ := newTestState(t)
+	ctx := context.Background()
+	now := time.Now()
+	addJob := func(state string, client string, queuedAt time.Time) string {
+		t.Helper()
+		jobId := uuid.NewString()
+		err := s.RedisClient.HSet(ctx, getRedisMetaKey(jobId),
+			metaState, state,
+			metaClient, client,
+			metaStateAt(jobStateQueued), queuedAt.UTC().Format(time.RFC3339Nano),
+		).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return jobId
+	}
+	oldFailed := addJob(jobStateFailed, "indexer", now.Add(-2*time.Hour))
+	newFailed := addJob(jobStateFailed, "wallet", now)
+	newDone := addJob(jobStateDone, "indexer", now)
+
+	tests := []struct {
+		name   string
+		filter JobFilter
+		limit  int
+		want   []string
+	}{
+		{"all", JobFilter{}, 10, []string{oldFailed, newFailed, newDone}},
+		{"state", JobFilter{State: jobStateFailed}, 10, []string{oldFailed, newFailed}},
+		{"client", JobFilter{Client: "indexer"}, 10, []string{oldFailed, newDone}},
+		{"since", JobFilter{Since: now.Add(-time.Hour)}, 10, []string{newFailed, newDone}},
+		{"combined", JobFilter{State: jobStateFailed, Since: now.Add(-time.Hour)}, 10, []string{newFailed}},
+		{"none", JobFilter{Client: "explorer"}, 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.FindJobs(ctx, tt.filter, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			want := append([]string(nil), tt.want...)
+			sort.Strings(want)
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Fatalf("FindJobs(%+v) = %v, want %v", tt.filter, got, want)
+			}
+		})
+	}
+	if got, err := s.FindJobs(ctx, JobFilter{}, 2); err != nil || len(got) != 2 {
+		t.Fatalf("FindJobs() with a limit of 2 = %v, %v", got, err)
+	}
+}
+
+func TestSnapshotRestoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source, _ := newTestState(t)
+	source.StoreInputs = true
+	jobId := addFinishedJob(t, source, jobStateDone, testProofRequest(t))
+	if err := source.RedisClient.RPush(ctx, getRedisCallbackAttemptsKey(jobId), `{"status":500}`).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := source.SnapshotJob(ctx, jobId, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(job.Result) == 0 || len(job.Input) == 0 || len(job.CallbackAttempts) != 1 || job.Metadata[metaState] != jobStateDone {
+		t.Fatalf("SnapshotJob() = %+v, want the result, input, callback attempts and metadata", job)
+	}
+	if withoutInput, err := source.SnapshotJob(ctx, jobId, false); err != nil || len(withoutInput.Input) != 0 {
+		t.Fatalf("SnapshotJob() without input = %+v, %v", withoutInput, err)
+	}
+	if _, err := source.SnapshotJob(ctx, uuid.NewString(), true); err != errJobNotFound {
+		t.Fatalf("SnapshotJob() of an unknown job = %v, want errJobNotFound", err)
+	}
+
+	for _, nonProvable := range []bool{false, true} {
+		dev, _ := newTestState(t)
+		dev.StoreInputs = true
+		restored, err := dev.RestoreJob(ctx, job, nonProvable)
+		if err != nil || !restored {
+			t.Fatalf("RestoreJob() = %v, %v", restored, err)
+		}
+		if restored, err := dev.RestoreJob(ctx, job, nonProvable); err != nil || restored {
+			t.Fatalf("RestoreJob() of an existing job = %v, %v, want it left alone", restored, err)
+		}
+		again, err := dev.SnapshotJob(ctx, jobId, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again.Result) != string(job.Result) || string(again.Input) != string(job.Input) || len(again.CallbackAttempts) != 1 {
+			t.Fatalf("restored job = %+v, want %+v", again, job)
+		}
+		if again.Metadata[metaImportedAt] == "" {
+			t.Fatalf("restored metadata %v, want the import time", again.Metadata)
+		}
+		if got := again.Metadata[metaNonProvable] == "true"; got != nonProvable {
+			t.Fatalf("restored metadata %v, want non-provable %v", again.Metadata, nonProvable)
+		}
+		if queued := queuedJobs(t, dev); len(queued) != 0 {
+			t.Fatalf("restoring queued %v", queued)
+		}
+
+		w := serve(dev.Jobs, http.MethodPost, "/jobs/"+jobId+"/replay", "")
+		if nonProvable {
+			if w.Code != http.StatusConflict || errorCode(t, w) != errJobNotProvable.Code {
+				t.Fatalf("replay of a non-provable job: %d %s, want %s", w.Code, w.Body, errJobNotProvable.Code)
+			}
+		} else if w.Code != http.StatusOK {
+			t.Fatalf("replay of a restored job: %d %s", w.Code, w.Body)
+		}
+	}
+}
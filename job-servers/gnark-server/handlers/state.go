@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gnark-server/circuitData"
+	"gnark-server/delivery"
+	"gnark-server/jobstore"
+	"gnark-server/mirror"
+	"gnark-server/proofcache"
+	"gnark-server/secrets"
+	"gnark-server/webhook"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+type State struct {
+	// Circuits holds the loaded circuits by name. Jobs name the circuit
+	// they are proved with, which may be left out when only one is loaded.
+	Circuits       circuitData.Registry
+	RedisClient    redis.UniversalClient
+	TracerProvider trace.TracerProvider
+	// CallbackValidator checks callbackUrl values at submission time.
+	CallbackValidator *webhook.Validator
+	// CallbackDeliverer posts terminal job responses to callback URLs.
+	CallbackDeliverer *webhook.Deliverer
+	// CallbackDedupeWindow is how long a delivered callback suppresses
+	// identical ones: same URL, input digest, circuit release and terminal
+	// status. Zero disables suppression.
+	CallbackDedupeWindow time.Duration
+	// ListCaps bounds the per-job lists stored in Redis, keyed by list name.
+	ListCaps map[string]ListCap
+	// ProofEventsIdleTimeout closes /proof-events streams and /proof-ws
+	// connections that have not received a stage transition for this long.
+	ProofEventsIdleTimeout time.Duration
+	// Shard, if not nil, restricts the jobs workers claim to one shard of
+	// the input digests.
+	Shard *Shard
+	// MaxWebSocketConnections is the number of open /proof-ws connections
+	// above which new ones are refused with 503. Zero means unbounded.
+	MaxWebSocketConnections int64
+	// MaxJobAttempts is the number of runs after which a job that fails or
+	// is interrupted is moved to the dead-letter queue. Zero means
+	// DefaultMaxJobAttempts.
+	MaxJobAttempts int
+	// ResultTTL is how long the records of completed jobs are kept. Zero
+	// means 1 hour.
+	ResultTTL time.Duration
+	// FailedResultTTL is how long the records of failed jobs are kept. Zero
+	// means 5 minutes.
+	FailedResultTTL time.Duration
+	// IdempotencyWindow is how long an idempotency key maps to its job. Zero
+	// means 24 hours.
+	IdempotencyWindow time.Duration
+	// StoreInputs keeps job inputs after the job finishes so that it can be
+	// replayed.
+	StoreInputs bool
+	// RetryInputTTL is how long the input of a failed job is kept so that
+	// it can be retried, when StoreInputs is not set. Zero means 24 hours.
+	RetryInputTTL time.Duration
+	// EventLogMaxLength is the number of job state transitions kept in the
+	// event log served on /events. Zero means DefaultEventLogMaxLength.
+	EventLogMaxLength int64
+	// Mirror receives terminal job metadata when MIRROR_DATABASE_URL is set.
+	Mirror *mirror.Mirror
+	// Deliveries, if not nil, runs callback and mirror deliveries on bounded
+	// per-sink workers. Register the sinks with RegisterDeliverySinks.
+	Deliveries *delivery.Pool
+	// JobStore durably records jobs and their results when JOB_STORE_PATH
+	// is set.
+	JobStore jobstore.JobStore
+	// Metrics records the job lifecycle for /metrics.
+	Metrics *Metrics
+	// ProofCache serves proofs for public inputs that were already proved
+	// when PROOF_CACHE_TTL_SECONDS is set.
+	ProofCache *proofcache.Cache
+	// ProveTimeout is how long a job may spend building its witness and
+	// proving before it fails with code timeout. Zero means no limit.
+	ProveTimeout time.Duration
+	// MaxProveTimeout bounds the proveTimeoutSeconds of requests. Zero means
+	// DefaultMaxProveTimeout.
+	MaxProveTimeout time.Duration
+	// DeadlinePolicy is DeadlinePolicyExpire or DeadlinePolicyFlag. Empty
+	// means DeadlinePolicyExpire.
+	DeadlinePolicy string
+	// MaxPublicInputs is the hard cap on the public inputs of submitted
+	// proofs, checked before they are decoded. Zero means
+	// prover.DefaultMaxPublicInputs.
+	MaxPublicInputs int
+	// MaxProveWait bounds how long POST /prove waits for a job to finish.
+	// Zero means DefaultMaxProveWait.
+	MaxProveWait time.Duration
+	// MaxQueueLength is the number of queued and running jobs above which
+	// new submissions are refused with 429. Zero means unbounded.
+	MaxQueueLength int64
+	// VerifyOnly is set when the server started without a usable proving
+	// key. New proofs are refused and no workers run, but everything else is
+	// served.
+	VerifyOnly bool
+	// LoadCircuits loads the circuits from dir, or again from the data
+	// directory when dir is empty, for /reload-circuit. LoadCircuit loads
+	// the circuit called name alone, see circuitData.InitCircuit.
+	LoadCircuits func(dir string) (circuitData.Registry, error)
+	LoadCircuit  func(dir string, name string) (circuitData.CircuitData, error)
+	// Secrets watches the secrets read from files, for /reload-secrets.
+	Secrets *secrets.Watcher
+	// BuildCommit is the VCS revision the server was built from, reported by
+	// /version.
+	BuildCommit string
+	// InstanceId names this instance in the fleet registry and in the leases
+	// of the jobs it proves.
+	InstanceId string
+	// FleetHeartbeatInterval is how often the fleet registration is
+	// refreshed. Zero means DefaultFleetHeartbeatInterval.
+	FleetHeartbeatInterval time.Duration
+
+	// circuitsMu guards Circuits, which /reload-circuit swaps, and reloading
+	// is held while it loads the new circuits.
+	circuitsMu sync.RWMutex
+	reloading  sync.Mutex
+
+	inFlight       sync.WaitGroup
+	workers        sync.WaitGroup
+	activeJobs     atomic.Int64
+	webSockets     atomic.Int64
+	proveDurations durationWindow
+	// endToEndLatencies holds the latencies from upstreamCreatedAt to
+	// completion, and clockSkewClamped counts those clamped to zero.
+	endToEndLatencies durationWindow
+	clockSkewClamped  atomic.Int64
+	readinessHistory  readinessHistory
+	// wrapperDigest is the result of CheckWrapperDigest, nil until it ran.
+	wrapperDigest atomic.Pointer[ReadinessCheck]
+	stopping      atomic.Bool
+	stop          signal
+	streams       signal
+	// running holds the IDs of the jobs this instance is proving.
+	running sync.Map
+	// resultReads coalesces concurrent get-proof reads of the same job.
+	resultReads singleflight.Group
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const durationWindowSize = 100
+
+// durationWindow keeps the most recent proving durations in a ring buffer.
+type durationWindow struct {
+	mu        sync.Mutex
+	durations [durationWindowSize]time.Duration
+	next      int
+	count     int
+}
+
+func (d *durationWindow) add(duration time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.durations[d.next] = duration
+	d.next = (d.next + 1) % durationWindowSize
+	if d.count < durationWindowSize {
+		d.count++
+	}
+}
+
+// average returns the mean of the recorded durations and false if nothing
+// has been recorded yet.
+func (d *durationWindow) average() (time.Duration, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.count == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	for i := 0; i < d.count; i++ {
+		total += d.durations[i]
+	}
+	return total / time.Duration(d.count), true
+}
+
+// percentiles returns the given percentiles, between 0 and 100, of the
+// recorded durations using the nearest-rank method, and false if nothing has
+// been recorded yet.
+func (d *durationWindow) percentiles(ps ...float64) ([]time.Duration, int, bool) {
+	d.mu.Lock()
+	sorted := make([]time.Duration, d.count)
+	copy(sorted, d.durations[:d.count])
+	d.mu.Unlock()
+	if len(sorted) == 0 {
+		return nil, 0, false
+	}
+	return sortedPercentiles(sorted, ps...), len(sorted), true
+}
+
+// sortedPercentiles sorts durations in place and returns the given
+// percentiles using the nearest-rank method. durations must not be empty.
+func sortedPercentiles(durations []time.Duration, ps ...float64) []time.Duration {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	result := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		rank := int(math.Ceil(p / 100 * float64(len(durations))))
+		if rank < 1 {
+			rank = 1
+		}
+		result[i] = durations[rank-1]
+	}
+	return result
+}
+
+// DurationStats summarizes the most recent durations of one kind.
+type DurationStats struct {
+	Count int   `json:"count"`
+	P50Ms int64 `json:"p50Ms"`
+	P90Ms int64 `json:"p90Ms"`
+	P99Ms int64 `json:"p99Ms"`
+}
+
+type StatsResponse struct {
+	// ProveDuration covers the time workers on this instance spent proving.
+	ProveDuration *DurationStats `json:"proveDuration"`
+	// EndToEndLatency covers the time from upstreamCreatedAt to completion
+	// of jobs finished on this instance.
+	EndToEndLatency *DurationStats `json:"endToEndLatency"`
+	// ClockSkewClamped counts end-to-end latencies that were negative and
+	// were clamped to zero.
+	ClockSkewClamped int64 `json:"clockSkewClamped"`
+	// QueueDepth is the number of queued and running jobs across all
+	// instances, or nil if Redis could not be read.
+	QueueDepth *int64 `json:"queueDepth"`
+	// MaxQueueLength is the queue depth above which submissions are refused,
+	// or nil if the queue is unbounded.
+	MaxQueueLength *int64 `json:"maxQueueLength"`
+	// ActiveWorkers is the number of workers on this instance that are
+	// proving a job.
+	ActiveWorkers int64 `json:"activeWorkers"`
+	// Shard is the shard of this instance, or nil if it is not sharded.
+	Shard *ShardStats `json:"shard,omitempty"`
+}
+
+func (d *durationWindow) stats() *DurationStats {
+	ps, count, ok := d.percentiles(50, 90, 99)
+	if !ok {
+		return nil
+	}
+	return &DurationStats{
+		Count: count,
+		P50Ms: ps[0].Milliseconds(),
+		P90Ms: ps[1].Milliseconds(),
+		P99Ms: ps[2].Milliseconds(),
+	}
+}
+
+// Stats reports percentiles of the last durationWindowSize proving durations
+// and end-to-end latencies seen by this instance, along with the queue depth
+// and the number of busy workers.
+func (s *State) Stats(w http.ResponseWriter, r *http.Request) {
+	resp := StatsResponse{
+		ProveDuration:    s.proveDurations.stats(),
+		EndToEndLatency:  s.endToEndLatencies.stats(),
+		ClockSkewClamped: s.clockSkewClamped.Load(),
+		ActiveWorkers:    s.activeJobs.Load(),
+		Shard:            s.shardStats(),
+	}
+	if depth, err := s.queueDepth(r.Context()); err != nil {
+		log.Println("Failed to read queue depth:", err)
+	} else {
+		resp.QueueDepth = &depth
+	}
+	if s.MaxQueueLength > 0 {
+		resp.MaxQueueLength = &s.MaxQueueLength
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultMaxProveWait bounds how long POST /prove waits for a proof when
+// MAX_PROVE_WAIT_SECONDS is not set.
+const DefaultMaxProveWait = 5 * time.Minute
+
+func (s *State) maxProveWait() time.Duration {
+	if s.MaxProveWait > 0 {
+		return s.MaxProveWait
+	}
+	return DefaultMaxProveWait
+}
+
+// proveWait returns how long /prove waits for the job: the waitSeconds
+// query parameter capped by maxProveWait, or maxProveWait when it is not set.
+func (s *State) proveWait(r *http.Request) (time.Duration, error) {
+	limit := s.maxProveWait()
+	v := r.URL.Query().Get("waitSeconds")
+	if v == "" {
+		return limit, nil
+	}
+	seconds, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || seconds < 1 {
+		return 0, &RequestError{Code: codeInvalidRequest, Message: "waitSeconds must be a positive integer"}
+	}
+	if wait := time.Duration(seconds) * time.Second; wait < limit {
+		return wait, nil
+	}
+	return limit, nil
+}
+
+// waitForJob blocks until the job is done or failed and reports whether it
+// finished before wait elapsed. It also returns false when the request is
+// cancelled or the server shuts down its streams.
+func (s *State) waitForJob(ctx context.Context, jobId string, wait time.Duration) (bool, error) {
+	pubsub, current, err := s.subscribeProofEvents(ctx, jobId)
+	if err != nil {
+		return false, err
+	}
+	defer pubsub.Close()
+	if isTerminalStage(current.Stage) {
+		return true, nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-s.streams.done():
+			return false, nil
+		case <-timer.C:
+			return false, nil
+		case msg, ok := <-messages:
+			if !ok {
+				return false, nil
+			}
+			var event ProofEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+			if isTerminalStage(event.Stage) {
+				return true, nil
+			}
+		}
+	}
+}
+
+// Prove serves POST /prove, a blocking start-proof for low-volume callers. It
+// queues the job exactly like start-proof and waits up to waitSeconds for it
+// to finish. A finished job is answered like get-proof, with the proof or the
+// error. A job still pending when the wait ends is answered with 202 and its
+// jobId, to be polled with get-proof.
+func (s *State) Prove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	wait, err := s.proveWait(r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	rawInput, ok := s.decodeProofRequest(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	started, err := s.startProof(ctx, rawInput)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if s.ProofCache != nil {
+		if started.cached != nil {
+			w.Header().Set(cacheHeader, "HIT")
+		} else {
+			w.Header().Set(cacheHeader, "MISS")
+		}
+	}
+	if started.deduplicated {
+		w.Header().Set(deduplicatedHeader, "true")
+	}
+	// Jobs without a stage, queued by older servers, are answered as they
+	// are now.
+	finished, err := s.waitForJob(ctx, started.jobId, wait)
+	if err == errJobNotFound {
+		finished, err = true, nil
+	}
+	if err != nil {
+		log.Printf("Failed to wait for job %s: %v\n", started.jobId, err)
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !finished {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(StartProofResponse{
+			JobId: started.jobId,
+			Job:   s.jobRecord(ctx, started.jobId),
+		})
+		return
+	}
+	response, err := s.getProof(ctx, started.jobId)
+	if err != nil {
+		writeGetProofError(w, response, err)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
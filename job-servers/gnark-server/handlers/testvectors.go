@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"gnark-server/prover"
+	"gnark-server/utils"
+)
+
+const (
+	codeJobNotProved     = "job_not_proved"
+	codeReleaseNotLoaded = "release_not_loaded"
+)
+
+// TestVectorsResponse is the response of GET /test-vectors.
+type TestVectorsResponse struct {
+	JobId          string `json:"jobId"`
+	Circuit        string `json:"circuit,omitempty"`
+	CircuitRelease string `json:"circuitRelease"`
+	// Backend is the proving system, plonk or groth16.
+	Backend string `json:"backend"`
+	// Vectors holds the proof of the job, followed by its mutations.
+	Vectors []TestVector `json:"vectors"`
+}
+
+// TestVector is a call the exported Solidity verifier must accept, when
+// Valid, or reject.
+type TestVector struct {
+	// Name is "valid" for the proof of the job, or names the mutation.
+	Name  string `json:"name"`
+	Valid bool   `json:"valid"`
+	// Mutation is what was changed, and Reason why the verifier must
+	// reject the result.
+	Mutation string `json:"mutation,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	// Proof, PublicInputs and Calldata are encoded like ProofCalldata.
+	Proof        string   `json:"proof"`
+	PublicInputs []string `json:"publicInputs"`
+	Calldata     string   `json:"calldata"`
+}
+
+// TestVectorsHandler serves GET /test-vectors?jobId=<jobId>. It derives
+// known-bad calls to the Solidity verifier from the proof of a succeeded
+// job, for contract tests to assert that the verifier rejects them. Every
+// mutation is checked to fail verification with the loaded verifying key,
+// so the job must have been proved by the release that is loaded.
+func (s *State) TestVectorsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	jobId := r.URL.Query().Get("jobId")
+	response, err := s.getProof(r.Context(), jobId)
+	if err != nil {
+		writeGetProofError(w, response, err)
+		return
+	}
+	if !response.Success || response.Proof == nil {
+		writeError(w, http.StatusConflict, codeJobNotProved, "job has no proof, test vectors are derived from succeeded jobs")
+		return
+	}
+	data, err := s.circuit(response.Circuit)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if response.CircuitRelease != "" && response.CircuitRelease != data.ReleaseId {
+		writeError(w, http.StatusConflict, codeReleaseNotLoaded, "job was proved by circuit release "+response.CircuitRelease+", but "+data.ReleaseId+" is loaded")
+		return
+	}
+	proof, err := utils.SolidityProof(response.Proof.Proof)
+	if err != nil {
+		log.Printf("Failed to decode the proof of job %s: %v\n", jobId, err)
+		writeInternalError(w)
+		return
+	}
+	vectors, err := prover.TestVectors(&data, &prover.Result{
+		Backend:      data.Backend.Name(),
+		PublicInputs: response.Proof.PublicInputs,
+		Proof:        proof,
+	})
+	if errors.Is(err, prover.ErrValidProofRejected) {
+		writeError(w, http.StatusConflict, codeReleaseNotLoaded, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("Failed to derive the test vectors of job %s: %v\n", jobId, err)
+		writeInternalError(w)
+		return
+	}
+
+	resp := TestVectorsResponse{
+		JobId:          jobId,
+		Circuit:        data.Name,
+		CircuitRelease: data.ReleaseId,
+		Backend:        data.Backend.Name(),
+		Vectors:        make([]TestVector, 0, len(vectors)),
+	}
+	for _, vector := range vectors {
+		publicInputs, err := utils.SolidityPublicInputs(vector.Result.PublicInputs)
+		if err != nil {
+			log.Printf("Failed to encode the test vectors of job %s: %v\n", jobId, err)
+			writeInternalError(w)
+			return
+		}
+		resp.Vectors = append(resp.Vectors, TestVector{
+			Name:         vector.Name,
+			Valid:        vector.Valid,
+			Mutation:     vector.Mutation,
+			Reason:       vector.Reason,
+			Proof:        "0x" + hex.EncodeToString(vector.Result.Proof),
+			PublicInputs: publicInputs,
+			Calldata:     "0x" + hex.EncodeToString(vector.Calldata),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
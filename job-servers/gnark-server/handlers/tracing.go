@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const tracerName = "gnark-server/handlers"
+
+func jobIdAttribute(jobId string) attribute.KeyValue {
+	return attribute.String("job.id", jobId)
+}
+
+func (s *State) tracer() trace.Tracer {
+	if s.TracerProvider == nil {
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+	return s.TracerProvider.Tracer(tracerName)
+}
+
+// traceMetadata returns the W3C trace context of ctx as job metadata fields so
+// that consumers can stitch the proof lifecycle together.
+func traceMetadata(ctx context.Context) map[string]interface{} {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	fields := make(map[string]interface{}, len(carrier))
+	for k, v := range carrier {
+		fields[k] = v
+	}
+	return fields
+}
+
+// getTraceContext restores the span context recorded by traceMetadata.
+func (s *State) getTraceContext(ctx context.Context, jobId string) (trace.SpanContext, error) {
+	fields, err := s.getJobMetadata(ctx, jobId)
+	if err != nil {
+		return trace.SpanContext{}, err
+	}
+	return traceContextFromMetadata(fields), nil
+}
+
+func traceContextFromMetadata(fields map[string]string) trace.SpanContext {
+	restored := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(fields))
+	return trace.SpanContextFromContext(restored)
+}
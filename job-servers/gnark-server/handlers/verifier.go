@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"gnark-server/circuitData"
+)
+
+// ExportVerifier returns the Solidity verifier contract for the verifying
+// key of the circuit in the circuit query parameter, the same contract setup
+// writes to data/verifier.sol (or data/groth16_verifier.sol). The parameter
+// may be left out when a single circuit is loaded. The contract verifies
+// proofs of the backend the circuit was set up for, which the file name
+// reflects.
+func (s *State) ExportVerifier(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	data, err := s.circuit(r.URL.Query().Get("circuit"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := data.Vk.ExportSolidity(&buf); err != nil {
+		log.Println("Failed to export Solidity verifier:", err)
+		writeInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fileName := circuitData.BackendFiles(data.Backend.Name()).SolidityVerifier
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	w.Write(buf.Bytes())
+}
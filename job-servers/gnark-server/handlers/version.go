@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gnark-server/circuitData"
+	"gnark-server/solc"
+)
+
+// VersionResponse identifies the circuit releases and the build of a running
+// server, so that a circuit rotation can be confirmed instance by instance.
+// The fields of CircuitVersion are also set at the top level when a single
+// circuit is loaded.
+type VersionResponse struct {
+	CircuitRelease string `json:"circuitRelease,omitempty"`
+	Backend        string `json:"backend"`
+	// VerifyingKeyHash is the hex SHA-256 of the loaded verifying key file.
+	VerifyingKeyHash string `json:"verifyingKeyHash,omitempty"`
+	// CircuitDigest is the plonky2 circuit digest the circuit was set up
+	// with, as a decimal string.
+	CircuitDigest string `json:"circuitDigest,omitempty"`
+	Constraints   int    `json:"constraints,omitempty"`
+	GnarkVersion  string `json:"gnarkVersion"`
+	BuildCommit   string `json:"buildCommit,omitempty"`
+	// Solc lists the solc versions the Solidity verifier was compiled with
+	// at setup, when setup checked any.
+	Solc []solc.Result `json:"solc,omitempty"`
+	// Circuits describes every loaded circuit, by name.
+	Circuits []CircuitVersion `json:"circuits"`
+}
+
+// CircuitVersion identifies the release of one loaded circuit.
+type CircuitVersion struct {
+	Circuit          string        `json:"circuit"`
+	CircuitRelease   string        `json:"circuitRelease"`
+	VerifyingKeyHash string        `json:"verifyingKeyHash"`
+	CircuitDigest    string        `json:"circuitDigest,omitempty"`
+	Constraints      int           `json:"constraints"`
+	Solc             []solc.Result `json:"solc,omitempty"`
+}
+
+func circuitVersion(data circuitData.CircuitData) CircuitVersion {
+	return CircuitVersion{
+		Circuit:          data.Name,
+		CircuitRelease:   data.ReleaseId,
+		VerifyingKeyHash: data.Version.VerifyingKeyHash,
+		CircuitDigest:    data.Version.CircuitDigest,
+		Constraints:      data.Version.Constraints,
+		Solc:             data.Version.Solc,
+	}
+}
+
+// VersionHandler serves GET /version. The values are computed when the
+// circuit data is loaded.
+func (s *State) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+	resp := VersionResponse{
+		Backend:     s.backendName(),
+		BuildCommit: s.BuildCommit,
+		Circuits:    []CircuitVersion{},
+	}
+	for _, data := range s.sortedCircuits() {
+		resp.GnarkVersion = data.Version.GnarkVersion
+		resp.Circuits = append(resp.Circuits, circuitVersion(data))
+	}
+	if data, ok := s.soleCircuit(); ok {
+		resp.CircuitRelease = data.ReleaseId
+		resp.VerifyingKeyHash = data.Version.VerifyingKeyHash
+		resp.CircuitDigest = data.Version.CircuitDigest
+		resp.Constraints = data.Version.Constraints
+		resp.Solc = data.Version.Solc
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
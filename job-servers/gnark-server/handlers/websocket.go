@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+const (
+	// DefaultMaxWebSocketConnections bounds /proof-ws connections when
+	// MAX_WS_CONNECTIONS is not set.
+	DefaultMaxWebSocketConnections = 1000
+
+	webSocketWriteTimeout = 10 * time.Second
+)
+
+// ProofWebSocket sends the same stage transitions as ProofEvents, one JSON
+// message per ProofEvent, over a WebSocket for clients without SSE support.
+// The connection is closed normally once the job reaches done or failed.
+// Connections above MaxWebSocketConnections are refused with 503 before the
+// upgrade.
+func (s *State) ProofWebSocket(w http.ResponseWriter, r *http.Request) {
+	jobId := r.URL.Query().Get("jobId")
+	if jobId == "" {
+		jobId = r.URL.Query().Get("id")
+	}
+	if _, err := uuid.Parse(jobId); err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidJobId, errInvalidJobId.Error())
+		return
+	}
+	if open := s.webSockets.Add(1); s.MaxWebSocketConnections > 0 && open > s.MaxWebSocketConnections {
+		s.webSockets.Add(-1)
+		writeError(w, http.StatusServiceUnavailable, codeTooManyConnections, "too many WebSocket connections")
+		return
+	}
+	defer s.webSockets.Add(-1)
+
+	pubsub, current, err := s.subscribeProofEvents(r.Context(), jobId)
+	if errors.Is(err, errJobNotFound) {
+		writeError(w, http.StatusNotFound, codeJobNotFound, err.Error())
+		return
+	} else if err != nil {
+		writeInternalError(w)
+		return
+	}
+	defer pubsub.Close()
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		// Accept has already answered the request.
+		return
+	}
+	defer conn.CloseNow()
+	// The client is not expected to send anything; reading in the background
+	// handles pings and notices when it goes away.
+	ctx := conn.CloseRead(context.Background())
+
+	send := func(event ProofEvent) bool {
+		writeCtx, cancel := context.WithTimeout(ctx, webSocketWriteTimeout)
+		defer cancel()
+		return wsjson.Write(writeCtx, conn, event) == nil
+	}
+	if !send(current) {
+		return
+	}
+	if isTerminalStage(current.Stage) {
+		conn.Close(websocket.StatusNormalClosure, current.Stage)
+		return
+	}
+
+	idleTimeout := s.ProofEventsIdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultProofEventsIdleTimeout
+	}
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+	ping := time.NewTicker(proofEventsPingInterval)
+	defer ping.Stop()
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.streams.done():
+			conn.Close(websocket.StatusGoingAway, "server shutting down")
+			return
+		case <-idle.C:
+			conn.Close(websocket.StatusNormalClosure, "idle timeout")
+			return
+		case <-ping.C:
+			pingCtx, cancel := context.WithTimeout(ctx, webSocketWriteTimeout)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return
+			}
+		case msg, ok := <-messages:
+			if !ok {
+				conn.Close(websocket.StatusInternalError, "event subscription closed")
+				return
+			}
+			var event ProofEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+			if !send(event) {
+				return
+			}
+			if isTerminalStage(event.Stage) {
+				conn.Close(websocket.StatusNormalClosure, event.Stage)
+				return
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(idleTimeout)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"gnark-server/prover"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	dequeueTimeout          = time.Second
+	workerRestartBackoff    = time.Second
+	workerMaxRestartBackoff = time.Minute
+)
+
+// StartWorker launches a supervised worker that pulls jobs from the Redis
+// queue until StopAccepting is called. A worker that panics is restarted
+// with exponential backoff. In verify-only mode no worker is started, so
+// that jobs stay in the shared queue for instances that can prove them.
+func (s *State) StartWorker(id int) {
+	if s.VerifyOnly {
+		return
+	}
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		backoff := workerRestartBackoff
+		for {
+			err := s.runWorker(id)
+			if err == nil {
+				return
+			}
+			log.Printf("Worker %d crashed, restarting in %v: %v\n", id, backoff, err)
+			select {
+			case <-s.stopped():
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > workerMaxRestartBackoff {
+				backoff = workerMaxRestartBackoff
+			}
+		}
+	}()
+}
+
+// runWorker processes jobs until the server stops, in which case it returns
+// nil, or until a job panics, in which case it returns the panic as an error.
+func (s *State) runWorker(id int) error {
+	ctx := context.Background()
+	for {
+		select {
+		case <-s.stopped():
+			return nil
+		default:
+		}
+		jobId, err := s.dequeueJob(ctx)
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			log.Printf("Worker %d failed to dequeue: %v\n", id, err)
+			time.Sleep(dequeueTimeout)
+			continue
+		}
+		if err := s.processJob(ctx, jobId); err != nil {
+			return err
+		}
+	}
+}
+
+// processJob proves a dequeued job with the circuit it was submitted for, as
+// loaded when the job was claimed: a job keeps proving with the keys it
+// started with when /reload-circuit swaps them. A panic while proving marks
+// the job as failed and is returned so that the worker gets restarted.
+func (s *State) processJob(ctx context.Context, jobId string) (panicErr error) {
+	s.activeJobs.Add(1)
+	defer s.activeJobs.Add(-1)
+	s.Metrics.workerBusy(1)
+	defer s.Metrics.workerBusy(-1)
+	s.running.Store(jobId, struct{}{})
+	defer s.running.Delete(jobId)
+	// Released after the job left the processing list, so that it is never
+	// there without a lease while this worker is alive.
+	defer s.holdLease(ctx, jobId)()
+	defer func() {
+		if err := s.RedisClient.LRem(ctx, redisProcessingKey, 1, jobId).Err(); err != nil {
+			log.Printf("Failed to remove job %s from the processing list: %v\n", jobId, err)
+		}
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr = fmt.Errorf("job %s panicked: %v\n%s", jobId, r, debug.Stack())
+			s.failDeadLetter(ctx, jobId, &prover.ProverError{Err: fmt.Errorf("prover panicked: %v", r)})
+		}
+	}()
+
+	// Jobs queued before circuits had names carry none and are proved with
+	// the only circuit.
+	name, err := s.RedisClient.HGet(ctx, getRedisMetaKey(jobId), metaCircuit).Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Failed to read the circuit of job %s: %v\n", jobId, err)
+	}
+	selected, err := s.circuit(name)
+	if err != nil {
+		s.failJob(ctx, jobId, fmt.Errorf("circuit %q is not loaded on this server", name))
+		return nil
+	}
+	// Each job proves with its own copy of the circuit data.
+	data := &selected
+	if !s.checkDeadline(ctx, jobId, data) {
+		return nil
+	}
+
+	// Record the release before proving so that every outcome, including a
+	// panic, is attributed to the circuit that handled the job. The job is
+	// proving from here on.
+	fields := stateFields(jobStateProving, time.Now())
+	fields[metaCircuitRelease] = data.ReleaseId
+	_, err = s.RedisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, getRedisMetaKey(jobId), fields)
+		s.queueTransition(ctx, pipe, jobId, fields, nil)
+		return nil
+	})
+	if err != nil {
+		data.Logger().Printf("Failed to record circuit release of job %s: %v\n", jobId, err)
+	}
+	input, err := s.loadJobInput(ctx, jobId)
+	if err != nil {
+		s.failJob(ctx, jobId, fmt.Errorf("failed to load job input: %w", err))
+		return nil
+	}
+	proofRaw, vdRaw, err := parseProofRequest(input)
+	if err != nil {
+		s.failJob(ctx, jobId, err)
+		return nil
+	}
+	s.prove(data, jobId, proofRaw, vdRaw)
+	return nil
+}
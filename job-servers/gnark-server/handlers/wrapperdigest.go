@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+
+	"gnark-server/circuitData"
+	"gnark-server/wrapper"
+)
+
+// readinessWarn is the status of a readiness check that found a problem
+// which does not stop this instance from serving.
+const readinessWarn = "warn"
+
+// CheckWrapperDigest compares the circuit digest pinned by the wrapper
+// contract with the one of the loaded circuit called circuit, which may be
+// empty when a single circuit is loaded. A mismatch means proofs that verify
+// here will be rejected on-chain, so it is logged and reported by
+// /health/ready as the wrapperDigest check, with status "warn". It does not
+// fail readiness: reads and verification are unaffected. It returns an error
+// only if circuit is not loaded.
+func (s *State) CheckWrapperDigest(ctx context.Context, source wrapper.Source, circuit string) error {
+	data, err := s.circuit(circuit)
+	if err != nil {
+		return err
+	}
+	check := wrapperDigestCheck(ctx, source, data)
+	if check.Status != "ok" {
+		log.Println("Warning:", check.Error)
+	} else {
+		log.Printf("The circuit digest pinned by the %s matches the loaded circuit\n", source)
+	}
+	s.wrapperDigest.Store(&check)
+	return nil
+}
+
+func wrapperDigestCheck(ctx context.Context, source wrapper.Source, data circuitData.CircuitData) ReadinessCheck {
+	loaded, ok := new(big.Int).SetString(data.Version.CircuitDigest, 10)
+	if !ok {
+		return ReadinessCheck{Status: readinessWarn, Error: "the circuit digest of the loaded release is unknown, verifier_only_circuit_data.json is missing from the data directory"}
+	}
+	pinned, err := source.Digest(ctx)
+	if err != nil {
+		return ReadinessCheck{Status: readinessWarn, Error: fmt.Sprintf("could not read the circuit digest pinned by the %s: %v", source, err)}
+	}
+	if pinned.Cmp(loaded) != 0 {
+		return ReadinessCheck{Status: readinessWarn, Error: fmt.Sprintf(
+			"the %s pins circuit digest %s but release %s has %s; proofs will fail on-chain until the wrapper is redeployed for this release or the previous keys are restored",
+			source, pinned, data.ReleaseId, loaded)}
+	}
+	return ReadinessCheck{Status: "ok"}
+}
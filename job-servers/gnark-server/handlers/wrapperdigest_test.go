@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"gnark-server/circuitData"
+)
+
+// fakeSource is a wrapper.Source returning a fixed digest or error.
+type fakeSource struct {
+	digest *big.Int
+	err    error
+}
+
+func (f fakeSource) Digest(ctx context.Context) (*big.Int, error) { return f.digest, f.err }
+
+func (f fakeSource) String() string { return "fake wrapper" }
+
+func TestCheckWrapperDigest(t *testing.T) {
+	const loaded = "1234567890"
+	tests := []struct {
+		name       string
+		source     fakeSource
+		digest     string
+		wantStatus string
+		wantError  []string
+	}{
+		{"matching", fakeSource{digest: big.NewInt(1234567890)}, loaded, "ok", nil},
+		{"mismatching", fakeSource{digest: big.NewInt(42)}, loaded, readinessWarn, []string{"pins circuit digest 42", "release 0123456789ab has 1234567890", "redeployed"}},
+		{"unavailable source", fakeSource{err: errors.New("connection refused")}, loaded, readinessWarn, []string{"could not read", "connection refused"}},
+		{"unknown loaded digest", fakeSource{digest: big.NewInt(1234567890)}, "", readinessWarn, []string{"verifier_only_circuit_data.json is missing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestState(t)
+			s.Circuits = circuitData.Registry{
+				circuitData.DefaultCircuit: {
+					Name:      circuitData.DefaultCircuit,
+					ReleaseId: "0123456789ab",
+					Version:   circuitData.Version{CircuitDigest: tt.digest},
+				},
+			}
+			if _, ok := s.checkReadiness(context.Background()).Checks["wrapperDigest"]; ok {
+				t.Fatal("readiness reports wrapperDigest before the check ran")
+			}
+			if err := s.CheckWrapperDigest(context.Background(), tt.source, ""); err != nil {
+				t.Fatal(err)
+			}
+			check, ok := s.checkReadiness(context.Background()).Checks["wrapperDigest"]
+			if !ok {
+				t.Fatal("readiness does not report wrapperDigest")
+			}
+			if check.Status != tt.wantStatus {
+				t.Fatalf("wrapperDigest status %q, want %q: %s", check.Status, tt.wantStatus, check.Error)
+			}
+			for _, want := range tt.wantError {
+				if !strings.Contains(check.Error, want) {
+					t.Fatalf("wrapperDigest error %q does not contain %q", check.Error, want)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown circuit", func(t *testing.T) {
+		s, _ := newProvingTestState(t)
+		if err := s.CheckWrapperDigest(context.Background(), fakeSource{digest: big.NewInt(1)}, "missing"); err == nil {
+			t.Fatal("CheckWrapperDigest() accepted an unknown circuit")
+		}
+		if _, ok := s.checkReadiness(context.Background()).Checks["wrapperDigest"]; ok {
+			t.Fatal("readiness reports wrapperDigest for an unknown circuit")
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package jobstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const openTimeout = 5 * time.Second
+
+var jobsBucket = []byte("jobs")
+
+// BoltStore is a JobStore in a single BoltDB file. The file is locked while
+// it is open, so every instance needs its own.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens or creates the store at path.
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open job store %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Create(job Job) error {
+	now := time.Now().UTC()
+	job.Status = StatusPending
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, job)
+	})
+}
+
+func (s *BoltStore) Finish(jobId string, status string, proof []byte, publicInputs []string, errorMessage string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		job, err := get(tx, jobId)
+		if err != nil {
+			return err
+		}
+		job.Status = status
+		job.Input = nil
+		job.Metadata = nil
+		job.Proof = proof
+		job.PublicInputs = publicInputs
+		job.ErrorMessage = errorMessage
+		job.UpdatedAt = time.Now().UTC()
+		return put(tx, job)
+	})
+}
+
+func (s *BoltStore) Get(jobId string) (Job, error) {
+	var job Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		job, err = get(tx, jobId)
+		return err
+	})
+	return job, err
+}
+
+func (s *BoltStore) Delete(jobId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		if bucket.Get([]byte(jobId)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete([]byte(jobId))
+	})
+}
+
+func (s *BoltStore) Pending() ([]Job, error) {
+	var jobs []Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return fmt.Errorf("invalid job record %s: %w", k, err)
+			}
+			if job.Status == StatusPending {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func get(tx *bolt.Tx, jobId string) (Job, error) {
+	var job Job
+	v := tx.Bucket(jobsBucket).Get([]byte(jobId))
+	if v == nil {
+		return job, ErrNotFound
+	}
+	err := json.Unmarshal(v, &job)
+	return job, err
+}
+
+func put(tx *bolt.Tx, job Job) error {
+	v, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(jobsBucket).Put([]byte(job.JobId), v)
+}
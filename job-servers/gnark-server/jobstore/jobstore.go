@@ -0,0 +1,54 @@
+// Package jobstore keeps a durable record of every proof job on local disk,
+// so that jobs survive a Redis restart and operators have an audit log of
+// requests and results.
+package jobstore
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// ErrNotFound is returned by Get, Finish and Delete for unknown job IDs.
+var ErrNotFound = errors.New("job not found")
+
+// Job is the stored record of a proof job.
+type Job struct {
+	JobId string `json:"jobId"`
+	// InputHash is the hex SHA-256 of the submitted proof.
+	InputHash string `json:"inputHash"`
+	// Circuit is the name of the circuit the job is proved with.
+	Circuit string `json:"circuit,omitempty"`
+	Status  string `json:"status"`
+	// Input is the serialized request, kept while the job is pending so
+	// that it can be re-enqueued.
+	Input []byte `json:"input,omitempty"`
+	// Metadata is the Redis metadata the job was queued with, restored
+	// when it is re-enqueued.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Proof is the proof in the layout of the Solidity verifier.
+	Proof        []byte    `json:"proof,omitempty"`
+	PublicInputs []string  `json:"publicInputs,omitempty"`
+	ErrorMessage string    `json:"errorMessage,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// JobStore persists jobs. Implementations must be safe for concurrent use.
+type JobStore interface {
+	// Create records a new pending job.
+	Create(job Job) error
+	// Finish marks a job as done or failed and drops its input.
+	Finish(jobId string, status string, proof []byte, publicInputs []string, errorMessage string) error
+	Get(jobId string) (Job, error)
+	// Delete removes the record of a job.
+	Delete(jobId string) error
+	// Pending returns the jobs that have not finished.
+	Pending() ([]Job, error)
+	Close() error
+}
@@ -2,18 +2,99 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"gnark-server/apichanges"
 	"gnark-server/circuitData"
+	"gnark-server/delivery"
 	"gnark-server/handlers"
+	"gnark-server/jobstore"
+	"gnark-server/metricspush"
+	"gnark-server/middleware"
+	"gnark-server/mirror"
+	"gnark-server/proofcache"
+	pb "gnark-server/proto"
+	"gnark-server/prover"
+	"gnark-server/redisconfig"
+	"gnark-server/routes"
+	"gnark-server/secrets"
+	"gnark-server/tracing"
+	"gnark-server/webhook"
+	"gnark-server/wrapper"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
+	"google.golang.org/grpc"
 )
 
+const listenerShutdownTimeout = 10 * time.Second
+
+// reloadPaths returns the paths /reload-circuit loads from: configured, or
+// the data directory dir without the overrides, which are files of the
+// configured circuit.
+func reloadPaths(configured circuitData.Paths, dir string) circuitData.Paths {
+	if dir == "" {
+		return configured
+	}
+	return circuitData.Paths{Dir: dir, ObjectCacheDir: configured.ObjectCacheDir}
+}
+
+// watchCertificate reloads cert when either of its files changes. A
+// rotation that has replaced only one of them is applied once both match.
+func watchCertificate(watcher *secrets.Watcher, prefix string, cert *routes.Certificate) {
+	reload := func(string) error { return cert.Reload() }
+	watcher.Watch(prefix+"_CERT_FILE", cert.CertFile, reload)
+	watcher.Watch(prefix+"_KEY_FILE", cert.KeyFile, reload)
+}
+
+// serveHTTP runs server until it is shut down, over TLS if it has a
+// TLSConfig.
+func serveHTTP(server *http.Server, name string) {
+	var err error
+	if server.TLSConfig != nil {
+		log.Printf("%s is running on %s with TLS\n", name, server.Addr)
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("%s is running on %s\n", name, server.Addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		panic(err)
+	}
+}
+
+func shutdownListener(server *http.Server, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), listenerShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println(name, "shutdown error:", err)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "prove":
+			os.Exit(runProve(os.Args[2:]))
+		case "snapshot":
+			os.Exit(runSnapshot(os.Args[2:]))
+		case "import-snapshot":
+			os.Exit(runImportSnapshot(os.Args[2:]))
+		case "check-consistency":
+			os.Exit(runCheckConsistency(os.Args[2:]))
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		}
+	}
+
 	godotenv.Load()
 
 	port := os.Getenv("PORT")
@@ -22,18 +103,36 @@ func main() {
 		os.Exit(1)
 	}
 
-	redisURL := os.Getenv("REDIS_URL")
+	// Secrets can be read from files named by a _FILE variable. Files are
+	// watched and rotated secrets are applied where the consumer allows it;
+	// the others are logged as needing a restart.
+	secretsWatchInterval := secrets.DefaultWatchInterval
+	if v := os.Getenv("SECRETS_WATCH_INTERVAL_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			log.Fatal("SECRETS_WATCH_INTERVAL_SECONDS must be a positive integer")
+			return
+		}
+		secretsWatchInterval = time.Duration(seconds) * time.Second
+	}
+	secretWatcher := secrets.NewWatcher(secretsWatchInterval)
+
+	redisURL, redisURLFile, err := secrets.Lookup("REDIS_URL")
+	if err != nil {
+		log.Fatal("REDIS_URL error:", err)
+		return
+	}
 	if redisURL == "" {
 		log.Fatal("REDIS_URL environment variable is not set")
 		return
 	}
-	opt, err := redis.ParseURL(redisURL)
+	// The Redis client and its connection pool are created once.
+	secretWatcher.Watch("REDIS_URL", redisURLFile, nil)
+	rdb, err := redisconfig.Parse(redisURL)
 	if err != nil {
 		log.Fatal("Redis URL parsing error:", err)
 		return
 	}
-
-	rdb := redis.NewClient(opt)
 	ctx := context.Background()
 
 	// Test connection
@@ -43,17 +142,743 @@ func main() {
 		return
 	}
 
-	data := circuitData.InitCircuitData()
+	tracerProvider, shutdownTracing, err := tracing.NewTracerProvider(ctx)
+	if err != nil {
+		log.Fatal("Tracer provider initialization error:", err)
+		return
+	}
+
+	callbackValidator := &webhook.Validator{
+		Probe:               os.Getenv("VALIDATE_CALLBACK") == "probe",
+		AllowPrivateTargets: os.Getenv("CALLBACK_ALLOW_PRIVATE_TARGETS") == "true",
+	}
+	callbackMaxAttempts := 0
+	if v := os.Getenv("CALLBACK_MAX_ATTEMPTS"); v != "" {
+		callbackMaxAttempts, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("CALLBACK_MAX_ATTEMPTS parsing error:", err)
+			return
+		}
+	}
+	var callbackDedupeWindow time.Duration
+	if v := os.Getenv("CALLBACK_DEDUPE_WINDOW_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			log.Fatal("CALLBACK_DEDUPE_WINDOW_SECONDS must be a non-negative integer")
+			return
+		}
+		callbackDedupeWindow = time.Duration(seconds) * time.Second
+	}
+	listCaps, err := handlers.ParseListCaps(os.Getenv("JOB_LIST_CAPS"))
+	if err != nil {
+		log.Fatal("JOB_LIST_CAPS parsing error:", err)
+		return
+	}
+	deliverySinks, err := handlers.ParseDeliverySinks(os.Getenv("DELIVERY_WORKERS"), os.Getenv("DELIVERY_QUEUE_SIZE"))
+	if err != nil {
+		log.Fatal("DELIVERY_WORKERS or DELIVERY_QUEUE_SIZE parsing error:", err)
+		return
+	}
+	var proofEventsIdleTimeout time.Duration
+	if v := os.Getenv("PROOF_EVENTS_IDLE_TIMEOUT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("PROOF_EVENTS_IDLE_TIMEOUT_SECONDS parsing error:", err)
+			return
+		}
+		proofEventsIdleTimeout = time.Duration(seconds) * time.Second
+	}
+
+	resultTTL, failedResultTTL, err := resultTTLsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	maxJobAttempts, err := maxJobAttemptsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+	var retryInputTTL time.Duration
+	if v := os.Getenv("RETRY_INPUT_TTL_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			log.Fatal("RETRY_INPUT_TTL_SECONDS must be a positive integer")
+			return
+		}
+		retryInputTTL = time.Duration(seconds) * time.Second
+	}
+
+	var eventLogMaxLength int64
+	if v := os.Getenv("EVENT_LOG_MAX_LENGTH"); v != "" {
+		eventLogMaxLength, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || eventLogMaxLength < 1 {
+			log.Fatal("EVENT_LOG_MAX_LENGTH must be a positive integer")
+			return
+		}
+	}
+
+	var idempotencyWindow time.Duration
+	if v := os.Getenv("IDEMPOTENCY_WINDOW_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			log.Fatal("IDEMPOTENCY_WINDOW_SECONDS must be a positive integer")
+			return
+		}
+		idempotencyWindow = time.Duration(seconds) * time.Second
+	}
+
+	// API keys or a JWT secret are required unless AUTH_DISABLED is set, so a
+	// deployment that forgets to configure them fails to start instead of
+	// running open.
+	var auth *middleware.Auth
+	if os.Getenv("AUTH_DISABLED") == "true" {
+		log.Println("API key authentication is disabled")
+	} else {
+		apiKeys, apiKeysFile, err := secrets.Lookup("API_KEYS")
+		if err != nil {
+			log.Fatal("API_KEYS error:", err)
+			return
+		}
+		jwtSecret, jwtSecretFile, err := secrets.Lookup("JWT_SECRET")
+		if err != nil {
+			log.Fatal("JWT_SECRET error:", err)
+			return
+		}
+		if apiKeys != "" || jwtSecret == "" {
+			auth, err = middleware.ParseAPIKeys(apiKeys)
+			if err != nil {
+				log.Fatal("API_KEYS parsing error:", err)
+				return
+			}
+			secretWatcher.Watch("API_KEYS", apiKeysFile, auth.Rotate)
+		}
+		if jwtSecret != "" {
+			if auth == nil {
+				auth, err = middleware.JWTAuth(jwtSecret)
+			} else {
+				err = auth.SetJWTSecret(jwtSecret)
+			}
+			if err != nil {
+				log.Fatal("JWT_SECRET error:", err)
+				return
+			}
+			auth.JWTIssuer = os.Getenv("JWT_ISSUER")
+			secretWatcher.Watch("JWT_SECRET", jwtSecretFile, auth.SetJWTSecret)
+		}
+	}
+
+	rateLimiter, err := rateLimiterFromEnv(rdb)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	var maxQueueLength int64
+	if v := os.Getenv("MAX_QUEUE_LENGTH"); v != "" {
+		maxQueueLength, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || maxQueueLength < 1 {
+			log.Fatal("MAX_QUEUE_LENGTH must be a positive integer")
+			return
+		}
+	}
+
+	var proveTimeout time.Duration
+	if v := os.Getenv("PROVE_TIMEOUT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			log.Fatal("PROVE_TIMEOUT_SECONDS must be a positive integer")
+			return
+		}
+		proveTimeout = time.Duration(seconds) * time.Second
+	}
+	maxProveTimeout := handlers.DefaultMaxProveTimeout
+	if v := os.Getenv("MAX_PROVE_TIMEOUT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			log.Fatal("MAX_PROVE_TIMEOUT_SECONDS must be a positive integer")
+			return
+		}
+		maxProveTimeout = time.Duration(seconds) * time.Second
+	}
+	if proveTimeout > maxProveTimeout {
+		log.Fatal("PROVE_TIMEOUT_SECONDS must not exceed MAX_PROVE_TIMEOUT_SECONDS")
+		return
+	}
+	deadlinePolicy := os.Getenv("DEADLINE_POLICY")
+	if deadlinePolicy != "" && !handlers.ValidDeadlinePolicy(deadlinePolicy) {
+		log.Fatal("DEADLINE_POLICY must be expire or flag")
+		return
+	}
+	maxPublicInputs := prover.DefaultMaxPublicInputs
+	if v := os.Getenv("MAX_PUBLIC_INPUTS"); v != "" {
+		maxPublicInputs, err = strconv.Atoi(v)
+		if err != nil || maxPublicInputs < 1 {
+			log.Fatal("MAX_PUBLIC_INPUTS must be a positive integer")
+			return
+		}
+	}
+	maxProveWait := handlers.DefaultMaxProveWait
+	if v := os.Getenv("MAX_PROVE_WAIT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			log.Fatal("MAX_PROVE_WAIT_SECONDS must be a positive integer")
+			return
+		}
+		maxProveWait = time.Duration(seconds) * time.Second
+	}
+
+	var shard *handlers.Shard
+	if v := os.Getenv("SHARD_COUNT"); v != "" {
+		count, err := strconv.Atoi(v)
+		if err != nil || count < 1 {
+			log.Fatal("SHARD_COUNT must be a positive integer")
+			return
+		}
+		index, err := strconv.Atoi(os.Getenv("SHARD_INDEX"))
+		if err != nil {
+			log.Fatal("SHARD_INDEX must be set to an integer when SHARD_COUNT is set")
+			return
+		}
+		fallbackBacklog := int64(handlers.DefaultShardFallbackBacklog)
+		if v := os.Getenv("SHARD_FALLBACK_BACKLOG"); v != "" {
+			fallbackBacklog, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				log.Fatal("SHARD_FALLBACK_BACKLOG parsing error:", err)
+				return
+			}
+		}
+		shard, err = handlers.NewShard(count, index, fallbackBacklog)
+		if err != nil {
+			log.Fatal("Shard configuration error:", err)
+			return
+		}
+		log.Printf("Claiming jobs of shard %d of %d\n", index, count)
+	}
+
+	maxWebSocketConnections := int64(handlers.DefaultMaxWebSocketConnections)
+	if v := os.Getenv("MAX_WS_CONNECTIONS"); v != "" {
+		maxWebSocketConnections, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || maxWebSocketConnections < 1 {
+			log.Fatal("MAX_WS_CONNECTIONS must be a positive integer")
+			return
+		}
+	}
+
+	provingBackend := os.Getenv("PROVING_BACKEND")
+	if provingBackend == "" {
+		provingBackend = circuitData.BackendPlonk
+	}
+	circuitPaths := circuitData.PathsFromEnv()
+	if circuitPaths.HasOverrides() {
+		// The overridden files live outside of the data directory, which
+		// is loaded in place.
+		log.Printf("Loading %s with the CIRCUIT_*_PATH overrides, its layout is not migrated\n", circuitPaths.DataDir())
+	} else if err := circuitData.MigrateLegacyLayouts(circuitPaths.DataDir(), provingBackend, os.Getenv("LAYOUT_MIGRATION_DRY_RUN") == "true"); err != nil {
+		log.Fatal("Circuit data migration error:", err)
+		return
+	}
+	fastKeyLoad := os.Getenv("FAST_KEY_LOAD") == "true"
+	circuits, err := circuitData.InitCircuitData(circuitPaths, provingBackend, fastKeyLoad)
+	verifyOnly := false
+	if errors.Is(err, circuitData.ErrProvingKey) && os.Getenv("DEGRADED_VERIFY_ONLY") == "true" {
+		log.Println("WARNING: Circuit data error:", err)
+		log.Println("WARNING: Starting in verify-only mode, new proofs will be refused")
+		verifyOnly = true
+		err = nil
+	}
+	if errors.Is(err, circuitData.ErrStaleCache) {
+		log.Fatal("Circuit data error: ", err, " (run `PROVING_BACKEND=", provingBackend, " go run setup/main.go`)")
+		return
+	} else if err != nil {
+		log.Fatal("Circuit data error:", err)
+		return
+	}
+	for name, data := range circuits {
+		log.Printf("Loaded circuit %s, release %s\n", name, data.ReleaseId)
+	}
 	state := &handlers.State{
-		CircuitData: data,
-		RedisClient: rdb,
+		Circuits: circuits,
+		LoadCircuits: func(dir string) (circuitData.Registry, error) {
+			return circuitData.InitCircuitData(reloadPaths(circuitPaths, dir), provingBackend, fastKeyLoad)
+		},
+		LoadCircuit: func(dir string, name string) (circuitData.CircuitData, error) {
+			return circuitData.InitCircuit(reloadPaths(circuitPaths, dir), name, provingBackend, fastKeyLoad)
+		},
+		RedisClient:       rdb,
+		Metrics:           handlers.NewMetrics(rdb),
+		TracerProvider:    tracerProvider,
+		CallbackValidator: callbackValidator,
+		CallbackDeliverer: &webhook.Deliverer{
+			Validator:   callbackValidator,
+			MaxAttempts: callbackMaxAttempts,
+		},
+		CallbackDedupeWindow:    callbackDedupeWindow,
+		ListCaps:                listCaps,
+		ProofEventsIdleTimeout:  proofEventsIdleTimeout,
+		StoreInputs:             os.Getenv("STORE_INPUTS") == "true",
+		ResultTTL:               resultTTL,
+		FailedResultTTL:         failedResultTTL,
+		MaxJobAttempts:          maxJobAttempts,
+		RetryInputTTL:           retryInputTTL,
+		EventLogMaxLength:       eventLogMaxLength,
+		IdempotencyWindow:       idempotencyWindow,
+		MaxQueueLength:          maxQueueLength,
+		ProveTimeout:            proveTimeout,
+		MaxProveTimeout:         maxProveTimeout,
+		DeadlinePolicy:          deadlinePolicy,
+		MaxProveWait:            maxProveWait,
+		MaxPublicInputs:         maxPublicInputs,
+		MaxWebSocketConnections: maxWebSocketConnections,
+		Shard:                   shard,
+		VerifyOnly:              verifyOnly,
+		BuildCommit:             buildCommit(),
 	}
+	state.Metrics.SetShard(shard)
 
-	http.HandleFunc("/health", handlers.HealthHandler)
-	http.HandleFunc("/start-proof", state.StartProof)
-	http.HandleFunc("/get-proof", state.GetProof)
-	log.Println("Server is running on port " + port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		panic(err)
+	if v := os.Getenv("PROOF_CACHE_TTL_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			log.Fatal("PROOF_CACHE_TTL_SECONDS must be a positive integer")
+			return
+		}
+		state.ProofCache = proofcache.New(rdb, time.Duration(seconds)*time.Second)
+	}
+
+	mirrorURL, mirrorURLFile, err := secrets.Lookup("MIRROR_DATABASE_URL")
+	if err != nil {
+		log.Fatal("MIRROR_DATABASE_URL error:", err)
+		return
+	}
+	if mirrorURL != "" {
+		state.Mirror, err = mirror.Open(ctx, mirrorURL)
+		if err != nil {
+			log.Fatal("Mirror database error:", err)
+			return
+		}
+		// The connection pool of the mirror is opened once.
+		secretWatcher.Watch("MIRROR_DATABASE_URL", mirrorURLFile, nil)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "mirror-backfill" {
+		if state.Mirror == nil {
+			log.Fatal("MIRROR_DATABASE_URL environment variable is not set")
+			return
+		}
+		mirrored, err := state.BackfillMirror(ctx)
+		if err != nil {
+			log.Fatal("Mirror backfill error:", err)
+			return
+		}
+		log.Printf("Mirrored %d jobs\n", mirrored)
+		state.Mirror.Close(ctx)
+		return
+	}
+
+	if jobStorePath := os.Getenv("JOB_STORE_PATH"); jobStorePath != "" {
+		state.JobStore, err = jobstore.OpenBolt(jobStorePath)
+		if err != nil {
+			log.Fatal("Job store error:", err)
+			return
+		}
+	}
+
+	// Callbacks and mirror writes run on bounded per-sink workers; what does
+	// not fit waits in Redis.
+	state.RegisterDeliverySinks(delivery.New(rdb), deliverySinks)
+
+	// The instance ID names this instance in the fleet registry, in the
+	// leases of its jobs and in pushed metrics.
+	state.InstanceId = os.Getenv("INSTANCE_ID")
+	if state.InstanceId == "" {
+		state.InstanceId, err = os.Hostname()
+		if err != nil {
+			log.Fatal("INSTANCE_ID is not set and the hostname could not be read:", err)
+			return
+		}
+	}
+	if v := os.Getenv("FLEET_HEARTBEAT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			log.Fatal("FLEET_HEARTBEAT_SECONDS must be a positive integer")
+			return
+		}
+		state.FleetHeartbeatInterval = time.Duration(seconds) * time.Second
+	}
+
+	var metricsPusher *metricspush.Pusher
+	if mode := os.Getenv("METRICS_PUSH"); mode != "" {
+		interval := 30 * time.Second
+		if v := os.Getenv("METRICS_PUSH_INTERVAL_SECONDS"); v != "" {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds < 1 {
+				log.Fatal("METRICS_PUSH_INTERVAL_SECONDS must be a positive integer")
+				return
+			}
+			interval = time.Duration(seconds) * time.Second
+		}
+		var sink metricspush.Sink
+		switch mode {
+		case "redis":
+			// Keep a snapshot for a few intervals, so that collectors see
+			// instances that stopped pushing disappear.
+			sink = &metricspush.RedisSink{Client: rdb, TTL: 3 * interval}
+		case "remote_write":
+			pushURL := os.Getenv("METRICS_PUSH_URL")
+			if pushURL == "" {
+				log.Fatal("METRICS_PUSH_URL must be set when METRICS_PUSH=remote_write")
+				return
+			}
+			bearerToken, bearerTokenFile, err := secrets.Lookup("METRICS_PUSH_BEARER_TOKEN")
+			if err != nil {
+				log.Fatal("METRICS_PUSH_BEARER_TOKEN error:", err)
+				return
+			}
+			// The sink is configured once.
+			secretWatcher.Watch("METRICS_PUSH_BEARER_TOKEN", bearerTokenFile, nil)
+			sink = &metricspush.RemoteWriteSink{URL: pushURL, BearerToken: bearerToken}
+		default:
+			log.Fatal("METRICS_PUSH must be redis or remote_write")
+			return
+		}
+		metricsPusher = state.Metrics.StartPush(sink, state.InstanceId, interval)
+		log.Printf("Pushing metrics of instance %s to %s every %v\n", state.InstanceId, mode, interval)
+	}
+
+	var wrapperSource wrapper.Source
+	if path := os.Getenv("WRAPPER_SOURCE_FILE"); path != "" {
+		wrapperSource = wrapper.SourceFile{Path: path}
+	} else if address := os.Getenv("WRAPPER_ADDRESS"); address != "" {
+		rpcURL := os.Getenv("WRAPPER_RPC_URL")
+		if rpcURL == "" {
+			log.Fatal("WRAPPER_RPC_URL must be set when WRAPPER_ADDRESS is set")
+			return
+		}
+		wrapperSource = wrapper.Contract{RPCURL: rpcURL, Address: address, Getter: os.Getenv("WRAPPER_DIGEST_GETTER")}
+	}
+	if wrapperSource != nil {
+		if err := state.CheckWrapperDigest(ctx, wrapperSource, os.Getenv("WRAPPER_CIRCUIT")); err != nil {
+			log.Fatal("WRAPPER_CIRCUIT error:", err)
+			return
+		}
+	}
+
+	shutdownTimeout := 120 * time.Second
+	if v := os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatal("SHUTDOWN_TIMEOUT_SECONDS parsing error:", err)
+			return
+		}
+		shutdownTimeout = time.Duration(seconds) * time.Second
+	}
+
+	workerCount := 1
+	if v := os.Getenv("WORKER_COUNT"); v != "" {
+		workerCount, err = strconv.Atoi(v)
+		if err != nil || workerCount < 1 {
+			log.Fatal("WORKER_COUNT must be a positive integer")
+			return
+		}
+	}
+	recovered, err := state.RecoverPendingJobs(ctx)
+	if err != nil {
+		log.Fatal("Job store recovery error:", err)
+		return
+	}
+	if recovered > 0 {
+		log.Printf("Re-enqueued %d jobs from the job store\n", recovered)
+	}
+	// Join the fleet registry and keep the registration fresh until
+	// shutdown. This must happen before RecoverInterrupted below, which
+	// tells the leases of dead instances from those of live ones by the
+	// registry.
+	leaveFleet := state.StartFleetHeartbeat()
+	log.Printf("Registered in the fleet as instance %s\n", state.InstanceId)
+	go func() {
+		requeued, failed, err := state.RecoverInterrupted(ctx, maxJobAttempts)
+		if err != nil {
+			log.Println("Interrupted job recovery error:", err)
+		} else if requeued > 0 || failed > 0 {
+			log.Printf("Re-enqueued %d interrupted jobs and failed %d\n", requeued, failed)
+		}
+	}()
+	if state.VerifyOnly {
+		log.Println("Not starting proving workers in verify-only mode")
+	} else {
+		for i := 0; i < workerCount; i++ {
+			state.StartWorker(i)
+		}
+		log.Printf("Started %d proving workers\n", workerCount)
+	}
+
+	var grpcServer *grpc.Server
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatal("gRPC listen error:", err)
+			return
+		}
+		grpcServer = grpc.NewServer(grpc.UnaryInterceptor(
+			auth.UnaryInterceptor(pb.GnarkServer_Health_FullMethodName),
+		))
+		pb.RegisterGnarkServerServer(grpcServer, &handlers.GRPCServer{State: state})
+		go func() {
+			log.Println("gRPC server is running on port " + grpcPort)
+			if err := grpcServer.Serve(lis); err != nil {
+				panic(err)
+			}
+		}()
+	}
+
+	publicStatusFields, err := handlers.ParsePublicStatusFields(os.Getenv("PUBLIC_STATUS_FIELDS"))
+	if err != nil {
+		log.Fatal("PUBLIC_STATUS_FIELDS parsing error:", err)
+		return
+	}
+	trustedProxyDepth, err := trustedProxyDepthFromEnv()
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	httpRoutes := []routes.Route{
+		{Pattern: "/health", Scope: routes.Shared, Handler: http.HandlerFunc(state.HealthHandler)},
+		{Pattern: "/health/ready", Scope: routes.Shared, Handler: http.HandlerFunc(state.ReadyHandler)},
+		{Pattern: "/version", Scope: routes.Shared, Handler: http.HandlerFunc(state.VersionHandler)},
+		{Pattern: "/circuit-info", Scope: routes.Shared, Handler: http.HandlerFunc(state.CircuitInfo)},
+		{Pattern: "/api-changes", Scope: routes.Shared, Handler: http.HandlerFunc(handlers.APIChangesHandler)},
+		{Pattern: "/metrics", Scope: routes.Public, Handler: state.Metrics.Handler()},
+		{Pattern: "/public-status", Scope: routes.Public, Handler: handlers.NewPublicStatus(state, publicStatusFields, trustedProxyDepth)},
+		{Pattern: "/start-proof", Scope: routes.Public, Handler: auth.Require(rateLimiter.Limit(state.StartProof))},
+		{Pattern: "/start-proofs", Scope: routes.Public, Handler: auth.Require(rateLimiter.Limit(state.StartProofs))},
+		{Pattern: "/prove", Scope: routes.Public, Handler: auth.Require(rateLimiter.Limit(state.Prove))},
+		{Pattern: "/get-proof", Scope: routes.Public, Handler: auth.Require(state.GetProof)},
+		{Pattern: "/retry-proof", Scope: routes.Public, Handler: auth.Require(rateLimiter.Limit(state.RetryProof))},
+		{Pattern: "/proof-events", Scope: routes.Public, Handler: auth.Require(state.ProofEvents)},
+		{Pattern: "/proof-ws", Scope: routes.Public, Handler: auth.Require(state.ProofWebSocket)},
+		{Pattern: "/jobs/", Scope: routes.Public, Handler: auth.Require(state.Jobs)},
+		{Pattern: "/stats", Scope: routes.Public, Handler: auth.Require(state.Stats)},
+		{Pattern: "/estimate-prove-time", Scope: routes.Public, Handler: auth.Require(state.EstimateProveTime)},
+	}
+
+	// Admin endpoints are only served on the admin listener, which runs when
+	// ADMIN_PORT and ADMIN_TOKEN are set.
+	adminPort := os.Getenv("ADMIN_PORT")
+	adminTokenValue, adminTokenFile, err := secrets.Lookup("ADMIN_TOKEN")
+	if err != nil {
+		log.Fatal("ADMIN_TOKEN error:", err)
+		return
+	}
+	var adminRoutes *routes.Table
+	if adminPort != "" || adminTokenValue != "" {
+		if adminPort == "" || adminTokenValue == "" {
+			log.Fatal("ADMIN_PORT and ADMIN_TOKEN must be set together")
+			return
+		}
+		if adminPort == port {
+			log.Fatal("ADMIN_PORT must differ from PORT")
+			return
+		}
+		adminToken := middleware.NewAdminToken(adminTokenValue)
+		secretWatcher.Watch("ADMIN_TOKEN", adminTokenFile, adminToken.Rotate)
+		adminRoutes = routes.NewTable(routes.Admin)
+		httpRoutes = append(httpRoutes,
+			routes.Route{Pattern: "/export-verifier", Scope: routes.Admin, Handler: middleware.RequireAdminToken(adminToken, state.ExportVerifier)},
+			routes.Route{Pattern: "/test-vectors", Scope: routes.Admin, Handler: middleware.RequireAdminToken(adminToken, state.TestVectorsHandler)},
+			routes.Route{Pattern: "/dashboard/", Scope: routes.Admin, Handler: middleware.RequireAdminLogin(adminToken, handlers.DashboardHandler())},
+			routes.Route{Pattern: "/dashboard/api/summary", Scope: routes.Admin, Handler: middleware.RequireAdminLogin(adminToken, http.HandlerFunc(state.DashboardSummaryHandler))},
+			routes.Route{Pattern: "/dashboard/api/jobs", Scope: routes.Admin, Handler: middleware.RequireAdminLogin(adminToken, http.HandlerFunc(state.DashboardJobsHandler))},
+			routes.Route{Pattern: "/dead-letter-jobs", Scope: routes.Admin, Handler: middleware.RequireAdminToken(adminToken, state.DeadLetterJobsHandler)},
+			routes.Route{Pattern: "/dead-letter-jobs/retry", Scope: routes.Admin, Handler: middleware.RequireAdminToken(adminToken, state.RetryDeadLetterHandler)},
+			routes.Route{Pattern: "/reload-secrets", Scope: routes.Admin, Handler: middleware.RequireAdminToken(adminToken, state.ReloadSecretsHandler)},
+			routes.Route{Pattern: "/reload-circuit", Scope: routes.Admin, Handler: middleware.RequireAdminToken(adminToken, state.ReloadCircuitHandler)},
+			routes.Route{Pattern: "/events", Scope: routes.Admin, Handler: middleware.RequireAdminToken(adminToken, state.EventLogHandler)},
+			routes.Route{Pattern: "/proof", Scope: routes.Admin, Handler: middleware.RequireAdminToken(adminToken, state.DeleteProof)},
+			routes.Route{Pattern: "/fleet", Scope: routes.Admin, Handler: middleware.RequireAdminToken(adminToken, state.FleetHandler)},
+		)
+	}
+	// The OpenAPI description is built from the routes actually served, and
+	// building it fails if one is not described or an example is stale.
+	openAPIPatterns := []string{"/openapi.json"}
+	for _, route := range httpRoutes {
+		openAPIPatterns = append(openAPIPatterns, route.Pattern)
+	}
+	openAPIDoc, err := handlers.BuildOpenAPI(openAPIPatterns)
+	if err != nil {
+		log.Fatal("OpenAPI description error:", err)
+		return
+	}
+	openAPIHandler, err := handlers.OpenAPIHandler(openAPIDoc)
+	if err != nil {
+		log.Fatal("OpenAPI description error:", err)
+		return
+	}
+	httpRoutes = append(httpRoutes, routes.Route{Pattern: "/openapi.json", Scope: routes.Shared, Handler: openAPIHandler})
+
+	publicRoutes := routes.NewTable(routes.Public)
+	if err := routes.Register(httpRoutes, publicRoutes, adminRoutes); err != nil {
+		log.Fatal("Route table error:", err)
+		return
+	}
+	// Every route must be announced in the API change registry before it
+	// ships.
+	servedPatterns := publicRoutes.Patterns()
+	if adminRoutes != nil {
+		servedPatterns = append(servedPatterns, adminRoutes.Patterns()...)
+	}
+	if err := apichanges.Validate(servedPatterns); err != nil {
+		log.Fatal("API change registry error:", err)
+		return
+	}
+
+	maxDecompressedBody := int64(middleware.DefaultMaxDecompressedBody)
+	if v := os.Getenv("MAX_DECOMPRESSED_BODY_BYTES"); v != "" {
+		maxDecompressedBody, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || maxDecompressedBody < 1 {
+			log.Fatal("MAX_DECOMPRESSED_BODY_BYTES must be a positive integer")
+			return
+		}
+	}
+
+	// CORS lets browser frontends call the public listener directly. It is
+	// off unless origins are configured.
+	var corsConfig middleware.CORSConfig
+	for _, origin := range strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			corsConfig.AllowedOrigins = append(corsConfig.AllowedOrigins, origin)
+		}
+	}
+	for _, header := range strings.Split(os.Getenv("CORS_ALLOWED_HEADERS"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			corsConfig.AllowedHeaders = append(corsConfig.AllowedHeaders, header)
+		}
+	}
+	corsConfig.AllowAll = os.Getenv("CORS_ALLOW_ALL") == "true"
+	if err := corsConfig.Validate(); err != nil {
+		log.Fatal("CORS_ALLOWED_ORIGINS error:", err)
+		return
+	}
+	var publicHandler http.Handler = middleware.Gzip(maxDecompressedBody, middleware.APIVersion(publicRoutes))
+	if corsConfig.Enabled() {
+		publicHandler = middleware.CORS(corsConfig, publicHandler)
+		if corsConfig.AllowAll {
+			log.Println("WARNING: CORS_ALLOW_ALL is set, any website may call the API from a browser")
+		} else {
+			log.Println("Allowing cross-origin requests from", strings.Join(corsConfig.AllowedOrigins, ", "))
+		}
+	}
+
+	server := &http.Server{Addr: ":" + port, Handler: publicHandler}
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if tlsDomain := os.Getenv("TLS_DOMAIN"); tlsDomain != "" {
+		if certFile != "" || keyFile != "" {
+			log.Fatal("TLS_DOMAIN cannot be used with TLS_CERT_FILE and TLS_KEY_FILE")
+			return
+		}
+		var domains []string
+		for _, domain := range strings.Split(tlsDomain, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		cacheDir := os.Getenv("ACME_CACHE_DIR")
+		if cacheDir == "" {
+			cacheDir = "./acme-cache"
+		}
+		server.TLSConfig, err = routes.AutocertTLSConfig(domains, os.Getenv("ACME_EMAIL"), cacheDir)
+		if err != nil {
+			log.Fatal("TLS_DOMAIN error:", err)
+			return
+		}
+		log.Printf("Obtaining certificates for %s from Let's Encrypt, cached in %s\n", strings.Join(domains, ", "), cacheDir)
+	} else if certFile != "" || keyFile != "" {
+		var cert *routes.Certificate
+		server.TLSConfig, cert, err = routes.TLSConfig(certFile, keyFile, "")
+		if err != nil {
+			log.Fatal("TLS configuration error:", err)
+			return
+		}
+		watchCertificate(secretWatcher, "TLS", cert)
+	}
+	server.RegisterOnShutdown(state.CloseStreams)
+	go serveHTTP(server, "Server")
+
+	var adminServer *http.Server
+	if adminRoutes != nil {
+		adminServer = &http.Server{Addr: ":" + adminPort, Handler: middleware.Gzip(maxDecompressedBody, middleware.APIVersion(adminRoutes))}
+		certFile, keyFile := os.Getenv("ADMIN_TLS_CERT_FILE"), os.Getenv("ADMIN_TLS_KEY_FILE")
+		clientCAFile := os.Getenv("ADMIN_TLS_CLIENT_CA_FILE")
+		if certFile != "" || keyFile != "" || clientCAFile != "" {
+			var cert *routes.Certificate
+			adminServer.TLSConfig, cert, err = routes.TLSConfig(certFile, keyFile, clientCAFile)
+			if err != nil {
+				log.Fatal("Admin TLS configuration error:", err)
+				return
+			}
+			watchCertificate(secretWatcher, "ADMIN_TLS", cert)
+			// The client CA pool is built once.
+			secretWatcher.Watch("ADMIN_TLS_CLIENT_CA_FILE", clientCAFile, nil)
+		}
+		go serveHTTP(adminServer, "Admin server")
+	}
+
+	state.Secrets = secretWatcher
+	secretWatcher.Start()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigCh
+	log.Printf("Received %v, draining in-flight jobs (timeout %v)\n", sig, shutdownTimeout)
+
+	// Stop taking new work first and let the workers finish while GetProof
+	// and health keep serving, then close the listeners.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	state.StopAccepting()
+	exitCode := 0
+	if err := state.WaitForJobs(shutdownCtx); err != nil {
+		log.Println("Timed out waiting for in-flight jobs:", err)
+		state.RequeueRunning(context.Background())
+		exitCode = 1
+	} else {
+		log.Println("All in-flight jobs finished")
+	}
+	leaveFleet()
+
+	// Deliveries of the jobs finished above go out before the mirror is
+	// closed; the ones that do not make it in time are kept in Redis.
+	if err := state.Deliveries.Close(shutdownCtx); err != nil {
+		log.Println("Timed out draining deliveries:", err)
+		exitCode = 1
+	}
+	if state.Mirror != nil {
+		if err := state.Mirror.Close(shutdownCtx); err != nil {
+			log.Println("Mirror shutdown error:", err)
+		}
+	}
+	if state.JobStore != nil {
+		if err := state.JobStore.Close(); err != nil {
+			log.Println("Job store shutdown error:", err)
+		}
+	}
+	if metricsPusher != nil {
+		// Push a final snapshot so that the drain shows up in the collector.
+		if err := metricsPusher.Close(shutdownCtx); err != nil {
+			log.Println("Metrics push shutdown error:", err)
+		}
+	}
+
+	// Close the public listener first and the admin listener last, so that
+	// operators can watch the drain until the end. Each gets its own timeout.
+	shutdownListener(server, "HTTP server")
+	if adminServer != nil {
+		shutdownListener(adminServer, "Admin server")
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
 	}
+	secretWatcher.Stop()
+	shutdownTracing(context.Background())
+	os.Exit(exitCode)
 }
@@ -0,0 +1,118 @@
+// Package metrics decides which values may be used as metric labels.
+//
+// Every distinct label value creates a new time series, so label values must
+// come from sets the server controls. The rule is:
+//
+//   - Values from closed server-side sets (registered circuits, defined
+//     priorities) may be used as labels as they are. Use a ClosedSet.
+//   - Values from server-side sets that grow with configuration, such as API
+//     key IDs, may be used up to a cap. Use a CappedSet.
+//   - Client-supplied free-form values (tags, names, anything read from a
+//     request body) must never be used as labels. Use a FreeForm, which
+//     always returns Other and only counts how many distinct values it saw.
+//
+// Code that adds a label must obtain its value from one of these types rather
+// than passing strings through.
+package metrics
+
+import (
+	"hash/maphash"
+	"math"
+	"sync"
+)
+
+// Other is the label value that stands in for every value that may not be
+// used as a label.
+const Other = "other"
+
+// ClosedSet admits a fixed set of label values.
+type ClosedSet struct {
+	values map[string]struct{}
+}
+
+func NewClosedSet(values ...string) *ClosedSet {
+	set := &ClosedSet{values: make(map[string]struct{}, len(values))}
+	for _, v := range values {
+		set.values[v] = struct{}{}
+	}
+	return set
+}
+
+// Label returns value if it belongs to the set and Other otherwise.
+func (s *ClosedSet) Label(value string) string {
+	if _, ok := s.values[value]; ok {
+		return value
+	}
+	return Other
+}
+
+// CappedSet admits the first Cap distinct values it sees and maps every
+// later value to Other.
+type CappedSet struct {
+	cap    int
+	mu     sync.Mutex
+	values map[string]struct{}
+}
+
+func NewCappedSet(cap int) *CappedSet {
+	return &CappedSet{cap: cap, values: map[string]struct{}{}}
+}
+
+// Label returns value if it was already admitted or there is room to admit
+// it, and Other otherwise.
+func (s *CappedSet) Label(value string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[value]; ok {
+		return value
+	}
+	if len(s.values) >= s.cap {
+		return Other
+	}
+	s.values[value] = struct{}{}
+	return value
+}
+
+// freeFormBits is the size of the bitmap used to estimate the number of
+// distinct free-form values, 8 KiB regardless of how many values are seen.
+const freeFormBits = 1 << 16
+
+// freeFormSeed seeds the hash of free-form values. The estimate needs the
+// low bits of the hash to be uniform, which FNV does not give for values that
+// only differ at the end, such as numbered tags.
+var freeFormSeed = maphash.MakeSeed()
+
+// FreeForm guards a client-supplied value. Its label is always Other; the
+// values are only counted, using linear counting over a fixed size bitmap so
+// that memory stays bounded too.
+type FreeForm struct {
+	mu     sync.Mutex
+	bitmap [freeFormBits / 64]uint64
+	set    int
+}
+
+// Label records value and returns Other.
+func (f *FreeForm) Label(value string) string {
+	bit := maphash.String(freeFormSeed, value) % freeFormBits
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	word, mask := bit/64, uint64(1)<<(bit%64)
+	if f.bitmap[word]&mask == 0 {
+		f.bitmap[word] |= mask
+		f.set++
+	}
+	return Other
+}
+
+// Distinct estimates how many distinct values were passed to Label. The
+// estimate is accurate to a few percent up to tens of thousands of values.
+func (f *FreeForm) Distinct() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	empty := freeFormBits - f.set
+	if empty == 0 {
+		// The bitmap is saturated; report the largest value it can estimate.
+		return freeFormBits * math.Log(freeFormBits)
+	}
+	return -freeFormBits * math.Log(float64(empty)/freeFormBits)
+}
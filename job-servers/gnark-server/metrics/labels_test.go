@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestLabelSpaceStaysBounded(t *testing.T) {
+	const tags = 20000
+	closed := NewClosedSet("claim", "withdrawal")
+	capped := NewCappedSet(10)
+	var freeForm FreeForm
+	labels := map[string]map[string]bool{"closed": {}, "capped": {}, "freeForm": {}}
+	for i := 0; i < tags; i++ {
+		tag := fmt.Sprintf("client-tag-%d", i)
+		labels["closed"][closed.Label(tag)] = true
+		labels["capped"][capped.Label(tag)] = true
+		labels["freeForm"][freeForm.Label(tag)] = true
+	}
+	labels["closed"][closed.Label("claim")] = true
+
+	if got := len(labels["closed"]); got != 2 || !labels["closed"]["claim"] || !labels["closed"][Other] {
+		t.Errorf("closed set labels %v, want claim and %s", labels["closed"], Other)
+	}
+	if got := len(labels["capped"]); got != 11 || !labels["capped"][Other] {
+		t.Errorf("capped set has %d labels, want the 10 first values and %s", got, Other)
+	}
+	if got := capped.Label("client-tag-0"); got != "client-tag-0" {
+		t.Errorf("capped set label of an admitted value = %q, want it kept", got)
+	}
+	if got := len(labels["freeForm"]); got != 1 || !labels["freeForm"][Other] {
+		t.Errorf("free-form labels %v, want only %s", labels["freeForm"], Other)
+	}
+	if distinct := freeForm.Distinct(); math.Abs(distinct-tags)/tags > 0.05 {
+		t.Errorf("free-form distinct estimate %.0f, want %d within 5%%", distinct, tags)
+	}
+}
+
+func TestFreeFormCountsRepeatsOnce(t *testing.T) {
+	var freeForm FreeForm
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				freeForm.Label(fmt.Sprint("tag-", i%100))
+			}
+		}()
+	}
+	wg.Wait()
+	if distinct := freeForm.Distinct(); math.Round(distinct) < 99 || math.Round(distinct) > 101 {
+		t.Fatalf("Distinct() = %.1f, want about 100", distinct)
+	}
+}
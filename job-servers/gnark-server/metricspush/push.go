@@ -0,0 +1,171 @@
+// Package metricspush periodically pushes the server's metrics for
+// environments where /metrics cannot be scraped. Snapshots are written either
+// to a Redis key that a central collector reads, or to a Prometheus
+// remote_write endpoint.
+package metricspush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	// RedisKeyPrefix is the prefix of the Redis key holding the latest
+	// snapshot of each instance.
+	RedisKeyPrefix = "gnark_metrics_snapshot:"
+
+	maxPushTimeout = 10 * time.Second
+)
+
+// Snapshot is the state of the metrics of one instance at one time.
+type Snapshot struct {
+	Instance  string
+	Timestamp time.Time
+	Families  []*dto.MetricFamily
+}
+
+// snapshotJSON is the encoding of a Snapshot: the metric families are kept in
+// the Prometheus text format, which collectors can parse with the standard
+// libraries.
+type snapshotJSON struct {
+	Instance  string    `json:"instance"`
+	Timestamp time.Time `json:"timestamp"`
+	Metrics   string    `json:"metrics"`
+}
+
+// Encode returns the JSON encoding of s.
+func (s *Snapshot) Encode() ([]byte, error) {
+	var text bytes.Buffer
+	for _, family := range s.Families {
+		if _, err := expfmt.MetricFamilyToText(&text, family); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(snapshotJSON{Instance: s.Instance, Timestamp: s.Timestamp.UTC(), Metrics: text.String()})
+}
+
+// DecodeSnapshot decodes a snapshot written by Encode. Families are sorted by
+// name, as the gatherer returns them.
+func DecodeSnapshot(data []byte) (*Snapshot, error) {
+	var encoded snapshotJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(encoded.Metrics))
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics in snapshot: %w", err)
+	}
+	s := &Snapshot{Instance: encoded.Instance, Timestamp: encoded.Timestamp}
+	for _, family := range families {
+		s.Families = append(s.Families, family)
+	}
+	sort.Slice(s.Families, func(i, j int) bool { return s.Families[i].GetName() < s.Families[j].GetName() })
+	return s, nil
+}
+
+// Sink receives pushed snapshots.
+type Sink interface {
+	Push(ctx context.Context, s *Snapshot) error
+}
+
+// RedisSink stores the latest snapshot of each instance under RedisKeyPrefix
+// followed by the instance ID. Keys expire after TTL, so that instances that
+// stopped pushing disappear.
+type RedisSink struct {
+	Client redis.UniversalClient
+	TTL    time.Duration
+}
+
+func (r *RedisSink) Push(ctx context.Context, s *Snapshot) error {
+	data, err := s.Encode()
+	if err != nil {
+		return err
+	}
+	return r.Client.Set(ctx, RedisKeyPrefix+s.Instance, data, r.TTL).Err()
+}
+
+// Pusher gathers the metrics and pushes them to a sink at a fixed interval.
+// Failures are reported to onError and never stop the pusher.
+type Pusher struct {
+	gatherer prometheus.Gatherer
+	sink     Sink
+	instance string
+	interval time.Duration
+	onError  func(error)
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Start pushes the metrics of gatherer to sink every interval until Close.
+func Start(gatherer prometheus.Gatherer, sink Sink, instance string, interval time.Duration, onError func(error)) *Pusher {
+	p := &Pusher{
+		gatherer: gatherer,
+		sink:     sink,
+		instance: instance,
+		interval: interval,
+		onError:  onError,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Close stops the pusher after pushing a final snapshot.
+func (p *Pusher) Close(ctx context.Context) error {
+	p.once.Do(func() { close(p.stop) })
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Pusher) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.push()
+		case <-p.stop:
+			p.push()
+			return
+		}
+	}
+}
+
+func (p *Pusher) push() {
+	timeout := p.interval
+	if timeout > maxPushTimeout {
+		timeout = maxPushTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	families, err := p.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		p.onError(fmt.Errorf("failed to gather metrics: %w", err))
+		return
+	}
+	// A partial gather still carries the families that could be collected.
+	snapshot := &Snapshot{Instance: p.instance, Timestamp: time.Now(), Families: families}
+	if err := p.sink.Push(ctx, snapshot); err != nil {
+		p.onError(err)
+	}
+}
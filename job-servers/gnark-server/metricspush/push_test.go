@@ -0,0 +1,276 @@
+package metricspush
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testSnapshot returns a snapshot of a counter, a labelled gauge and a
+// histogram.
+func testSnapshot(t *testing.T) *Snapshot {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "jobs_total", Help: "Jobs."})
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "queue_depth", Help: "Queue depth."}, []string{"priority"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "prove_seconds", Help: "Prove time.", Buckets: []float64{1, 10}})
+	registry.MustRegister(counter, gauge, histogram)
+	counter.Add(3)
+	gauge.WithLabelValues("high").Set(2)
+	gauge.WithLabelValues("low").Set(5)
+	histogram.Observe(0.5)
+	histogram.Observe(20)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Snapshot{Instance: "prover-1", Timestamp: time.UnixMilli(1714564800123).UTC(), Families: families}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	want := testSnapshot(t)
+	data, err := want.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Instance != want.Instance || !got.Timestamp.Equal(want.Timestamp) {
+		t.Fatalf("DecodeSnapshot() = %s at %v, want %s at %v", got.Instance, got.Timestamp, want.Instance, want.Timestamp)
+	}
+	again, err := got.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(data) {
+		t.Fatalf("re-encoded snapshot\n%s\nwant\n%s", again, data)
+	}
+	if _, err := DecodeSnapshot([]byte(`{"metrics": "not metrics {"}`)); err == nil {
+		t.Fatal("DecodeSnapshot() accepted invalid metrics")
+	}
+}
+
+func TestRedisSink(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	sink := &RedisSink{Client: rdb, TTL: time.Minute}
+	s := testSnapshot(t)
+	if err := sink.Push(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	data, err := mr.Get(RedisKeyPrefix + "prover-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeSnapshot([]byte(data)); err != nil {
+		t.Fatalf("stored snapshot: %v", err)
+	}
+	if ttl := mr.TTL(RedisKeyPrefix + "prover-1"); ttl != time.Minute {
+		t.Fatalf("snapshot TTL = %v, want a minute", ttl)
+	}
+}
+
+// decodedSeries is a remote write TimeSeries decoded by decodeWriteRequest.
+type decodedSeries struct {
+	labels      map[string]string
+	value       float64
+	timestampMs int64
+}
+
+// decodeWriteRequest decodes the series of a protobuf WriteRequest.
+func decodeWriteRequest(t *testing.T, b []byte) []decodedSeries {
+	t.Helper()
+	// fields calls f with every field of a message.
+	fields := func(b []byte, f func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			n = f(num, typ, b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	var all []decodedSeries
+	fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		seriesBytes, n := protowire.ConsumeBytes(b)
+		if num != 1 || typ != protowire.BytesType {
+			t.Fatalf("unexpected WriteRequest field %d", num)
+		}
+		ts := decodedSeries{labels: map[string]string{}}
+		fields(seriesBytes, func(num protowire.Number, typ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			switch num {
+			case 1:
+				var name, value string
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					s, n := protowire.ConsumeString(b)
+					if num == 1 {
+						name = s
+					} else {
+						value = s
+					}
+					return n
+				})
+				ts.labels[name] = value
+			case 2:
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if num == 1 {
+						v, n := protowire.ConsumeFixed64(b)
+						ts.value = math.Float64frombits(v)
+						return n
+					}
+					v, n := protowire.ConsumeVarint(b)
+					ts.timestampMs = int64(v)
+					return n
+				})
+			}
+			return n
+		})
+		all = append(all, ts)
+		return n
+	})
+	return all
+}
+
+func TestRemoteWriteSink(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		body []byte
+		head http.Header
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		head = r.Header.Clone()
+		compressed, err := io.ReadAll(r.Body)
+		if err == nil {
+			body, err = snappy.Decode(nil, compressed)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	s := testSnapshot(t)
+	sink := &RemoteWriteSink{URL: receiver.URL, BearerToken: "push-token"}
+	if err := sink.Push(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for name, want := range map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"Authorization":                     "Bearer push-token",
+	} {
+		if got := head.Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+
+	values := map[string]float64{}
+	for _, ts := range decodeWriteRequest(t, body) {
+		if ts.labels["instance"] != "prover-1" || ts.labels["job"] != remoteWriteJob {
+			t.Errorf("series %v lacks the instance and job labels", ts.labels)
+		}
+		if ts.timestampMs != s.Timestamp.UnixMilli() {
+			t.Errorf("series %v timestamp %d, want %d", ts.labels, ts.timestampMs, s.Timestamp.UnixMilli())
+		}
+		key := ts.labels["__name__"]
+		for _, extra := range []string{"priority", "le"} {
+			if v, ok := ts.labels[extra]; ok {
+				key += "{" + extra + "=" + v + "}"
+			}
+		}
+		values[key] = ts.value
+	}
+	want := map[string]float64{
+		"jobs_total":                    3,
+		"queue_depth{priority=high}":    2,
+		"queue_depth{priority=low}":     5,
+		"prove_seconds_bucket{le=1}":    1,
+		"prove_seconds_bucket{le=10}":   1,
+		"prove_seconds_bucket{le=+Inf}": 2,
+		"prove_seconds_sum":             20.5,
+		"prove_seconds_count":           2,
+	}
+	if len(values) != len(want) {
+		t.Errorf("series %v, want %v", values, want)
+	}
+	for key, value := range want {
+		if got, ok := values[key]; !ok || got != value {
+			t.Errorf("series %s = %v, want %v", key, got, value)
+		}
+	}
+}
+
+func TestRemoteWriteSinkReportsRejection(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer receiver.Close()
+	err := (&RemoteWriteSink{URL: receiver.URL}).Push(context.Background(), testSnapshot(t))
+	if err == nil || !strings.Contains(err.Error(), "out of order sample") {
+		t.Fatalf("Push() = %v, want the receiver's error", err)
+	}
+}
+
+// failingSink fails every push and counts them.
+type failingSink struct {
+	mu     sync.Mutex
+	pushes int
+}
+
+func (f *failingSink) Push(ctx context.Context, s *Snapshot) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pushes++
+	return errors.New("collector unavailable")
+}
+
+func TestPusherKeepsPushingAfterFailures(t *testing.T) {
+	sink := &failingSink{}
+	var mu sync.Mutex
+	failures := 0
+	p := Start(prometheus.NewRegistry(), sink, "prover-1", 10*time.Millisecond, func(err error) {
+		mu.Lock()
+		failures++
+		mu.Unlock()
+	})
+	time.Sleep(100 * time.Millisecond)
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	if sink.pushes < 3 || failures != sink.pushes {
+		t.Fatalf("%d pushes and %d reported failures, want several of each", sink.pushes, failures)
+	}
+}
@@ -0,0 +1,183 @@
+package metricspush
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/golang/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// remoteWriteJob is the job label of pushed series, which a scrape
+	// would otherwise add.
+	remoteWriteJob = "gnark-server"
+
+	maxErrorBodyBytes = 512
+)
+
+// RemoteWriteSink sends snapshots to a Prometheus remote_write endpoint, as
+// protocol version 0.1.0: a snappy-compressed protobuf WriteRequest. Every
+// series gets the labels instance and job, and the snapshot timestamp.
+type RemoteWriteSink struct {
+	URL string
+	// BearerToken, if set, is sent in the Authorization header.
+	BearerToken string
+	Client      *http.Client
+}
+
+func (r *RemoteWriteSink) Push(ctx context.Context, s *Snapshot) error {
+	body := snappy.Encode(nil, EncodeWriteRequest(s))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if r.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.BearerToken)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		return fmt.Errorf("remote write returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+type label struct {
+	name, value string
+}
+
+type sample struct {
+	value       float64
+	timestampMs int64
+}
+
+type series struct {
+	labels []label
+	sample sample
+}
+
+// EncodeWriteRequest returns the uncompressed protobuf WriteRequest of the
+// series of s. Histograms and summaries are split into their _bucket or
+// quantile, _sum and _count series, as a scrape would.
+func EncodeWriteRequest(s *Snapshot) []byte {
+	var out []byte
+	for _, ts := range snapshotSeries(s) {
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, encodeSeries(ts))
+	}
+	return out
+}
+
+func snapshotSeries(s *Snapshot) []series {
+	timestampMs := s.Timestamp.UnixMilli()
+	var all []series
+	for _, family := range s.Families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			ts := timestampMs
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, extra ...label) {
+				labels := []label{{"__name__", name + suffix}, {"instance", s.Instance}, {"job", remoteWriteJob}}
+				for _, pair := range m.GetLabel() {
+					labels = setLabel(labels, pair.GetName(), pair.GetValue())
+				}
+				for _, l := range extra {
+					labels = setLabel(labels, l.name, l.value)
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+				all = append(all, series{labels: labels, sample: sample{value: value, timestampMs: ts}})
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				hasInf := false
+				for _, b := range h.GetBucket() {
+					hasInf = hasInf || math.IsInf(b.GetUpperBound(), 1)
+					add("_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !hasInf {
+					add("_bucket", float64(h.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				summary := m.GetSummary()
+				for _, q := range summary.GetQuantile() {
+					add("", q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add("_sum", summary.GetSampleSum())
+				add("_count", float64(summary.GetSampleCount()))
+			}
+		}
+	}
+	return all
+}
+
+// setLabel sets name to value, replacing a label of the same name so that
+// metric labels take precedence over the defaults.
+func setLabel(labels []label, name string, value string) []label {
+	for i := range labels {
+		if labels[i].name == name {
+			labels[i].value = value
+			return labels
+		}
+	}
+	return append(labels, label{name, value})
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeSeries encodes a remote write TimeSeries: labels are field 1 and
+// samples field 2.
+func encodeSeries(ts series) []byte {
+	var out []byte
+	for _, l := range ts.labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.name)
+		lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.value)
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, lb)
+	}
+	var sb []byte
+	sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+	sb = protowire.AppendFixed64(sb, math.Float64bits(ts.sample.value))
+	sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+	sb = protowire.AppendVarint(sb, uint64(ts.sample.timestampMs))
+	out = protowire.AppendTag(out, 2, protowire.BytesType)
+	out = protowire.AppendBytes(out, sb)
+	return out
+}
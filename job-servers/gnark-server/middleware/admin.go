@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// AdminToken is the bearer token of the admin endpoints. It can be rotated
+// while the server runs.
+type AdminToken struct {
+	token atomic.Pointer[string]
+}
+
+// NewAdminToken returns an AdminToken holding token.
+func NewAdminToken(token string) *AdminToken {
+	t := &AdminToken{}
+	t.token.Store(&token)
+	return t
+}
+
+// Rotate replaces the token. Requests presenting the previous token are
+// rejected from then on. An empty token is refused, so that a truncated
+// secret file cannot open the admin endpoints.
+func (t *AdminToken) Rotate(token string) error {
+	if token == "" {
+		return errors.New("the admin token must not be empty")
+	}
+	t.token.Store(&token)
+	return nil
+}
+
+func (t *AdminToken) matches(presented string) bool {
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(*t.token.Load())) == 1
+}
+
+// RequireAdminToken wraps an operator-only handler so that it only runs for
+// requests carrying token as a bearer token.
+func RequireAdminToken(token *AdminToken, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !token.matches(presented) {
+			log.Printf("Rejected unauthenticated admin request to %s from %s\n", r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RequireAdminLogin is RequireAdminToken for pages opened in a browser. It
+// also accepts HTTP basic authentication with the token as the password, and
+// challenges for it, so that the browser prompts for the token once and
+// sends it with the page's own requests.
+func RequireAdminLogin(token *AdminToken, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		presented, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, presented, ok = r.BasicAuth()
+		}
+		if !ok || !token.matches(presented) {
+			log.Printf("Rejected unauthenticated admin request to %s from %s\n", r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Basic realm="gnark-server admin"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
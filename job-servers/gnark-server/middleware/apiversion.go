@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"gnark-server/apichanges"
+)
+
+const (
+	apiVersionHeader      = "X-Api-Version"
+	apiKnownVersionHeader = "X-Api-Known-Version"
+	apiChangesSinceHeader = "X-Api-Changes-Since"
+)
+
+// APIVersion sets the X-Api-Version header of every response to the API
+// version of this server. When the request announces the last version its
+// client knows in X-Api-Known-Version and the API changed since, it also sets
+// X-Api-Changes-Since to that version, so that the client can fetch
+// /api-changes?since=<version>. An unparsable announced version is ignored.
+func APIVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(apiVersionHeader, apichanges.Current)
+		if known := r.Header.Get(apiKnownVersionHeader); known != "" {
+			if version, err := apichanges.ParseVersion(known); err == nil && len(apichanges.Since(version)) > 0 {
+				w.Header().Set(apiChangesSinceHeader, version.String())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gnark-server/apichanges"
+)
+
+func TestAPIVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		known     string
+		wantSince string
+	}{
+		{"not announced", "", ""},
+		{"older client", "1.9", "1.9"},
+		{"normalized", "01.09", "1.9"},
+		{"current client", apichanges.Current, ""},
+		{"newer client", "99.0", ""},
+		{"unparsable", "latest", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/health", nil)
+			if tt.known != "" {
+				r.Header.Set(apiKnownVersionHeader, tt.known)
+			}
+			w := httptest.NewRecorder()
+			APIVersion(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			if got := w.Header().Get(apiVersionHeader); got != apichanges.Current {
+				t.Fatalf("%s = %q, want %s", apiVersionHeader, got, apichanges.Current)
+			}
+			if got := w.Header().Get(apiChangesSinceHeader); got != tt.wantSince {
+				t.Fatalf("%s = %q, want %q", apiChangesSinceHeader, got, tt.wantSince)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type contextKey struct{}
+
+// Auth checks bearer API keys, and JSON Web Tokens once a JWT secret is set.
+// A nil *Auth lets every request through, which is how authentication is
+// disabled for local development.
+type Auth struct {
+	// JWTIssuer, when set, is the iss claim tokens must carry.
+	JWTIssuer string
+
+	labels atomic.Pointer[map[[sha256.Size]byte]string]
+	jwtKey atomic.Pointer[[]byte]
+}
+
+// ParseAPIKeys parses a list of the form "label=key,label2=key2".
+func ParseAPIKeys(value string) (*Auth, error) {
+	auth := &Auth{}
+	if err := auth.Rotate(value); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// Rotate replaces the API keys with the list in value, in the format of
+// ParseAPIKeys. Requests in flight finish with the keys they were checked
+// against. The keys are left unchanged if value is invalid.
+func (a *Auth) Rotate(value string) error {
+	labels, err := parseAPIKeys(value)
+	if err != nil {
+		return err
+	}
+	a.labels.Store(&labels)
+	return nil
+}
+
+func parseAPIKeys(value string) (map[[sha256.Size]byte]string, error) {
+	labels := map[[sha256.Size]byte]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		label, key, ok := strings.Cut(entry, "=")
+		label, key = strings.TrimSpace(label), strings.TrimSpace(key)
+		if !ok || label == "" || key == "" {
+			return nil, fmt.Errorf("invalid API key entry %q, expected label=key", entry)
+		}
+		digest := sha256.Sum256([]byte(key))
+		if _, exists := labels[digest]; exists {
+			return nil, fmt.Errorf("duplicate API key for label %q", label)
+		}
+		labels[digest] = label
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("no API keys configured")
+	}
+	return labels, nil
+}
+
+// ClientLabel returns the label of the API key that authenticated the
+// request, the subject of its token, or "" when authentication is disabled.
+func ClientLabel(ctx context.Context) string {
+	label, _ := ctx.Value(contextKey{}).(string)
+	return label
+}
+
+// authenticate checks a bearer token and returns ctx carrying its client
+// label, and its claims if it is a JSON Web Token. API keys are compared by
+// digest so the lookup time does not depend on how much of a key matched.
+func (a *Auth) authenticate(ctx context.Context, header string) (context.Context, bool) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || token == "" {
+		return ctx, false
+	}
+	if a.jwtKey.Load() != nil && isJWT(token) {
+		claims, err := a.parseJWT(token)
+		if err != nil {
+			log.Println("Rejected JSON Web Token:", err)
+			return ctx, false
+		}
+		label := claims.Subject
+		if label == "" {
+			label = jwtClientLabel
+		}
+		ctx = context.WithValue(ctx, claimsContextKey{}, claims)
+		return context.WithValue(ctx, contextKey{}, label), true
+	}
+	labels := a.labels.Load()
+	if labels == nil {
+		return ctx, false
+	}
+	label, ok := (*labels)[sha256.Sum256([]byte(token))]
+	if !ok {
+		return ctx, false
+	}
+	return context.WithValue(ctx, contextKey{}, label), true
+}
+
+// Require wraps a handler so that it only runs for requests carrying a valid
+// API key or token.
+func (a *Auth) Require(next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, ok := a.authenticate(r.Context(), r.Header.Get("Authorization"))
+		if !ok {
+			log.Printf("Rejected unauthenticated request to %s from %s\n", r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid API key")
+			return
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// UnaryInterceptor applies the same check to gRPC calls, reading the key from
+// the "authorization" metadata. Methods in exempt are not checked.
+func (a *Auth) UnaryInterceptor(exempt ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if a == nil {
+			return handler(ctx, req)
+		}
+		for _, method := range exempt {
+			if info.FullMethod == method {
+				return handler(ctx, req)
+			}
+		}
+		var header string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) > 0 {
+				header = values[0]
+			}
+		}
+		ctx, ok := a.authenticate(ctx, header)
+		if !ok {
+			log.Printf("Rejected unauthenticated gRPC call to %s\n", info.FullMethod)
+			return nil, status.Error(codes.Unauthenticated, "missing or invalid API key")
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gnark-server/secrets"
+)
+
+// statusOf serves a request with the bearer token through handler and returns
+// the status code.
+func statusOf(handler http.Handler, token string) int {
+	r := httptest.NewRequest(http.MethodGet, "/get-proof", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+var allow = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestAuthRotate(t *testing.T) {
+	auth, err := ParseAPIKeys("ops=old-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := auth.Require(allow)
+	if code := statusOf(handler, "old-key"); code != http.StatusOK {
+		t.Fatalf("old key before rotation: %d", code)
+	}
+	if err := auth.Rotate("ops=new-key, ci=ci-key"); err != nil {
+		t.Fatal(err)
+	}
+	for token, want := range map[string]int{"old-key": http.StatusUnauthorized, "new-key": http.StatusOK, "ci-key": http.StatusOK} {
+		if code := statusOf(handler, token); code != want {
+			t.Fatalf("%s after rotation: %d, want %d", token, code, want)
+		}
+	}
+	for _, invalid := range []string{"", "new-key", "ops=a,ci=a"} {
+		if err := auth.Rotate(invalid); err == nil {
+			t.Fatalf("Rotate(%q) accepted an invalid key list", invalid)
+		}
+	}
+	if code := statusOf(handler, "new-key"); code != http.StatusOK {
+		t.Fatalf("new key after a refused rotation: %d", code)
+	}
+}
+
+func TestAdminTokenRotate(t *testing.T) {
+	token := NewAdminToken("old-token")
+	handler := RequireAdminToken(token, allow)
+	if err := token.Rotate(""); err == nil {
+		t.Fatal("Rotate() accepted an empty token")
+	}
+	if code := statusOf(handler, "old-token"); code != http.StatusOK {
+		t.Fatalf("old token after a refused rotation: %d", code)
+	}
+	if err := token.Rotate("new-token"); err != nil {
+		t.Fatal(err)
+	}
+	if code := statusOf(handler, "old-token"); code != http.StatusUnauthorized {
+		t.Fatalf("old token after rotation: %d, want 401", code)
+	}
+	if code := statusOf(handler, "new-token"); code != http.StatusOK {
+		t.Fatalf("new token after rotation: %d", code)
+	}
+}
+
+func TestRotatedSecretFilesTakeEffect(t *testing.T) {
+	dir := t.TempDir()
+	keysPath, tokenPath := filepath.Join(dir, "api_keys"), filepath.Join(dir, "admin_token")
+	os.WriteFile(keysPath, []byte("ops=old-key\n"), 0600)
+	os.WriteFile(tokenPath, []byte("old-token\n"), 0600)
+	t.Setenv("API_KEYS_FILE", keysPath)
+	t.Setenv("ADMIN_TOKEN_FILE", tokenPath)
+
+	keys, keysFile, err := secrets.Lookup("API_KEYS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := ParseAPIKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adminValue, adminFile, err := secrets.Lookup("ADMIN_TOKEN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := NewAdminToken(adminValue)
+	interval := 20 * time.Millisecond
+	watcher := secrets.NewWatcher(interval)
+	watcher.Watch("API_KEYS", keysFile, auth.Rotate)
+	watcher.Watch("ADMIN_TOKEN", adminFile, admin.Rotate)
+	watcher.Start()
+	defer watcher.Stop()
+
+	public, adminHandler := auth.Require(allow), RequireAdminToken(admin, allow)
+	os.WriteFile(keysPath, []byte("ops=new-key\n"), 0600)
+	os.WriteFile(tokenPath, []byte("new-token\n"), 0600)
+	deadline := time.Now().Add(50 * interval)
+	for statusOf(public, "new-key") != http.StatusOK || statusOf(adminHandler, "new-token") != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatalf("the rotated secrets did not take effect within %v", 50*interval)
+		}
+		time.Sleep(interval / 4)
+	}
+	if statusOf(public, "old-key") != http.StatusUnauthorized || statusOf(adminHandler, "old-token") != http.StatusUnauthorized {
+		t.Fatal("the previous secrets are still accepted after rotation")
+	}
+}
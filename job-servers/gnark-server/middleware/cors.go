@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	corsAllowedMethods = "GET, POST, OPTIONS"
+	// corsMaxAge is how long, in seconds, browsers may cache a preflight.
+	corsMaxAge = "600"
+)
+
+// DefaultCORSAllowedHeaders are the request headers the API reads, allowed
+// in cross-origin requests when CORS_ALLOWED_HEADERS is not set.
+var DefaultCORSAllowedHeaders = []string{"Authorization", "Content-Type", "Content-Encoding", "Idempotency-Key", "X-Priority", apiKnownVersionHeader}
+
+// corsExposedHeaders are the response headers browsers let scripts read,
+// besides the CORS-safelisted ones.
+var corsExposedHeaders = strings.Join([]string{apiVersionHeader, apiChangesSinceHeader, "X-Deduplicated", "X-Cache", "Retry-After"}, ", ")
+
+// CORSConfig selects the browser origins allowed to call the API.
+type CORSConfig struct {
+	// AllowedOrigins lists the allowed origins, such as
+	// https://wallet.example.com.
+	AllowedOrigins []string
+	// AllowedHeaders lists the request headers allowed in cross-origin
+	// requests. Empty means DefaultCORSAllowedHeaders.
+	AllowedHeaders []string
+	// AllowAll allows every origin with a wildcard. It must be set
+	// explicitly; "*" in AllowedOrigins is refused.
+	AllowAll bool
+}
+
+// Enabled reports whether any origin is allowed.
+func (c CORSConfig) Enabled() bool {
+	return c.AllowAll || len(c.AllowedOrigins) > 0
+}
+
+// Validate checks that every allowed origin is a bare http or https origin,
+// and that the wildcard is only allowed through AllowAll.
+func (c CORSConfig) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return errors.New("the wildcard origin * is only allowed with CORS_ALLOW_ALL=true")
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return fmt.Errorf("%q is not an origin such as https://wallet.example.com", origin)
+		}
+	}
+	return nil
+}
+
+// CORS lets the browser origins of cfg call next. Requests from an allowed
+// origin get Access-Control-Allow-Origin, -Methods and -Headers, and
+// preflight OPTIONS requests are answered with 204 without reaching next.
+// Preflights from other origins get 204 without these headers, so that the
+// browser blocks the request. Requests without an Origin header, which
+// browsers only leave out for same-origin requests, are passed through
+// unchanged.
+func CORS(cfg CORSConfig, next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		allowed[normalizeOrigin(origin)] = true
+	}
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = DefaultCORSAllowedHeaders
+	}
+	allowedHeaders := strings.Join(headers, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		h := w.Header()
+		if cfg.AllowAll {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			// The response depends on the origin, so caches must not serve
+			// it to another one.
+			h.Add("Vary", "Origin")
+			if allowed[normalizeOrigin(origin)] {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		if h.Get("Access-Control-Allow-Origin") != "" {
+			h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			h.Set("Access-Control-Allow-Headers", allowedHeaders)
+			h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			if preflight {
+				h.Set("Access-Control-Max-Age", corsMaxAge)
+			}
+		}
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// normalizeOrigin makes origins that differ only in case or a trailing
+// slash compare equal.
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(origin, "/"))
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// errorResponse mirrors handlers.ErrorResponse so that middleware rejections
+// look the same as handler errors.
+type errorResponse struct {
+	Code    string                 `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
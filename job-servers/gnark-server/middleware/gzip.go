@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultMaxDecompressedBody bounds gzip request bodies once decompressed
+// when no limit is configured.
+const DefaultMaxDecompressedBody = 64 << 20
+
+// Gzip decompresses request bodies sent with Content-Encoding: gzip and
+// compresses responses for clients that send Accept-Encoding: gzip, so that
+// handlers only see plain bodies. Decompressed bodies larger than
+// maxDecompressed are refused with 413 so that a small compressed request
+// cannot expand without bound. Responses that already have a
+// Content-Encoding and event streams, which must be flushed as they are
+// written, are passed through, and so are protocol upgrades such as
+// WebSocket handshakes, which need the connection itself.
+func Gzip(maxDecompressed int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if encoding := strings.TrimSpace(r.Header.Get("Content-Encoding")); encoding != "" {
+			if !strings.EqualFold(encoding, "gzip") {
+				writeError(w, http.StatusUnsupportedMediaType, "unsupported_content_encoding",
+					fmt.Sprintf("Content-Encoding %q is not supported, use gzip", encoding))
+				return
+			}
+			body, err := gunzipBody(r.Body, maxDecompressed)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, "request_too_large",
+					fmt.Sprintf("decompressed request body exceeds %d bytes", maxDecompressed))
+				return
+			} else if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid_gzip", "request body is not valid gzip: "+err.Error())
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Del("Content-Encoding")
+			r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func gunzipBody(body io.ReadCloser, maxDecompressed int64) ([]byte, error) {
+	defer body.Close()
+	zr, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(io.LimitReader(zr, maxDecompressed+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxDecompressed {
+		return nil, &http.MaxBytesError{Limit: maxDecompressed}
+	}
+	return data, nil
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, either
+// by name or through "*", with a non-zero quality.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter decides on the first write whether to compress: it does
+// unless the handler set its own Content-Encoding, streams events or sends no
+// body.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	zw      *gzip.Writer
+	decided bool
+}
+
+func (g *gzipResponseWriter) decide(status int) {
+	if g.decided {
+		return
+	}
+	g.decided = true
+	h := g.Header()
+	if h.Get("Content-Encoding") != "" || strings.HasPrefix(h.Get("Content-Type"), "text/event-stream") ||
+		status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	g.zw = gzip.NewWriter(g.ResponseWriter)
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	g.decide(status)
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.decided {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		g.decide(http.StatusOK)
+	}
+	if g.zw == nil {
+		return g.ResponseWriter.Write(p)
+	}
+	return g.zw.Write(p)
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if g.zw != nil {
+		g.zw.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.zw != nil {
+		g.zw.Close()
+	}
+}
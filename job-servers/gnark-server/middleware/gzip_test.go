@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// echo answers with the request body as JSON.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"body":            string(body),
+		"contentLength":   r.ContentLength,
+		"contentEncoding": r.Header.Get("Content-Encoding"),
+	})
+})
+
+func TestGzipClients(t *testing.T) {
+	payload := `{"proof": "` + strings.Repeat("0123456789", 1000) + `"}`
+	tests := []struct {
+		name           string
+		gzipRequest    bool
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{"plain client", false, "", false},
+		{"compressed request", true, "", false},
+		{"compressed response", false, "gzip", true},
+		{"compressed both ways", true, "gzip, deflate", true},
+		{"gzip refused", true, "gzip;q=0, identity", false},
+		{"any encoding", false, "*", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := []byte(payload)
+			r := httptest.NewRequest(http.MethodPost, "/start-proof", nil)
+			if tt.gzipRequest {
+				body = gzipped(t, body)
+				r.Header.Set("Content-Encoding", "gzip")
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			Gzip(DefaultMaxDecompressedBody, echo).ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("%d %s", w.Code, w.Body)
+			}
+
+			response := w.Body.Bytes()
+			if got := w.Header().Get("Content-Encoding") == "gzip"; got != tt.wantGzip {
+				t.Fatalf("response Content-Encoding %q, want gzip %v", w.Header().Get("Content-Encoding"), tt.wantGzip)
+			}
+			if tt.wantGzip {
+				zr, err := gzip.NewReader(bytes.NewReader(response))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if response, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Fatalf("Vary = %q, want Accept-Encoding", vary)
+			}
+			var echoed struct {
+				Body            string `json:"body"`
+				ContentLength   int64  `json:"contentLength"`
+				ContentEncoding string `json:"contentEncoding"`
+			}
+			if err := json.Unmarshal(response, &echoed); err != nil {
+				t.Fatalf("response %q: %v", response, err)
+			}
+			if echoed.Body != payload || echoed.ContentLength != int64(len(payload)) || echoed.ContentEncoding != "" {
+				t.Fatalf("handler saw a body of %d bytes, Content-Length %d, Content-Encoding %q, want the plain payload",
+					len(echoed.Body), echoed.ContentLength, echoed.ContentEncoding)
+			}
+		})
+	}
+}
+
+func TestGzipRefusesBadBodies(t *testing.T) {
+	tests := []struct {
+		name       string
+		encoding   string
+		body       func(t *testing.T) []byte
+		wantStatus int
+		wantCode   string
+	}{
+		{"zip bomb", "gzip", func(t *testing.T) []byte { return gzipped(t, make([]byte, 1<<20+1)) }, http.StatusRequestEntityTooLarge, "request_too_large"},
+		{"at the limit", "gzip", func(t *testing.T) []byte { return gzipped(t, make([]byte, 1<<20)) }, http.StatusOK, ""},
+		{"not gzip", "gzip", func(t *testing.T) []byte { return []byte("{}") }, http.StatusBadRequest, "invalid_gzip"},
+		{"truncated", "gzip", func(t *testing.T) []byte { b := gzipped(t, []byte(strings.Repeat("a", 1000))); return b[:len(b)/2] }, http.StatusBadRequest, "invalid_gzip"},
+		{"other encoding", "br", func(t *testing.T) []byte { return []byte("{}") }, http.StatusUnsupportedMediaType, "unsupported_content_encoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/start-proof", bytes.NewReader(tt.body(t)))
+			r.Header.Set("Content-Encoding", tt.encoding)
+			w := httptest.NewRecorder()
+			called := false
+			Gzip(1<<20, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("%d %s, want %d", w.Code, w.Body, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Fatalf("handler called: %v", called)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var body struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != tt.wantCode {
+				t.Fatalf("error %s, want code %s", w.Body, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestGzipPassesThrough(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		upgrade bool
+	}{
+		{"event stream", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {}\n\n"))
+		}, false},
+		{"own encoding", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte("data"))
+		}, false},
+		{"no content", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }, false},
+		{"upgrade", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("data")) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/proof-events", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			if tt.upgrade {
+				r.Header.Set("Upgrade", "websocket")
+			}
+			w := httptest.NewRecorder()
+			Gzip(DefaultMaxDecompressedBody, tt.handler).ServeHTTP(w, r)
+			if w.Header().Get("Content-Encoding") == "gzip" {
+				t.Fatal("response was gzipped")
+			}
+			if w.Body.Len() > 0 && w.Body.String() != "data" && w.Body.String() != "data: {}\n\n" {
+				t.Fatalf("body %q was altered", w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// minJWTSecretLength is the shortest HMAC secret accepted, the output
+	// size of SHA-256.
+	minJWTSecretLength = 32
+
+	// jwtLeeway absorbs clock skew between the token issuer and the server.
+	jwtLeeway = 30 * time.Second
+
+	// jwtClientLabel is the client label of tokens without a subject.
+	jwtClientLabel = "jwt"
+)
+
+type claimsContextKey struct{}
+
+// JWTClaims are the claims of an accepted JSON Web Token. AllowedCircuits,
+// when set, lists the plonky2 circuit digests the bearer may submit proofs
+// for.
+type JWTClaims struct {
+	jwt.RegisteredClaims
+	AllowedCircuits []string `json:"allowed_circuits,omitempty"`
+}
+
+// JWTAuth returns an Auth accepting bearer JSON Web Tokens signed with
+// secret using HMAC-SHA256, -384 or -512. Tokens must carry an exp claim,
+// and an iss claim equal to JWTIssuer when it is set. The subject of a token
+// is its client label.
+func JWTAuth(secret string) (*Auth, error) {
+	auth := &Auth{}
+	if err := auth.SetJWTSecret(secret); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// SetJWTSecret makes a accept JSON Web Tokens signed with secret, besides
+// its API keys, or replaces the secret they are checked against. The secret
+// is left unchanged if it is too short.
+func (a *Auth) SetJWTSecret(secret string) error {
+	if len(secret) < minJWTSecretLength {
+		return fmt.Errorf("JWT secret must be at least %d bytes", minJWTSecretLength)
+	}
+	key := []byte(secret)
+	a.jwtKey.Store(&key)
+	return nil
+}
+
+// parseJWT validates token and returns its claims.
+func (a *Auth) parseJWT(token string) (*JWTClaims, error) {
+	key := a.jwtKey.Load()
+	if key == nil {
+		return nil, errors.New("JWT authentication is not enabled")
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if a.JWTIssuer != "" {
+		options = append(options, jwt.WithIssuer(a.JWTIssuer))
+	}
+	claims := &JWTClaims{}
+	_, err := jwt.NewParser(options...).ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return *key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// isJWT reports whether token has the three dot-separated parts of a JSON
+// Web Token, as opposed to an API key.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// JWTClaimsFromContext returns the claims of the token that authenticated
+// the request, or false when it was not authenticated with a token.
+func JWTClaimsFromContext(ctx context.Context) (*JWTClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*JWTClaims)
+	return claims, ok
+}
+
+// CircuitAllowed reports whether the request may submit proofs for the
+// plonky2 circuit digest. Only tokens with an allowed_circuits claim
+// restrict it.
+func CircuitAllowed(ctx context.Context, digest string) bool {
+	claims, ok := JWTClaimsFromContext(ctx)
+	if !ok || claims.AllowedCircuits == nil {
+		return true
+	}
+	for _, allowed := range claims.AllowedCircuits {
+		if allowed == digest {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const rateLimitKeyPrefix = "gnark_rate_limit:"
+
+// tokenBucketScript takes one token from the bucket KEYS[1], refilled at
+// ARGV[1] tokens per second up to ARGV[2] tokens. It uses the Redis clock so
+// that all replicas share the same view of time, and returns whether the
+// request is allowed and, if not, how many seconds until a token is available.
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local state = redis.call('HMGET', key, 'tokens', 'updatedAt')
+local tokens = tonumber(state[1]) or burst
+local updatedAt = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updatedAt) * rate)
+
+local allowed = 0
+local retryAfter = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retryAfter = (1 - tokens) / rate
+end
+
+redis.call('HSET', key, 'tokens', tostring(tokens), 'updatedAt', tostring(now))
+redis.call('EXPIRE', key, math.ceil(burst / rate) + 1)
+return {allowed, tostring(retryAfter)}
+`)
+
+// RateLimiter is a token bucket per client stored in Redis, so the limit
+// holds across replicas. A nil *RateLimiter does not limit anything.
+type RateLimiter struct {
+	RedisClient redis.UniversalClient
+	// Rate is the number of requests per second a client may sustain.
+	Rate float64
+	// Burst is the number of requests a client may make at once.
+	Burst int
+	// TrustedProxyDepth is the number of reverse proxies in front of the
+	// server that append the address they received a request from to
+	// X-Forwarded-For. Zero ignores the header.
+	TrustedProxyDepth int
+}
+
+// clientKey identifies the caller by API key label when authenticated and by
+// IP otherwise.
+func (l *RateLimiter) clientKey(r *http.Request) string {
+	if label := ClientLabel(r.Context()); label != "" {
+		return "key:" + label
+	}
+	return "ip:" + ClientIP(r, l.TrustedProxyDepth)
+}
+
+// ClientIP returns the address of the client that sent r through
+// trustedProxies reverse proxies. Each proxy appends the address it received
+// the request from to X-Forwarded-For, so the client is the entry the
+// outermost trusted proxy added, trustedProxies from the end. Entries before
+// it were sent by the client and cannot be trusted. The remote address is
+// used when trustedProxies is zero or the header has fewer entries, since
+// the request then did not come through every proxy.
+func ClientIP(r *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(header, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		if len(hops) >= trustedProxies {
+			if ip := net.ParseIP(hops[len(hops)-trustedProxies]); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+// take reports whether the client may proceed and otherwise how long it has
+// to wait.
+func (l *RateLimiter) take(ctx context.Context, client string) (bool, float64, error) {
+	res, err := tokenBucketScript.Run(ctx, l.RedisClient, []string{rateLimitKeyPrefix + client}, l.Rate, l.Burst).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script result %v", res)
+	}
+	allowed, _ := values[0].(int64)
+	retryAfterStr, _ := values[1].(string)
+	retryAfter, _ := strconv.ParseFloat(retryAfterStr, 64)
+	return allowed == 1, retryAfter, nil
+}
+
+// Limit wraps a handler so that it only runs while the client has tokens
+// left. It runs before the handler reads the request body. If Redis is
+// unavailable requests are let through rather than rejected.
+func (l *RateLimiter) Limit(next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := l.clientKey(r)
+		allowed, retryAfter, err := l.take(r.Context(), client)
+		if err != nil {
+			log.Printf("Rate limiter error for %s: %v\n", client, err)
+		} else if !allowed {
+			seconds := int(math.Ceil(retryAfter))
+			if seconds < 1 {
+				seconds = 1
+			}
+			log.Printf("Rate limited %s on %s\n", client, r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "too many requests, retry later")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS proof_jobs (
+    job_id          UUID PRIMARY KEY,
+    success         BOOLEAN NOT NULL,
+    error_message   TEXT,
+    public_inputs   JSONB,
+    circuit_release TEXT,
+    completed_at    TIMESTAMPTZ NOT NULL,
+    mirrored_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS proof_jobs_completed_at_idx ON proof_jobs (completed_at);
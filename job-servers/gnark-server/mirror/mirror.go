@@ -0,0 +1,280 @@
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const (
+	queueSize     = 1024
+	batchSize     = 100
+	flushInterval = 2 * time.Second
+	maxAttempts   = 5
+	retryBackoff  = time.Second
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Record is the terminal metadata of a job. Proof blobs are deliberately not
+// mirrored.
+type Record struct {
+	JobId          string
+	Success        bool
+	ErrorMessage   *string
+	PublicInputs   []string
+	CircuitRelease string
+	CompletedAt    time.Time
+}
+
+// Mirror upserts terminal job records into PostgreSQL in the background. It is
+// best-effort: a slow or unavailable database drops records rather than
+// blocking the proving path.
+type Mirror struct {
+	db      *sql.DB
+	records chan Record
+	done    chan struct{}
+	once    sync.Once
+
+	// lagNanos is the delay between job completion and the record being
+	// committed, for the most recently flushed batch.
+	lagNanos atomic.Int64
+	dropped  atomic.Int64
+}
+
+// Open connects to the database, applies the embedded migrations and starts
+// the background writer.
+func Open(ctx context.Context, databaseURL string) (*Mirror, error) {
+	db, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to apply mirror migrations: %w", err)
+	}
+	m := &Mirror{
+		db:      db,
+		records: make(chan Record, queueSize),
+		done:    make(chan struct{}),
+	}
+	go m.run()
+	return m, nil
+}
+
+func migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS mirror_schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var applied bool
+		if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM mirror_schema_migrations WHERE version = $1)`, name).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+		stmt, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, string(stmt)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO mirror_schema_migrations (version) VALUES ($1)`, name); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Println("Applied mirror migration", name)
+	}
+	return nil
+}
+
+// Enqueue schedules a record for mirroring without blocking. Records are
+// dropped when the queue is full.
+func (m *Mirror) Enqueue(record Record) {
+	select {
+	case m.records <- record:
+	default:
+		m.dropped.Add(1)
+		log.Printf("Mirror queue is full, dropping record for job %s\n", record.JobId)
+	}
+}
+
+// Lag returns the completion-to-commit delay of the last flushed batch.
+func (m *Mirror) Lag() time.Duration {
+	return time.Duration(m.lagNanos.Load())
+}
+
+// Dropped returns the number of records dropped because the queue was full or
+// the database kept failing.
+func (m *Mirror) Dropped() int64 {
+	return m.dropped.Load()
+}
+
+// Close flushes queued records and closes the database, giving up when ctx
+// is done.
+func (m *Mirror) Close(ctx context.Context) error {
+	m.once.Do(func() { close(m.records) })
+	select {
+	case <-m.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return m.db.Close()
+}
+
+func (m *Mirror) run() {
+	defer close(m.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]Record, 0, batchSize)
+	for {
+		select {
+		case record, ok := <-m.records:
+			if !ok {
+				m.flush(batch)
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= batchSize {
+				m.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			m.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (m *Mirror) flush(batch []Record) {
+	if len(batch) == 0 {
+		return
+	}
+	m.Write(context.Background(), batch)
+}
+
+// Write upserts records, retrying with backoff, and records the lag of the
+// batch. Records that fail every attempt are counted as dropped; when ctx is
+// done Write gives up and leaves them to the caller.
+func (m *Mirror) Write(ctx context.Context, records []Record) error {
+	backoff := retryBackoff
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = m.Upsert(ctx, records); err == nil {
+			oldest := records[0].CompletedAt
+			for _, r := range records[1:] {
+				if r.CompletedAt.Before(oldest) {
+					oldest = r.CompletedAt
+				}
+			}
+			m.lagNanos.Store(int64(time.Since(oldest)))
+			return nil
+		}
+		log.Printf("Mirror upsert of %d records failed (attempt %d/%d): %v\n", len(records), attempt, maxAttempts, err)
+		if attempt == maxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	m.dropped.Add(int64(len(records)))
+	return err
+}
+
+// Mirrored returns which of jobIds have a row in the mirror.
+func (m *Mirror) Mirrored(ctx context.Context, jobIds []string) (map[string]bool, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT job_id FROM proof_jobs WHERE job_id = ANY($1)`, jobIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mirrored := make(map[string]bool, len(jobIds))
+	for rows.Next() {
+		var jobId string
+		if err := rows.Scan(&jobId); err != nil {
+			return nil, err
+		}
+		mirrored[jobId] = true
+	}
+	return mirrored, rows.Err()
+}
+
+// Delete removes the row of a job, if any. A record of the job still queued
+// for the background writer is not affected.
+func (m *Mirror) Delete(ctx context.Context, jobId string) error {
+	_, err := m.db.ExecContext(ctx, `DELETE FROM proof_jobs WHERE job_id = $1`, jobId)
+	return err
+}
+
+// Upsert writes records in a single transaction. Re-mirroring a job
+// overwrites its previous row, so the operation is idempotent.
+func (m *Mirror) Upsert(ctx context.Context, records []Record) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO proof_jobs (job_id, success, error_message, public_inputs, circuit_release, completed_at, mirrored_at)
+		VALUES ($1, $2, $3, $4, $5, $6, now())
+		ON CONFLICT (job_id) DO UPDATE SET
+			success = EXCLUDED.success,
+			error_message = EXCLUDED.error_message,
+			public_inputs = EXCLUDED.public_inputs,
+			circuit_release = EXCLUDED.circuit_release,
+			completed_at = EXCLUDED.completed_at,
+			mirrored_at = now()`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range records {
+		var publicInputs []byte
+		if r.PublicInputs != nil {
+			if publicInputs, err = json.Marshal(r.PublicInputs); err != nil {
+				return err
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, r.JobId, r.Success, r.ErrorMessage, publicInputs, r.CircuitRelease, r.CompletedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,135 @@
+//go:build integration
+
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// openTestMirror opens a mirror in a new schema of the database of
+// MIRROR_TEST_DATABASE_URL, dropped when the test ends, and returns it with
+// the URL of the schema.
+func openTestMirror(t *testing.T) (*Mirror, string) {
+	t.Helper()
+	databaseURL := os.Getenv("MIRROR_TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("MIRROR_TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+	admin, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("mirror_test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE") })
+
+	u, err := url.Parse(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	m, err := Open(ctx, u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close(ctx) })
+	return m, u.String()
+}
+
+func TestMigrationsApplyOnce(t *testing.T) {
+	ctx := context.Background()
+	m, schemaURL := openTestMirror(t)
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening the mirror again finds every migration applied.
+	again, err := Open(ctx, schemaURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close(ctx)
+	var applied int
+	if err := m.db.QueryRowContext(ctx, `SELECT count(*) FROM mirror_schema_migrations`).Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(names) {
+		t.Fatalf("%d migrations recorded, want %d", applied, len(names))
+	}
+	var columns int
+	if err := m.db.QueryRowContext(ctx, `SELECT count(*) FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'proof_jobs'`).Scan(&columns); err != nil {
+		t.Fatal(err)
+	}
+	if columns != 7 {
+		t.Fatalf("proof_jobs has %d columns, want 7", columns)
+	}
+}
+
+func TestUpsertIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	m, _ := openTestMirror(t)
+	jobId := uuid.NewString()
+	errMsg := "prover failed"
+	completedAt := time.Now().UTC().Truncate(time.Microsecond)
+	failed := Record{JobId: jobId, ErrorMessage: &errMsg, CircuitRelease: "0123456789ab", CompletedAt: completedAt}
+	for i := 0; i < 2; i++ {
+		if err := m.Upsert(ctx, []Record{failed}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var rows int
+	if err := m.db.QueryRowContext(ctx, `SELECT count(*) FROM proof_jobs WHERE job_id = $1`, jobId).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Fatalf("%d rows after upserting the job twice, want 1", rows)
+	}
+
+	// A retried job that succeeded overwrites the row.
+	succeeded := Record{JobId: jobId, Success: true, PublicInputs: []string{"1", "2"}, CircuitRelease: "0123456789ab", CompletedAt: completedAt.Add(time.Minute)}
+	if err := m.Write(ctx, []Record{succeeded}); err != nil {
+		t.Fatal(err)
+	}
+	var success bool
+	var storedErr sql.NullString
+	var publicInputs string
+	var storedAt time.Time
+	if err := m.db.QueryRowContext(ctx, `SELECT success, error_message, public_inputs, completed_at FROM proof_jobs WHERE job_id = $1`, jobId).
+		Scan(&success, &storedErr, &publicInputs, &storedAt); err != nil {
+		t.Fatal(err)
+	}
+	if !success || storedErr.Valid || publicInputs != `["1", "2"]` || !storedAt.Equal(succeeded.CompletedAt) {
+		t.Fatalf("row = %v, %v, %s, %v, want the succeeded record", success, storedErr, publicInputs, storedAt)
+	}
+	mirrored, err := m.Mirrored(ctx, []string{jobId, uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirrored) != 1 || !mirrored[jobId] {
+		t.Fatalf("Mirrored() = %v, want only %s", mirrored, jobId)
+	}
+
+	if err := m.Delete(ctx, jobId); err != nil {
+		t.Fatal(err)
+	}
+	if mirrored, err := m.Mirrored(ctx, []string{jobId}); err != nil || len(mirrored) != 0 {
+		t.Fatalf("Mirrored() after Delete() = %v, %v, want nothing", mirrored, err)
+	}
+}
@@ -0,0 +1,335 @@
+package objectstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// credentialsRefreshMargin is how long before they expire temporary
+	// credentials are refreshed.
+	credentialsRefreshMargin = 5 * time.Minute
+	metadataTimeout          = 2 * time.Second
+	defaultEC2MetadataURL    = "http://169.254.169.254"
+	ecsMetadataURL           = "http://169.254.170.2"
+)
+
+var metadataClient = &http.Client{Timeout: metadataTimeout}
+
+// awsCredentials are static credentials, or temporary ones that expire.
+type awsCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expires is zero for static credentials.
+	Expires time.Time
+}
+
+func (c awsCredentials) valid() bool {
+	return c.AccessKeyId != "" && (c.Expires.IsZero() || time.Until(c.Expires) > credentialsRefreshMargin)
+}
+
+// credentials returns the cached credentials, or loads them again when they
+// are about to expire.
+func (s *s3Store) credentials(ctx context.Context) (awsCredentials, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.creds.valid() {
+		return s.creds, nil
+	}
+	creds, err := loadAWSCredentials(ctx)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	s.creds = creds
+	return creds, nil
+}
+
+// loadAWSCredentials finds credentials in the order of the default chain of
+// the AWS SDKs: the environment, a web identity token as on EKS, the shared
+// credentials and config files, the ECS container endpoint and the EC2
+// instance metadata service. Profiles that assume a role, use SSO or run a
+// credential_process are not supported.
+func loadAWSCredentials(ctx context.Context) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{
+			AccessKeyId:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		return assumeRoleWithWebIdentity(ctx, tokenFile, os.Getenv("AWS_ROLE_ARN"))
+	}
+	if creds, ok := sharedCredentials(); ok {
+		return creds, nil
+	}
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		return containerCredentials(ctx)
+	}
+	if !strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		creds, err := instanceCredentials(ctx)
+		if err == nil {
+			return creds, nil
+		}
+		return awsCredentials{}, fmt.Errorf("no AWS credentials found in the environment, a web identity token, the shared files or the container endpoint, and the instance metadata service failed: %w", err)
+	}
+	return awsCredentials{}, errors.New("no AWS credentials found in the environment, a web identity token, the shared files or the container endpoint")
+}
+
+// assumeRoleWithWebIdentity exchanges the OIDC token in tokenFile for
+// credentials of roleArn, as IAM roles for service accounts provide them.
+func assumeRoleWithWebIdentity(ctx context.Context, tokenFile string, roleArn string) (awsCredentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to read the web identity token: %w", err)
+	}
+	if roleArn == "" {
+		return awsCredentials{}, errors.New("AWS_WEB_IDENTITY_TOKEN_FILE is set without AWS_ROLE_ARN")
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("gnark-server-%d", time.Now().UnixNano())
+	}
+	endpoint := "https://sts.amazonaws.com/"
+	if region := awsRegion(); os.Getenv("AWS_STS_REGIONAL_ENDPOINTS") != "legacy" {
+		endpoint = "https://sts." + region + ".amazonaws.com/"
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleArn},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(query.Encode()))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to assume %s: %w", roleArn, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("failed to assume %s: %w", roleArn, newStatusError(resp))
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Credentials struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to parse the credentials of %s: %w", roleArn, err)
+	}
+	c := result.Credentials
+	return awsCredentials{AccessKeyId: c.AccessKeyId, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken, Expires: c.Expiration}, nil
+}
+
+// sharedCredentials returns the static keys of the profile AWS_PROFILE, or
+// default, from the shared credentials file or the shared config file.
+func sharedCredentials() (awsCredentials, bool) {
+	for _, profile := range []map[string]string{awsCredentialsProfile(), awsConfigProfile()} {
+		if profile["aws_access_key_id"] != "" {
+			return awsCredentials{
+				AccessKeyId:     profile["aws_access_key_id"],
+				SecretAccessKey: profile["aws_secret_access_key"],
+				SessionToken:    profile["aws_session_token"],
+			}, true
+		}
+	}
+	return awsCredentials{}, false
+}
+
+func awsProfile() string {
+	if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+// awsCredentialsProfile returns the section of the profile in the shared
+// credentials file, ~/.aws/credentials unless AWS_SHARED_CREDENTIALS_FILE
+// is set.
+func awsCredentialsProfile() map[string]string {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		path = homePath(".aws", "credentials")
+	}
+	return readINISection(path, awsProfile())
+}
+
+// awsConfigProfile returns the section of the profile in the shared config
+// file, ~/.aws/config unless AWS_CONFIG_FILE is set. Profiles other than
+// default are named "profile <name>" there.
+func awsConfigProfile() map[string]string {
+	path := os.Getenv("AWS_CONFIG_FILE")
+	if path == "" {
+		path = homePath(".aws", "config")
+	}
+	section := awsProfile()
+	if section != "default" {
+		section = "profile " + section
+	}
+	return readINISection(path, section)
+}
+
+func homePath(elem ...string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(append([]string{home}, elem...)...)
+}
+
+// readINISection returns the keys of section in the INI file at path, or
+// nil if the file or the section does not exist.
+func readINISection(path string, section string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var values map[string]string
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == section
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && inSection {
+			if values == nil {
+				values = map[string]string{}
+			}
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
+
+// metadataCredentials is how the container endpoint and the instance
+// metadata service encode credentials.
+type metadataCredentials struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+func (c metadataCredentials) credentials() awsCredentials {
+	return awsCredentials{AccessKeyId: c.AccessKeyId, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expires: c.Expiration}
+}
+
+// containerCredentials requests the credentials of the ECS task role, or of
+// EKS Pod Identity, from the container credentials endpoint.
+func containerCredentials(ctx context.Context) (awsCredentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = ecsMetadataURL + relative
+	}
+	header := http.Header{}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		raw, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return awsCredentials{}, fmt.Errorf("failed to read the container authorization token: %w", err)
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+	if token != "" {
+		header.Set("Authorization", token)
+	}
+	var creds metadataCredentials
+	if err := getMetadataJSON(ctx, endpoint, header, &creds); err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to get the container credentials: %w", err)
+	}
+	return creds.credentials(), nil
+}
+
+// instanceCredentials requests the credentials of the instance profile from
+// the EC2 instance metadata service, with a session token as IMDSv2
+// requires.
+func instanceCredentials(ctx context.Context) (awsCredentials, error) {
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultEC2MetadataURL
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	token, err := metadataText(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	header := http.Header{}
+	header.Set("X-Aws-Ec2-Metadata-Token", token)
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/latest/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header = header.Clone()
+	roles, err := metadataText(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("the instance has no instance profile: %w", err)
+	}
+	role, _, _ := strings.Cut(roles, "\n")
+	var creds metadataCredentials
+	if err := getMetadataJSON(ctx, endpoint+"/latest/meta-data/iam/security-credentials/"+role, header, &creds); err != nil {
+		return awsCredentials{}, err
+	}
+	return creds.credentials(), nil
+}
+
+func getMetadataJSON(ctx context.Context, endpoint string, header http.Header, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	text, err := metadataText(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(text), v)
+}
+
+// metadataText sends req to a metadata service and returns the body of the
+// response.
+func metadataText(req *http.Request) (string, error) {
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status %d", req.URL.Redacted(), resp.StatusCode)
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
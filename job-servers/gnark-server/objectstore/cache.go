@@ -0,0 +1,104 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Fetch returns the path of a copy of the object at rawURL in dir, named
+// after the URL and the ETag of the object. The object is only downloaded
+// when dir has no complete copy of its current ETag, and copies of its
+// previous versions are then removed. The download is written to a
+// temporary file and renamed, so an interrupted one is never used.
+func Fetch(ctx context.Context, rawURL string, dir string) (string, error) {
+	loc, err := parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	obj, err := Stat(ctx, rawURL)
+	if err != nil {
+		return "", err
+	}
+	etag := cacheETag(obj.ETag)
+	if etag == "" {
+		return "", fmt.Errorf("%s has no ETag to cache it by", loc)
+	}
+	prefix := cachePrefix(loc)
+	path := filepath.Join(dir, prefix+etag)
+	if info, err := os.Stat(path); err == nil && info.Size() == obj.Size {
+		log.Printf("Using the cached copy of %s at %s\n", loc, path)
+		return path, nil
+	}
+
+	startedAt := time.Now()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := download(ctx, loc, obj.ETag, path); err != nil {
+		return "", err
+	}
+	log.Printf("Downloaded %s (%d bytes) to %s in %v\n", loc, obj.Size, path, time.Since(startedAt).Round(time.Millisecond))
+	stale, _ := filepath.Glob(filepath.Join(dir, prefix+"*"))
+	for _, old := range stale {
+		if old != path && !strings.HasSuffix(old, ".part") {
+			os.Remove(old)
+		}
+	}
+	return path, nil
+}
+
+func download(ctx context.Context, loc location, etag string, path string) error {
+	body, obj, err := open(ctx, loc, etag)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	n, err := io.Copy(f, body)
+	if err == nil && n != obj.Size {
+		err = fmt.Errorf("%s: downloaded %d of %d bytes", loc, n, obj.Size)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// cachePrefix starts the names of the copies of the object at loc.
+func cachePrefix(loc location) string {
+	sum := sha256.Sum256([]byte(loc.String()))
+	return hex.EncodeToString(sum[:8]) + "-" + sanitize(filepath.Base(loc.key)) + "-"
+}
+
+// cacheETag returns etag without its quotes, usable in a file name.
+func cacheETag(etag string) string {
+	return sanitize(strings.Trim(strings.TrimPrefix(etag, "W/"), `"`))
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '.' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
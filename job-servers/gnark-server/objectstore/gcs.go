@@ -0,0 +1,227 @@
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	gcsEndpoint       = "https://storage.googleapis.com"
+	gcsReadOnlyScope  = "https://www.googleapis.com/auth/devstorage.read_only"
+	googleTokenURL    = "https://oauth2.googleapis.com/token"
+	defaultGCEMetaURL = "http://metadata.google.internal"
+)
+
+// gcsStore requests objects from the XML API of Google Cloud Storage, or
+// from the emulator at STORAGE_EMULATOR_HOST, which needs no credentials.
+type gcsStore struct {
+	mu    sync.Mutex
+	token googleToken
+}
+
+// googleToken is an OAuth2 access token.
+type googleToken struct {
+	value   string
+	expires time.Time
+}
+
+func (t googleToken) valid() bool {
+	return t.value != "" && time.Until(t.expires) > credentialsRefreshMargin
+}
+
+func (s *gcsStore) newRequest(ctx context.Context, method string, loc location) (*http.Request, error) {
+	endpoint := gcsEndpoint
+	emulator := os.Getenv("STORAGE_EMULATOR_HOST")
+	if emulator != "" {
+		endpoint = emulator
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(endpoint, "/")+"/"+loc.bucket+"/"+escapeKey(loc.key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if emulator == "" {
+		token, err := s.accessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// accessToken returns the cached access token, or a new one when it is
+// about to expire.
+func (s *gcsStore) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token.valid() {
+		return s.token.value, nil
+	}
+	token, err := loadGoogleToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	return token.value, nil
+}
+
+// loadGoogleToken gets an access token from the application default
+// credentials: the file of GOOGLE_APPLICATION_CREDENTIALS, the one gcloud
+// auth application-default login writes, or the metadata server of GCE and
+// GKE workload identity. Service account keys and user credentials are
+// supported in files, not external accounts.
+func loadGoogleToken(ctx context.Context) (googleToken, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		configDir := os.Getenv("CLOUDSDK_CONFIG")
+		if configDir == "" {
+			configDir = homePath(".config", "gcloud")
+		}
+		path = filepath.Join(configDir, "application_default_credentials.json")
+		if _, err := os.Stat(path); err != nil {
+			path = ""
+		}
+	}
+	if path != "" {
+		return credentialsFileToken(ctx, path)
+	}
+	token, err := metadataServerToken(ctx)
+	if err != nil {
+		return googleToken{}, fmt.Errorf("no Google credentials found in GOOGLE_APPLICATION_CREDENTIALS or the gcloud configuration, and the metadata server failed: %w", err)
+	}
+	return token, nil
+}
+
+// googleCredentialsFile holds the fields of the credential files used to
+// get a token.
+type googleCredentialsFile struct {
+	Type string `json:"type"`
+	// A service account key.
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyId string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	// User credentials.
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func credentialsFileToken(ctx context.Context, path string) (googleToken, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return googleToken{}, fmt.Errorf("failed to read the Google credentials: %w", err)
+	}
+	var file googleCredentialsFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return googleToken{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	switch file.Type {
+	case "service_account":
+		return serviceAccountToken(ctx, file)
+	case "authorized_user":
+		return requestGoogleToken(ctx, googleTokenURL, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {file.ClientId},
+			"client_secret": {file.ClientSecret},
+			"refresh_token": {file.RefreshToken},
+		})
+	default:
+		return googleToken{}, fmt.Errorf("%s has Google credentials of type %q, only service_account and authorized_user are supported", path, file.Type)
+	}
+}
+
+// serviceAccountToken exchanges a JWT signed with the key of a service
+// account for an access token.
+func serviceAccountToken(ctx context.Context, file googleCredentialsFile) (googleToken, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(file.PrivateKey))
+	if err != nil {
+		return googleToken{}, fmt.Errorf("invalid private key of %s: %w", file.ClientEmail, err)
+	}
+	tokenURI := file.TokenURI
+	if tokenURI == "" {
+		tokenURI = googleTokenURL
+	}
+	now := time.Now()
+	assertion := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   file.ClientEmail,
+		"scope": gcsReadOnlyScope,
+		"aud":   tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	assertion.Header["kid"] = file.PrivateKeyId
+	signed, err := assertion.SignedString(key)
+	if err != nil {
+		return googleToken{}, err
+	}
+	return requestGoogleToken(ctx, tokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {signed},
+	})
+}
+
+// googleTokenResponse is the response of the token endpoint and of the
+// metadata server.
+type googleTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (r googleTokenResponse) token() (googleToken, error) {
+	if r.AccessToken == "" {
+		return googleToken{}, errors.New("the response has no access token")
+	}
+	return googleToken{value: r.AccessToken, expires: time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)}, nil
+}
+
+func requestGoogleToken(ctx context.Context, tokenURI string, form url.Values) (googleToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return googleToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return googleToken{}, fmt.Errorf("failed to get a Google access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return googleToken{}, fmt.Errorf("failed to get a Google access token: status %d", resp.StatusCode)
+	}
+	var body googleTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return googleToken{}, fmt.Errorf("failed to parse the Google access token: %w", err)
+	}
+	return body.token()
+}
+
+// metadataServerToken gets a token of the default service account of the
+// instance, or of the Kubernetes service account with workload identity.
+func metadataServerToken(ctx context.Context) (googleToken, error) {
+	endpoint := defaultGCEMetaURL
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		endpoint = "http://" + host
+	}
+	header := http.Header{}
+	header.Set("Metadata-Flavor", "Google")
+	var body googleTokenResponse
+	if err := getMetadataJSON(ctx, endpoint+"/computeMetadata/v1/instance/service-accounts/default/token", header, &body); err != nil {
+		return googleToken{}, err
+	}
+	return body.token()
+}
@@ -0,0 +1,300 @@
+// Package objectstore reads objects from Amazon S3 and Google Cloud Storage,
+// for circuit artifacts too large to bake into an image. It speaks the HTTP
+// APIs of both directly, with credentials found the way the default chains
+// of their SDKs find them. Transient failures are retried with exponential
+// backoff, and a read that fails midway resumes where it stopped.
+package objectstore
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	maxAttempts    = 5
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+	// responseHeaderTimeout bounds the wait for a response, not the
+	// download of its body, which may be many gigabytes.
+	responseHeaderTimeout = 30 * time.Second
+)
+
+// ErrChanged is returned when an object is overwritten while it is read.
+var ErrChanged = errors.New("object changed while it was read")
+
+// IsURL reports whether path is an s3:// or gs:// URL rather than a local
+// path.
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://")
+}
+
+// Object describes a stored object.
+type Object struct {
+	URL  string
+	Size int64
+	// ETag changes whenever the object is overwritten.
+	ETag string
+}
+
+// location is a parsed object URL.
+type location struct {
+	scheme, bucket, key string
+}
+
+func parse(rawURL string) (location, error) {
+	scheme, rest, ok := strings.Cut(rawURL, "://")
+	bucket, key, _ := strings.Cut(rest, "/")
+	if !ok || (scheme != "s3" && scheme != "gs") || bucket == "" || key == "" {
+		return location{}, fmt.Errorf("invalid object URL %q, expected s3://<bucket>/<key> or gs://<bucket>/<key>", rawURL)
+	}
+	return location{scheme, bucket, key}, nil
+}
+
+func (l location) String() string {
+	return l.scheme + "://" + l.bucket + "/" + l.key
+}
+
+// store builds the authenticated requests of a storage service.
+type store interface {
+	newRequest(ctx context.Context, method string, loc location) (*http.Request, error)
+}
+
+// redirector is implemented by stores that can follow a redirect response
+// by building the next request differently. It reports whether the request
+// should be sent again.
+type redirector interface {
+	redirected(loc location, resp *http.Response) bool
+}
+
+var stores = map[string]store{
+	"s3": &s3Store{},
+	"gs": &gcsStore{},
+}
+
+var httpClient = newHTTPClient()
+
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	// Objects are read as stored: sizes, ranges and hashes refer to the
+	// stored bytes.
+	transport.DisableCompression = true
+	return &http.Client{
+		Transport: transport,
+		// Redirects are followed by the stores, which must sign the
+		// request for its new endpoint.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// Stat returns the size and ETag of the object at rawURL. An object that
+// does not exist returns an error wrapping os.ErrNotExist.
+func Stat(ctx context.Context, rawURL string) (Object, error) {
+	loc, err := parse(rawURL)
+	if err != nil {
+		return Object{}, err
+	}
+	resp, err := do(ctx, http.MethodHead, loc, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	return objectOf(loc, resp), nil
+}
+
+// Open returns a reader of the object at rawURL. If reading fails midway,
+// the reader requests the rest of the object, as long as it was not
+// overwritten in the meantime.
+func Open(ctx context.Context, rawURL string) (io.ReadCloser, Object, error) {
+	loc, err := parse(rawURL)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	return open(ctx, loc, "")
+}
+
+// open is Open, requiring the object to have etag unless it is empty.
+func open(ctx context.Context, loc location, etag string) (io.ReadCloser, Object, error) {
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-Match", etag)
+	}
+	resp, err := do(ctx, http.MethodGet, loc, header)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	obj := objectOf(loc, resp)
+	return &reader{ctx: ctx, loc: loc, obj: obj, body: resp.Body}, obj, nil
+}
+
+func objectOf(loc location, resp *http.Response) Object {
+	return Object{URL: loc.String(), Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
+}
+
+// do sends the request of method for loc with header, retrying transient
+// failures. It returns the response if its status is 2xx.
+func do(ctx context.Context, method string, loc location, header http.Header) (*http.Response, error) {
+	st := stores[loc.scheme]
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		req, err := st.newRequest(ctx, method, loc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", loc, err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := httpClient.Do(req)
+		if err == nil && resp.StatusCode < 300 {
+			return resp, nil
+		}
+		if err == nil {
+			if r, ok := st.(redirector); ok && attempt < maxAttempts && r.redirected(loc, resp) {
+				resp.Body.Close()
+				continue
+			}
+			err = newStatusError(resp)
+		}
+		if !isTransient(ctx, err) || attempt == maxAttempts {
+			return nil, fmt.Errorf("%s: %w", loc, err)
+		}
+		log.Printf("Retrying %s of %s in %v after attempt %d failed: %v\n", method, loc, backoff, attempt, err)
+		if err := sleep(ctx, backoff); err != nil {
+			return nil, err
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// statusError is a response with a status other than 2xx. Both services
+// describe errors with an XML body holding a code and a message.
+type statusError struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func newStatusError(resp *http.Response) *statusError {
+	defer resp.Body.Close()
+	err := &statusError{StatusCode: resp.StatusCode}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	xml.Unmarshal(raw, err)
+	return err
+}
+
+func (e *statusError) Error() string {
+	message := fmt.Sprintf("status %d", e.StatusCode)
+	if e.Code != "" {
+		message += " " + e.Code
+	}
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	return message
+}
+
+func (e *statusError) Is(target error) bool {
+	switch target {
+	case os.ErrNotExist:
+		return e.StatusCode == http.StatusNotFound
+	case ErrChanged:
+		return e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// isTransient reports whether a request that failed with err may succeed
+// if it is sent again: network errors, timeouts, throttling and 5xx.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests ||
+			statusErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// reader reads the body of an object, requesting the rest of the object
+// again when the connection fails.
+type reader struct {
+	ctx      context.Context
+	loc      location
+	obj      Object
+	body     io.ReadCloser
+	offset   int64
+	attempts int
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == io.EOF && r.obj.Size >= 0 && r.offset < r.obj.Size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		if resumeErr := r.resume(err); resumeErr != nil {
+			return n, resumeErr
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resume requests the object again from the offset reached, after the body
+// failed with err.
+func (r *reader) resume(err error) error {
+	r.body.Close()
+	r.attempts++
+	if r.ctx.Err() != nil || r.attempts >= maxAttempts || r.obj.ETag == "" {
+		return fmt.Errorf("%s: after %d bytes: %w", r.loc, r.offset, err)
+	}
+	backoff := min(initialBackoff<<(r.attempts-1), maxBackoff)
+	log.Printf("Resuming the read of %s at byte %d in %v: %v\n", r.loc, r.offset, backoff, err)
+	if err := sleep(r.ctx, backoff); err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("If-Match", r.obj.ETag)
+	header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	resp, err := do(r.ctx, http.MethodGet, r.loc, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return fmt.Errorf("%s: expected a partial response to resume at byte %d, got status %d", r.loc, r.offset, resp.StatusCode)
+	}
+	r.body = resp.Body
+	return nil
+}
+
+func (r *reader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,174 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	// unsignedPayload is the payload hash of requests without a body whose
+	// payload is not signed.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// s3Store requests objects from S3, or from the S3 compatible endpoint of
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL, such as MinIO.
+type s3Store struct {
+	mu    sync.Mutex
+	creds awsCredentials
+	// regions holds the region of the buckets that redirected a request
+	// sent to another region.
+	regions map[string]string
+}
+
+func (s *s3Store) newRequest(ctx context.Context, method string, loc location) (*http.Request, error) {
+	creds, err := s.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	region := s.region(loc.bucket)
+	var target string
+	if endpoint := s3Endpoint(); endpoint != "" {
+		// S3 compatible stores expect path-style requests.
+		target = strings.TrimSuffix(endpoint, "/") + "/" + loc.bucket + "/" + escapeKey(loc.key)
+	} else if strings.Contains(loc.bucket, ".") {
+		// The wildcard certificate of the virtual host does not cover
+		// bucket names with dots.
+		target = fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", region, loc.bucket, escapeKey(loc.key))
+	} else {
+		target = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", loc.bucket, region, escapeKey(loc.key))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	signV4(req, creds, region, time.Now())
+	return req, nil
+}
+
+// redirected records the region of a bucket that S3 reported in a 301
+// response to a request sent to another region.
+func (s *s3Store) redirected(loc location, resp *http.Response) bool {
+	region := resp.Header.Get("X-Amz-Bucket-Region")
+	if resp.StatusCode != http.StatusMovedPermanently || region == "" || region == s.region(loc.bucket) {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.regions == nil {
+		s.regions = map[string]string{}
+	}
+	s.regions[loc.bucket] = region
+	return true
+}
+
+// region returns the region of bucket: the one S3 redirected to, or the
+// configured region.
+func (s *s3Store) region(bucket string) string {
+	s.mu.Lock()
+	region, ok := s.regions[bucket]
+	s.mu.Unlock()
+	if ok {
+		return region
+	}
+	return awsRegion()
+}
+
+// awsRegion returns the region of AWS_REGION, AWS_DEFAULT_REGION or the
+// profile of the shared config file, or us-east-1.
+func awsRegion() string {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			return region
+		}
+	}
+	if region := awsConfigProfile()["region"]; region != "" {
+		return region
+	}
+	return defaultS3Region
+}
+
+func s3Endpoint() string {
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL_S3"); endpoint != "" {
+		return endpoint
+	}
+	return os.Getenv("AWS_ENDPOINT_URL")
+}
+
+// escapeKey escapes the segments of an object key as SigV4 canonical URIs
+// require: everything but unreserved characters is percent-encoded.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signV4 signs req, which has no body and no query, and its headers with
+// AWS Signature Version 4 for S3 in region. The payload is not signed unless
+// X-Amz-Content-Sha256 is set.
+func signV4(req *http.Request, creds awsCredentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyId, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
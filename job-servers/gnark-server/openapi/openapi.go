@@ -0,0 +1,317 @@
+// Package openapi builds an OpenAPI 3 description of the HTTP API from the Go
+// types of its payloads, so that the published schema is the one the handlers
+// encode, and checks example payloads against it so that the examples cannot
+// drift from the code either.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI version of the documents built here.
+const Version = "3.0.3"
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+
+	// localPackage is the package whose types get unqualified schema names.
+	localPackage string
+	types        map[string]reflect.Type
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem maps the lower-case HTTP methods of a path to their operations.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string               `json:"summary"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+	// Security lists the security schemes any of which authorizes the
+	// operation. It is empty for unauthenticated operations.
+	Security []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Headers     map[string]Header    `json:"headers,omitempty"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema  *Schema         `json:"schema,omitempty"`
+	Example json.RawMessage `json:"example,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Schema is the subset of the OpenAPI schema object that Go types map to.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// New returns an empty document. Schemas of types declared in localPackage
+// are named after the type alone, and those of other packages are prefixed
+// with their package name, such as solc.Result.
+func New(info Info, localPackage string) *Document {
+	return &Document{
+		OpenAPI:      Version,
+		Info:         info,
+		Paths:        map[string]*PathItem{},
+		Components:   Components{Schemas: map[string]*Schema{}},
+		localPackage: localPackage,
+		types:        map[string]reflect.Type{},
+	}
+}
+
+// Add adds op under method and path.
+func (d *Document) Add(path string, method string, op *Operation) error {
+	item := d.Paths[path]
+	if item == nil {
+		item = &PathItem{}
+		d.Paths[path] = item
+	}
+	method = strings.ToLower(method)
+	if (*item)[method] != nil {
+		return fmt.Errorf("%s %s is described twice", strings.ToUpper(method), path)
+	}
+	(*item)[method] = op
+	return nil
+}
+
+// Schema returns the schema of the JSON encoding of v, registering the
+// structs it contains as components.
+func (d *Document) Schema(v interface{}) (*Schema, error) {
+	return d.schemaOf(reflect.TypeOf(v))
+}
+
+// AnyOf returns the schema of a payload that is the JSON encoding of any of
+// values.
+func (d *Document) AnyOf(values ...interface{}) (*Schema, error) {
+	schema := &Schema{}
+	for _, v := range values {
+		s, err := d.Schema(v)
+		if err != nil {
+			return nil, err
+		}
+		schema.AnyOf = append(schema.AnyOf, s)
+	}
+	return schema, nil
+}
+
+func (d *Document) schemaName(t reflect.Type) string {
+	if t.PkgPath() == d.localPackage {
+		return t.Name()
+	}
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (d *Document) schemaOf(t reflect.Type) (*Schema, error) {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	case rawMessageType:
+		return &Schema{}, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}, nil
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Ptr:
+		return d.schemaOf(t.Elem())
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}, nil
+		}
+		items, err := d.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map key of %s is not a string", t)
+		}
+		values, err := d.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		name := d.schemaName(t)
+		ref := &Schema{Ref: "#/components/schemas/" + name}
+		if existing, ok := d.types[name]; ok {
+			if existing != t {
+				return nil, fmt.Errorf("schema %s is used by both %s and %s", name, existing, t)
+			}
+			return ref, nil
+		}
+		d.types[name] = t
+		schema, err := d.structSchema(t)
+		if err != nil {
+			return nil, err
+		}
+		d.Components.Schemas[name] = schema
+		return ref, nil
+	}
+	return nil, fmt.Errorf("type %s has no JSON schema", t)
+}
+
+// structSchema describes the JSON object encoding/json makes of t. Fields
+// without omitempty are always encoded and therefore required, and pointers
+// encoded without omitempty may be null.
+func (d *Document) structSchema(t reflect.Type) (*Schema, error) {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		omitempty := strings.Contains(","+options+",", ",omitempty,")
+		property, err := d.schemaOf(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t, field.Name, err)
+		}
+		if field.Type.Kind() == reflect.Ptr && !omitempty {
+			property = nullable(property)
+		}
+		schema.Properties[name] = property
+		if !omitempty {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema, nil
+}
+
+// nullable returns schema allowing null. References are wrapped in allOf,
+// since OpenAPI 3.0 ignores the siblings of $ref.
+func nullable(schema *Schema) *Schema {
+	if schema.Ref != "" {
+		return &Schema{AllOf: []*Schema{schema}, Nullable: true}
+	}
+	schema.Nullable = true
+	return schema
+}
+
+// SetEnum restricts property of the struct schema of v to values.
+func (d *Document) SetEnum(v interface{}, property string, values []string) error {
+	schema, err := d.component(v)
+	if err != nil {
+		return err
+	}
+	p := schema.Properties[property]
+	if p == nil {
+		return fmt.Errorf("schema of %T has no property %s", v, property)
+	}
+	if p.Type != "string" {
+		return fmt.Errorf("property %s of %T is not a string", property, v)
+	}
+	p.Enum = values
+	return nil
+}
+
+// SetDescription describes property of the struct schema of v.
+func (d *Document) SetDescription(v interface{}, property string, description string) error {
+	schema, err := d.component(v)
+	if err != nil {
+		return err
+	}
+	p := schema.Properties[property]
+	if p == nil {
+		return fmt.Errorf("schema of %T has no property %s", v, property)
+	}
+	p.Description = description
+	return nil
+}
+
+func (d *Document) component(v interface{}) (*Schema, error) {
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Struct || t.Name() == "" {
+		return nil, fmt.Errorf("%T is not a named struct", v)
+	}
+	if _, err := d.schemaOf(t); err != nil {
+		return nil, err
+	}
+	return d.Components.Schemas[d.schemaName(t)], nil
+}
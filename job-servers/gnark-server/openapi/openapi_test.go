@@ -0,0 +1,109 @@
+package openapi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testJob struct {
+	JobId    string     `json:"jobId"`
+	State    string     `json:"state"`
+	Error    *string    `json:"error"`
+	Attempts int        `json:"attempts,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+	At       time.Time  `json:"at"`
+	Parent   *testJob   `json:"parent,omitempty"`
+	Extra    testExtras `json:"extra"`
+	internal string
+}
+
+type testExtras map[string]json.RawMessage
+
+func testDocument(t *testing.T) (*Document, *Schema) {
+	t.Helper()
+	doc := New(Info{Title: "test", Version: "1.0"}, "gnark-server/openapi")
+	schema, err := doc.Schema(testJob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.SetEnum(testJob{}, "state", []string{"queued", "done"}); err != nil {
+		t.Fatal(err)
+	}
+	return doc, schema
+}
+
+func TestSchema(t *testing.T) {
+	doc, schema := testDocument(t)
+	if schema.Ref != "#/components/schemas/testJob" {
+		t.Fatalf("Schema() = %+v, want a reference to testJob", schema)
+	}
+	job := doc.Components.Schemas["testJob"]
+	if got := strings.Join(job.Required, ","); got != "at,error,extra,jobId,state" {
+		t.Fatalf("required = %s, want the fields without omitempty", got)
+	}
+	if _, ok := job.Properties["internal"]; ok {
+		t.Fatal("unexported field described")
+	}
+	if p := job.Properties["error"]; !p.Nullable || p.Type != "string" {
+		t.Fatalf("error = %+v, want a nullable string", p)
+	}
+	if p := job.Properties["at"]; p.Format != "date-time" {
+		t.Fatalf("at = %+v, want a date-time", p)
+	}
+	if p := job.Properties["parent"]; p.Ref != "#/components/schemas/testJob" {
+		t.Fatalf("parent = %+v, want a reference to testJob", p)
+	}
+	if err := doc.SetEnum(testJob{}, "attempts", []string{"1"}); err == nil {
+		t.Fatal("SetEnum() accepted an integer property")
+	}
+	if err := doc.SetEnum(testJob{}, "missing", []string{"1"}); err == nil {
+		t.Fatal("SetEnum() accepted an unknown property")
+	}
+}
+
+func TestValidateExample(t *testing.T) {
+	doc, schema := testDocument(t)
+	tests := []struct {
+		name    string
+		example string
+		wantErr string
+	}{
+		{"valid", `{"jobId":"a","state":"done","error":null,"at":"2024-07-01T12:00:00Z","extra":{"k":[1]},"tags":["x"],"parent":{"jobId":"b","state":"queued","error":"failed","at":"2024-07-01T11:00:00.5Z","extra":{}}}`, ""},
+		{"missing required", `{"jobId":"a","state":"done","error":null,"extra":{}}`, "$: missing required property at"},
+		{"unknown property", `{"jobId":"a","state":"done","error":null,"at":"2024-07-01T12:00:00Z","extra":{},"status":"done"}`, "$: unknown property status"},
+		{"not in the enum", `{"jobId":"a","state":"proving","error":null,"at":"2024-07-01T12:00:00Z","extra":{}}`, `$.state: "proving" is not one of queued, done`},
+		{"wrong type", `{"jobId":1,"state":"done","error":null,"at":"2024-07-01T12:00:00Z","extra":{}}`, "$.jobId: expected a string"},
+		{"fractional integer", `{"jobId":"a","state":"done","error":null,"at":"2024-07-01T12:00:00Z","extra":{},"attempts":1.5}`, "$.attempts: 1.5 is not a valid integer"},
+		{"not a time", `{"jobId":"a","state":"done","error":null,"at":"yesterday","extra":{}}`, `$.at: "yesterday" is not an RFC 3339 time`},
+		{"null not nullable", `{"jobId":null,"state":"done","error":null,"at":"2024-07-01T12:00:00Z","extra":{}}`, "$.jobId: null is not allowed"},
+		{"nested", `{"jobId":"a","state":"done","error":null,"at":"2024-07-01T12:00:00Z","extra":{},"tags":[1]}`, "$.tags[0]: expected a string"},
+		{"not JSON", `{`, "not valid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := doc.ValidateExample(schema, []byte(tt.example))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateExample() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	anyOf, err := doc.AnyOf(testJob{}, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.ValidateExample(anyOf, []byte(`["a"]`)); err != nil {
+		t.Fatalf("ValidateExample() of an alternative = %v", err)
+	}
+	if err := doc.ValidateExample(anyOf, []byte(`"a"`)); err == nil {
+		t.Fatal("ValidateExample() accepted a payload matching no alternative")
+	}
+}
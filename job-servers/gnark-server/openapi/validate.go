@@ -0,0 +1,144 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValidateExample checks that the JSON payload example matches schema. It is
+// stricter than OpenAPI: properties a struct schema does not list are
+// rejected, so that an example keeping a removed or renamed field fails.
+func (d *Document) ValidateExample(schema *Schema, example []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(example))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("example is not valid JSON: %w", err)
+	}
+	return d.validate(schema, value, "$")
+}
+
+func (d *Document) resolve(schema *Schema) (*Schema, error) {
+	if schema.Ref == "" {
+		return schema, nil
+	}
+	name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+	resolved := d.Components.Schemas[name]
+	if resolved == nil {
+		return nil, fmt.Errorf("unknown schema %s", schema.Ref)
+	}
+	return resolved, nil
+}
+
+func (d *Document) validate(schema *Schema, value interface{}, at string) error {
+	if value == nil {
+		if schema.Nullable || (schema.Type == "" && len(schema.AllOf) == 0 && len(schema.AnyOf) == 0 && schema.Ref == "") {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", at)
+	}
+	for _, sub := range schema.AllOf {
+		if err := d.validate(sub, value, at); err != nil {
+			return err
+		}
+	}
+	if len(schema.AnyOf) > 0 {
+		var errs []string
+		for _, sub := range schema.AnyOf {
+			err := d.validate(sub, value, at)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%s: matches no alternative: %s", at, strings.Join(errs, "; "))
+	}
+	schema, err := d.resolve(schema)
+	if err != nil {
+		return fmt.Errorf("%s: %w", at, err)
+	}
+	switch schema.Type {
+	case "":
+		return nil
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean", at)
+		}
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected a %s", at, schema.Type)
+		}
+		f, err := n.Float64()
+		if err != nil || (schema.Type == "integer" && f != math.Trunc(f)) {
+			return fmt.Errorf("%s: %s is not a valid %s", at, n, schema.Type)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string", at)
+		}
+		if len(schema.Enum) > 0 && !contains(schema.Enum, s) {
+			return fmt.Errorf("%s: %q is not one of %s", at, s, strings.Join(schema.Enum, ", "))
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %q is not an RFC 3339 time", at, s)
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array", at)
+		}
+		for i, item := range items {
+			if err := d.validate(schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object", at)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing required property %s", at, name)
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property := schema.Properties[name]
+			if property == nil {
+				property = schema.AdditionalProperties
+			}
+			if property == nil {
+				return fmt.Errorf("%s: unknown property %s", at, name)
+			}
+			if err := d.validate(property, object[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unknown schema type %s", at, schema.Type)
+	}
+	return nil
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+// Package proofcache stores finished proofs by the public inputs they prove,
+// so that resubmitting the same transaction data does not prove it again.
+package proofcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisKeyPrefix = "gnark_proof_cache:"
+
+// Entry is a cached proof.
+type Entry struct {
+	PublicInputs []string `json:"publicInputs"`
+	// Proof is the hex proof in the layout of the Solidity verifier.
+	Proof string `json:"proof"`
+}
+
+// Cache is a Redis backed proof cache. Entries are namespaced by circuit
+// release, so proofs made with other keys are never returned.
+type Cache struct {
+	rdb redis.UniversalClient
+	ttl time.Duration
+}
+
+// New returns a cache whose entries expire after ttl.
+func New(rdb redis.UniversalClient, ttl time.Duration) *Cache {
+	return &Cache{rdb: rdb, ttl: ttl}
+}
+
+// Key returns the cache key of a serialized public witness: its hex SHA-256.
+func Key(publicWitness []byte) string {
+	h := sha256.Sum256(publicWitness)
+	return hex.EncodeToString(h[:])
+}
+
+func redisKey(release string, key string) string {
+	return redisKeyPrefix + release + ":" + key
+}
+
+// Get returns the entry of circuit release stored under key, or nil if there
+// is none.
+func (c *Cache) Get(ctx context.Context, release string, key string) (*Entry, error) {
+	entryJSON, err := c.rdb.Get(ctx, redisKey(release, key)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(entryJSON, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Delete removes the entry of circuit release stored under key, if any.
+func (c *Cache) Delete(ctx context.Context, release string, key string) error {
+	return c.rdb.Del(ctx, redisKey(release, key)).Err()
+}
+
+// Put stores entry of circuit release under key.
+func (c *Cache) Put(ctx context.Context, release string, key string, entry Entry) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, redisKey(release, key), entryJSON, c.ttl).Err()
+}
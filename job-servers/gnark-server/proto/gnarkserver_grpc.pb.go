@@ -0,0 +1,186 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: gnarkserver.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	GnarkServer_StartProof_FullMethodName = "/gnarkserver.GnarkServer/StartProof"
+	GnarkServer_GetProof_FullMethodName   = "/gnarkserver.GnarkServer/GetProof"
+	GnarkServer_Health_FullMethodName     = "/gnarkserver.GnarkServer/Health"
+)
+
+// GnarkServerClient is the client API for GnarkServer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GnarkServerClient interface {
+	StartProof(ctx context.Context, in *ProofRequest, opts ...grpc.CallOption) (*StartProofResponse, error)
+	GetProof(ctx context.Context, in *GetProofRequest, opts ...grpc.CallOption) (*GetProofResponse, error)
+	Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error)
+}
+
+type gnarkServerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGnarkServerClient(cc grpc.ClientConnInterface) GnarkServerClient {
+	return &gnarkServerClient{cc}
+}
+
+func (c *gnarkServerClient) StartProof(ctx context.Context, in *ProofRequest, opts ...grpc.CallOption) (*StartProofResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StartProofResponse)
+	err := c.cc.Invoke(ctx, GnarkServer_StartProof_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gnarkServerClient) GetProof(ctx context.Context, in *GetProofRequest, opts ...grpc.CallOption) (*GetProofResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetProofResponse)
+	err := c.cc.Invoke(ctx, GnarkServer_GetProof_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gnarkServerClient) Health(ctx context.Context, in *HealthRequest, opts ...grpc.CallOption) (*HealthResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HealthResponse)
+	err := c.cc.Invoke(ctx, GnarkServer_Health_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GnarkServerServer is the server API for GnarkServer service.
+// All implementations must embed UnimplementedGnarkServerServer
+// for forward compatibility
+type GnarkServerServer interface {
+	StartProof(context.Context, *ProofRequest) (*StartProofResponse, error)
+	GetProof(context.Context, *GetProofRequest) (*GetProofResponse, error)
+	Health(context.Context, *HealthRequest) (*HealthResponse, error)
+	mustEmbedUnimplementedGnarkServerServer()
+}
+
+// UnimplementedGnarkServerServer must be embedded to have forward compatible implementations.
+type UnimplementedGnarkServerServer struct {
+}
+
+func (UnimplementedGnarkServerServer) StartProof(context.Context, *ProofRequest) (*StartProofResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartProof not implemented")
+}
+func (UnimplementedGnarkServerServer) GetProof(context.Context, *GetProofRequest) (*GetProofResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetProof not implemented")
+}
+func (UnimplementedGnarkServerServer) Health(context.Context, *HealthRequest) (*HealthResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Health not implemented")
+}
+func (UnimplementedGnarkServerServer) mustEmbedUnimplementedGnarkServerServer() {}
+
+// UnsafeGnarkServerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GnarkServerServer will
+// result in compilation errors.
+type UnsafeGnarkServerServer interface {
+	mustEmbedUnimplementedGnarkServerServer()
+}
+
+func RegisterGnarkServerServer(s grpc.ServiceRegistrar, srv GnarkServerServer) {
+	s.RegisterService(&GnarkServer_ServiceDesc, srv)
+}
+
+func _GnarkServer_StartProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProofRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GnarkServerServer).StartProof(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GnarkServer_StartProof_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GnarkServerServer).StartProof(ctx, req.(*ProofRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GnarkServer_GetProof_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetProofRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GnarkServerServer).GetProof(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GnarkServer_GetProof_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GnarkServerServer).GetProof(ctx, req.(*GetProofRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GnarkServer_Health_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GnarkServerServer).Health(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GnarkServer_Health_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GnarkServerServer).Health(ctx, req.(*HealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// GnarkServer_ServiceDesc is the grpc.ServiceDesc for GnarkServer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var GnarkServer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gnarkserver.GnarkServer",
+	HandlerType: (*GnarkServerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "StartProof",
+			Handler:    _GnarkServer_StartProof_Handler,
+		},
+		{
+			MethodName: "GetProof",
+			Handler:    _GnarkServer_GetProof_Handler,
+		},
+		{
+			MethodName: "Health",
+			Handler:    _GnarkServer_Health_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "gnarkserver.proto",
+}
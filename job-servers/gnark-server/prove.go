@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gnark-server/circuitData"
+	"gnark-server/prover"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Exit codes of the prove command.
+const (
+	exitProveFailed  = 1
+	exitUsage        = 2
+	exitInvalidInput = 3
+	exitCircuitData  = 4
+)
+
+// runProve implements `gnark-server prove`, which proves a single plonky2
+// proof without Redis or an HTTP server and writes the result envelope to
+// disk. Progress goes to stderr; the return value is the exit code.
+func runProve(args []string) int {
+	flags := flag.NewFlagSet("prove", flag.ContinueOnError)
+	input := flags.String("input", "", "plonky2 proof with public inputs (JSON)")
+	paths := circuitData.PathsFromEnv()
+	verifierData := flags.String("verifier-data", "", "verifier only circuit data (JSON), defaults to $CIRCUIT_VERIFIER_ONLY_DATA_PATH or <data-dir>/verifier_only_circuit_data.json")
+	dataDir := flags.String("data-dir", paths.DataDir(), "directory holding the keys and compiled circuit written by setup, $CIRCUIT_DATA_DIR by default")
+	out := flags.String("out", "", "path of the binary proof; the JSON envelope is written to <out>.json")
+	backend := flags.String("backend", os.Getenv("PROVING_BACKEND"), "proving backend, plonk or groth16 (default plonk, or $PROVING_BACKEND)")
+	fastKeyLoad := flags.Bool("fast-key-load", os.Getenv("FAST_KEY_LOAD") == "true", "read the proving key without subgroup checks when the release manifest has its checksum (default $FAST_KEY_LOAD)")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *input == "" || *out == "" || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: gnark-server prove --input <proof.json> --out <result.bin> [--data-dir <dir>] [--verifier-data <vd.json>] [--backend plonk|groth16] [--fast-key-load]")
+		return exitUsage
+	}
+	paths = paths.In(*dataDir)
+	if *verifierData == "" {
+		*verifierData = paths.Path(circuitData.Files{}, circuitData.VerifierOnlyCircuitDataFile)
+	}
+	if *backend == "" {
+		*backend = circuitData.BackendPlonk
+	}
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	proofJSON, err := os.ReadFile(*input)
+	if err != nil {
+		logger.Println("Failed to read input:", err)
+		return exitInvalidInput
+	}
+	vdJSON, err := circuitData.ReadFile(*verifierData)
+	if err != nil {
+		logger.Println("Failed to read verifier data:", err)
+		return exitInvalidInput
+	}
+	proofRaw, vdRaw, err := prover.ParseInput(proofJSON, vdJSON)
+	if err == nil {
+		err = prover.ValidateProof(proofRaw)
+	}
+	if err != nil {
+		logger.Println(err)
+		return exitInvalidInput
+	}
+
+	logger.Println("Loading circuit data from", *dataDir)
+	data, err := circuitData.InitCircuitDataFromPaths(paths, *backend, *fastKeyLoad)
+	if err == nil {
+		err = data.Validate()
+	}
+	if errors.Is(err, circuitData.ErrStaleCache) {
+		logger.Println("Circuit data error:", err, "(run `PROVING_BACKEND="+*backend+" go run setup/main.go`)")
+		return exitCircuitData
+	} else if err != nil {
+		logger.Println("Circuit data error:", err)
+		return exitCircuitData
+	}
+
+	startedAt := time.Now()
+	result, err := prover.Prove(context.Background(), noop.NewTracerProvider().Tracer(""), &data, proofRaw, vdRaw, func(stage string) {
+		logger.Printf("%s (%v elapsed)\n", stage, time.Since(startedAt).Round(time.Second))
+	})
+	if err != nil {
+		logger.Println("Prove failed:", err)
+		return exitProveFailed
+	}
+	envelope, err := prover.NewEnvelope(result, data.ReleaseId, proofJSON)
+	if err == nil {
+		err = prover.WriteEnvelope(*out, result, envelope)
+	}
+	if err != nil {
+		logger.Println("Failed to write result:", err)
+		return exitProveFailed
+	}
+	logger.Printf("Proof verified and written to %s and %s.json in %v\n", *out, *out, time.Since(startedAt).Round(time.Second))
+	return 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// clearCircuitEnv unsets the environment runProve reads its defaults from.
+func clearCircuitEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"CIRCUIT_DATA_DIR", "CIRCUIT_PROVING_KEY_PATH", "CIRCUIT_VERIFYING_KEY_PATH", "CIRCUIT_CONSTRAINT_SYSTEM_PATH", "CIRCUIT_COMMON_DATA_PATH", "CIRCUIT_VERIFIER_ONLY_DATA_PATH", "CIRCUIT_PROOF_WITH_PUBLIC_INPUTS_PATH", "CIRCUIT_OBJECT_CACHE_DIR", "PROVING_BACKEND", "FAST_KEY_LOAD"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestRunProveExitCodes(t *testing.T) {
+	clearCircuitEnv(t)
+	dir := t.TempDir()
+	notJSON := filepath.Join(dir, "proof.json")
+	if err := os.WriteFile(notJSON, []byte("not a proof"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const proof = "testdata/proof_with_public_inputs.json"
+	const verifierData = "testdata/verifier_only_circuit_data.json"
+	out := filepath.Join(dir, "result.bin")
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"no arguments", nil, exitUsage},
+		{"no output", []string{"--input", proof}, exitUsage},
+		{"no input", []string{"--out", out}, exitUsage},
+		{"unknown flag", []string{"--input", proof, "--out", out, "--verbose"}, exitUsage},
+		{"extra argument", []string{"--input", proof, "--out", out, "extra"}, exitUsage},
+		{"missing input", []string{"--input", filepath.Join(dir, "missing.json"), "--out", out, "--verifier-data", verifierData}, exitInvalidInput},
+		{"missing verifier data", []string{"--input", proof, "--out", out, "--data-dir", dir}, exitInvalidInput},
+		{"invalid input", []string{"--input", notJSON, "--out", out, "--verifier-data", verifierData}, exitInvalidInput},
+		{"no circuit data", []string{"--input", proof, "--out", out, "--verifier-data", verifierData, "--data-dir", dir}, exitCircuitData},
+		{"unknown backend", []string{"--input", proof, "--out", out, "--verifier-data", verifierData, "--data-dir", dir, "--backend", "stark"}, exitCircuitData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runProve(tt.args); got != tt.want {
+				t.Fatalf("runProve(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+			if _, err := os.Stat(out); !os.IsNotExist(err) {
+				t.Fatalf("runProve(%q) left %s behind: %v", tt.args, out, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package prover
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"gnark-server/circuitData"
+	"gnark-server/utils"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// EnvelopeVersion is the version of the result envelope layout.
+const EnvelopeVersion = 1
+
+// Envelope is the JSON sidecar written next to a binary proof by the prove
+// command. PublicInputs and Proof match the proof of a get-proof response,
+// so the two can be used interchangeably.
+type Envelope struct {
+	Version        int    `json:"version"`
+	CircuitRelease string `json:"circuitRelease"`
+	// Backend is the proving system, plonk or groth16. Envelopes written
+	// before Groth16 support omit it and hold PLONK proofs.
+	Backend      string   `json:"backend,omitempty"`
+	PublicInputs []string `json:"publicInputs"`
+	// Proof is the hex encoded contents of the binary proof file.
+	Proof string `json:"proof"`
+	// Calldata is the ABI encoded call to the exported Solidity verifier, see
+	// VerifyCalldata.
+	Calldata  string            `json:"calldata"`
+	Checksums EnvelopeChecksums `json:"checksums"`
+}
+
+type EnvelopeChecksums struct {
+	// Proof is the SHA-256 of the binary proof file.
+	Proof string `json:"proof"`
+	// Input is the SHA-256 of the proof with public inputs that was proved.
+	Input string `json:"input"`
+}
+
+// NewEnvelope describes result, proved from input with the circuit release.
+func NewEnvelope(result *Result, circuitRelease string, input []byte) (*Envelope, error) {
+	calldata, err := VerifyCalldata(result)
+	if err != nil {
+		return nil, err
+	}
+	proofSum := sha256.Sum256(result.Proof)
+	inputSum := sha256.Sum256(input)
+	return &Envelope{
+		Version:        EnvelopeVersion,
+		CircuitRelease: circuitRelease,
+		Backend:        result.Backend,
+		PublicInputs:   result.PublicInputs,
+		Proof:          hex.EncodeToString(result.Proof),
+		Calldata:       "0x" + hex.EncodeToString(calldata),
+		Checksums: EnvelopeChecksums{
+			Proof: hex.EncodeToString(proofSum[:]),
+			Input: hex.EncodeToString(inputSum[:]),
+		},
+	}, nil
+}
+
+// WriteEnvelope writes the binary proof to path and the envelope to
+// path + ".json".
+func WriteEnvelope(path string, result *Result, envelope *Envelope) error {
+	if err := os.WriteFile(path, result.Proof, 0644); err != nil {
+		return err
+	}
+	envelopeJSON, err := json.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".json", append(envelopeJSON, '\n'), 0644)
+}
+
+// ReadEnvelope reads a binary proof and its envelope and checks that they
+// belong together.
+func ReadEnvelope(path string) (*Envelope, error) {
+	proof, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	envelopeJSON, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil, err
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(envelopeJSON, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid envelope %s.json: %w", path, err)
+	}
+	if envelope.Version != EnvelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", envelope.Version)
+	}
+	sum := sha256.Sum256(proof)
+	if hex.EncodeToString(sum[:]) != envelope.Checksums.Proof {
+		return nil, fmt.Errorf("checksum of %s does not match its envelope", path)
+	}
+	if hex.EncodeToString(proof) != envelope.Proof {
+		return nil, fmt.Errorf("proof in %s.json does not match %s", path, path)
+	}
+	return &envelope, nil
+}
+
+// VerifyCalldata ABI encodes a call to the entry point of the Solidity
+// verifier exported by setup: Verify(bytes proof, uint256[] public_inputs)
+// for PLONK and verifyProof(uint256[8] proof, uint256[n] input) for Groth16.
+func VerifyCalldata(result *Result) ([]byte, error) {
+	inputs := make([][]byte, len(result.PublicInputs))
+	for i, s := range result.PublicInputs {
+		input, err := utils.SolidityUint256(s)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = input
+	}
+	if result.Backend == circuitData.BackendGroth16 {
+		if len(result.Proof) < 256 {
+			return nil, fmt.Errorf("groth16 proof is %d bytes, expected at least 256", len(result.Proof))
+		}
+		calldata := selector(fmt.Sprintf("verifyProof(uint256[8],uint256[%d])", len(inputs)))
+		calldata = append(calldata, result.Proof[:256]...)
+		for _, input := range inputs {
+			calldata = append(calldata, input...)
+		}
+		return calldata, nil
+	}
+
+	padded := (len(result.Proof) + 31) / 32 * 32
+	calldata := selector("Verify(bytes,uint256[])")
+
+	// Head: offsets of the two dynamic arguments.
+	calldata = append(calldata, word(big.NewInt(64))...)
+	calldata = append(calldata, word(big.NewInt(int64(64+32+padded)))...)
+	// bytes proof
+	calldata = append(calldata, word(big.NewInt(int64(len(result.Proof))))...)
+	calldata = append(calldata, result.Proof...)
+	calldata = append(calldata, make([]byte, padded-len(result.Proof))...)
+	// uint256[] public_inputs
+	calldata = append(calldata, word(big.NewInt(int64(len(inputs))))...)
+	for _, input := range inputs {
+		calldata = append(calldata, input...)
+	}
+	return calldata, nil
+}
+
+func word(v *big.Int) []byte {
+	b := make([]byte, 32)
+	return v.FillBytes(b)
+}
+
+func selector(signature string) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(signature))
+	return h.Sum(nil)[:4]
+}
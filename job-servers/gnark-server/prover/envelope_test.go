@@ -0,0 +1,105 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gnark-server/circuitData"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	for _, backend := range []string{circuitData.BackendPlonk, circuitData.BackendGroth16} {
+		t.Run(backend, func(t *testing.T) {
+			result := &Result{
+				Backend:      backend,
+				PublicInputs: []string{"1", "2"},
+				Proof:        bytes.Repeat([]byte{0xab}, 300),
+			}
+			envelope, err := NewEnvelope(result, "0123456789ab", []byte(`{"proof": {}}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "result.bin")
+			if err := WriteEnvelope(path, result, envelope); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ReadEnvelope(path)
+			if err != nil {
+				t.Fatalf("ReadEnvelope() = %v", err)
+			}
+			if got.Backend != backend || got.CircuitRelease != "0123456789ab" || got.Proof != hex.EncodeToString(result.Proof) {
+				t.Fatalf("ReadEnvelope() = %+v, want the envelope written", got)
+			}
+			calldata, err := VerifyCalldata(result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Calldata != "0x"+hex.EncodeToString(calldata) {
+				t.Fatalf("envelope calldata = %s, want %x", got.Calldata, calldata)
+			}
+		})
+	}
+}
+
+func TestReadEnvelopeRejectsMismatch(t *testing.T) {
+	result := &Result{Backend: circuitData.BackendPlonk, PublicInputs: []string{"1"}, Proof: []byte{1, 2, 3}}
+	envelope, err := NewEnvelope(result, "0123456789ab", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(path string) error
+		want   string
+	}{
+		{"proof changed", func(path string) error { return os.WriteFile(path, []byte{1, 2, 4}, 0644) }, "checksum"},
+		{"envelope missing", func(path string) error { return os.Remove(path + ".json") }, "no such file"},
+		{"envelope invalid", func(path string) error { return os.WriteFile(path+".json", []byte("{"), 0644) }, "invalid envelope"},
+		{"unknown version", func(path string) error {
+			return os.WriteFile(path+".json", []byte(`{"version": 2}`), 0644)
+		}, "unsupported envelope version 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "result.bin")
+			if err := WriteEnvelope(path, result, envelope); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.tamper(path); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadEnvelope(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ReadEnvelope() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyCalldata(t *testing.T) {
+	proof := bytes.Repeat([]byte{0xab}, 40)
+	calldata, err := VerifyCalldata(&Result{Backend: circuitData.BackendPlonk, PublicInputs: []string{"7"}, Proof: proof})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Selector, two offsets, the proof length, the proof padded to 64 bytes,
+	// the input count and one input.
+	if want := 4 + 32*2 + 32 + 64 + 32 + 32; len(calldata) != want {
+		t.Fatalf("PLONK calldata is %d bytes, want %d", len(calldata), want)
+	}
+	if !bytes.Equal(calldata[4+96:4+96+40], proof) || calldata[len(calldata)-1] != 7 {
+		t.Fatalf("PLONK calldata %x does not hold the proof and input", calldata)
+	}
+
+	if _, err := VerifyCalldata(&Result{Backend: circuitData.BackendGroth16, Proof: proof}); err == nil {
+		t.Fatal("VerifyCalldata() accepted a short Groth16 proof")
+	}
+	if _, err := VerifyCalldata(&Result{Backend: circuitData.BackendPlonk, PublicInputs: []string{"-1"}, Proof: proof}); err == nil {
+		t.Fatal("VerifyCalldata() accepted a negative public input")
+	}
+}
@@ -0,0 +1,254 @@
+package prover
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"gnark-server/circuitData"
+	"gnark-server/utils"
+)
+
+const (
+	// DefaultMaxPublicInputs is the hard cap on public_inputs when
+	// MAX_PUBLIC_INPUTS is not set.
+	DefaultMaxPublicInputs = 256
+	// DefaultMaxProofArray bounds the arrays of proofs for circuits without
+	// common circuit data to derive limits from.
+	DefaultMaxProofArray = 1 << 12
+
+	// maxProofDepth bounds the nesting of the proof JSON, which is 7 levels
+	// deep for a plonky2 proof.
+	maxProofDepth = 16
+)
+
+// ErrProofTooLarge is wrapped by the errors of CheckProofSize for arrays
+// beyond their limit.
+var ErrProofTooLarge = errors.New("proof too large")
+
+// arrayLimitError reports an array beyond its limit. It wraps
+// ErrProofTooLarge, or utils.ErrPublicInputCount for public_inputs.
+type arrayLimitError struct {
+	kind error
+	msg  string
+}
+
+func (e *arrayLimitError) Error() string { return e.msg }
+func (e *arrayLimitError) Unwrap() error { return e.kind }
+
+// scanFrame is an array or object open at the current position of the scan.
+type scanFrame struct {
+	array bool
+	// name is the field holding the array, empty for nested arrays.
+	name  string
+	count int
+	limit int
+	// key is the last key of an object, and wantKey is set when the next
+	// token is a key.
+	key     string
+	wantKey bool
+}
+
+// CheckProofSize scans the plonky2 proof JSON token by token, without
+// decoding it, and fails as soon as an array exceeds its limit: public_inputs
+// beyond maxPublicInputs or the public inputs of the circuit with an error
+// wrapping utils.ErrPublicInputCount, and any other array beyond limits with
+// ErrProofTooLarge. Nil limits only bound arrays by DefaultMaxProofArray.
+// Syntax errors are left to ParseInput.
+func CheckProofSize(proofJSON []byte, limits *circuitData.ProofLimits, maxPublicInputs int) error {
+	return checkProofSize(bytes.NewReader(proofJSON), limits, maxPublicInputs)
+}
+
+// CheckQuotedProofSize is CheckProofSize for a proof as it appears in a
+// request body: a JSON string, still quoted and escaped. The proof is
+// unescaped as it is scanned, so that an oversized one is refused before the
+// request is decoded. Anything but a string is left to the decoding.
+func CheckQuotedProofSize(quoted []byte, limits *circuitData.ProofLimits, maxPublicInputs int) error {
+	if len(quoted) < 2 || quoted[0] != '"' {
+		return nil
+	}
+	return checkProofSize(&unquoteReader{raw: quoted[1:]}, limits, maxPublicInputs)
+}
+
+func checkProofSize(proofJSON io.Reader, limits *circuitData.ProofLimits, maxPublicInputs int) error {
+	publicInputs := maxPublicInputs
+	arrays := map[string]int{}
+	maxArray := DefaultMaxProofArray
+	if limits != nil {
+		publicInputs = min(publicInputs, limits.PublicInputs)
+		arrays = limits.Arrays
+		maxArray = limits.MaxArray
+	}
+	dec := json.NewDecoder(proofJSON)
+	dec.UseNumber()
+	var stack []scanFrame
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			// The end of the proof, or a syntax error ParseInput reports.
+			return nil
+		}
+		var top *scanFrame
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+		delim, isDelim := tok.(json.Delim)
+		if top != nil && !top.array && top.wantKey {
+			if isDelim && delim == '}' {
+				stack = stack[:len(stack)-1]
+			} else if key, ok := tok.(string); ok {
+				top.key = key
+				top.wantKey = false
+			}
+			continue
+		}
+		if isDelim && delim == ']' {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		// tok starts a value of top.
+		if top != nil {
+			if top.array {
+				top.count++
+				if top.count > top.limit {
+					return newArrayLimitError(top.name, top.limit)
+				}
+			} else {
+				top.wantKey = true
+			}
+		}
+		if !isDelim {
+			continue
+		}
+		if len(stack) >= maxProofDepth {
+			return &arrayLimitError{ErrProofTooLarge, fmt.Sprintf("proof is nested more than %d levels deep", maxProofDepth)}
+		}
+		switch delim {
+		case '[':
+			frame := scanFrame{array: true, limit: maxArray}
+			if top != nil && !top.array {
+				frame.name = top.key
+				if frame.name == "public_inputs" {
+					frame.limit = publicInputs
+				} else if limit, ok := arrays[frame.name]; ok {
+					frame.limit = limit
+				}
+			}
+			stack = append(stack, frame)
+		case '{':
+			stack = append(stack, scanFrame{wantKey: true})
+		}
+	}
+}
+
+// unquoteReader reads the content of a JSON string, given from after its
+// opening quote, with its escape sequences undone. It stops at the closing
+// quote. Invalid escapes end the string, the decoding of the request reports
+// them.
+type unquoteReader struct {
+	raw []byte
+	// pending holds the bytes of an unescaped character that did not fit
+	// into the last read.
+	pending []byte
+}
+
+func (u *unquoteReader) Read(p []byte) (int, error) {
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+	for n < len(p) && len(u.raw) > 0 {
+		c := u.raw[0]
+		if c == '"' {
+			u.raw = nil
+			break
+		}
+		if c != '\\' {
+			p[n] = c
+			n++
+			u.raw = u.raw[1:]
+			continue
+		}
+		r, size := unescape(u.raw)
+		if size == 0 {
+			u.raw = nil
+			break
+		}
+		u.raw = u.raw[size:]
+		var buf [utf8.UTFMax]byte
+		encoded := buf[:utf8.EncodeRune(buf[:], r)]
+		copied := copy(p[n:], encoded)
+		n += copied
+		u.pending = append(u.pending, encoded[copied:]...)
+	}
+	if n == 0 && len(u.pending) == 0 && len(u.raw) == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// unescape decodes the escape sequence at the start of raw and returns the
+// character and the length of the sequence, or a length of 0 if it is not
+// valid.
+func unescape(raw []byte) (rune, int) {
+	if len(raw) < 2 {
+		return 0, 0
+	}
+	switch raw[1] {
+	case '"', '\\', '/':
+		return rune(raw[1]), 2
+	case 'b':
+		return '\b', 2
+	case 'f':
+		return '\f', 2
+	case 'n':
+		return '\n', 2
+	case 'r':
+		return '\r', 2
+	case 't':
+		return '\t', 2
+	case 'u':
+		r, ok := hexRune(raw[2:])
+		if !ok {
+			return 0, 0
+		}
+		if utf16.IsSurrogate(r) {
+			if len(raw) >= 12 && raw[6] == '\\' && raw[7] == 'u' {
+				if low, ok := hexRune(raw[8:]); ok {
+					if r := utf16.DecodeRune(r, low); r != utf8.RuneError {
+						return r, 12
+					}
+				}
+			}
+			return utf8.RuneError, 6
+		}
+		return r, 6
+	}
+	return 0, 0
+}
+
+// hexRune parses the four hex digits of a \u escape at the start of raw.
+func hexRune(raw []byte) (rune, bool) {
+	if len(raw) < 4 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(string(raw[:4]), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	return rune(v), true
+}
+
+func newArrayLimitError(name string, limit int) error {
+	switch name {
+	case "public_inputs":
+		return &arrayLimitError{utils.ErrPublicInputCount, fmt.Sprintf("expected at most %d public inputs", limit)}
+	case "":
+		return &arrayLimitError{ErrProofTooLarge, fmt.Sprintf("an array of the proof has more than %d entries", limit)}
+	default:
+		return &arrayLimitError{ErrProofTooLarge, fmt.Sprintf("proof %s has more than %d entries", name, limit)}
+	}
+}
@@ -0,0 +1,89 @@
+package prover
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"gnark-server/circuitData"
+	"gnark-server/utils"
+)
+
+// proofWithArrays returns a proof JSON with n public inputs and an
+// openings.wires array of wires entries.
+func proofWithArrays(n int, wires int) string {
+	inputs := make([]string, n)
+	for i := range inputs {
+		inputs[i] = fmt.Sprint(i)
+	}
+	openings := make([]string, wires)
+	for i := range openings {
+		openings[i] = "[1, 2]"
+	}
+	return fmt.Sprintf(`{"proof": {"openings": {"wires": [%s]}}, "public_inputs": [%s]}`,
+		strings.Join(openings, ", "), strings.Join(inputs, ", "))
+}
+
+func TestCheckProofSizeBoundary(t *testing.T) {
+	limits := &circuitData.ProofLimits{PublicInputs: 8, Arrays: map[string]int{"wires": 4}, MaxArray: 16}
+	tests := []struct {
+		name         string
+		publicInputs int
+		wires        int
+		want         error
+	}{
+		{"at the limits", 8, 4, nil},
+		{"one public input past", 9, 4, utils.ErrPublicInputCount},
+		{"one wire past", 8, 5, ErrProofTooLarge},
+		{"far past", 100000, 4, utils.ErrPublicInputCount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proof := proofWithArrays(tt.publicInputs, tt.wires)
+			quoted, err := json.Marshal(proof)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, err := range map[string]error{
+				"CheckProofSize":       CheckProofSize([]byte(proof), limits, DefaultMaxPublicInputs),
+				"CheckQuotedProofSize": CheckQuotedProofSize(quoted, limits, DefaultMaxPublicInputs),
+			} {
+				if tt.want == nil && err != nil {
+					t.Fatalf("%s() = %v", name, err)
+				}
+				if tt.want != nil && !errors.Is(err, tt.want) {
+					t.Fatalf("%s() = %v, want %v", name, err, tt.want)
+				}
+			}
+		})
+	}
+
+	// Without limits of the circuit, MAX_PUBLIC_INPUTS applies.
+	if err := CheckProofSize([]byte(proofWithArrays(3, 0)), nil, 3); err != nil {
+		t.Fatalf("CheckProofSize() at MAX_PUBLIC_INPUTS = %v", err)
+	}
+	if err := CheckProofSize([]byte(proofWithArrays(4, 0)), nil, 3); !errors.Is(err, utils.ErrPublicInputCount) {
+		t.Fatalf("CheckProofSize() past MAX_PUBLIC_INPUTS = %v, want ErrPublicInputCount", err)
+	}
+}
+
+func TestUnquoteReader(t *testing.T) {
+	want := "{\"public_inputs\": [1,\t2]}\né€\U0001F600/\\"
+	quoted, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Escape what json.Marshal leaves as is, to cover every sequence.
+	escaped := strings.NewReplacer("/", `\/`, "é", `\u00e9`, "\U0001F600", `\ud83d\ude00`).Replace(string(quoted))
+	got, err := io.ReadAll(iotest.OneByteReader(&unquoteReader{raw: []byte(escaped[1:] + ` "trailing"`)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("unquoteReader read %q, want %q", got, want)
+	}
+}
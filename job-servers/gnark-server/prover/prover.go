@@ -0,0 +1,187 @@
+// Package prover holds the proving pipeline shared by the server workers and
+// the one-shot prove command: parsing and validating a plonky2 proof,
+// building the witness, proving and self-verifying.
+package prover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	verifierCircuit "gnark-server/circuit"
+	"gnark-server/circuitData"
+	"gnark-server/utils"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/qope/gnark-plonky2-verifier/types"
+	"github.com/qope/gnark-plonky2-verifier/variables"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	StageWitnessGeneration = "witness-generation"
+	StageProving           = "proving"
+	StageVerifying         = "verifying"
+)
+
+// ErrMalformedInput is wrapped by the errors of ParseInput.
+var ErrMalformedInput = errors.New("malformed input")
+
+// ErrInputHashMismatch is wrapped by the error of Prove when the inputHash of
+// the public witness differs from the digest of the plonky2 public inputs.
+var ErrInputHashMismatch = errors.New("input hash mismatch")
+
+// ProverError wraps failures of the proving backend itself, as opposed to
+// problems with the input.
+type ProverError struct {
+	Err error
+}
+
+func (e *ProverError) Error() string { return e.Err.Error() }
+func (e *ProverError) Unwrap() error { return e.Err }
+
+type malformedInputError struct {
+	err error
+}
+
+func (e *malformedInputError) Error() string { return e.err.Error() }
+func (e *malformedInputError) Unwrap() []error {
+	return []error{ErrMalformedInput, e.err}
+}
+
+// Result is a verified proof together with its public inputs.
+type Result struct {
+	// Backend is the proving system that produced Proof.
+	Backend string
+	// PublicInputs are the public inputs of the gnark proof as decimal
+	// strings.
+	PublicInputs []string
+	// Proof is the proof in the layout expected by the Solidity verifier of
+	// Backend.
+	Proof []byte
+}
+
+// ParseInput decodes the plonky2 proof with public inputs and the verifier
+// only circuit data.
+func ParseInput(proofJSON []byte, verifierDataJSON []byte) (proofRaw types.ProofWithPublicInputsRaw, vdRaw types.VerifierOnlyCircuitDataRaw, err error) {
+	// The Merkle proof decoder of the verifier panics on JSON it cannot
+	// decode instead of returning an error.
+	defer func() {
+		if r := recover(); r != nil {
+			err = &malformedInputError{fmt.Errorf("Failed to parse proof JSON: %v", r)}
+		}
+	}()
+	if err := json.Unmarshal(proofJSON, &proofRaw); err != nil {
+		return proofRaw, types.VerifierOnlyCircuitDataRaw{}, &malformedInputError{fmt.Errorf("Failed to parse proof JSON: %w", err)}
+	}
+	if err := json.Unmarshal(verifierDataJSON, &vdRaw); err != nil {
+		return proofRaw, vdRaw, &malformedInputError{fmt.Errorf("Failed to parse verifier data JSON: %w", err)}
+	}
+	return proofRaw, vdRaw, nil
+}
+
+// PublicWitness returns the public part of the witness Prove builds for the
+// plonky2 proof, with the public inputs packed according to cfg, without the
+// cost of assigning the proof itself.
+func PublicWitness(proofRaw types.ProofWithPublicInputsRaw, vdRaw types.VerifierOnlyCircuitDataRaw, cfg utils.InputDigestConfig) (witness.Witness, error) {
+	verifierData := variables.DeserializeVerifierOnlyCircuitData(vdRaw)
+	inputHash, err := utils.CalculateDigest(proofRaw.PublicInputs, cfg)
+	if err != nil {
+		return nil, err
+	}
+	assignment := verifierCircuit.VerifierCircuit{
+		VerifierDigest: verifierData.CircuitDigest,
+		InputHash:      frontend.Variable(inputHash),
+	}
+	return frontend.NewWitness(&assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+}
+
+// Prove builds the witness for the plonky2 proof, proves it with data and
+// verifies the result before returning it. onStage, if not nil, is called as
+// each stage starts. When ctx is done before the witness is built or the
+// proof is made, Prove returns an error wrapping the error of ctx without
+// waiting for the stage to finish.
+func Prove(ctx context.Context, tracer trace.Tracer, data *circuitData.CircuitData, proofRaw types.ProofWithPublicInputsRaw, vdRaw types.VerifierOnlyCircuitDataRaw, onStage func(stage string)) (*Result, error) {
+	if onStage == nil {
+		onStage = func(string) {}
+	}
+
+	onStage(StageWitnessGeneration)
+	_, witnessSpan := tracer.Start(ctx, "build_witness")
+	proofWithPis := variables.DeserializeProofWithPublicInputs(proofRaw)
+	verifierData := variables.DeserializeVerifierOnlyCircuitData(vdRaw)
+	inputHash, err := utils.CalculateDigest(proofRaw.PublicInputs, data.InputDigestConfig())
+	if err != nil {
+		witnessSpan.End()
+		return nil, err
+	}
+	assignment := verifierCircuit.VerifierCircuit{
+		VerifierDigest: verifierData.CircuitDigest,
+		InputHash:      frontend.Variable(inputHash),
+		ProofWithPis:   proofWithPis,
+		VerifierData:   verifierData,
+	}
+	// The stages write to their own variables, which are not read once
+	// Prove has stopped waiting for them.
+	var witness witness.Witness
+	var witnessErr error
+	stageErr := runStage(ctx, StageWitnessGeneration, func() {
+		witness, witnessErr = frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+	})
+	witnessSpan.End()
+	if stageErr != nil {
+		return nil, fmt.Errorf("stopped during %s: %w", StageWitnessGeneration, stageErr)
+	}
+	if witnessErr != nil {
+		return nil, &ProverError{witnessErr}
+	}
+
+	onStage(StageProving)
+	backend := data.Backend.Name()
+	_, proveSpan := tracer.Start(ctx, backend+".Prove")
+	var proof []byte
+	var proveErr error
+	stageErr = runStage(ctx, StageProving, func() {
+		proof, proveErr = data.Backend.Prove(data.Ccs, witness)
+	})
+	proveSpan.End()
+	if stageErr != nil {
+		return nil, fmt.Errorf("stopped during %s: %w", StageProving, stageErr)
+	}
+	if proveErr != nil {
+		return nil, &ProverError{proveErr}
+	}
+
+	onStage(StageVerifying)
+	_, verifySpan := tracer.Start(ctx, backend+".Verify")
+	publicWitness, err := witness.Public()
+	if err == nil {
+		err = data.Backend.Verify(proof, data.Vk, publicWitness)
+	}
+	verifySpan.End()
+	if err != nil {
+		return nil, fmt.Errorf("proof verification failed: %w", err)
+	}
+	publicInputs, err := utils.ExtractPublicInputs(witness)
+	if err != nil {
+		return nil, &ProverError{err}
+	}
+	// The public inputs are verifierDigest and inputHash. A proof whose
+	// inputHash is not the digest of the submitted public inputs would be
+	// accepted on-chain for different inputs, so it is never returned.
+	if len(publicInputs) != 2 || publicInputs[1].Cmp(inputHash) != 0 {
+		return nil, fmt.Errorf("%w: the public witness %v does not end with the digest %s of the plonky2 public inputs", ErrInputHashMismatch, publicInputs, inputHash)
+	}
+	publicInputsStr := make([]string, len(publicInputs))
+	for i, bi := range publicInputs {
+		publicInputsStr[i] = bi.String()
+	}
+	return &Result{
+		Backend:      backend,
+		PublicInputs: publicInputsStr,
+		Proof:        proof,
+	}, nil
+}
@@ -0,0 +1,57 @@
+package prover
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// abandoned counts the stages still running after their context was done.
+var abandoned atomic.Int64
+
+// Abandoned returns the number of witness constructions and proofs that
+// still run in the background after Prove returned because its context was
+// done.
+func Abandoned() int64 {
+	return abandoned.Load()
+}
+
+// runStage runs f and waits for it, unless ctx is done first, in which case
+// it returns the error of ctx right away. gnark cannot be interrupted, so f
+// keeps running in the background until it returns, and its outcome is
+// dropped. A panic of f is raised again in the caller when it waited for f.
+func runStage(ctx context.Context, stage string, f func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	var panicked interface{}
+	go func() {
+		defer close(done)
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = fmt.Sprintf("%v\n%s", r, debug.Stack())
+			}
+		}()
+		f()
+	}()
+	select {
+	case <-done:
+		if panicked != nil {
+			panic(panicked)
+		}
+		return nil
+	case <-ctx.Done():
+		abandoned.Add(1)
+		go func() {
+			<-done
+			abandoned.Add(-1)
+			if panicked != nil {
+				log.Printf("Abandoned %s stage panicked: %v\n", stage, panicked)
+			}
+		}()
+		return ctx.Err()
+	}
+}
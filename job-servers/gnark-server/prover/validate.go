@@ -0,0 +1,148 @@
+package prover
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	gl "github.com/qope/gnark-plonky2-verifier/goldilocks"
+	"github.com/qope/gnark-plonky2-verifier/types"
+	"github.com/qope/gnark-plonky2-verifier/variables"
+)
+
+// ErrMalformedProof is wrapped by the errors of ValidateProof.
+var ErrMalformedProof = errors.New("malformed proof")
+
+type malformedProofError struct {
+	msg string
+}
+
+func (e *malformedProofError) Error() string { return e.msg }
+func (e *malformedProofError) Unwrap() error { return ErrMalformedProof }
+
+func malformedProof(format string, args ...interface{}) error {
+	return &malformedProofError{fmt.Sprintf(format, args...)}
+}
+
+// ValidateProof checks that a parsed plonky2 proof has the structure Prove
+// needs: non-empty Merkle caps and query rounds, hashes that are decimal
+// BN254 field elements, and Goldilocks elements and quadratic extension
+// elements of two limbs below the Goldilocks modulus. The deserializer of the
+// verifier does not check any of this and either panics or builds a witness
+// that fails minutes later, so ValidateProof also runs it and reports a panic
+// as a malformed proof. Public inputs are checked separately, against the
+// input digest config of the circuit, by utils.CalculateDigest.
+func ValidateProof(proofRaw types.ProofWithPublicInputsRaw) (err error) {
+	p := proofRaw.Proof
+	for _, c := range []struct {
+		name   string
+		hashes []string
+	}{
+		{"wires_cap", p.WiresCap},
+		{"plonk_zs_partial_products_cap", p.PlonkZsPartialProductsCap},
+		{"quotient_polys_cap", p.QuotientPolysCap},
+	} {
+		if len(c.hashes) == 0 {
+			return malformedProof("proof.%s is empty", c.name)
+		}
+		if err := checkHashes("proof."+c.name, c.hashes); err != nil {
+			return err
+		}
+	}
+	for _, c := range []struct {
+		name  string
+		elems [][]uint64
+	}{
+		{"constants", p.Openings.Constants},
+		{"plonk_sigmas", p.Openings.PlonkSigmas},
+		{"wires", p.Openings.Wires},
+		{"plonk_zs", p.Openings.PlonkZs},
+		{"plonk_zs_next", p.Openings.PlonkZsNext},
+		{"partial_products", p.Openings.PartialProducts},
+		{"quotient_polys", p.Openings.QuotientPolys},
+	} {
+		if err := checkExtensions("proof.openings."+c.name, c.elems); err != nil {
+			return err
+		}
+	}
+
+	fri := p.OpeningProof
+	for i, cap := range fri.CommitPhaseMerkleCaps {
+		if err := checkHashes(fmt.Sprintf("proof.opening_proof.commit_phase_merkle_caps[%d]", i), cap); err != nil {
+			return err
+		}
+	}
+	if len(fri.QueryRoundProofs) == 0 {
+		return malformedProof("proof.opening_proof.query_round_proofs is empty")
+	}
+	for i, round := range fri.QueryRoundProofs {
+		name := fmt.Sprintf("proof.opening_proof.query_round_proofs[%d]", i)
+		for j, eval := range round.InitialTreesProof.EvalsProofs {
+			evalName := fmt.Sprintf("%s.initial_trees_proof.evals_proofs[%d]", name, j)
+			if err := checkElements(evalName+".leaf_elements", eval.LeafElements); err != nil {
+				return err
+			}
+			if err := checkHashes(evalName+".merkle_proof.siblings", eval.MerkleProof.Hash); err != nil {
+				return err
+			}
+		}
+		for j, step := range round.Steps {
+			stepName := fmt.Sprintf("%s.steps[%d]", name, j)
+			if err := checkExtensions(stepName+".evals", step.Evals); err != nil {
+				return err
+			}
+			if err := checkHashes(stepName+".merkle_proof.siblings", step.MerkleProof.Siblings); err != nil {
+				return err
+			}
+		}
+	}
+	if err := checkExtensions("proof.opening_proof.final_poly.coeffs", fri.FinalPoly.Coeffs); err != nil {
+		return err
+	}
+	if err := checkElements("proof.opening_proof.pow_witness", []uint64{fri.PowWitness}); err != nil {
+		return err
+	}
+	if err := checkElements("public_inputs", proofRaw.PublicInputs); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = malformedProof("proof cannot be deserialized: %v", r)
+		}
+	}()
+	variables.DeserializeProofWithPublicInputs(proofRaw)
+	return nil
+}
+
+func checkHashes(name string, hashes []string) error {
+	for i, h := range hashes {
+		v, ok := new(big.Int).SetString(h, 10)
+		if !ok || v.Sign() < 0 || v.Cmp(fr.Modulus()) >= 0 {
+			return malformedProof("%s[%d] is not a decimal BN254 field element: %q", name, i, h)
+		}
+	}
+	return nil
+}
+
+func checkElements(name string, elems []uint64) error {
+	for i, e := range elems {
+		if e >= gl.MODULUS.Uint64() {
+			return malformedProof("%s[%d] is not a Goldilocks field element: %d", name, i, e)
+		}
+	}
+	return nil
+}
+
+func checkExtensions(name string, elems [][]uint64) error {
+	for i, e := range elems {
+		if len(e) != 2 {
+			return malformedProof("%s[%d] has %d limbs, expected 2", name, i, len(e))
+		}
+		if err := checkElements(fmt.Sprintf("%s[%d]", name, i), e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package prover
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	gl "github.com/qope/gnark-plonky2-verifier/goldilocks"
+	"github.com/qope/gnark-plonky2-verifier/types"
+)
+
+// testProof parses the plonky2 proof in testdata.
+func testProof(t *testing.T) types.ProofWithPublicInputsRaw {
+	t.Helper()
+	proofJSON, err := os.ReadFile("../testdata/proof_with_public_inputs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifierDataJSON, err := os.ReadFile("../testdata/verifier_only_circuit_data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofRaw, _, err := ParseInput(proofJSON, verifierDataJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proofRaw
+}
+
+func TestParseInputMalformed(t *testing.T) {
+	proofJSON, err := os.ReadFile("../testdata/proof_with_public_inputs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, input := range map[string]string{
+		"truncated":  string(proofJSON[:len(proofJSON)/2]),
+		"not JSON":   "not a proof",
+		"wrong type": `{"proof": {"wires_cap": 1}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := ParseInput([]byte(input), []byte("{}"))
+			if !errors.Is(err, ErrMalformedInput) {
+				t.Fatalf("ParseInput() = %v, want ErrMalformedInput", err)
+			}
+		})
+	}
+}
+
+func TestValidateProof(t *testing.T) {
+	if err := ValidateProof(testProof(t)); err != nil {
+		t.Fatalf("ValidateProof() of the testdata proof = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(p *types.ProofWithPublicInputsRaw)
+		wantErr string
+	}{
+		{"empty cap", func(p *types.ProofWithPublicInputsRaw) { p.Proof.WiresCap = nil },
+			"proof.wires_cap is empty"},
+		{"hash not decimal", func(p *types.ProofWithPublicInputsRaw) { p.Proof.QuotientPolysCap[3] = "0xff" },
+			"proof.quotient_polys_cap[3] is not a decimal BN254 field element"},
+		{"hash past the field", func(p *types.ProofWithPublicInputsRaw) {
+			p.Proof.PlonkZsPartialProductsCap[0] = "21888242871839275222246405745257275088548364400416034343698204186575808495617"
+		}, "proof.plonk_zs_partial_products_cap[0] is not a decimal BN254 field element"},
+		{"extension with one limb", func(p *types.ProofWithPublicInputsRaw) { p.Proof.Openings.Wires[2] = []uint64{1} },
+			"proof.openings.wires[2] has 1 limbs, expected 2"},
+		{"limb out of range", func(p *types.ProofWithPublicInputsRaw) {
+			p.Proof.Openings.PlonkSigmas[1][1] = gl.MODULUS.Uint64()
+		}, "proof.openings.plonk_sigmas[1][1] is not a Goldilocks field element"},
+		{"no query rounds", func(p *types.ProofWithPublicInputsRaw) { p.Proof.OpeningProof.QueryRoundProofs = nil },
+			"proof.opening_proof.query_round_proofs is empty"},
+		{"pow witness out of range", func(p *types.ProofWithPublicInputsRaw) { p.Proof.OpeningProof.PowWitness = ^uint64(0) },
+			"proof.opening_proof.pow_witness[0] is not a Goldilocks field element"},
+		{"public input out of range", func(p *types.ProofWithPublicInputsRaw) { p.PublicInputs[7] = ^uint64(0) },
+			"public_inputs[7] is not a Goldilocks field element"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proofRaw := testProof(t)
+			tt.mutate(&proofRaw)
+			err := ValidateProof(proofRaw)
+			if !errors.Is(err, ErrMalformedProof) {
+				t.Fatalf("ValidateProof() = %v, want ErrMalformedProof", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateProof() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
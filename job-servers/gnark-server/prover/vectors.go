@@ -0,0 +1,210 @@
+package prover
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"gnark-server/circuitData"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/witness"
+)
+
+// TestVector is a proof the Solidity verifier must accept, or reject because
+// of Mutation.
+type TestVector struct {
+	// Name identifies the mutation, or is "valid" for the proof itself.
+	Name  string
+	Valid bool
+	// Mutation describes what was changed, and Reason why the verifier
+	// must reject the result. Both are empty for the valid proof.
+	Mutation string
+	Reason   string
+	Result   *Result
+	// Calldata is the call to the verifier with Result, see VerifyCalldata.
+	Calldata []byte
+}
+
+// ErrValidProofRejected is returned by TestVectors when the proof they are
+// derived from does not verify.
+var ErrValidProofRejected = errors.New("the proof does not verify with the loaded verifying key")
+
+// plonkScalarOffset is the offset of the first scalar, l(zeta), in the
+// Solidity layout of a PLONK proof, after 6 points.
+const plonkScalarOffset = 6 * 64
+
+// TestVectors returns result, followed by mutations of it that the verifier
+// must reject: changed public inputs, and a proof with a flipped bit, a
+// negated point and, for PLONK, a changed scalar or truncated length. The
+// mutations are deterministic. result must verify with data, and every
+// mutation is checked to fail verification with it and to change the
+// calldata, so that no vector claims a failure it does not produce.
+func TestVectors(data *circuitData.CircuitData, result *Result) ([]TestVector, error) {
+	if len(result.PublicInputs) != 2 {
+		return nil, fmt.Errorf("expected the public inputs verifierDigest and inputHash, got %d", len(result.PublicInputs))
+	}
+	if err := verifyResult(data, result); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrValidProofRejected, err)
+	}
+	valid, err := newTestVector("valid", "", "", result)
+	if err != nil {
+		return nil, err
+	}
+	vectors := []TestVector{valid}
+	vectors[0].Valid = true
+
+	field := ecc.BN254.ScalarField()
+	mutations := []vectorMutation{
+		{
+			"verifier_digest_changed", "verifierDigest, the first public input, incremented by one",
+			"the proof commits to the digest of the plonky2 circuit it verified, so it must not verify for another circuit",
+			func(r *Result) error { return addOne(&r.PublicInputs[0], field) },
+		},
+		{
+			"input_hash_changed", "inputHash, the second public input, incremented by one",
+			"the proof commits to the digest of the plonky2 public inputs, so it must not verify for other inputs",
+			func(r *Result) error { return addOne(&r.PublicInputs[1], field) },
+		},
+		{
+			"public_inputs_swapped", "verifierDigest and inputHash swapped",
+			"the public inputs are bound to their positions",
+			func(r *Result) error {
+				r.PublicInputs[0], r.PublicInputs[1] = r.PublicInputs[1], r.PublicInputs[0]
+				return nil
+			},
+		},
+		{
+			"proof_bit_flipped", "the lowest bit of the last byte of the proof argument flipped",
+			"a proof corrupted in transit is either not a valid encoding or a different proof",
+			func(r *Result) error {
+				r.Proof[calldataProofLength(r)-1] ^= 1
+				return nil
+			},
+		},
+		{
+			"proof_point_negated", "the first point of the proof replaced by its negation, which is still on the curve",
+			"a well-formed proof that is not the one computed for the public inputs must fail the pairing check",
+			func(r *Result) error { return negatePoint(r.Proof[0:64]) },
+		},
+	}
+	if result.Backend != circuitData.BackendGroth16 {
+		mutations = append(mutations, []vectorMutation{
+			{
+				"proof_scalar_changed", "l(zeta), the first scalar of the proof, incremented by one",
+				"the evaluations of the proof must match its commitments",
+				func(r *Result) error {
+					if len(r.Proof) < plonkScalarOffset+32 {
+						return fmt.Errorf("plonk proof is %d bytes, too short for its scalars", len(r.Proof))
+					}
+					word := new(big.Int).SetBytes(r.Proof[plonkScalarOffset : plonkScalarOffset+32])
+					word.Add(word, big.NewInt(1)).Mod(word, field)
+					word.FillBytes(r.Proof[plonkScalarOffset : plonkScalarOffset+32])
+					return nil
+				},
+			},
+			{
+				"proof_truncated", "the last 32 bytes of the proof removed",
+				"the verifier must check the length of the proof rather than read past it",
+				func(r *Result) error {
+					r.Proof = r.Proof[:len(r.Proof)-32]
+					return nil
+				},
+			},
+		}...)
+	}
+
+	for _, m := range mutations {
+		mutated := &Result{
+			Backend:      result.Backend,
+			PublicInputs: append([]string(nil), result.PublicInputs...),
+			Proof:        bytes.Clone(result.Proof),
+		}
+		if err := m.mutate(mutated); err != nil {
+			return nil, fmt.Errorf("%s: %w", m.name, err)
+		}
+		if verifyResult(data, mutated) == nil {
+			return nil, fmt.Errorf("%s: the mutated proof still verifies", m.name)
+		}
+		vector, err := newTestVector(m.name, m.mutation, m.reason, mutated)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m.name, err)
+		}
+		if bytes.Equal(vector.Calldata, valid.Calldata) {
+			return nil, fmt.Errorf("%s: the mutation does not change the calldata", m.name)
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
+
+// vectorMutation changes a copy of a valid result so that the verifier must
+// reject it.
+type vectorMutation struct {
+	name, mutation, reason string
+	mutate                 func(*Result) error
+}
+
+func newTestVector(name, mutation, reason string, result *Result) (TestVector, error) {
+	calldata, err := VerifyCalldata(result)
+	if err != nil {
+		return TestVector{}, err
+	}
+	return TestVector{Name: name, Mutation: mutation, Reason: reason, Result: result, Calldata: calldata}, nil
+}
+
+// verifyResult verifies the proof of result for its public inputs with the
+// verifying key of data.
+func verifyResult(data *circuitData.CircuitData, result *Result) error {
+	publicWitness, err := witness.New(ecc.BN254.ScalarField())
+	if err != nil {
+		return err
+	}
+	values := make(chan any, len(result.PublicInputs))
+	for _, s := range result.PublicInputs {
+		v, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			close(values)
+			return fmt.Errorf("invalid public input %q", s)
+		}
+		values <- v
+	}
+	close(values)
+	if err := publicWitness.Fill(len(result.PublicInputs), 0, values); err != nil {
+		return err
+	}
+	return data.Backend.Verify(result.Proof, data.Vk, publicWitness)
+}
+
+// calldataProofLength is the number of bytes of the proof VerifyCalldata
+// passes to the verifier: the Groth16 verifier only takes the points A, B
+// and C.
+func calldataProofLength(result *Result) int {
+	if result.Backend == circuitData.BackendGroth16 {
+		return 256
+	}
+	return len(result.Proof)
+}
+
+// addOne increments the decimal field element s modulo field.
+func addOne(s *string, field *big.Int) error {
+	v, ok := new(big.Int).SetString(*s, 10)
+	if !ok {
+		return fmt.Errorf("invalid public input %q", *s)
+	}
+	*s = v.Add(v, big.NewInt(1)).Mod(v, field).String()
+	return nil
+}
+
+// negatePoint replaces the uncompressed G1 point x || y in point with
+// x || p - y.
+func negatePoint(point []byte) error {
+	p := ecc.BN254.BaseField()
+	y := new(big.Int).SetBytes(point[32:64])
+	if y.Sign() == 0 || y.Cmp(p) >= 0 {
+		return errors.New("the point has no distinct negation")
+	}
+	y.Sub(p, y).FillBytes(point[32:64])
+	return nil
+}
@@ -0,0 +1,162 @@
+package prover
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"gnark-server/circuitData"
+	"gnark-server/utils"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/backend/plonk"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	"github.com/consensys/gnark/test"
+)
+
+// vectorCircuit has the public inputs of the verifier circuit, with
+// InputHash the square of a secret.
+type vectorCircuit struct {
+	VerifierDigest frontend.Variable `gnark:",public"`
+	InputHash      frontend.Variable `gnark:",public"`
+	Secret         frontend.Variable
+}
+
+func (c *vectorCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.Secret, c.Secret), c.InputHash)
+	api.AssertIsDifferent(c.VerifierDigest, 0)
+	return nil
+}
+
+// proveVectorCircuit sets up vectorCircuit with backend and returns the
+// circuit data and a proof of it.
+func proveVectorCircuit(t *testing.T, backend string) (*circuitData.CircuitData, *Result) {
+	t.Helper()
+	field := ecc.BN254.ScalarField()
+	data := &circuitData.CircuitData{}
+	switch backend {
+	case circuitData.BackendPlonk:
+		ccs, err := frontend.Compile(field, scs.NewBuilder, &vectorCircuit{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srs, err := test.NewKZGSRS(ccs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk, vk, err := plonk.Setup(ccs, srs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data.Backend = &circuitData.PlonkBackend{Pk: *pk.(*plonk_bn254.ProvingKey)}
+		data.Vk, data.Ccs = vk.(*plonk_bn254.VerifyingKey), ccs
+	case circuitData.BackendGroth16:
+		ccs, err := frontend.Compile(field, r1cs.NewBuilder, &vectorCircuit{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk, vk, err := groth16.Setup(ccs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data.Backend = &circuitData.Groth16Backend{Pk: *pk.(*groth16_bn254.ProvingKey)}
+		data.Vk, data.Ccs = vk.(*groth16_bn254.VerifyingKey), ccs
+	}
+
+	verifierDigest, _ := new(big.Int).SetString("10639849666975086414110868463771120369189468607622759510754735453420311446140", 10)
+	secret := big.NewInt(1<<32 - 1)
+	witness, err := frontend.NewWitness(&vectorCircuit{
+		VerifierDigest: verifierDigest,
+		InputHash:      new(big.Int).Mul(secret, secret),
+		Secret:         secret,
+	}, field)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := data.Backend.Prove(data.Ccs, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicInputs, err := utils.ExtractPublicInputs(witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &Result{Backend: backend, Proof: proof}
+	for _, input := range publicInputs {
+		result.PublicInputs = append(result.PublicInputs, input.String())
+	}
+	return data, result
+}
+
+func TestTestVectors(t *testing.T) {
+	for backend, negatives := range map[string]int{circuitData.BackendPlonk: 7, circuitData.BackendGroth16: 5} {
+		t.Run(backend, func(t *testing.T) {
+			data, result := proveVectorCircuit(t, backend)
+			proof := bytes.Clone(result.Proof)
+			vectors, err := TestVectors(data, result)
+			if err != nil {
+				t.Fatalf("TestVectors() = %v", err)
+			}
+			if len(vectors) != 1+negatives {
+				t.Fatalf("TestVectors() returned %d vectors, want the valid one and %d negative ones", len(vectors), negatives)
+			}
+			if !bytes.Equal(result.Proof, proof) {
+				t.Fatal("TestVectors() changed the proof it was given")
+			}
+
+			valid := vectors[0]
+			if valid.Name != "valid" || !valid.Valid || valid.Mutation != "" {
+				t.Fatalf("first vector = %+v, want the valid proof", valid)
+			}
+			if err := verifyResult(data, valid.Result); err != nil {
+				t.Fatalf("the valid vector does not verify: %v", err)
+			}
+			names := map[string]bool{}
+			for _, vector := range vectors[1:] {
+				if vector.Valid || vector.Mutation == "" || vector.Reason == "" || names[vector.Name] {
+					t.Fatalf("negative vector %+v is not labeled", vector)
+				}
+				names[vector.Name] = true
+				if verifyResult(data, vector.Result) == nil {
+					t.Fatalf("negative vector %s verifies", vector.Name)
+				}
+				calldata, err := VerifyCalldata(vector.Result)
+				if err != nil || !bytes.Equal(calldata, vector.Calldata) {
+					t.Fatalf("calldata of %s does not encode its result: %v", vector.Name, err)
+				}
+				if bytes.Equal(vector.Calldata, valid.Calldata) {
+					t.Fatalf("negative vector %s has the calldata of the valid one", vector.Name)
+				}
+			}
+
+			again, err := TestVectors(data, result)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range vectors {
+				if again[i].Name != vectors[i].Name || !bytes.Equal(again[i].Calldata, vectors[i].Calldata) {
+					t.Fatalf("vector %s is not deterministic", vectors[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestTestVectorsRejectInvalidProof(t *testing.T) {
+	data, result := proveVectorCircuit(t, circuitData.BackendGroth16)
+	invalid := *result
+	invalid.PublicInputs = []string{result.PublicInputs[1], result.PublicInputs[0]}
+	if _, err := TestVectors(data, &invalid); !errors.Is(err, ErrValidProofRejected) {
+		t.Fatalf("TestVectors() of a proof that does not verify = %v, want ErrValidProofRejected", err)
+	}
+	invalid.PublicInputs = result.PublicInputs[:1]
+	if _, err := TestVectors(data, &invalid); err == nil {
+		t.Fatal("TestVectors() accepted a single public input")
+	}
+}
@@ -0,0 +1,174 @@
+package redisconfig
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// ClusterHashTag is prepended to every key in a Redis Cluster. The
+	// scripts and transactions of the server touch the keys of a job along
+	// with the shared queues and indexes, which Redis Cluster only allows
+	// within one hash slot, so every key is tagged into the same slot. The
+	// cluster still provides failover, not sharding of the server's data.
+	ClusterHashTag = "{gnark}"
+
+	// keyPrefix starts every key of the server.
+	keyPrefix = "gnark_"
+)
+
+// NewClusterClient returns a cluster client that tags the server's keys with
+// ClusterHashTag, on the cluster client and on the client of every node.
+func NewClusterClient(opt *redis.ClusterOptions) *redis.ClusterClient {
+	commands := &commandTable{}
+	newClient := opt.NewClient
+	if newClient == nil {
+		newClient = redis.NewClient
+	}
+	opt.NewClient = func(nodeOpt *redis.Options) *redis.Client {
+		client := newClient(nodeOpt)
+		client.AddHook(hashTagHook{commands})
+		return client
+	}
+	client := redis.NewClusterClient(opt)
+	commands.load = client.Command
+	client.AddHook(hashTagHook{commands})
+	return client
+}
+
+// commandTable caches the key positions of the commands of the server,
+// loaded once with COMMAND.
+type commandTable struct {
+	load func(ctx context.Context) *redis.CommandsInfoCmd
+
+	mu   sync.Mutex
+	info map[string]*redis.CommandInfo
+}
+
+func (t *commandTable) get(ctx context.Context, name string) (*redis.CommandInfo, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.info == nil {
+		info, err := t.load(ctx).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the Redis command table: %w", err)
+		}
+		t.info = info
+	}
+	return t.info[name], nil
+}
+
+// hashTagHook prepends ClusterHashTag to the keys starting with keyPrefix,
+// before the cluster client picks the slot of the command, and strips it
+// from the keys SCAN and KEYS return, so that callers never see it. Only the
+// key arguments, as COMMAND reports them, and the patterns of SCAN and KEYS
+// are rewritten: values and pub/sub channels keep their names.
+type hashTagHook struct {
+	commands *commandTable
+}
+
+func (h hashTagHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, h.tagKeys(ctx, cmd)
+}
+
+func (h hashTagHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	untagKeys(cmd)
+	return nil
+}
+
+func (h hashTagHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if err := h.tagKeys(ctx, cmd); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func (h hashTagHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		untagKeys(cmd)
+	}
+	return nil
+}
+
+// tagKeys tags the keys of cmd in place. It is idempotent, since the node
+// clients see the commands the cluster client already tagged.
+func (h hashTagHook) tagKeys(ctx context.Context, cmd redis.Cmder) error {
+	name := cmd.Name()
+	// The cluster client sends CLUSTER SLOTS to load the topology the first
+	// time it needs it, which can be while COMMAND is loading the command
+	// table. Neither takes keys, and looking them up would wait for the
+	// table forever.
+	if name == "command" || name == "cluster" {
+		return nil
+	}
+	args := cmd.Args()
+	first, last, step := 0, 0, 1
+	switch name {
+	case "eval", "evalsha", "eval_ro", "evalsha_ro":
+		if len(args) < 3 {
+			return nil
+		}
+		numKeys, err := strconv.Atoi(fmt.Sprint(args[2]))
+		if err != nil {
+			return nil
+		}
+		first, last = 3, 2+numKeys
+	case "keys":
+		first, last = 1, 1
+	case "scan":
+		// The MATCH pattern selects keys.
+		for i := 2; i+1 < len(args); i++ {
+			if strings.EqualFold(fmt.Sprint(args[i]), "match") {
+				first, last = i+1, i+1
+			}
+		}
+	default:
+		info, err := h.commands.get(ctx, name)
+		if err != nil {
+			return err
+		}
+		if info == nil || info.FirstKeyPos <= 0 {
+			return nil
+		}
+		first, last = int(info.FirstKeyPos), int(info.LastKeyPos)
+		if last < 0 {
+			last += len(args)
+		}
+		if info.StepCount > 1 {
+			step = int(info.StepCount)
+		}
+	}
+	if first <= 0 {
+		return nil
+	}
+	for i := first; i <= last && i < len(args); i += step {
+		if key, ok := args[i].(string); ok && strings.HasPrefix(key, keyPrefix) {
+			args[i] = ClusterHashTag + key
+		}
+	}
+	return nil
+}
+
+func untagKeys(cmd redis.Cmder) {
+	var keys []string
+	switch cmd := cmd.(type) {
+	case *redis.ScanCmd:
+		if cmd.Name() == "scan" {
+			keys, _ = cmd.Val()
+		}
+	case *redis.StringSliceCmd:
+		if cmd.Name() == "keys" {
+			keys = cmd.Val()
+		}
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, ClusterHashTag)
+	}
+}
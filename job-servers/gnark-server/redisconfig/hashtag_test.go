@@ -0,0 +1,49 @@
+package redisconfig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestClusterClientTagsKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}})
+	defer client.Close()
+	// The first command loads the topology and the command table, which
+	// must not wait on each other.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Set(ctx, "gnark_proof:1", "done", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Set(ctx, "other", "value", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists(ClusterHashTag+"gnark_proof:1") || mr.Exists("gnark_proof:1") {
+		t.Fatalf("keys %v, want gnark_proof:1 tagged with %s", mr.Keys(), ClusterHashTag)
+	}
+	if !mr.Exists("other") {
+		t.Fatalf("keys %v, want other left untagged", mr.Keys())
+	}
+	if value, err := client.Get(ctx, "gnark_proof:1").Result(); err != nil || value != "done" {
+		t.Fatalf("GET = %q, %v", value, err)
+	}
+
+	script := redis.NewScript(`return redis.call('GET', KEYS[1])`)
+	if value, err := script.Run(ctx, client, []string{"gnark_proof:1"}).Text(); err != nil || value != "done" {
+		t.Fatalf("script GET = %q, %v", value, err)
+	}
+
+	keys, _, err := client.Scan(ctx, 0, "gnark_proof:*", 100).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "gnark_proof:1" {
+		t.Fatalf("SCAN = %v, want [gnark_proof:1] without the tag", keys)
+	}
+}
@@ -0,0 +1,183 @@
+// Package redisconfig builds the Redis client of a REDIS_URL for a
+// standalone server, a Redis Cluster or a Sentinel-managed master, so that
+// the rest of the server only sees a redis.UniversalClient.
+package redisconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// clusterHost is the host of cluster URLs, whose nodes are listed in
+	// the addrs query parameter.
+	clusterHost = "cluster"
+
+	schemeSentinel    = "redis+sentinel"
+	schemeSentinelTLS = "rediss+sentinel"
+
+	defaultSentinelPort = "26379"
+)
+
+// Parse returns the client of rawURL:
+//
+//   - redis://[user:password@]host:port[/db], or rediss:// for TLS, is a
+//     standalone server, as accepted by redis.ParseURL.
+//   - redis://[user:password@]cluster?addrs=host:port,host:port, or
+//     rediss:// for TLS, is a Redis Cluster reached through the listed seed
+//     nodes. Its keys are hash-tagged, see ClusterHashTag.
+//   - redis+sentinel://[user:password@]host:port,host:port/master[/db], or
+//     rediss+sentinel:// for TLS, is the master named master, found through
+//     the listed sentinels. The credentials are the master's; the
+//     sentinel_username and sentinel_password query parameters authenticate
+//     to the sentinels.
+func Parse(rawURL string) (redis.UniversalClient, error) {
+	if strings.HasPrefix(rawURL, schemeSentinel+"://") || strings.HasPrefix(rawURL, schemeSentinelTLS+"://") {
+		opt, err := parseSentinel(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewFailoverClient(opt), nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		if u.Host == clusterHost {
+			opt, err := parseCluster(u)
+			if err != nil {
+				return nil, err
+			}
+			return NewClusterClient(opt), nil
+		}
+		opt, err := redis.ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewClient(opt), nil
+	default:
+		return nil, fmt.Errorf("invalid URL scheme %q, expected redis, rediss, %s or %s", u.Scheme, schemeSentinel, schemeSentinelTLS)
+	}
+}
+
+func parseCluster(u *url.URL) (*redis.ClusterOptions, error) {
+	query := u.Query()
+	var addrs []string
+	for _, v := range query["addrs"] {
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("a cluster URL needs the addrs of at least one node, such as redis://cluster?addrs=10.0.0.1:6379,10.0.0.2:6379")
+	}
+	for _, addr := range addrs {
+		if !strings.Contains(addr, ":") {
+			return nil, fmt.Errorf("cluster node %q has no port", addr)
+		}
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, errors.New("Redis Cluster has no databases, remove the path from the URL")
+	}
+	for key := range query {
+		if key != "addrs" {
+			return nil, fmt.Errorf("unknown query parameter %q in the cluster URL", key)
+		}
+	}
+	opt := &redis.ClusterOptions{Addrs: addrs}
+	opt.Username, opt.Password = credentials(u)
+	if u.Scheme == "rediss" {
+		opt.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return opt, nil
+}
+
+// parseSentinel parses a sentinel URL. Its host list is taken out before
+// url.Parse, which only accepts a port after the last host.
+func parseSentinel(rawURL string) (*redis.FailoverOptions, error) {
+	scheme, rest, _ := strings.Cut(rawURL, "://")
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	authority := rest[:end]
+	hosts := authority
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		hosts = authority[at+1:]
+		authority = authority[:at+1] + "sentinels"
+	} else {
+		authority = "sentinels"
+	}
+	u, err := url.Parse(scheme + "://" + authority + rest[end:])
+	if err != nil {
+		return nil, err
+	}
+	opt := &redis.FailoverOptions{}
+	for _, addr := range strings.Split(hosts, ",") {
+		if addr == "" {
+			return nil, errors.New("empty sentinel address")
+		}
+		if !strings.Contains(addr, ":") {
+			addr += ":" + defaultSentinelPort
+		}
+		opt.SentinelAddrs = append(opt.SentinelAddrs, addr)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if parts[0] == "" || len(parts) > 2 {
+		return nil, errors.New("a sentinel URL needs the master name as its path, such as redis+sentinel://10.0.0.1:26379/mymaster")
+	}
+	opt.MasterName = parts[0]
+	if len(parts) == 2 {
+		db, err := strconv.Atoi(parts[1])
+		if err != nil || db < 0 {
+			return nil, fmt.Errorf("invalid database number %q", parts[1])
+		}
+		opt.DB = db
+	}
+	opt.Username, opt.Password = credentials(u)
+	query := u.Query()
+	for key := range query {
+		switch key {
+		case "sentinel_username":
+			opt.SentinelUsername = query.Get(key)
+		case "sentinel_password":
+			opt.SentinelPassword = query.Get(key)
+		default:
+			return nil, fmt.Errorf("unknown query parameter %q in the sentinel URL", key)
+		}
+	}
+	if u.Scheme == schemeSentinelTLS {
+		opt.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return opt, nil
+}
+
+func credentials(u *url.URL) (username string, password string) {
+	if u.User == nil {
+		return "", ""
+	}
+	password, _ = u.User.Password()
+	return u.User.Username(), password
+}
+
+// ScanClient returns the client to SCAN the server's keys with: rdb itself,
+// or for a cluster the master of the slot ClusterHashTag maps to, since a
+// cluster SCAN only walks the node it is sent to.
+func ScanClient(ctx context.Context, rdb redis.UniversalClient) (redis.UniversalClient, error) {
+	cluster, ok := rdb.(*redis.ClusterClient)
+	if !ok {
+		return rdb, nil
+	}
+	return cluster.MasterForKey(ctx, ClusterHashTag)
+}
@@ -0,0 +1,194 @@
+// Package routes holds the route tables of the public and admin HTTP
+// listeners. Every route is tagged with the listeners it may be served on,
+// and a table refuses routes that are not meant for its listener, so that an
+// admin endpoint can never end up on the public port.
+package routes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Scope tags a route with the listeners that may serve it.
+type Scope int
+
+const (
+	// Public routes are only served on the public listener.
+	Public Scope = iota
+	// Admin routes are only served on the admin listener.
+	Admin
+	// Shared routes, such as health checks, are served on both.
+	Shared
+)
+
+func (s Scope) String() string {
+	switch s {
+	case Public:
+		return "public"
+	case Admin:
+		return "admin"
+	case Shared:
+		return "shared"
+	default:
+		return fmt.Sprintf("Scope(%d)", int(s))
+	}
+}
+
+type Route struct {
+	Pattern string
+	Scope   Scope
+	Handler http.Handler
+}
+
+// Table is the route table of one listener.
+type Table struct {
+	listener Scope
+	mux      *http.ServeMux
+	patterns []string
+}
+
+// NewTable returns an empty table for the Public or Admin listener.
+func NewTable(listener Scope) *Table {
+	return &Table{listener: listener, mux: http.NewServeMux()}
+}
+
+// Handle registers route. It returns an error if the route is not allowed
+// on this table's listener.
+func (t *Table) Handle(route Route) error {
+	if route.Scope != t.listener && route.Scope != Shared {
+		return fmt.Errorf("%s route %s cannot be registered on the %s listener", route.Scope, route.Pattern, t.listener)
+	}
+	t.mux.Handle(route.Pattern, route.Handler)
+	t.patterns = append(t.patterns, route.Pattern)
+	return nil
+}
+
+// Patterns returns the registered patterns in registration order.
+func (t *Table) Patterns() []string {
+	return append([]string(nil), t.patterns...)
+}
+
+func (t *Table) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.mux.ServeHTTP(w, r)
+}
+
+// Register adds every route to the tables that may serve it. admin may be
+// nil when there is no admin listener, in which case admin routes are an
+// error.
+func Register(routes []Route, public *Table, admin *Table) error {
+	for _, route := range routes {
+		switch route.Scope {
+		case Public:
+			if err := public.Handle(route); err != nil {
+				return err
+			}
+		case Admin:
+			if admin == nil {
+				return fmt.Errorf("admin route %s needs an admin listener", route.Pattern)
+			}
+			if err := admin.Handle(route); err != nil {
+				return err
+			}
+		case Shared:
+			if err := public.Handle(route); err != nil {
+				return err
+			}
+			if admin != nil {
+				if err := admin.Handle(route); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("route %s has unknown scope %v", route.Pattern, route.Scope)
+		}
+	}
+	return nil
+}
+
+// Certificate is the server certificate of a listener, served through
+// tls.Config.GetCertificate so that it can be reloaded from its files while
+// the server runs.
+type Certificate struct {
+	CertFile string
+	KeyFile  string
+
+	cert atomic.Pointer[tls.Certificate]
+}
+
+// Reload loads the key pair from the files again. The previous certificate
+// is kept if they do not hold a matching pair, for example while a rotation
+// has replaced only one of them.
+func (c *Certificate) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return err
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+func (c *Certificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// AutocertTLSConfig obtains certificates for domains from Let's Encrypt and
+// renews them before they expire. The TLS-ALPN-01 challenge is answered by
+// the listener itself, so it must be reachable on port 443 under every
+// domain. Certificates and the ACME account key are kept in cacheDir so that
+// restarts do not request new ones. email is given to Let's Encrypt for
+// expiry notices and may be empty.
+func AutocertTLSConfig(domains []string, email string, cacheDir string) (*tls.Config, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("at least one domain is required")
+	}
+	if cacheDir == "" {
+		return nil, errors.New("a cache directory is required")
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	config := manager.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config, nil
+}
+
+// TLSConfig loads a server certificate for a listener. If clientCAFile is
+// set, clients must present a certificate signed by one of its CAs. The
+// returned Certificate reloads the server certificate; the client CAs are
+// only read here.
+func TLSConfig(certFile string, keyFile string, clientCAFile string) (*tls.Config, *Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, nil, errors.New("both a certificate and a key file are required")
+	}
+	cert := &Certificate{CertFile: certFile, KeyFile: keyFile}
+	if err := cert.Reload(); err != nil {
+		return nil, nil, err
+	}
+	config := &tls.Config{
+		GetCertificate: cert.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		caPEM, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, cert, nil
+}
@@ -0,0 +1,176 @@
+package routes
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// answer returns a handler that answers 200 with the pattern it is
+// registered for.
+func answer(pattern string) Route {
+	return Route{Pattern: pattern, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(pattern))
+	})}
+}
+
+// scoped sets the scope of route.
+func scoped(route Route, scope Scope) Route {
+	route.Scope = scope
+	return route
+}
+
+func TestRegisterSeparatesListeners(t *testing.T) {
+	table := []Route{
+		scoped(answer("/health"), Shared),
+		scoped(answer("/start-proof"), Public),
+		scoped(answer("/jobs/"), Public),
+		scoped(answer("/reload-circuit"), Admin),
+		scoped(answer("/dashboard/"), Admin),
+	}
+	public, admin := NewTable(Public), NewTable(Admin)
+	if err := Register(table, public, admin); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, route := range table {
+		for _, listener := range []*Table{public, admin} {
+			w := httptest.NewRecorder()
+			listener.ServeHTTP(w, httptest.NewRequest(http.MethodGet, route.Pattern, nil))
+			want := route.Scope == Shared || route.Scope == listener.listener
+			if served := w.Code == http.StatusOK && w.Body.String() == route.Pattern; served != want {
+				t.Errorf("%s route %s on the %s listener: %d %q, want served %v", route.Scope, route.Pattern, listener.listener, w.Code, w.Body, want)
+			}
+		}
+	}
+	// Paths below an admin subtree are not served on the public listener
+	// either.
+	w := httptest.NewRecorder()
+	public.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/dashboard/api/jobs", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/dashboard/api/jobs on the public listener: %d, want 404", w.Code)
+	}
+	if got := public.Patterns(); strings.Join(got, " ") != "/health /start-proof /jobs/" {
+		t.Errorf("public patterns = %v", got)
+	}
+	if got := admin.Patterns(); strings.Join(got, " ") != "/health /reload-circuit /dashboard/" {
+		t.Errorf("admin patterns = %v", got)
+	}
+}
+
+func TestRegisterRefusesMisplacedRoutes(t *testing.T) {
+	if err := NewTable(Public).Handle(scoped(answer("/reload-circuit"), Admin)); err == nil {
+		t.Fatal("the public table accepted an admin route")
+	}
+	if err := NewTable(Admin).Handle(scoped(answer("/start-proof"), Public)); err == nil {
+		t.Fatal("the admin table accepted a public route")
+	}
+	if err := Register([]Route{scoped(answer("/reload-circuit"), Admin)}, NewTable(Public), nil); err == nil {
+		t.Fatal("Register() accepted an admin route without an admin listener")
+	}
+	if err := Register([]Route{scoped(answer("/x"), Scope(7))}, NewTable(Public), NewTable(Admin)); err == nil {
+		t.Fatal("Register() accepted a route of unknown scope")
+	}
+	// Without an admin listener, shared routes are only on the public one.
+	public := NewTable(Public)
+	if err := Register([]Route{scoped(answer("/health"), Shared)}, public, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeKeyPair writes a self-signed certificate and its key to dir and
+// returns their paths.
+func writeKeyPair(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, "server")
+	caFile, _ := writeKeyPair(t, dir, "client-ca")
+
+	config, cert, err := TLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		t.Fatalf("ClientAuth = %v without a client CA, want none", config.ClientAuth)
+	}
+	served, err := config.GetCertificate(nil)
+	if err != nil || served == nil {
+		t.Fatalf("GetCertificate() = %v, %v", served, err)
+	}
+
+	// A half-rotated pair keeps the previous certificate.
+	otherCert, _ := writeKeyPair(t, t.TempDir(), "server")
+	raw, err := os.ReadFile(otherCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, raw, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.Reload(); err == nil {
+		t.Fatal("Reload() accepted a certificate that does not match the key")
+	}
+	if again, _ := config.GetCertificate(nil); again != served {
+		t.Fatal("a failed reload replaced the certificate")
+	}
+
+	certFile, keyFile = writeKeyPair(t, dir, "admin")
+	config, _, err = TLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Fatalf("ClientAuth = %v with a client CA, want client certificates required", config.ClientAuth)
+	}
+
+	if _, _, err := TLSConfig(certFile, keyFile, keyFile); err == nil {
+		t.Fatal("TLSConfig() accepted a client CA file without certificates")
+	}
+	if _, _, err := TLSConfig(certFile, "", ""); err == nil {
+		t.Fatal("TLSConfig() accepted a missing key file")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+
+	"gnark-server/apichanges"
+)
+
+// servedPatterns returns the patterns of the routes.Route literals of
+// main.go, the routes the server can serve on either listener.
+func servedPatterns(t *testing.T) []string {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var patterns []string
+	ast.Inspect(f, func(n ast.Node) bool {
+		kv, ok := n.(*ast.KeyValueExpr)
+		if !ok {
+			return true
+		}
+		if key, ok := kv.Key.(*ast.Ident); !ok || key.Name != "Pattern" {
+			return true
+		}
+		lit, ok := kv.Value.(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			t.Fatalf("route pattern %#v is not a string literal", kv.Value)
+		}
+		pattern, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		patterns = append(patterns, pattern)
+		return true
+	})
+	if len(patterns) == 0 {
+		t.Fatal("no routes found in main.go")
+	}
+	return patterns
+}
+
+func TestEveryRouteHasAnAPIChange(t *testing.T) {
+	if err := apichanges.Validate(servedPatterns(t)); err != nil {
+		t.Fatal(err)
+	}
+}
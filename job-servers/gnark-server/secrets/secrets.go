@@ -0,0 +1,178 @@
+// Package secrets reads secrets from the environment or from files named by
+// a _FILE variable, as mounted by a vault sidecar, and watches those files so
+// that rotated secrets take effect without a restart where the consumer
+// supports it. Secret values are never logged.
+package secrets
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWatchInterval is how often watched files are read again when
+// SECRETS_WATCH_INTERVAL_SECONDS is not set.
+const DefaultWatchInterval = 30 * time.Second
+
+// Lookup returns the secret name from the file named by name_FILE, or else
+// from the variable name. path is the file it was read from, or "" when it
+// came from the environment. Setting both is an error, so that a stale value
+// cannot shadow the mounted file.
+func Lookup(name string) (value string, path string, err error) {
+	path = os.Getenv(name + "_FILE")
+	if path == "" {
+		return os.Getenv(name), "", nil
+	}
+	if os.Getenv(name) != "" {
+		return "", "", fmt.Errorf("set either %s or %s_FILE, not both", name, name)
+	}
+	value, err = readFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	return value, path, nil
+}
+
+// readFile reads a secret file without its trailing newline.
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Result is the outcome of checking one watched secret.
+type Result struct {
+	Name string `json:"name"`
+	// Rotated is set when the secret changed and the new value was applied.
+	Rotated bool `json:"rotated"`
+	// RestartRequired is set when the secret changed but its consumer only
+	// reads it at startup.
+	RestartRequired bool   `json:"restartRequired"`
+	Error           string `json:"error,omitempty"`
+}
+
+type watched struct {
+	name   string
+	path   string
+	apply  func(value string) error
+	digest [sha256.Size]byte
+	// pending is set once a change that needs a restart has been logged, so
+	// that it is not logged on every check.
+	pending bool
+}
+
+// Watcher polls secret files and hands changed values to their consumers.
+type Watcher struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	entries []*watched
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewWatcher returns a watcher that checks its files every interval once
+// started.
+func NewWatcher(interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	return &Watcher{interval: interval}
+}
+
+// Watch registers the file at path, holding the secret name. When its
+// content changes, apply is called with the new value; if apply fails, the
+// consumer keeps the previous value and the change is tried again on the
+// next check. A nil apply marks a consumer that only reads the secret at
+// startup: changes are logged as needing a restart. Nothing is watched when
+// path is "", for secrets set in the environment.
+func (w *Watcher) Watch(name string, path string, apply func(value string) error) {
+	if path == "" {
+		return
+	}
+	entry := &watched{name: name, path: path, apply: apply}
+	if data, err := os.ReadFile(path); err == nil {
+		entry.digest = sha256.Sum256(data)
+	}
+	w.mu.Lock()
+	w.entries = append(w.entries, entry)
+	w.mu.Unlock()
+}
+
+// Check reads every watched file now and applies the secrets that changed.
+func (w *Watcher) Check() []Result {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	results := make([]Result, 0, len(w.entries))
+	for _, entry := range w.entries {
+		results = append(results, entry.check())
+	}
+	return results
+}
+
+func (e *watched) check() Result {
+	result := Result{Name: e.name}
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		log.Printf("Failed to read secret %s: %v\n", e.name, err)
+		result.Error = err.Error()
+		return result
+	}
+	digest := sha256.Sum256(data)
+	if digest == e.digest {
+		// A change that was reverted no longer needs a restart.
+		e.pending = false
+		return result
+	}
+	if e.apply == nil {
+		if !e.pending {
+			log.Printf("Secret %s changed in %s, restart the server to apply it\n", e.name, e.path)
+			e.pending = true
+		}
+		result.RestartRequired = true
+		return result
+	}
+	if err := e.apply(strings.TrimRight(string(data), "\r\n")); err != nil {
+		log.Printf("Failed to rotate secret %s, keeping the previous value: %v\n", e.name, err)
+		result.Error = err.Error()
+		return result
+	}
+	e.digest = digest
+	log.Printf("Rotated secret %s from %s\n", e.name, e.path)
+	result.Rotated = true
+	return result
+}
+
+// Start checks the watched files every interval until Stop is called.
+func (w *Watcher) Start() {
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				w.Check()
+			}
+		}
+	}()
+}
+
+// Stop stops the checks started by Start.
+func (w *Watcher) Stop() {
+	if w.stop == nil {
+		return
+	}
+	close(w.stop)
+	<-w.done
+}
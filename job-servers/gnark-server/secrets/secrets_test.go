@@ -0,0 +1,162 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeSecret writes value to the file at path.
+func writeSecret(t *testing.T, path string, value string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(value), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// captureLog returns the log output of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys")
+	writeSecret(t, path, "ops=from-file\n")
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("API_KEYS", "ops=from-env")
+		t.Setenv("API_KEYS_FILE", "")
+		value, from, err := Lookup("API_KEYS")
+		if err != nil || value != "ops=from-env" || from != "" {
+			t.Fatalf("Lookup() = %q, %q, %v", value, from, err)
+		}
+	})
+	t.Run("file", func(t *testing.T) {
+		t.Setenv("API_KEYS", "")
+		t.Setenv("API_KEYS_FILE", path)
+		value, from, err := Lookup("API_KEYS")
+		if err != nil || value != "ops=from-file" || from != path {
+			t.Fatalf("Lookup() = %q, %q, %v, want the file content without its newline", value, from, err)
+		}
+	})
+	t.Run("both", func(t *testing.T) {
+		t.Setenv("API_KEYS", "ops=from-env")
+		t.Setenv("API_KEYS_FILE", path)
+		if _, _, err := Lookup("API_KEYS"); err == nil || !strings.Contains(err.Error(), "not both") {
+			t.Fatalf("Lookup() = %v, want an error for both being set", err)
+		}
+	})
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("API_KEYS", "")
+		t.Setenv("API_KEYS_FILE", path+".missing")
+		if _, _, err := Lookup("API_KEYS"); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("Lookup() = %v, want a not-exist error", err)
+		}
+	})
+}
+
+func TestWatcherCheck(t *testing.T) {
+	logs := captureLog(t)
+	dir := t.TempDir()
+	rotatedPath := filepath.Join(dir, "admin_token")
+	startupPath := filepath.Join(dir, "redis_url")
+	writeSecret(t, rotatedPath, "first-token\n")
+	writeSecret(t, startupPath, "redis://first")
+
+	var applied []string
+	refuse := false
+	w := NewWatcher(time.Hour)
+	w.Watch("ADMIN_TOKEN", rotatedPath, func(value string) error {
+		if refuse {
+			return errors.New("refused")
+		}
+		applied = append(applied, value)
+		return nil
+	})
+	w.Watch("REDIS_URL", startupPath, nil)
+	w.Watch("FROM_ENV", "", func(string) error { t.Fatal("applied a secret set in the environment"); return nil })
+
+	if results := w.Check(); len(results) != 2 || results[0].Rotated || results[1].RestartRequired || len(applied) != 0 {
+		t.Fatalf("Check() without changes = %+v, applied %v", results, applied)
+	}
+
+	writeSecret(t, rotatedPath, "second-token\n")
+	writeSecret(t, startupPath, "redis://second")
+	results := w.Check()
+	if !results[0].Rotated || len(applied) != 1 || applied[0] != "second-token" {
+		t.Fatalf("Check() after rotation = %+v, applied %v", results[0], applied)
+	}
+	if !results[1].RestartRequired || results[1].Rotated {
+		t.Fatalf("Check() of a startup-only secret = %+v, want restart required", results[1])
+	}
+	// A pending restart is reported on every check but logged once.
+	if results := w.Check(); results[0].Rotated || !results[1].RestartRequired {
+		t.Fatalf("second Check() = %+v", results)
+	}
+	if n := strings.Count(logs.String(), "restart the server"); n != 1 {
+		t.Fatalf("restart needed logged %d times, want once:\n%s", n, logs)
+	}
+	// Reverting the change clears it.
+	writeSecret(t, startupPath, "redis://first")
+	if results := w.Check(); results[1].RestartRequired {
+		t.Fatalf("Check() after revert = %+v", results[1])
+	}
+
+	// A refused value is retried on the next check.
+	refuse = true
+	writeSecret(t, rotatedPath, "third-token")
+	if results := w.Check(); results[0].Rotated || results[0].Error != "refused" {
+		t.Fatalf("Check() with a refused value = %+v", results[0])
+	}
+	refuse = false
+	if results := w.Check(); !results[0].Rotated || applied[len(applied)-1] != "third-token" {
+		t.Fatalf("Check() after the refusal = %+v, applied %v", results[0], applied)
+	}
+
+	// An unreadable file is reported and keeps the previous value.
+	os.Remove(rotatedPath)
+	if results := w.Check(); results[0].Rotated || results[0].Error == "" {
+		t.Fatalf("Check() of a removed file = %+v", results[0])
+	}
+
+	for _, value := range []string{"first-token", "second-token", "third-token", "redis://"} {
+		if strings.Contains(logs.String(), value) {
+			t.Fatalf("the log shows a secret value:\n%s", logs)
+		}
+	}
+}
+
+func TestWatcherStart(t *testing.T) {
+	captureLog(t)
+	path := filepath.Join(t.TempDir(), "api_keys")
+	writeSecret(t, path, "ops=first")
+	var current atomic.Value
+	current.Store("ops=first")
+	interval := 20 * time.Millisecond
+	w := NewWatcher(interval)
+	w.Watch("API_KEYS", path, func(value string) error {
+		current.Store(value)
+		return nil
+	})
+	w.Start()
+	defer w.Stop()
+
+	writeSecret(t, path, "ops=second")
+	deadline := time.Now().Add(50 * interval)
+	for current.Load() != "ops=second" {
+		if time.Now().After(deadline) {
+			t.Fatalf("the rotated secret did not take effect within %v", 50*interval)
+		}
+		time.Sleep(interval / 4)
+	}
+}
@@ -1,40 +1,68 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
+	"math/big"
 	"os"
+	"path/filepath"
 
 	verifierCircuit "gnark-server/circuit"
+	"gnark-server/circuitData"
+	"gnark-server/solc"
 	"gnark-server/trusted_setup"
 	"gnark-server/utils"
 
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark-crypto/kzg"
+	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/backend/witness"
 	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
 	"github.com/consensys/gnark/frontend/cs/scs"
 	"github.com/qope/gnark-plonky2-verifier/types"
 	"github.com/qope/gnark-plonky2-verifier/variables"
 )
 
-func loadCircuit() constraint.ConstraintSystem {
-	commonCircuitData := types.ReadCommonCircuitData("data/common_circuit_data.json")
-	proofRaw := types.ReadProofWithPublicInputs("data/proof_with_public_inputs.json")
-	proofWithPis := variables.DeserializeProofWithPublicInputs(proofRaw)
-	verifierOnlyCircuitData := variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData("data/verifier_only_circuit_data.json"))
-	inputHash, err := utils.CalculateInputDigest(proofRaw.PublicInputs)
+// inputDigest packs publicInputs into the inputHash of the circuit, as Define
+// does for the number of public inputs of commonCircuitData.
+func inputDigest(commonCircuitData types.CommonCircuitData, publicInputs []uint64) *big.Int {
+	cfg, err := utils.InputDigestConfigFor(int(commonCircuitData.NumPublicInputs), ecc.BN254.ScalarField())
+	if err != nil {
+		panic(fmt.Sprintf("failed to derive the input digest config: %v", err))
+	}
+	digest, err := utils.CalculateDigest(publicInputs, cfg)
 	if err != nil {
 		panic(fmt.Sprintf("failed to calculate input digest: %v", err))
 	}
+	return digest
+}
+
+func loadCircuit(paths circuitData.Paths, backend string) constraint.ConstraintSystem {
+	commonCircuitDataPath := paths.Path(circuitData.Files{}, circuitData.CommonCircuitDataFile)
+	commonCircuitData := types.ReadCommonCircuitData(commonCircuitDataPath)
+	if err := verifierCircuit.Validate(commonCircuitData); err != nil {
+		fmt.Printf("invalid %s:\n%v\n", commonCircuitDataPath, err)
+		os.Exit(1)
+	}
+	proofRaw := types.ReadProofWithPublicInputs(paths.Path(circuitData.Files{}, circuitData.ProofWithPublicInputsFile))
+	proofWithPis := variables.DeserializeProofWithPublicInputs(proofRaw)
+	verifierOnlyCircuitData := variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData(paths.Path(circuitData.Files{}, circuitData.VerifierOnlyCircuitDataFile)))
 	circuit := verifierCircuit.VerifierCircuit{
 		VerifierDigest:    verifierOnlyCircuitData.CircuitDigest,
-		InputHash:         frontend.Variable(inputHash),
+		InputHash:         frontend.Variable(inputDigest(commonCircuitData, proofRaw.PublicInputs)),
 		VerifierData:      verifierOnlyCircuitData,
 		ProofWithPis:      proofWithPis,
 		CommonCircuitData: commonCircuitData,
 	}
 	builder := scs.NewBuilder
+	if backend == circuitData.BackendGroth16 {
+		builder = r1cs.NewBuilder
+	}
 	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), builder, &circuit)
 	if err != nil {
 		panic(err)
@@ -43,35 +71,167 @@ func loadCircuit() constraint.ConstraintSystem {
 }
 
 func main() {
-	r1cs := loadCircuit()
+	paths := circuitData.PathsFromEnv()
+	dataDir := flag.String("data-dir", paths.DataDir(), "directory the circuit is read from and its keys are written to, $CIRCUIT_DATA_DIR by default")
+	backendFlag := flag.String("backend", os.Getenv("PROVING_BACKEND"), "proving backend, plonk or groth16 (default plonk, or $PROVING_BACKEND)")
+	flag.StringVar(backendFlag, "scheme", *backendFlag, "same as --backend")
+	flag.Parse()
+	backend := *backendFlag
+	if backend == "" {
+		backend = circuitData.BackendPlonk
+	}
+	if backend != circuitData.BackendPlonk && backend != circuitData.BackendGroth16 {
+		fmt.Printf("unknown proving backend %q, use %s or %s\n", backend, circuitData.BackendPlonk, circuitData.BackendGroth16)
+		os.Exit(1)
+	}
+	// With CIRCUIT set, the circuit is read from and its keys written to
+	// <data-dir>/<CIRCUIT>/, so that the server can load several circuits.
+	// The CIRCUIT_*_PATH overrides apply to it all the same.
+	dir := *dataDir
+	if name := os.Getenv("CIRCUIT"); name != "" {
+		dir = filepath.Join(dir, name)
+	}
+	paths = paths.In(dir)
+	if err := paths.CheckInputs(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	ccs := loadCircuit(paths, backend)
 
-	proofRaw := types.ReadProofWithPublicInputs("data/proof_with_public_inputs.json")
+	proofRaw := types.ReadProofWithPublicInputs(paths.Path(circuitData.Files{}, circuitData.ProofWithPublicInputsFile))
 	proofWithPis := variables.DeserializeProofWithPublicInputs(proofRaw)
-	verifierOnlyCircuitData := variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData("data/verifier_only_circuit_data.json"))
-	inputHash, err := utils.CalculateInputDigest(proofRaw.PublicInputs)
+	verifierOnlyCircuitData := variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData(paths.Path(circuitData.Files{}, circuitData.VerifierOnlyCircuitDataFile)))
+	commonCircuitData := types.ReadCommonCircuitData(paths.Path(circuitData.Files{}, circuitData.CommonCircuitDataFile))
+
+	assignment := verifierCircuit.VerifierCircuit{
+		VerifierDigest:    verifierOnlyCircuitData.CircuitDigest,
+		InputHash:         inputDigest(commonCircuitData, proofRaw.PublicInputs),
+		ProofWithPis:      proofWithPis,
+		VerifierData:      verifierOnlyCircuitData,
+		CommonCircuitData: commonCircuitData,
+	}
+	witness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
 	if err != nil {
-		panic(fmt.Sprintf("failed to calculate input digest: %v", err))
+		panic(err)
+	}
+	witnessPublic, err := witness.Public()
+	if err != nil {
+		panic(err)
 	}
 
-	// 1. One setup
-	var srs kzg.SRS = kzg.NewSRS(ecc.BN254)
+	var pk io.WriterTo
+	var vk circuitData.VerifyingKey
+	if backend == circuitData.BackendGroth16 {
+		pk, vk = setupGroth16(ccs, witness, witnessPublic)
+	} else {
+		pk, vk = setupPlonk(ccs, witness, witnessPublic)
+	}
+
+	files := circuitData.BackendFiles(backend)
 	{
-		fileName := "srs_setup"
+		fSol, _ := os.Create(filepath.Join(dir, files.SolidityVerifier))
+		_ = vk.ExportSolidity(fSol)
+		fSol.Close()
+	}
+	// Check the verifier before writing the keys, so that a failure leaves
+	// the previous release in place.
+	if !checkSolidityVerifier(dir, files) {
+		os.Exit(1)
+	}
+	writeArtifact(paths.Path(files, files.VerifyingKey), vk)
+	writeArtifact(paths.Path(files, files.ProvingKey), pk)
+	writeArtifact(paths.Path(files, files.Circuit), ccs)
+	if err := circuitData.WriteCacheKey(paths, backend); err != nil {
+		panic(err)
+	}
+	// The manifest is written last: the server refuses keys that do not
+	// match it, such as the ones of an interrupted run.
+	if err := circuitData.WriteSetupManifest(paths, backend); err != nil {
+		panic(err)
+	}
+	fmt.Println("Setup done!")
+}
 
-		if _, err := os.Stat(fileName); os.IsNotExist(err) {
-			trusted_setup.DownloadAndSaveAztecIgnitionSrs(174, fileName)
-		}
+// writeArtifact writes a key or the constraint system to path, and panics
+// if it could not be written entirely.
+func writeArtifact(path string, artifact io.WriterTo) {
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := artifact.WriteTo(f); err != nil {
+		panic(fmt.Sprintf("failed to write %s: %v", path, err))
+	}
+	if err := f.Close(); err != nil {
+		panic(fmt.Sprintf("failed to write %s: %v", path, err))
+	}
+}
 
-		fSRS, err := os.Open(fileName)
+// checkSolidityVerifier compiles the Solidity verifier with every version in
+// SOLC_VERSIONS, the first being the one it is deployed with, and records the
+// results next to it in dir. It returns false if the first version failed.
+func checkSolidityVerifier(dir string, files circuitData.Files) bool {
+	versions := solc.ParseVersions(os.Getenv("SOLC_VERSIONS"))
+	reportPath := filepath.Join(dir, files.SolcReport)
+	if len(versions) == 0 {
+		fmt.Println("SOLC_VERSIONS is not set, skipping the Solidity verifier check")
+		os.Remove(reportPath)
+		return true
+	}
+	template := os.Getenv("SOLC_COMMAND")
+	if template == "" {
+		template = solc.DefaultTemplate
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		panic(err)
+	}
+	report := solc.Check(context.Background(), solc.Command{Template: template}, versions, absDir, []string{files.SolidityVerifier})
+	for _, result := range report.Results {
+		if result.Error != "" {
+			fmt.Printf("solc %s: %s: %s\n", result.Version, result.Status, result.Error)
+		} else {
+			fmt.Printf("solc %s: %s\n", result.Version, result.Status)
+		}
+	}
+	if err := report.Write(reportPath); err != nil {
+		panic(err)
+	}
+	if !report.PrimaryPassed() {
+		fmt.Printf("%s does not compile with solc %s, the version it is deployed with\n", files.SolidityVerifier, versions[0])
+		return false
+	}
+	return true
+}
 
+// setupPlonk runs the PLONK setup over the Aztec Ignition SRS, or over
+// PTAU_FILE if set, and checks the keys by proving the sample proof.
+func setupPlonk(r1cs constraint.ConstraintSystem, witness, witnessPublic witness.Witness) (plonk.ProvingKey, plonk.VerifyingKey) {
+	var err error
+	// 1. One setup
+	var srs kzg.SRS
+	if ptauFile := os.Getenv("PTAU_FILE"); ptauFile != "" {
+		// PLONK needs the domain size plus 3 powers of tau.
+		domainSize := ecc.NextPowerOfTwo(uint64(r1cs.GetNbConstraints() + r1cs.GetNbPublicVariables()))
+		srs, err = trusted_setup.LoadPtau(ptauFile, int(domainSize)+2)
 		if err != nil {
 			panic(err)
 		}
+	} else {
+		fileName := "srs_setup"
 
-		_, err = srs.ReadFrom(fSRS)
-
-		fSRS.Close()
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			downloadConfig := trusted_setup.DownloadConfig{}
+			downloadConfig.ExpectedSHA256, err = trusted_setup.LoadExpectedSHA256("srs_sha256.json")
+			if err != nil && !os.IsNotExist(err) {
+				panic(err)
+			}
+			if err := trusted_setup.DownloadAndSaveAztecIgnitionSrs(174, fileName, downloadConfig); err != nil {
+				panic(err)
+			}
+		}
 
+		srs, err = trusted_setup.OpenMapped(fileName)
 		if err != nil {
 			panic(err)
 		}
@@ -81,49 +241,38 @@ func main() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
-	assignment := verifierCircuit.VerifierCircuit{
-		VerifierDigest:    verifierOnlyCircuitData.CircuitDigest,
-		InputHash:         inputHash,
-		ProofWithPis:      proofWithPis,
-		VerifierData:      verifierOnlyCircuitData,
-		CommonCircuitData: types.ReadCommonCircuitData("data/common_circuit_data.json"),
-	}
-	witness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
-	if err != nil {
-		panic(err)
-	}
+	// 2. Proof creation
 	proof, err := plonk.Prove(r1cs, pk, witness)
 	if err != nil {
 		panic(err)
 	}
 	// 3. Proof verification
-	witnessPublic, err := witness.Public()
-	if err != nil {
-		panic(err)
-	}
 	err = plonk.Verify(proof, vk, witnessPublic)
 	if err != nil {
 		panic(err)
 	}
-	{
-		fSol, _ := os.Create("data/verifier.sol")
-		_ = vk.ExportSolidity(fSol)
-		fSol.Close()
-	}
-	{
-		fVk, _ := os.Create("data/verifying.key")
-		_, _ = vk.WriteTo(fVk)
-		fVk.Close()
+	return pk, vk
+}
+
+// setupGroth16 runs the circuit specific Groth16 setup and checks the keys by
+// proving the sample proof. The setup samples its toxic waste locally, so
+// the keys are only as trustworthy as the machine that ran it.
+func setupGroth16(r1cs constraint.ConstraintSystem, witness, witnessPublic witness.Witness) (groth16.ProvingKey, groth16.VerifyingKey) {
+	// 1. One setup
+	pk, vk, err := groth16.Setup(r1cs)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	{
-		fPk, _ := os.Create("data/proving.key")
-		_, _ = pk.WriteTo(fPk)
-		fPk.Close()
+	// 2. Proof creation
+	proof, err := groth16.Prove(r1cs, pk, witness)
+	if err != nil {
+		panic(err)
 	}
-	{
-		fCs, _ := os.Create("data/circuit.r1cs")
-		_, _ = r1cs.WriteTo(fCs)
-		fCs.Close()
+	// 3. Proof verification
+	err = groth16.Verify(proof, vk, witnessPublic)
+	if err != nil {
+		panic(err)
 	}
-	fmt.Println("Setup done!")
+	return pk, vk
 }
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gnark-server/circuitData"
+	"gnark-server/handlers"
+	"gnark-server/redisconfig"
+	"gnark-server/secrets"
+	"gnark-server/snapshot"
+
+	"github.com/joho/godotenv"
+)
+
+const (
+	defaultSnapshotLimit   = 20
+	maxSnapshotLimit       = 1000
+	snapshotStatusTimeout  = 10 * time.Second
+	snapshotStatusMaxBytes = 16 << 20
+)
+
+// snapshotConfigVars are the environment variables recorded in a snapshot,
+// besides the OTEL_ ones.
+var snapshotConfigVars = []string{
+	"PORT", "REDIS_URL", "PROVING_BACKEND", "DEGRADED_VERIFY_ONLY", "LAYOUT_MIGRATION_DRY_RUN", "FAST_KEY_LOAD", "WORKER_COUNT",
+	"CIRCUIT_DATA_DIR", "CIRCUIT_PROVING_KEY_PATH", "CIRCUIT_VERIFYING_KEY_PATH", "CIRCUIT_CONSTRAINT_SYSTEM_PATH", "CIRCUIT_COMMON_DATA_PATH", "CIRCUIT_VERIFIER_ONLY_DATA_PATH", "CIRCUIT_PROOF_WITH_PUBLIC_INPUTS_PATH", "CIRCUIT_OBJECT_CACHE_DIR",
+	"MAX_QUEUE_LENGTH", "PROVE_TIMEOUT_SECONDS", "MAX_PROVE_TIMEOUT_SECONDS", "DEADLINE_POLICY", "MAX_PROVE_WAIT_SECONDS", "MAX_PUBLIC_INPUTS", "MAX_WS_CONNECTIONS", "MAX_JOB_ATTEMPTS", "MAX_RETRIES", "SHARD_COUNT", "SHARD_INDEX", "SHARD_FALLBACK_BACKLOG", "JOB_RESULT_TTL_SECONDS", "FAILED_JOB_TTL_SECONDS", "RESULT_TTL_SECONDS", "FAILED_RESULT_TTL_SECONDS", "RETRY_INPUT_TTL_SECONDS", "EVENT_LOG_MAX_LENGTH",
+	"PROOF_CACHE_TTL_SECONDS", "IDEMPOTENCY_WINDOW_SECONDS", "STORE_INPUTS", "JOB_LIST_CAPS", "DELIVERY_WORKERS", "DELIVERY_QUEUE_SIZE",
+	"PROOF_EVENTS_IDLE_TIMEOUT_SECONDS", "SHUTDOWN_TIMEOUT_SECONDS", "JOB_STORE_PATH", "MIRROR_DATABASE_URL",
+	"VALIDATE_CALLBACK", "CALLBACK_ALLOW_PRIVATE_TARGETS", "CALLBACK_MAX_ATTEMPTS", "CALLBACK_DEDUPE_WINDOW_SECONDS",
+	"AUTH_DISABLED", "API_KEYS", "JWT_SECRET", "JWT_ISSUER", "RATE_LIMIT_RPM", "START_PROOF_RATE_LIMIT", "START_PROOF_RATE_BURST", "TRUSTED_PROXY_DEPTH", "CORS_ALLOWED_ORIGINS", "CORS_ALLOWED_HEADERS", "CORS_ALLOW_ALL",
+	"GRPC_PORT", "PUBLIC_STATUS_FIELDS", "ADMIN_PORT", "ADMIN_TOKEN",
+	"TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_DOMAIN", "ACME_EMAIL", "ACME_CACHE_DIR", "ADMIN_TLS_CERT_FILE", "ADMIN_TLS_KEY_FILE", "ADMIN_TLS_CLIENT_CA_FILE",
+	"METRICS_PUSH", "METRICS_PUSH_INTERVAL_SECONDS", "METRICS_PUSH_URL", "METRICS_PUSH_BEARER_TOKEN", "INSTANCE_ID", "FLEET_HEARTBEAT_SECONDS",
+	"MAX_DECOMPRESSED_BODY_BYTES",
+	"REDIS_URL_FILE", "API_KEYS_FILE", "JWT_SECRET_FILE", "ADMIN_TOKEN_FILE", "MIRROR_DATABASE_URL_FILE", "METRICS_PUSH_BEARER_TOKEN_FILE", "SECRETS_WATCH_INTERVAL_SECONDS",
+	"WRAPPER_SOURCE_FILE", "WRAPPER_ADDRESS", "WRAPPER_RPC_URL", "WRAPPER_DIGEST_GETTER", "WRAPPER_CIRCUIT",
+}
+
+// snapshotState connects to REDIS_URL with just enough state to read and
+// write job records.
+func snapshotState(logger *log.Logger) (*handlers.State, bool) {
+	redisURL, _, err := secrets.Lookup("REDIS_URL")
+	if err != nil {
+		logger.Println(err)
+		return nil, false
+	}
+	if redisURL == "" {
+		logger.Println("REDIS_URL environment variable is not set")
+		return nil, false
+	}
+	rdb, err := redisconfig.Parse(redisURL)
+	if err != nil {
+		logger.Println("Redis URL parsing error:", err)
+		return nil, false
+	}
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		logger.Println("Redis connection error:", err)
+		return nil, false
+	}
+	return &handlers.State{RedisClient: rdb}, true
+}
+
+// runSnapshot implements `gnark-server snapshot`, which writes the records of
+// selected jobs, the circuit release and the redacted configuration to an
+// archive that import-snapshot loads into a development server. The return
+// value is the exit code.
+func runSnapshot(args []string) int {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	out := flags.String("out", "", "path of the archive (.tar.gz)")
+	jobs := flags.String("jobs", "", "comma-separated job IDs; when empty, jobs are selected with --state, --client and --since")
+	state := flags.String("state", "", "only jobs in this lifecycle state")
+	client := flags.String("client", "", "only jobs submitted by this API key")
+	since := flags.Duration("since", 0, "only jobs queued within this duration, e.g. 24h")
+	limit := flags.Int("limit", defaultSnapshotLimit, fmt.Sprintf("maximum number of jobs selected by filter, at most %d", maxSnapshotLimit))
+	withInputs := flags.Bool("with-inputs", false, "include the stored inputs of the jobs")
+	dataDir := flags.String("data-dir", circuitData.PathsFromEnv().DataDir(), "directory holding the keys and compiled circuit written by setup, $CIRCUIT_DATA_DIR by default")
+	maxArtifactBytes := flags.Int64("max-artifact-bytes", 1<<20, "release files up to this size are included in the archive, larger ones are only listed")
+	adminURL := flags.String("admin-url", "", "base URL of the admin listener; when set, the dashboard summary is included, authenticated with $ADMIN_TOKEN")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *out == "" || flags.NArg() != 0 || *limit < 1 || *limit > maxSnapshotLimit {
+		fmt.Fprintln(os.Stderr, "usage: gnark-server snapshot --out <snapshot.tar.gz> [--jobs <id,...> | --state <state> --client <name> --since <duration> --limit <n>] [--with-inputs] [--data-dir <dir>] [--admin-url <url>]")
+		return exitUsage
+	}
+	godotenv.Load()
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	s, ok := snapshotState(logger)
+	if !ok {
+		return 1
+	}
+	ctx := context.Background()
+
+	var jobIds []string
+	if *jobs != "" {
+		for _, jobId := range strings.Split(*jobs, ",") {
+			if jobId = strings.TrimSpace(jobId); jobId != "" {
+				jobIds = append(jobIds, jobId)
+			}
+		}
+	} else {
+		filter := handlers.JobFilter{State: *state, Client: *client}
+		if *since > 0 {
+			filter.Since = time.Now().Add(-*since)
+		}
+		var err error
+		if jobIds, err = s.FindJobs(ctx, filter, *limit); err != nil {
+			logger.Println("Failed to select jobs:", err)
+			return 1
+		}
+	}
+
+	backend := os.Getenv("PROVING_BACKEND")
+	if backend == "" {
+		backend = circuitData.BackendPlonk
+	}
+	archive := &snapshot.Archive{
+		Manifest: snapshot.Manifest{
+			Version:   snapshot.FormatVersion,
+			CreatedAt: time.Now().UTC(),
+			Release:   snapshot.Release{Backend: backend},
+			Config:    snapshot.RedactConfig(snapshotConfig()),
+		},
+		Artifacts: map[string][]byte{},
+	}
+	for _, jobId := range jobIds {
+		job, err := s.SnapshotJob(ctx, jobId, *withInputs)
+		if err != nil {
+			logger.Printf("Skipping job %s: %v\n", jobId, err)
+			continue
+		}
+		archive.Jobs = append(archive.Jobs, job)
+		archive.Manifest.Jobs = append(archive.Manifest.Jobs, jobId)
+	}
+
+	releaseId, err := circuitData.ReleaseId(*dataDir, backend)
+	if err != nil {
+		logger.Println("Warning: no release ID, the verifying key could not be read:", err)
+	}
+	archive.Manifest.Release.Id = releaseId
+	releaseDir, err := circuitData.ReleaseDir(*dataDir, backend)
+	if err != nil {
+		logger.Println("Failed to find the release files:", err)
+		return 1
+	}
+	for _, name := range circuitData.BackendFiles(backend).Names() {
+		file, data, err := snapshotFile(filepath.Join(releaseDir, name), *maxArtifactBytes)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			logger.Printf("Failed to read release file %s: %v\n", name, err)
+			return 1
+		}
+		file.Name = name
+		archive.Manifest.Release.Files = append(archive.Manifest.Release.Files, file)
+		if file.Included {
+			archive.Artifacts[name] = data
+		}
+	}
+
+	if *adminURL != "" {
+		adminToken, _, err := secrets.Lookup("ADMIN_TOKEN")
+		if err != nil {
+			logger.Println(err)
+			return 1
+		}
+		status, err := fetchDashboardSummary(*adminURL, adminToken)
+		if err != nil {
+			logger.Println("Warning: the dashboard summary is not included:", err)
+		}
+		archive.Status = status
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		logger.Println("Failed to create archive:", err)
+		return 1
+	}
+	if err := snapshot.Write(f, archive); err != nil {
+		f.Close()
+		logger.Println("Failed to write archive:", err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		logger.Println("Failed to write archive:", err)
+		return 1
+	}
+	logger.Printf("Wrote %d jobs of release %q to %s\n", len(archive.Jobs), releaseId, *out)
+	return 0
+}
+
+// snapshotConfig returns the server's configuration variables that are set.
+func snapshotConfig() map[string]string {
+	config := map[string]string{}
+	for _, name := range snapshotConfigVars {
+		if value, ok := os.LookupEnv(name); ok {
+			config[name] = value
+		}
+	}
+	for _, kv := range os.Environ() {
+		if name, value, _ := strings.Cut(kv, "="); strings.HasPrefix(name, "OTEL_") {
+			config[name] = value
+		}
+	}
+	return config
+}
+
+// snapshotFile describes a release file and returns its content when it is
+// at most maxBytes long.
+func snapshotFile(path string, maxBytes int64) (snapshot.File, []byte, error) {
+	var file snapshot.File
+	info, err := os.Stat(path)
+	if err != nil {
+		return file, nil, err
+	}
+	file.Size = info.Size()
+	if file.Size > maxBytes {
+		return file, nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, nil, err
+	}
+	sum := sha256.Sum256(data)
+	file.Sha256 = hex.EncodeToString(sum[:])
+	file.Included = true
+	return file, data, nil
+}
+
+// fetchDashboardSummary reads the dashboard summary, which includes the
+// readiness history, from the admin listener at baseURL.
+func fetchDashboardSummary(baseURL string, token string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/dashboard/api/summary", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := &http.Client{Timeout: snapshotStatusTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, snapshotStatusMaxBytes))
+}
+
+// runImportSnapshot implements `gnark-server import-snapshot`, which loads the
+// jobs of a snapshot into REDIS_URL. It only runs with DEV_MODE=true. Jobs of
+// a release other than the one in --data-dir are marked as non-provable, so
+// that they can be inspected but not retried or replayed.
+func runImportSnapshot(args []string) int {
+	flags := flag.NewFlagSet("import-snapshot", flag.ContinueOnError)
+	archivePath := flags.String("archive", "", "snapshot archive written by `gnark-server snapshot`")
+	dataDir := flags.String("data-dir", circuitData.PathsFromEnv().DataDir(), "directory holding the keys and compiled circuit written by setup, $CIRCUIT_DATA_DIR by default")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *archivePath == "" || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: gnark-server import-snapshot --archive <snapshot.tar.gz> [--data-dir <dir>]")
+		return exitUsage
+	}
+	godotenv.Load()
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	if os.Getenv("DEV_MODE") != "true" {
+		logger.Println("import-snapshot writes production records into REDIS_URL and only runs with DEV_MODE=true")
+		return exitUsage
+	}
+
+	f, err := os.Open(*archivePath)
+	if err != nil {
+		logger.Println("Failed to open archive:", err)
+		return 1
+	}
+	archive, err := snapshot.Read(f)
+	f.Close()
+	if err != nil {
+		logger.Println("Failed to read archive:", err)
+		return 1
+	}
+	s, ok := snapshotState(logger)
+	if !ok {
+		return 1
+	}
+
+	release := archive.Manifest.Release
+	localId, err := circuitData.ReleaseId(*dataDir, release.Backend)
+	nonProvable := err != nil || release.Id == "" || localId != release.Id
+	if nonProvable {
+		logger.Printf("Release %q of the snapshot is not loaded from %s, jobs are imported as non-provable\n", release.Id, *dataDir)
+	}
+
+	ctx := context.Background()
+	restored, skipped := 0, 0
+	for _, job := range archive.Jobs {
+		ok, err := s.RestoreJob(ctx, job, nonProvable)
+		if err != nil {
+			logger.Printf("Failed to import job %s: %v\n", job.JobId, err)
+			return 1
+		}
+		if ok {
+			restored++
+		} else {
+			logger.Printf("Job %s already exists, skipped\n", job.JobId)
+			skipped++
+		}
+	}
+	logger.Printf("Imported %d jobs, skipped %d\n", restored, skipped)
+	return 0
+}
@@ -0,0 +1,241 @@
+// Package snapshot reads and writes bounded snapshots of a server's state:
+// selected job records, the circuit release and its small artifacts, the
+// configuration with secrets redacted and the readiness history. Snapshots
+// are gzipped tar archives so that they can also be unpacked by hand.
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FormatVersion is the version of the archive layout written by Write.
+const FormatVersion = 1
+
+const (
+	manifestName = "manifest.json"
+	statusName   = "status.json"
+	jobsDir      = "jobs/"
+	artifactsDir = "artifacts/"
+
+	// maxEntrySize bounds every archive entry read back, so that a corrupt
+	// archive cannot exhaust memory.
+	maxEntrySize = 256 << 20
+)
+
+// Manifest describes the content of a snapshot.
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Release   Release   `json:"release"`
+	// Config holds the server's environment configuration, with secrets
+	// replaced by Redacted.
+	Config map[string]string `json:"config"`
+	// Jobs lists the IDs of the jobs in the snapshot.
+	Jobs []string `json:"jobs"`
+}
+
+// Release identifies the circuit release the snapshot was taken with.
+type Release struct {
+	Id      string `json:"id"`
+	Backend string `json:"backend"`
+	Files   []File `json:"files"`
+}
+
+// File describes a file of the release's data directory. Only files that are
+// Included are stored in the archive, under artifacts/.
+type File struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256,omitempty"`
+	Included bool   `json:"included"`
+}
+
+// Job is the stored state of one job: its response, its metadata and,
+// optionally, its input and callback attempts, as they are kept in Redis.
+type Job struct {
+	JobId            string            `json:"jobId"`
+	Result           json.RawMessage   `json:"result,omitempty"`
+	Metadata         map[string]string `json:"metadata"`
+	Input            json.RawMessage   `json:"input,omitempty"`
+	CallbackAttempts []json.RawMessage `json:"callbackAttempts,omitempty"`
+}
+
+// Archive is the content of a snapshot.
+type Archive struct {
+	Manifest Manifest
+	Jobs     []Job
+	// Artifacts maps file names of the release to their content.
+	Artifacts map[string][]byte
+	// Status is the dashboard summary of the server, including its readiness
+	// history, when it could be fetched.
+	Status json.RawMessage
+}
+
+// Write writes a as a gzipped tar archive.
+func Write(w io.Writer, a *Archive) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	modTime := a.Manifest.CreatedAt
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, data)
+	}
+
+	if err := addJSON(manifestName, a.Manifest); err != nil {
+		return err
+	}
+	for _, job := range a.Jobs {
+		// Job records are not indented, so that results and inputs are
+		// restored byte for byte.
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if err := add(jobsDir+job.JobId+".json", data); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(a.Artifacts))
+	for name := range a.Artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := add(artifactsDir+name, a.Artifacts[name]); err != nil {
+			return err
+		}
+	}
+	if len(a.Status) > 0 {
+		if err := add(statusName, a.Status); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Read reads an archive written by Write.
+func Read(r io.Reader) (*Archive, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	a := &Archive{Artifacts: map[string][]byte{}}
+	hasManifest := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Size > maxEntrySize {
+			return nil, fmt.Errorf("archive entry %s is %d bytes, more than %d", hdr.Name, hdr.Size, maxEntrySize)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		switch {
+		case name == manifestName:
+			if err := json.Unmarshal(data, &a.Manifest); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+			hasManifest = true
+		case name == statusName:
+			a.Status = data
+		case strings.HasPrefix(name, jobsDir):
+			var job Job
+			if err := json.Unmarshal(data, &job); err != nil {
+				return nil, fmt.Errorf("invalid job record %s: %w", name, err)
+			}
+			a.Jobs = append(a.Jobs, job)
+		case strings.HasPrefix(name, artifactsDir):
+			a.Artifacts[strings.TrimPrefix(name, artifactsDir)] = data
+		}
+	}
+	if !hasManifest {
+		return nil, errors.New("archive has no manifest")
+	}
+	if a.Manifest.Version != FormatVersion {
+		return nil, fmt.Errorf("archive version %d is not supported, expected %d", a.Manifest.Version, FormatVersion)
+	}
+	return a, nil
+}
+
+// Redacted replaces secret configuration values.
+const Redacted = "[redacted]"
+
+// secretMarkers are the parts of variable names whose values are secrets.
+var secretMarkers = []string{"TOKEN", "KEY", "SECRET", "PASSWORD"}
+
+// RedactConfig returns env with the values of secret variables replaced by
+// Redacted and the passwords of URLs masked. Paths of key files are kept.
+func RedactConfig(env map[string]string) map[string]string {
+	redacted := make(map[string]string, len(env))
+	for name, value := range env {
+		redacted[name] = redactValue(name, value)
+	}
+	return redacted
+}
+
+func redactValue(name string, value string) string {
+	if value == "" || strings.HasSuffix(name, "_FILE") || strings.HasSuffix(name, "_PATH") {
+		return value
+	}
+	for _, marker := range secretMarkers {
+		if strings.Contains(name, marker) {
+			return Redacted
+		}
+	}
+	if strings.HasSuffix(name, "_URL") {
+		u, err := url.Parse(value)
+		if err != nil {
+			return Redacted
+		}
+		// Query parameters such as the sentinel_password of Redis Sentinel
+		// URLs are masked like the password of the URL.
+		query := u.Query()
+		masked := false
+		for key := range query {
+			if strings.Contains(strings.ToLower(key), "password") {
+				query.Set(key, "xxxxx")
+				masked = true
+			}
+		}
+		if masked {
+			u.RawQuery = query.Encode()
+		}
+		return u.Redacted()
+	}
+	return value
+}
@@ -0,0 +1,168 @@
+// Package solc checks that the Solidity verifier written by setup compiles
+// with the solc versions it will be deployed with, so that an incompatible
+// compiler is caught at setup rather than at deployment.
+package solc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Outcomes of compiling with one version.
+const (
+	StatusPassed = "passed"
+	StatusFailed = "failed"
+	// StatusUnavailable means the compiler of that version could not be run.
+	StatusUnavailable = "unavailable"
+)
+
+const maxErrorLength = 2048
+
+// ErrUnavailable is returned, wrapped, by compilers that cannot run a
+// version, for example because its binary is not installed.
+var ErrUnavailable = errors.New("compiler not available")
+
+// Compiler compiles Solidity sources with a given solc version.
+type Compiler interface {
+	// Compile compiles files, relative to dir, and returns the compiler's
+	// binary output.
+	Compile(ctx context.Context, version string, dir string, files []string) ([]byte, error)
+}
+
+// Result is the outcome of compiling with one version.
+type Result struct {
+	Version string `json:"version"`
+	// Primary is set on the version contracts are deployed with. Setup fails
+	// unless it passes.
+	Primary bool   `json:"primary,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	// BytecodeSha256 is the hex SHA-256 of the compiler output, set when
+	// the compilation passed.
+	BytecodeSha256 string `json:"bytecodeSha256,omitempty"`
+}
+
+// Report lists the results of every configured version.
+type Report struct {
+	CheckedAt time.Time `json:"checkedAt"`
+	Results   []Result  `json:"results"`
+}
+
+// Check compiles files in dir with every version. The first version is the
+// primary one.
+func Check(ctx context.Context, compiler Compiler, versions []string, dir string, files []string) Report {
+	report := Report{CheckedAt: time.Now().UTC()}
+	for i, version := range versions {
+		result := Result{Version: version, Primary: i == 0, Status: StatusPassed}
+		out, err := compiler.Compile(ctx, version, dir, files)
+		switch {
+		case errors.Is(err, ErrUnavailable):
+			result.Status = StatusUnavailable
+			result.Error = err.Error()
+		case err != nil:
+			result.Status = StatusFailed
+			result.Error = truncate(err.Error())
+		default:
+			sum := sha256.Sum256(out)
+			result.BytecodeSha256 = hex.EncodeToString(sum[:])
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// PrimaryPassed reports whether the primary version compiled the sources.
+func (r Report) PrimaryPassed() bool {
+	return len(r.Results) > 0 && r.Results[0].Status == StatusPassed
+}
+
+// Write saves the report as JSON to path.
+func (r Report) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadReport loads a report saved by Write.
+func ReadReport(path string) (Report, error) {
+	var r Report
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, fmt.Errorf("invalid solc report %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// ParseVersions parses a comma separated list of versions such as
+// "0.8.19,0.8.24".
+func ParseVersions(s string) []string {
+	var versions []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// Command runs a solc binary or a docker image. Template is the command
+// line, in which {version} is replaced by the version and {dir} by the
+// absolute source directory; the sources are passed as arguments after
+// --optimize --bin, relative to dir, which is also the working directory.
+// For example "solc-{version}" or
+// "docker run --rm -v {dir}:/sources -w /sources ethereum/solc:{version}".
+type Command struct {
+	Template string
+}
+
+// DefaultTemplate runs solc-<version> from PATH, as installed by svm or
+// solc-select.
+const DefaultTemplate = "solc-{version}"
+
+func (c Command) Compile(ctx context.Context, version string, dir string, files []string) ([]byte, error) {
+	replacer := strings.NewReplacer("{version}", version, "{dir}", dir)
+	args := strings.Fields(replacer.Replace(c.Template))
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: empty command", ErrUnavailable)
+	}
+	args = append(args, "--optimize", "--bin")
+	args = append(args, files...)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	// A command looked up in PATH fails with exec.Error, one given by path
+	// with fs.PathError.
+	var execErr *exec.Error
+	var pathErr *fs.PathError
+	if errors.As(err, &execErr) || errors.As(err, &pathErr) {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	} else if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func truncate(s string) string {
+	if len(s) <= maxErrorLength {
+		return s
+	}
+	return s[:maxErrorLength] + "…"
+}
@@ -0,0 +1,150 @@
+package solc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubCompiler compiles with the versions of output and fails with the
+// versions of errs.
+type stubCompiler struct {
+	output map[string]string
+	errs   map[string]error
+	calls  []string
+}
+
+func (c *stubCompiler) Compile(ctx context.Context, version string, dir string, files []string) ([]byte, error) {
+	c.calls = append(c.calls, fmt.Sprintf("%s %s %s", version, dir, strings.Join(files, " ")))
+	if err := c.errs[version]; err != nil {
+		return nil, err
+	}
+	return []byte(c.output[version]), nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCheck(t *testing.T) {
+	compiler := &stubCompiler{
+		output: map[string]string{"0.8.19": "6080", "0.8.24": "6081"},
+		errs: map[string]error{
+			"0.8.25": errors.New("CompilerError: Stack too deep. " + strings.Repeat("x", 3000)),
+			"0.8.26": fmt.Errorf("%w: solc-0.8.26 not found", ErrUnavailable),
+		},
+	}
+	report := Check(context.Background(), compiler, []string{"0.8.19", "0.8.24", "0.8.25", "0.8.26"}, "/data", []string{"verifier.sol"})
+
+	if want := []string{"0.8.19 /data verifier.sol", "0.8.24 /data verifier.sol", "0.8.25 /data verifier.sol", "0.8.26 /data verifier.sol"}; !reflect.DeepEqual(compiler.calls, want) {
+		t.Fatalf("compiled %v, want %v", compiler.calls, want)
+	}
+	if len(report.Results) != 4 {
+		t.Fatalf("Check() = %+v, want a result per version", report)
+	}
+	passed := []Result{
+		{Version: "0.8.19", Primary: true, Status: StatusPassed, BytecodeSha256: sha256Hex("6080")},
+		{Version: "0.8.24", Status: StatusPassed, BytecodeSha256: sha256Hex("6081")},
+	}
+	if !reflect.DeepEqual(report.Results[:2], passed) {
+		t.Fatalf("passing results = %+v, want %+v", report.Results[:2], passed)
+	}
+	if failed := report.Results[2]; failed.Status != StatusFailed || !strings.HasPrefix(failed.Error, "CompilerError: Stack too deep.") ||
+		len(failed.Error) > maxErrorLength+len("…") || failed.BytecodeSha256 != "" {
+		t.Fatalf("failing result = %+v, want the truncated compiler error", failed)
+	}
+	if unavailable := report.Results[3]; unavailable.Status != StatusUnavailable || !strings.Contains(unavailable.Error, "not found") {
+		t.Fatalf("unavailable result = %+v", unavailable)
+	}
+	if !report.PrimaryPassed() {
+		t.Fatal("PrimaryPassed() = false, want true")
+	}
+}
+
+func TestCheckPrimaryFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		errs     map[string]error
+	}{
+		{"primary fails", []string{"0.8.19", "0.8.24"}, map[string]error{"0.8.19": errors.New("ParserError")}},
+		{"primary unavailable", []string{"0.8.19", "0.8.24"}, map[string]error{"0.8.19": ErrUnavailable}},
+		{"no versions", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Check(context.Background(), &stubCompiler{errs: tt.errs}, tt.versions, "/data", []string{"verifier.sol"})
+			if report.PrimaryPassed() {
+				t.Fatalf("PrimaryPassed() = true for %+v", report)
+			}
+		})
+	}
+}
+
+func TestReportRoundTrip(t *testing.T) {
+	report := Check(context.Background(), &stubCompiler{output: map[string]string{"0.8.19": "6080"}}, []string{"0.8.19"}, "/data", nil)
+	path := filepath.Join(t.TempDir(), "solc_report.json")
+	if err := report.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CheckedAt.Equal(report.CheckedAt) || !reflect.DeepEqual(got.Results, report.Results) {
+		t.Fatalf("ReadReport() = %+v, want %+v", got, report)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadReport(path); err == nil {
+		t.Fatal("ReadReport() accepted an invalid report")
+	}
+}
+
+func TestParseVersions(t *testing.T) {
+	if got := ParseVersions(" 0.8.19, 0.8.24,,"); !reflect.DeepEqual(got, []string{"0.8.19", "0.8.24"}) {
+		t.Fatalf("ParseVersions() = %v", got)
+	}
+	if got := ParseVersions(""); got != nil {
+		t.Fatalf("ParseVersions(\"\") = %v, want nil", got)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	// fake-solc-<version> prints its arguments, or fails for 0.8.25.
+	script := "#!/bin/sh\nif [ \"$(basename \"$0\")\" = fake-solc-0.8.25 ]; then echo 'Stack too deep' >&2; exit 1; fi\necho \"$@\"\n"
+	for _, version := range []string{"0.8.19", "0.8.25"} {
+		if err := os.WriteFile(filepath.Join(dir, "fake-solc-"+version), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	compiler := Command{Template: filepath.Join(dir, "fake-solc-{version}")}
+	ctx := context.Background()
+
+	out, err := compiler.Compile(ctx, "0.8.19", dir, []string{"verifier.sol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "--optimize --bin verifier.sol" {
+		t.Fatalf("solc was run with %q", got)
+	}
+	if _, err := compiler.Compile(ctx, "0.8.25", dir, nil); err == nil || errors.Is(err, ErrUnavailable) || !strings.Contains(err.Error(), "Stack too deep") {
+		t.Fatalf("Compile() of a failing version = %v, want the compiler error", err)
+	}
+	if _, err := compiler.Compile(ctx, "0.8.30", dir, nil); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Compile() of a missing version = %v, want ErrUnavailable", err)
+	}
+	if _, err := (Command{}).Compile(ctx, "0.8.19", dir, nil); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Compile() with an empty template = %v, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,313 @@
+package trusted_setup
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/consensys/gnark-ignition-verifier/ignition"
+)
+
+const (
+	defaultDownloadWorkers = 4
+	// maxTranscripts bounds the transcripts per participant; the ignition
+	// verifier rejects contributions with more than 30.
+	maxTranscripts = 32
+	// checksumsFile records the SHA-256 of every verified chunk in the cache
+	// directory, in the same format LoadExpectedSHA256 reads.
+	checksumsFile = "sha256.json"
+)
+
+// DownloadConfig controls how the ceremony transcripts are fetched.
+type DownloadConfig struct {
+	// WorkerCount is the number of chunks downloaded in parallel. Defaults
+	// to 4.
+	WorkerCount int
+	// ExpectedSHA256 maps chunk indexes (see ChunkIndex) to the hex SHA-256
+	// of the transcript file. Chunks without an entry are checked against the
+	// checksums recorded when they were first downloaded, if any.
+	ExpectedSHA256 map[int]string
+	// ResumeFromByte controls how partially downloaded chunks are resumed.
+	// Zero resumes from the end of the partial file, a positive value
+	// truncates the partial file to that offset first (in case its tail is
+	// suspect) and a negative value discards partial files.
+	ResumeFromByte int64
+}
+
+// ErrChecksumMismatch is returned when a downloaded chunk does not have the
+// expected SHA-256. The chunk is deleted so that the next run downloads it
+// again.
+type ErrChecksumMismatch struct {
+	Chunk    int
+	File     string
+	Expected string
+	Actual   string
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("checksum mismatch for chunk %d (%s): expected %s, got %s", e.Chunk, e.File, e.Expected, e.Actual)
+}
+
+// ChunkIndex identifies transcript file transcript of the participant at
+// position in the ceremony.
+func ChunkIndex(position, transcript int) int {
+	return position*maxTranscripts + transcript
+}
+
+// LoadExpectedSHA256 reads a checksum manifest, a JSON object mapping chunk
+// indexes to hex SHA-256 digests.
+func LoadExpectedSHA256(path string) (map[int]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("invalid checksum manifest %s: %w", path, err)
+	}
+	sums := make(map[int]string, len(raw))
+	for k, v := range raw {
+		chunk, err := strconv.Atoi(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk index %q in %s", k, path)
+		}
+		sums[chunk] = strings.ToLower(v)
+	}
+	return sums, nil
+}
+
+func writeChecksums(path string, sums map[int]string) error {
+	chunks := make([]int, 0, len(sums))
+	for chunk := range sums {
+		chunks = append(chunks, chunk)
+	}
+	sort.Ints(chunks)
+	raw := make(map[string]string, len(sums))
+	for _, chunk := range chunks {
+		raw[strconv.Itoa(chunk)] = sums[chunk]
+	}
+	b, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+type chunk struct {
+	index      int
+	file       string
+	transcript int
+}
+
+// downloadTranscripts fetches the transcripts of every participant from
+// startIdx into the ignition cache directory, verifying each one, so that
+// ignition reads them from disk instead of downloading them again.
+func downloadTranscripts(config ignition.Config, manifest ignition.Manifest, startIdx int, cfg DownloadConfig) error {
+	if manifest.PointsPerTranscript <= 0 {
+		return errors.New("manifest has no pointsPerTranscript")
+	}
+	transcripts := (manifest.NumG1Points + manifest.PointsPerTranscript - 1) / manifest.PointsPerTranscript
+	if transcripts > maxTranscripts {
+		return fmt.Errorf("manifest declares %d transcripts per participant", transcripts)
+	}
+	baseURL, err := url.JoinPath(config.BaseURL, config.Ceremony)
+	if err != nil {
+		return err
+	}
+	cacheDir := filepath.Join(config.CacheDir, config.Ceremony)
+
+	recorded := map[int]string{}
+	if sums, err := LoadExpectedSHA256(filepath.Join(cacheDir, checksumsFile)); err == nil {
+		recorded = sums
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var chunks []chunk
+	for _, p := range manifest.Participants[startIdx:] {
+		addr := strings.ToLower(p.Address)
+		for i := 0; i < transcripts; i++ {
+			chunks = append(chunks, chunk{
+				index:      ChunkIndex(p.Position, i),
+				file:       fmt.Sprintf("%03d_%s/transcript%02d.dat", p.Position, addr, i),
+				transcript: i,
+			})
+		}
+	}
+
+	workers := cfg.WorkerCount
+	if workers <= 0 {
+		workers = defaultDownloadWorkers
+	}
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	work := make(chan chunk)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				mu.Lock()
+				expected, ok := cfg.ExpectedSHA256[c.index]
+				if !ok {
+					expected = recorded[c.index]
+				}
+				failed := firstErr != nil
+				mu.Unlock()
+				if failed {
+					continue
+				}
+				sum, err := fetchChunk(baseURL, cacheDir, c, expected, transcripts, cfg.ResumeFromByte)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				} else if err == nil {
+					recorded[c.index] = sum
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, c := range chunks {
+		work <- c
+	}
+	close(work)
+	wg.Wait()
+
+	if err := writeChecksums(filepath.Join(cacheDir, checksumsFile), recorded); err != nil {
+		log.Println("failed to record chunk checksums:", err)
+	}
+	return firstErr
+}
+
+// fetchChunk makes sure the chunk is present in the cache and matches
+// expected, if given, and returns its SHA-256. Downloads go to a .part file
+// that is only renamed into place once complete and verified.
+func fetchChunk(baseURL, cacheDir string, c chunk, expected string, transcripts int, resumeFrom int64) (string, error) {
+	path := filepath.Join(cacheDir, c.file)
+	if _, err := os.Stat(path); err == nil {
+		sum, err := verifyChunk(path, c, expected, transcripts)
+		if err == nil {
+			return sum, nil
+		}
+		log.Printf("cached chunk %d is invalid, downloading it again: %v\n", c.index, err)
+		if err := os.Remove(path); err != nil {
+			return "", err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	partPath := path + ".part"
+	offset := int64(0)
+	if info, err := os.Stat(partPath); err == nil && resumeFrom >= 0 {
+		offset = info.Size()
+		if resumeFrom > 0 && resumeFrom < offset {
+			offset = resumeFrom
+		}
+	}
+	if err := downloadChunk(baseURL+"/"+c.file, partPath, offset); err != nil {
+		return "", fmt.Errorf("failed to download chunk %d (%s): %w", c.index, c.file, err)
+	}
+	sum, err := verifyChunk(partPath, c, expected, transcripts)
+	if err != nil {
+		os.Remove(partPath)
+		return "", err
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		return "", err
+	}
+	return sum, nil
+}
+
+func downloadChunk(fileURL, partPath string, offset int64) error {
+	req, err := http.NewRequest(http.MethodGet, fileURL, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		log.Printf("resume %s from byte %d\n", fileURL, offset)
+	} else {
+		log.Println("download", fileURL)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusPartialContent && offset > 0:
+	case res.StatusCode == http.StatusOK:
+		// The server ignored the range, start over.
+		offset = 0
+	case res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is already complete.
+		return nil
+	default:
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, res.Body); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// verifyChunk hashes the chunk at path and checks it against expected. For
+// the first transcript of a participant it also checks that the transcript
+// count in its header matches the manifest.
+func verifyChunk(path string, c chunk, expected string, transcripts int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	var header [8]byte
+	if _, err := io.ReadFull(io.TeeReader(f, h), header[:]); err != nil {
+		return "", fmt.Errorf("chunk %d (%s) is truncated: %w", c.index, c.file, err)
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if expected != "" && sum != expected {
+		return "", &ErrChecksumMismatch{Chunk: c.index, File: c.file, Expected: expected, Actual: sum}
+	}
+	if c.transcript == 0 {
+		if total := int(binary.BigEndian.Uint32(header[4:8])); total != transcripts {
+			return "", fmt.Errorf("chunk %d (%s) declares %d transcripts, manifest implies %d", c.index, c.file, total, transcripts)
+		}
+	}
+	return sum, nil
+}
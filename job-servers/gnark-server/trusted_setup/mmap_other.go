@@ -0,0 +1,25 @@
+//go:build !unix
+
+package trusted_setup
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/kzg"
+)
+
+// OpenMapped falls back to buffered file reads on platforms without mmap.
+func OpenMapped(path string) (kzg.SRS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	srs := kzg.NewSRS(ecc.BN254)
+	if _, err := srs.ReadFrom(f); err != nil {
+		return nil, fmt.Errorf("failed to read srs from %s: %w", path, err)
+	}
+	return srs, nil
+}
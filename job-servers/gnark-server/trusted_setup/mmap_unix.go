@@ -0,0 +1,45 @@
+//go:build unix
+
+package trusted_setup
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/kzg"
+	"golang.org/x/sys/unix"
+)
+
+// OpenMapped reads an SRS file through a read-only memory mapping instead of
+// buffered file reads. Pages are faulted in as the decoder walks the file and
+// belong to the page cache, so they can be reclaimed under memory pressure
+// and are released when the mapping is removed once decoding finishes.
+func OpenMapped(path string) (kzg.SRS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, fmt.Errorf("srs file %s is empty", path)
+	}
+	mapped, err := unix.Mmap(int(f.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map %s: %w", path, err)
+	}
+	defer unix.Munmap(mapped)
+	// The decoder reads the file front to back exactly once.
+	_ = unix.Madvise(mapped, unix.MADV_SEQUENTIAL)
+
+	srs := kzg.NewSRS(ecc.BN254)
+	if _, err := srs.ReadFrom(bytes.NewReader(mapped)); err != nil {
+		return nil, fmt.Errorf("failed to read srs from %s: %w", path, err)
+	}
+	return srs, nil
+}
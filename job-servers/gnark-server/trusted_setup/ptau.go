@@ -0,0 +1,225 @@
+package trusted_setup
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	kzg_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	"github.com/consensys/gnark-crypto/kzg"
+)
+
+// Layout of the snarkjs/Hermez powers of tau (.ptau) file, see
+// https://github.com/iden3/snarkjs/blob/master/src/powersoftau_new.js
+const (
+	ptauMagic   = "ptau"
+	ptauVersion = 1
+
+	ptauSectionHeader = 1
+	ptauSectionTauG1  = 2
+	ptauSectionTauG2  = 3
+
+	ptauFieldSize = fp.Bytes
+)
+
+type ptauSection struct {
+	offset int64
+	size   int64
+}
+
+// LoadPtau reads the powers of tau of a bn254 .ptau file produced by snarkjs
+// or the Hermez ceremony and returns an SRS with maxDegree+1 G1 powers, the
+// layout gnark's plonk.Setup expects.
+func LoadPtau(path string, maxDegree int) (kzg.SRS, error) {
+	if maxDegree < 1 {
+		return nil, fmt.Errorf("maxDegree must be at least 1, got %d", maxDegree)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections, err := readPtauSections(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ptau file %s: %w", path, err)
+	}
+	power, err := readPtauHeader(f, sections[ptauSectionHeader])
+	if err != nil {
+		return nil, fmt.Errorf("invalid ptau file %s: %w", path, err)
+	}
+	// A ceremony of power p holds 2^p·2-1 G1 powers and 2^p G2 powers.
+	if available := int64(1)<<power*2 - 1; int64(maxDegree)+1 > available {
+		return nil, fmt.Errorf("ptau file %s has %d G1 powers, %d are needed", path, available, maxDegree+1)
+	}
+
+	var srs kzg_bn254.SRS
+	srs.Pk.G1 = make([]bn254.G1Affine, maxDegree+1)
+	if err := readPtauPoints(f, sections[ptauSectionTauG1], len(srs.Pk.G1), 2*ptauFieldSize, func(i int, b []byte) {
+		readMontgomeryLE(&srs.Pk.G1[i].X, b[:ptauFieldSize])
+		readMontgomeryLE(&srs.Pk.G1[i].Y, b[ptauFieldSize:])
+	}); err != nil {
+		return nil, fmt.Errorf("invalid tauG1 section in %s: %w", path, err)
+	}
+	if err := readPtauPoints(f, sections[ptauSectionTauG2], 2, 4*ptauFieldSize, func(i int, b []byte) {
+		p := &srs.Vk.G2[i]
+		readMontgomeryLE(&p.X.A0, b[:ptauFieldSize])
+		readMontgomeryLE(&p.X.A1, b[ptauFieldSize:2*ptauFieldSize])
+		readMontgomeryLE(&p.Y.A0, b[2*ptauFieldSize:3*ptauFieldSize])
+		readMontgomeryLE(&p.Y.A1, b[3*ptauFieldSize:])
+	}); err != nil {
+		return nil, fmt.Errorf("invalid tauG2 section in %s: %w", path, err)
+	}
+	srs.Vk.G1 = srs.Pk.G1[0]
+
+	if err := checkPtauSRS(&srs); err != nil {
+		return nil, fmt.Errorf("invalid powers of tau in %s: %w", path, err)
+	}
+	return &srs, nil
+}
+
+// readPtauSections checks the file header and returns the position of every
+// section in the file.
+func readPtauSections(f *os.File) (map[uint32]ptauSection, error) {
+	var header struct {
+		Magic     [4]byte
+		Version   uint32
+		NSections uint32
+	}
+	if err := binary.Read(f, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if string(header.Magic[:]) != ptauMagic {
+		return nil, errors.New("not a ptau file")
+	}
+	if header.Version != ptauVersion {
+		return nil, fmt.Errorf("unsupported ptau version %d", header.Version)
+	}
+	sections := make(map[uint32]ptauSection, header.NSections)
+	for i := uint32(0); i < header.NSections; i++ {
+		var sectionHeader struct {
+			Type uint32
+			Size uint64
+		}
+		if err := binary.Read(f, binary.LittleEndian, &sectionHeader); err != nil {
+			return nil, err
+		}
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := sections[sectionHeader.Type]; ok {
+			return nil, fmt.Errorf("duplicate section %d", sectionHeader.Type)
+		}
+		sections[sectionHeader.Type] = ptauSection{offset: offset, size: int64(sectionHeader.Size)}
+		if _, err := f.Seek(int64(sectionHeader.Size), io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range []uint32{ptauSectionHeader, ptauSectionTauG1, ptauSectionTauG2} {
+		if _, ok := sections[t]; !ok {
+			return nil, fmt.Errorf("missing section %d", t)
+		}
+	}
+	return sections, nil
+}
+
+// readPtauHeader checks that the file is for the bn254 base field and
+// returns the power of the ceremony.
+func readPtauHeader(f *os.File, section ptauSection) (uint32, error) {
+	if _, err := f.Seek(section.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var n8 uint32
+	if err := binary.Read(f, binary.LittleEndian, &n8); err != nil {
+		return 0, err
+	}
+	if n8 != ptauFieldSize {
+		return 0, fmt.Errorf("field elements are %d bytes, expected %d", n8, ptauFieldSize)
+	}
+	q := make([]byte, n8)
+	if _, err := io.ReadFull(f, q); err != nil {
+		return 0, err
+	}
+	for i, j := 0, len(q)-1; i < j; i, j = i+1, j-1 {
+		q[i], q[j] = q[j], q[i]
+	}
+	if new(big.Int).SetBytes(q).Cmp(fp.Modulus()) != 0 {
+		return 0, errors.New("ptau file is not for the bn254 curve")
+	}
+	var power uint32
+	if err := binary.Read(f, binary.LittleEndian, &power); err != nil {
+		return 0, err
+	}
+	if power == 0 || power > 28 {
+		return 0, fmt.Errorf("invalid power %d", power)
+	}
+	return power, nil
+}
+
+func readPtauPoints(f *os.File, section ptauSection, count int, pointSize int, decode func(i int, b []byte)) error {
+	if int64(count)*int64(pointSize) > section.size {
+		return fmt.Errorf("section holds %d bytes, %d points need %d", section.size, count, count*pointSize)
+	}
+	if _, err := f.Seek(section.offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReaderSize(f, 1<<20)
+	b := make([]byte, pointSize)
+	for i := 0; i < count; i++ {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		decode(i, b)
+	}
+	return nil
+}
+
+// readMontgomeryLE decodes a field element stored by snarkjs, which writes
+// the Montgomery form as little-endian 64-bit limbs, the same representation
+// fp.Element uses in memory.
+func readMontgomeryLE(z *fp.Element, b []byte) {
+	for i := range z {
+		z[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+}
+
+// checkPtauSRS checks that the points are valid and that G1 and G2 were
+// generated from the same τ.
+func checkPtauSRS(srs *kzg_bn254.SRS) error {
+	_, _, g1gen, g2gen := bn254.Generators()
+	if !srs.Pk.G1[0].Equal(&g1gen) {
+		return errors.New("first G1 power is not the generator")
+	}
+	if !srs.Vk.G2[0].Equal(&g2gen) {
+		return errors.New("first G2 power is not the generator")
+	}
+	for i := range srs.Pk.G1 {
+		if !srs.Pk.G1[i].IsOnCurve() {
+			return fmt.Errorf("G1 power %d is not on the curve", i)
+		}
+	}
+	if !srs.Vk.G2[1].IsInSubGroup() {
+		return errors.New("τ·G2 is not in the subgroup")
+	}
+	// e(τ·G1, G2) == e(G1, τ·G2)
+	var negG1 bn254.G1Affine
+	negG1.Neg(&srs.Pk.G1[0])
+	ok, err := bn254.PairingCheck(
+		[]bn254.G1Affine{srs.Pk.G1[1], negG1},
+		[]bn254.G2Affine{srs.Vk.G2[0], srs.Vk.G2[1]},
+	)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("G1 and G2 powers do not share τ")
+	}
+	return nil
+}
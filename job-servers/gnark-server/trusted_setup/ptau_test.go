@@ -0,0 +1,149 @@
+package trusted_setup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	kzg_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+)
+
+// ptauOptions changes the .ptau file written by writePtau.
+type ptauOptions struct {
+	magic   string
+	version uint32
+	// g2Tau is the τ of the G2 powers, τ if zero.
+	g2Tau int64
+}
+
+// writePtau writes a .ptau file of the given power in the layout snarkjs
+// writes, with the powers of tau, and returns its path.
+func writePtau(t *testing.T, power uint32, tau int64, opts ptauOptions) string {
+	t.Helper()
+	if opts.magic == "" {
+		opts.magic = ptauMagic
+	}
+	if opts.version == 0 {
+		opts.version = ptauVersion
+	}
+	if opts.g2Tau == 0 {
+		opts.g2Tau = tau
+	}
+	_, _, g1, g2 := bn254.Generators()
+	element := func(buf *bytes.Buffer, z fp.Element) {
+		for _, limb := range z {
+			binary.Write(buf, binary.LittleEndian, limb)
+		}
+	}
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.LittleEndian, uint32(ptauFieldSize))
+	q := fp.Modulus().FillBytes(make([]byte, ptauFieldSize))
+	for i := len(q) - 1; i >= 0; i-- {
+		header.WriteByte(q[i])
+	}
+	binary.Write(&header, binary.LittleEndian, power)
+	binary.Write(&header, binary.LittleEndian, uint32(0))
+
+	var tauG1, tauG2 bytes.Buffer
+	scalar := big.NewInt(1)
+	for i := 0; i < 1<<power*2-1; i++ {
+		var p bn254.G1Affine
+		p.ScalarMultiplication(&g1, scalar)
+		element(&tauG1, p.X)
+		element(&tauG1, p.Y)
+		scalar.Mul(scalar, big.NewInt(tau))
+	}
+	scalar = big.NewInt(1)
+	for i := 0; i < 1<<power; i++ {
+		var p bn254.G2Affine
+		p.ScalarMultiplication(&g2, scalar)
+		element(&tauG2, p.X.A0)
+		element(&tauG2, p.X.A1)
+		element(&tauG2, p.Y.A0)
+		element(&tauG2, p.Y.A1)
+		scalar.Mul(scalar, big.NewInt(opts.g2Tau))
+	}
+
+	var file bytes.Buffer
+	file.WriteString(opts.magic)
+	binary.Write(&file, binary.LittleEndian, opts.version)
+	binary.Write(&file, binary.LittleEndian, uint32(3))
+	for i, section := range []*bytes.Buffer{&header, &tauG1, &tauG2} {
+		binary.Write(&file, binary.LittleEndian, uint32(i+1))
+		binary.Write(&file, binary.LittleEndian, uint64(section.Len()))
+		file.Write(section.Bytes())
+	}
+	path := filepath.Join(t.TempDir(), "test.ptau")
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPtau(t *testing.T) {
+	const tau = 42
+	path := writePtau(t, 2, tau, ptauOptions{})
+	want, err := kzg_bn254.NewSRS(7, big.NewInt(tau))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, maxDegree := range []int{1, 6} {
+		srs, err := LoadPtau(path, maxDegree)
+		if err != nil {
+			t.Fatalf("LoadPtau(%d) = %v", maxDegree, err)
+		}
+		got := srs.(*kzg_bn254.SRS)
+		if len(got.Pk.G1) != maxDegree+1 {
+			t.Fatalf("LoadPtau(%d) has %d G1 powers, want %d", maxDegree, len(got.Pk.G1), maxDegree+1)
+		}
+		for i := range got.Pk.G1 {
+			if !got.Pk.G1[i].Equal(&want.Pk.G1[i]) {
+				t.Fatalf("LoadPtau(%d) G1 power %d differs from the SRS of τ", maxDegree, i)
+			}
+		}
+		if got.Vk.G2 != want.Vk.G2 || !got.Vk.G1.Equal(&want.Vk.G1) {
+			t.Fatalf("LoadPtau(%d) verifying key differs from the SRS of τ", maxDegree)
+		}
+	}
+}
+
+func TestLoadPtauInvalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      func(t *testing.T) string
+		maxDegree int
+		want      string
+	}{
+		{"too few powers", func(t *testing.T) string { return writePtau(t, 2, 42, ptauOptions{}) }, 7, "has 7 G1 powers, 8 are needed"},
+		{"zero degree", func(t *testing.T) string { return writePtau(t, 2, 42, ptauOptions{}) }, 0, "maxDegree must be at least 1"},
+		{"not a ptau file", func(t *testing.T) string { return writePtau(t, 2, 42, ptauOptions{magic: "zkey"}) }, 1, "not a ptau file"},
+		{"unknown version", func(t *testing.T) string { return writePtau(t, 2, 42, ptauOptions{version: 2}) }, 1, "unsupported ptau version 2"},
+		{"G2 of another tau", func(t *testing.T) string { return writePtau(t, 2, 42, ptauOptions{g2Tau: 43}) }, 1, "do not share τ"},
+		{"truncated", func(t *testing.T) string {
+			path := writePtau(t, 2, 42, ptauOptions{})
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, raw[:100], 0644); err != nil {
+				t.Fatal(err)
+			}
+			return path
+		}, 1, "invalid ptau file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadPtau(tt.path(t), tt.maxDegree); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("LoadPtau() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
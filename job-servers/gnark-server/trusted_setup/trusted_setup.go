@@ -1,6 +1,7 @@
 package trusted_setup
 
 import (
+	"fmt"
 	"log"
 	"os"
 
@@ -62,7 +63,9 @@ func eval(p []fr.Element, point fr.Element) fr.Element {
 	return res
 }
 
-func DownloadAndSaveAztecIgnitionSrs(startIdx int, fileName string) {
+// DownloadAndSaveAztecIgnitionSrs downloads and verifies the Aztec Ignition
+// contributions from startIdx on and writes the resulting SRS to fileName.
+func DownloadAndSaveAztecIgnitionSrs(startIdx int, fileName string, downloadConfig DownloadConfig) error {
 	config := ignition.Config{
 		BaseURL:  "https://aztec-ignition.s3.amazonaws.com/",
 		Ceremony: "MAIN IGNITION", // "TINY_TEST_5"
@@ -73,8 +76,7 @@ func DownloadAndSaveAztecIgnitionSrs(startIdx int, fileName string) {
 		err := os.MkdirAll(config.CacheDir, os.ModePerm)
 
 		if err != nil {
-			log.Fatal("when creating cache dir: ", err)
-			panic(err)
+			return fmt.Errorf("when creating cache dir: %w", err)
 		}
 	}
 
@@ -83,29 +85,33 @@ func DownloadAndSaveAztecIgnitionSrs(startIdx int, fileName string) {
 	manifest, err := ignition.NewManifest(config)
 
 	if err != nil {
-		log.Fatal("when fetching manifest: ", err)
+		return fmt.Errorf("when fetching manifest: %w", err)
+	}
+
+	if err := downloadTranscripts(config, manifest, startIdx, downloadConfig); err != nil {
+		return err
 	}
 
 	current, next := ignition.NewContribution(manifest.NumG1Points), ignition.NewContribution(manifest.NumG1Points)
 
 	if err := current.Get(manifest.Participants[startIdx], config); err != nil {
-		log.Fatal("when fetching contribution: ", err)
+		return fmt.Errorf("when fetching contribution: %w", err)
 	}
 	if err := next.Get(manifest.Participants[startIdx+1], config); err != nil {
-		log.Fatal("when fetching contribution: ", err)
+		return fmt.Errorf("when fetching contribution: %w", err)
 	}
 	if !next.Follows(&current) {
-		log.Fatalf("contribution %d does not follow contribution %d", startIdx+1, startIdx)
+		return fmt.Errorf("contribution %d does not follow contribution %d", startIdx+1, startIdx)
 	}
 
 	for i := startIdx + 2; i < len(manifest.Participants); i++ {
 		log.Println("processing contribution ", i+1)
 		current, next = next, current
 		if err := next.Get(manifest.Participants[i], config); err != nil {
-			log.Fatal("when fetching contribution ", i+1, ": ", err)
+			return fmt.Errorf("when fetching contribution %d: %w", i+1, err)
 		}
 		if !next.Follows(&current) {
-			log.Fatal("contribution ", i+1, " does not follow contribution ", i, ": ", err)
+			return fmt.Errorf("contribution %d does not follow contribution %d", i+1, i)
 		}
 	}
 
@@ -132,14 +138,13 @@ func DownloadAndSaveAztecIgnitionSrs(startIdx int, fileName string) {
 
 	fSRS, err := os.Create(fileName)
 	if err != nil {
-		log.Fatal("error creating srs file: ", err)
-		panic(err)
+		return fmt.Errorf("error creating srs file: %w", err)
 	}
 	defer fSRS.Close()
 
 	_, err = srs.WriteTo(fSRS)
 	if err != nil {
-		log.Fatal("error writing srs file: ", err)
-		panic(err)
+		return fmt.Errorf("error writing srs file: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// SolidityUint256 encodes a decimal public input as the 32-byte big-endian
+// word the Solidity verifiers take as a uint256.
+func SolidityUint256(decimal string) ([]byte, error) {
+	v, ok := new(big.Int).SetString(decimal, 10)
+	if !ok || v.Sign() < 0 || v.BitLen() > 256 {
+		return nil, fmt.Errorf("invalid public input %q", decimal)
+	}
+	return v.FillBytes(make([]byte, 32)), nil
+}
+
+// SolidityPublicInputs encodes decimal public inputs as 0x-prefixed 32-byte
+// hex words, in the order the verifier takes them.
+func SolidityPublicInputs(publicInputs []string) ([]string, error) {
+	words := make([]string, len(publicInputs))
+	for i, input := range publicInputs {
+		word, err := SolidityUint256(input)
+		if err != nil {
+			return nil, err
+		}
+		words[i] = "0x" + hex.EncodeToString(word)
+	}
+	return words, nil
+}
+
+// SolidityProof decodes a proof stored as hex. Proofs are stored in the
+// layout of gnark's MarshalSolidity, which is exactly the bytes argument of
+// the exported PLONK verifier, so no re-serialization is needed.
+func SolidityProof(proofHex string) ([]byte, error) {
+	proof, err := hex.DecodeString(strings.TrimPrefix(proofHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof hex: %w", err)
+	}
+	return proof, nil
+}
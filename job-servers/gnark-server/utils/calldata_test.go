@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/plonk"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	"github.com/consensys/gnark/test"
+)
+
+// squareCircuit has the public inputs of the verifier circuit, with
+// InputHash the square of a secret.
+type squareCircuit struct {
+	VerifierDigest frontend.Variable `gnark:",public"`
+	InputHash      frontend.Variable `gnark:",public"`
+	Secret         frontend.Variable
+}
+
+func (c *squareCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.Secret, c.Secret), c.InputHash)
+	api.AssertIsDifferent(c.VerifierDigest, 0)
+	return nil
+}
+
+func TestSolidityCalldataRoundTrip(t *testing.T) {
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), scs.NewBuilder, &squareCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srs, err := test.NewKZGSRS(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := plonk.Setup(ccs, srs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifierDigest, _ := new(big.Int).SetString("10639849666975086414110868463771120369189468607622759510754735453420311446140", 10)
+	secret := big.NewInt(1<<32 - 1)
+	witness, err := frontend.NewWitness(&squareCircuit{
+		VerifierDigest: verifierDigest,
+		InputHash:      new(big.Int).Mul(secret, secret),
+		Secret:         secret,
+	}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := plonk.Prove(ccs, pk, witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicInputs, err := ExtractPublicInputs(witness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decimals := make([]string, len(publicInputs))
+	for i, input := range publicInputs {
+		decimals[i] = input.String()
+	}
+	// The proof as stored by the server.
+	stored := hex.EncodeToString(proof.(*plonk_bn254.Proof).MarshalSolidity())
+
+	proofBytes, err := SolidityProof("0x" + stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words, err := SolidityPublicInputs(decimals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(words) != 2 {
+		t.Fatalf("SolidityPublicInputs() = %v, want verifierDigest and inputHash", words)
+	}
+
+	// Decode the calldata as the Solidity verifier would and check it with
+	// plonk.Verify.
+	decoded := plonk_bn254.UnmarshalSolidity(proofBytes, 0)
+	assignment := &squareCircuit{}
+	for i, word := range words {
+		if !strings.HasPrefix(word, "0x") || len(word) != 2+64 {
+			t.Fatalf("public input word %q is not a 0x-prefixed 32-byte word", word)
+		}
+		v, ok := new(big.Int).SetString(word[2:], 16)
+		if !ok {
+			t.Fatalf("public input word %q is not hex", word)
+		}
+		if i == 0 {
+			assignment.VerifierDigest = v
+		} else {
+			assignment.InputHash = v
+		}
+	}
+	publicWitness, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plonk.Verify(&decoded, vk, publicWitness); err != nil {
+		t.Fatalf("plonk.Verify() of the calldata = %v", err)
+	}
+
+	// A different inputHash must not verify.
+	assignment.InputHash = new(big.Int).Add(assignment.InputHash.(*big.Int), big.NewInt(1))
+	publicWitness, err = frontend.NewWitness(assignment, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plonk.Verify(&decoded, vk, publicWitness); err == nil {
+		t.Fatal("plonk.Verify() accepted the calldata with another inputHash")
+	}
+}
+
+func TestSolidityUint256(t *testing.T) {
+	tests := []struct {
+		decimal string
+		want    string
+		wantErr bool
+	}{
+		{"0", strings.Repeat("0", 64), false},
+		{"255", strings.Repeat("0", 62) + "ff", false},
+		{new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1)).String(), strings.Repeat("f", 64), false},
+		{new(big.Int).Lsh(big.NewInt(1), 256).String(), "", true},
+		{"-1", "", true},
+		{"0x10", "", true},
+	}
+	for _, tt := range tests {
+		got, err := SolidityUint256(tt.decimal)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("SolidityUint256(%s) error = %v, wantErr %v", tt.decimal, err, tt.wantErr)
+		}
+		if err == nil && hex.EncodeToString(got) != tt.want {
+			t.Fatalf("SolidityUint256(%s) = %x, want %s", tt.decimal, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// publicInputBits is the width assumed for plonky2 public inputs when a
+// config is derived from the number of inputs alone.
+const publicInputBits = 32
+
+// Errors returned by CalculateDigest, wrapped so that the message stays the
+// same.
+var (
+	ErrPublicInputCount = errors.New("wrong number of public inputs")
+	ErrPublicInputRange = errors.New("public input out of range")
+)
+
+type inputError struct {
+	kind    error
+	message string
+}
+
+func (e *inputError) Error() string { return e.message }
+func (e *inputError) Unwrap() error { return e.kind }
+
+// InputDigestConfig describes how the plonky2 public inputs are packed into
+// the inputHash of the circuit. Input i must fit in Lengths[i] bits, and the
+// inputs are concatenated tightly, most significant first, so that circuits
+// with narrow inputs can have more than eight of them.
+type InputDigestConfig struct {
+	Lengths []uint
+}
+
+// DefaultInputDigestConfig is the config of the current circuit: eight
+// 32-bit inputs where the most significant one is limited to 29 bits so that
+// the digest stays below the BN254 scalar field modulus.
+var DefaultInputDigestConfig = MustCheckInputDigestConfig(InputDigestConfig{
+	Lengths: []uint{29, 32, 32, 32, 32, 32, 32, 32},
+}, ecc.BN254.ScalarField())
+
+// MustCheckInputDigestConfig checks cfg against the target field and panics
+// if its digests can overflow, so that a bad config fails at startup instead
+// of producing digests that wrap around the modulus.
+func MustCheckInputDigestConfig(cfg InputDigestConfig, modulus *big.Int) InputDigestConfig {
+	if err := cfg.Check(modulus); err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// InputDigestConfigFor derives the config of a circuit with numPublicInputs
+// plonky2 public inputs. Inputs are taken to be 32 bits wide, except the
+// most significant one, which is narrowed until every digest is below
+// modulus. For eight inputs over BN254 this is DefaultInputDigestConfig.
+//
+// When the inputs do not fit at 32 bits, which over BN254 is from nine
+// inputs on, the bits below the modulus are shared out evenly instead: every
+// input gets the same width and the most significant one whatever is left,
+// so nine inputs get 29 bits for the first one and 28 for the others. It
+// fails when there are more inputs than bits.
+func InputDigestConfigFor(numPublicInputs int, modulus *big.Int) (InputDigestConfig, error) {
+	if numPublicInputs <= 0 {
+		return InputDigestConfig{}, fmt.Errorf("circuit has %d public inputs", numPublicInputs)
+	}
+	available := modulus.BitLen() - 1
+	if numPublicInputs > available {
+		return InputDigestConfig{}, fmt.Errorf("%d public inputs do not fit in a %d-bit field element, at most %d do",
+			numPublicInputs, available, available)
+	}
+	width := publicInputBits
+	if publicInputBits*(numPublicInputs-1) >= available {
+		width = available / numPublicInputs
+	}
+	first := available - width*(numPublicInputs-1)
+	if first > publicInputBits {
+		first = publicInputBits
+	}
+	lengths := make([]uint, numPublicInputs)
+	lengths[0] = uint(first)
+	for i := 1; i < numPublicInputs; i++ {
+		lengths[i] = uint(width)
+	}
+	return InputDigestConfig{Lengths: lengths}, nil
+}
+
+// Bits returns the width of the digest.
+func (c InputDigestConfig) Bits() uint {
+	var bits uint
+	for _, length := range c.Lengths {
+		bits += length
+	}
+	return bits
+}
+
+// Shift returns the position of the least significant bit of input i in the
+// digest.
+func (c InputDigestConfig) Shift(i int) uint {
+	var shift uint
+	for _, bits := range c.Lengths[i+1:] {
+		shift += bits
+	}
+	return shift
+}
+
+// MaxDigest returns the largest digest of the config, with every input at
+// its maximum.
+func (c InputDigestConfig) MaxDigest() *big.Int {
+	max := new(big.Int).Lsh(big.NewInt(1), c.Bits())
+	return max.Sub(max, big.NewInt(1))
+}
+
+// Headroom returns how far the largest digest is below modulus. It is
+// positive for every config that fits the field.
+func (c InputDigestConfig) Headroom(modulus *big.Int) *big.Int {
+	return new(big.Int).Sub(modulus, c.MaxDigest())
+}
+
+// Check returns an error unless every input fits in a uint64 and every
+// digest is strictly less than modulus.
+func (c InputDigestConfig) Check(modulus *big.Int) error {
+	if len(c.Lengths) == 0 {
+		return fmt.Errorf("input digest config has no inputs")
+	}
+	for i, bits := range c.Lengths {
+		if bits == 0 || bits > 64 {
+			return fmt.Errorf("input digest config: input %d has %d bits, expected 1 to 64", i, bits)
+		}
+	}
+	if c.Headroom(modulus).Sign() <= 0 {
+		return fmt.Errorf("input digest config overflows the field: max digest %s of %d bits is not below modulus %s",
+			c.MaxDigest(), c.Bits(), modulus)
+	}
+	return nil
+}
+
+// CalculateDigest packs publicInputs according to cfg. It fails with
+// ErrPublicInputCount or ErrPublicInputRange for inputs that do not match
+// cfg, and with a plain error for a cfg whose digests can exceed the BN254
+// scalar field.
+func CalculateDigest(publicInputs []uint64, cfg InputDigestConfig) (*big.Int, error) {
+	if err := cfg.Check(ecc.BN254.ScalarField()); err != nil {
+		return nil, err
+	}
+	if err := checkInputs(publicInputs, cfg.Lengths); err != nil {
+		return nil, err
+	}
+	digest := big.NewInt(0)
+	for i, input := range publicInputs {
+		value := new(big.Int).SetUint64(input)
+		digest.Add(digest, value.Lsh(value, cfg.Shift(i)))
+	}
+	return digest, nil
+}
+
+// checkInputs checks the number of public inputs and that input i fits in
+// lengths[i] bits.
+func checkInputs(publicInputs []uint64, lengths []uint) error {
+	if len(publicInputs) != len(lengths) {
+		return &inputError{ErrPublicInputCount, fmt.Sprintf("expected %d public inputs, got %d", len(lengths), len(publicInputs))}
+	}
+	for i, bits := range lengths {
+		if bits >= 64 || publicInputs[i] < uint64(1)<<bits {
+			continue
+		}
+		max := uint64(1)<<bits - 1
+		if i == 0 {
+			return &inputError{ErrPublicInputRange, fmt.Sprintf("first public input exceeds %d bits: %d (max: %d)", bits, publicInputs[i], max)}
+		}
+		return &inputError{ErrPublicInputRange, fmt.Sprintf("public input[%d] exceeds %d bits: %d (max: %d)", i, bits, publicInputs[i], max)}
+	}
+	return nil
+}
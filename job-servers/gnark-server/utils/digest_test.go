@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+func TestDefaultInputDigestConfigHeadroom(t *testing.T) {
+	modulus := ecc.BN254.ScalarField()
+	if bits := DefaultInputDigestConfig.Bits(); bits != 253 {
+		t.Fatalf("Bits() = %d, want 253", bits)
+	}
+	want, _ := new(big.Int).SetString("7414231717174750794300032619171286606889616317210963838766006185586667290626", 10)
+	if got := DefaultInputDigestConfig.Headroom(modulus); got.Cmp(want) != 0 {
+		t.Fatalf("Headroom() = %s, want %s", got, want)
+	}
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 253), big.NewInt(1))
+	if got := DefaultInputDigestConfig.MaxDigest(); got.Cmp(max) != 0 {
+		t.Fatalf("MaxDigest() = %s, want 2^253-1", got)
+	}
+	derived, err := InputDigestConfigFor(8, modulus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived.Check(modulus) != nil || len(derived.Lengths) != 8 || derived.Lengths[0] != 29 || derived.Bits() != 253 {
+		t.Fatalf("InputDigestConfigFor(8) = %v, want the default config", derived.Lengths)
+	}
+}
+
+func TestInputDigestConfigCheck(t *testing.T) {
+	modulus := ecc.BN254.ScalarField()
+	tests := []struct {
+		name    string
+		lengths []uint
+		err     string
+	}{
+		{"default", []uint{29, 32, 32, 32, 32, 32, 32, 32}, ""},
+		{"narrow", []uint{8, 8, 8}, ""},
+		{"empty", nil, "no inputs"},
+		{"zero width", []uint{0, 32}, "input 0 has 0 bits"},
+		{"wider than uint64", []uint{65}, "input 0 has 65 bits"},
+		{"first limb one bit too wide", []uint{30, 32, 32, 32, 32, 32, 32, 32}, "overflows the field"},
+		{"eight full limbs", []uint{32, 32, 32, 32, 32, 32, 32, 32}, "overflows the field"},
+		{"nine limbs", []uint{29, 32, 32, 32, 32, 32, 32, 32, 32}, "overflows the field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := InputDigestConfig{Lengths: tt.lengths}.Check(modulus)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Check() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Check() = %v, want an error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestMustCheckInputDigestConfigPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustCheckInputDigestConfig accepted a config that overflows the field")
+		}
+	}()
+	MustCheckInputDigestConfig(InputDigestConfig{Lengths: []uint{32, 32, 32, 32, 32, 32, 32, 32}}, ecc.BN254.ScalarField())
+}
+
+func TestCalculateDigest(t *testing.T) {
+	inputs := []uint64{1, 2, 3, 4, 5, 6, 7, 8}
+	got, err := CalculateInputDigest(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := new(big.Int)
+	for _, input := range inputs {
+		want.Lsh(want, 32).Add(want, new(big.Int).SetUint64(input))
+	}
+	if got.Cmp(want) != 0 {
+		t.Fatalf("CalculateInputDigest() = %s, want %s", got, want)
+	}
+
+	if _, err := CalculateInputDigest(inputs[:7]); !errors.Is(err, ErrPublicInputCount) {
+		t.Fatalf("7 inputs: err = %v, want ErrPublicInputCount", err)
+	}
+	if _, err := CalculateInputDigest([]uint64{1 << 29, 0, 0, 0, 0, 0, 0, 0}); !errors.Is(err, ErrPublicInputRange) {
+		t.Fatalf("30-bit first input: err = %v, want ErrPublicInputRange", err)
+	}
+	if _, err := CalculateInputDigest([]uint64{0, 1 << 32, 0, 0, 0, 0, 0, 0}); !errors.Is(err, ErrPublicInputRange) {
+		t.Fatalf("33-bit input: err = %v, want ErrPublicInputRange", err)
+	}
+
+	narrow, err := CalculateDigest([]uint64{1, 2, 3}, InputDigestConfig{Lengths: []uint{4, 8, 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if narrow.Int64() != 1<<16|2<<8|3 {
+		t.Fatalf("CalculateDigest() = %s, want %d", narrow, 1<<16|2<<8|3)
+	}
+}
+
+func TestInputDigestConfigFor(t *testing.T) {
+	modulus := ecc.BN254.ScalarField()
+	tests := []struct {
+		inputs  int
+		first   uint
+		others  uint
+		bits    uint
+		wantErr bool
+	}{
+		{inputs: 1, first: 32, bits: 32},
+		{inputs: 8, first: 29, others: 32, bits: 253},
+		{inputs: 9, first: 29, others: 28, bits: 253},
+		{inputs: 253, first: 1, others: 1, bits: 253},
+		{inputs: 0, wantErr: true},
+		{inputs: 254, wantErr: true},
+	}
+	for _, tt := range tests {
+		cfg, err := InputDigestConfigFor(tt.inputs, modulus)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("InputDigestConfigFor(%d) = %v, want an error", tt.inputs, cfg.Lengths)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("InputDigestConfigFor(%d) = %v", tt.inputs, err)
+		}
+		if err := cfg.Check(modulus); err != nil {
+			t.Fatalf("InputDigestConfigFor(%d): Check() = %v", tt.inputs, err)
+		}
+		if len(cfg.Lengths) != tt.inputs || cfg.Lengths[0] != tt.first || cfg.Bits() != tt.bits {
+			t.Fatalf("InputDigestConfigFor(%d) = %v, want %d inputs, the first of %d bits, %d bits in all", tt.inputs, cfg.Lengths, tt.inputs, tt.first, tt.bits)
+		}
+		for i, bits := range cfg.Lengths[1:] {
+			if bits != tt.others {
+				t.Fatalf("InputDigestConfigFor(%d): input %d has %d bits, want %d", tt.inputs, i+1, bits, tt.others)
+			}
+		}
+	}
+
+	cfg, err := InputDigestConfigFor(9, modulus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CalculateDigest([]uint64{1<<29 - 1, 1, 2, 3, 4, 5, 6, 7, 1<<28 - 1}, cfg); err != nil {
+		t.Fatalf("CalculateDigest() of 9 inputs = %v", err)
+	}
+	if _, err := CalculateDigest([]uint64{0, 0, 0, 0, 0, 0, 0, 0, 1 << 28}, cfg); !errors.Is(err, ErrPublicInputRange) {
+		t.Fatalf("29-bit ninth input: err = %v, want ErrPublicInputRange", err)
+	}
+}
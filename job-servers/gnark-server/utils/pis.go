@@ -1,55 +1,63 @@
 package utils
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math/big"
 
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
 	"github.com/consensys/gnark/backend/witness"
 )
 
-func CalculateInputDigest(publicInputs []uint64) (*big.Int, error) {
-	if len(publicInputs) != 8 {
-		return nil, fmt.Errorf("expected 8 public inputs, got %d", len(publicInputs))
-	}
+// witnessHeaderSize is the size of the header gnark writes before the
+// elements of a marshalled witness: the number of public and of secret
+// variables, then the length of the element vector, each a big-endian
+// uint32. The format has no magic number, so parseWitnessBinary checks that
+// the three fields agree with each other and with the data length instead.
+const witnessHeaderSize = 12
 
-	// Validate first element is within 29 bits
-	if publicInputs[0] > (1<<29 - 1) {
-		return nil, fmt.Errorf("first public input exceeds 29 bits: %d (max: %d)",
-			publicInputs[0], 1<<29-1)
-	}
-
-	// Validate remaining elements are within 32 bits
-	for i := 1; i < 8; i++ {
-		if publicInputs[i] > (1<<32 - 1) {
-			return nil, fmt.Errorf("public input[%d] exceeds 32 bits: %d (max: %d)",
-				i, publicInputs[i], 1<<32-1)
-		}
-	}
-
-	inputDigest := big.NewInt(0)
-	for i := 0; i < 8; i++ {
-		value := new(big.Int).SetUint64(publicInputs[7-i])
-		bitPosition := uint(32 * i)
-		value.Lsh(value, bitPosition)
-		inputDigest.Add(inputDigest, value)
-	}
-
-	return inputDigest, nil
+// CalculateInputDigest packs the plonky2 public inputs into the inputHash of
+// the circuit using DefaultInputDigestConfig.
+func CalculateInputDigest(publicInputs []uint64) (*big.Int, error) {
+	return CalculateDigest(publicInputs, DefaultInputDigestConfig)
 }
 
+// ExtractPublicInputs returns the public part of a BN254 witness.
 func ExtractPublicInputs(witness witness.Witness) ([]*big.Int, error) {
 	public, err := witness.Public()
 	if err != nil {
 		return nil, err
 	}
-	_publicBytes, _ := public.MarshalBinary()
-	publicBytes := _publicBytes[12:]
-	const chunkSize = 32
-	bigInts := make([]*big.Int, len(publicBytes)/chunkSize)
-	for i := 0; i < len(publicBytes)/chunkSize; i += 1 {
-		chunk := publicBytes[i*chunkSize : (i+1)*chunkSize]
-		bigInt := new(big.Int).SetBytes(chunk)
-		bigInts[i] = bigInt
+	publicBytes, err := public.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return parseWitnessBinary(publicBytes)
+}
+
+// parseWitnessBinary decodes a public BN254 witness marshalled by gnark. It
+// returns an error rather than misreading the elements if the header does not
+// describe a public witness of exactly the length of data.
+func parseWitnessBinary(data []byte) ([]*big.Int, error) {
+	if len(data) < witnessHeaderSize {
+		return nil, fmt.Errorf("witness is %d bytes, shorter than its %d-byte header", len(data), witnessHeaderSize)
+	}
+	nbPublic := binary.BigEndian.Uint32(data[0:4])
+	nbSecret := binary.BigEndian.Uint32(data[4:8])
+	length := binary.BigEndian.Uint32(data[8:12])
+	if nbSecret != 0 {
+		return nil, fmt.Errorf("witness header has %d secret variables, expected a public witness", nbSecret)
+	}
+	if length != nbPublic {
+		return nil, fmt.Errorf("witness header has %d public variables but %d elements", nbPublic, length)
+	}
+	elements := data[witnessHeaderSize:]
+	if uint64(len(elements)) != uint64(length)*fr.Bytes {
+		return nil, fmt.Errorf("witness has %d bytes of elements, expected %d elements of %d bytes", len(elements), length, fr.Bytes)
+	}
+	bigInts := make([]*big.Int, length)
+	for i := range bigInts {
+		bigInts[i] = new(big.Int).SetBytes(elements[i*fr.Bytes : (i+1)*fr.Bytes])
 	}
 	return bigInts, nil
 }
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark/frontend"
+)
+
+// witnessCircuit has the public inputs of the verifier circuit and a secret
+// input, so that ExtractPublicInputs has to drop it.
+type witnessCircuit struct {
+	VerifierDigest frontend.Variable `gnark:",public"`
+	InputHash      frontend.Variable `gnark:",public"`
+	Secret         frontend.Variable
+}
+
+func (c *witnessCircuit) Define(api frontend.API) error { return nil }
+
+func TestExtractPublicInputs(t *testing.T) {
+	verifierDigest, _ := new(big.Int).SetString("10639849666975086414110868463771120369189468607622759510754735453420311446140", 10)
+	inputHash, err := CalculateInputDigest([]uint64{1<<29 - 1, 2, 3, 4, 5, 6, 7, 1<<32 - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignment := &witnessCircuit{VerifierDigest: verifierDigest, InputHash: inputHash, Secret: 42}
+	w, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pin the header gnark v0.9.1 writes, which parseWitnessBinary relies on.
+	public, err := w.Public()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := public.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := []byte{0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 2}
+	if !bytes.HasPrefix(data, header) || len(data) != witnessHeaderSize+2*fr.Bytes {
+		t.Fatalf("public witness is %d bytes starting with %x, want %d bytes starting with %x",
+			len(data), data[:min(len(data), witnessHeaderSize)], witnessHeaderSize+2*fr.Bytes, header)
+	}
+
+	inputs, err := ExtractPublicInputs(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 || inputs[0].Cmp(verifierDigest) != 0 || inputs[1].Cmp(inputHash) != 0 {
+		t.Fatalf("ExtractPublicInputs() = %v, want [%s %s]", inputs, verifierDigest, inputHash)
+	}
+}
+
+func TestParseWitnessBinary(t *testing.T) {
+	witness := func(nbPublic, nbSecret, length uint32, elements int) []byte {
+		data := make([]byte, witnessHeaderSize+elements*fr.Bytes)
+		binary.BigEndian.PutUint32(data[0:4], nbPublic)
+		binary.BigEndian.PutUint32(data[4:8], nbSecret)
+		binary.BigEndian.PutUint32(data[8:12], length)
+		for i := 0; i < elements; i++ {
+			data[witnessHeaderSize+(i+1)*fr.Bytes-1] = byte(i + 1)
+		}
+		return data
+	}
+
+	inputs, err := parseWitnessBinary(witness(2, 0, 2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 || inputs[0].Int64() != 1 || inputs[1].Int64() != 2 {
+		t.Fatalf("parseWitnessBinary() = %v, want [1 2]", inputs)
+	}
+	if inputs, err := parseWitnessBinary(witness(0, 0, 0, 0)); err != nil || len(inputs) != 0 {
+		t.Fatalf("empty witness: parseWitnessBinary() = %v, %v", inputs, err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"empty", nil, "shorter than its 12-byte header"},
+		{"truncated header", witness(2, 0, 2, 2)[:11], "shorter than its 12-byte header"},
+		{"full witness", witness(2, 1, 3, 3), "1 secret variables"},
+		{"length differs from public count", witness(2, 0, 3, 3), "2 public variables but 3 elements"},
+		{"missing element", witness(2, 0, 2, 1), "expected 2 elements of 32 bytes"},
+		{"truncated element", witness(2, 0, 2, 2)[:witnessHeaderSize+2*fr.Bytes-1], "expected 2 elements of 32 bytes"},
+		{"trailing bytes", append(witness(2, 0, 2, 2), 0), "expected 2 elements of 32 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs, err := parseWitnessBinary(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("parseWitnessBinary() = %v, %v, want an error containing %q", inputs, err, tt.err)
+			}
+		})
+	}
+}
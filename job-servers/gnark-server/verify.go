@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"gnark-server/circuitData"
+	"gnark-server/handlers"
+	"gnark-server/prover"
+)
+
+// runVerify implements `gnark-server verify`, which checks a stored proof
+// against the verifying key without Redis or an HTTP server, for audits in
+// CI. The public witness is derived from a start-proof request body, so the
+// fixtures of the server can be reused. The return value is the exit code,
+// exitProveFailed when the proof does not verify.
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	proofPath := flags.String("proof", "", "proof to verify: the binary proof written by prove, or the hex proof of a get-proof response")
+	witnessPath := flags.String("witness", "", "start-proof request (JSON) whose public inputs the proof is checked against")
+	paths := circuitData.PathsFromEnv()
+	dataDir := flags.String("data-dir", paths.DataDir(), "directory holding the verifying key written by setup, $CIRCUIT_DATA_DIR by default")
+	backend := flags.String("backend", os.Getenv("PROVING_BACKEND"), "proving backend, plonk or groth16 (default plonk, or $PROVING_BACKEND)")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *proofPath == "" || *witnessPath == "" || flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: gnark-server verify --proof <proof> --witness <request.json> [--data-dir <dir>] [--backend plonk|groth16]")
+		return exitUsage
+	}
+	paths = paths.In(*dataDir)
+	if *backend == "" {
+		*backend = circuitData.BackendPlonk
+	}
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	proof, err := readProof(*proofPath)
+	if err != nil {
+		logger.Println("Failed to read proof:", err)
+		return exitInvalidInput
+	}
+	requestJSON, err := os.ReadFile(*witnessPath)
+	if err != nil {
+		logger.Println("Failed to read witness:", err)
+		return exitInvalidInput
+	}
+	var request handlers.ProofRequest
+	if err := json.Unmarshal(requestJSON, &request); err != nil {
+		logger.Printf("Failed to parse %s as a start-proof request: %v\n", *witnessPath, err)
+		return exitInvalidInput
+	}
+	verifierData := []byte(request.VerifierData)
+	if request.VerifierData == "" {
+		// Like prove, fall back to the verifier data setup used.
+		if verifierData, err = circuitData.ReadFile(paths.Path(circuitData.Files{}, circuitData.VerifierOnlyCircuitDataFile)); err != nil {
+			logger.Println("The request has no verifierData and the data directory none either:", err)
+			return exitInvalidInput
+		}
+	}
+	proofRaw, vdRaw, err := prover.ParseInput([]byte(request.Proof), verifierData)
+	if err != nil {
+		logger.Println(err)
+		return exitInvalidInput
+	}
+
+	data, err := circuitData.InitVerifierFromPaths(paths, *backend)
+	if err != nil {
+		logger.Println("Circuit data error:", err)
+		return exitCircuitData
+	}
+	publicWitness, err := prover.PublicWitness(proofRaw, vdRaw, data.InputDigestConfig())
+	if err != nil {
+		logger.Println("Invalid public inputs:", err)
+		return exitInvalidInput
+	}
+	if err := data.Backend.Verify(proof, data.Vk, publicWitness); err != nil {
+		logger.Printf("Proof does not verify against release %s: %v\n", data.ReleaseId, err)
+		return exitProveFailed
+	}
+	logger.Printf("Proof verified against release %s (%s)\n", data.ReleaseId, *backend)
+	return 0
+}
+
+// readProof reads a binary proof, or a hex encoded one as get-proof returns
+// it, with or without a 0x prefix.
+func readProof(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := bytes.TrimPrefix(bytes.TrimSpace(raw), []byte("0x"))
+	if len(text) == 0 {
+		return nil, errors.New("the proof is empty")
+	}
+	if decoded, err := hex.DecodeString(string(text)); err == nil {
+		return decoded, nil
+	}
+	return raw, nil
+}
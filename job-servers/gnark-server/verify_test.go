@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gnark-server/circuitData"
+	"gnark-server/handlers"
+	"gnark-server/prover"
+	"gnark-server/utils"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	groth16_bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// echoCircuit has the public inputs of the verifier circuit and accepts any
+// values for them, so that it can prove the public inputs of testdata.
+type echoCircuit struct {
+	VerifierDigest frontend.Variable `gnark:",public"`
+	InputHash      frontend.Variable `gnark:",public"`
+	Secret         frontend.Variable
+}
+
+func (c *echoCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(c.Secret, c.InputHash)
+	api.AssertIsDifferent(c.VerifierDigest, 0)
+	return nil
+}
+
+// writeVerifyFixture sets up echoCircuit with Groth16 in a data directory
+// holding its verifying key, and proves the public inputs of the start-proof
+// request in testdata with it. It returns the data directory, the request and
+// the hex proof, as get-proof returns it.
+func writeVerifyFixture(t *testing.T) (string, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &echoCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, circuitData.BackendFiles(circuitData.BackendGroth16).VerifyingKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vk.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	common, err := os.ReadFile(filepath.Join(circuitData.DefaultDir, circuitData.CommonCircuitDataFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, circuitData.CommonCircuitDataFile), common, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	proofJSON, err := os.ReadFile("testdata/proof_with_public_inputs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifierData, err := os.ReadFile("testdata/verifier_only_circuit_data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := json.Marshal(handlers.ProofRequest{Proof: string(proofJSON), VerifierData: string(verifierData)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestPath := filepath.Join(dir, "request.json")
+	if err := os.WriteFile(requestPath, request, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := circuitData.InitVerifierFromPaths(circuitData.Paths{Dir: dir}, circuitData.BackendGroth16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofRaw, vdRaw, err := prover.ParseInput(proofJSON, verifierData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicWitness, err := prover.PublicWitness(proofRaw, vdRaw, data.InputDigestConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicInputs, err := utils.ExtractPublicInputs(publicWitness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := frontend.NewWitness(&echoCircuit{
+		VerifierDigest: publicInputs[0],
+		InputHash:      publicInputs[1],
+		Secret:         publicInputs[1],
+	}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &circuitData.Groth16Backend{Pk: *pk.(*groth16_bn254.ProvingKey)}
+	proof, err := backend.Prove(ccs, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, requestPath, "0x" + hex.EncodeToString(proof)
+}
+
+func TestRunVerify(t *testing.T) {
+	clearCircuitEnv(t)
+	dataDir, request, proof := writeVerifyFixture(t)
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	valid := write("proof.hex", proof+"\n")
+	raw, err := hex.DecodeString(proof[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := write("proof.bin", string(raw))
+	raw[len(raw)-1] ^= 1
+	tampered := write("tampered.hex", hex.EncodeToString(raw))
+	empty := write("empty.hex", "")
+	notJSON := write("request.txt", "not a request")
+	groth16Args := []string{"--data-dir", dataDir, "--backend", circuitData.BackendGroth16}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"hex proof", append([]string{"--proof", valid, "--witness", request}, groth16Args...), 0},
+		{"binary proof", append([]string{"--proof", binary, "--witness", request}, groth16Args...), 0},
+		{"tampered proof", append([]string{"--proof", tampered, "--witness", request}, groth16Args...), exitProveFailed},
+		{"no arguments", nil, exitUsage},
+		{"no witness", []string{"--proof", valid}, exitUsage},
+		{"extra argument", append([]string{"--proof", valid, "--witness", request, "extra"}, groth16Args...), exitUsage},
+		{"missing proof", append([]string{"--proof", filepath.Join(dir, "missing"), "--witness", request}, groth16Args...), exitInvalidInput},
+		{"empty proof", append([]string{"--proof", empty, "--witness", request}, groth16Args...), exitInvalidInput},
+		{"invalid witness", append([]string{"--proof", valid, "--witness", notJSON}, groth16Args...), exitInvalidInput},
+		{"no verifying key", []string{"--proof", valid, "--witness", request, "--data-dir", dir, "--backend", circuitData.BackendGroth16}, exitCircuitData},
+		{"other backend", []string{"--proof", valid, "--witness", request, "--data-dir", dataDir, "--backend", circuitData.BackendPlonk}, exitCircuitData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runVerify(tt.args); got != tt.want {
+				t.Fatalf("runVerify(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "runtime/debug"
+
+// commit is set at build time with -ldflags "-X main.commit=<revision>".
+var commit string
+
+// buildCommit returns the revision the binary was built from: commit when it
+// was set, otherwise the VCS revision Go recorded, if any.
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
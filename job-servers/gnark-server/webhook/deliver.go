@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+	deliveryTimeout       = 10 * time.Second
+)
+
+// Attempt records the outcome of one delivery attempt.
+type Attempt struct {
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Deliverer POSTs job results to callback URLs, retrying network errors and
+// 5xx responses with exponential backoff.
+type Deliverer struct {
+	Validator      *Validator
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Deliver sends body to url until it is accepted, a non-retryable response is
+// received or MaxAttempts is reached. record is called after every attempt.
+func (d *Deliverer) Deliver(ctx context.Context, url string, body []byte, record func(Attempt)) error {
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	backoff := d.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultInitialBackoff
+	}
+	maxBackoff := d.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	validator := d.Validator
+	if validator == nil {
+		validator = &Validator{}
+	}
+	client := validator.HTTPClient(deliveryTimeout)
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		statusCode, err := post(ctx, client, url, body)
+		result := Attempt{Attempt: attempt, Time: time.Now().UTC(), StatusCode: statusCode}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		record(result)
+
+		switch {
+		case err == nil && statusCode < 300:
+			return nil
+		case err == nil && statusCode < 500:
+			return fmt.Errorf("callback rejected with status %d", statusCode)
+		case err == nil:
+			lastErr = fmt.Errorf("callback failed with status %d", statusCode)
+		default:
+			lastErr = err
+		}
+
+		if attempt == maxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	return fmt.Errorf("callback failed after %d attempts: %w", maxAttempts, lastErr)
+}
+
+func post(ctx context.Context, client *http.Client, url string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,185 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	CodeInvalidCallbackURL = "invalid_callback_url"
+	CodeSchemeNotAllowed   = "callback_scheme_not_allowed"
+	CodeTargetBlocked      = "callback_target_blocked"
+	CodeProbeFailed        = "callback_probe_failed"
+
+	ProbeSkipped = "skipped"
+	ProbeOK      = "ok"
+
+	defaultProbeTimeout = 3 * time.Second
+)
+
+// ValidationError is returned when a callback URL is rejected. Code is a
+// stable identifier clients can branch on.
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Validator checks callback URLs at submission time so that bad targets are
+// rejected before any proving work is done.
+type Validator struct {
+	// Probe enables a HEAD (falling back to OPTIONS) request against the
+	// target. Set with VALIDATE_CALLBACK=probe.
+	Probe bool
+	// AllowPrivateTargets disables the SSRF address checks. Intended for
+	// local development only.
+	AllowPrivateTargets bool
+	ProbeTimeout        time.Duration
+}
+
+// Validate parses rawURL and enforces the scheme and SSRF rules. When probing
+// is enabled it also checks that the target answers. It returns the probe
+// outcome to record on the job.
+func (v *Validator) Validate(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", &ValidationError{Code: CodeInvalidCallbackURL, Message: fmt.Sprintf("cannot parse callback URL %q", rawURL)}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", &ValidationError{Code: CodeSchemeNotAllowed, Message: fmt.Sprintf("scheme %q is not allowed, use http or https", u.Scheme)}
+	}
+	if u.User != nil {
+		return "", &ValidationError{Code: CodeInvalidCallbackURL, Message: "callback URL must not contain credentials"}
+	}
+	if !v.AllowPrivateTargets {
+		if err := checkHost(ctx, u.Hostname()); err != nil {
+			return "", err
+		}
+	}
+	if !v.Probe {
+		return ProbeSkipped, nil
+	}
+	return v.probe(ctx, u.String())
+}
+
+func (v *Validator) probe(ctx context.Context, target string) (string, error) {
+	timeout := v.ProbeTimeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := v.HTTPClient(timeout)
+	var lastErr error
+	for _, method := range []string{http.MethodHead, http.MethodOptions} {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return "", &ValidationError{Code: CodeInvalidCallbackURL, Message: err.Error()}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			break
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+			lastErr = fmt.Errorf("%s returned %d", method, resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			return "", &ValidationError{Code: CodeProbeFailed, Message: fmt.Sprintf("%s returned %d", method, resp.StatusCode)}
+		}
+		return fmt.Sprintf("%s (%s %d)", ProbeOK, method, resp.StatusCode), nil
+	}
+	return "", &ValidationError{Code: CodeProbeFailed, Message: lastErr.Error()}
+}
+
+// HTTPClient returns a client whose dialer re-checks every resolved address,
+// so redirects and DNS rebinding cannot reach blocked targets.
+func (v *Validator) HTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !v.AllowPrivateTargets {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip != nil && isBlockedIP(ip) {
+				return &ValidationError{Code: CodeTargetBlocked, Message: fmt.Sprintf("address %s is not allowed", ip)}
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+func checkHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if isBlockedIP(ip) {
+			return &ValidationError{Code: CodeTargetBlocked, Message: fmt.Sprintf("address %s is not allowed", ip)}
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return &ValidationError{Code: CodeInvalidCallbackURL, Message: fmt.Sprintf("cannot resolve host %q: %v", host, err)}
+	}
+	for _, addr := range addrs {
+		if isBlockedIP(addr.IP) {
+			return &ValidationError{Code: CodeTargetBlocked, Message: fmt.Sprintf("host %q resolves to blocked address %s", host, addr.IP)}
+		}
+	}
+	return nil
+}
+
+// blockedNets are the ranges the net.IP predicates in isBlockedIP do not
+// cover: "this network" and the shared address space of carrier-grade NAT.
+var blockedNets = []net.IPNet{
+	{IP: net.IPv4(0, 0, 0, 0), Mask: net.CIDRMask(8, 32)},
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+}
+
+// nat64Nets are the NAT64 prefixes, which embed an IPv4 address in their
+// last 4 bytes (RFC 6052 and RFC 8215).
+var nat64Nets = []net.IPNet{
+	{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)},
+	{IP: net.ParseIP("64:ff9b:1::"), Mask: net.CIDRMask(48, 128)},
+}
+
+// isBlockedIP reports whether ip must not be a callback target. IPv4-mapped
+// and NAT64 addresses are checked as the IPv4 address they reach.
+func isBlockedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else if len(ip) == net.IPv6len {
+		for _, n := range nat64Nets {
+			if n.Contains(ip) {
+				return isBlockedIP(ip[12:])
+			}
+		}
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast()
+}
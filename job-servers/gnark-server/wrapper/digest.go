@@ -0,0 +1,157 @@
+// Package wrapper reads the circuit digest that the on-chain wrapper
+// contract pins as a constant, so that the server can warn when its keys were
+// upgraded but the contract was not redeployed.
+package wrapper
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// DefaultGetter is the signature of the public getter of the pinned digest.
+const DefaultGetter = "circuitDigest()"
+
+const rpcTimeout = 10 * time.Second
+
+// Source reads the pinned digest.
+type Source interface {
+	Digest(ctx context.Context) (*big.Int, error)
+	// String describes where the digest is read from.
+	String() string
+}
+
+// digestConstant matches a uint256 or bytes32 constant whose name contains
+// "digest", in any case, in the generated wrapper template.
+var digestConstant = regexp.MustCompile(`(?i)\b(?:uint256|bytes32)\s+(?:(?:public|internal|private)\s+)?constant\s+(?:(?:public|internal|private)\s+)?(\w*digest\w*)\s*=\s*(?:bytes32\s*\(\s*)?(0x[0-9a-f]+|[0-9]+)\s*\)?\s*;`)
+
+// DigestFromSource extracts the pinned digest from the wrapper's Solidity
+// source. It fails if no digest constant is found or if constants disagree.
+func DigestFromSource(src []byte) (*big.Int, error) {
+	matches := digestConstant.FindAllSubmatch(src, -1)
+	if len(matches) == 0 {
+		return nil, errors.New("no digest constant found in wrapper source")
+	}
+	var digest *big.Int
+	for _, m := range matches {
+		value, ok := parseNumber(string(m[2]))
+		if !ok {
+			return nil, fmt.Errorf("invalid value of constant %s", m[1])
+		}
+		if digest != nil && digest.Cmp(value) != 0 {
+			return nil, fmt.Errorf("wrapper source pins different digests: %s and %s", digest, value)
+		}
+		digest = value
+	}
+	return digest, nil
+}
+
+func parseNumber(s string) (*big.Int, bool) {
+	if hexDigits, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok {
+		return new(big.Int).SetString(hexDigits, 16)
+	}
+	return new(big.Int).SetString(s, 10)
+}
+
+// SourceFile reads the digest from the wrapper's Solidity source.
+type SourceFile struct {
+	Path string
+}
+
+func (f SourceFile) Digest(ctx context.Context) (*big.Int, error) {
+	src, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	return DigestFromSource(src)
+}
+
+func (f SourceFile) String() string {
+	return "wrapper source " + f.Path
+}
+
+// Contract reads the digest from the deployed wrapper by calling its public
+// getter with eth_call.
+type Contract struct {
+	RPCURL  string
+	Address string
+	// Getter is the signature of the getter, DefaultGetter if empty.
+	Getter string
+	Client *http.Client
+}
+
+func (c Contract) getter() string {
+	if c.Getter == "" {
+		return DefaultGetter
+	}
+	return c.Getter
+}
+
+func (c Contract) Digest(ctx context.Context) (*big.Int, error) {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(c.getter()))
+	selector := h.Sum(nil)[:4]
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "eth_call",
+		"params": []interface{}{
+			map[string]string{"to": c.Address, "data": "0x" + hex.EncodeToString(selector)},
+			"latest",
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, rpcTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.RPCURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("RPC returned %s", resp.Status)
+	}
+	var rpcResp struct {
+		Result string `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return nil, fmt.Errorf("invalid RPC response: %w", err)
+	}
+	if rpcResp.Error != nil {
+		return nil, fmt.Errorf("eth_call %s failed: %s", c.getter(), rpcResp.Error.Message)
+	}
+	result, err := hex.DecodeString(strings.TrimPrefix(rpcResp.Result, "0x"))
+	if err != nil || len(result) != 32 {
+		return nil, fmt.Errorf("eth_call %s returned %q, expected 32 bytes", c.getter(), rpcResp.Result)
+	}
+	return new(big.Int).SetBytes(result), nil
+}
+
+func (c Contract) String() string {
+	return fmt.Sprintf("wrapper contract %s (%s)", c.Address, c.getter())
+}
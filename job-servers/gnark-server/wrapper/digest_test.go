@@ -0,0 +1,149 @@
+package wrapper
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const testDigest = "1234567890123456789012345678901234567890"
+
+func TestDigestFromSource(t *testing.T) {
+	digest, _ := new(big.Int).SetString(testDigest, 10)
+	digestHex := fmt.Sprintf("0x%064x", digest)
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"decimal", "uint256 constant CIRCUIT_DIGEST = " + testDigest + ";", ""},
+		{"public hex", "uint256 public constant circuitDigest = " + digestHex + ";", ""},
+		{"bytes32", "bytes32 internal constant VERIFIER_DIGEST = bytes32(" + digestHex + ");", ""},
+		{"repeated", "uint256 constant CIRCUIT_DIGEST = " + testDigest + ";\nuint256 constant OTHER_DIGEST = " + digestHex + ";", ""},
+		{"none", "uint256 constant CIRCUIT_SIZE = 4;", "no digest constant"},
+		{"conflicting", "uint256 constant CIRCUIT_DIGEST = " + testDigest + ";\nuint256 constant OTHER_DIGEST = 1;", "different digests"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "pragma solidity ^0.8.0;\ncontract Wrapper {\n" + tt.src + "\n}\n"
+			got, err := DigestFromSource([]byte(src))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("DigestFromSource() = %v, %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Cmp(digest) != 0 {
+				t.Fatalf("DigestFromSource() = %s, want %s", got, digest)
+			}
+		})
+	}
+}
+
+func TestSourceFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Wrapper.sol")
+	if err := os.WriteFile(path, []byte("uint256 constant CIRCUIT_DIGEST = "+testDigest+";"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := SourceFile{Path: path}.Digest(context.Background())
+	if err != nil || got.String() != testDigest {
+		t.Fatalf("Digest() = %v, %v, want %s", got, err, testDigest)
+	}
+	if _, err := (SourceFile{Path: path + ".missing"}).Digest(context.Background()); !os.IsNotExist(err) {
+		t.Fatalf("Digest() of a missing file = %v, want a not-exist error", err)
+	}
+}
+
+// rpcServer answers eth_call requests with result, or with an RPC error if
+// rpcErr is set, after checking that the call reads getter from address.
+func rpcServer(t *testing.T, address, getter, result, rpcErr string) *httptest.Server {
+	t.Helper()
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(getter))
+	wantData := "0x" + hex.EncodeToString(h.Sum(nil)[:4])
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "eth_call" || len(req.Params) != 2 {
+			t.Errorf("unexpected RPC request %+v: %v", req, err)
+		} else {
+			var call map[string]string
+			json.Unmarshal(req.Params[0], &call)
+			if call["to"] != address || call["data"] != wantData {
+				t.Errorf("eth_call %v, want to %s with data %s", call, address, wantData)
+			}
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": 1}
+		if rpcErr != "" {
+			resp["error"] = map[string]interface{}{"code": 3, "message": rpcErr}
+		} else {
+			resp["result"] = result
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestContractDigest(t *testing.T) {
+	const address = "0x00000000000000000000000000000000000000aa"
+	digest, _ := new(big.Int).SetString(testDigest, 10)
+	word := fmt.Sprintf("0x%064x", digest)
+	tests := []struct {
+		name    string
+		getter  string
+		result  string
+		rpcErr  string
+		wantErr string
+	}{
+		{"default getter", "", word, "", ""},
+		{"custom getter", "CIRCUIT_DIGEST()", word, "", ""},
+		{"reverted", "", "", "execution reverted", "execution reverted"},
+		{"short result", "", "0x", "", "expected 32 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getter := tt.getter
+			if getter == "" {
+				getter = DefaultGetter
+			}
+			server := rpcServer(t, address, getter, tt.result, tt.rpcErr)
+			got, err := Contract{RPCURL: server.URL, Address: address, Getter: tt.getter}.Digest(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Digest() = %v, %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Cmp(digest) != 0 {
+				t.Fatalf("Digest() = %s, want %s", got, digest)
+			}
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		if _, err := (Contract{RPCURL: server.URL, Address: address}).Digest(context.Background()); err == nil {
+			t.Fatal("Digest() succeeded without an RPC endpoint")
+		}
+	})
+}